@@ -0,0 +1,134 @@
+// Karoo (Go) - fake Stratum pool for testing the proxy
+// Author: Carlos Rabelo <contato@carlosrabelo.com.br>
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/sim"
+)
+
+var (
+	version   = "dev"
+	buildTime = "unknown"
+)
+
+func main() {
+	cfgFile := flag.String("config", "", "JSON file with the pool script; flags override it")
+	showVersion := flag.Bool("version", false, "Show version information")
+	statsEvery := flag.Duration("stats", time.Minute, "Log the pool counters this often; 0 disables")
+
+	var cfg sim.Config
+	var diffs string
+	flag.StringVar(&cfg.Listen, "listen", "", "Address to accept miners on (default 127.0.0.1:3333)")
+	flag.IntVar(&cfg.Extranonce2Size, "extranonce2-size", 0, "extranonce2 size announced on subscribe (default 4)")
+	flag.IntVar(&cfg.NotifyIntervalMs, "notify-ms", 0, "Interval between new jobs (default 30000)")
+	flag.IntVar(&cfg.CleanEvery, "clean-every", 0, "Mark every Nth job clean_jobs, as for a new block")
+	flag.StringVar(&diffs, "difficulties", "", "Comma-separated difficulties sent in turn (default 1)")
+	flag.IntVar(&cfg.DifficultyIntervalMs, "difficulty-ms", 0, "Interval between difficulty changes")
+	flag.Float64Var(&cfg.RejectRate, "reject-rate", 0, "Fraction of valid submits to reject (0-1)")
+	flag.StringVar(&cfg.RejectMessage, "reject-message", "", "Error message of rejected submits")
+	flag.IntVar(&cfg.DisconnectAfterMs, "disconnect-ms", 0, "Drop each connection this long after it opened")
+	flag.IntVar(&cfg.StallAfterMs, "stall-ms", 0, "Stop sending jobs to a connection this long after it opened")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("karoo-sim %s (built %s)\n", version, buildTime)
+		os.Exit(0)
+	}
+
+	if *cfgFile != "" {
+		data, err := os.ReadFile(*cfgFile)
+		if err != nil {
+			log.Fatalf("Failed to read config: %v", err)
+		}
+		var file sim.Config
+		if err := json.Unmarshal(data, &file); err != nil {
+			log.Fatalf("Failed to parse config: %v", err)
+		}
+		// flags given on the command line win over the file
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		merge(&file, &cfg, set)
+		cfg = file
+	}
+	if diffs != "" {
+		cfg.Difficulties = nil
+		for _, s := range strings.Split(diffs, ",") {
+			d, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				log.Fatalf("Invalid difficulty %q", s)
+			}
+			cfg.Difficulties = append(cfg.Difficulties, d)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	pool := sim.New(&cfg)
+	if *statsEvery > 0 {
+		go func() {
+			t := time.NewTicker(*statsEvery)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					st := pool.GetStats()
+					log.Printf("sim: active=%d connections=%d jobs=%d accepted=%d rejected=%d (stale=%d)",
+						st.Active, st.Connections, st.Jobs, st.Accepted, st.Rejected, st.Stale)
+				}
+			}
+		}()
+	}
+	if err := pool.ListenAndServe(ctx); err != nil {
+		log.Fatalf("sim: %v", err)
+	}
+}
+
+// merge copies the flags set on the command line from f into dst
+func merge(dst, f *sim.Config, set map[string]bool) {
+	if set["listen"] {
+		dst.Listen = f.Listen
+	}
+	if set["extranonce2-size"] {
+		dst.Extranonce2Size = f.Extranonce2Size
+	}
+	if set["notify-ms"] {
+		dst.NotifyIntervalMs = f.NotifyIntervalMs
+	}
+	if set["clean-every"] {
+		dst.CleanEvery = f.CleanEvery
+	}
+	if set["difficulty-ms"] {
+		dst.DifficultyIntervalMs = f.DifficultyIntervalMs
+	}
+	if set["reject-rate"] {
+		dst.RejectRate = f.RejectRate
+	}
+	if set["reject-message"] {
+		dst.RejectMessage = f.RejectMessage
+	}
+	if set["disconnect-ms"] {
+		dst.DisconnectAfterMs = f.DisconnectAfterMs
+	}
+	if set["stall-ms"] {
+		dst.StallAfterMs = f.StallAfterMs
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/carlosrabelo/karoo/core/internal/bench"
+)
+
+// runBench runs "karoo bench": simulated miners against a proxy, with the
+// results printed on exit
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var cfg bench.Config
+	fs.StringVar(&cfg.Target, "target", "127.0.0.1:3333", "Proxy address (host:port)")
+	fs.BoolVar(&cfg.TLS, "tls", false, "Connect with TLS")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure", false, "With -tls, accept any certificate")
+	fs.IntVar(&cfg.Miners, "miners", 100, "Simulated miners")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "Run time after the ramp-up (default 1m)")
+	fs.DurationVar(&cfg.RampUp, "ramp", 0, "Spread miner connects over this long")
+	fs.DurationVar(&cfg.SubmitInterval, "submit-interval", 0, "Average time between shares of one miner (default 10s)")
+	fs.StringVar(&cfg.Worker, "worker", "bench", "Worker name prefix; miners authorize as <worker>.N")
+	fs.StringVar(&cfg.Password, "password", "x", "Worker password")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "Connect and response timeout (default 10s)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	fmt.Fprintf(os.Stderr, "bench: %d miners against %s\n", cfg.Miners, cfg.Target)
+	rep := bench.Run(ctx, &cfg)
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printBench(os.Stdout, rep)
+	}
+	if rep.ConnectErrors > 0 || rep.Disconnects > 0 || rep.Timeouts > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printBench writes a human readable report
+func printBench(w io.Writer, rep bench.Report) {
+	fmt.Fprintf(w, "miners       %d (connected %d, connect errors %d, dropped %d)\n",
+		rep.Miners, rep.Connected, rep.ConnectErrors, rep.Disconnects)
+	fmt.Fprintf(w, "handshakes   subscribed %d, authorized %d\n", rep.Subscribed, rep.Authorized)
+	fmt.Fprintf(w, "shares       submitted %d, accepted %d, rejected %d, unanswered %d\n",
+		rep.Submits, rep.Accepted, rep.Rejected, rep.Timeouts)
+	fmt.Fprintf(w, "jobs         %d received in %.1fs\n", rep.Jobs, float64(rep.ElapsedMs)/1000)
+
+	methods := make([]string, 0, len(rep.Latency))
+	for m := range rep.Latency {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	fmt.Fprintf(w, "\n%-20s %8s %9s %9s %9s %9s\n", "latency (ms)", "count", "p50", "p90", "p99", "max")
+	for _, m := range methods {
+		l := rep.Latency[m]
+		fmt.Fprintf(w, "%-20s %8d %9.2f %9.2f %9.2f %9.2f\n", m, l.Count, l.P50Ms, l.P90Ms, l.P99Ms, l.MaxMs)
+	}
+
+	if len(rep.Errors) > 0 {
+		msgs := make([]string, 0, len(rep.Errors))
+		for m := range rep.Errors {
+			msgs = append(msgs, m)
+		}
+		sort.Strings(msgs)
+		fmt.Fprintf(w, "\nerrors\n")
+		for _, m := range msgs {
+			fmt.Fprintf(w, "  %-40s %d\n", m, rep.Errors[m])
+		}
+	}
+}
@@ -9,13 +9,29 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	_ "net/http/pprof"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/carlosrabelo/karoo/core/internal/configfile"
+	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/journal"
+	"github.com/carlosrabelo/karoo/core/internal/logfile"
+	"github.com/carlosrabelo/karoo/core/internal/nonce"
 	"github.com/carlosrabelo/karoo/core/internal/proxy"
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/sdnotify"
+	"github.com/carlosrabelo/karoo/core/internal/selection"
+	"github.com/carlosrabelo/karoo/core/internal/solo"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/vardiff"
+	"github.com/carlosrabelo/karoo/core/internal/workers"
 )
 
 var (
@@ -23,9 +39,33 @@ var (
 	buildTime = "unknown"
 )
 
+// configFields are the config keys that KAROO_* variables and -<key> flags
+// can override
+var configFields = configfile.Fields(proxy.Config{})
+
+// flagOverrides holds the config keys set on the command line; they are
+// re-applied on every reload
+var flagOverrides = map[string]string{}
+
 func main() {
-	cfgFile := flag.String("config", "config.json", "Path to configuration file")
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+
+	cfgFile := flag.String("config", "config.json", "Path to configuration file (.json, .yaml, .yml or .toml); empty uses only overrides")
 	showVersion := flag.Bool("version", false, "Show version information")
+	allocAudit := flag.Bool("alloc-audit", false, "Track allocations per processed message (overrides diagnostics.alloc_audit)")
+	exportWorkers := flag.String("export-workers", "", "Export the worker registry to a .json or .csv file and exit")
+	importWorkers := flag.String("import-workers", "", "Import a .json or .csv file into the worker registry and exit")
+	replaceWorkers := flag.Bool("replace-workers", false, "With -import-workers, replace the registry instead of merging")
+	checkConfig := flag.Bool("check-config", false, "Validate the config, print the effective config and exit")
+	for _, f := range configFields {
+		path := f.Path
+		flag.Func(path, "override "+path+" (env "+f.Env()+")", func(v string) error {
+			flagOverrides[path] = v
+			return nil
+		})
+	}
 	flag.Parse()
 
 	if *showVersion {
@@ -35,12 +75,36 @@ func main() {
 
 	// Load configuration
 	cfg, err := loadConfig(*cfgFile)
+	if *checkConfig {
+		os.Exit(runCheckConfig(cfg, err))
+	}
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if *allocAudit {
+		cfg.Diagnostics.AllocAudit = true
+	}
+
+	if *exportWorkers != "" || *importWorkers != "" {
+		if err := workerTool(cfg, *exportWorkers, *importWorkers, *replaceWorkers); err != nil {
+			log.Fatalf("Worker registry: %v", err)
+		}
+		return
+	}
+
+	// Log to the configured file from here on
+	lw := logfile.New(&cfg.Log)
+	if err := lw.Reopen(); err != nil {
+		log.Fatalf("Failed to open log file: %v", err)
+	}
+	log.SetOutput(lw)
+	defer lw.Close()
+
+	proxy.Version, proxy.BuildTime = version, buildTime
 
 	// Create proxy instance
 	p := proxy.NewProxy(cfg)
+	p.SetConfigLoader(func() (*proxy.Config, error) { return loadConfig(*cfgFile) })
 
 	// Setup context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -48,24 +112,74 @@ func main() {
 
 	// Handle signals
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
 
 	// Start HTTP server if enabled
 	if cfg.HTTP.Listen != "" {
 		go p.HttpServe(ctx)
 	}
 
-	// Start upstream manager
-	go p.UpstreamManager(ctx, 30*time.Second)
+	// Serve pprof on its own loopback port if configured
+	if cfg.HTTP.Pprof && cfg.HTTP.PprofListen != "" {
+		go p.PprofServe(ctx)
+	}
+
+	// Answer ACME challenges for automatic TLS certificates
+	if cfg.ACME.Enabled {
+		go p.ACMELoop(ctx)
+	}
+
+	// Start the dedicated public stats listener if configured
+	if cfg.Public.Enabled && cfg.Public.Listen != "" {
+		go p.PublicServe(ctx)
+	}
+
+	// Start upstream manager (and those of SNI profiles)
+	go p.UpstreamManager(ctx)
+	go p.SelectionLoop(ctx)
+	go p.PendingLoop(ctx)
+	p.RunProfiles(ctx)
 
 	// Start VarDiff if enabled
 	if cfg.VarDiff.Enabled {
 		go p.VarDiffLoop(ctx)
 	}
 
+	// Start availability tracking if enabled
+	if cfg.Availability.Enabled {
+		go p.AvailabilityLoop(ctx)
+	}
+
+	// Start event webhook delivery
+	go p.EventsLoop(ctx)
+
+	// Start the idle worker watchdog if enabled
+	if cfg.Idle.Enabled {
+		go p.IdleLoop(ctx)
+	}
+
+	// Shed low priority clients under load (the loop follows reloads)
+	go p.ShedLoop(ctx)
+	go p.LifetimeLoop(ctx)
+	go p.RuntimeLoop(ctx)
+	go p.FeeLoop(ctx)
+	go p.ScheduleLoop(ctx)
+	go p.MirrorLoop(ctx)
+	go p.HistoryLoop(ctx)
+
+	// Start the end-to-end canary if enabled
+	if cfg.Canary.Enabled {
+		go p.CanaryLoop(ctx)
+	}
+
 	// Start report loop
 	go p.ReportLoop(ctx, 60*time.Second)
 
+	// Start additional listeners
+	if err := p.RunListeners(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// Start accept loop
 	go func() {
 		if err := p.AcceptLoop(ctx); err != nil {
@@ -74,22 +188,75 @@ func main() {
 		}
 	}()
 
+	// Tell systemd once miners can be served and keep its watchdog fed
+	go func() {
+		for !p.Ready() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+		if sent, err := sdnotify.Notify(sdnotify.Ready); err != nil {
+			log.Printf("systemd notify: %v", err)
+		} else if sent {
+			log.Printf("Notified systemd: ready")
+		}
+	}()
+	if d := sdnotify.WatchdogInterval(); d > 0 {
+		go p.WatchdogLoop(ctx, d/2, func() { _, _ = sdnotify.Notify(sdnotify.Watchdog) })
+	}
+
+	// Reload when the config file changes, through the same path as SIGHUP
+	changed := make(chan struct{}, 1)
+	if cfg.ConfigWatch.Enabled && *cfgFile != "" {
+		go configfile.Watch(ctx, *cfgFile, cfg.ConfigWatch, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+	reload := func() {
+		newCfg, err := loadConfig(*cfgFile)
+		if err != nil {
+			log.Printf("Failed to reload config: %v", err)
+			return
+		}
+		if *allocAudit {
+			newCfg.Diagnostics.AllocAudit = true
+		}
+		lw.UpdateConfig(&newCfg.Log)
+		p.Reload(newCfg)
+	}
+
 	// Wait for signal
 	for {
-		sig := <-sigCh
+		var sig os.Signal
+		select {
+		case sig = <-sigCh:
+		case <-changed:
+			log.Printf("Config file %s changed, reloading config...", *cfgFile)
+			reload()
+			continue
+		}
 		if sig == syscall.SIGHUP {
 			log.Printf("Received SIGHUP, reloading config...")
-			newCfg, err := loadConfig(*cfgFile)
-			if err != nil {
-				log.Printf("Failed to reload config: %v", err)
-				continue
+			reload()
+			continue
+		}
+		if sig == syscall.SIGUSR1 {
+			if err := lw.Reopen(); err != nil {
+				log.Printf("Failed to reopen log file: %v", err)
+			} else {
+				log.Printf("Received SIGUSR1, reopened log file")
 			}
-			p.Reload(newCfg)
 			continue
 		}
 
 		// SIGINT/SIGTERM
 		log.Printf("Shutting down...")
+		_, _ = sdnotify.Notify(sdnotify.Stopping)
 		cancel()
 		time.Sleep(2 * time.Second)
 		log.Printf("Shutdown complete")
@@ -97,10 +264,102 @@ func main() {
 	}
 }
 
+// workerTool imports into and/or exports the worker registry file offline
+func workerTool(cfg *proxy.Config, exportPath, importPath string, replace bool) error {
+	if cfg.Workers.File == "" {
+		return fmt.Errorf("workers.file is not configured")
+	}
+	reg := workers.NewRegistry(&cfg.Workers)
+	if err := reg.Load(); err != nil {
+		return err
+	}
+	if importPath != "" {
+		f, err := os.Open(importPath)
+		if err != nil {
+			return err
+		}
+		list, err := workers.Parse(f, workers.FormatFor(importPath))
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", importPath, err)
+		}
+		added, updated, err := reg.Import(list, replace)
+		if err != nil {
+			return err
+		}
+		if err := reg.Save(); err != nil {
+			return err
+		}
+		log.Printf("imported %s: added=%d updated=%d total=%d", importPath, added, updated, len(reg.List()))
+	}
+	if exportPath != "" {
+		f, err := os.Create(exportPath)
+		if err != nil {
+			return err
+		}
+		if err := reg.Export(f, workers.FormatFor(exportPath)); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("exported %d workers to %s", len(reg.List()), exportPath)
+	}
+	return nil
+}
+
+// runCheckConfig prints the result of a dry-run validation and returns the
+// exit status: issues go to stderr, the effective config to stdout
+func runCheckConfig(cfg *proxy.Config, loadErr error) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res := proxy.RunConfigCheck(ctx, cfg, loadErr)
+	if res.Error != "" {
+		fmt.Fprintf(os.Stderr, "error: %s\n", res.Error)
+		return 1
+	}
+	for _, is := range res.Issues {
+		level := "error"
+		if is.Warning {
+			level = "warning"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", level, is.Field, is.Message)
+	}
+	out, _ := json.MarshalIndent(res.Config, "", "  ")
+	fmt.Println(string(out))
+	if !res.Valid {
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "config OK")
+	return 0
+}
+
+// loadConfig reads the config file, if any, and applies overrides in order
+// of precedence: KAROO_* environment variables over the file, and flags
+// over both. Defaults fill whatever is still unset.
 func loadConfig(path string) (*proxy.Config, error) {
-	data, err := os.ReadFile(path)
+	var data []byte
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if data, err = configfile.ToJSON(raw, configfile.FormatFor(path)); err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
+	}
+
+	overrides := configfile.EnvOverrides(configFields, os.LookupEnv)
+	for k, v := range flagOverrides {
+		overrides[k] = v
+	}
+	data, err := configfile.Apply(data, configFields, overrides)
 	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+		return nil, fmt.Errorf("applying overrides: %w", err)
+	}
+	if len(data) == 0 {
+		data = []byte("{}")
 	}
 
 	var cfg proxy.Config
@@ -121,6 +380,15 @@ func loadConfig(path string) (*proxy.Config, error) {
 	if cfg.Proxy.WriteBuf == 0 {
 		cfg.Proxy.WriteBuf = 4096
 	}
+	if cfg.Proxy.MaxLineBytes < 0 || cfg.Proxy.MaxInvalidLines < 0 {
+		return nil, fmt.Errorf("proxy: max_line_bytes and max_invalid_lines must not be negative")
+	}
+	if cfg.Proxy.InvalidBanSeconds < 0 {
+		return nil, fmt.Errorf("proxy: invalid_ban_seconds must not be negative")
+	}
+	if cfg.Proxy.IdleGraceMs < 0 {
+		return nil, fmt.Errorf("proxy: idle_grace_ms must not be negative")
+	}
 	// Helper to set defaults and validate upstream config
 	validateUpstream := func(u *proxy.UpstreamConfig) error {
 		if u.Port == 0 {
@@ -143,6 +411,21 @@ func loadConfig(path string) (*proxy.Config, error) {
 			return fmt.Errorf("backoff_max_ms (%d) must be >= backoff_min_ms (%d)",
 				u.BackoffMaxMs, u.BackoffMinMs)
 		}
+		if _, err := stratum.AlgorithmByName(u.Algorithm); err != nil {
+			return err
+		}
+		if u.DifficultyScale < 0 {
+			return fmt.Errorf("difficulty_scale must not be negative")
+		}
+		if u.AuthBackoffMs < 0 {
+			return fmt.Errorf("auth_backoff_ms must not be negative")
+		}
+		if err := u.Tunnel.Validate(); err != nil {
+			return fmt.Errorf("tunnel: %w", err)
+		}
+		if err := u.ValidateProxy(); err != nil {
+			return fmt.Errorf("socks_proxy: %w", err)
+		}
 		return nil
 	}
 
@@ -159,18 +442,463 @@ func loadConfig(path string) (*proxy.Config, error) {
 	if cfg.VarDiff.AdjustEveryMs == 0 {
 		cfg.VarDiff.AdjustEveryMs = 60000
 	}
+	if cfg.VarDiff.MinDiff < 0 || cfg.VarDiff.MaxDiff < cfg.VarDiff.MinDiff {
+		return nil, fmt.Errorf("vardiff: min_diff (%d) must be positive and not above max_diff (%d)",
+			cfg.VarDiff.MinDiff, cfg.VarDiff.MaxDiff)
+	}
+	if cfg.VarDiff.TargetSeconds < 0 || cfg.VarDiff.AdjustEveryMs < 0 {
+		return nil, fmt.Errorf("vardiff: target_seconds and adjust_every_ms must be positive")
+	}
+	if cfg.VarDiff.StartDiff < 0 || cfg.VarDiff.FastRetargetShares < 0 || cfg.VarDiff.FastRetargetSeconds < 0 {
+		return nil, fmt.Errorf("vardiff: start_diff, fast_retarget_shares and fast_retarget_seconds must not be negative")
+	}
+	if !vardiff.ValidSteps(cfg.VarDiff.Steps, cfg.VarDiff.StepList) {
+		return nil, fmt.Errorf("vardiff.steps must be empty, pow2 or list with a step_list of positive difficulties")
+	}
+	if cfg.VarDiff.HysteresisPct < 0 || cfg.VarDiff.HysteresisPct >= 100 {
+		return nil, fmt.Errorf("vardiff.hysteresis_pct must be between 0 and 100")
+	}
+	switch cfg.VarDiff.PasswordDifficulty {
+	case "", "off", vardiff.BoundFloor, vardiff.BoundCeiling, vardiff.BoundFixed:
+	default:
+		return nil, fmt.Errorf("vardiff.password_difficulty must be off, floor, ceiling or fixed")
+	}
+
+	if cfg.Extranonce.PrefixBytes == 0 {
+		cfg.Extranonce.PrefixBytes = nonce.DefaultPrefixBytes
+	}
+	if cfg.Extranonce.PrefixBytes < 0 || cfg.Extranonce.PrefixBytes > nonce.MaxPrefixBytes {
+		return nil, fmt.Errorf("extranonce.prefix_bytes must be between 1 and %d", nonce.MaxPrefixBytes)
+	}
+	if cfg.Extranonce.ChainPrefixBytes < 0 || cfg.Extranonce.ChainPrefixBytes > cfg.Extranonce.PrefixBytes {
+		return nil, fmt.Errorf("extranonce.chain_prefix_bytes must be between 0 and prefix_bytes")
+	}
+
+	if cfg.Availability.SampleIntervalMs == 0 {
+		cfg.Availability.SampleIntervalMs = 10000
+	}
+	if cfg.Availability.RetentionDays == 0 {
+		cfg.Availability.RetentionDays = 400
+	}
+
+	if cfg.Journal.Format == "" {
+		cfg.Journal.Format = journal.FormatNDJSON
+	}
+	if cfg.Journal.Format != journal.FormatNDJSON && cfg.Journal.Format != journal.FormatCSV {
+		return nil, fmt.Errorf("journal.format must be %q or %q", journal.FormatNDJSON, journal.FormatCSV)
+	}
+	if cfg.Journal.Enabled && cfg.Journal.Path == "" {
+		return nil, fmt.Errorf("journal.path is required when the journal is enabled")
+	}
+	if cfg.Journal.MaxSizeMB == 0 {
+		cfg.Journal.MaxSizeMB = 100
+	}
+	if cfg.Journal.MaxFiles == 0 {
+		cfg.Journal.MaxFiles = 5
+	}
+
+	if cfg.Canary.Worker == "" {
+		cfg.Canary.Worker = "karoo.canary"
+	}
+	if cfg.Canary.HashesPerSec == 0 {
+		cfg.Canary.HashesPerSec = 20000
+	}
+	if cfg.Canary.SubmitIntervalMs == 0 {
+		cfg.Canary.SubmitIntervalMs = 60000
+	}
+	if cfg.Canary.FailThreshold == 0 {
+		cfg.Canary.FailThreshold = 3
+	}
+	if cfg.Canary.ResponseTimeoutMs == 0 {
+		cfg.Canary.ResponseTimeoutMs = 30000
+	}
+
+	if cfg.Identity.UserAgent == "" {
+		cfg.Identity.UserAgent = "karoo/" + version
+	}
+
+	if cfg.Submit.MaxInFlight < 0 || cfg.Submit.QueueSize < 0 {
+		return nil, fmt.Errorf("submit: max_inflight and queue_size must not be negative")
+	}
+	if cfg.Submit.MaxInFlight > 0 && cfg.Submit.QueueSize == 0 {
+		cfg.Submit.QueueSize = 256
+	}
+	switch cfg.Submit.StalePolicy {
+	case "", routing.StaleForward, routing.StaleDrop:
+	default:
+		return nil, fmt.Errorf("submit: stale_policy must be forward or drop")
+	}
+	if cfg.Submit.StaleGraceMs < 0 || cfg.Submit.NTimeRollSeconds < 0 {
+		return nil, fmt.Errorf("submit: stale_grace_ms and ntime_roll_seconds must not be negative")
+	}
+	if cfg.Submit.HoldMs < 0 || cfg.Submit.HoldPerClient < 0 {
+		return nil, fmt.Errorf("submit: hold_ms and hold_per_client must not be negative")
+	}
+
+	// Solo mining replaces the upstream pool with a local node, so upstream
+	// settings are only validated without it
+	if cfg.Solo.Enabled {
+		if cfg.Solo.RPCURL == "" {
+			return nil, fmt.Errorf("solo: rpc_url is required")
+		}
+		if cfg.Solo.PayoutAddress == "" {
+			return nil, fmt.Errorf("solo: payout_address is required")
+		}
+		if cfg.Solo.PollIntervalMs == 0 {
+			cfg.Solo.PollIntervalMs = 1000
+		}
+		if cfg.Solo.ShareDifficulty == 0 {
+			cfg.Solo.ShareDifficulty = float64(cfg.VarDiff.MinDiff)
+		}
+		if err := solo.ValidateCoinbaseTag(cfg.Solo.CoinbaseTag); err != nil {
+			return nil, fmt.Errorf("solo: %w", err)
+		}
+	} else {
+		// Validate primary upstream
+		if err := validateUpstream(&cfg.Upstream); err != nil {
+			return nil, fmt.Errorf("upstream: %w", err)
+		}
+
+		// Validate backups
+		for i := range cfg.Backups {
+			if err := validateUpstream(&cfg.Backups[i]); err != nil {
+				return nil, fmt.Errorf("backup[%d]: %w", i, err)
+			}
+		}
+	}
+
+	// Aggregated submission validates shares locally
+	if cfg.Aggregate.Enabled {
+		if cfg.Solo.Enabled {
+			return nil, fmt.Errorf("aggregate and solo modes are mutually exclusive")
+		}
+		if !cfg.VarDiff.Enabled && cfg.Aggregate.ShareDifficulty <= 0 {
+			return nil, fmt.Errorf("aggregate.share_difficulty must be positive when vardiff is disabled")
+		}
+	}
+
+	// Duplicate worker policy
+	switch cfg.Duplicates.Policy {
+	case "":
+		cfg.Duplicates.Policy = proxy.DuplicateWarn
+	case proxy.DuplicateAllow, proxy.DuplicateWarn, proxy.DuplicateRename, proxy.DuplicateReject:
+	default:
+		return nil, fmt.Errorf("duplicates.policy must be allow, warn, rename or reject")
+	}
+
+	// Validate rate limit subnet granularity
+	if cfg.RateLimit.IPv4Prefix < 0 || cfg.RateLimit.IPv4Prefix > 32 || cfg.RateLimit.IPv6Prefix < 0 || cfg.RateLimit.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("ratelimit: ipv4_prefix must be 0-32 and ipv6_prefix 0-128")
+	}
+
+	// Validate explicit bans
+	for i, b := range cfg.RateLimit.Bans {
+		if err := ratelimit.ValidateBan(b); err != nil {
+			return nil, fmt.Errorf("ratelimit.bans[%d]: %w", i, err)
+		}
+	}
+
+	// Validate SNI upstream profiles and routes
+	for name, pc := range cfg.Profiles {
+		if err := validateUpstream(&pc.Upstream); err != nil {
+			return nil, fmt.Errorf("profiles.%s.upstream: %w", name, err)
+		}
+		for i := range pc.Backups {
+			if err := validateUpstream(&pc.Backups[i]); err != nil {
+				return nil, fmt.Errorf("profiles.%s.backups[%d]: %w", name, i, err)
+			}
+		}
+		cfg.Profiles[name] = pc
+	}
+	if len(cfg.SNIRoutes) > 0 && !cfg.Proxy.TLS.Enabled {
+		return nil, fmt.Errorf("sni_routes require proxy.tls.enabled")
+	}
+	for i, r := range cfg.SNIRoutes {
+		if r.ServerName == "" {
+			return nil, fmt.Errorf("sni_routes[%d]: server_name is required", i)
+		}
+		if (r.CertFile == "") != (r.KeyFile == "") {
+			return nil, fmt.Errorf("sni_routes[%d]: cert_file and key_file must be set together", i)
+		}
+		if _, ok := cfg.Profiles[r.Profile]; r.Profile != "" && !ok {
+			return nil, fmt.Errorf("sni_routes[%d]: unknown profile %q", i, r.Profile)
+		}
+	}
+
+	// Validate idle worker alerting
+	if cfg.Idle.AfterMinutes < 0 || cfg.Idle.CheckIntervalSeconds < 0 {
+		return nil, fmt.Errorf("idle: after_minutes and check_interval_seconds must not be negative")
+	}
+	if u := cfg.Idle.WebhookURL; u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return nil, fmt.Errorf("idle.webhook_url must be an http(s) URL")
+	}
+
+	// Validate event webhooks
+	for i, wh := range cfg.Events.Webhooks {
+		if !strings.HasPrefix(wh.URL, "http://") && !strings.HasPrefix(wh.URL, "https://") {
+			return nil, fmt.Errorf("events.webhooks[%d]: url must be an http(s) URL", i)
+		}
+		for _, e := range wh.Events {
+			if !slices.Contains(events.Types, e) {
+				return nil, fmt.Errorf("events.webhooks[%d]: unknown event %q", i, e)
+			}
+		}
+	}
+	if cfg.Events.MaxRetries < 0 || cfg.Events.TimeoutMs < 0 {
+		return nil, fmt.Errorf("events: max_retries and timeout_ms must not be negative")
+	}
+	if cfg.Events.RejectRatePct < 0 || cfg.Events.RejectRatePct > 100 {
+		return nil, fmt.Errorf("events.reject_rate_pct must be between 0 and 100")
+	}
+	if cfg.Events.RejectRatePct > 0 && cfg.Events.RejectWindow <= 0 {
+		cfg.Events.RejectWindow = 100
+	}
+
+	// Validate additional listeners
+	names := make(map[string]bool, len(cfg.Listeners))
+	for i := range cfg.Listeners {
+		l := &cfg.Listeners[i]
+		if l.Listen == "" {
+			return nil, fmt.Errorf("listeners[%d]: listen is required", i)
+		}
+		if l.Listen == cfg.Proxy.Listen {
+			return nil, fmt.Errorf("listeners[%d]: %s is already proxy.listen", i, l.Listen)
+		}
+		if l.Name == "" {
+			l.Name = l.Listen
+		}
+		if names[l.Name] {
+			return nil, fmt.Errorf("listeners[%d]: duplicate name %q", i, l.Name)
+		}
+		names[l.Name] = true
+		if l.TLS.Enabled && (l.TLS.Cert == "") != (l.TLS.Key == "") {
+			return nil, fmt.Errorf("listeners[%d]: tls cert_file and key_file must be set together", i)
+		}
+		if l.TLS.Enabled && l.TLS.Cert == "" && !cfg.ACME.Enabled {
+			return nil, fmt.Errorf("listeners[%d]: tls requires cert_file and key_file, or acme.enabled", i)
+		}
+		if _, ok := cfg.Profiles[l.Profile]; l.Profile != "" && !ok {
+			return nil, fmt.Errorf("listeners[%d]: unknown profile %q", i, l.Profile)
+		}
+		if l.StartDifficulty < 0 || l.MaxClients < 0 {
+			return nil, fmt.Errorf("listeners[%d]: start_difficulty and max_clients must not be negative", i)
+		}
+		if err := l.Tunnel.Validate(); err != nil {
+			return nil, fmt.Errorf("listeners[%d]: tunnel: %w", i, err)
+		}
+	}
+
+	// Validate upstream selection
+	if cfg.Selection.Policy == "" {
+		cfg.Selection.Policy = selection.Priority
+	}
+	if !slices.Contains(selection.Policies, cfg.Selection.Policy) {
+		return nil, fmt.Errorf("selection.policy must be one of %s", strings.Join(selection.Policies, ", "))
+	}
+	if cfg.Selection.ProbeIntervalSeconds < 0 || cfg.Selection.ProbeTimeoutMs < 0 || cfg.Selection.SwitchMarginPct < 0 {
+		return nil, fmt.Errorf("selection: probe_interval_seconds, probe_timeout_ms and switch_margin_pct must not be negative")
+	}
+
+	// Validate client write queues
+	if cfg.ClientQueue.Size < 0 || cfg.ClientQueue.WriteTimeoutMs < 0 {
+		return nil, fmt.Errorf("client_queue: size and write_timeout_ms must not be negative")
+	}
+	w := cfg.UpstreamWriter
+	if w.QueueSize < 0 || w.MaxBatch < 0 || w.EnqueueTimeoutMs < 0 {
+		return nil, fmt.Errorf("upstream_writer: queue_size, max_batch and enqueue_timeout_ms must not be negative")
+	}
+
+	// Validate client compat profiles
+	for name, prof := range cfg.ClientCompat.Profiles {
+		if err := prof.Validate(); err != nil {
+			return nil, fmt.Errorf("client_compat.profiles.%s: %w", name, err)
+		}
+	}
+	if _, ok := cfg.ClientCompat.Lookup(cfg.ClientCompat.Default); !ok {
+		return nil, fmt.Errorf("client_compat.default: unknown profile %q", cfg.ClientCompat.Default)
+	}
+	for i, l := range cfg.Listeners {
+		if _, ok := cfg.ClientCompat.Lookup(l.Compat); !ok {
+			return nil, fmt.Errorf("listeners[%d]: unknown compat profile %q", i, l.Compat)
+		}
+	}
+
+	// Validate pending request timeout
+	if cfg.Pending.TimeoutMs < 0 || cfg.Pending.ReapIntervalMs < 0 {
+		return nil, fmt.Errorf("pending: timeout_ms and reap_interval_ms must not be negative")
+	}
 
-	// Validate primary upstream
-	if err := validateUpstream(&cfg.Upstream); err != nil {
-		return nil, fmt.Errorf("upstream: %w", err)
+	// Validate upstream TLS options
+	for i, uc := range append([]proxy.UpstreamConfig{cfg.Upstream}, cfg.Backups...) {
+		if err := uc.ValidateTLS(); err != nil {
+			return nil, fmt.Errorf("upstream %d (%s): %w", i, uc.Host, err)
+		}
 	}
 
-	// Validate backups
-	for i := range cfg.Backups {
-		if err := validateUpstream(&cfg.Backups[i]); err != nil {
-			return nil, fmt.Errorf("backup[%d]: %w", i, err)
+	// Validate upstream DNS
+	if r := cfg.UpstreamDNS.Resolver; r != "" {
+		if _, _, err := net.SplitHostPort(r); err != nil {
+			return nil, fmt.Errorf("upstream_dns.resolver must be host:port: %w", err)
 		}
 	}
+	if cfg.UpstreamDNS.TTLSeconds < 0 || cfg.UpstreamDNS.TimeoutMs < 0 {
+		return nil, fmt.Errorf("upstream_dns: ttl_seconds and timeout_ms must not be negative")
+	}
+
+	switch cfg.UpstreamDial.Strategy {
+	case "", connection.DialSerial, connection.DialParallel:
+	default:
+		return nil, fmt.Errorf("upstream_dial.strategy must be serial or parallel")
+	}
+	if cfg.UpstreamDial.StaggerMs < 0 {
+		return nil, fmt.Errorf("upstream_dial.stagger_ms must not be negative")
+	}
+
+	// Validate keepalive
+	if err := cfg.Keepalive.Validate(); err != nil {
+		return nil, fmt.Errorf("keepalive: %w", err)
+	}
+
+	// Validate worker pins
+	if cfg.WorkerPin.TTLSeconds < 0 || cfg.WorkerPin.IPv4Prefix < 0 || cfg.WorkerPin.IPv4Prefix > 32 ||
+		cfg.WorkerPin.IPv6Prefix < 0 || cfg.WorkerPin.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("worker_pin: ttl_seconds must not be negative, ipv4_prefix must be 0-32 and ipv6_prefix 0-128")
+	}
+
+	// Validate worker name rewriting
+	if err := cfg.WorkerNames.Validate(); err != nil {
+		return nil, fmt.Errorf("worker_names: %w", err)
+	}
+
+	// Validate authorize fast acks
+	if err := cfg.Authorize.Validate(); err != nil {
+		return nil, fmt.Errorf("authorize: %w", err)
+	}
+
+	// Validate latency histograms
+	if err := cfg.Metrics.Validate(); err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+
+	// Validate share throttle
+	if err := cfg.Throttle.Validate(); err != nil {
+		return nil, fmt.Errorf("throttle: %w", err)
+	}
+
+	// Validate connection admission
+	if err := cfg.Admission.Validate(); err != nil {
+		return nil, fmt.Errorf("admission: %w", err)
+	}
+
+	// Validate HTTP listener
+	if err := cfg.HTTP.Validate(); err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+
+	// Validate ACME certificates
+	if err := cfg.ACME.Validate(); err != nil {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+	if cfg.Proxy.TLS.Enabled && cfg.Proxy.TLS.Cert == "" && !cfg.ACME.Enabled {
+		return nil, fmt.Errorf("proxy.tls requires cert_file and key_file, or acme.enabled")
+	}
+	if cfg.ACME.Dashboard && cfg.HTTP.Listen == "" {
+		return nil, fmt.Errorf("acme.dashboard requires http.listen")
+	}
+	if cfg.ACME.Dashboard && cfg.HTTP.TLS.Enabled {
+		return nil, fmt.Errorf("acme.dashboard and http.tls are mutually exclusive")
+	}
+
+	// Validate the fee; a timeslice upstream gets the upstream defaults
+	if err := cfg.Fee.Validate(); err != nil {
+		return nil, fmt.Errorf("fee: %w", err)
+	}
+	if cfg.Fee.Enabled {
+		if cfg.Solo.Enabled {
+			return nil, fmt.Errorf("fee needs an upstream pool and is not available in solo mode")
+		}
+		if cfg.Fee.Mode == proxy.FeeModeTimeslice {
+			if err := validateUpstream(&cfg.Fee.Upstream); err != nil {
+				return nil, fmt.Errorf("fee.upstream: %w", err)
+			}
+		} else if cfg.Fee.User == cfg.Upstream.User {
+			return nil, fmt.Errorf("fee.user must differ from upstream.user")
+		}
+	}
+
+	// Validate the upstream schedule
+	if err := cfg.Schedule.Validate(); err != nil {
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+	if cfg.Schedule.Enabled && cfg.Solo.Enabled {
+		return nil, fmt.Errorf("schedule needs an upstream pool and is not available in solo mode")
+	}
+	for i, w := range cfg.Schedule.Windows {
+		if cfg.Schedule.Enabled && w.Upstream != nil {
+			if err := validateUpstream(w.Upstream); err != nil {
+				return nil, fmt.Errorf("schedule.windows[%d].upstream: %w", i, err)
+			}
+		}
+	}
+
+	// Validate the mirror upstream
+	if cfg.Mirror.Enabled {
+		if cfg.Solo.Enabled {
+			return nil, fmt.Errorf("mirror needs an upstream pool and is not available in solo mode")
+		}
+		if cfg.Mirror.TimeoutMs < 0 {
+			return nil, fmt.Errorf("mirror: timeout_ms must not be negative")
+		}
+		if err := validateUpstream(&cfg.Mirror.Upstream); err != nil {
+			return nil, fmt.Errorf("mirror.upstream: %w", err)
+		}
+	}
+
+	// Validate load shedding
+	if err := cfg.Shedding.Validate(); err != nil {
+		return nil, fmt.Errorf("shedding: %w", err)
+	}
+
+	// Validate the client lifetime policy
+	if err := cfg.Lifetime.Validate(); err != nil {
+		return nil, fmt.Errorf("lifetime: %w", err)
+	}
+	if r := cfg.Runtime; r.LeakChecks < 0 || r.IntervalSeconds < 0 || r.MinGrowth < 0 {
+		return nil, fmt.Errorf("runtime: leak_checks, interval_seconds and min_growth must not be negative")
+	}
+	if err := cfg.UpstreamAuth.Validate(); err != nil {
+		return nil, fmt.Errorf("upstream_auth: %w", err)
+	}
+
+	// Validate the config file watcher
+	if err := cfg.ConfigWatch.Validate(); err != nil {
+		return nil, fmt.Errorf("config_watch: %w", err)
+	}
+
+	// Validate the log file rotation
+	if err := cfg.Log.Validate(); err != nil {
+		return nil, fmt.Errorf("log: %w", err)
+	}
+
+	// Validate the session audit trail
+	if err := cfg.Sessions.Validate(); err != nil {
+		return nil, fmt.Errorf("sessions: %w", err)
+	}
+
+	// Validate client groups
+	if err := proxy.ValidateGroups(cfg.Groups); err != nil {
+		return nil, fmt.Errorf("groups: %w", err)
+	}
+
+	// Validate traffic capture
+	if err := cfg.Diagnostics.Capture.Validate(); err != nil {
+		return nil, fmt.Errorf("diagnostics.capture: %w", err)
+	}
+
+	// Validate the metrics history
+	if err := cfg.History.Validate(); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
 
 	return &cfg, nil
 }
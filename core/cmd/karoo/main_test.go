@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/carlosrabelo/karoo/core/internal/proxy"
@@ -162,3 +164,59 @@ func TestProxyCreation(t *testing.T) {
 	// We can't test internal fields directly since they're not exported
 	// but we can verify the proxy is not nil
 }
+
+func TestLoadConfigFormats(t *testing.T) {
+	t.Setenv("POOL_USER", "wallet.rig")
+	t.Setenv("POOL_PASS", "x")
+	files := map[string]string{
+		"config.json": `{"upstream": {"host": "pool.example.com", "port": ${POOL_PORT:-4444}, "user": "${POOL_USER}", "pass": "${POOL_PASS}"}}`,
+		"config.yaml": "upstream:\n  host: pool.example.com\n  port: ${POOL_PORT:-4444}\n  user: ${POOL_USER}\n  pass: \"${POOL_PASS}\"\n",
+		"config.toml": "[upstream]\nhost = \"pool.example.com\"\nport = ${POOL_PORT:-4444}\nuser = \"${POOL_USER}\"\npass = \"${POOL_PASS}\"\n",
+	}
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Upstream.Host != "pool.example.com" || cfg.Upstream.Port != 4444 ||
+			cfg.Upstream.User != "wallet.rig" || cfg.Upstream.Pass != "x" {
+			t.Errorf("%s: upstream = %+v", name, cfg.Upstream)
+		}
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	body := `{"upstream": {"host": "file.example.com", "port": 3333, "user": "wallet.rig"}, "vardiff": {"min_diff": 512}}`
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KAROO_UPSTREAM_HOST", "env.example.com")
+	t.Setenv("KAROO_UPSTREAM_PORT", "4444")
+	flagOverrides = map[string]string{"upstream.port": "5555", "vardiff.enabled": "true"}
+	defer func() { flagOverrides = map[string]string{} }()
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Upstream.Host != "env.example.com" || cfg.Upstream.Port != 5555 ||
+		!cfg.VarDiff.Enabled || cfg.VarDiff.MinDiff != 512 {
+		t.Errorf("precedence not applied: upstream=%+v vardiff=%+v", cfg.Upstream, cfg.VarDiff)
+	}
+
+	// Without a file the overrides are the whole config
+	t.Setenv("KAROO_UPSTREAM_USER", "wallet.env")
+	cfg, err = loadConfig("")
+	if err != nil {
+		t.Fatalf("loading without a file: %v", err)
+	}
+	if cfg.Upstream.Host != "env.example.com" || cfg.Upstream.User != "wallet.env" || cfg.VarDiff.MinDiff == 512 {
+		t.Errorf("upstream = %+v vardiff = %+v", cfg.Upstream, cfg.VarDiff)
+	}
+}
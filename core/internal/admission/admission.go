@@ -0,0 +1,294 @@
+// Package admission decides whether a new client connection is accepted
+package admission
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Reasons a connection is refused
+const (
+	ReasonNone       = ""
+	ReasonMaxClients = "max_clients" // proxy.max_clients reached
+	ReasonRate       = "rate"        // accept token bucket empty
+	ReasonPending    = "pending"     // too many clients still to subscribe
+	ReasonBacklog    = "backlog"     // handshake backlog full
+)
+
+// Config holds admission control configuration. proxy.max_clients always
+// applies; the rest only when Enabled.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// AcceptsPerSecond refills the global accept bucket; 0 is unlimited
+	AcceptsPerSecond float64 `json:"accepts_per_second"`
+	Burst            int     `json:"burst"` // bucket size; default AcceptsPerSecond, at least 1
+	// MaxPending caps clients connected without a mining.subscribe; 0 is
+	// unlimited
+	MaxPending int `json:"max_pending"`
+	// SubscribeTimeoutSeconds closes clients that do not subscribe in time;
+	// default 10, 0 keeps only proxy.client_idle_ms
+	SubscribeTimeoutSeconds int `json:"subscribe_timeout_seconds"`
+
+	// MaxHandshakes caps the clients between accept and their authorize
+	// answer; further connections wait in a backlog instead of being
+	// refused, so a reconnect storm is let in at the pace handshakes
+	// finish. 0 is unlimited
+	MaxHandshakes int `json:"max_handshakes"`
+	// Backlog caps the connections waiting for a handshake; past it they
+	// are refused. Default 1000
+	Backlog int `json:"backlog"`
+	// BacklogJitterMs delays each connection let out of the backlog by a
+	// random time up to this, spreading them out
+	BacklogJitterMs int `json:"backlog_jitter_ms"`
+	// StaggerMs delays the first difficulty and job of a client that
+	// waited in the backlog by a random time up to this, so the storm's
+	// authorizes do not turn into a burst of work
+	StaggerMs int `json:"stagger_ms"`
+}
+
+// Validate checks that the limits are not negative
+func (c *Config) Validate() error {
+	if c.AcceptsPerSecond < 0 || c.Burst < 0 || c.MaxPending < 0 || c.SubscribeTimeoutSeconds < 0 {
+		return fmt.Errorf("accepts_per_second, burst, max_pending and subscribe_timeout_seconds must not be negative")
+	}
+	if c.MaxHandshakes < 0 || c.Backlog < 0 || c.BacklogJitterMs < 0 || c.StaggerMs < 0 {
+		return fmt.Errorf("max_handshakes, backlog, backlog_jitter_ms and stagger_ms must not be negative")
+	}
+	return nil
+}
+
+// backlog returns how many connections may wait for a handshake
+func (c *Config) backlog() int {
+	if c.Backlog <= 0 {
+		return 1000
+	}
+	return c.Backlog
+}
+
+// BacklogJitter returns a random delay for a connection leaving the backlog
+func (c *Config) BacklogJitter() time.Duration {
+	return jitter(c.BacklogJitterMs)
+}
+
+// Stagger returns a random delay for the first work of a client that
+// waited in the backlog
+func (c *Config) Stagger() time.Duration {
+	return jitter(c.StaggerMs)
+}
+
+// jitter returns a random duration below ms milliseconds, 0 for none
+func jitter(ms int) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(ms) * time.Millisecond)
+}
+
+// burst returns the bucket size
+func (c *Config) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return math.Max(1, math.Ceil(c.AcceptsPerSecond))
+}
+
+// SubscribeTimeout returns how long a client may take to subscribe, or 0
+// when admission control does not enforce it
+func (c *Config) SubscribeTimeout() time.Duration {
+	if !c.Enabled {
+		return 0
+	}
+	if c.SubscribeTimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.SubscribeTimeoutSeconds) * time.Second
+}
+
+// Controller admits connections against the client cap, a token bucket and
+// the pending handshake cap
+type Controller struct {
+	mu       sync.Mutex
+	cfg      *Config
+	tokens   float64
+	refilled time.Time
+	pending  int
+	accepted uint64
+	rejected map[string]uint64
+	timedOut uint64
+
+	// handshake slots taken, and the connections waiting for one
+	handshakes int
+	waiting    []chan struct{}
+	waited     uint64
+}
+
+// New creates a new admission controller
+func New(cfg *Config) *Controller {
+	return &Controller{
+		cfg:      cfg,
+		tokens:   cfg.burst(),
+		rejected: make(map[string]uint64),
+	}
+}
+
+// UpdateConfig updates the admission configuration
+func (c *Controller) UpdateConfig(cfg *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+	c.tokens = math.Min(c.tokens, cfg.burst())
+	c.wake()
+}
+
+// refill adds the tokens earned since the last refill
+func (c *Controller) refill(now time.Time) {
+	if !c.refilled.IsZero() {
+		c.tokens += now.Sub(c.refilled).Seconds() * c.cfg.AcceptsPerSecond
+		c.tokens = math.Min(c.tokens, c.cfg.burst())
+	}
+	c.refilled = now
+}
+
+// Admit decides on a new connection given the clients already connected.
+// An admitted connection counts as pending until Release.
+func (c *Controller) Admit(active int64, maxClients int, now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reason := ReasonNone
+	switch {
+	case active >= int64(maxClients):
+		reason = ReasonMaxClients
+	case !c.cfg.Enabled:
+	case c.cfg.MaxPending > 0 && c.pending >= c.cfg.MaxPending:
+		reason = ReasonPending
+	case c.cfg.AcceptsPerSecond > 0:
+		c.refill(now)
+		if c.tokens < 1 {
+			reason = ReasonRate
+		} else {
+			c.tokens--
+		}
+	}
+	if reason != ReasonNone {
+		c.rejected[reason]++
+		return reason
+	}
+	c.accepted++
+	c.pending++
+	return reason
+}
+
+// Release ends the pending state of an admitted connection, once it
+// subscribed or disconnected
+func (c *Controller) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending > 0 {
+		c.pending--
+	}
+}
+
+// Handshake takes a handshake slot for a new connection. When all are
+// taken the connection joins the backlog: the returned channel is closed
+// once it got a slot, and is nil when it got one at once. It reports false
+// when the backlog is full.
+func (c *Controller) Handshake() (<-chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slotFree() {
+		c.handshakes++
+		return nil, true
+	}
+	if len(c.waiting) >= c.cfg.backlog() {
+		c.rejected[ReasonBacklog]++
+		return nil, false
+	}
+	ch := make(chan struct{})
+	c.waiting = append(c.waiting, ch)
+	c.waited++
+	return ch, true
+}
+
+// CancelHandshake withdraws a connection from the backlog, freeing the slot
+// it was given meanwhile, if any
+func (c *Controller) CancelHandshake(wait <-chan struct{}) {
+	c.mu.Lock()
+	for i, ch := range c.waiting {
+		if ch == wait {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			c.mu.Unlock()
+			return
+		}
+	}
+	c.mu.Unlock()
+	c.HandshakeDone()
+}
+
+// HandshakeDone frees a handshake slot, handing it to the connection that
+// waited longest
+func (c *Controller) HandshakeDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handshakes > 0 {
+		c.handshakes--
+	}
+	c.wake()
+}
+
+// slotFree reports whether a handshake may start now
+func (c *Controller) slotFree() bool {
+	return !c.cfg.Enabled || c.cfg.MaxHandshakes <= 0 || c.handshakes < c.cfg.MaxHandshakes
+}
+
+// wake gives free handshake slots to the backlog, oldest first
+func (c *Controller) wake() {
+	for len(c.waiting) > 0 && c.slotFree() {
+		close(c.waiting[0])
+		c.waiting = c.waiting[1:]
+		c.handshakes++
+	}
+}
+
+// TimedOut counts a client closed for not subscribing in time
+func (c *Controller) TimedOut() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timedOut++
+}
+
+// Pending returns the connections admitted but not yet subscribed
+func (c *Controller) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending
+}
+
+// GetStats returns the admission view in /status
+func (c *Controller) GetStats(now time.Time) map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rejected := make(map[string]uint64, len(c.rejected))
+	for k, v := range c.rejected {
+		rejected[k] = v
+	}
+	stats := map[string]interface{}{
+		"enabled":   c.cfg.Enabled,
+		"accepted":  c.accepted,
+		"rejected":  rejected,
+		"pending":   c.pending,
+		"timed_out": c.timedOut,
+	}
+	if c.cfg.Enabled && c.cfg.MaxHandshakes > 0 {
+		stats["handshakes"] = c.handshakes
+		stats["backlog"] = len(c.waiting)
+		stats["backlogged"] = c.waited
+	}
+	if c.cfg.Enabled && c.cfg.AcceptsPerSecond > 0 {
+		c.refill(now)
+		stats["tokens"] = math.Floor(c.tokens)
+	}
+	return stats
+}
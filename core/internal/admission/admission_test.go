@@ -0,0 +1,135 @@
+package admission
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxClientsAlwaysApplies(t *testing.T) {
+	c := New(&Config{})
+	now := time.Unix(1000, 0)
+	if r := c.Admit(9, 10, now); r != ReasonNone {
+		t.Errorf("admit below the cap = %q", r)
+	}
+	if r := c.Admit(10, 10, now); r != ReasonMaxClients {
+		t.Errorf("admit at the cap = %q, want %q", r, ReasonMaxClients)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := New(&Config{Enabled: true, AcceptsPerSecond: 2, Burst: 3})
+	now := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		if r := c.Admit(0, 100, now); r != ReasonNone {
+			t.Fatalf("accept %d within burst = %q", i, r)
+		}
+		c.Release()
+	}
+	if r := c.Admit(0, 100, now); r != ReasonRate {
+		t.Fatalf("accept past burst = %q, want %q", r, ReasonRate)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if r := c.Admit(0, 100, now); r != ReasonNone {
+		t.Errorf("accept after refill = %q", r)
+	}
+	if r := c.Admit(0, 100, now); r != ReasonRate {
+		t.Errorf("second accept after half a second = %q, want %q", r, ReasonRate)
+	}
+	// a long pause refills only up to the burst
+	now = now.Add(time.Hour)
+	admitted := 0
+	for c.Admit(0, 100, now) == ReasonNone {
+		admitted++
+	}
+	if admitted != 3 {
+		t.Errorf("admitted %d after a pause, want the burst of 3", admitted)
+	}
+}
+
+func TestPendingCap(t *testing.T) {
+	c := New(&Config{Enabled: true, MaxPending: 2, SubscribeTimeoutSeconds: 5})
+	now := time.Unix(1000, 0)
+	c.Admit(0, 100, now)
+	c.Admit(0, 100, now)
+	if r := c.Admit(0, 100, now); r != ReasonPending {
+		t.Fatalf("admit with 2 pending = %q, want %q", r, ReasonPending)
+	}
+	c.Release()
+	if r := c.Admit(0, 100, now); r != ReasonNone {
+		t.Errorf("admit after a subscribe = %q", r)
+	}
+	c.TimedOut()
+
+	stats := c.GetStats(now)
+	if stats["pending"] != 2 || stats["timed_out"] != uint64(1) || stats["accepted"] != uint64(3) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	if d := (&Config{Enabled: true}).SubscribeTimeout(); d != 10*time.Second {
+		t.Errorf("default subscribe timeout = %s", d)
+	}
+	if d := (&Config{SubscribeTimeoutSeconds: 5}).SubscribeTimeout(); d != 0 {
+		t.Errorf("subscribe timeout while disabled = %s", d)
+	}
+}
+
+func TestHandshakeBacklog(t *testing.T) {
+	cfg := &Config{Enabled: true, MaxHandshakes: 2, Backlog: 2}
+	c := New(cfg)
+	for i := 0; i < 2; i++ {
+		if wait, ok := c.Handshake(); !ok || wait != nil {
+			t.Fatalf("handshake %d within the limit waited", i)
+		}
+	}
+	first, ok := c.Handshake()
+	if !ok || first == nil {
+		t.Fatal("handshake past the limit did not wait")
+	}
+	second, _ := c.Handshake()
+	if _, ok := c.Handshake(); ok {
+		t.Fatal("handshake past a full backlog was accepted")
+	}
+
+	c.HandshakeDone()
+	select {
+	case <-first:
+	default:
+		t.Fatal("freed slot not handed to the oldest waiter")
+	}
+	select {
+	case <-second:
+		t.Fatal("second waiter let in without a free slot")
+	default:
+	}
+	// a waiter that gives up leaves the backlog without taking a slot
+	c.CancelHandshake(second)
+	if stats := c.GetStats(time.Now()); stats["handshakes"] != 2 || stats["backlog"] != 0 || stats["backlogged"] != uint64(2) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	// raising the limit on reload lets the backlog in at once
+	third, _ := c.Handshake()
+	c.UpdateConfig(&Config{Enabled: true, MaxHandshakes: 3})
+	select {
+	case <-third:
+	default:
+		t.Fatal("raised limit did not release the backlog")
+	}
+	if d := cfg.Stagger(); d != 0 {
+		t.Errorf("stagger without stagger_ms = %s", d)
+	}
+	if d := (&Config{StaggerMs: 50}).Stagger(); d < 0 || d >= 50*time.Millisecond {
+		t.Errorf("stagger %s outside [0, 50ms)", d)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (&Config{Enabled: true, AcceptsPerSecond: 50, MaxPending: 200}).Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	if err := (&Config{AcceptsPerSecond: -1}).Validate(); err == nil {
+		t.Error("negative rate accepted")
+	}
+	if err := (&Config{StaggerMs: -1}).Validate(); err == nil {
+		t.Error("negative stagger accepted")
+	}
+}
@@ -0,0 +1,177 @@
+// Package aggregate validates shares locally so karoo can present its whole
+// fleet to the pool as a single high-difficulty worker
+package aggregate
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// maxJobs bounds the jobs remembered between clean notifications
+const maxJobs = 32
+
+// Config holds aggregated submission settings
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// ShareDifficulty is the client difficulty used when vardiff is disabled
+	ShareDifficulty float64 `json:"share_difficulty"`
+}
+
+var (
+	errMalformed     = stratum.NewError(stratum.CodeOther, "Malformed share")
+	errJobNotFound   = stratum.ErrJobNotFound
+	errDuplicate     = stratum.ErrDuplicate
+	errLowDifficulty = stratum.ErrLowDifficulty
+)
+
+type job struct {
+	stratum.Job
+	seen map[string]struct{}
+}
+
+// Aggregator tracks upstream jobs and difficulty and decides, per share,
+// whether it is rejected, acknowledged locally or worth forwarding
+type Aggregator struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	order  []string
+	upDiff float64
+	alg    *stratum.Algorithm
+
+	validated atomic.Uint64
+	forwarded atomic.Uint64
+	local     atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+// New creates an aggregator. Until the pool sets a difficulty it is assumed
+// to be 1, the Stratum default, so every valid share is forwarded.
+func New() *Aggregator {
+	return &Aggregator{jobs: make(map[string]*job), upDiff: 1, alg: stratum.SHA256d}
+}
+
+// SetAlgorithm selects the proof of work shares are validated with
+func (a *Aggregator) SetAlgorithm(alg *stratum.Algorithm) {
+	if alg == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alg = alg
+}
+
+// SetUpstreamDifficulty records the difficulty the pool assigned to karoo
+func (a *Aggregator) SetUpstreamDifficulty(d float64) {
+	if d <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.upDiff = d
+}
+
+// UpstreamDifficulty returns the pool's current difficulty
+func (a *Aggregator) UpstreamDifficulty() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.upDiff
+}
+
+// AddJob remembers an upstream job; clean jobs invalidate the older ones
+func (a *Aggregator) AddJob(j stratum.Job) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if j.Clean {
+		a.jobs = make(map[string]*job)
+		a.order = a.order[:0]
+	}
+	if _, ok := a.jobs[j.ID]; !ok {
+		a.order = append(a.order, j.ID)
+	}
+	a.jobs[j.ID] = &job{Job: j, seen: make(map[string]struct{})}
+	for len(a.order) > maxJobs {
+		delete(a.jobs, a.order[0])
+		a.order = a.order[1:]
+	}
+}
+
+// Reset forgets all jobs, e.g. after the upstream connection drops
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.jobs = make(map[string]*job)
+	a.order = a.order[:0]
+	a.upDiff = 1
+}
+
+// Check validates a share whose extranonce2 has already been widened to the
+// upstream size. It returns a *stratum.Error for shares below clientDiff and
+// reports whether the share also meets the pool difficulty.
+func (a *Aggregator) Check(params []any, ex1 string, clientDiff float64) (bool, error) {
+	if len(params) < 5 {
+		a.rejected.Add(1)
+		return false, errMalformed
+	}
+	var fields [6]string
+	for i := 1; i < len(params) && i < 6; i++ {
+		s, ok := params[i].(string)
+		if !ok {
+			a.rejected.Add(1)
+			return false, errMalformed
+		}
+		fields[i] = strings.ToLower(s)
+	}
+	jobID, ex2, ntime, nonce, version := fields[1], fields[2], fields[3], fields[4], fields[5]
+
+	a.mu.Lock()
+	j, ok := a.jobs[jobID]
+	if !ok {
+		a.mu.Unlock()
+		a.rejected.Add(1)
+		return false, errJobNotFound
+	}
+	key := ex2 + ntime + nonce + version
+	if _, dup := j.seen[key]; dup {
+		a.mu.Unlock()
+		a.rejected.Add(1)
+		return false, errDuplicate
+	}
+	j.seen[key] = struct{}{}
+	upDiff, alg := a.upDiff, a.alg
+	a.mu.Unlock()
+
+	diff, err := alg.ShareDifficulty(j.Job, ex1, ex2, ntime, nonce, version)
+	if err != nil {
+		a.rejected.Add(1)
+		return false, errMalformed
+	}
+	if clientDiff > 0 && diff < clientDiff*0.999 {
+		a.rejected.Add(1)
+		return false, errLowDifficulty
+	}
+	a.validated.Add(1)
+	if diff >= upDiff*0.999 {
+		a.forwarded.Add(1)
+		return true, nil
+	}
+	a.local.Add(1)
+	return false, nil
+}
+
+// GetStats returns aggregation counters for /status
+func (a *Aggregator) GetStats() map[string]interface{} {
+	a.mu.Lock()
+	upDiff, jobs := a.upDiff, len(a.jobs)
+	a.mu.Unlock()
+	return map[string]interface{}{
+		"upstream_difficulty": upDiff,
+		"jobs":                jobs,
+		"validated":           a.validated.Load(),
+		"forwarded":           a.forwarded.Load(),
+		"acknowledged_local":  a.local.Load(),
+		"rejected":            a.rejected.Load(),
+	}
+}
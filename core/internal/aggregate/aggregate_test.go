@@ -0,0 +1,105 @@
+package aggregate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+const genesisCoinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+// genesisJob rebuilds the genesis header (difficulty ~2536) when submitted
+// with ex1 04ffff00, ex2 1d010445 and nonce 7c2bac1d
+func genesisJob(id string, clean bool) stratum.Job {
+	return stratum.Job{
+		ID:        id,
+		PrevHash:  strings.Repeat("0", 64),
+		Coinbase1: genesisCoinbase[:84],
+		Coinbase2: genesisCoinbase[100:],
+		Version:   "00000001",
+		NBits:     "1d00ffff",
+		NTime:     "495fab29",
+		Clean:     clean,
+	}
+}
+
+func share(job, nonce string) []any {
+	return []any{"karoo", job, "1D010445", "495fab29", nonce}
+}
+
+func code(err error) int {
+	var se *stratum.Error
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return 0
+}
+
+func TestCheck(t *testing.T) {
+	a := New()
+	a.AddJob(genesisJob("1", true))
+
+	// pool difficulty above the share: acknowledged locally
+	a.SetUpstreamDifficulty(4096)
+	forward, err := a.Check(share("1", "7c2bac1d"), "04ffff00", 1000)
+	if err != nil || forward {
+		t.Fatalf("Check = %v, %v; want local ack", forward, err)
+	}
+	if _, err := a.Check(share("1", "7C2BAC1D"), "04ffff00", 1000); code(err) != 22 {
+		t.Errorf("duplicate share: got %v, want code 22", err)
+	}
+	if _, err := a.Check(share("1", "7c2bac1e"), "04ffff00", 1000); code(err) != 23 {
+		t.Errorf("low difficulty share: got %v, want code 23", err)
+	}
+	if _, err := a.Check(share("9", "7c2bac1d"), "04ffff00", 1000); code(err) != 21 {
+		t.Errorf("unknown job: got %v, want code 21", err)
+	}
+	if _, err := a.Check([]any{"karoo", "1"}, "04ffff00", 1000); code(err) != 20 {
+		t.Errorf("malformed share: got %v, want code 20", err)
+	}
+
+	// pool difficulty met: forwarded
+	a.AddJob(genesisJob("2", false))
+	a.SetUpstreamDifficulty(2048)
+	forward, err = a.Check(share("2", "7c2bac1d"), "04ffff00", 1000)
+	if err != nil || !forward {
+		t.Errorf("Check = %v, %v; want forward", forward, err)
+	}
+
+	// a clean job drops older ones
+	a.AddJob(genesisJob("3", true))
+	if _, err := a.Check(share("2", "7c2bac1d"), "04ffff00", 1000); code(err) != 21 {
+		t.Errorf("stale job after clean: got %v, want code 21", err)
+	}
+
+	st := a.GetStats()
+	if st["forwarded"] != uint64(1) || st["acknowledged_local"] != uint64(1) || st["rejected"] != uint64(5) {
+		t.Errorf("unexpected stats: %v", st)
+	}
+}
+
+func TestJobLimit(t *testing.T) {
+	a := New()
+	for i := 0; i < maxJobs+5; i++ {
+		a.AddJob(genesisJob(string(rune('a'+i)), false))
+	}
+	if n := len(a.jobs); n != maxJobs {
+		t.Errorf("jobs = %d, want %d", n, maxJobs)
+	}
+	if _, ok := a.jobs["a"]; ok {
+		t.Error("oldest job should be evicted")
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	a := New()
+	a.SetAlgorithm(stratum.Scrypt)
+	a.AddJob(genesisJob("1", true))
+
+	// the Bitcoin genesis header is no scrypt proof of work
+	if _, err := a.Check(share("1", "7c2bac1d"), "04ffff00", 1000); code(err) != 23 {
+		t.Errorf("sha256d share under scrypt: got %v, want code 23", err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package allocaudit measures heap allocations per processed Stratum message
+package allocaudit
+
+import (
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	metricObjects = "/gc/heap/allocs:objects"
+	metricBytes   = "/gc/heap/allocs:bytes"
+)
+
+// Sample is a snapshot of the cumulative allocation counters taken before a
+// message is processed
+type Sample struct {
+	objects uint64
+	bytes   uint64
+	ok      bool
+}
+
+// MethodStats reports the allocation averages for one message method
+type MethodStats struct {
+	Method       string  `json:"method"`
+	Messages     uint64  `json:"messages"`
+	AllocsPerMsg float64 `json:"allocs_per_msg"`
+	BytesPerMsg  float64 `json:"bytes_per_msg"`
+	MaxAllocs    uint64  `json:"max_allocs"`
+}
+
+// Report is the audit snapshot served by the admin API
+type Report struct {
+	Enabled bool          `json:"enabled"`
+	Methods []MethodStats `json:"methods"`
+}
+
+type totals struct {
+	messages  uint64
+	objects   uint64
+	bytes     uint64
+	maxAllocs uint64
+}
+
+// Auditor accumulates allocation deltas per method. The runtime counters are
+// process-wide, so concurrent goroutines inflate the numbers; averages are
+// meant for comparing builds under similar load, not as exact figures.
+type Auditor struct {
+	enabled atomic.Bool
+
+	mu    sync.Mutex
+	stats map[string]*totals
+}
+
+// New creates an auditor, optionally enabled
+func New(enabled bool) *Auditor {
+	a := &Auditor{stats: make(map[string]*totals)}
+	a.enabled.Store(enabled)
+	return a
+}
+
+// SetEnabled turns auditing on or off; collected stats are kept
+func (a *Auditor) SetEnabled(enabled bool) {
+	a.enabled.Store(enabled)
+}
+
+// Enabled reports whether auditing is on
+func (a *Auditor) Enabled() bool {
+	return a.enabled.Load()
+}
+
+// Begin snapshots the allocation counters. It costs nothing while disabled.
+func (a *Auditor) Begin() Sample {
+	if !a.enabled.Load() {
+		return Sample{}
+	}
+	objects, bytes := read()
+	return Sample{objects: objects, bytes: bytes, ok: true}
+}
+
+// End records the allocations made since s under method
+func (a *Auditor) End(s Sample, method string) {
+	if !s.ok {
+		return
+	}
+	objects, bytes := read()
+	dObjects, dBytes := objects-s.objects, bytes-s.bytes
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.stats[method]
+	if t == nil {
+		t = &totals{}
+		a.stats[method] = t
+	}
+	t.messages++
+	t.objects += dObjects
+	t.bytes += dBytes
+	if dObjects > t.maxAllocs {
+		t.maxAllocs = dObjects
+	}
+}
+
+// Reset discards the collected stats
+func (a *Auditor) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats = make(map[string]*totals)
+}
+
+// Report returns per-method averages sorted by method name
+func (a *Auditor) Report() Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rep := Report{Enabled: a.enabled.Load(), Methods: make([]MethodStats, 0, len(a.stats))}
+	for method, t := range a.stats {
+		rep.Methods = append(rep.Methods, MethodStats{
+			Method:       method,
+			Messages:     t.messages,
+			AllocsPerMsg: float64(t.objects) / float64(t.messages),
+			BytesPerMsg:  float64(t.bytes) / float64(t.messages),
+			MaxAllocs:    t.maxAllocs,
+		})
+	}
+	sort.Slice(rep.Methods, func(i, j int) bool { return rep.Methods[i].Method < rep.Methods[j].Method })
+	return rep
+}
+
+// read returns the cumulative heap allocation counters
+func read() (objects, bytes uint64) {
+	samples := []metrics.Sample{{Name: metricObjects}, {Name: metricBytes}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		objects = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		bytes = samples[1].Value.Uint64()
+	}
+	return objects, bytes
+}
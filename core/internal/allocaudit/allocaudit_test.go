@@ -0,0 +1,49 @@
+package allocaudit
+
+import "testing"
+
+var sink [][]byte
+
+func TestAuditorDisabled(t *testing.T) {
+	a := New(false)
+	s := a.Begin()
+	sink = append(sink, make([]byte, 1024))
+	a.End(s, "mining.submit")
+	if rep := a.Report(); rep.Enabled || len(rep.Methods) != 0 {
+		t.Errorf("disabled auditor recorded stats: %+v", rep)
+	}
+}
+
+func TestAuditorRecords(t *testing.T) {
+	a := New(true)
+	for i := 0; i < 10; i++ {
+		s := a.Begin()
+		for j := 0; j < 4; j++ {
+			sink = append(sink, make([]byte, 4096))
+		}
+		a.End(s, "mining.submit")
+	}
+	a.End(a.Begin(), "mining.authorize")
+	sink = nil
+
+	rep := a.Report()
+	if !rep.Enabled || len(rep.Methods) != 2 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if rep.Methods[0].Method != "mining.authorize" || rep.Methods[1].Method != "mining.submit" {
+		t.Errorf("methods not sorted: %+v", rep.Methods)
+	}
+	sub := rep.Methods[1]
+	if sub.Messages != 10 {
+		t.Errorf("messages = %d, want 10", sub.Messages)
+	}
+	// Runtime counters are flushed lazily per P, so only expect a rough figure
+	if sub.AllocsPerMsg < 1 || sub.BytesPerMsg < 4096 {
+		t.Errorf("allocations not captured: %+v", sub)
+	}
+
+	a.Reset()
+	if len(a.Report().Methods) != 0 {
+		t.Error("reset did not clear stats")
+	}
+}
@@ -0,0 +1,311 @@
+// Package availability tracks daily proxy availability for SLA reporting
+package availability
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config holds availability tracking configuration
+type Config struct {
+	Enabled          bool   `json:"enabled"`
+	StateFile        string `json:"state_file"`
+	SampleIntervalMs int    `json:"sample_interval_ms"`
+	RetentionDays    int    `json:"retention_days"`
+}
+
+// Sample is a point-in-time view of the proxy used to accumulate daily stats
+type Sample struct {
+	UpstreamUp bool
+	ListenerUp bool
+	SharesOK   uint64 // cumulative
+	SharesBad  uint64 // cumulative
+}
+
+// Day holds the raw accumulated counters for one UTC day
+type Day struct {
+	Date              string  `json:"date"`
+	ObservedSeconds   float64 `json:"observed_seconds"`
+	UpstreamUpSeconds float64 `json:"upstream_up_seconds"`
+	ListenerUpSeconds float64 `json:"listener_up_seconds"`
+	SharesOK          uint64  `json:"shares_ok"`
+	SharesBad         uint64  `json:"shares_bad"`
+	Outages           int     `json:"outages"`
+	LongestOutageSecs float64 `json:"longest_outage_seconds"`
+}
+
+// Summary is the availability view of a day or a month
+type Summary struct {
+	Period            string  `json:"period"`
+	ObservedSeconds   float64 `json:"observed_seconds"`
+	UpstreamUptimePct float64 `json:"upstream_uptime_pct"`
+	ListenerUptimePct float64 `json:"listener_uptime_pct"`
+	ShareSuccessPct   float64 `json:"share_success_pct"`
+	SharesOK          uint64  `json:"shares_ok"`
+	SharesBad         uint64  `json:"shares_bad"`
+	Outages           int     `json:"outages"`
+	LongestOutageSecs float64 `json:"longest_outage_seconds"`
+}
+
+// Report is the payload served at /api/availability
+type Report struct {
+	Daily         []Summary `json:"daily"`
+	Monthly       []Summary `json:"monthly"`
+	CurrentOutage float64   `json:"current_outage_seconds"`
+}
+
+// Tracker accumulates availability samples into per-day buckets
+type Tracker struct {
+	cfg *Config
+
+	mu        sync.Mutex
+	days      map[string]*Day
+	last      time.Time
+	lastOK    uint64
+	lastBad   uint64
+	outageRun float64
+	upWasUp   bool
+}
+
+// NewTracker creates a new availability tracker
+func NewTracker(cfg *Config) *Tracker {
+	return &Tracker{
+		cfg:     cfg,
+		days:    make(map[string]*Day),
+		upWasUp: true,
+	}
+}
+
+// UpdateConfig updates the tracker configuration
+func (t *Tracker) UpdateConfig(cfg *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+}
+
+// Record accumulates a sample taken at now. The first sample only sets the
+// baseline; later samples credit the elapsed time to the day of now.
+func (t *Tracker) Record(now time.Time, s Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.last.IsZero() || s.SharesOK < t.lastOK || s.SharesBad < t.lastBad {
+		t.last, t.lastOK, t.lastBad = now, s.SharesOK, s.SharesBad
+		return
+	}
+	elapsed := now.Sub(t.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	d := t.day(now)
+	d.ObservedSeconds += elapsed
+	if s.UpstreamUp {
+		d.UpstreamUpSeconds += elapsed
+		t.outageRun = 0
+	} else {
+		if t.upWasUp {
+			d.Outages++
+		}
+		t.outageRun += elapsed
+		if t.outageRun > d.LongestOutageSecs {
+			d.LongestOutageSecs = t.outageRun
+		}
+	}
+	if s.ListenerUp {
+		d.ListenerUpSeconds += elapsed
+	}
+	d.SharesOK += s.SharesOK - t.lastOK
+	d.SharesBad += s.SharesBad - t.lastBad
+
+	t.upWasUp = s.UpstreamUp
+	t.last, t.lastOK, t.lastBad = now, s.SharesOK, s.SharesBad
+	t.prune(now)
+}
+
+// day returns the bucket for now's UTC date, creating it if needed
+func (t *Tracker) day(now time.Time) *Day {
+	key := now.UTC().Format("2006-01-02")
+	d, ok := t.days[key]
+	if !ok {
+		d = &Day{Date: key}
+		t.days[key] = d
+	}
+	return d
+}
+
+// prune drops days older than the retention window
+func (t *Tracker) prune(now time.Time) {
+	if t.cfg.RetentionDays <= 0 {
+		return
+	}
+	cutoff := now.UTC().AddDate(0, 0, -t.cfg.RetentionDays).Format("2006-01-02")
+	for key := range t.days {
+		if key < cutoff {
+			delete(t.days, key)
+		}
+	}
+}
+
+// Report returns daily summaries and monthly rollups, oldest first
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.days))
+	for k := range t.days {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rep := Report{Daily: []Summary{}, Monthly: []Summary{}}
+	if !t.upWasUp {
+		rep.CurrentOutage = t.outageRun
+	}
+	months := make(map[string]*Day)
+	var monthKeys []string
+	for _, k := range keys {
+		d := t.days[k]
+		rep.Daily = append(rep.Daily, summarize(d.Date, d))
+
+		mk := k[:7]
+		m, ok := months[mk]
+		if !ok {
+			m = &Day{Date: mk}
+			months[mk] = m
+			monthKeys = append(monthKeys, mk)
+		}
+		m.ObservedSeconds += d.ObservedSeconds
+		m.UpstreamUpSeconds += d.UpstreamUpSeconds
+		m.ListenerUpSeconds += d.ListenerUpSeconds
+		m.SharesOK += d.SharesOK
+		m.SharesBad += d.SharesBad
+		m.Outages += d.Outages
+		if d.LongestOutageSecs > m.LongestOutageSecs {
+			m.LongestOutageSecs = d.LongestOutageSecs
+		}
+	}
+	for _, mk := range monthKeys {
+		rep.Monthly = append(rep.Monthly, summarize(mk, months[mk]))
+	}
+	return rep
+}
+
+// summarize converts raw counters into percentages
+func summarize(period string, d *Day) Summary {
+	s := Summary{
+		Period:            period,
+		ObservedSeconds:   d.ObservedSeconds,
+		SharesOK:          d.SharesOK,
+		SharesBad:         d.SharesBad,
+		Outages:           d.Outages,
+		LongestOutageSecs: d.LongestOutageSecs,
+	}
+	if d.ObservedSeconds > 0 {
+		s.UpstreamUptimePct = d.UpstreamUpSeconds / d.ObservedSeconds * 100
+		s.ListenerUptimePct = d.ListenerUpSeconds / d.ObservedSeconds * 100
+	}
+	if total := d.SharesOK + d.SharesBad; total > 0 {
+		s.ShareSuccessPct = float64(d.SharesOK) / float64(total) * 100
+	}
+	return s
+}
+
+// SaveState writes the daily buckets to path atomically
+func (t *Tracker) SaveState(path string) error {
+	t.mu.Lock()
+	days := make([]Day, 0, len(t.days))
+	for _, d := range t.days {
+		days = append(days, *d)
+	}
+	t.mu.Unlock()
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+
+	data, err := json.MarshalIndent(days, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".availability-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState reads daily buckets previously written by SaveState.
+// A missing file is not an error.
+func (t *Tracker) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var days []Day
+	if err := json.Unmarshal(data, &days); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	for i := range days {
+		d := days[i]
+		t.days[d.Date] = &d
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// Run samples the proxy at the configured interval until ctx is cancelled,
+// persisting state after every sample when a state file is configured
+func (t *Tracker) Run(ctx context.Context, sample func() Sample) {
+	if !t.cfg.Enabled {
+		return
+	}
+	interval := time.Duration(t.cfg.SampleIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	t.Record(time.Now(), sample())
+	for {
+		select {
+		case <-ctx.Done():
+			t.Record(time.Now(), sample())
+			t.persist()
+			return
+		case now := <-ticker.C:
+			t.Record(now, sample())
+			t.persist()
+		}
+	}
+}
+
+// persist saves state to the configured file, if any
+func (t *Tracker) persist() {
+	t.mu.Lock()
+	path := t.cfg.StateFile
+	t.mu.Unlock()
+	if path == "" {
+		return
+	}
+	if err := t.SaveState(path); err != nil {
+		log.Printf("availability: saving state to %s: %v", path, err)
+	}
+}
@@ -0,0 +1,72 @@
+package availability
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordDailyStats(t *testing.T) {
+	tr := NewTracker(&Config{Enabled: true})
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tr.Record(start, Sample{UpstreamUp: true, ListenerUp: true})
+	tr.Record(start.Add(60*time.Second), Sample{UpstreamUp: true, ListenerUp: true, SharesOK: 9, SharesBad: 1})
+	tr.Record(start.Add(90*time.Second), Sample{UpstreamUp: false, ListenerUp: true, SharesOK: 9, SharesBad: 1})
+	tr.Record(start.Add(120*time.Second), Sample{UpstreamUp: false, ListenerUp: true, SharesOK: 9, SharesBad: 1})
+	tr.Record(start.Add(180*time.Second), Sample{UpstreamUp: true, ListenerUp: true, SharesOK: 10, SharesBad: 1})
+
+	rep := tr.Report()
+	if len(rep.Daily) != 1 || len(rep.Monthly) != 1 {
+		t.Fatalf("daily=%d monthly=%d, want 1/1", len(rep.Daily), len(rep.Monthly))
+	}
+	d := rep.Daily[0]
+	if d.Period != "2024-03-10" || d.ObservedSeconds != 180 {
+		t.Errorf("period=%s observed=%v", d.Period, d.ObservedSeconds)
+	}
+	if want := 120.0 / 180 * 100; math.Abs(d.UpstreamUptimePct-want) > 1e-9 {
+		t.Errorf("upstream uptime = %v, want %v", d.UpstreamUptimePct, want)
+	}
+	if d.ListenerUptimePct != 100 {
+		t.Errorf("listener uptime = %v, want 100", d.ListenerUptimePct)
+	}
+	if d.Outages != 1 || d.LongestOutageSecs != 60 {
+		t.Errorf("outages=%d longest=%v, want 1/60", d.Outages, d.LongestOutageSecs)
+	}
+	if want := 10.0 / 11 * 100; math.Abs(d.ShareSuccessPct-want) > 1e-9 {
+		t.Errorf("share success = %v, want %v", d.ShareSuccessPct, want)
+	}
+	if rep.Monthly[0].Period != "2024-03" {
+		t.Errorf("monthly period = %s", rep.Monthly[0].Period)
+	}
+}
+
+func TestRetentionAndState(t *testing.T) {
+	tr := NewTracker(&Config{Enabled: true, RetentionDays: 30})
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr.Record(old, Sample{UpstreamUp: true})
+	tr.Record(old.Add(time.Minute), Sample{UpstreamUp: true})
+	now := old.AddDate(0, 2, 0)
+	tr.Record(now.Add(time.Minute), Sample{UpstreamUp: true})
+
+	rep := tr.Report()
+	if len(rep.Daily) != 1 || rep.Daily[0].Period != "2024-03-01" {
+		t.Fatalf("expected only the recent day to be retained, got %+v", rep.Daily)
+	}
+
+	path := filepath.Join(t.TempDir(), "availability.json")
+	if err := tr.SaveState(path); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	loaded := NewTracker(&Config{Enabled: true})
+	if err := loaded.LoadState(path); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if got := loaded.Report().Daily; len(got) != 1 || got[0].ObservedSeconds != rep.Daily[0].ObservedSeconds {
+		t.Errorf("loaded report = %+v", got)
+	}
+	if err := NewTracker(&Config{}).LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing state file should not error: %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+// Package bench drives simulated miners against a Stratum endpoint and
+// reports how it held up
+package bench
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Config describes a benchmark run
+type Config struct {
+	Target string // host:port of the proxy
+	TLS    bool
+	// InsecureSkipVerify accepts any proxy certificate
+	InsecureSkipVerify bool
+	Miners             int           // simulated miners; default 100
+	Duration           time.Duration // run time after the last miner started; default 1m
+	RampUp             time.Duration // spread miner connects over this long
+	// SubmitInterval is the time between shares of one miner; default 10s
+	SubmitInterval time.Duration
+	Worker         string // worker name prefix; miners authorize as Worker.N
+	Password       string // default "x"
+	Timeout        time.Duration
+}
+
+// Validate checks the run settings
+func (c *Config) Validate() error {
+	if c.Target == "" {
+		return errors.New("target is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Target); err != nil {
+		return fmt.Errorf("target must be host:port: %w", err)
+	}
+	if c.Miners < 0 || c.Duration < 0 || c.RampUp < 0 || c.SubmitInterval < 0 || c.Timeout < 0 {
+		return errors.New("miners and durations must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) miners() int {
+	if c.Miners == 0 {
+		return 100
+	}
+	return c.Miners
+}
+
+func (c *Config) duration() time.Duration {
+	if c.Duration == 0 {
+		return time.Minute
+	}
+	return c.Duration
+}
+
+func (c *Config) submitInterval() time.Duration {
+	if c.SubmitInterval == 0 {
+		return 10 * time.Second
+	}
+	return c.SubmitInterval
+}
+
+func (c *Config) worker() string {
+	if c.Worker == "" {
+		return "bench"
+	}
+	return c.Worker
+}
+
+func (c *Config) password() string {
+	if c.Password == "" {
+		return "x"
+	}
+	return c.Password
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return c.Timeout
+}
+
+// Latency summarizes the response times of one request method
+type Latency struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P90Ms float64 `json:"p90_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Miners        int                `json:"miners"`
+	Connected     uint64             `json:"connected"`
+	ConnectErrors uint64             `json:"connect_errors"`
+	Subscribed    uint64             `json:"subscribed"`
+	Authorized    uint64             `json:"authorized"`
+	Disconnects   uint64             `json:"disconnects"` // dropped by the target mid-run
+	Jobs          uint64             `json:"jobs"`
+	Submits       uint64             `json:"submits"`
+	Accepted      uint64             `json:"accepted"`
+	Rejected      uint64             `json:"rejected"`
+	Timeouts      uint64             `json:"timeouts"` // requests without a response
+	Errors        map[string]uint64  `json:"errors"`   // error messages returned
+	Latency       map[string]Latency `json:"latency"`
+	ElapsedMs     int64              `json:"elapsed_ms"`
+}
+
+// run collects the results of all miners
+type run struct {
+	cfg *Config
+
+	connected, connectErrors     atomic.Uint64
+	subscribed, authorized       atomic.Uint64
+	disconnects, jobs, submits   atomic.Uint64
+	accepted, rejected, timeouts atomic.Uint64
+
+	mu      sync.Mutex
+	errs    map[string]uint64
+	samples map[string][]time.Duration
+}
+
+// Run connects the miners, lets them submit until the run ends and reports
+// the results. It returns early when ctx is done.
+func Run(ctx context.Context, cfg *Config) Report {
+	r := &run{cfg: cfg, errs: make(map[string]uint64), samples: make(map[string][]time.Duration)}
+	n := cfg.miners()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, cfg.RampUp+cfg.duration())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		delay := time.Duration(0)
+		if n > 1 {
+			delay = cfg.RampUp * time.Duration(i) / time.Duration(n-1)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			r.miner(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return r.report(n, time.Since(start))
+}
+
+// pendingReq is a request awaiting its response
+type pendingReq struct {
+	method string
+	sent   time.Time
+}
+
+// miner runs one simulated miner until ctx is done or the target drops it
+func (r *run) miner(ctx context.Context, idx int) {
+	d := &net.Dialer{Timeout: r.cfg.timeout()}
+	var c net.Conn
+	var err error
+	if r.cfg.TLS {
+		host, _, _ := net.SplitHostPort(r.cfg.Target)
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host, InsecureSkipVerify: r.cfg.InsecureSkipVerify}}
+		c, err = td.DialContext(ctx, "tcp", r.cfg.Target)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", r.cfg.Target)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			r.connectErrors.Add(1)
+		}
+		return
+	}
+	r.connected.Add(1)
+	defer func() { _ = c.Close() }()
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+
+	var (
+		wmu     sync.Mutex
+		mu      sync.Mutex
+		nextID  int64
+		pending = make(map[int64]pendingReq)
+		job     stratum.Job
+		ex2Size = 4
+		ex2     uint64
+	)
+	send := func(method string, params []interface{}) {
+		mu.Lock()
+		nextID++
+		id := nextID
+		pending[id] = pendingReq{method: method, sent: time.Now()}
+		mu.Unlock()
+		b, _ := (&stratum.Message{ID: stratum.NewID(id), Method: method, Params: params}).Marshal()
+		wmu.Lock()
+		_ = c.SetWriteDeadline(time.Now().Add(r.cfg.timeout()))
+		_, _ = c.Write(b)
+		wmu.Unlock()
+	}
+
+	worker := fmt.Sprintf("%s.%d", r.cfg.worker(), idx)
+	send(stratum.MethodSubscribe, []interface{}{"karoo-bench/1.0"})
+	send(stratum.MethodAuthorize, []interface{}{worker, r.cfg.password()})
+
+	// submit on a jittered schedule once a job arrived; expire requests the
+	// target never answered
+	go func() {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(idx)))
+		every := r.cfg.submitInterval()
+		next := time.Duration(rng.Int63n(int64(every))) + every/2
+		t := time.NewTimer(next)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			now := time.Now()
+			mu.Lock()
+			for id, p := range pending {
+				if now.Sub(p.sent) > r.cfg.timeout() {
+					delete(pending, id)
+					r.timeouts.Add(1)
+				}
+			}
+			j, size := job, ex2Size
+			ex2++
+			n2 := ex2
+			mu.Unlock()
+			if j.ID != "" {
+				r.submits.Add(1)
+				send(stratum.MethodSubmit, []interface{}{
+					worker, j.ID, fmt.Sprintf("%0*x", size*2, n2), j.NTime, fmt.Sprintf("%08x", rng.Uint32()),
+				})
+			}
+			t.Reset(time.Duration(rng.Int63n(int64(every))) + every/2)
+		}
+	}()
+
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for sc.Scan() {
+		var msg stratum.Message
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Method == stratum.MethodNotify {
+			if j, ok := stratum.ParseNotify(msg.Params); ok {
+				r.jobs.Add(1)
+				mu.Lock()
+				job = j
+				mu.Unlock()
+			}
+			continue
+		}
+		id, ok := msg.ID.Int64()
+		if !ok || msg.Method != "" {
+			continue
+		}
+		mu.Lock()
+		p, found := pending[id]
+		delete(pending, id)
+		if found && p.method == stratum.MethodSubscribe {
+			if ex := stratum.ParseExtranonceResult(msg.Result); ex.Valid {
+				ex2Size = ex.Extranonce2Size
+			}
+		}
+		mu.Unlock()
+		if found {
+			r.response(p, msg)
+		}
+	}
+	if ctx.Err() == nil {
+		r.disconnects.Add(1)
+	}
+}
+
+// response records the outcome and latency of one request
+func (r *run) response(p pendingReq, msg stratum.Message) {
+	failed := msg.Error != nil || msg.Result == false
+	switch p.method {
+	case stratum.MethodSubscribe:
+		if !failed {
+			r.subscribed.Add(1)
+		}
+	case stratum.MethodAuthorize:
+		if !failed {
+			r.authorized.Add(1)
+		}
+	case stratum.MethodSubmit:
+		if failed {
+			r.rejected.Add(1)
+		} else {
+			r.accepted.Add(1)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[p.method] = append(r.samples[p.method], time.Since(p.sent))
+	if failed {
+		_, m := stratum.ParseError(msg.Error)
+		if m == "" {
+			m = p.method + " refused"
+		}
+		r.errs[strings.ToLower(m)]++
+	}
+}
+
+// report builds the final report
+func (r *run) report(miners int, elapsed time.Duration) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := Report{
+		Miners:        miners,
+		Connected:     r.connected.Load(),
+		ConnectErrors: r.connectErrors.Load(),
+		Subscribed:    r.subscribed.Load(),
+		Authorized:    r.authorized.Load(),
+		Disconnects:   r.disconnects.Load(),
+		Jobs:          r.jobs.Load(),
+		Submits:       r.submits.Load(),
+		Accepted:      r.accepted.Load(),
+		Rejected:      r.rejected.Load(),
+		Timeouts:      r.timeouts.Load(),
+		Errors:        r.errs,
+		Latency:       make(map[string]Latency, len(r.samples)),
+		ElapsedMs:     elapsed.Milliseconds(),
+	}
+	for method, s := range r.samples {
+		rep.Latency[method] = summarize(s)
+	}
+	return rep
+}
+
+// summarize computes the latency percentiles of the samples
+func summarize(s []time.Duration) Latency {
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	at := func(q float64) float64 {
+		i := int(q * float64(len(s)-1))
+		return float64(s[i].Microseconds()) / 1000
+	}
+	return Latency{Count: len(s), P50Ms: at(0.5), P90Ms: at(0.9), P99Ms: at(0.99), MaxMs: at(1)}
+}
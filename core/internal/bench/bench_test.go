@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/sim"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := sim.New(&sim.Config{NotifyIntervalMs: 100, RejectRate: 0.5})
+	go func() { _ = pool.Serve(ctx, ln) }()
+
+	rep := Run(context.Background(), &Config{
+		Target:         ln.Addr().String(),
+		Miners:         5,
+		Duration:       700 * time.Millisecond,
+		RampUp:         100 * time.Millisecond,
+		SubmitInterval: 100 * time.Millisecond,
+		Timeout:        time.Second,
+	})
+	if rep.Connected != 5 || rep.Subscribed != 5 || rep.Authorized != 5 {
+		t.Fatalf("handshakes: %+v", rep)
+	}
+	if rep.Submits == 0 || rep.Accepted == 0 || rep.Rejected == 0 {
+		t.Errorf("shares: submitted %d accepted %d rejected %d", rep.Submits, rep.Accepted, rep.Rejected)
+	}
+	if rep.Errors["low difficulty share"] == 0 {
+		t.Errorf("errors = %v", rep.Errors)
+	}
+	if l := rep.Latency[stratum.MethodSubmit]; l.Count == 0 || l.MaxMs < l.P50Ms {
+		t.Errorf("submit latency = %+v", l)
+	}
+	if rep.ConnectErrors != 0 || rep.Disconnects != 0 {
+		t.Errorf("failures: %+v", rep)
+	}
+
+	if err := (&Config{Target: "nohostport"}).Validate(); err == nil {
+		t.Error("target without a port accepted")
+	}
+}
@@ -0,0 +1,406 @@
+// Package canary runs a tiny synthetic miner through the proxy's own
+// listener to continuously verify that shares are accepted end to end
+package canary
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Canary states
+const (
+	StateStarting = "starting"
+	StateHealthy  = "healthy"
+	StateFailing  = "failing"
+)
+
+// Config holds canary configuration
+type Config struct {
+	Enabled           bool   `json:"enabled"`
+	Addr              string `json:"-"` // proxy listener to dial
+	TLS               bool   `json:"-"`
+	Worker            string `json:"worker"`
+	Password          string `json:"password"`
+	HashesPerSec      int    `json:"hashes_per_sec"`
+	SubmitIntervalMs  int    `json:"submit_interval_ms"`
+	FailThreshold     int    `json:"fail_threshold"`
+	ResponseTimeoutMs int    `json:"response_timeout_ms"`
+}
+
+// Canary is a throttled CPU miner that reports share health
+type Canary struct {
+	cfg *Config
+
+	mu          sync.Mutex
+	state       string
+	accepted    uint64
+	failed      uint64
+	consecutive int
+	lastAccept  time.Time
+	lastSubmit  time.Time
+	lastError   string
+	onChange    func(healthy bool, reason string)
+
+	// per-session state, guarded by mu
+	ex1        string
+	ex2Size    int
+	difficulty float64
+	current    *stratum.Job
+	pending    map[int64]time.Time
+}
+
+// New creates a canary
+func New(cfg *Config) *Canary {
+	return &Canary{cfg: cfg, state: StateStarting}
+}
+
+// OnChange registers a callback invoked when the canary turns healthy or failing
+func (c *Canary) OnChange(fn func(healthy bool, reason string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChange = fn
+}
+
+// Run keeps a canary session open until ctx is cancelled
+func (c *Canary) Run(ctx context.Context) {
+	if !c.cfg.Enabled {
+		return
+	}
+	for ctx.Err() == nil {
+		if err := c.session(ctx); err != nil && ctx.Err() == nil {
+			c.fail(err.Error())
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// session runs a single connection: handshake, mining and response handling
+func (c *Canary) session(ctx context.Context) error {
+	var conn net.Conn
+	var err error
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	if c.cfg.TLS {
+		conn, err = tls.DialWithDialer(d, "tcp", c.cfg.Addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.cfg.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", c.cfg.Addr, err)
+	}
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-sessCtx.Done()
+		_ = conn.Close()
+	}()
+
+	c.mu.Lock()
+	c.ex1, c.ex2Size, c.difficulty, c.current = "", 0, 1, nil
+	c.pending = make(map[int64]time.Time)
+	c.mu.Unlock()
+
+	w := &writer{bw: bufio.NewWriter(conn)}
+	if err := w.send(stratum.Message{ID: stratum.NewID(1), Method: stratum.MethodSubscribe, Params: []interface{}{"karoo-canary"}}); err != nil {
+		return err
+	}
+	if err := w.send(stratum.Message{ID: stratum.NewID(2), Method: stratum.MethodAuthorize, Params: []interface{}{c.cfg.Worker, c.cfg.Password}}); err != nil {
+		return err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.readLoop(conn)
+		cancel()
+	}()
+
+	c.mine(sessCtx, w)
+	select {
+	case err := <-readErr:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("connection lost: %v", err)
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// readLoop processes messages from the proxy until the connection closes
+func (c *Canary) readLoop(conn net.Conn) error {
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for sc.Scan() {
+		var msg stratum.Message
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			continue
+		}
+		id, hasID := msg.ID.Int64()
+		switch {
+		case msg.Method == stratum.MethodSetDifficulty:
+			if arr, ok := msg.Params.([]interface{}); ok && len(arr) > 0 {
+				if d, ok := arr[0].(float64); ok && d > 0 {
+					c.mu.Lock()
+					c.difficulty = d
+					c.mu.Unlock()
+				}
+			}
+		case msg.Method == stratum.MethodNotify:
+			j, ok := stratum.ParseNotify(msg.Params)
+			if !ok {
+				log.Printf("canary: invalid notify")
+				continue
+			}
+			c.mu.Lock()
+			c.current = &j
+			c.mu.Unlock()
+		case hasID && id == 1:
+			info := stratum.ParseExtranonceResult(msg.Result)
+			if !info.Valid {
+				return fmt.Errorf("invalid subscribe result")
+			}
+			c.mu.Lock()
+			c.ex1, c.ex2Size = info.Extranonce1, info.Extranonce2Size
+			c.mu.Unlock()
+		case hasID && id == 2:
+			if ok, _ := msg.Result.(bool); !ok {
+				_, reason := stratum.ParseError(msg.Error)
+				return fmt.Errorf("authorize rejected: %s", reason)
+			}
+		case hasID:
+			c.handleSubmitResponse(id, msg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("closed by proxy")
+}
+
+// handleSubmitResponse records the outcome of a canary submit
+func (c *Canary) handleSubmitResponse(id int64, msg stratum.Message) {
+	c.mu.Lock()
+	_, known := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if !known {
+		return
+	}
+	if ok, _ := msg.Result.(bool); ok {
+		c.succeed()
+		return
+	}
+	_, reason := stratum.ParseError(msg.Error)
+	if reason == "" {
+		reason = "rejected"
+	}
+	c.fail("share rejected: " + reason)
+}
+
+// mine hashes the current job at the configured rate, submitting at most one
+// share per submit interval
+func (c *Canary) mine(ctx context.Context, w *writer) {
+	const tick = 100 * time.Millisecond
+	perTick := c.cfg.HashesPerSec / 10
+	if perTick < 1 {
+		perTick = 1
+	}
+	interval := time.Duration(c.cfg.SubmitIntervalMs) * time.Millisecond
+	timeout := time.Duration(c.cfg.ResponseTimeoutMs) * time.Millisecond
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var (
+		jobID  string
+		ex2    uint64
+		nonce  uint32
+		header []byte
+		nextID int64 = 3
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.expirePending(timeout)
+
+		c.mu.Lock()
+		j, ex1, ex2Size, diff, last := c.current, c.ex1, c.ex2Size, c.difficulty, c.lastSubmit
+		c.mu.Unlock()
+		if j == nil || ex1 == "" || ex2Size <= 0 || time.Since(last) < interval {
+			continue
+		}
+		if j.ID != jobID || header == nil {
+			jobID, nonce = j.ID, 0
+			ex2++
+			var err error
+			if header, err = j.Header(ex1, ex2Hex(ex2, ex2Size), j.NTime, "00000000", ""); err != nil {
+				log.Printf("canary: %v", err)
+				header = nil
+				continue
+			}
+		}
+
+		target := stratum.SHA256d.Target(diff)
+		for i := 0; i < perTick; i++ {
+			binary.LittleEndian.PutUint32(header[76:], nonce)
+			found := stratum.SHA256d.HeaderHash(header).Cmp(target) <= 0
+			n := nonce
+			nonce++
+			if nonce == 0 {
+				header = nil // nonce space exhausted: roll extranonce2
+			}
+			if found {
+				id := nextID
+				nextID++
+				c.mu.Lock()
+				c.pending[id] = time.Now()
+				c.lastSubmit = time.Now()
+				c.mu.Unlock()
+				params := []interface{}{c.cfg.Worker, j.ID, ex2Hex(ex2, ex2Size), j.NTime, fmt.Sprintf("%08x", n)}
+				if err := w.send(stratum.Message{ID: stratum.NewID(id), Method: stratum.MethodSubmit, Params: params}); err != nil {
+					return
+				}
+				break
+			}
+			if header == nil {
+				break
+			}
+		}
+	}
+}
+
+// expirePending fails submits that got no answer within the timeout
+func (c *Canary) expirePending(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	var expired int
+	c.mu.Lock()
+	for id, sent := range c.pending {
+		if time.Since(sent) > timeout {
+			delete(c.pending, id)
+			expired++
+		}
+	}
+	c.mu.Unlock()
+	for i := 0; i < expired; i++ {
+		c.fail("no response to share within " + timeout.String())
+	}
+}
+
+// succeed records an accepted share
+func (c *Canary) succeed() {
+	c.mu.Lock()
+	c.accepted++
+	c.consecutive = 0
+	c.lastAccept = time.Now()
+	changed := c.state != StateHealthy
+	wasFailing := c.state == StateFailing
+	c.state = StateHealthy
+	cb := c.onChange
+	c.mu.Unlock()
+	if wasFailing {
+		log.Printf("canary: recovered, shares accepted again")
+	}
+	if changed && cb != nil {
+		cb(true, "")
+	}
+}
+
+// fail records a failed share or session and raises the alert at the threshold
+func (c *Canary) fail(reason string) {
+	c.mu.Lock()
+	c.failed++
+	c.consecutive++
+	c.lastError = reason
+	threshold := c.cfg.FailThreshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	alert := c.consecutive >= threshold && c.state != StateFailing
+	if alert {
+		c.state = StateFailing
+	}
+	cb := c.onChange
+	c.mu.Unlock()
+	if alert {
+		log.Printf("canary: ALERT end-to-end shares failing: %s", reason)
+		if cb != nil {
+			cb(false, reason)
+		}
+	}
+}
+
+// Healthy reports whether the canary is not in the failing state
+func (c *Canary) Healthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state != StateFailing
+}
+
+// GetStats returns canary statistics
+func (c *Canary) GetStats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lastAccept int64
+	if !c.lastAccept.IsZero() {
+		lastAccept = c.lastAccept.Unix()
+	}
+	return map[string]interface{}{
+		"state":                c.state,
+		"worker":               c.cfg.Worker,
+		"accepted":             c.accepted,
+		"failed":               c.failed,
+		"consecutive_failures": c.consecutive,
+		"last_accept_unix":     lastAccept,
+		"last_error":           c.lastError,
+		"difficulty":           c.difficulty,
+	}
+}
+
+// writer serializes stratum messages onto the canary connection
+type writer struct {
+	mu sync.Mutex
+	bw *bufio.Writer
+}
+
+func (w *writer) send(msg stratum.Message) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.bw.Write(data); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
+
+// ex2Hex renders an extranonce2 counter at the requested byte width
+func ex2Hex(v uint64, size int) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	if size < 8 {
+		b = b[8-size:]
+	} else if size > 8 {
+		b = append(make([]byte, size-8), b...)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,128 @@
+package canary
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// fakePool answers the canary handshake, hands out an easy job and answers
+// submits with accept
+func fakePool(t *testing.T, accept func() bool) (string, chan []interface{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	submits := make(chan []interface{}, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		send := func(v interface{}) {
+			b, _ := json.Marshal(v)
+			_, _ = w.Write(append(b, '\n'))
+			_ = w.Flush()
+		}
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			var msg stratum.Message
+			if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+				continue
+			}
+			switch msg.Method {
+			case stratum.MethodSubscribe:
+				send(stratum.NewSuccessResponse(msg.ID, []interface{}{nil, "aabbccdd", 4}))
+			case stratum.MethodAuthorize:
+				send(stratum.NewSuccessResponse(msg.ID, true))
+				send(stratum.NewSetDifficultyMessage(1e-12))
+				send(map[string]interface{}{"id": nil, "method": "mining.notify", "params": []interface{}{
+					"j1", fmt.Sprintf("%064x", 0), "01000000", "00000000", []interface{}{}, "20000000", "207fffff", "6553f100", true,
+				}})
+			case stratum.MethodSubmit:
+				submits <- msg.Params.([]interface{})
+				if accept() {
+					send(stratum.NewSuccessResponse(msg.ID, true))
+				} else {
+					send(stratum.NewErrorResponse(msg.ID, 23, "Low difficulty share", nil))
+				}
+			}
+		}
+	}()
+	return ln.Addr().String(), submits
+}
+
+func TestCanaryHealthyThenFailing(t *testing.T) {
+	accept := make(chan bool, 1)
+	accept <- true
+	ok := true
+	addr, submits := fakePool(t, func() bool {
+		select {
+		case ok = <-accept:
+		default:
+		}
+		return ok
+	})
+
+	c := New(&Config{Enabled: true, Addr: addr, Worker: "farm.canary", HashesPerSec: 100, SubmitIntervalMs: 1, FailThreshold: 2})
+	changes := make(chan bool, 4)
+	c.OnChange(func(healthy bool, reason string) { changes <- healthy })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case p := <-submits:
+		if p[0] != "farm.canary" || p[1] != "j1" || len(p[2].(string)) != 8 {
+			t.Errorf("unexpected submit params: %v", p)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("canary did not submit")
+	}
+	if healthy := waitChange(t, changes); !healthy {
+		t.Fatal("expected canary to report healthy first")
+	}
+
+	accept <- false
+	if healthy := waitChange(t, changes); healthy {
+		t.Fatal("expected canary to report failing after rejections")
+	}
+	if c.Healthy() {
+		t.Error("Healthy() should be false")
+	}
+	stats := c.GetStats()
+	if stats["state"] != StateFailing || stats["consecutive_failures"].(int) < 2 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func waitChange(t *testing.T, ch chan bool) bool {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for canary state change")
+		return false
+	}
+}
+
+func TestEx2Hex(t *testing.T) {
+	if got := ex2Hex(1, 4); got != "00000001" {
+		t.Errorf("ex2Hex(1,4) = %s", got)
+	}
+	if got := ex2Hex(0x0102, 2); got != "0102" {
+		t.Errorf("ex2Hex(0x0102,2) = %s", got)
+	}
+}
@@ -0,0 +1,263 @@
+// Package capture records raw Stratum lines with their direction for
+// debugging miner and pool incompatibilities without packet captures
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Directions of a captured line
+const (
+	FromClient   = "from_client"
+	ToClient     = "to_client"
+	FromUpstream = "from_upstream"
+	ToUpstream   = "to_upstream"
+)
+
+// Config holds traffic capture configuration. Capture also starts and stops
+// at runtime through the admin API.
+type Config struct {
+	Enabled bool   `json:"enabled"` // capture every client and the pool from startup
+	Size    int    `json:"size"`    // lines kept in memory; default 1000
+	File    string `json:"file"`    // also append lines as NDJSON; empty keeps memory only
+}
+
+// Validate checks the buffer size
+func (c *Config) Validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("size must not be negative")
+	}
+	return nil
+}
+
+// size returns the ring buffer capacity
+func (c *Config) size() int {
+	if c.Size <= 0 {
+		return 1000
+	}
+	return c.Size
+}
+
+// Entry is one captured line
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Dir    string    `json:"dir"`
+	Client string    `json:"client,omitempty"` // client address; empty for pool traffic
+	Worker string    `json:"worker,omitempty"`
+	Line   string    `json:"line"`
+}
+
+// Status describes what is being captured
+type Status struct {
+	All      bool     `json:"all"`
+	Targets  []string `json:"targets"`
+	Buffered int      `json:"buffered"`
+	Dropped  uint64   `json:"dropped"` // lines overwritten in the ring
+	File     string   `json:"file,omitempty"`
+}
+
+// Recorder keeps the captured lines in a ring buffer
+type Recorder struct {
+	active atomic.Bool // fast path: anything to capture at all
+
+	mu      sync.Mutex
+	cfg     *Config
+	all     bool
+	targets map[string]bool // client addresses, IPs or workers
+	ring    []Entry
+	next    int
+	full    bool
+	dropped uint64
+	f       *os.File
+	path    string
+}
+
+// New creates a recorder, capturing everything when cfg.Enabled
+func New(cfg *Config) *Recorder {
+	r := &Recorder{cfg: cfg, targets: make(map[string]bool), ring: make([]Entry, cfg.size())}
+	r.all = cfg.Enabled
+	r.refresh()
+	return r
+}
+
+// UpdateConfig applies a new configuration; a resized buffer starts empty
+func (r *Recorder) UpdateConfig(cfg *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cfg.size() != len(r.ring) {
+		r.ring, r.next, r.full = make([]Entry, cfg.size()), 0, false
+	}
+	if cfg.File != r.path {
+		r.closeLocked()
+	}
+	if cfg.Enabled != r.cfg.Enabled {
+		r.all = cfg.Enabled
+	}
+	r.cfg = cfg
+	r.refresh()
+}
+
+// refresh updates the fast path flag; callers hold mu or own r
+func (r *Recorder) refresh() {
+	r.active.Store(r.all || len(r.targets) > 0)
+}
+
+// Active reports whether any traffic is being captured
+func (r *Recorder) Active() bool {
+	return r.active.Load()
+}
+
+// SetAll starts or stops capturing every client and the pool
+func (r *Recorder) SetAll(on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.all = on
+	r.refresh()
+}
+
+// SetTarget starts or stops capturing one client, named by its address, IP
+// or worker
+func (r *Recorder) SetTarget(target string, on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if on {
+		r.targets[target] = true
+	} else {
+		delete(r.targets, target)
+	}
+	r.refresh()
+}
+
+// Wants reports whether traffic of the client at addr is captured. Pool
+// traffic, with an empty addr, is captured only when capturing everything.
+func (r *Recorder) Wants(addr, worker string) bool {
+	if !r.active.Load() {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.all {
+		return true
+	}
+	if addr == "" {
+		return false
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return r.targets[addr] || (host != "" && r.targets[host]) || (worker != "" && r.targets[worker])
+}
+
+// Record stores a line if its client is captured
+func (r *Recorder) Record(dir, addr, worker string, line []byte) {
+	if !r.Wants(addr, worker) {
+		return
+	}
+	e := Entry{
+		Time:   time.Now(),
+		Dir:    dir,
+		Client: addr,
+		Worker: worker,
+		Line:   strings.TrimRight(string(line), "\r\n"),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.full {
+		r.dropped++
+	}
+	r.ring[r.next] = e
+	r.next = (r.next + 1) % len(r.ring)
+	if r.next == 0 {
+		r.full = true
+	}
+	if r.cfg.File != "" {
+		r.writeLocked(e)
+	}
+}
+
+// writeLocked appends e to the capture file, opening it on first use
+func (r *Recorder) writeLocked(e Entry) {
+	if r.f == nil {
+		f, err := os.OpenFile(r.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return
+		}
+		r.f, r.path = f, r.cfg.File
+	}
+	b, _ := json.Marshal(e)
+	_, _ = r.f.Write(append(b, '\n'))
+}
+
+// closeLocked closes the capture file
+func (r *Recorder) closeLocked() {
+	if r.f != nil {
+		_ = r.f.Close()
+		r.f, r.path = nil, ""
+	}
+}
+
+// Entries returns up to limit of the newest captured lines, oldest first,
+// optionally only those of one client address, IP or worker
+func (r *Recorder) Entries(client string, limit int) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.ring)
+	}
+	out := make([]Entry, 0, n)
+	for i := 0; i < n; i++ {
+		e := r.ring[(r.next-n+i+len(r.ring))%len(r.ring)]
+		if client != "" && !e.matches(client) {
+			continue
+		}
+		out = append(out, e)
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// matches reports whether the entry belongs to the client address, IP or
+// worker
+func (e Entry) matches(client string) bool {
+	host, _, _ := net.SplitHostPort(e.Client)
+	return e.Client == client || host == client || e.Worker == client
+}
+
+// Clear drops the buffered lines
+func (r *Recorder) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ring = make([]Entry, len(r.ring))
+	r.next, r.full = 0, false
+}
+
+// GetStatus returns what is being captured
+func (r *Recorder) GetStatus() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := Status{All: r.all, Targets: []string{}, Buffered: r.next, Dropped: r.dropped, File: r.cfg.File}
+	if r.full {
+		st.Buffered = len(r.ring)
+	}
+	for t := range r.targets {
+		st.Targets = append(st.Targets, t)
+	}
+	sort.Strings(st.Targets)
+	return st
+}
+
+// Close closes the capture file
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked()
+}
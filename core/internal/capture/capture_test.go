@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	r := New(&Config{Size: 3, File: path})
+	defer r.Close()
+
+	r.Record(FromClient, "10.0.0.1:4000", "w1", []byte("ignored\n"))
+	if r.Active() || len(r.Entries("", 0)) != 0 {
+		t.Fatal("nothing should be captured until enabled")
+	}
+
+	// one client by IP: its lines, but neither other clients nor the pool
+	r.SetTarget("10.0.0.1", true)
+	r.Record(FromClient, "10.0.0.1:4000", "w1", []byte(`{"id":1,"method":"mining.subscribe"}`+"\n"))
+	r.Record(ToClient, "10.0.0.1:4000", "w1", []byte(`{"id":1,"result":[]}`+"\n"))
+	r.Record(FromClient, "10.0.0.2:4000", "w2", []byte("other\n"))
+	r.Record(FromUpstream, "", "", []byte("pool\n"))
+	got := r.Entries("", 0)
+	if len(got) != 2 || got[0].Dir != FromClient || got[1].Line != `{"id":1,"result":[]}` {
+		t.Fatalf("targeted capture = %+v", got)
+	}
+
+	// everything: the ring keeps the newest lines
+	r.SetTarget("10.0.0.1", false)
+	r.SetAll(true)
+	r.Record(ToUpstream, "", "", []byte("a\n"))
+	r.Record(FromUpstream, "", "", []byte("b\n"))
+	got = r.Entries("", 0)
+	if len(got) != 3 || got[0].Dir != ToClient || got[2].Line != "b" {
+		t.Fatalf("ring = %+v", got)
+	}
+	if st := r.GetStatus(); !st.All || st.Buffered != 3 || st.Dropped != 1 {
+		t.Errorf("status = %+v", st)
+	}
+	if got := r.Entries("w1", 0); len(got) != 1 {
+		t.Errorf("worker filter = %+v", got)
+	}
+	if got := r.Entries("", 1); len(got) != 1 || got[0].Line != "b" {
+		t.Errorf("limit = %+v", got)
+	}
+
+	// every captured line also went to the file
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("file line %d: %v", n, err)
+		}
+	}
+	if n != 4 {
+		t.Errorf("file has %d lines, want 4", n)
+	}
+
+	r.Clear()
+	if len(r.Entries("", 0)) != 0 {
+		t.Error("clear left entries")
+	}
+	if err := (&Config{Size: -1}).Validate(); err == nil {
+		t.Error("negative size accepted")
+	}
+}
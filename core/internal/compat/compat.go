@@ -0,0 +1,201 @@
+// Package compat adapts the messages of miner firmware that bends Stratum V1
+package compat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Profile lists the quirks tolerated for one kind of firmware
+type Profile struct {
+	// treat a request without params (or with null) as an empty array
+	MissingParams bool `json:"missing_params"`
+	// field names in the order the firmware sends mining.submit params
+	SubmitOrder []string `json:"submit_order"`
+	// lowercase the hex strings sent to the client
+	LowercaseHex bool `json:"lowercase_hex"`
+	// multiply the difficulties sent to the client, for firmware that
+	// expects a pool scale (e.g. 65536); 0 or 1 sends them unchanged
+	DifficultyScale float64 `json:"difficulty_scale"`
+}
+
+// Config holds the client compatibility profiles
+type Config struct {
+	// profile for clients of proxy.listen and listeners without their own
+	Default  string             `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Builtin profiles, usable by name without configuring them
+var Builtin = map[string]Profile{
+	"strict":  {},
+	"lenient": {MissingParams: true},
+}
+
+// submitFields maps submit param names to their standard position
+var submitFields = map[string]int{
+	"worker":       0,
+	"job_id":       1,
+	"extranonce2":  2,
+	"ntime":        3,
+	"nonce":        4,
+	"version_bits": 5,
+}
+
+// Lookup returns the named profile, configured ones first; "" is no profile
+func (c *Config) Lookup(name string) (*Profile, bool) {
+	if name == "" {
+		return nil, true
+	}
+	if p, ok := c.Profiles[name]; ok {
+		return &p, true
+	}
+	if p, ok := Builtin[name]; ok {
+		return &p, true
+	}
+	return nil, false
+}
+
+// Validate checks the submit field names and difficulty scale of the profile
+func (p *Profile) Validate() error {
+	if p.DifficultyScale < 0 {
+		return fmt.Errorf("difficulty_scale must not be negative")
+	}
+	seen := make(map[string]bool, len(p.SubmitOrder))
+	for _, name := range p.SubmitOrder {
+		if _, ok := submitFields[name]; !ok {
+			return fmt.Errorf("unknown submit field %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("submit field %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Session applies a profile to one client connection
+type Session struct {
+	p *Profile
+}
+
+// NewSession creates the state of one client using p; nil p gives nil
+func NewSession(p *Profile) *Session {
+	if p == nil {
+		return nil
+	}
+	return &Session{p: p}
+}
+
+// Decode parses a line from the client, repairing the quirks of the profile
+func (s *Session) Decode(line []byte) (stratum.Message, error) {
+	var msg stratum.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, err
+	}
+
+	if s.p.MissingParams && msg.Method != "" && msg.Params == nil {
+		msg.Params = []interface{}{}
+	}
+	if len(s.p.SubmitOrder) > 0 && msg.Method == stratum.MethodSubmit {
+		if arr, ok := msg.Params.([]interface{}); ok {
+			msg.Params = s.reorderSubmit(arr)
+		}
+	}
+	return msg, nil
+}
+
+// reorderSubmit moves the params into the standard submit order
+func (s *Session) reorderSubmit(arr []interface{}) []interface{} {
+	out := append([]interface{}(nil), arr...)
+	for i, name := range s.p.SubmitOrder {
+		if j := submitFields[name]; i < len(arr) && j < len(out) {
+			out[j] = arr[i]
+		}
+	}
+	return out
+}
+
+// RewritesOutput reports whether lines sent to the client need Rewrite
+func (s *Session) RewritesOutput() bool {
+	return s.p.LowercaseHex || s.scaled()
+}
+
+func (s *Session) scaled() bool {
+	return s.p.DifficultyScale > 0 && s.p.DifficultyScale != 1
+}
+
+// Rewrite lowercases the hex strings of a line sent to the client, except
+// the job ID of mining.notify, which the client must echo unchanged, and
+// scales the difficulty of mining.set_difficulty
+func (s *Session) Rewrite(line []byte) []byte {
+	hex := s.p.LowercaseHex && bytes.ContainsAny(line, "ABCDEF")
+	diff := s.scaled() && bytes.Contains(line, []byte(stratum.MethodSetDifficulty))
+	if !hex && !diff {
+		return line
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return line
+	}
+	params, _ := m["params"].([]interface{})
+	if diff && m["method"] == stratum.MethodSetDifficulty && len(params) > 0 {
+		if n, ok := params[0].(json.Number); ok {
+			if v, err := n.Float64(); err == nil {
+				params[0] = v * s.p.DifficultyScale
+			}
+		}
+	}
+	if hex {
+		from := 0
+		if m["method"] == stratum.MethodNotify {
+			from = 1
+		}
+		for i := from; i < len(params); i++ {
+			params[i] = lowerHex(params[i])
+		}
+		if res, ok := m["result"]; ok {
+			m["result"] = lowerHex(res)
+		}
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return line
+	}
+	return out
+}
+
+// lowerHex lowercases the hex strings in v, descending into arrays
+func lowerHex(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		if isHex(t) {
+			return strings.ToLower(t)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = lowerHex(t[i])
+		}
+	}
+	return v
+}
+
+// isHex reports whether s is a non-empty run of hex digits
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package compat
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMissingParams(t *testing.T) {
+	s := NewSession(&Profile{MissingParams: true})
+
+	msg, err := s.Decode([]byte(`{"id":"auth-1","method":"mining.authorize"}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if params, ok := msg.Params.([]interface{}); !ok || len(params) != 0 {
+		t.Errorf("missing params = %#v, want empty array", msg.Params)
+	}
+
+	msg, _ = s.Decode([]byte(`{"id":3,"method":"mining.authorize","params":null}`))
+	if params, ok := msg.Params.([]interface{}); !ok || len(params) != 0 {
+		t.Errorf("null params = %#v, want empty array", msg.Params)
+	}
+
+	// responses keep their missing params
+	msg, _ = s.Decode([]byte(`{"id":4,"result":true}`))
+	if msg.Params != nil {
+		t.Errorf("response params = %#v, want nil", msg.Params)
+	}
+}
+
+func TestSubmitOrder(t *testing.T) {
+	p := &Profile{SubmitOrder: []string{"worker", "job_id", "ntime", "nonce", "extranonce2"}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	msg, err := NewSession(p).Decode([]byte(`{"id":1,"method":"mining.submit","params":["w","j","T","N","E"]}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []interface{}{"w", "j", "E", "T", "N"}
+	if !reflect.DeepEqual(msg.Params, want) {
+		t.Errorf("params = %v, want %v", msg.Params, want)
+	}
+
+	if err := (&Profile{SubmitOrder: []string{"worker", "worker"}}).Validate(); err == nil {
+		t.Error("expected duplicate field to fail validation")
+	}
+	if err := (&Profile{SubmitOrder: []string{"time"}}).Validate(); err == nil {
+		t.Error("expected unknown field to fail validation")
+	}
+}
+
+func TestLowercaseHex(t *testing.T) {
+	s := NewSession(&Profile{LowercaseHex: true})
+	out := s.Rewrite([]byte(`{"method":"mining.notify","params":["AB12","00FF",["DEAD"],true,"Not hex"]}`))
+	var m map[string]interface{}
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatalf("rewritten line: %v", err)
+	}
+	want := []interface{}{"AB12", "00ff", []interface{}{"dead"}, true, "Not hex"}
+	if !reflect.DeepEqual(m["params"], want) {
+		t.Errorf("params = %v, want %v", m["params"], want)
+	}
+
+	line := []byte(`{"id":1,"result":true}`)
+	if got := s.Rewrite(line); string(got) != string(line) {
+		t.Errorf("line without hex rewritten to %s", got)
+	}
+}
+
+func TestDifficultyScale(t *testing.T) {
+	s := NewSession(&Profile{DifficultyScale: 65536})
+	if !s.RewritesOutput() {
+		t.Fatal("scaled profile should rewrite output")
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(s.Rewrite([]byte(`{"id":null,"method":"mining.set_difficulty","params":[2]}`)), &m); err != nil {
+		t.Fatal(err)
+	}
+	if p := m["params"].([]interface{}); p[0] != 131072.0 {
+		t.Errorf("scaled difficulty = %v, want 131072", p[0])
+	}
+	line := []byte(`{"method":"mining.notify","params":["AB12"]}`)
+	if got := s.Rewrite(line); string(got) != string(line) {
+		t.Errorf("notify rewritten to %s", got)
+	}
+	if NewSession(&Profile{DifficultyScale: 1}).RewritesOutput() {
+		t.Error("scale 1 should not rewrite output")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cfg := &Config{Profiles: map[string]Profile{"lenient": {LowercaseHex: true}}}
+	if p, ok := cfg.Lookup("lenient"); !ok || !p.LowercaseHex {
+		t.Error("configured profile should override the builtin of the same name")
+	}
+	if p, ok := cfg.Lookup(""); !ok || p != nil {
+		t.Error("empty name should mean no profile")
+	}
+	if _, ok := cfg.Lookup("strict"); !ok {
+		t.Error("builtin profile not found")
+	}
+	if _, ok := cfg.Lookup("nope"); ok {
+		t.Error("unknown profile found")
+	}
+}
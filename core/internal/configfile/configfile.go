@@ -0,0 +1,127 @@
+// Package configfile reads configuration files written in JSON, YAML or
+// TOML and expands ${VAR} environment references inside their values
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported formats
+const (
+	JSON = "json"
+	YAML = "yaml"
+	TOML = "toml"
+)
+
+// FormatFor picks the format from the file extension, defaulting to JSON
+func FormatFor(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	default:
+		return JSON
+	}
+}
+
+// ToJSON converts a config file in the given format to JSON so it can be
+// decoded into the config structs, expanding environment references
+func ToJSON(data []byte, format string) ([]byte, error) {
+	var v interface{}
+	var err error
+	switch format {
+	case JSON:
+		return expandJSON(string(data))
+	case YAML:
+		v, err = parseYAML(string(data))
+	case TOML:
+		v, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ExpandEnv replaces ${VAR} and ${VAR:-default} with the environment value.
+// Referencing an unset variable without a default is an error, so a missing
+// secret does not silently become an empty string.
+func ExpandEnv(s string) (string, error) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && (v != "" || m[2] == "") {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		missing = append(missing, m[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// expandJSON expands references in JSON text, escaping each value so it
+// stays valid both inside strings and as a bare number or boolean
+func expandJSON(text string) ([]byte, error) {
+	var firstErr error
+	out := envRef.ReplaceAllStringFunc(text, func(ref string) string {
+		v, err := ExpandEnv(ref)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ""
+		}
+		b, _ := json.Marshal(v)
+		return string(b[1 : len(b)-1])
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return []byte(out), nil
+}
+
+var numberRe = regexp.MustCompile(`^[-+]?(\d[\d_]*)(\.\d[\d_]*)?([eE][-+]?\d+)?$`)
+
+// plainScalar resolves an unquoted value to null, a boolean, a number or a
+// string after expanding environment references
+func plainScalar(raw string) (interface{}, error) {
+	s, err := ExpandEnv(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch s {
+	case "", "~", "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if numberRe.MatchString(s) {
+		clean := strings.ReplaceAll(s, "_", "")
+		if n, err := strconv.ParseInt(clean, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(clean, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
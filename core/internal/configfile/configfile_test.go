@@ -0,0 +1,285 @@
+package configfile
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleJSON = `{
+  "proxy": {"listen": "0.0.0.0:3333", "max_clients": 500, "tls": {"enabled": false}},
+  "upstream": {"host": "pool.example.com", "port": 3333, "user": "wallet.${RIG}", "pass": "s3cr#t"},
+  "backups": [
+    {"host": "backup1.example.com", "port": 4444, "socks_proxy": {"enabled": true, "type": "socks5"}},
+    {"host": "backup2.example.com", "port": 5555}
+  ],
+  "vardiff": {"enabled": true, "min_diff": 1024, "target_seconds": 1.5},
+  "events": {"webhooks": [{"url": "https://hooks.example.com/x", "events": ["upstream_down", "ban_issued"]}]},
+  "ratelimit": {"bans": [{"ip": "10.0.0.0/8", "until": "2026-01-02T03:04:05Z"}]},
+  "admin": {"token": null},
+  "notes": "it's 'quoted'"
+}`
+
+const sampleYAML = `# karoo config
+---
+proxy:
+  listen: "0.0.0.0:3333"
+  max_clients: 500   # per instance
+  tls:
+    enabled: false
+upstream:
+  host: pool.example.com
+  port: 3333
+  user: wallet.${RIG}
+  pass: 's3cr#t'
+backups:
+  - host: backup1.example.com
+    port: 4444
+    socks_proxy:
+      enabled: true
+      type: socks5
+  -
+    host: backup2.example.com
+    port: 5555
+vardiff: {enabled: true, min_diff: 1024, target_seconds: 1.5}
+events:
+  webhooks:
+  - url: https://hooks.example.com/x
+    events: [upstream_down, "ban_issued"]
+ratelimit:
+  bans:
+    - ip: 10.0.0.0/8
+      until: "2026-01-02T03:04:05Z"
+admin:
+  token:
+notes: it's 'quoted'
+`
+
+const sampleTOML = `# karoo config
+notes = "it's 'quoted'"
+
+[proxy]
+listen = "0.0.0.0:3333"
+max_clients = 500 # per instance
+tls = { enabled = false }
+
+[upstream]
+host = "pool.example.com"
+port = 3_333
+user = "wallet.${RIG}"
+pass = 's3cr#t'
+
+[[backups]]
+host = "backup1.example.com"
+port = 4444
+
+[backups.socks_proxy]
+enabled = true
+type = "socks5"
+
+[[backups]]
+host = "backup2.example.com"
+port = 5555
+
+[vardiff]
+enabled = true
+min_diff = 1024
+target_seconds = 1.5
+
+[[events.webhooks]]
+url = "https://hooks.example.com/x"
+events = [
+  "upstream_down",
+  "ban_issued", # trailing comma
+]
+
+[ratelimit]
+bans = [{ ip = "10.0.0.0/8", until = 2026-01-02T03:04:05Z }]
+
+[admin]
+`
+
+func decode(t *testing.T, data []byte, format string) interface{} {
+	t.Helper()
+	out, err := ToJSON(data, format)
+	if err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(out, &v); err != nil {
+		t.Fatalf("%s produced invalid JSON: %v\n%s", format, err, out)
+	}
+	return v
+}
+
+func TestFormatsAgree(t *testing.T) {
+	t.Setenv("RIG", "farm1")
+	want := decode(t, []byte(sampleJSON), JSON)
+	if got := want.(map[string]interface{})["upstream"].(map[string]interface{})["user"]; got != "wallet.farm1" {
+		t.Fatalf("JSON env expansion: user = %v", got)
+	}
+
+	if got := decode(t, []byte(sampleYAML), YAML); !reflect.DeepEqual(got, want) {
+		t.Errorf("YAML mismatch:\n got %v\nwant %v", got, want)
+	}
+
+	// TOML has no null; the empty [admin] table stands in for it
+	want.(map[string]interface{})["admin"] = map[string]interface{}{}
+	if got := decode(t, []byte(sampleTOML), TOML); !reflect.DeepEqual(got, want) {
+		t.Errorf("TOML mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("POOL_PASS", `p"a\ss`)
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"${POOL_PASS}", `p"a\ss`, false},
+		{"x-${MISSING:-fallback}-y", "x-fallback-y", false},
+		{"${EMPTY:-fallback}", "fallback", false},
+		{"${EMPTY}", "", false},
+		{"$POOL_PASS and $$", "$POOL_PASS and $$", false},
+		{"${MISSING}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandEnv(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ExpandEnv(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+
+	// Values are escaped inside JSON strings and typed when bare
+	t.Setenv("POOL_PORT", "4444")
+	v := decode(t, []byte(`{"pass": "${POOL_PASS}", "port": ${POOL_PORT}}`), JSON).(map[string]interface{})
+	if v["pass"] != `p"a\ss` || v["port"] != 4444.0 {
+		t.Errorf("JSON expansion = %v", v)
+	}
+	v = decode(t, []byte("port: ${POOL_PORT}\nquoted: \"${POOL_PORT}\"\nliteral: '${POOL_PORT}'\n"), YAML).(map[string]interface{})
+	if v["port"] != 4444.0 || v["quoted"] != "4444" || v["literal"] != "${POOL_PORT}" {
+		t.Errorf("YAML expansion = %v", v)
+	}
+	v = decode(t, []byte("port = ${POOL_PORT}\n"), TOML).(map[string]interface{})
+	if v["port"] != 4444.0 {
+		t.Errorf("TOML expansion = %v", v)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		format, src, want string
+	}{
+		{YAML, "a: 1\n   b: 2\n", "line 2"},
+		{YAML, "a: 1\na: 2\n", "duplicate key"},
+		{YAML, "a: |\n  text\n", "multi-line"},
+		{YAML, "a: &anchor 1\n", "anchors"},
+		{YAML, "a: [1, 2\n", "unterminated"},
+		{YAML, "a: ${NOT_SET_ANYWHERE}\n", "NOT_SET_ANYWHERE"},
+		{TOML, "a = 1\na = 2\n", "duplicate key"},
+		{TOML, "a = bare\n", "invalid value"},
+		{TOML, "[a\n", "unterminated"},
+		{TOML, "a = \"\"\"x\"\"\"\n", "multi-line"},
+		{TOML, "a = 1\n[a.b]\n", "not a table"},
+	}
+	for _, tt := range tests {
+		_, err := ToJSON([]byte(tt.src), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %q: error %v, want %q", tt.format, tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	cases := map[string]string{
+		"config.json": JSON,
+		"karoo.YAML":  YAML,
+		"karoo.yml":   YAML,
+		"karoo.toml":  TOML,
+		"config":      JSON,
+	}
+	for path, want := range cases {
+		if got := FormatFor(path); got != want {
+			t.Errorf("FormatFor(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+type testConfig struct {
+	Upstream struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+		TLS  bool   `json:"tls"`
+	} `json:"upstream"`
+	VarDiff struct {
+		Ratio float64 `json:"ratio"`
+	} `json:"vardiff"`
+	Backups []struct {
+		Host string `json:"host"`
+	} `json:"backups"`
+	Hidden   string `json:"-"`
+	internal int
+}
+
+func TestFields(t *testing.T) {
+	var paths []string
+	for _, f := range Fields(testConfig{}) {
+		paths = append(paths, f.Path+"="+f.Env())
+	}
+	want := []string{
+		"backups=KAROO_BACKUPS",
+		"upstream.host=KAROO_UPSTREAM_HOST",
+		"upstream.port=KAROO_UPSTREAM_PORT",
+		"upstream.tls=KAROO_UPSTREAM_TLS",
+		"vardiff.ratio=KAROO_VARDIFF_RATIO",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Fields = %v, want %v", paths, want)
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	fields := Fields(testConfig{})
+	env := map[string]string{
+		"KAROO_UPSTREAM_HOST": "env.example.com",
+		"KAROO_UPSTREAM_PORT": "4444",
+		"KAROO_BACKUPS":       `[{"host": "b1"}]`,
+		"OTHER":               "ignored",
+	}
+	overrides := EnvOverrides(fields, func(k string) (string, bool) { v, ok := env[k]; return v, ok })
+	overrides["upstream.host"] = "flag.example.com" // flags are applied over env
+	overrides["upstream.tls"] = "true"
+
+	doc, err := Apply([]byte(`{"upstream": {"host": "file.example.com", "port": 3333}, "vardiff": {"ratio": 2}}`), fields, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg testConfig
+	if err := json.Unmarshal(doc, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Upstream.Host != "flag.example.com" || cfg.Upstream.Port != 4444 || !cfg.Upstream.TLS ||
+		cfg.VarDiff.Ratio != 2 || len(cfg.Backups) != 1 || cfg.Backups[0].Host != "b1" {
+		t.Errorf("overridden config = %+v", cfg)
+	}
+
+	// Overrides work without a file
+	if doc, err = Apply(nil, fields, map[string]string{"vardiff.ratio": "1.5"}); err != nil || string(doc) != `{"vardiff":{"ratio":1.5}}` {
+		t.Errorf("Apply without file = %s, %v", doc, err)
+	}
+
+	bad := []map[string]string{
+		{"upstream.port": "many"},
+		{"upstream.tls": "perhaps"},
+		{"backups": "not json"},
+		{"upstream.nope": "x"},
+	}
+	for _, o := range bad {
+		if _, err := Apply(nil, fields, o); err == nil {
+			t.Errorf("Apply(%v) should fail", o)
+		}
+	}
+}
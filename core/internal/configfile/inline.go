@@ -0,0 +1,230 @@
+package configfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// inlineParser reads the single-line collections shared by YAML flow style
+// ([a, b], {k: v}) and TOML arrays and inline tables ([a, b], {k = v})
+type inlineParser struct {
+	s   string
+	i   int
+	sep byte // key/value separator inside {}
+	// plain resolves an unquoted token
+	plain func(string) (interface{}, error)
+}
+
+// parseInline parses s as one complete value
+func parseInline(s string, sep byte, plain func(string) (interface{}, error)) (interface{}, error) {
+	p := &inlineParser{s: s, sep: sep, plain: plain}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skip()
+	if p.i < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q after value", p.s[p.i:])
+	}
+	return v, nil
+}
+
+func (p *inlineParser) skip() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *inlineParser) value() (interface{}, error) {
+	p.skip()
+	if p.i >= len(p.s) {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch p.s[p.i] {
+	case '[':
+		return p.list()
+	case '{':
+		return p.table()
+	case '"', '\'':
+		return p.quoted()
+	default:
+		start := p.i
+		for p.i < len(p.s) && !strings.ContainsRune(",]}", rune(p.s[p.i])) {
+			// ${VAR} references may sit in unquoted values
+			if strings.HasPrefix(p.s[p.i:], "${") {
+				if end := strings.IndexByte(p.s[p.i:], '}'); end > 0 {
+					p.i += end
+				}
+			}
+			p.i++
+		}
+		return p.plain(strings.TrimSpace(p.s[start:p.i]))
+	}
+}
+
+func (p *inlineParser) list() ([]interface{}, error) {
+	p.i++ // [
+	out := []interface{}{}
+	for {
+		p.skip()
+		if p.i < len(p.s) && p.s[p.i] == ']' {
+			p.i++
+			return out, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+		p.skip()
+		if p.i >= len(p.s) {
+			return nil, fmt.Errorf("unterminated list")
+		}
+		switch p.s[p.i] {
+		case ',':
+			p.i++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] in list")
+		}
+	}
+}
+
+func (p *inlineParser) table() (map[string]interface{}, error) {
+	p.i++ // {
+	out := map[string]interface{}{}
+	for {
+		p.skip()
+		if p.i < len(p.s) && p.s[p.i] == '}' {
+			p.i++
+			return out, nil
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		out[key] = v
+		p.skip()
+		if p.i >= len(p.s) {
+			return nil, fmt.Errorf("unterminated table")
+		}
+		switch p.s[p.i] {
+		case ',':
+			p.i++
+		case '}':
+		default:
+			return nil, fmt.Errorf("expected , or } in table")
+		}
+	}
+}
+
+// key reads a table key up to and including the separator
+func (p *inlineParser) key() (string, error) {
+	var key string
+	if c := p.s[p.i]; c == '"' || c == '\'' {
+		end, err := quotedEnd(p.s, p.i)
+		if err != nil {
+			return "", err
+		}
+		if key, err = unquote(p.s[p.i : end+1]); err != nil {
+			return "", err
+		}
+		p.i = end + 1
+		p.skip()
+	} else {
+		start := p.i
+		for p.i < len(p.s) && p.s[p.i] != p.sep && !strings.ContainsRune(",}", rune(p.s[p.i])) {
+			p.i++
+		}
+		key = strings.TrimSpace(p.s[start:p.i])
+	}
+	if p.i >= len(p.s) || p.s[p.i] != p.sep || key == "" {
+		return "", fmt.Errorf("expected key %c value in table", p.sep)
+	}
+	p.i++
+	return key, nil
+}
+
+func (p *inlineParser) quoted() (interface{}, error) {
+	end, err := quotedEnd(p.s, p.i)
+	if err != nil {
+		return nil, err
+	}
+	raw := p.s[p.i : end+1]
+	p.i = end + 1
+	return quotedValue(raw)
+}
+
+// quotedEnd returns the index of the quote closing the string at s[start]
+func quotedEnd(s string, start int) (int, error) {
+	q := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q:
+			// YAML escapes ' as ''; TOML literal strings cannot contain '
+			if q == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// unquote removes the quotes of a double- or single-quoted string
+func unquote(raw string) (string, error) {
+	if raw[0] == '\'' {
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", raw)
+	}
+	return s, nil
+}
+
+// quotedValue unquotes a string value; double-quoted strings expand
+// environment references, single-quoted ones are taken literally
+func quotedValue(raw string) (interface{}, error) {
+	s, err := unquote(raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw[0] == '\'' {
+		return s, nil
+	}
+	return ExpandEnv(s)
+}
+
+// stripComment removes a # comment that starts outside quotes. Quotes only
+// count at the start of a value, so apostrophes in plain text are ignored.
+func stripComment(line string) string {
+	var q byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case q == '"' && c == '\\':
+			i++
+		case q != 0:
+			if c == q {
+				q = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t:[{,=", line[i-1]) >= 0):
+			q = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
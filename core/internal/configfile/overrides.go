@@ -0,0 +1,174 @@
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts every environment override
+const EnvPrefix = "KAROO_"
+
+// Field is one config key that can be overridden
+type Field struct {
+	Path string // dotted JSON path, e.g. "upstream.host"
+	Kind reflect.Kind
+}
+
+// Env returns the environment variable overriding the field
+func (f Field) Env() string {
+	return EnvName(f.Path)
+}
+
+// EnvName maps a dotted path to its variable, e.g. KAROO_UPSTREAM_HOST
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// Fields lists the overridable keys of a config struct. Nested structs are
+// expanded; lists and maps are single keys that take a JSON value.
+func Fields(cfg interface{}) []Field {
+	var out []Field
+	walkFields(reflect.TypeOf(cfg), "", &out)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func walkFields(t reflect.Type, prefix string, out *[]Field) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !sf.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		path := prefix + name
+		if sf.Type.Kind() == reflect.Struct {
+			walkFields(sf.Type, path+".", out)
+			continue
+		}
+		*out = append(*out, Field{Path: path, Kind: sf.Type.Kind()})
+	}
+}
+
+// value converts an override string to the JSON value of the field's kind
+func (f Field) value(s string) (interface{}, error) {
+	switch f.Kind {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	default:
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("expected a JSON value: %w", err)
+		}
+		return v, nil
+	}
+}
+
+// EnvOverrides collects the KAROO_* variables set for the given fields
+func EnvOverrides(fields []Field, lookup func(string) (string, bool)) map[string]string {
+	out := make(map[string]string)
+	for _, f := range fields {
+		if v, ok := lookup(f.Env()); ok {
+			out[f.Path] = v
+		}
+	}
+	return out
+}
+
+// Apply sets overrides (dotted path to raw value) on a JSON config document
+// and returns the updated document
+func Apply(doc []byte, fields []Field, overrides map[string]string) ([]byte, error) {
+	if len(overrides) == 0 {
+		return doc, nil
+	}
+	byPath := make(map[string]Field, len(fields))
+	for _, f := range fields {
+		byPath[f.Path] = f
+	}
+	root := map[string]interface{}{}
+	if len(strings.TrimSpace(string(doc))) > 0 {
+		if err := json.Unmarshal(doc, &root); err != nil {
+			return nil, err
+		}
+	}
+	paths := make([]string, 0, len(overrides))
+	for p := range overrides {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f, ok := byPath[path]
+		if !ok {
+			return nil, fmt.Errorf("unknown config key %q", path)
+		}
+		v, err := f.value(overrides[path])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys := strings.Split(path, ".")
+		m := root
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := m[k].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[k] = next
+			}
+			m = next
+		}
+		m[keys[len(keys)-1]] = v
+	}
+	return json.Marshal(root)
+}
+
+// secretKeys are the config keys whose values Redact hides
+var secretKeys = map[string]bool{
+	"pass": true, "password": true, "rpc_pass": true, "token": true, "secret": true,
+}
+
+// Redact returns the config as a generic JSON value with every non-empty
+// secret replaced by "***", for printing or serving the effective config
+func Redact(cfg interface{}) (interface{}, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	redact(v)
+	return v, nil
+}
+
+func redact(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if s, ok := val.(string); ok && s != "" && secretKeys[k] {
+				t[k] = "***"
+				continue
+			}
+			redact(val)
+		}
+	case []interface{}:
+		for _, val := range t {
+			redact(val)
+		}
+	}
+}
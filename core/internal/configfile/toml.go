@@ -0,0 +1,198 @@
+package configfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The TOML reader covers tables, arrays of tables, dotted keys, strings,
+// numbers, booleans, arrays (which may span lines) and inline tables.
+// Dates are kept as strings and multi-line strings are rejected. As an
+// extension, an unquoted ${VAR} value is expanded and typed like YAML.
+
+var dateRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?$`)
+
+// parseTOML parses a TOML document into maps, slices and scalars
+func parseTOML(src string) (interface{}, error) {
+	root := map[string]interface{}{}
+	cur := root
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		num := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("toml line %d: %s", num, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			array := strings.HasPrefix(line, "[[")
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasSuffix(line, closing) {
+				return nil, errorf("unterminated table header")
+			}
+			path, err := keyPath(line[len(closing) : len(line)-len(closing)])
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			parent, err := tomlTable(root, path[:len(path)-1])
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			last := path[len(path)-1]
+			if array {
+				list, ok := parent[last].([]interface{})
+				if _, exists := parent[last]; exists && !ok {
+					return nil, errorf("%s is not an array of tables", last)
+				}
+				cur = map[string]interface{}{}
+				parent[last] = append(list, cur)
+				continue
+			}
+			if cur, err = tomlTable(parent, []string{last}); err != nil {
+				return nil, errorf("%v", err)
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, errorf("expected key = value")
+		}
+		path, err := keyPath(line[:eq])
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		rest := strings.TrimSpace(line[eq+1:])
+		if strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, "'''") {
+			return nil, errorf("multi-line strings are not supported")
+		}
+		// Arrays and inline tables may continue on the following lines
+		for depth(rest) > 0 && i+1 < len(lines) {
+			i++
+			rest += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		v, err := parseInline(rest, '=', tomlPlain)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		t, err := tomlTable(cur, path[:len(path)-1])
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		last := path[len(path)-1]
+		if _, dup := t[last]; dup {
+			return nil, errorf("duplicate key %q", last)
+		}
+		t[last] = v
+	}
+	return root, nil
+}
+
+// tomlTable walks path from m, creating tables as needed. A path element
+// naming an array of tables continues into its last table.
+func tomlTable(m map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for _, k := range path {
+		switch v := m[k].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			m[k] = next
+			m = next
+		case map[string]interface{}:
+			m = v
+		case []interface{}:
+			if len(v) == 0 {
+				return nil, fmt.Errorf("%s is not a table", k)
+			}
+			last, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", k)
+			}
+			m = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", k)
+		}
+	}
+	return m, nil
+}
+
+// keyPath splits a dotted key, unquoting quoted parts
+func keyPath(s string) ([]string, error) {
+	var path []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		var part string
+		if s[0] == '"' || s[0] == '\'' {
+			end, err := quotedEnd(s, 0)
+			if err != nil {
+				return nil, err
+			}
+			if part, err = unquote(s[:end+1]); err != nil {
+				return nil, err
+			}
+			s = strings.TrimSpace(s[end+1:])
+		} else {
+			dot := strings.IndexByte(s, '.')
+			if dot < 0 {
+				dot = len(s)
+			}
+			part = strings.TrimSpace(s[:dot])
+			s = s[dot:]
+			if part == "" || strings.ContainsAny(part, " \t\"'[]") {
+				return nil, fmt.Errorf("invalid key %q", part)
+			}
+		}
+		path = append(path, part)
+		if s != "" {
+			if s[0] != '.' {
+				return nil, fmt.Errorf("invalid key")
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return path, nil
+}
+
+// depth returns how many brackets and braces are still open in s
+func depth(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			end, err := quotedEnd(s, i)
+			if err != nil {
+				return n
+			}
+			i = end
+		case '[', '{':
+			n++
+		case ']', '}':
+			n--
+		}
+	}
+	return n
+}
+
+// tomlPlain resolves an unquoted TOML value
+func tomlPlain(s string) (interface{}, error) {
+	switch {
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case numberRe.MatchString(s), strings.HasPrefix(s, "${"):
+		return plainScalar(s)
+	case dateRe.MatchString(s):
+		return s, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", s)
+}
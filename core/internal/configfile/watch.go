@@ -0,0 +1,79 @@
+package configfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"time"
+)
+
+// WatchConfig enables reloading the config file when its content changes
+type WatchConfig struct {
+	Enabled    bool `json:"enabled"`
+	IntervalMs int  `json:"interval_ms"` // how often the file is read; default 2000
+	DebounceMs int  `json:"debounce_ms"` // how long a change must settle; default 1000
+}
+
+// Validate checks the polling and debounce times
+func (c WatchConfig) Validate() error {
+	if c.IntervalMs < 0 || c.DebounceMs < 0 {
+		return errors.New("interval_ms and debounce_ms must not be negative")
+	}
+	return nil
+}
+
+func (c WatchConfig) interval() time.Duration {
+	if c.IntervalMs <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.IntervalMs) * time.Millisecond
+}
+
+func (c WatchConfig) debounce() time.Duration {
+	if c.DebounceMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.DebounceMs) * time.Millisecond
+}
+
+// Watch reads path every interval and calls changed once its content differs
+// from the version last seen and has stayed the same for the debounce time,
+// so editors and ConfigMap updates that write in steps trigger one reload.
+// The file is read rather than watched for events, which also follows the
+// symlink swaps used by mounted ConfigMaps. It returns when ctx is done.
+func Watch(ctx context.Context, path string, cfg WatchConfig, changed func()) {
+	hash := func() []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	applied := hash()
+	var pending []byte
+	var since time.Time
+
+	ticker := time.NewTicker(cfg.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h := hash()
+			switch {
+			case h == nil || bytes.Equal(h, applied):
+				// missing while being replaced, or unchanged
+				pending = nil
+			case !bytes.Equal(h, pending):
+				pending, since = h, now
+			case now.Sub(since) >= cfg.debounce():
+				applied, pending = h, nil
+				changed()
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package configfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"a":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go Watch(ctx, path, WatchConfig{Enabled: true, IntervalMs: 10, DebounceMs: 50}, func() { changes <- struct{}{} })
+
+	expect := func(n int) {
+		t.Helper()
+		time.Sleep(200 * time.Millisecond)
+		if got := len(changes); got != n {
+			t.Fatalf("changes = %d, want %d", got, n)
+		}
+		for len(changes) > 0 {
+			<-changes
+		}
+	}
+	expect(0)
+
+	// several quick writes settle into one change
+	for i := 0; i < 3; i++ {
+		_ = os.WriteFile(path, []byte(`{"a":`+string(rune('2'+i))+`}`), 0o600)
+		time.Sleep(15 * time.Millisecond)
+	}
+	expect(1)
+
+	// a ConfigMap style symlink swap is followed; rewriting the same
+	// content is not a change
+	target := filepath.Join(filepath.Dir(path), "v2.json")
+	_ = os.WriteFile(target, []byte(`{"a":9}`), 0o600)
+	_ = os.Remove(path)
+	_ = os.Symlink(target, path)
+	expect(1)
+	_ = os.WriteFile(target, []byte(`{"a":9}`), 0o600)
+	expect(0)
+
+	if (WatchConfig{IntervalMs: -1}).Validate() == nil {
+		t.Error("negative interval accepted")
+	}
+}
@@ -0,0 +1,206 @@
+package configfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The YAML reader covers what config files need: block mappings and
+// sequences, plain, single- and double-quoted scalars, flow collections on
+// one line and comments. Anchors, tags and multi-line scalars are rejected.
+
+type yamlLine struct {
+	num    int // 1-based, for errors
+	indent int
+	text   string // without indentation or comment
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses a YAML document into maps, slices and scalars
+func parseYAML(src string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		text := strings.TrimRight(stripComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if trimmed == "..." {
+			break
+		}
+		if trimmed[0] == '\t' {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf(p.lines[p.pos], "unexpected indentation")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(l yamlLine, format string, args ...interface{}) error {
+	return fmt.Errorf("yaml line %d: %s", l.num, fmt.Sprintf(format, args...))
+}
+
+// isSeqItem reports whether a line starts a block sequence entry
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// block parses the node starting at the current line
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	if isSeqItem(l.text) {
+		return p.seq(indent)
+	}
+	if _, _, ok, err := splitKey(l.text); err != nil {
+		return nil, p.errorf(l, "%v", err)
+	} else if ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return p.scalar(l, l.text)
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && isSeqItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf(l, "unexpected indentation")
+		}
+		key, rest, ok, err := splitKey(l.text)
+		if err != nil {
+			return nil, p.errorf(l, "%v", err)
+		}
+		if !ok {
+			return nil, p.errorf(l, "expected key: value")
+		}
+		if _, dup := out[key]; dup {
+			return nil, p.errorf(l, "duplicate key %q", key)
+		}
+		p.pos++
+		if rest != "" {
+			if out[key], err = p.scalar(l, rest); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out[key] = nil
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || (next.indent == indent && isSeqItem(next.text)) {
+				if out[key], err = p.block(next.indent); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func (p *yamlParser) seq(indent int) ([]interface{}, error) {
+	out := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || !isSeqItem(l.text) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf(l, "unexpected indentation")
+		}
+		content := strings.TrimLeft(l.text[1:], " ")
+		if content == "" {
+			p.pos++
+			var v interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.block(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, v)
+			continue
+		}
+		// Read the entry's inline content as a block at its own column, so
+		// "- key: v" continues with keys aligned under "key"
+		col := indent + len(l.text) - len(content)
+		p.lines[p.pos] = yamlLine{num: l.num, indent: col, text: content}
+		v, err := p.block(col)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// scalar parses a value written on the same line as its key or dash
+func (p *yamlParser) scalar(l yamlLine, s string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch s[0] {
+	case '"', '\'':
+		var end int
+		if end, err = quotedEnd(s, 0); err == nil {
+			if end != len(s)-1 {
+				err = fmt.Errorf("unexpected %q after string", s[end+1:])
+			} else {
+				v, err = quotedValue(s)
+			}
+		}
+	case '[', '{':
+		v, err = parseInline(s, ':', plainScalar)
+	case '|', '>':
+		err = fmt.Errorf("multi-line scalars are not supported")
+	case '&', '*', '!':
+		err = fmt.Errorf("anchors, aliases and tags are not supported")
+	default:
+		v, err = plainScalar(s)
+	}
+	if err != nil {
+		return nil, p.errorf(l, "%v", err)
+	}
+	return v, nil
+}
+
+// splitKey splits "key: value" into its parts; ok is false when the line is
+// not a mapping entry
+func splitKey(text string) (key, rest string, ok bool, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		end, err := quotedEnd(text, 0)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := strings.TrimLeft(text[end+1:], " ")
+		if !strings.HasPrefix(after, ":") || (len(after) > 1 && after[1] != ' ') {
+			return "", "", false, nil
+		}
+		key, err = unquote(text[:end+1])
+		return key, strings.TrimSpace(after[1:]), err == nil, err
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true, nil
+		}
+	}
+	return "", "", false, nil
+}
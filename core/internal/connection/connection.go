@@ -10,10 +10,12 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/carlosrabelo/karoo/core/internal/proxysocks"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/tunnel"
 )
 
 // Config holds proxy configuration (subset needed for connection)
@@ -31,6 +33,22 @@ type Config struct {
 		InsecureSkipVerify bool              `json:"insecure_skip_verify"`
 		SocksProxy         proxysocks.Config `json:"socks_proxy"`
 	} `json:"upstream"`
+	// UserAgent is announced in mining.subscribe; empty sends no agent
+	UserAgent string `json:"user_agent"`
+	// Writer bounds the queue feeding the upstream connection
+	Writer WriterConfig `json:"writer"`
+	// DNS controls resolution of the pool hostname
+	DNS DNSConfig `json:"dns"`
+	// Dial selects how resolved addresses are tried
+	Dial DialConfig `json:"dial"`
+	// TLSOptions adds a private CA, pins and a client certificate
+	TLSOptions TLSOptions `json:"tls_options"`
+	// Keepalive detects a dead pool connection
+	Keepalive KeepaliveConfig `json:"keepalive"`
+	// Tunnel compresses the stream to another karoo's tunnel listener
+	Tunnel tunnel.Config `json:"tunnel"`
+	// Subscribe overrides the mining.subscribe parameters
+	Subscribe SubscribeConfig `json:"subscribe"`
 }
 
 // Client represents a mining client interface for connection package
@@ -49,27 +67,53 @@ type Upstream struct {
 	br   *bufio.Reader
 	bw   *bufio.Writer
 
+	// write queue of the current connection, drained by writeLoop
+	wq      chan []byte
+	wdone   chan struct{}
+	onFlush func(FlushStats)
+	onWrite func(line []byte)
+	stats   writerStats
+
 	// SOCKS proxy dialer
 	proxyDialer *proxysocks.ProxyDialer
 
-	// extranonce
-	ex1     string
-	ex2Size int
+	// resolved addresses of the pool hostname for direct dials
+	dns *resolver
+
+	// extranonce assignment of the pool, replaced as a whole
+	ex atomic.Pointer[Extranonce]
+
+	// gen counts connections; each one gets a fresh request ID space so a
+	// request numbered for a dead connection never reaches its successor
+	gen uint64
 
-	// req id para upstream
-	reqID int64
+	// response routing: upID -> client, for connection pendingGen
+	respMu     sync.Mutex
+	pendingGen uint64
+	reqID      int64
+	pending    map[int64]PendingReq
 
-	// response routing: upID -> client
-	respMu  sync.Mutex
-	pending map[int64]PendingReq
+	// request IDs of this connection's handshake
+	subID  int64
+	authID int64
+
+	// extranonce1 last given by each pool (host:port), resumed as the
+	// session ID
+	sessions map[string]string
+
+	// outstanding keepalive request, and when a line was last queued
+	pingID   int64
+	pingSent time.Time
+	lastSend atomic.Int64
 }
 
 // PendingReq represents a pending upstream request
 type PendingReq struct {
 	Client interface{} // Will be routing.Client
 	Method string
+	Params interface{}
 	Sent   time.Time
-	OrigID *int64
+	OrigID *stratum.ID
 }
 
 // Downstream represents a downstream mining client connection
@@ -90,7 +134,9 @@ func NewUpstream(cfg *Config) (*Upstream, error) {
 	return &Upstream{
 		cfg:         cfg,
 		proxyDialer: proxyDialer,
+		dns:         newResolver(cfg.DNS),
 		pending:     make(map[int64]PendingReq),
+		sessions:    make(map[string]string),
 	}, nil
 }
 
@@ -110,49 +156,89 @@ func (u *Upstream) Dial(ctx context.Context) error {
 	var c net.Conn
 	var err error
 
-	if u.proxyDialer.IsEnabled() {
+	u.mu.Lock()
+	opts, ka, pd, tun := u.cfg.TLSOptions, u.cfg.Keepalive, u.proxyDialer, u.cfg.Tunnel
+	u.mu.Unlock()
+
+	var tlsConf *tls.Config
+	if u.cfg.Upstream.TLS {
+		if tlsConf, err = opts.config(u.cfg.Upstream.Host, u.cfg.Upstream.InsecureSkipVerify); err != nil {
+			return fmt.Errorf("upstream TLS: %w", err)
+		}
+	}
+
+	if pd.IsEnabled() {
 		// Use SOCKS proxy
 		if u.cfg.Upstream.TLS {
 			// First connect through SOCKS proxy, then wrap with TLS
 			var rawConn net.Conn
-			rawConn, err = u.proxyDialer.DialContext(ctx, "tcp", addr)
+			rawConn, err = pd.DialContext(ctx, "tcp", addr)
 			if err != nil {
 				return fmt.Errorf("SOCKS proxy connection failed: %w", err)
 			}
+			ka.SetTCPKeepAlive(rawConn)
 
-			conf := &tls.Config{InsecureSkipVerify: u.cfg.Upstream.InsecureSkipVerify}
-			c = tls.Client(rawConn, conf)
+			c = tls.Client(rawConn, tlsConf)
 			if err := c.(*tls.Conn).Handshake(); err != nil {
 				_ = rawConn.Close()
 				return fmt.Errorf("TLS handshake through SOCKS proxy failed: %w", err)
 			}
 		} else {
 			// Direct SOCKS proxy connection
-			c, err = u.proxyDialer.DialContext(ctx, "tcp", addr)
+			c, err = pd.DialContext(ctx, "tcp", addr)
 			if err != nil {
 				return fmt.Errorf("SOCKS proxy connection failed: %w", err)
 			}
+			ka.SetTCPKeepAlive(c)
 		}
 	} else {
-		// Direct connection (original behavior)
+		// Direct connection to the resolved addresses of the pool
+		d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: ka.tcpPeriod()}
+		dial := func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
 		if u.cfg.Upstream.TLS {
-			conf := &tls.Config{InsecureSkipVerify: u.cfg.Upstream.InsecureSkipVerify}
-			c, err = tls.Dial("tcp", addr, conf)
-		} else {
-			c, err = net.DialTimeout("tcp", addr, 10*time.Second)
+			// tlsConf verifies the certificate against the hostname, not the address
+			td := &tls.Dialer{NetDialer: d, Config: tlsConf}
+			dial = func(ctx context.Context, addr string) (net.Conn, error) {
+				return td.DialContext(ctx, "tcp", addr)
+			}
 		}
+		c, err = u.dialDirect(ctx, u.cfg.Upstream.Host, u.cfg.Upstream.Port, dial)
 		if err != nil {
 			return err
 		}
 	}
 
+	if tun.Enabled {
+		tc, err := tunnel.Client(c, tun)
+		if err != nil {
+			_ = c.Close()
+			return fmt.Errorf("tunnel: %w", err)
+		}
+		c = tc
+	}
+
 	u.mu.Lock()
 	u.conn = c
-	u.br = bufio.NewReaderSize(c, u.cfg.Proxy.ReadBuf)
+	if w := ka.UpstreamSilence(); w > 0 {
+		u.br = bufio.NewReaderSize(silenceReader{conn: c, window: w}, u.cfg.Proxy.ReadBuf)
+	} else {
+		u.br = bufio.NewReaderSize(c, u.cfg.Proxy.ReadBuf)
+	}
 	u.bw = bufio.NewWriterSize(c, u.cfg.Proxy.WriteBuf)
+	u.wq = make(chan []byte, u.cfg.Writer.queueSize())
+	u.wdone = make(chan struct{})
+	u.gen++
+	gen := u.gen
+	go u.writeLoop(c, u.bw, u.wq, u.wdone, u.cfg.Writer.maxBatch())
 	u.mu.Unlock()
 	u.respMu.Lock()
+	u.pendingGen = gen
+	u.reqID = 0
 	u.pending = make(map[int64]PendingReq)
+	u.subID, u.authID = 0, 0
+	u.pingID = 0
 	u.respMu.Unlock()
 	return nil
 }
@@ -169,15 +255,60 @@ func (u *Upstream) UpdateTarget(host string, port int, user, pass string, tls bo
 	u.cfg.Upstream.InsecureSkipVerify = insecure
 }
 
+// SetSocksProxy changes the egress proxy from the next dial; failover sets
+// it together with the target, so every upstream can use its own
+func (u *Upstream) SetSocksProxy(cfg proxysocks.Config) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if cfg == u.cfg.Upstream.SocksProxy && u.proxyDialer != nil {
+		return nil
+	}
+	pd, err := proxysocks.NewProxyDialer(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create proxy dialer: %w", err)
+	}
+	u.cfg.Upstream.SocksProxy = cfg
+	u.proxyDialer = pd
+	return nil
+}
+
+// SetTunnel changes the tunnel settings from the next dial
+func (u *Upstream) SetTunnel(t tunnel.Config) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.Tunnel = t
+}
+
+// TunnelStats returns the byte counters of the connection's tunnel; false
+// when it has none
+func (u *Upstream) TunnelStats() (tunnel.Stats, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if tc, ok := u.conn.(*tunnel.Conn); ok {
+		return tc.Stats(), true
+	}
+	return tunnel.Stats{}, false
+}
+
+// SetUserAgent changes the agent announced on the next subscribe
+func (u *Upstream) SetUserAgent(agent string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.UserAgent = agent
+}
+
 // Close closes upstream connection
 func (u *Upstream) Close() {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	if u.conn != nil {
 		_ = u.conn.Close()
+		close(u.wdone)
 		u.conn = nil
 		u.br = nil
 		u.bw = nil
+		u.wq = nil
+		u.wdone = nil
 	}
 }
 
@@ -188,46 +319,96 @@ func (u *Upstream) IsConnected() bool {
 	return u.conn != nil
 }
 
-// SendRaw sends raw data to upstream
+// SendRaw queues raw data for the upstream writer
 func (u *Upstream) SendRaw(line string) error {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-	if u.conn == nil {
-		return fmt.Errorf("upstream nil")
-	}
-	if _, err := u.bw.WriteString(line); err != nil {
-		return err
-	}
-	return u.bw.Flush()
+	return u.enqueue([]byte(line))
 }
 
-// Send sends JSON message to upstream
+// Send queues a JSON message for the upstream writer
 func (u *Upstream) Send(msg stratum.Message) (int64, error) {
+	return u.send(msg, nil, nil)
+}
+
+// Request queues a JSON message whose response is routed by req. The
+// pending entry is registered before the line is queued, so a fast reply
+// always finds it, and it is dropped again if the line cannot be queued.
+func (u *Upstream) Request(msg stratum.Message, req PendingReq) (int64, error) {
+	if req.Sent.IsZero() {
+		req.Sent = time.Now()
+	}
+	return u.send(msg, &req, nil)
+}
+
+// send numbers msg in the current connection's ID space and queues it on
+// that connection only. A reconnect in between makes the send fail instead
+// of putting an old-numbered request on the new connection. reserve, if
+// set, sees the ID under respMu before the line is queued.
+func (u *Upstream) send(msg stratum.Message, req *PendingReq, reserve func(id int64)) (int64, error) {
+	u.mu.Lock()
+	wq, done, gen := u.wq, u.wdone, u.gen
+	timeout := u.cfg.Writer.enqueueTimeout()
+	u.mu.Unlock()
+
+	u.respMu.Lock()
+	if wq == nil || u.pendingGen != gen {
+		u.respMu.Unlock()
+		return 0, errUpstreamNil
+	}
 	u.reqID++
 	id := u.reqID
-	msg.ID = &id
-	b, _ := msg.Marshal()
-	return id, u.SendRaw(string(b))
+	if req != nil {
+		u.pending[id] = *req
+	}
+	if reserve != nil {
+		reserve(id)
+	}
+	u.respMu.Unlock()
+
+	msg.ID = stratum.NewID(id)
+	b, _ := msg.MarshalRequest()
+	err := u.enqueueTo(wq, done, timeout, b)
+	if err == nil {
+		u.lastSend.Store(time.Now().UnixMilli())
+	}
+	if err != nil && req != nil {
+		u.respMu.Lock()
+		if u.pendingGen == gen {
+			delete(u.pending, id)
+		}
+		u.respMu.Unlock()
+	}
+	return id, err
 }
 
-// SubscribeAuthorize sends subscribe and authorize messages
+// SubscribeAuthorize sends subscribe and authorize messages, remembering
+// their IDs so the replies can be told apart from forwarded requests
 func (u *Upstream) SubscribeAuthorize() error {
-	if _, err := u.Send(stratum.NewSubscribeMessage("karoo/v0.0.1")); err != nil {
+	sub := stratum.Message{Method: stratum.MethodSubscribe, Params: u.subscribeParams()}
+	subID, err := u.Send(sub)
+	if err != nil {
 		return err
 	}
-	_, err := u.Send(stratum.NewAuthorizeMessage(u.cfg.Upstream.User, u.cfg.Upstream.Pass))
+	authID, err := u.Send(stratum.NewAuthorizeMessage(u.cfg.Upstream.User, u.cfg.Upstream.Pass))
+	u.respMu.Lock()
+	u.subID, u.authID = subID, authID
+	u.respMu.Unlock()
 	return err
 }
 
-// SetExtranonce sets the extranonce values from upstream
-func (u *Upstream) SetExtranonce(ex1 string, ex2Size int) {
-	u.ex1 = ex1
-	u.ex2Size = ex2Size
-}
-
-// GetExtranonce returns the current extranonce values
-func (u *Upstream) GetExtranonce() (string, int) {
-	return u.ex1, u.ex2Size
+// HandshakeMethod returns the method of the current connection's handshake
+// request with the given ID, or "" when id is not a handshake request
+func (u *Upstream) HandshakeMethod(id int64) string {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	switch {
+	case id == 0:
+		return ""
+	case id == u.subID:
+		return stratum.MethodSubscribe
+	case id == u.authID:
+		return stratum.MethodAuthorize
+	}
+	return ""
 }
 
 // AddPendingRequest adds a pending request to the routing table
@@ -237,6 +418,30 @@ func (u *Upstream) AddPendingRequest(id int64, req PendingReq) {
 	u.pending[id] = req
 }
 
+// DropPending removes and returns every pending request; used when the
+// connection is lost and none of them will be answered
+func (u *Upstream) DropPending() map[int64]PendingReq {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	out := u.pending
+	u.pending = make(map[int64]PendingReq)
+	return out
+}
+
+// DropPendingIf removes and returns the pending requests drop selects
+func (u *Upstream) DropPendingIf(drop func(PendingReq) bool) map[int64]PendingReq {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	out := make(map[int64]PendingReq)
+	for id, req := range u.pending {
+		if drop(req) {
+			out[id] = req
+			delete(u.pending, id)
+		}
+	}
+	return out
+}
+
 // RemovePendingRequest removes and returns a pending request
 func (u *Upstream) RemovePendingRequest(id int64) (PendingReq, bool) {
 	u.respMu.Lock()
@@ -248,6 +453,31 @@ func (u *Upstream) RemovePendingRequest(id int64) (PendingReq, bool) {
 	return req, exists
 }
 
+// ExpirePending removes and returns the pending requests sent before cutoff,
+// keyed by upstream ID
+func (u *Upstream) ExpirePending(cutoff time.Time) map[int64]PendingReq {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	var out map[int64]PendingReq
+	for id, req := range u.pending {
+		if req.Sent.Before(cutoff) {
+			if out == nil {
+				out = make(map[int64]PendingReq)
+			}
+			out[id] = req
+			delete(u.pending, id)
+		}
+	}
+	return out
+}
+
+// PendingCount returns how many requests await an upstream response
+func (u *Upstream) PendingCount() int {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	return len(u.pending)
+}
+
 // GetReader returns the upstream reader
 func (u *Upstream) GetReader() *bufio.Reader {
 	u.mu.Lock()
@@ -1,8 +1,14 @@
 package connection
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -223,3 +229,493 @@ func TestBackoff(t *testing.T) {
 		t.Errorf("Backoff %v outside range [%v, %v]", d, min, min+250*time.Millisecond)
 	}
 }
+
+func TestUpstreamWriterBatching(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "127.0.0.1"
+	cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	cfg.Writer = WriterConfig{QueueSize: 4, MaxBatch: 3, EnqueueTimeoutMs: 50}
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	pool := <-accepted
+	defer pool.Close()
+
+	// Fill the queue while the pool is not reading; the writer blocks on a
+	// full socket only after the kernel buffers fill, so send many lines
+	big := make([]byte, 64<<10)
+	for i := range big {
+		big[i] = 'x'
+	}
+	var busy error
+	for i := 0; i < 200 && busy == nil; i++ {
+		busy = u.SendRaw(string(big) + "\n")
+	}
+	if busy != ErrUpstreamBusy {
+		t.Fatalf("expected backpressure from a stalled upstream, got %v", busy)
+	}
+	if st := u.GetWriterStats(); st.Blocked == 0 || st.Queued != 4 {
+		t.Errorf("writer stats under backpressure = %+v", st)
+	}
+
+	// Once the pool drains the socket every line arrives, in order
+	go func() { _, _ = io.Copy(io.Discard, pool) }()
+	for i := 1; i <= 5; i++ {
+		if _, err := u.Send(stratum.Message{Method: "mining.submit", Params: []interface{}{i}}); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for u.GetWriterStats().Queued > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := u.GetWriterStats()
+	if st.Queued != 0 || st.MaxBatch > 3 || st.Flushes == 0 || st.Flushes >= st.Lines {
+		t.Errorf("expected coalesced flushes of at most 3 lines, got %+v", st)
+	}
+}
+
+func TestUpstreamRequestIDSpace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, c) }()
+		}
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "127.0.0.1"
+	cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := u.Request(stratum.Message{Method: "mining.submit"}, PendingReq{Method: "mining.submit"}); err == nil {
+		t.Error("expected request to a disconnected upstream to fail")
+	}
+	if u.PendingCount() != 0 {
+		t.Errorf("failed request left %d pending entries", u.PendingCount())
+	}
+
+	for round := 0; round < 2; round++ {
+		if err := u.Dial(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.SubscribeAuthorize(); err != nil {
+			t.Fatal(err)
+		}
+		if u.HandshakeMethod(1) != stratum.MethodSubscribe || u.HandshakeMethod(2) != stratum.MethodAuthorize {
+			t.Errorf("round %d: handshake IDs not 1 and 2", round)
+		}
+		id, err := u.Request(stratum.Message{Method: "mining.submit"}, PendingReq{Method: "mining.submit"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != 3 || u.HandshakeMethod(id) != "" {
+			t.Errorf("round %d: request id = %d, want 3", round, id)
+		}
+		if _, ok := u.RemovePendingRequest(id); !ok {
+			t.Errorf("round %d: request not registered as pending", round)
+		}
+		_, _ = u.Request(stratum.Message{Method: "mining.submit"}, PendingReq{Method: "mining.submit"})
+		u.Close()
+		if n := len(u.DropPending()); n != 1 {
+			t.Errorf("round %d: dropped %d pending requests, want 1", round, n)
+		}
+	}
+}
+
+func TestUpstreamDNSRotation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "pool.example"
+	cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := 0
+	records := []string{"127.0.0.2", "127.0.0.1"} // nothing listens on the first
+	u.dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		return records, nil
+	}
+
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatalf("dial should fail over to the second record: %v", err)
+	}
+	st := u.GetDNSStats()
+	if st.Current != "127.0.0.1" || len(st.Addrs) != 2 {
+		t.Errorf("dns stats = %+v", st)
+	}
+	u.Close()
+
+	// the working address is tried first and the cached records are reused
+	if addrs, _ := u.dns.candidates(context.Background(), "pool.example", time.Now()); addrs[0] != "127.0.0.1" {
+		t.Errorf("candidates = %v, want the working address first", addrs)
+	}
+	if lookups != 1 {
+		t.Errorf("lookups = %d, want the cache used", lookups)
+	}
+
+	// when every record fails the name is resolved again on the next dial
+	records = []string{"127.0.0.2", "127.0.0.3"}
+	u.dns.invalidate()
+	if err := u.Dial(context.Background()); err == nil {
+		t.Fatal("dial to dead records succeeded")
+	}
+	records = []string{"127.0.0.1"}
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatalf("dial after re-resolution: %v", err)
+	}
+	u.Close()
+	if lookups != 3 {
+		t.Errorf("lookups = %d, want a fresh lookup after the failure", lookups)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	got := interleave([]string{"2001:db8::1", "2001:db8::2", "192.0.2.1", "2001:db8::3", "192.0.2.2"})
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("interleave = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRaceDial(t *testing.T) {
+	u, err := NewUpstream(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hung := make(chan struct{})
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		switch addr {
+		case "[2001:db8::1]:3333": // blackholed path
+			<-ctx.Done()
+			close(hung)
+			return nil, ctx.Err()
+		case "192.0.2.1:3333":
+			return nil, io.EOF
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	start := time.Now()
+	c, err := u.raceDial(context.Background(), []string{"2001:db8::1", "192.0.2.1", "192.0.2.2"}, "3333", 50*time.Millisecond, dial)
+	if err != nil {
+		t.Fatalf("race: %v", err)
+	}
+	c.Close()
+	// the refused attempt starts the third at once instead of waiting out a stagger
+	if d := time.Since(start); d > 90*time.Millisecond {
+		t.Errorf("race took %s", d)
+	}
+	select {
+	case <-hung:
+	case <-time.After(time.Second):
+		t.Error("losing attempt not cancelled")
+	}
+	if st := u.GetDNSStats(); st.Current != "192.0.2.2" {
+		t.Errorf("current = %q, want the winner", st.Current)
+	}
+
+	if _, err := u.raceDial(context.Background(), []string{"192.0.2.1"}, "3333", time.Millisecond, dial); err == nil {
+		t.Error("race over failing addresses succeeded")
+	}
+}
+
+func TestUpstreamSilence(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = c.Write([]byte("{}\n"))
+		time.Sleep(5 * time.Second) // connected but silent
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "127.0.0.1"
+	cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	cfg.Keepalive = KeepaliveConfig{TCPSeconds: 5, UpstreamSilenceSeconds: 1}
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	br := u.GetReader()
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("first line: %v", err)
+	}
+	start := time.Now()
+	_, err = br.ReadString('\n')
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read from a silent pool = %v, want a deadline error", err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond || d > 3*time.Second {
+		t.Errorf("silence detected after %s, want about 1s", d)
+	}
+
+	if d := (KeepaliveConfig{UpstreamSilenceSeconds: -1}).UpstreamSilence(); d != 0 {
+		t.Errorf("disabled upstream silence = %s", d)
+	}
+	if d := (KeepaliveConfig{}).ClientSilence(); d != 30*time.Minute {
+		t.Errorf("default client silence = %s", d)
+	}
+}
+
+func TestUpstreamPing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 4)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			lines <- string(buf[:n])
+		}
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "127.0.0.1"
+	cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	now := time.Now()
+	if err := u.Ping(PingMethodPing, []interface{}{}, now); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l := <-lines:
+		if !strings.Contains(l, `"mining.ping"`) || !strings.Contains(l, `"id":1`) {
+			t.Errorf("unexpected keepalive line %q", l)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("keepalive not sent")
+	}
+	if d := u.IdleFor(time.Now()); d > time.Second {
+		t.Errorf("idle %s right after a keepalive", d)
+	}
+
+	// a second ping waits for the first to be answered
+	if err := u.Ping(PingMethodPing, []interface{}{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if u.PingOverdue(now.Add(29*time.Second), 30*time.Second) {
+		t.Error("keepalive overdue before the timeout")
+	}
+	if !u.PingOverdue(now.Add(30*time.Second), 30*time.Second) {
+		t.Error("unanswered keepalive not overdue")
+	}
+	if u.PingReply(2) {
+		t.Error("another ID settled the keepalive")
+	}
+	if !u.PingReply(1) || u.PingOverdue(now.Add(time.Hour), 30*time.Second) {
+		t.Error("response did not settle the keepalive")
+	}
+
+	if err := (KeepaliveConfig{UpstreamPingMethod: "mining.noop"}).Validate(); err == nil {
+		t.Error("unknown keepalive method accepted")
+	}
+}
+
+func TestSetSocksProxy(t *testing.T) {
+	// an HTTP proxy that answers CONNECT and then speaks for the pool
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	connects := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		connects <- strings.TrimSpace(line)
+		_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\n\r\n{}\n"))
+		time.Sleep(time.Second)
+	}()
+
+	cfg := &Config{}
+	cfg.Upstream.Host = "pool.onion"
+	cfg.Upstream.Port = 3333
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	u, err := NewUpstream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.SetSocksProxy(proxysocks.Config{Enabled: true, Type: "ftp", Host: "127.0.0.1", Port: 1}); err == nil {
+		t.Error("unsupported proxy type accepted")
+	}
+	sp := proxysocks.Config{Enabled: true, Type: proxysocks.TypeHTTP, Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port}
+	if err := u.SetSocksProxy(sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if got := <-connects; got != "CONNECT pool.onion:3333 HTTP/1.1" {
+		t.Errorf("proxy request = %q", got)
+	}
+	if line, err := u.GetReader().ReadString('\n'); err != nil || line != "{}\n" {
+		t.Errorf("pool line through the proxy = %q, %v", line, err)
+	}
+}
+
+func TestSubscribeParams(t *testing.T) {
+	u, err := NewUpstream(&Config{UserAgent: "karoo/1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.UpdateTarget("pool.example", 3333, "w", "x", false, false)
+	for _, tc := range []struct {
+		sub  SubscribeConfig
+		want []interface{}
+	}{
+		{SubscribeConfig{}, []interface{}{"karoo/1.0"}},
+		{SubscribeConfig{UserAgent: "cgminer/4.10.0"}, []interface{}{"cgminer/4.10.0"}},
+		{SubscribeConfig{UserAgent: "-"}, []interface{}{}},
+		{SubscribeConfig{ResumeSession: true}, []interface{}{"karoo/1.0", nil}},
+		{SubscribeConfig{UserAgent: "-", ProtocolVersion: "EthereumStratum/1.0.0"}, []interface{}{"", "EthereumStratum/1.0.0"}},
+	} {
+		u.SetSubscribe(tc.sub)
+		if got := u.subscribeParams(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: params %v, want %v", tc.sub, got, tc.want)
+		}
+	}
+
+	// the pool's extranonce1 is resumed on the next connection to it only
+	u.SetExtranonce("f000000d", 4)
+	u.SetSubscribe(SubscribeConfig{ResumeSession: true})
+	if got := u.subscribeParams(); !reflect.DeepEqual(got, []interface{}{"karoo/1.0", "f000000d"}) {
+		t.Errorf("resume params %v", got)
+	}
+	u.UpdateTarget("other.example", 3333, "w", "x", false, false)
+	if got := u.subscribeParams(); got[1] != nil {
+		t.Errorf("another pool got session %v", got[1])
+	}
+}
+
+func TestExtranonceGeneration(t *testing.T) {
+	u, err := NewUpstream(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex := u.ExtranonceState(); ex != (Extranonce{}) {
+		t.Fatalf("state before any subscribe = %+v", ex)
+	}
+	u.SetExtranonce("aa", 4)
+	u.SetExtranonce("bbbb", 8)
+	if ex := u.ExtranonceState(); ex != (Extranonce{Ex1: "bbbb", Ex2Size: 8, Gen: 2}) {
+		t.Errorf("state = %+v, want bbbb/8 at generation 2", ex)
+	}
+
+	// readers racing a reconnect see whole pairs only
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				u.SetExtranonce("aa", 4)
+			} else {
+				u.SetExtranonce("bbbb", 8)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if gen := u.ExtranonceState().Gen; gen != 1002 {
+				t.Errorf("generation = %d, want 1002", gen)
+			}
+			return
+		default:
+		}
+		if ex1, size := u.GetExtranonce(); len(ex1) != size/2 {
+			t.Fatalf("torn extranonce %q/%d", ex1, size)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Dial strategies
+const (
+	DialSerial   = "serial"   // one address at a time
+	DialParallel = "parallel" // staggered race, first handshake wins
+)
+
+// DialConfig selects how the resolved addresses of the pool are dialed
+type DialConfig struct {
+	Strategy string `json:"strategy"` // "serial" (default) or "parallel"
+	// StaggerMs is the head start of each attempt over the next one in a
+	// parallel dial; a failed attempt starts the next one at once
+	StaggerMs int `json:"stagger_ms"` // default 250
+}
+
+// parallel reports whether addresses are raced
+func (c DialConfig) parallel() bool {
+	return c.Strategy == DialParallel
+}
+
+// stagger returns the delay between parallel attempts
+func (c DialConfig) stagger() time.Duration {
+	if c.StaggerMs <= 0 {
+		return 250 * time.Millisecond
+	}
+	return time.Duration(c.StaggerMs) * time.Millisecond
+}
+
+// SetDialConfig changes the dial strategy from the next dial
+func (u *Upstream) SetDialConfig(cfg DialConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.Dial = cfg
+}
+
+// interleave alternates address families, keeping the resolver's order
+// within each and starting with the family of the first address, so a
+// broken IPv6 or IPv4 path costs a single stagger
+func interleave(addrs []string) []string {
+	var first, second []string
+	v4 := func(a string) bool {
+		ip := net.ParseIP(a)
+		return ip != nil && ip.To4() != nil
+	}
+	for _, a := range addrs {
+		if v4(a) == v4(addrs[0]) {
+			first = append(first, a)
+		} else {
+			second = append(second, a)
+		}
+	}
+	out := make([]string, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// raceDial starts an attempt on each address, stagger apart, and returns the
+// first connection to complete its handshake. Slower attempts are cancelled
+// and any that still connect are closed.
+func (u *Upstream) raceDial(ctx context.Context, addrs []string, port string, stagger time.Duration, dial dialFunc) (net.Conn, error) {
+	type result struct {
+		c    net.Conn
+		addr string
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(addrs))
+	next, running := 0, 0
+	start := func() {
+		a := addrs[next]
+		next++
+		running++
+		go func() {
+			c, err := dial(ctx, net.JoinHostPort(a, port))
+			results <- result{c: c, addr: a, err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(stagger)
+	defer timer.Stop()
+	var errs []error
+	for running > 0 {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(stagger)
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				u.dns.succeeded(r.addr)
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.c != nil {
+							_ = late.c.Close()
+						}
+					}
+				}(running)
+				return r.c, nil
+			}
+			u.dns.failed(r.addr)
+			errs = append(errs, fmt.Errorf("%s: %w", r.addr, r.err))
+			if next < len(addrs) && ctx.Err() == nil {
+				start()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(stagger)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,53 @@
+package connection
+
+import (
+	"net"
+	"strconv"
+)
+
+// Extranonce is the extranonce1 and extranonce2 size a pool assigned. Gen
+// counts assignments, so state derived from one can be told apart from the
+// next after a reconnect.
+type Extranonce struct {
+	Ex1     string
+	Ex2Size int
+	Gen     uint64
+}
+
+// SetExtranonce sets the extranonce values from upstream. Readers see either
+// the previous pair or this one, never a mix.
+func (u *Upstream) SetExtranonce(ex1 string, ex2Size int) {
+	for {
+		old := u.ex.Load()
+		next := &Extranonce{Ex1: ex1, Ex2Size: ex2Size, Gen: 1}
+		if old != nil {
+			next.Gen = old.Gen + 1
+		}
+		if u.ex.CompareAndSwap(old, next) {
+			break
+		}
+	}
+	if ex1 != "" {
+		u.mu.Lock()
+		addr := net.JoinHostPort(u.cfg.Upstream.Host, strconv.Itoa(u.cfg.Upstream.Port))
+		u.mu.Unlock()
+		u.respMu.Lock()
+		u.sessions[addr] = ex1
+		u.respMu.Unlock()
+	}
+}
+
+// ExtranonceState returns the current extranonce assignment; the zero value
+// before the first one
+func (u *Upstream) ExtranonceState() Extranonce {
+	if ex := u.ex.Load(); ex != nil {
+		return *ex
+	}
+	return Extranonce{}
+}
+
+// GetExtranonce returns the current extranonce values
+func (u *Upstream) GetExtranonce() (string, int) {
+	ex := u.ExtranonceState()
+	return ex.Ex1, ex.Ex2Size
+}
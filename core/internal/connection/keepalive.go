@@ -0,0 +1,214 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// KeepaliveConfig detects dead peers on client and pool connections: TCP
+// keepalive probes catch a vanished host, the silence windows a peer that
+// is connected but no longer talking
+type KeepaliveConfig struct {
+	// TCPSeconds is the TCP keepalive probe interval on client and pool
+	// sockets; 0 keeps the system default, -1 disables probes
+	TCPSeconds int `json:"tcp_seconds"`
+	// ClientSilenceSeconds closes an authorized client that sent nothing
+	// for this long; default 1800
+	ClientSilenceSeconds int `json:"client_silence_seconds"`
+	// UpstreamSilenceSeconds drops the pool connection when the pool sent
+	// nothing for this long, so failover can take over; default 600, or
+	// UpstreamNotifySeconds when that is set; -1 disables
+	UpstreamSilenceSeconds int `json:"upstream_silence_seconds"`
+	// UpstreamNotifySeconds drops the pool connection when no mining.notify
+	// arrived for this long, even if other messages still do; 0 disables
+	UpstreamNotifySeconds int `json:"upstream_notify_seconds"`
+	// UpstreamRefreshSeconds re-sends the last job with its ntime rolled
+	// forward when no mining.notify arrived for this long, and again every
+	// interval, to keep miners busy through a stall; 0 disables
+	UpstreamRefreshSeconds int `json:"upstream_refresh_seconds"`
+	// UpstreamPingSeconds sends a keepalive request once nothing was sent to
+	// the pool for this long; 0 disables
+	UpstreamPingSeconds int `json:"upstream_ping_seconds"`
+	// UpstreamPingMethod is "mining.ping" (default) or
+	// "mining.suggest_difficulty", which repeats the pool's difficulty
+	UpstreamPingMethod string `json:"upstream_ping_method"`
+	// UpstreamPingTimeoutSeconds drops the pool connection when a keepalive
+	// request gets no response, even an error, in time; default 30
+	UpstreamPingTimeoutSeconds int `json:"upstream_ping_timeout_seconds"`
+}
+
+// Keepalive request methods
+const (
+	PingMethodPing    = "mining.ping"
+	PingMethodSuggest = stratum.MethodSuggestDifficulty
+)
+
+// Validate checks the windows and the keepalive method
+func (c KeepaliveConfig) Validate() error {
+	if c.TCPSeconds < -1 || c.UpstreamSilenceSeconds < -1 {
+		return errors.New("tcp_seconds and upstream_silence_seconds must be -1 or more")
+	}
+	if c.ClientSilenceSeconds < 0 || c.UpstreamNotifySeconds < 0 || c.UpstreamRefreshSeconds < 0 || c.UpstreamPingSeconds < 0 || c.UpstreamPingTimeoutSeconds < 0 {
+		return errors.New("client_silence_seconds, upstream_notify_seconds, upstream_refresh_seconds and the upstream ping settings must not be negative")
+	}
+	switch c.UpstreamPingMethod {
+	case "", PingMethodPing, PingMethodSuggest:
+	default:
+		return fmt.Errorf("upstream_ping_method must be %q or %q", PingMethodPing, PingMethodSuggest)
+	}
+	return nil
+}
+
+// tcpPeriod returns the probe interval in net.Dialer.KeepAlive terms:
+// zero for the default, negative for disabled
+func (c KeepaliveConfig) tcpPeriod() time.Duration {
+	if c.TCPSeconds < 0 {
+		return -1
+	}
+	return time.Duration(c.TCPSeconds) * time.Second
+}
+
+// ClientSilence returns how long an authorized client may stay silent
+func (c KeepaliveConfig) ClientSilence() time.Duration {
+	if c.ClientSilenceSeconds <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(c.ClientSilenceSeconds) * time.Second
+}
+
+// UpstreamSilence returns how long the pool may stay silent, or 0 when the
+// check is disabled
+func (c KeepaliveConfig) UpstreamSilence() time.Duration {
+	switch {
+	case c.UpstreamSilenceSeconds < 0:
+		return 0
+	case c.UpstreamSilenceSeconds == 0 && c.UpstreamNotifySeconds > 0:
+		return c.NotifyTimeout()
+	case c.UpstreamSilenceSeconds == 0:
+		return 10 * time.Minute
+	}
+	return time.Duration(c.UpstreamSilenceSeconds) * time.Second
+}
+
+// NotifyTimeout returns how long the pool may go without a mining.notify,
+// or 0 when the watchdog is disabled
+func (c KeepaliveConfig) NotifyTimeout() time.Duration {
+	if c.UpstreamNotifySeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.UpstreamNotifySeconds) * time.Second
+}
+
+// RefreshInterval returns how long the pool may go without a mining.notify
+// before the last job is re-sent, or 0 when refreshing is disabled
+func (c KeepaliveConfig) RefreshInterval() time.Duration {
+	return time.Duration(c.UpstreamRefreshSeconds) * time.Second
+}
+
+// PingInterval returns the idle time before a keepalive request, or 0 when
+// keepalive requests are disabled
+func (c KeepaliveConfig) PingInterval() time.Duration {
+	return time.Duration(c.UpstreamPingSeconds) * time.Second
+}
+
+// PingTimeout returns how long a keepalive request may go unanswered
+func (c KeepaliveConfig) PingTimeout() time.Duration {
+	if c.UpstreamPingTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.UpstreamPingTimeoutSeconds) * time.Second
+}
+
+// PingMethod returns the keepalive request method
+func (c KeepaliveConfig) PingMethod() string {
+	if c.UpstreamPingMethod == "" {
+		return PingMethodPing
+	}
+	return c.UpstreamPingMethod
+}
+
+// SetTCPKeepAlive applies the configured probe interval to an accepted
+// connection, looking through TLS and tunnels; other connection types are
+// left alone
+func (c KeepaliveConfig) SetTCPKeepAlive(conn net.Conn) {
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || c.TCPSeconds == 0 {
+		return
+	}
+	if c.TCPSeconds < 0 {
+		_ = tcp.SetKeepAlive(false)
+		return
+	}
+	_ = tcp.SetKeepAlive(true)
+	_ = tcp.SetKeepAlivePeriod(c.tcpPeriod())
+}
+
+// silenceReader extends the read deadline of conn before every read, so a
+// read fails once the peer has sent nothing for the window
+type silenceReader struct {
+	conn   net.Conn
+	window time.Duration
+}
+
+func (r silenceReader) Read(b []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(r.window))
+	return r.conn.Read(b)
+}
+
+// Ping sends a keepalive request unless one is outstanding. Its response
+// is recognized by PingReply.
+func (u *Upstream) Ping(method string, params []interface{}, now time.Time) error {
+	u.respMu.Lock()
+	outstanding := u.pingID != 0
+	u.respMu.Unlock()
+	if outstanding {
+		return nil
+	}
+	_, err := u.send(stratum.Message{Method: method, Params: params}, nil, func(id int64) {
+		u.pingID, u.pingSent = id, now
+	})
+	return err
+}
+
+// PingReply reports whether id answers the outstanding keepalive request,
+// which is then settled
+func (u *Upstream) PingReply(id int64) bool {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	if u.pingID == 0 || id != u.pingID {
+		return false
+	}
+	u.pingID = 0
+	return true
+}
+
+// PingOverdue reports whether the outstanding keepalive request has gone
+// unanswered for timeout
+func (u *Upstream) PingOverdue(now time.Time, timeout time.Duration) bool {
+	u.respMu.Lock()
+	defer u.respMu.Unlock()
+	return u.pingID != 0 && now.Sub(u.pingSent) >= timeout
+}
+
+// IdleFor returns how long nothing has been queued for the pool
+func (u *Upstream) IdleFor(now time.Time) time.Duration {
+	return now.Sub(time.UnixMilli(u.lastSend.Load()))
+}
+
+// SetKeepaliveConfig changes dead peer detection from the next dial
+func (u *Upstream) SetKeepaliveConfig(cfg KeepaliveConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.Keepalive = cfg
+}
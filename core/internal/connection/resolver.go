@@ -0,0 +1,255 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DNSConfig controls how the pool hostname is resolved for direct dials.
+// Connections through a SOCKS proxy leave resolution to the proxy.
+type DNSConfig struct {
+	// Resolver is a DNS server as host:port; empty uses the system resolver
+	Resolver   string `json:"resolver"`
+	TTLSeconds int    `json:"ttl_seconds"` // reuse resolved addresses; default 60
+	TimeoutMs  int    `json:"timeout_ms"`  // per lookup; default 5000
+}
+
+// ttl returns how long resolved addresses are reused
+func (c DNSConfig) ttl() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// timeout returns the lookup deadline
+func (c DNSConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// DNSStats is the upstream resolution view in /status
+type DNSStats struct {
+	Host     string    `json:"host"`
+	Addrs    []string  `json:"addrs"`
+	Current  string    `json:"current,omitempty"` // address of the last successful dial
+	Resolved time.Time `json:"resolved"`
+	Expires  time.Time `json:"expires"`
+}
+
+// resolver caches the A and AAAA records of the pool hostname and rotates
+// through them: a dial starts at the address that last worked, moves on when
+// one fails, and forces a fresh lookup once all of them have failed
+type resolver struct {
+	mu       sync.Mutex
+	cfg      DNSConfig
+	host     string
+	addrs    []string
+	next     int
+	current  string
+	resolved time.Time
+	expires  time.Time
+
+	// lookup is replaced in tests
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+func newResolver(cfg DNSConfig) *resolver {
+	r := &resolver{cfg: cfg}
+	r.lookup = r.lookupHost
+	return r
+}
+
+// setConfig applies a new DNS config, dropping cached addresses
+func (r *resolver) setConfig(cfg DNSConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg
+	r.expires = time.Time{}
+}
+
+// lookupHost queries the configured resolver, or the system one
+func (r *resolver) lookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	cfg := r.cfg
+	r.mu.Unlock()
+
+	res := net.DefaultResolver
+	if cfg.Resolver != "" {
+		res = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, cfg.Resolver)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout())
+	defer cancel()
+	return res.LookupHost(ctx, host)
+}
+
+// candidates returns the addresses to try for host, in dial order, resolving
+// it again when the cache is stale or belongs to another host
+func (r *resolver) candidates(ctx context.Context, host string, now time.Time) ([]string, error) {
+	r.mu.Lock()
+	fresh := r.host == host && now.Before(r.expires) && len(r.addrs) > 0
+	r.mu.Unlock()
+
+	if !fresh {
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("resolve %s: no addresses", host)
+		}
+		r.mu.Lock()
+		if r.host != host || !sameAddrs(r.addrs, addrs) {
+			log.Printf("upstream %s resolved to %v", host, addrs)
+			r.next = 0
+			for i, a := range addrs {
+				if a == r.current {
+					r.next = i
+				}
+			}
+		}
+		r.host, r.addrs = host, addrs
+		r.resolved, r.expires = now, now.Add(r.cfg.ttl())
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.addrs))
+	for i := range r.addrs {
+		out = append(out, r.addrs[(r.next+i)%len(r.addrs)])
+	}
+	return out, nil
+}
+
+// succeeded records the address a dial reached
+func (r *resolver) succeeded(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = addr
+	for i, a := range r.addrs {
+		if a == addr {
+			r.next = i
+		}
+	}
+}
+
+// failed records an address that could not be reached so the next dial
+// starts after it
+func (r *resolver) failed(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, a := range r.addrs {
+		if a == addr {
+			r.next = (i + 1) % len(r.addrs)
+		}
+	}
+}
+
+// invalidate forces a lookup before the next dial
+func (r *resolver) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expires = time.Time{}
+}
+
+// stats returns the cached resolution
+func (r *resolver) stats() DNSStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return DNSStats{
+		Host:     r.host,
+		Addrs:    append([]string{}, r.addrs...),
+		Current:  r.current,
+		Resolved: r.resolved,
+		Expires:  r.expires,
+	}
+}
+
+// sameAddrs reports whether two address lists are equal
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// dialFunc connects to one host:port, including any TLS handshake
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// dialDirect connects to host:port without a proxy, trying the resolved
+// addresses of host one after another, or racing them with the parallel
+// strategy. A literal IP is dialed as is.
+func (u *Upstream) dialDirect(ctx context.Context, host string, port int, dial dialFunc) (net.Conn, error) {
+	portStr := strconv.Itoa(port)
+	if net.ParseIP(host) != nil {
+		return dial(ctx, net.JoinHostPort(host, portStr))
+	}
+
+	addrs, err := u.dns.candidates(ctx, host, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	dc := u.cfg.Dial
+	u.mu.Unlock()
+
+	var c net.Conn
+	if dc.parallel() && len(addrs) > 1 {
+		c, err = u.raceDial(ctx, interleave(addrs), portStr, dc.stagger(), dial)
+	} else {
+		c, err = u.serialDial(ctx, addrs, portStr, dial)
+	}
+	if err != nil {
+		// every address failed: the pool may have moved, look it up again
+		u.dns.invalidate()
+	}
+	return c, err
+}
+
+// serialDial tries each address in turn until one connects
+func (u *Upstream) serialDial(ctx context.Context, addrs []string, port string, dial dialFunc) (net.Conn, error) {
+	var errs []error
+	for _, a := range addrs {
+		c, err := dial(ctx, net.JoinHostPort(a, port))
+		if err == nil {
+			u.dns.succeeded(a)
+			return c, nil
+		}
+		u.dns.failed(a)
+		errs = append(errs, fmt.Errorf("%s: %w", a, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SetDNSConfig changes how the pool hostname is resolved from the next dial
+func (u *Upstream) SetDNSConfig(cfg DNSConfig) {
+	u.dns.setConfig(cfg)
+}
+
+// GetDNSStats returns the resolved addresses of the pool hostname
+func (u *Upstream) GetDNSStats() DNSStats {
+	return u.dns.stats()
+}
@@ -0,0 +1,61 @@
+package connection
+
+import (
+	"net"
+	"strconv"
+)
+
+// SubscribeConfig sets the mining.subscribe parameters sent to one pool,
+// since some pools gate features such as extranonce.subscribe on them
+type SubscribeConfig struct {
+	// UserAgent replaces identity.user_agent for this pool; "-" sends none
+	UserAgent string `json:"user_agent"`
+	// ResumeSession sends the extranonce1 the pool gave the previous
+	// connection as the session ID, asking it to resume that session
+	ResumeSession bool `json:"resume_session"`
+	// ProtocolVersion is sent after the agent and session ID, for pools
+	// that expect one such as "EthereumStratum/1.0.0"
+	ProtocolVersion string `json:"protocol_version"`
+}
+
+// SetSubscribe changes the subscribe parameters from the next connection
+func (u *Upstream) SetSubscribe(s SubscribeConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.Subscribe = s
+}
+
+// subscribeParams builds the mining.subscribe parameters for the current
+// pool: the agent, then the session ID when resuming (null when there is
+// none yet), then the protocol version
+func (u *Upstream) subscribeParams() []interface{} {
+	u.mu.Lock()
+	s, agent := u.cfg.Subscribe, u.cfg.UserAgent
+	addr := net.JoinHostPort(u.cfg.Upstream.Host, strconv.Itoa(u.cfg.Upstream.Port))
+	u.mu.Unlock()
+
+	switch s.UserAgent {
+	case "":
+	case "-":
+		agent = ""
+	default:
+		agent = s.UserAgent
+	}
+	if agent == "" && !s.ResumeSession && s.ProtocolVersion == "" {
+		return []interface{}{}
+	}
+	params := []interface{}{agent}
+	if s.ResumeSession {
+		var session interface{}
+		u.respMu.Lock()
+		if id := u.sessions[addr]; id != "" {
+			session = id
+		}
+		u.respMu.Unlock()
+		params = append(params, session)
+	}
+	if s.ProtocolVersion != "" {
+		params = append(params, s.ProtocolVersion)
+	}
+	return params
+}
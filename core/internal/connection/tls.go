@@ -0,0 +1,113 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions trust a pool on a private CA or a pinned certificate, and
+// present a client certificate, without resorting to insecure_skip_verify
+type TLSOptions struct {
+	CAFile   string `json:"ca_file"`   // PEM bundle trusted instead of the system roots
+	CertFile string `json:"cert_file"` // PEM client certificate
+	KeyFile  string `json:"key_file"`  // PEM key of CertFile
+	// Pins are SHA-256 hashes, hex or base64 with an optional "sha256/"
+	// prefix, of a certificate or its SubjectPublicKeyInfo; any certificate
+	// in the pool's chain matching one is accepted. Without CAFile the pin
+	// replaces chain verification, so self-signed pools work.
+	Pins []string `json:"pin_sha256"`
+}
+
+var errPinMismatch = errors.New("no certificate of the pool matches pin_sha256")
+
+// parsePin decodes one pin to its 32-byte hash
+func parsePin(s string) ([]byte, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(s), "sha256/")
+	var b []byte
+	var err error
+	if h := strings.ReplaceAll(v, ":", ""); len(h) == 2*sha256.Size {
+		b, err = hex.DecodeString(h)
+	} else {
+		b, err = base64.StdEncoding.DecodeString(v)
+	}
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin %q: want a SHA-256 hash in hex or base64", s)
+	}
+	return b, nil
+}
+
+// Validate loads the configured files and parses the pins
+func (o TLSOptions) Validate() error {
+	_, err := o.config("", false)
+	return err
+}
+
+// config builds the client TLS config for host
+func (o TLSOptions) config(host string, insecure bool) (*tls.Config, error) {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+	conf := &tls.Config{ServerName: host, InsecureSkipVerify: insecure}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s: no PEM certificates", o.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if len(o.Pins) == 0 {
+		return conf, nil
+	}
+
+	pins := make([][]byte, 0, len(o.Pins))
+	for _, s := range o.Pins {
+		b, err := parsePin(s)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, b)
+	}
+	if o.CAFile == "" {
+		conf.InsecureSkipVerify = true
+	}
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			raw := sha256.Sum256(cert.Raw)
+			spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(pin, raw[:]) || bytes.Equal(pin, spki[:]) {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+	return conf, nil
+}
+
+// SetTLSOptions changes the TLS trust and client certificate from the next
+// dial; failover sets them together with the target
+func (u *Upstream) SetTLSOptions(o TLSOptions) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.TLSOptions = o
+}
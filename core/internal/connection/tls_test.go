@@ -0,0 +1,162 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert returns a certificate for 127.0.0.1 signed by parent, or
+// self-signed when parent is nil
+func testCert(t *testing.T, parent *tls.Certificate, isCA bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "karoo test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM stores a certificate and its key as PEM files
+func writePEM(t *testing.T, dir, name string, c tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestUpstreamTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	ca := testCert(t, nil, true)
+	server := testCert(t, &ca, false)
+	client := testCert(t, nil, false)
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "client", client)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = c.(*tls.Conn).Handshake()
+				time.Sleep(100 * time.Millisecond)
+				c.Close()
+			}()
+		}
+	}()
+
+	dial := func(o TLSOptions) error {
+		cfg := &Config{TLSOptions: o}
+		cfg.Upstream.Host = "127.0.0.1"
+		cfg.Upstream.Port = ln.Addr().(*net.TCPAddr).Port
+		cfg.Upstream.TLS = true
+		u, err := NewUpstream(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Dial(t.Context()); err != nil {
+			return err
+		}
+		// TLS 1.3 reports a refused client certificate on the first read
+		_ = u.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, err = u.conn.Read(make([]byte, 1))
+		u.Close()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	spki := sha256.Sum256(server.Leaf.RawSubjectPublicKeyInfo)
+	caHash := sha256.Sum256(ca.Certificate[0])
+	cases := []struct {
+		name string
+		opts TLSOptions
+		ok   bool
+	}{
+		{"private CA", TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{"no client certificate", TLSOptions{CAFile: caFile}, false},
+		{"system roots", TLSOptions{CertFile: certFile, KeyFile: keyFile}, false},
+		{"SPKI pin", TLSOptions{CertFile: certFile, KeyFile: keyFile, Pins: []string{"sha256/" + b64(spki[:])}}, true},
+		{"wrong pin", TLSOptions{CertFile: certFile, KeyFile: keyFile, Pins: []string{hex.EncodeToString(caHash[:])}}, false},
+	}
+	for _, tc := range cases {
+		if err := dial(tc.opts); (err == nil) != tc.ok {
+			t.Errorf("%s: dial err = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	bad := []TLSOptions{
+		{CertFile: "client.crt"},
+		{CAFile: "/nonexistent/ca.pem"},
+		{Pins: []string{"abcd"}},
+	}
+	for _, o := range bad {
+		if err := o.Validate(); err == nil {
+			t.Errorf("%+v passed validation", o)
+		}
+	}
+	pin := "sha256/" + b64(make([]byte, 32))
+	if err := (TLSOptions{Pins: []string{pin, "AA:" + hex.EncodeToString(make([]byte, 31))}}).Validate(); err != nil {
+		t.Errorf("valid pins: %v", err)
+	}
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
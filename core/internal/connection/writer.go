@@ -0,0 +1,242 @@
+package connection
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// WriterConfig bounds the upstream write queue
+type WriterConfig struct {
+	QueueSize        int `json:"queue_size"`         // lines; default 1024
+	MaxBatch         int `json:"max_batch"`          // lines per flush; default 64
+	EnqueueTimeoutMs int `json:"enqueue_timeout_ms"` // wait on a full queue; default 5000
+}
+
+// queueSize returns the queue capacity
+func (c WriterConfig) queueSize() int {
+	if c.QueueSize <= 0 {
+		return 1024
+	}
+	return c.QueueSize
+}
+
+// maxBatch returns how many lines one flush may carry
+func (c WriterConfig) maxBatch() int {
+	if c.MaxBatch <= 0 {
+		return 64
+	}
+	return c.MaxBatch
+}
+
+// enqueueTimeout returns how long a sender waits on a full queue
+func (c WriterConfig) enqueueTimeout() time.Duration {
+	if c.EnqueueTimeoutMs <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(c.EnqueueTimeoutMs) * time.Millisecond
+}
+
+var (
+	// ErrUpstreamBusy is returned when the write queue stays full past the
+	// enqueue timeout
+	ErrUpstreamBusy = errors.New("upstream write queue full")
+	errUpstreamNil  = errors.New("upstream nil")
+)
+
+// FlushStats describes one flush to the upstream
+type FlushStats struct {
+	Lines   int           // lines coalesced into the flush
+	Latency time.Duration // time spent writing and flushing
+	Queued  int           // lines still waiting afterwards
+}
+
+// writerStats accumulates flush totals for WriterStats
+type writerStats struct {
+	mu         sync.Mutex
+	flushes    uint64
+	lines      uint64
+	blocked    uint64
+	lastFlush  time.Duration
+	maxFlush   time.Duration
+	maxBatched int
+}
+
+// WriterStats is the upstream writer view in /status
+type WriterStats struct {
+	Queued      int     `json:"queued"`
+	Capacity    int     `json:"capacity"`
+	Flushes     uint64  `json:"flushes"`
+	Lines       uint64  `json:"lines"`
+	AvgBatch    float64 `json:"avg_batch"`
+	MaxBatch    int     `json:"max_batch"`
+	Blocked     uint64  `json:"blocked"` // sends that waited on a full queue
+	LastFlushMs float64 `json:"last_flush_ms"`
+	MaxFlushMs  float64 `json:"max_flush_ms"`
+}
+
+// SetWriterConfig changes the writer limits; the queue size and batch limit
+// apply from the next connection
+func (u *Upstream) SetWriterConfig(cfg WriterConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cfg.Writer = cfg
+}
+
+// SetFlushHook registers a callback invoked after every flush
+func (u *Upstream) SetFlushHook(fn func(FlushStats)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.onFlush = fn
+}
+
+// SetWriteHook registers a callback seeing every line written to the pool,
+// called from the writer goroutine
+func (u *Upstream) SetWriteHook(fn func(line []byte)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.onWrite = fn
+}
+
+// enqueue hands a newline-terminated line to the current connection's writer
+func (u *Upstream) enqueue(line []byte) error {
+	u.mu.Lock()
+	wq, done := u.wq, u.wdone
+	timeout := u.cfg.Writer.enqueueTimeout()
+	u.mu.Unlock()
+	return u.enqueueTo(wq, done, timeout, line)
+}
+
+// enqueueTo hands a line to the writer owning wq. When the queue is full the
+// sender waits, up to timeout, for the writer to catch up; that wait is the
+// backpressure felt by clients submitting shares.
+func (u *Upstream) enqueueTo(wq chan []byte, done chan struct{}, timeout time.Duration, line []byte) error {
+	if wq == nil {
+		return errUpstreamNil
+	}
+	select {
+	case wq <- line:
+		return nil
+	case <-done:
+		return errUpstreamNil
+	default:
+	}
+
+	u.stats.mu.Lock()
+	u.stats.blocked++
+	u.stats.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case wq <- line:
+		return nil
+	case <-done:
+		return errUpstreamNil
+	case <-timer.C:
+		return ErrUpstreamBusy
+	}
+}
+
+// writeLoop writes queued lines to one connection, coalescing whatever is
+// pending (up to MaxBatch lines) into a single flush. A write error closes
+// the connection so the reader notices and the proxy reconnects.
+func (u *Upstream) writeLoop(c net.Conn, bw *bufio.Writer, wq chan []byte, done chan struct{}, maxBatch int) {
+	for {
+		var line []byte
+		select {
+		case <-done:
+			return
+		case line = <-wq:
+		}
+
+		u.mu.Lock()
+		tap := u.onWrite
+		u.mu.Unlock()
+
+		start := time.Now()
+		n := 1
+		if tap != nil {
+			tap(line)
+		}
+		_, err := bw.Write(line)
+	batch:
+		for err == nil && n < maxBatch {
+			select {
+			case line = <-wq:
+				if tap != nil {
+					tap(line)
+				}
+				_, err = bw.Write(line)
+				n++
+			default:
+				break batch
+			}
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		fs := FlushStats{Lines: n, Latency: time.Since(start), Queued: len(wq)}
+		u.recordFlush(fs)
+
+		if err != nil {
+			select {
+			case <-done: // closed on purpose
+			default:
+				log.Printf("upstream write err: %v", err)
+				_ = c.Close()
+			}
+			return
+		}
+	}
+}
+
+// recordFlush adds a flush to the totals and reports it to the hook
+func (u *Upstream) recordFlush(fs FlushStats) {
+	st := &u.stats
+	st.mu.Lock()
+	st.flushes++
+	st.lines += uint64(fs.Lines)
+	st.lastFlush = fs.Latency
+	if fs.Latency > st.maxFlush {
+		st.maxFlush = fs.Latency
+	}
+	if fs.Lines > st.maxBatched {
+		st.maxBatched = fs.Lines
+	}
+	st.mu.Unlock()
+
+	u.mu.Lock()
+	hook := u.onFlush
+	u.mu.Unlock()
+	if hook != nil {
+		hook(fs)
+	}
+}
+
+// GetWriterStats returns the upstream writer totals and current queue depth
+func (u *Upstream) GetWriterStats() WriterStats {
+	u.mu.Lock()
+	queued, capacity := len(u.wq), cap(u.wq)
+	u.mu.Unlock()
+
+	st := &u.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := WriterStats{
+		Queued:      queued,
+		Capacity:    capacity,
+		Flushes:     st.flushes,
+		Lines:       st.lines,
+		MaxBatch:    st.maxBatched,
+		Blocked:     st.blocked,
+		LastFlushMs: float64(st.lastFlush.Microseconds()) / 1000,
+		MaxFlushMs:  float64(st.maxFlush.Microseconds()) / 1000,
+	}
+	if st.flushes > 0 {
+		out.AvgBatch = float64(st.lines) / float64(st.flushes)
+	}
+	return out
+}
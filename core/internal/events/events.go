@@ -0,0 +1,296 @@
+// Package events delivers significant proxy events to webhooks
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	UpstreamUp         = "upstream_up"
+	UpstreamDown       = "upstream_down"
+	UpstreamFailover   = "upstream_failover"
+	WorkerConnected    = "worker_connected"
+	WorkerDisconnected = "worker_disconnected"
+	BanIssued          = "ban_issued"
+	RejectRateHigh     = "reject_rate_high"
+	RejectRateNormal   = "reject_rate_normal"
+	ClientThrottled    = "client_throttled"
+	ClientShed         = "client_shed"
+	UpstreamScheduled  = "upstream_scheduled"
+	BlockFound         = "block_found"
+	UpstreamAuthFailed = "upstream_auth_failed"
+	GoroutineLeak      = "goroutine_leak"
+)
+
+// Types lists every event type
+var Types = []string{
+	UpstreamUp, UpstreamDown, UpstreamFailover, WorkerConnected,
+	WorkerDisconnected, BanIssued, RejectRateHigh, RejectRateNormal,
+	ClientThrottled, ClientShed, UpstreamScheduled, BlockFound,
+	UpstreamAuthFailed, GoroutineLeak,
+}
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body as "sha256=<hex>"
+const SignatureHeader = "X-Karoo-Signature"
+
+// queueSize bounds the events waiting for delivery
+const queueSize = 256
+
+// WebhookConfig is one webhook endpoint
+type WebhookConfig struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"` // signs the body when set
+	Events []string `json:"events"` // empty subscribes to every event
+}
+
+// wants reports whether the webhook subscribes to an event type
+func (w WebhookConfig) wants(typ string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds event notification configuration
+type Config struct {
+	Webhooks   []WebhookConfig `json:"webhooks"`
+	MaxRetries int             `json:"max_retries"`
+	TimeoutMs  int             `json:"timeout_ms"`
+	// RejectRatePct raises reject_rate_high when the rejected share of the
+	// last RejectWindow submits reaches it; 0 disables the check
+	RejectRatePct float64 `json:"reject_rate_pct"`
+	RejectWindow  int     `json:"reject_window"`
+}
+
+// Event is the JSON body posted to webhooks
+type Event struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Dispatcher queues events and posts them to the subscribed webhooks
+type Dispatcher struct {
+	mu     sync.Mutex
+	cfg    *Config
+	client *http.Client
+
+	queue chan Event
+
+	// rolling submit outcomes for the reject rate check
+	outcomes []bool
+	next     int
+	filled   bool
+	rejects  int
+	breached bool
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// New creates a new event dispatcher
+func New(cfg *Config) *Dispatcher {
+	d := &Dispatcher{queue: make(chan Event, queueSize)}
+	d.UpdateConfig(cfg)
+	return d
+}
+
+// UpdateConfig updates the dispatcher configuration. Changing the reject
+// window restarts the reject rate check.
+func (d *Dispatcher) UpdateConfig(cfg *Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	d.cfg = cfg
+	d.client = &http.Client{Timeout: timeout}
+	if cfg.RejectWindow != len(d.outcomes) {
+		d.outcomes = make([]bool, cfg.RejectWindow)
+		d.next, d.filled, d.rejects, d.breached = 0, false, 0, false
+	}
+}
+
+// Enabled reports whether any webhook is configured
+func (d *Dispatcher) Enabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.cfg.Webhooks) > 0
+}
+
+// Emit queues an event for delivery. It never blocks; events are dropped
+// when no webhook is configured or the queue is full.
+func (d *Dispatcher) Emit(typ string, data map[string]interface{}) {
+	if !d.Enabled() {
+		return
+	}
+	select {
+	case d.queue <- Event{Type: typ, Time: time.Now().UTC(), Data: data}:
+	default:
+		d.dropped.Add(1)
+		log.Printf("events: queue full, dropping %s", typ)
+	}
+}
+
+// ObserveShare feeds a submit outcome into the reject rate check and emits
+// reject_rate_high or reject_rate_normal when the rate crosses the threshold
+func (d *Dispatcher) ObserveShare(accepted bool) {
+	d.mu.Lock()
+	if d.cfg.RejectRatePct <= 0 || len(d.outcomes) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	if d.filled && !d.outcomes[d.next] {
+		d.rejects--
+	}
+	d.outcomes[d.next] = accepted
+	if !accepted {
+		d.rejects++
+	}
+	d.next = (d.next + 1) % len(d.outcomes)
+	if d.next == 0 {
+		d.filled = true
+	}
+	if !d.filled {
+		d.mu.Unlock()
+		return
+	}
+	rate := float64(d.rejects) * 100 / float64(len(d.outcomes))
+	threshold := d.cfg.RejectRatePct
+	var typ string
+	switch {
+	case rate >= threshold && !d.breached:
+		d.breached, typ = true, RejectRateHigh
+	case rate < threshold && d.breached:
+		d.breached, typ = false, RejectRateNormal
+	}
+	d.mu.Unlock()
+
+	if typ != "" {
+		log.Printf("events: reject rate %.1f%% over the last %d submits (threshold %.1f%%)", rate, len(d.outcomes), threshold)
+		d.Emit(typ, map[string]interface{}{
+			"reject_rate_pct": rate,
+			"threshold_pct":   threshold,
+			"window":          len(d.outcomes),
+		})
+	}
+}
+
+// Run delivers queued events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-d.queue:
+			d.deliver(ctx, ev)
+		}
+	}
+}
+
+// deliver posts an event to every subscribed webhook
+func (d *Dispatcher) deliver(ctx context.Context, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("events: encoding %s: %v", ev.Type, err)
+		return
+	}
+	d.mu.Lock()
+	hooks, retries, client := d.cfg.Webhooks, d.cfg.MaxRetries, d.client
+	d.mu.Unlock()
+
+	for _, wh := range hooks {
+		if !wh.wants(ev.Type) {
+			continue
+		}
+		if err := post(ctx, client, wh, ev.Type, body, retries); err != nil {
+			d.failed.Add(1)
+			log.Printf("events: %s to %s failed: %v", ev.Type, wh.URL, err)
+			continue
+		}
+		d.delivered.Add(1)
+	}
+}
+
+// post sends body to the webhook, retrying with exponential backoff
+func post(ctx context.Context, client *http.Client, wh WebhookConfig, typ string, body []byte, retries int) error {
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = postOnce(ctx, client, wh, typ, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// postOnce makes a single delivery attempt
+func postOnce(ctx context.Context, client *http.Client, wh WebhookConfig, typ string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Karoo-Event", typ)
+	if wh.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(wh.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GetStats returns delivery counters for /status
+func (d *Dispatcher) GetStats() map[string]interface{} {
+	d.mu.Lock()
+	webhooks, breached := len(d.cfg.Webhooks), d.breached
+	d.mu.Unlock()
+	return map[string]interface{}{
+		"webhooks":          webhooks,
+		"queued":            len(d.queue),
+		"delivered":         d.delivered.Load(),
+		"failed":            d.failed.Load(),
+		"dropped":           d.dropped.Load(),
+		"reject_rate_alarm": breached,
+	}
+}
@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverSignedWithRetry(t *testing.T) {
+	var calls atomic.Int32
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	d := New(&Config{
+		Webhooks: []WebhookConfig{
+			{URL: srv.URL, Secret: "s3cret", Events: []string{UpstreamDown}},
+			{URL: srv.URL + "/unused", Events: []string{BanIssued}},
+		},
+		MaxRetries: 2,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Emit(UpstreamDown, map[string]interface{}{"host": "pool.example.com"})
+
+	select {
+	case r := <-got:
+		body := <-bodies
+		if r.Header.Get(SignatureHeader) != "sha256="+Sign("s3cret", body) {
+			t.Errorf("bad signature header %q", r.Header.Get(SignatureHeader))
+		}
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil || ev.Type != UpstreamDown || ev.Data["host"] != "pool.example.com" {
+			t.Errorf("unexpected event %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not retried")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("webhook called %d times, want 2", calls.Load())
+	}
+}
+
+func TestEmitWithoutWebhooks(t *testing.T) {
+	d := New(&Config{})
+	d.Emit(UpstreamUp, nil)
+	if len(d.queue) != 0 {
+		t.Error("events queued with no webhook configured")
+	}
+}
+
+func TestRejectRate(t *testing.T) {
+	d := New(&Config{Webhooks: []WebhookConfig{{URL: "http://127.0.0.1:0"}}, RejectRatePct: 50, RejectWindow: 4})
+
+	for _, ok := range []bool{false, false, false} {
+		d.ObserveShare(ok)
+	}
+	if len(d.queue) != 0 {
+		t.Fatal("alarm raised before the window filled")
+	}
+	d.ObserveShare(true) // 3/4 rejected
+	if ev := <-d.queue; ev.Type != RejectRateHigh {
+		t.Fatalf("got %s, want %s", ev.Type, RejectRateHigh)
+	}
+	d.ObserveShare(false) // still 3/4
+	if len(d.queue) != 0 {
+		t.Fatal("alarm repeated")
+	}
+	d.ObserveShare(true) // 2/4: at the threshold
+	if len(d.queue) != 0 {
+		t.Fatal("alarm cleared at the threshold")
+	}
+	d.ObserveShare(true) // 1/4
+	if ev := <-d.queue; ev.Type != RejectRateNormal {
+		t.Fatalf("got %s, want %s", ev.Type, RejectRateNormal)
+	}
+}
@@ -0,0 +1,172 @@
+// Package history keeps a short in-memory time series of proxy activity for
+// dashboards that have no Prometheus to query
+package history
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Config holds the series resolution and retention
+type Config struct {
+	Enabled           bool `json:"enabled"`
+	ResolutionSeconds int  `json:"resolution_seconds"` // bucket width; default 60
+	RetentionHours    int  `json:"retention_hours"`    // span kept; default 24
+}
+
+// Validate checks the bucket layout
+func (c *Config) Validate() error {
+	if c.ResolutionSeconds < 0 || c.RetentionHours < 0 {
+		return fmt.Errorf("resolution_seconds and retention_hours must not be negative")
+	}
+	if n := c.buckets(); n > 100000 {
+		return fmt.Errorf("retention_hours / resolution_seconds gives %d buckets, at most 100000 allowed", n)
+	}
+	return nil
+}
+
+func (c *Config) resolution() time.Duration {
+	if c.ResolutionSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.ResolutionSeconds) * time.Second
+}
+
+func (c *Config) buckets() int {
+	hours := c.RetentionHours
+	if hours <= 0 {
+		hours = 24
+	}
+	n := int(time.Duration(hours) * time.Hour / c.resolution())
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Point is one bucket of the series
+type Point struct {
+	Time          int64   `json:"time"` // bucket start, unix seconds
+	Accepted      uint64  `json:"accepted"`
+	Rejected      uint64  `json:"rejected"`
+	RejectPercent float64 `json:"reject_percent"`
+	HashrateHs    float64 `json:"hashrate_hs"` // from accepted difficulty
+	Clients       int64   `json:"clients"`     // most connected at once
+}
+
+// bucket accumulates one resolution step
+type bucket struct {
+	n        int64 // bucket number, unix time / resolution
+	accepted uint64
+	rejected uint64
+	work     float64
+	clients  int64
+}
+
+// Series is a ring of buckets
+type Series struct {
+	mu   sync.Mutex
+	cfg  *Config
+	res  time.Duration
+	ring []bucket
+}
+
+// New creates an empty series
+func New(cfg *Config) *Series {
+	s := &Series{}
+	s.UpdateConfig(cfg)
+	return s
+}
+
+// UpdateConfig applies a new layout; a changed layout starts over
+func (s *Series) UpdateConfig(cfg *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	if res, n := cfg.resolution(), cfg.buckets(); res != s.res || n != len(s.ring) {
+		s.res = res
+		s.ring = make([]bucket, n)
+	}
+}
+
+// at returns the bucket of t, clearing it when it held an older step; nil
+// when t is older than the step the slot holds
+func (s *Series) at(t time.Time) *bucket {
+	n := t.UnixNano() / int64(s.res)
+	b := &s.ring[int(n%int64(len(s.ring)))]
+	if b.n > n {
+		return nil
+	}
+	if b.n != n {
+		*b = bucket{n: n}
+	}
+	return b
+}
+
+// AddShare records a submit outcome and, when accepted, its difficulty
+func (s *Series) AddShare(t time.Time, accepted bool, diff float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cfg.Enabled {
+		return
+	}
+	b := s.at(t)
+	if b == nil {
+		return
+	}
+	if accepted {
+		b.accepted++
+		b.work += diff
+	} else {
+		b.rejected++
+	}
+}
+
+// SampleClients records the number of connected clients
+func (s *Series) SampleClients(t time.Time, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cfg.Enabled {
+		return
+	}
+	if b := s.at(t); b != nil && n > b.clients {
+		b.clients = n
+	}
+}
+
+// Points returns the buckets from since to now, oldest first, with empty
+// steps filled in. At most limit points are returned when limit > 0.
+func (s *Series) Points(now, since time.Time, limit int) []Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := now.UnixNano() / int64(s.res)
+	first := last - int64(len(s.ring)) + 1
+	if n := since.UnixNano() / int64(s.res); n > first {
+		first = n
+	}
+	if limit > 0 && last-first+1 > int64(limit) {
+		first = last - int64(limit) + 1
+	}
+	secs := s.res.Seconds()
+	out := make([]Point, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		pt := Point{Time: time.Unix(0, n*int64(s.res)).Unix()}
+		if b := s.ring[int(n%int64(len(s.ring)))]; b.n == n {
+			pt.Accepted, pt.Rejected, pt.Clients = b.accepted, b.rejected, b.clients
+			pt.HashrateHs = b.work * 4294967296 / secs
+			if total := b.accepted + b.rejected; total > 0 {
+				pt.RejectPercent = float64(b.rejected) * 100 / float64(total)
+			}
+		}
+		out = append(out, pt)
+	}
+	return out
+}
+
+// Resolution returns the bucket width
+func (s *Series) Resolution() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.res
+}
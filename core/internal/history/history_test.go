@@ -0,0 +1,75 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	s := New(&Config{Enabled: true, ResolutionSeconds: 60, RetentionHours: 1})
+	base := time.Unix(1_700_000_040, 0) // minute aligned
+
+	s.AddShare(base, true, 1)
+	s.AddShare(base.Add(10*time.Second), true, 2)
+	s.AddShare(base.Add(20*time.Second), false, 4)
+	s.SampleClients(base, 3)
+	s.SampleClients(base.Add(30*time.Second), 2)
+	s.AddShare(base.Add(2*time.Minute), true, 1)
+
+	got := s.Points(base.Add(2*time.Minute), base, 0)
+	if len(got) != 3 {
+		t.Fatalf("points = %+v", got)
+	}
+	first := got[0]
+	if first.Time != base.Unix() || first.Accepted != 2 || first.Rejected != 1 || first.Clients != 3 {
+		t.Fatalf("first bucket = %+v", first)
+	}
+	if first.RejectPercent < 33.3 || first.RejectPercent > 33.4 {
+		t.Fatalf("reject percent = %v", first.RejectPercent)
+	}
+	if want := 3 * 4294967296.0 / 60; first.HashrateHs != want {
+		t.Fatalf("hashrate = %v, want %v", first.HashrateHs, want)
+	}
+	if got[1].Accepted != 0 || got[2].Accepted != 1 {
+		t.Fatalf("later buckets = %+v", got[1:])
+	}
+
+	// limit keeps the newest points
+	if got := s.Points(base.Add(2*time.Minute), base, 1); len(got) != 1 || got[0].Accepted != 1 {
+		t.Fatalf("limited points = %+v", got)
+	}
+
+	// an hour later the ring has wrapped and the old minutes are gone
+	later := base.Add(time.Hour + 2*time.Minute)
+	s.AddShare(later, true, 1)
+	got = s.Points(later, time.Time{}, 0)
+	if len(got) != 60 {
+		t.Fatalf("retained %d points, want 60", len(got))
+	}
+	var accepted uint64
+	for _, pt := range got {
+		accepted += pt.Accepted
+	}
+	if accepted != 1 {
+		t.Fatalf("accepted after wrap = %d, want 1", accepted)
+	}
+
+	// disabled series record nothing
+	s.UpdateConfig(&Config{ResolutionSeconds: 60, RetentionHours: 1})
+	s.AddShare(later, true, 1)
+	if got := s.Points(later, later, 0); got[0].Accepted != 1 {
+		t.Fatalf("disabled series recorded: %+v", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (&Config{ResolutionSeconds: -1}).Validate(); err == nil {
+		t.Fatal("negative resolution accepted")
+	}
+	if err := (&Config{ResolutionSeconds: 1, RetentionHours: 48}).Validate(); err == nil {
+		t.Fatal("oversized ring accepted")
+	}
+	if err := (&Config{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package idle detects connected workers that stopped submitting accepted shares
+package idle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config holds idle watchdog configuration
+type Config struct {
+	Enabled              bool   `json:"enabled"`
+	AfterMinutes         int    `json:"after_minutes"`
+	CheckIntervalSeconds int    `json:"check_interval_seconds"`
+	WebhookURL           string `json:"webhook_url"` // optional; receives a JSON POST per alert
+}
+
+// after returns the silence that marks a worker idle
+func (c *Config) after() time.Duration {
+	if c.AfterMinutes <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.AfterMinutes) * time.Minute
+}
+
+// interval returns how often workers are checked
+func (c *Config) interval() time.Duration {
+	if c.CheckIntervalSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.CheckIntervalSeconds) * time.Second
+}
+
+// Worker is a connected worker as seen by the watchdog
+type Worker struct {
+	Name string
+	Addr string
+	// LastShare is the last accepted share, or the connection time when the
+	// worker has none yet
+	LastShare time.Time
+}
+
+// key identifies one worker session
+func (w Worker) key() string {
+	return w.Addr + "/" + w.Name
+}
+
+// Alert events
+const (
+	EventIdle    = "worker_idle"
+	EventResumed = "worker_resumed"
+)
+
+// Alert describes a worker going idle or resuming
+type Alert struct {
+	Event       string    `json:"event"`
+	Worker      string    `json:"worker"`
+	Addr        string    `json:"addr"`
+	LastShare   time.Time `json:"last_share"`
+	IdleSeconds float64   `json:"idle_seconds"`
+}
+
+// Watchdog tracks which connected workers are idle
+type Watchdog struct {
+	cfg *Config
+
+	mu   sync.Mutex
+	idle map[string]Alert
+
+	client *http.Client
+}
+
+// New creates a new idle watchdog
+func New(cfg *Config) *Watchdog {
+	return &Watchdog{
+		cfg:    cfg,
+		idle:   make(map[string]Alert),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// UpdateConfig updates the watchdog configuration
+func (w *Watchdog) UpdateConfig(cfg *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cfg = cfg
+}
+
+// Check compares the connected workers against the idle threshold and
+// returns the workers that went idle or resumed since the last check.
+// Disconnected workers are forgotten without an alert.
+func (w *Watchdog) Check(now time.Time, workers []Worker) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	after := w.cfg.after()
+	seen := make(map[string]bool, len(workers))
+	var out []Alert
+	for _, wk := range workers {
+		k := wk.key()
+		seen[k] = true
+		silent := now.Sub(wk.LastShare)
+		_, wasIdle := w.idle[k]
+		a := Alert{Worker: wk.Name, Addr: wk.Addr, LastShare: wk.LastShare, IdleSeconds: silent.Seconds()}
+		switch {
+		case silent >= after:
+			a.Event = EventIdle
+			w.idle[k] = a
+			if !wasIdle {
+				out = append(out, a)
+			}
+		case wasIdle:
+			delete(w.idle, k)
+			a.Event = EventResumed
+			out = append(out, a)
+		}
+	}
+	for k := range w.idle {
+		if !seen[k] {
+			delete(w.idle, k)
+		}
+	}
+	return out
+}
+
+// Idle returns the workers currently considered idle, longest silence first
+func (w *Watchdog) Idle() []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Alert, 0, len(w.idle))
+	for _, a := range w.idle {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IdleSeconds > out[j].IdleSeconds })
+	return out
+}
+
+// Notify logs the alert and posts it to the configured webhook, if any
+func (w *Watchdog) Notify(ctx context.Context, a Alert) {
+	if a.Event == EventIdle {
+		log.Printf("idle: worker %s (%s) has no accepted share for %s", a.Worker, a.Addr, time.Duration(a.IdleSeconds*float64(time.Second)).Round(time.Second))
+	} else {
+		log.Printf("idle: worker %s (%s) resumed submitting", a.Worker, a.Addr)
+	}
+
+	w.mu.Lock()
+	url := w.cfg.WebhookURL
+	w.mu.Unlock()
+	if url == "" {
+		return
+	}
+	if err := w.post(ctx, url, a); err != nil {
+		log.Printf("idle: webhook failed: %v", err)
+	}
+}
+
+// post sends the alert as JSON to url
+func (w *Watchdog) post(ctx context.Context, url string, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Run checks the workers returned by list every interval until ctx is done.
+// onCheck receives the number of idle workers after each check.
+func (w *Watchdog) Run(ctx context.Context, list func() []Worker, onCheck func(idle int)) {
+	if !w.cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(w.cfg.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, a := range w.Check(now, list()) {
+				go w.Notify(ctx, a)
+			}
+			if onCheck != nil {
+				w.mu.Lock()
+				n := len(w.idle)
+				w.mu.Unlock()
+				onCheck(n)
+			}
+		}
+	}
+}
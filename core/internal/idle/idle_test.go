@@ -0,0 +1,66 @@
+package idle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	w := New(&Config{Enabled: true, AfterMinutes: 5})
+	now := time.Now()
+	rig1 := Worker{Name: "rig1", Addr: "10.0.0.1:4000", LastShare: now.Add(-6 * time.Minute)}
+	rig2 := Worker{Name: "rig2", Addr: "10.0.0.2:4000", LastShare: now.Add(-time.Minute)}
+
+	alerts := w.Check(now, []Worker{rig1, rig2})
+	if len(alerts) != 1 || alerts[0].Event != EventIdle || alerts[0].Worker != "rig1" {
+		t.Fatalf("expected rig1 to go idle, got %+v", alerts)
+	}
+
+	// Still idle: no repeated alert
+	if alerts := w.Check(now.Add(time.Minute), []Worker{rig1, rig2}); len(alerts) != 0 {
+		t.Errorf("idle alert repeated: %+v", alerts)
+	}
+	if idle := w.Idle(); len(idle) != 1 || idle[0].IdleSeconds < 420 {
+		t.Errorf("idle list not refreshed: %+v", idle)
+	}
+
+	rig1.LastShare = now.Add(2 * time.Minute)
+	alerts = w.Check(now.Add(2*time.Minute), []Worker{rig1, rig2})
+	if len(alerts) != 1 || alerts[0].Event != EventResumed {
+		t.Fatalf("expected rig1 to resume, got %+v", alerts)
+	}
+
+	// Disconnected workers are forgotten silently
+	w.Check(now.Add(10*time.Minute), []Worker{rig2})
+	if alerts := w.Check(now.Add(11*time.Minute), nil); len(alerts) != 0 || len(w.Idle()) != 0 {
+		t.Errorf("disconnected worker still tracked: %+v %+v", alerts, w.Idle())
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	got := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		got <- a
+	}))
+	defer srv.Close()
+
+	w := New(&Config{Enabled: true, WebhookURL: srv.URL})
+	w.Notify(context.Background(), Alert{Event: EventIdle, Worker: "rig1", IdleSeconds: 600})
+
+	select {
+	case a := <-got:
+		if a.Event != EventIdle || a.Worker != "rig1" {
+			t.Errorf("unexpected webhook payload: %+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
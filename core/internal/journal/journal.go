@@ -0,0 +1,182 @@
+// Package journal appends every share outcome to a rotating log file for
+// offline reconciliation against pool-side accounting
+package journal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Supported journal formats
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Config holds share journal configuration
+type Config struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	Format    string `json:"format"`      // "ndjson" (default) or "csv"
+	MaxSizeMB int    `json:"max_size_mb"` // rotate when exceeded; 0 = never
+	MaxFiles  int    `json:"max_files"`   // rotated files kept (path.1 … path.N)
+}
+
+// Entry is a single journaled share
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Worker     string    `json:"worker"`
+	Session    string    `json:"session,omitempty"` // connection the share came from
+	JobID      string    `json:"job_id"`
+	Difficulty float64   `json:"difficulty"`
+	Result     string    `json:"result"` // "accepted" or "rejected"
+	LatencyMs  int64     `json:"latency_ms"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+var csvHeader = []string{"time", "worker", "job_id", "difficulty", "result", "latency_ms", "reason", "session"}
+
+// Journal writes entries to the configured file
+type Journal struct {
+	mu   sync.Mutex
+	cfg  *Config
+	f    *os.File
+	path string
+	size int64
+}
+
+// New creates a share journal; the file is opened on the first entry
+func New(cfg *Config) *Journal {
+	return &Journal{cfg: cfg}
+}
+
+// UpdateConfig updates the configuration; a new path takes effect on the
+// next entry
+func (j *Journal) UpdateConfig(cfg *Config) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !cfg.Enabled || cfg.Path != j.path || cfg.Format != j.cfg.Format {
+		j.closeLocked()
+	}
+	j.cfg = cfg
+}
+
+// Record appends an entry, rotating the file when it grows past the limit
+func (j *Journal) Record(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.cfg.Enabled || j.cfg.Path == "" {
+		return nil
+	}
+
+	line, err := j.encode(e)
+	if err != nil {
+		return err
+	}
+	if j.f != nil && j.cfg.MaxSizeMB > 0 && j.size+int64(len(line)) > int64(j.cfg.MaxSizeMB)<<20 {
+		j.closeLocked()
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	if j.f == nil {
+		if err := j.open(); err != nil {
+			return err
+		}
+	}
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.closeLocked()
+}
+
+func (j *Journal) closeLocked() error {
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	j.size = 0
+	return err
+}
+
+// open opens (or creates) the journal file, writing the CSV header for a new file
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening share journal: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	j.f, j.path, j.size = f, j.cfg.Path, st.Size()
+	if j.size == 0 && j.cfg.Format == FormatCSV {
+		line, _ := csvLine(csvHeader)
+		n, err := j.f.Write(line)
+		j.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+// rotate shifts path → path.1 → … → path.N, dropping the oldest
+func (j *Journal) rotate() error {
+	keep := j.cfg.MaxFiles
+	if keep <= 0 {
+		keep = 1
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", j.cfg.Path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", j.cfg.Path, i), fmt.Sprintf("%s.%d", j.cfg.Path, i+1))
+	}
+	if err := os.Rename(j.cfg.Path, j.cfg.Path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("rotating share journal: %w", err)
+	}
+	return nil
+}
+
+// encode renders an entry in the configured format, newline terminated
+func (j *Journal) encode(e Entry) ([]byte, error) {
+	if j.cfg.Format == FormatCSV {
+		return csvLine([]string{
+			e.Time.UTC().Format(time.RFC3339Nano),
+			e.Worker,
+			e.JobID,
+			strconv.FormatFloat(e.Difficulty, 'g', -1, 64),
+			e.Result,
+			strconv.FormatInt(e.LatencyMs, 10),
+			e.Reason,
+			e.Session,
+		})
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// csvLine encodes a single CSV record
+func csvLine(fields []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(fields); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,93 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+func TestRecordNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.log")
+	j := New(&Config{Enabled: true, Path: path, Format: FormatNDJSON})
+	defer j.Close()
+
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := j.Record(Entry{Time: ts, Worker: "rig1", JobID: "a1", Difficulty: 1024, Result: "accepted", LatencyMs: 42}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := j.Record(Entry{Time: ts, Worker: "rig1", Session: "65f0-7", JobID: "a2", Difficulty: 1024, Result: "rejected", Reason: "Stale share"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	lines := readLines(t, path)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var e Entry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if e.JobID != "a2" || e.Result != "rejected" || e.Reason != "Stale share" || e.Session != "65f0-7" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestRecordCSVAndRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.csv")
+	j := New(&Config{Enabled: true, Path: path, Format: FormatCSV, MaxSizeMB: 1, MaxFiles: 2})
+	defer j.Close()
+
+	e := Entry{Time: time.Now(), Worker: "rig,1", Session: "65f0-1", JobID: "j", Difficulty: 1, Result: "accepted", Reason: strings.Repeat("x", 4096)}
+	for i := 0; i < 600; i++ {
+		if err := j.Record(e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated file: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d rotated files", 2)
+	}
+	lines := readLines(t, path)
+	if len(lines) == 0 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Errorf("rotated file should start with the CSV header")
+	}
+	if !strings.HasPrefix(strings.SplitN(lines[1], ",", 2)[1], `"rig,1"`) {
+		t.Errorf("worker field not quoted: %s", lines[1][:60])
+	}
+	if !strings.HasSuffix(lines[1], ",65f0-1") {
+		t.Errorf("session is not the last column: %s", lines[1][len(lines[1])-40:])
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.log")
+	j := New(&Config{Enabled: false, Path: path})
+	if err := j.Record(Entry{Worker: "rig1"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("disabled journal should not create a file")
+	}
+}
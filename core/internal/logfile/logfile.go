@@ -0,0 +1,276 @@
+// Package logfile writes the log to a file and rotates it by size and
+// schedule, keeping a bounded number of optionally compressed backups
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files; it sorts chronologically
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Config holds the log file and its rotation settings
+type Config struct {
+	File          string `json:"file"`           // empty logs to stderr only
+	MaxSizeMB     int    `json:"max_size_mb"`    // rotate above this size; 0 is unlimited
+	IntervalHours int    `json:"interval_hours"` // also rotate every N hours (24 is daily); 0 is off
+	MaxBackups    int    `json:"max_backups"`    // rotated files kept; 0 keeps all
+	MaxAgeDays    int    `json:"max_age_days"`   // rotated files older than this are removed; 0 keeps all
+	Compress      bool   `json:"compress"`       // gzip rotated files
+	Stderr        bool   `json:"stderr"`         // also write to stderr
+}
+
+// Validate checks the rotation limits
+func (c *Config) Validate() error {
+	if c.MaxSizeMB < 0 || c.IntervalHours < 0 || c.MaxBackups < 0 || c.MaxAgeDays < 0 {
+		return errors.New("max_size_mb, interval_hours, max_backups and max_age_days must not be negative")
+	}
+	return nil
+}
+
+// Writer is an io.Writer for the log package that rotates its file
+type Writer struct {
+	mu      sync.Mutex
+	cfg     Config
+	f       *os.File
+	size    int64
+	period  int64 // interval number the open file belongs to
+	millMu  sync.Mutex
+	milling sync.WaitGroup
+	nowFunc func() time.Time
+}
+
+// New creates a writer; the file is opened on the first write
+func New(cfg *Config) *Writer {
+	return &Writer{cfg: *cfg, nowFunc: time.Now}
+}
+
+// UpdateConfig applies new settings; a changed file is opened on the next
+// write
+func (w *Writer) UpdateConfig(cfg *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cfg.File != w.cfg.File {
+		w.closeFile()
+	}
+	w.cfg = *cfg
+}
+
+// Write appends p to the file, rotating it first when p would exceed the
+// size limit or a new interval has started. While the file cannot be opened
+// the log goes to stderr.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cfg.Stderr || w.cfg.File == "" {
+		_, _ = os.Stderr.Write(p)
+	}
+	if w.cfg.File == "" {
+		return len(p), nil
+	}
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			if !w.cfg.Stderr {
+				_, _ = os.Stderr.Write(p)
+			}
+			return len(p), err
+		}
+	}
+	now := w.nowFunc()
+	if (w.cfg.MaxSizeMB > 0 && w.size > 0 && w.size+int64(len(p)) > int64(w.cfg.MaxSizeMB)<<20) ||
+		(w.cfg.IntervalHours > 0 && w.periodOf(now) != w.period) {
+		if err := w.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file, for use after an external tool moved it
+func (w *Writer) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeFile()
+	if w.cfg.File == "" {
+		return nil
+	}
+	return w.open()
+}
+
+// Rotate moves the current file aside and starts a new one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cfg.File == "" {
+		return nil
+	}
+	return w.rotate(w.nowFunc())
+}
+
+// Close closes the file once pending compression is done
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	w.closeFile()
+	w.mu.Unlock()
+	w.milling.Wait()
+	return nil
+}
+
+func (w *Writer) periodOf(t time.Time) int64 {
+	if w.cfg.IntervalHours <= 0 {
+		return 0
+	}
+	return t.Unix() / int64(w.cfg.IntervalHours*3600)
+}
+
+// open opens the file for appending; an existing file keeps its interval
+// from its modification time
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.File), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+	w.period = w.periodOf(w.nowFunc())
+	if w.size > 0 {
+		w.period = w.periodOf(info.ModTime())
+	}
+	return nil
+}
+
+func (w *Writer) closeFile() {
+	if w.f != nil {
+		_ = w.f.Close()
+		w.f = nil
+	}
+}
+
+// rotate renames the file to a timestamped backup, opens a new one and
+// compresses and prunes backups in the background
+func (w *Writer) rotate(now time.Time) error {
+	w.closeFile()
+	backup := w.backupName(now)
+	if err := os.Rename(w.cfg.File, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.period = w.periodOf(now)
+	w.milling.Add(1)
+	go w.mill(w.cfg, backup, now)
+	return nil
+}
+
+// backupName returns the rotated name of the file: karoo.log becomes
+// karoo-<time>.log
+func (w *Writer) backupName(t time.Time) string {
+	dir, base := filepath.Split(w.cfg.File)
+	ext := filepath.Ext(base)
+	return filepath.Join(dir, strings.TrimSuffix(base, ext)+"-"+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+// mill compresses the new backup and removes those beyond max_backups or
+// max_age_days
+func (w *Writer) mill(cfg Config, backup string, now time.Time) {
+	defer w.milling.Done()
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+	if cfg.Compress {
+		if err := compress(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "logfile: compressing %s: %v\n", backup, err)
+		}
+	}
+	backups, err := listBackups(cfg.File)
+	if err != nil {
+		return
+	}
+	for i, b := range backups {
+		if (cfg.MaxBackups > 0 && i >= cfg.MaxBackups) ||
+			(cfg.MaxAgeDays > 0 && now.Sub(b.t) > time.Duration(cfg.MaxAgeDays)*24*time.Hour) {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+// backupFile is a rotated file and the time in its name
+type backupFile struct {
+	path string
+	t    time.Time
+}
+
+// listBackups returns the rotated files of file, newest first
+func listBackups(file string) ([]backupFile, error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []backupFile
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		out = append(out, backupFile{path: filepath.Join(dir, name), t: t})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].t.After(out[j].t) })
+	return out, nil
+}
+
+// compress gzips path to path.gz and removes the original
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,96 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSizeRotation(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "karoo.log")
+	w := New(&Config{File: file, MaxSizeMB: 1, MaxBackups: 2, Compress: true})
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.nowFunc = func() time.Time { now = now.Add(time.Second); return now }
+
+	chunk := strings.Repeat("x", 700<<10)
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	backups, err := listBackups(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %+v, want the newest 2", backups)
+	}
+	if !strings.HasSuffix(backups[0].path, ".log.gz") || !backups[0].t.After(backups[1].t) {
+		t.Errorf("newest backup = %+v", backups[0])
+	}
+	f, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != chunk {
+		t.Errorf("backup holds %d bytes, want %d", len(b), len(chunk))
+	}
+	if info, _ := os.Stat(file); info.Size() != int64(len(chunk)) {
+		t.Errorf("current file is %d bytes", info.Size())
+	}
+}
+
+func TestIntervalRotationAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "karoo.log")
+	w := New(&Config{File: file, IntervalHours: 24, MaxAgeDays: 2})
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	w.nowFunc = func() time.Time { return now }
+
+	_, _ = w.Write([]byte("day one\n"))
+	now = now.Add(2 * time.Hour)
+	_, _ = w.Write([]byte("day two\n"))
+	_ = w.Close()
+	if b, _ := os.ReadFile(file); string(b) != "day two\n" {
+		t.Errorf("current file = %q", b)
+	}
+	backups, _ := listBackups(file)
+	if len(backups) != 1 {
+		t.Fatalf("backups = %+v", backups)
+	}
+
+	// old backups age out on the next rotation
+	now = now.Add(3 * 24 * time.Hour)
+	_, _ = w.Write([]byte("day five\n"))
+	_ = w.Close()
+	if backups, _ := listBackups(file); len(backups) != 1 || !backups[0].t.Equal(now) {
+		t.Errorf("backups after aging = %+v", backups)
+	}
+
+	// reopening follows a file moved by an external tool
+	_ = os.Rename(file, file+".1")
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("after move\n"))
+	_ = w.Close()
+	if b, _ := os.ReadFile(file); string(b) != "after move\n" {
+		t.Errorf("reopened file = %q", b)
+	}
+
+	if (&Config{MaxBackups: -1}).Validate() == nil {
+		t.Error("negative max_backups accepted")
+	}
+}
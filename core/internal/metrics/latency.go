@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default histogram buckets, in seconds
+var (
+	DefaultSubmitBuckets     = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DefaultFirstShareBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// exemplarMaxRunes bounds the label names and values of an exemplar, as
+// OpenMetrics requires
+const exemplarMaxRunes = 128
+
+// LatencyConfig sets up the share latency histograms
+type LatencyConfig struct {
+	// SubmitBuckets are the upper bounds, in seconds, of the histogram of
+	// submit round trips to the pool; empty uses DefaultSubmitBuckets
+	SubmitBuckets []float64 `json:"submit_buckets"`
+	// FirstShareBuckets are those of the time from a job arriving to the
+	// first share submitted on it; empty uses DefaultFirstShareBuckets
+	FirstShareBuckets []float64 `json:"first_share_buckets"`
+	// Exemplars attaches the worker and session of an observation to its
+	// bucket, served when /metrics is scraped as OpenMetrics
+	Exemplars bool `json:"exemplars"`
+}
+
+// Validate checks that the buckets are positive and ascending
+func (c *LatencyConfig) Validate() error {
+	for _, f := range []struct {
+		name    string
+		buckets []float64
+	}{{"submit_buckets", c.SubmitBuckets}, {"first_share_buckets", c.FirstShareBuckets}} {
+		for i, v := range f.buckets {
+			if v <= 0 || (i > 0 && v <= f.buckets[i-1]) {
+				return fmt.Errorf("%s must be positive and ascending", f.name)
+			}
+		}
+	}
+	return nil
+}
+
+// buckets returns b, or def when it is empty
+func buckets(b, def []float64) []float64 {
+	if len(b) == 0 {
+		return def
+	}
+	return b
+}
+
+// ConfigureLatency registers the share latency histograms. Buckets are
+// fixed by the first registration in the process, so changing them needs a
+// restart.
+func (m *Collector) ConfigureLatency(cfg *LatencyConfig) {
+	m.exemplars.Store(cfg.Exemplars)
+	m.Prom.SubmitLatency = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "karoo",
+		Name:      "share_submit_latency_seconds",
+		Help:      "Round trip of mining.submit from forwarding it to the pool's answer",
+		Buckets:   buckets(cfg.SubmitBuckets, DefaultSubmitBuckets),
+	})).(prometheus.Histogram)
+	m.Prom.FirstShareLatency = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "karoo",
+		Name:      "job_first_share_seconds",
+		Help:      "Time from a job arriving from the pool to the first share submitted on it",
+		Buckets:   buckets(cfg.FirstShareBuckets, DefaultFirstShareBuckets),
+	})).(prometheus.Histogram)
+}
+
+// ObserveSubmitLatency records the round trip of a submit a worker's
+// session sent
+func (m *Collector) ObserveSubmitLatency(d time.Duration, worker, session string) {
+	m.observe(m.Prom.SubmitLatency, d, worker, session)
+}
+
+// ObserveFirstShare records how long after a job arrived its first share
+// was submitted, by the given worker's session
+func (m *Collector) ObserveFirstShare(d time.Duration, worker, session string) {
+	m.observe(m.Prom.FirstShareLatency, d, worker, session)
+}
+
+// observe adds d to h, with an exemplar when they are enabled
+func (m *Collector) observe(h prometheus.Histogram, d time.Duration, worker, session string) {
+	if h == nil {
+		return
+	}
+	if !m.exemplars.Load() {
+		h.Observe(d.Seconds())
+		return
+	}
+	if labels := exemplar(worker, session); labels != nil {
+		if eo, ok := h.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(d.Seconds(), labels)
+			return
+		}
+	}
+	h.Observe(d.Seconds())
+}
+
+// exemplar labels an observation with its session and worker, cutting the
+// worker name to the OpenMetrics limit; nil when even the session does not
+// fit
+func exemplar(worker, session string) prometheus.Labels {
+	left := exemplarMaxRunes - len("session") - utf8.RuneCountInString(session)
+	if left < 0 {
+		return nil
+	}
+	labels := prometheus.Labels{"session": session}
+	left -= len("worker")
+	if worker == "" || left <= 0 {
+		return labels
+	}
+	if utf8.RuneCountInString(worker) > left {
+		worker = string([]rune(worker)[:left])
+	}
+	labels["worker"] = worker
+	return labels
+}
@@ -2,24 +2,101 @@
 package metrics
 
 import (
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// Upstream connection states
+const (
+	UpstreamDisconnected   = "disconnected"
+	UpstreamAuthenticating = "authenticating" // dialed, subscribe/authorize pending
+	UpstreamConnected      = "connected"      // subscribed and authorized
+)
+
 // Collector holds all proxy metrics
 type Collector struct {
-	// Connection metrics
+	// Connection metrics; UpConnected is set only once the upstream
+	// handshake succeeded
 	UpConnected   atomic.Bool
+	upState       atomic.Value // string
 	ClientsActive atomic.Int64
 
 	// Share metrics
 	SharesOK  atomic.Uint64
 	SharesBad atomic.Uint64
 
+	// Rejects by canonical category
+	rejectMu      sync.Mutex
+	rejectReasons map[string]uint64
+
+	// Upstream submit pipeline
+	SubmitsInFlight atomic.Int64
+	SubmitsQueued   atomic.Int64
+	SubmitsDropped  atomic.Uint64
+	RequestTimeouts atomic.Uint64
+
+	// Per-client outbound queues
+	ClientQueued         atomic.Int64
+	ClientQueueOverflows atomic.Uint64
+
+	// Client lines past proxy.max_line_bytes, and lines that were not
+	// JSON-RPC
+	ClientOversizedLines atomic.Uint64
+	ClientInvalidLines   atomic.Uint64
+
+	// Suspected goroutine leaks reported by the runtime watchdog
+	GoroutineLeaks atomic.Uint64
+
+	// Authorizes answered locally by authorize.fast_ack
+	AuthorizeFastAcks atomic.Uint64
+
+	// Same worker name seen from several addresses at once
+	DuplicateWorkers atomic.Uint64
+
+	// Actions taken by the share throttle
+	ThrottleActions atomic.Uint64
+
+	// Authorizations refused because the worker is pinned elsewhere
+	WorkerPinRejections atomic.Uint64
+
+	// Pool connections dropped because the pool went quiet
+	UpstreamStalls atomic.Uint64
+
+	// Pool connections dropped because the pool refused mining.authorize
+	UpstreamAuthRejections atomic.Uint64
+
+	// Connections refused by admission control, clients still to subscribe
+	// and clients closed for not subscribing in time
+	AdmissionRejections atomic.Uint64
+	PendingSubscribe    atomic.Int64
+	SubscribeTimeouts   atomic.Uint64
+
+	// Clients disconnected by load shedding
+	ClientsShed atomic.Uint64
+
+	// Shares meeting the network target
+	BlocksFound atomic.Uint64
+
+	// Jobs re-sent with a rolled ntime while the pool sent none, and
+	// whether such a gap is ongoing
+	JobRefreshes atomic.Uint64
+	NotifyGap    atomic.Bool
+
+	// Broadcasts skipped for clients that had not subscribed
+	BroadcastSuppressed atomic.Uint64
+
 	// Timing metrics
 	LastNotifyUnix atomic.Int64
 	LastSetDiff    atomic.Int64
 
+	// Per-upstream dial, handshake, submit and notify timing
+	upstreams upstreamLatencies
+
+	// attach worker and session exemplars to latency observations
+	exemplars atomic.Bool
+
 	// Prometheus collectors
 	Prom *PrometheusCollectors
 }
@@ -27,7 +104,8 @@ type Collector struct {
 // NewCollector creates a new metrics collector
 func NewCollector() *Collector {
 	return &Collector{
-		Prom: InitPrometheus("karoo"),
+		Prom:          InitPrometheus("karoo"),
+		rejectReasons: make(map[string]uint64),
 	}
 }
 
@@ -41,6 +119,21 @@ func (m *Collector) SetUpstreamConnected(connected bool) {
 	m.Prom.UpConnected.Set(val)
 }
 
+// SetUpstreamState records the upstream connection state; only
+// UpstreamConnected counts as connected
+func (m *Collector) SetUpstreamState(state string) {
+	m.upState.Store(state)
+	m.SetUpstreamConnected(state == UpstreamConnected)
+}
+
+// UpstreamState returns the upstream connection state
+func (m *Collector) UpstreamState() string {
+	if s, ok := m.upState.Load().(string); ok {
+		return s
+	}
+	return UpstreamDisconnected
+}
+
 // IsUpstreamConnected returns the upstream connection status
 func (m *Collector) IsUpstreamConnected() bool {
 	return m.UpConnected.Load()
@@ -67,12 +160,14 @@ func (m *Collector) GetClientsActive() int64 {
 func (m *Collector) IncrementSharesOK() {
 	m.SharesOK.Add(1)
 	m.Prom.SharesOK.Inc()
+	m.observeUpstreamShare(true)
 }
 
 // IncrementSharesBad increments the rejected shares counter
 func (m *Collector) IncrementSharesBad() {
 	m.SharesBad.Add(1)
 	m.Prom.SharesBad.Inc()
+	m.observeUpstreamShare(false)
 }
 
 // GetSharesOK returns the total accepted shares
@@ -90,10 +185,250 @@ func (m *Collector) GetTotalShares() uint64 {
 	return m.SharesOK.Load() + m.SharesBad.Load()
 }
 
+// IncrementRejectReason counts a rejected share under its canonical category
+func (m *Collector) IncrementRejectReason(worker, category string) {
+	m.rejectMu.Lock()
+	if m.rejectReasons == nil {
+		m.rejectReasons = make(map[string]uint64)
+	}
+	m.rejectReasons[category]++
+	m.rejectMu.Unlock()
+	m.Prom.RejectReasons.WithLabelValues(worker, category).Inc()
+}
+
+// GetRejectReasons returns rejected share totals per category
+func (m *Collector) GetRejectReasons() map[string]uint64 {
+	m.rejectMu.Lock()
+	defer m.rejectMu.Unlock()
+	out := make(map[string]uint64, len(m.rejectReasons))
+	for k, v := range m.rejectReasons {
+		out[k] = v
+	}
+	return out
+}
+
+// SetSubmitsInFlight records the number of submits awaiting an upstream response
+func (m *Collector) SetSubmitsInFlight(n int64) {
+	m.SubmitsInFlight.Store(n)
+	m.Prom.SubmitsInFlight.Set(float64(n))
+}
+
+// SetSubmitsQueued records the number of submits waiting for an in-flight slot
+func (m *Collector) SetSubmitsQueued(n int64) {
+	m.SubmitsQueued.Store(n)
+	m.Prom.SubmitsQueued.Set(float64(n))
+}
+
+// IncrementSubmitsDropped counts a submit refused because the queue was full
+func (m *Collector) IncrementSubmitsDropped() {
+	m.SubmitsDropped.Add(1)
+	m.Prom.SubmitsDropped.Inc()
+}
+
+// IncrementRequestTimeouts counts an upstream request expired without a
+// response
+func (m *Collector) IncrementRequestTimeouts(method string) {
+	m.RequestTimeouts.Add(1)
+	m.Prom.RequestTimeouts.WithLabelValues(method).Inc()
+}
+
+// IncrementDuplicateWorkers counts a duplicate worker connection
+func (m *Collector) IncrementDuplicateWorkers(action string) {
+	m.DuplicateWorkers.Add(1)
+	m.Prom.DuplicateWorkers.WithLabelValues(action).Inc()
+}
+
+// IncrementThrottleActions counts an action of the share throttle
+func (m *Collector) IncrementThrottleActions(action string) {
+	m.ThrottleActions.Add(1)
+	m.Prom.ThrottleActions.WithLabelValues(action).Inc()
+}
+
+// IncrementWorkerPinRejections counts an authorization refused by a worker pin
+func (m *Collector) IncrementWorkerPinRejections() {
+	m.WorkerPinRejections.Add(1)
+	m.Prom.WorkerPinRejections.Inc()
+}
+
+// IncrementUpstreamAuthRejections counts a pool that refused
+// mining.authorize
+func (m *Collector) IncrementUpstreamAuthRejections() {
+	m.UpstreamAuthRejections.Add(1)
+	m.Prom.UpstreamAuthRejections.Inc()
+}
+
+// IncrementUpstreamStalls counts a pool connection dropped for silence
+// ("silent") or for missing job notifications ("no_notify")
+func (m *Collector) IncrementUpstreamStalls(reason string) {
+	m.UpstreamStalls.Add(1)
+	m.Prom.UpstreamStalls.WithLabelValues(reason).Inc()
+}
+
+// IncrementAdmissionRejections counts a connection refused by admission
+// control
+func (m *Collector) IncrementAdmissionRejections(reason string) {
+	m.AdmissionRejections.Add(1)
+	m.Prom.AdmissionRejections.WithLabelValues(reason).Inc()
+}
+
+// SetPendingSubscribe records the clients connected without a subscribe
+func (m *Collector) SetPendingSubscribe(n int) {
+	m.PendingSubscribe.Store(int64(n))
+	m.Prom.PendingSubscribe.Set(float64(n))
+}
+
+// IncrementSubscribeTimeouts counts a client closed for not subscribing
+func (m *Collector) IncrementSubscribeTimeouts() {
+	m.SubscribeTimeouts.Add(1)
+	m.Prom.SubscribeTimeouts.Inc()
+}
+
+// IncrementClientsShed counts a client disconnected by load shedding,
+// labeled by its priority tier
+func (m *Collector) IncrementClientsShed(tier string) {
+	m.ClientsShed.Add(1)
+	m.Prom.ClientsShed.WithLabelValues(tier).Inc()
+}
+
+// IncrementBlocksFound counts an accepted share meeting the network target
+func (m *Collector) IncrementBlocksFound() {
+	m.BlocksFound.Add(1)
+	m.Prom.BlocksFound.Inc()
+}
+
+// IncrementClientsRecycled counts a client past the maximum session
+// lifetime, labeled by whether it was asked to reconnect or closed
+func (m *Collector) IncrementClientsRecycled(action string) {
+	m.Prom.ClientsRecycled.WithLabelValues(action).Inc()
+}
+
+// IncrementBroadcastSuppressed counts a notification not sent to a client
+// that had not subscribed, labeled by its method
+func (m *Collector) IncrementBroadcastSuppressed(method string) {
+	m.BroadcastSuppressed.Add(1)
+	m.Prom.BroadcastSuppressed.WithLabelValues(method).Inc()
+}
+
+// IncrementSubmitsHeld counts a submit held while the upstream was down,
+// labeled by whether it was forwarded, expired or did not fit the queue
+func (m *Collector) IncrementSubmitsHeld(outcome string) {
+	m.Prom.SubmitsHeld.WithLabelValues(outcome).Inc()
+}
+
+// ObserveGroupShare counts a share of a client group
+func (m *Collector) ObserveGroupShare(group string, accepted bool, diff float64) {
+	if !accepted {
+		m.Prom.GroupShares.WithLabelValues(group, "rejected").Inc()
+		return
+	}
+	m.Prom.GroupShares.WithLabelValues(group, "accepted").Inc()
+	if diff > 0 {
+		m.Prom.GroupDifficulty.WithLabelValues(group).Add(diff)
+	}
+}
+
+// ObserveJob counts an upstream job and how long the previous one was
+// current, which is 0 for the first job
+func (m *Collector) ObserveJob(clean bool, replaced time.Duration) {
+	m.Prom.JobsReceived.WithLabelValues(strconv.FormatBool(clean)).Inc()
+	if replaced > 0 {
+		m.Prom.JobLifetime.Observe(replaced.Seconds())
+	}
+}
+
+// SetBestShare exports the highest share difficulty seen so far
+func (m *Collector) SetBestShare(d float64) {
+	m.Prom.BestShare.Set(d)
+}
+
+// AddClientQueued adjusts the number of lines waiting in client queues
+func (m *Collector) AddClientQueued(delta int64) {
+	m.ClientQueued.Add(delta)
+	m.Prom.ClientQueued.Add(float64(delta))
+}
+
+// IncrementClientOversizedLines counts a client line past the length limit
+func (m *Collector) IncrementClientOversizedLines() {
+	m.ClientOversizedLines.Add(1)
+	m.Prom.ClientBadInput.WithLabelValues("oversized").Inc()
+}
+
+// IncrementClientInvalidLines counts a client line that could not be decoded
+func (m *Collector) IncrementClientInvalidLines() {
+	m.ClientInvalidLines.Add(1)
+	m.Prom.ClientBadInput.WithLabelValues("invalid").Inc()
+}
+
+// IncrementGoroutineLeaks counts a suspected goroutine leak
+func (m *Collector) IncrementGoroutineLeaks() {
+	m.GoroutineLeaks.Add(1)
+	m.Prom.GoroutineLeaks.Inc()
+}
+
+// IncrementAuthorizeFastAcks counts an authorize answered locally
+func (m *Collector) IncrementAuthorizeFastAcks() {
+	m.AuthorizeFastAcks.Add(1)
+	m.Prom.AuthorizeFastAcks.Inc()
+}
+
+// IncrementAuthorizeChecks counts the outcome of a background authorize of
+// an upstream name: accepted, rejected or timeout
+func (m *Collector) IncrementAuthorizeChecks(result string) {
+	m.Prom.AuthorizeChecks.WithLabelValues(result).Inc()
+}
+
+// IncrementClientQueueOverflows counts a client dropped for a full queue
+func (m *Collector) IncrementClientQueueOverflows() {
+	m.ClientQueueOverflows.Add(1)
+	m.Prom.ClientQueueOverflows.Inc()
+}
+
+// ObserveUpstreamFlush records one flush of the upstream writer: how many
+// lines it coalesced, how long it took and how many lines still wait
+func (m *Collector) ObserveUpstreamFlush(lines int, latency time.Duration, queued int) {
+	m.Prom.UpstreamFlushSeconds.Observe(latency.Seconds())
+	m.Prom.UpstreamFlushLines.Observe(float64(lines))
+	m.Prom.UpstreamWriteQueue.Set(float64(queued))
+}
+
+// SetCanaryHealthy records the end-to-end canary status
+func (m *Collector) SetCanaryHealthy(healthy bool) {
+	val := 0.0
+	if healthy {
+		val = 1.0
+	}
+	m.Prom.CanaryHealthy.Set(val)
+}
+
+// SetIdleWorkers records how many connected workers are idle
+func (m *Collector) SetIdleWorkers(n int) {
+	m.Prom.IdleWorkers.Set(float64(n))
+}
+
 // SetLastNotify updates the last notification timestamp
 func (m *Collector) SetLastNotify(t time.Time) {
 	m.LastNotifyUnix.Store(t.Unix())
 	m.Prom.LastNotify.Set(float64(t.Unix()))
+	m.observeUpstreamNotify(t)
+}
+
+// IncrementJobRefreshes counts a job re-sent during a notify gap
+func (m *Collector) IncrementJobRefreshes() {
+	m.JobRefreshes.Add(1)
+	m.Prom.JobRefreshes.Inc()
+}
+
+// SetNotifyGap records whether the pool has gone quiet on jobs for longer
+// than the refresh interval
+func (m *Collector) SetNotifyGap(gap bool) {
+	if m.NotifyGap.Swap(gap) == gap {
+		return
+	}
+	val := 0.0
+	if gap {
+		val = 1.0
+	}
+	m.Prom.NotifyGap.Set(val)
 }
 
 // GetLastNotify returns the last notification timestamp
@@ -126,11 +461,15 @@ func (m *Collector) GetAcceptanceRate() float64 {
 // Reset resets all metrics to zero values
 func (m *Collector) Reset() {
 	m.UpConnected.Store(false)
+	m.upState.Store(UpstreamDisconnected)
 	m.ClientsActive.Store(0)
 	m.SharesOK.Store(0)
 	m.SharesBad.Store(0)
 	m.LastNotifyUnix.Store(0)
 	m.LastSetDiff.Store(0)
+	m.rejectMu.Lock()
+	m.rejectReasons = make(map[string]uint64)
+	m.rejectMu.Unlock()
 }
 
 // Snapshot returns a snapshot of current metrics
@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestCollector(t *testing.T) {
@@ -264,3 +266,90 @@ func TestClientMetricsReset(t *testing.T) {
 		t.Error("Acceptance rate should be 0 after reset")
 	}
 }
+
+func TestUpstreamLatencies(t *testing.T) {
+	c := NewCollector()
+
+	// Samples before any upstream connects are dropped
+	c.ObserveSubmitRTT(time.Second)
+	if got := c.UpstreamLatencies(); len(got) != 0 {
+		t.Fatalf("expected no upstreams, got %+v", got)
+	}
+
+	c.ObserveUpstreamDial("pool-a:3333", 20*time.Millisecond)
+	c.ObserveUpstreamHandshake(35 * time.Millisecond)
+	for i := 1; i <= 100; i++ {
+		c.ObserveSubmitRTT(time.Duration(i) * time.Millisecond)
+	}
+	c.SetLastNotify(time.Now().Add(-2 * time.Second))
+	c.IncrementSharesOK()
+	c.IncrementSharesOK()
+	c.IncrementSharesBad()
+
+	// Failover: the next samples belong to the backup
+	c.SetUpstreamInactive()
+	c.ObserveUpstreamDial("pool-b:3333", 5*time.Millisecond)
+
+	got := c.UpstreamLatencies()
+	if len(got) != 2 || got[0].Upstream != "pool-a:3333" || got[1].Upstream != "pool-b:3333" {
+		t.Fatalf("unexpected upstreams %+v", got)
+	}
+	a, b := got[0], got[1]
+	if a.Active || !b.Active {
+		t.Error("pool-b should be the active upstream")
+	}
+	if a.SharesOK != 2 || a.SharesBad != 1 || b.SharesOK != 0 {
+		t.Errorf("shares by upstream = %+v, %+v", a, b)
+	}
+	if a.DialMs != 20 || a.HandshakeMs != 35 || a.Connects != 1 {
+		t.Errorf("pool-a dial/handshake = %+v", a)
+	}
+	if a.SubmitSamples != 100 || a.SubmitP50Ms != 50 || a.SubmitP95Ms != 95 || a.SubmitP99Ms != 99 {
+		t.Errorf("pool-a percentiles = %+v", a)
+	}
+	if a.SinceNotifySeconds < 2 || a.SinceNotifySeconds > 10 {
+		t.Errorf("pool-a since last notify = %v", a.SinceNotifySeconds)
+	}
+	if b.SubmitSamples != 0 || b.SinceNotifySeconds != -1 {
+		t.Errorf("pool-b should have no samples yet: %+v", b)
+	}
+}
+
+func TestLatencyConfig(t *testing.T) {
+	if err := (&LatencyConfig{SubmitBuckets: []float64{0.01, 0.1, 1}}).Validate(); err != nil {
+		t.Errorf("valid buckets rejected: %v", err)
+	}
+	for _, c := range []LatencyConfig{
+		{SubmitBuckets: []float64{0.1, 0.1}},
+		{FirstShareBuckets: []float64{1, 0.5}},
+		{SubmitBuckets: []float64{0}},
+	} {
+		if c.Validate() == nil {
+			t.Errorf("buckets %v accepted", c)
+		}
+	}
+
+	// observations before and after configuring must not panic
+	c := NewCollector()
+	c.ObserveSubmitLatency(time.Millisecond, "rig", "s1")
+	c.ConfigureLatency(&LatencyConfig{Exemplars: true})
+	c.ObserveSubmitLatency(time.Millisecond, "rig", "s1")
+	c.ObserveFirstShare(time.Second, strings.Repeat("w", 200), "s1")
+}
+
+func TestExemplarLabels(t *testing.T) {
+	if l := exemplar("rig", "s1"); l["worker"] != "rig" || l["session"] != "s1" {
+		t.Errorf("exemplar = %v", l)
+	}
+	l := exemplar(strings.Repeat("é", 200), "s1")
+	runes := 0
+	for k, v := range l {
+		runes += utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+	}
+	if runes != exemplarMaxRunes || !utf8.ValidString(l["worker"]) {
+		t.Errorf("long worker exemplar has %d runes: %v", runes, l)
+	}
+	if l := exemplar("rig", strings.Repeat("s", 200)); l != nil {
+		t.Errorf("oversized session exemplar = %v", l)
+	}
+}
@@ -12,22 +12,79 @@ type PrometheusCollectors struct {
 	UpConnected   prometheus.Gauge
 	LastSetDiff   prometheus.Gauge
 	LastNotify    prometheus.Gauge
+
+	NotifyGap    prometheus.Gauge
+	JobRefreshes prometheus.Counter
+
+	SubmitsInFlight prometheus.Gauge
+	SubmitsQueued   prometheus.Gauge
+	SubmitsDropped  prometheus.Counter
+
+	CanaryHealthy prometheus.Gauge
+	IdleWorkers   prometheus.Gauge
+
+	ClientQueued         prometheus.Gauge
+	ClientQueueOverflows prometheus.Counter
+	ClientBadInput       *prometheus.CounterVec
+
+	GoroutineLeaks prometheus.Counter
+
+	AuthorizeFastAcks prometheus.Counter
+	AuthorizeChecks   *prometheus.CounterVec
+
+	UpstreamWriteQueue   prometheus.Gauge
+	UpstreamFlushSeconds prometheus.Histogram
+	UpstreamFlushLines   prometheus.Histogram
+
+	RejectReasons    *prometheus.CounterVec
+	DuplicateWorkers *prometheus.CounterVec
+	RequestTimeouts  *prometheus.CounterVec
+	ThrottleActions  *prometheus.CounterVec
+
+	WorkerPinRejections prometheus.Counter
+
+	UpstreamAuthRejections prometheus.Counter
+
+	AdmissionRejections *prometheus.CounterVec
+	UpstreamStalls      *prometheus.CounterVec
+	PendingSubscribe    prometheus.Gauge
+	SubscribeTimeouts   prometheus.Counter
+	ClientsShed         *prometheus.CounterVec
+	BlocksFound         prometheus.Counter
+	BestShare           prometheus.Gauge
+	JobsReceived        *prometheus.CounterVec
+	JobLifetime         prometheus.Histogram
+	GroupShares         *prometheus.CounterVec
+	GroupDifficulty     *prometheus.CounterVec
+	ClientsRecycled     *prometheus.CounterVec
+	SubmitsHeld         *prometheus.CounterVec
+	BroadcastSuppressed *prometheus.CounterVec
+
+	UpstreamDial       *prometheus.GaugeVec
+	UpstreamHandshake  *prometheus.GaugeVec
+	UpstreamSubmitRTT  *prometheus.GaugeVec
+	UpstreamLastNotify *prometheus.GaugeVec
+
+	// share latency histograms, nil until ConfigureLatency
+	SubmitLatency     prometheus.Histogram
+	FirstShareLatency prometheus.Histogram
 }
 
-// InitPrometheus initializes and registers prometheus metrics
-func InitPrometheus(namespace string) *PrometheusCollectors {
-	// Helper to safely register or get existing collector
-	register := func(c prometheus.Collector) prometheus.Collector {
-		if err := prometheus.Register(c); err != nil {
-			if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-				return are.ExistingCollector
-			}
-			// Don't panic on registration error in tests/dev, just log
-			return c
+// register safely registers c or returns the collector already registered
+// under its name
+func register(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
 		}
+		// Don't panic on registration error in tests/dev, just log
 		return c
 	}
+	return c
+}
 
+// InitPrometheus initializes and registers prometheus metrics
+func InitPrometheus(namespace string) *PrometheusCollectors {
 	pc := &PrometheusCollectors{}
 
 	pc.SharesOK = register(prometheus.NewCounter(prometheus.CounterOpts{
@@ -66,6 +123,249 @@ func InitPrometheus(namespace string) *PrometheusCollectors {
 		Help:      "Unix timestamp of last mining.notify received",
 	})).(prometheus.Gauge)
 
+	pc.NotifyGap = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_notify_gap",
+		Help:      "1 while the pool has sent no mining.notify for longer than keepalive.upstream_refresh_seconds",
+	})).(prometheus.Gauge)
+
+	pc.JobRefreshes = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_job_refreshes_total",
+		Help:      "Jobs re-sent to miners with a rolled ntime because the pool sent none",
+	})).(prometheus.Counter)
+
+	pc.SubmitsInFlight = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_submits_inflight",
+		Help:      "Number of mining.submit requests awaiting an upstream response",
+	})).(prometheus.Gauge)
+
+	pc.SubmitsQueued = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_submits_queued",
+		Help:      "Number of mining.submit requests waiting for an in-flight slot",
+	})).(prometheus.Gauge)
+
+	pc.SubmitsDropped = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_submits_dropped_total",
+		Help:      "Total number of submits refused because the submit queue was full",
+	})).(prometheus.Counter)
+
+	pc.RequestTimeouts = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_timeouts_total",
+		Help:      "Upstream requests expired without a response, by method",
+	}, []string{"method"})).(*prometheus.CounterVec)
+
+	pc.ThrottleActions = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_throttle_actions_total",
+		Help:      "Share throttle actions taken against clients (escalate, mute, ban)",
+	}, []string{"action"})).(*prometheus.CounterVec)
+
+	pc.RejectReasons = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shares_rejected_by_reason_total",
+		Help:      "Rejected shares per worker and canonical reject category",
+	}, []string{"worker", "reason"})).(*prometheus.CounterVec)
+
+	pc.CanaryHealthy = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "canary_healthy",
+		Help:      "End-to-end canary share status (1 = accepted, 0 = failing)",
+	})).(prometheus.Gauge)
+
+	pc.IdleWorkers = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "idle_workers",
+		Help:      "Connected workers without an accepted share for longer than the idle threshold",
+	})).(prometheus.Gauge)
+
+	pc.WorkerPinRejections = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_pin_rejections_total",
+		Help:      "Authorizations refused because the worker name is pinned to another network",
+	})).(prometheus.Counter)
+
+	pc.AdmissionRejections = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_admission_rejections_total",
+		Help:      "Client connections refused by admission control, by reason (max_clients, rate, pending)",
+	}, []string{"reason"})).(*prometheus.CounterVec)
+
+	pc.UpstreamStalls = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_stalls_total",
+		Help:      "Pool connections dropped because the pool went quiet, by reason (silent, no_notify)",
+	}, []string{"reason"})).(*prometheus.CounterVec)
+
+	pc.UpstreamAuthRejections = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_auth_rejections_total",
+		Help:      "Pool connections dropped because the pool refused mining.authorize",
+	})).(prometheus.Counter)
+
+	pc.PendingSubscribe = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "clients_pending_subscribe",
+		Help:      "Client connections accepted but not yet subscribed",
+	})).(prometheus.Gauge)
+
+	pc.SubscribeTimeouts = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_subscribe_timeouts_total",
+		Help:      "Client connections closed for not sending mining.subscribe in time",
+	})).(prometheus.Counter)
+
+	pc.ClientsShed = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clients_shed_total",
+		Help:      "Clients disconnected by load shedding, by priority tier",
+	}, []string{"tier"})).(*prometheus.CounterVec)
+
+	pc.BlocksFound = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_found_total",
+		Help:      "Accepted shares whose hash met the network target",
+	})).(prometheus.Counter)
+
+	pc.BestShare = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "best_share_difficulty",
+		Help:      "Highest difficulty of an accepted share since start",
+	})).(prometheus.Gauge)
+
+	pc.JobsReceived = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_jobs_total",
+		Help:      "Jobs received from the upstream, by clean_jobs flag",
+	}, []string{"clean"})).(*prometheus.CounterVec)
+
+	pc.JobLifetime = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_job_lifetime_seconds",
+		Help:      "How long an upstream job stayed current before the next one arrived",
+		Buckets:   []float64{0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	})).(prometheus.Histogram)
+
+	pc.GroupShares = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "group_shares_total",
+		Help:      "Share submissions by client group and result",
+	}, []string{"group", "result"})).(*prometheus.CounterVec)
+
+	pc.GroupDifficulty = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "group_accepted_difficulty_total",
+		Help:      "Difficulty of accepted shares by client group; its rate times 2^32 is the group hashrate",
+	}, []string{"group"})).(*prometheus.CounterVec)
+
+	pc.ClientsRecycled = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clients_recycled_total",
+		Help:      "Clients past the maximum session lifetime, by action (reconnect or close)",
+	}, []string{"action"})).(*prometheus.CounterVec)
+
+	pc.SubmitsHeld = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_submits_held_total",
+		Help:      "Submits held while the upstream reconnected, by outcome (forwarded, expired or overflow)",
+	}, []string{"outcome"})).(*prometheus.CounterVec)
+
+	pc.BroadcastSuppressed = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_suppressed_total",
+		Help:      "Upstream notifications not sent to clients that had not subscribed yet, by method",
+	}, []string{"method"})).(*prometheus.CounterVec)
+
+	pc.DuplicateWorkers = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_workers_total",
+		Help:      "Connections reusing a worker name already connected from another address, by action taken",
+	}, []string{"action"})).(*prometheus.CounterVec)
+
+	pc.ClientQueued = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "client_write_queue_messages",
+		Help:      "Lines waiting in client outbound queues",
+	})).(prometheus.Gauge)
+
+	pc.ClientQueueOverflows = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_write_queue_overflows_total",
+		Help:      "Clients disconnected because their outbound queue was full",
+	})).(prometheus.Counter)
+
+	pc.ClientBadInput = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_bad_input_total",
+		Help:      "Client lines refused, by kind (oversized past max_line_bytes or invalid JSON-RPC)",
+	}, []string{"kind"})).(*prometheus.CounterVec)
+
+	pc.GoroutineLeaks = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "goroutine_leaks_total",
+		Help:      "Suspected goroutine leaks: counts that kept rising while clients did not",
+	})).(prometheus.Counter)
+
+	pc.AuthorizeFastAcks = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorize_fast_acks_total",
+		Help:      "Client authorizes answered locally without waiting for the pool",
+	})).(prometheus.Counter)
+
+	pc.AuthorizeChecks = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorize_upstream_checks_total",
+		Help:      "Background authorizes of upstream names behind fast acks, by result",
+	}, []string{"result"})).(*prometheus.CounterVec)
+
+	pc.UpstreamWriteQueue = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_write_queue_lines",
+		Help:      "Lines waiting in the upstream write queue after the last flush",
+	})).(prometheus.Gauge)
+
+	pc.UpstreamFlushSeconds = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_flush_seconds",
+		Help:      "Time taken to write and flush one batch to the upstream",
+		Buckets:   []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	})).(prometheus.Histogram)
+
+	pc.UpstreamFlushLines = register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_flush_lines",
+		Help:      "Lines coalesced into one upstream flush",
+		Buckets:   []float64{1, 2, 4, 8, 16, 32, 64},
+	})).(prometheus.Histogram)
+
+	pc.UpstreamDial = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_dial_seconds",
+		Help:      "Time taken by the last successful dial, per upstream",
+	}, []string{"upstream"})).(*prometheus.GaugeVec)
+
+	pc.UpstreamHandshake = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_handshake_seconds",
+		Help:      "Time from sending subscribe/authorize to the authorize reply on the last connection, per upstream",
+	}, []string{"upstream"})).(*prometheus.GaugeVec)
+
+	pc.UpstreamSubmitRTT = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_submit_rtt_seconds",
+		Help:      "Submit round-trip percentiles over the last 512 submits, per upstream",
+	}, []string{"upstream", "quantile"})).(*prometheus.GaugeVec)
+
+	pc.UpstreamLastNotify = register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_last_notify_timestamp_seconds",
+		Help:      "Unix timestamp of the last mining.notify, per upstream",
+	}, []string{"upstream"})).(*prometheus.GaugeVec)
+
 	return pc
 }
 
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// rttWindow is how many recent submit round trips the percentiles cover
+const rttWindow = 512
+
+// rttRefresh limits how often the percentile gauges are recomputed
+const rttRefresh = time.Second
+
+// upstreamTiming holds the latency measurements of one upstream endpoint
+type upstreamTiming struct {
+	dial       time.Duration
+	handshake  time.Duration
+	connects   uint64
+	lastNotify time.Time
+	sharesOK   uint64
+	sharesBad  uint64
+
+	rtt       []time.Duration // ring of the last rttWindow samples
+	next      int
+	refreshed time.Time
+}
+
+// UpstreamLatency is the latency view of one upstream endpoint, with the
+// share results it returned
+type UpstreamLatency struct {
+	Upstream      string  `json:"upstream"`
+	Active        bool    `json:"active"`
+	Connects      uint64  `json:"connects"`
+	SharesOK      uint64  `json:"shares_ok"`
+	SharesBad     uint64  `json:"shares_bad"`
+	DialMs        float64 `json:"dial_ms"`
+	HandshakeMs   float64 `json:"handshake_ms"`
+	SubmitSamples int     `json:"submit_samples"`
+	SubmitP50Ms   float64 `json:"submit_p50_ms"`
+	SubmitP95Ms   float64 `json:"submit_p95_ms"`
+	SubmitP99Ms   float64 `json:"submit_p99_ms"`
+	// -1 until the upstream has sent a mining.notify
+	SinceNotifySeconds float64 `json:"since_last_notify_seconds"`
+}
+
+// upstreamLatencies tracks every upstream the proxy has connected to; the
+// active one receives handshake, submit and notify samples
+type upstreamLatencies struct {
+	mu     sync.Mutex
+	active string
+	byName map[string]*upstreamTiming
+}
+
+// timing returns the entry for name, creating it; mu must be held
+func (l *upstreamLatencies) timing(name string) *upstreamTiming {
+	if l.byName == nil {
+		l.byName = make(map[string]*upstreamTiming)
+	}
+	t, ok := l.byName[name]
+	if !ok {
+		t = &upstreamTiming{}
+		l.byName[name] = t
+	}
+	return t
+}
+
+// ObserveUpstreamDial records a successful dial and makes name the active
+// upstream for the following samples
+func (m *Collector) ObserveUpstreamDial(name string, d time.Duration) {
+	m.upstreams.mu.Lock()
+	m.upstreams.active = name
+	t := m.upstreams.timing(name)
+	t.dial = d
+	t.connects++
+	m.upstreams.mu.Unlock()
+	m.Prom.UpstreamDial.WithLabelValues(name).Set(d.Seconds())
+}
+
+// ObserveUpstreamHandshake records the subscribe/authorize round trip of the
+// active upstream
+func (m *Collector) ObserveUpstreamHandshake(d time.Duration) {
+	m.upstreams.mu.Lock()
+	name := m.upstreams.active
+	if name == "" {
+		m.upstreams.mu.Unlock()
+		return
+	}
+	m.upstreams.timing(name).handshake = d
+	m.upstreams.mu.Unlock()
+	m.Prom.UpstreamHandshake.WithLabelValues(name).Set(d.Seconds())
+}
+
+// ObserveSubmitRTT records the round trip of a submit answered by the
+// active upstream
+func (m *Collector) ObserveSubmitRTT(d time.Duration) {
+	m.upstreams.mu.Lock()
+	name := m.upstreams.active
+	if name == "" {
+		m.upstreams.mu.Unlock()
+		return
+	}
+	t := m.upstreams.timing(name)
+	if len(t.rtt) < rttWindow {
+		t.rtt = append(t.rtt, d)
+	} else {
+		t.rtt[t.next] = d
+		t.next = (t.next + 1) % rttWindow
+	}
+	var p50, p95, p99 time.Duration
+	refresh := time.Since(t.refreshed) >= rttRefresh
+	if refresh {
+		t.refreshed = time.Now()
+		p50, p95, p99 = t.percentiles()
+	}
+	m.upstreams.mu.Unlock()
+
+	if refresh {
+		m.Prom.UpstreamSubmitRTT.WithLabelValues(name, "0.5").Set(p50.Seconds())
+		m.Prom.UpstreamSubmitRTT.WithLabelValues(name, "0.95").Set(p95.Seconds())
+		m.Prom.UpstreamSubmitRTT.WithLabelValues(name, "0.99").Set(p99.Seconds())
+	}
+}
+
+// observeUpstreamNotify stamps the active upstream's last notify
+func (m *Collector) observeUpstreamNotify(at time.Time) {
+	m.upstreams.mu.Lock()
+	name := m.upstreams.active
+	if name != "" {
+		m.upstreams.timing(name).lastNotify = at
+	}
+	m.upstreams.mu.Unlock()
+	if name != "" {
+		m.Prom.UpstreamLastNotify.WithLabelValues(name).Set(float64(at.Unix()))
+	}
+}
+
+// observeUpstreamShare counts a share result against the active upstream
+func (m *Collector) observeUpstreamShare(ok bool) {
+	m.upstreams.mu.Lock()
+	defer m.upstreams.mu.Unlock()
+	if m.upstreams.active == "" {
+		return
+	}
+	t := m.upstreams.timing(m.upstreams.active)
+	if ok {
+		t.sharesOK++
+	} else {
+		t.sharesBad++
+	}
+}
+
+// SetUpstreamInactive clears the active upstream after a disconnect
+func (m *Collector) SetUpstreamInactive() {
+	m.upstreams.mu.Lock()
+	m.upstreams.active = ""
+	m.upstreams.mu.Unlock()
+}
+
+// UpstreamLatencies returns the latency view of every upstream seen, by name
+func (m *Collector) UpstreamLatencies() []UpstreamLatency {
+	m.upstreams.mu.Lock()
+	defer m.upstreams.mu.Unlock()
+	out := make([]UpstreamLatency, 0, len(m.upstreams.byName))
+	for name, t := range m.upstreams.byName {
+		p50, p95, p99 := t.percentiles()
+		v := UpstreamLatency{
+			Upstream:           name,
+			Active:             name == m.upstreams.active,
+			Connects:           t.connects,
+			SharesOK:           t.sharesOK,
+			SharesBad:          t.sharesBad,
+			DialMs:             ms(t.dial),
+			HandshakeMs:        ms(t.handshake),
+			SubmitSamples:      len(t.rtt),
+			SubmitP50Ms:        ms(p50),
+			SubmitP95Ms:        ms(p95),
+			SubmitP99Ms:        ms(p99),
+			SinceNotifySeconds: -1,
+		}
+		if !t.lastNotify.IsZero() {
+			v.SinceNotifySeconds = time.Since(t.lastNotify).Seconds()
+		}
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Upstream < out[j].Upstream })
+	return out
+}
+
+// percentiles returns the nearest-rank p50, p95 and p99 of the window
+func (t *upstreamTiming) percentiles() (p50, p95, p99 time.Duration) {
+	if len(t.rtt) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]time.Duration(nil), t.rtt...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(q float64) time.Duration {
+		i := int(q*float64(len(sorted))+0.999999) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return rank(0.50), rank(0.95), rank(0.99)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -2,8 +2,11 @@
 package nonce
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
@@ -11,6 +14,27 @@ import (
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
 )
 
+const (
+	// DefaultPrefixBytes is the per-client extranonce prefix width used when
+	// none is configured
+	DefaultPrefixBytes = 1
+	// MaxPrefixBytes bounds the configurable prefix width
+	MaxPrefixBytes = 4
+)
+
+// ErrPrefixExhausted is returned when every extranonce prefix is in use
+var ErrPrefixExhausted = errors.New("extranonce prefix space exhausted")
+
+// Config holds extranonce allocation settings
+type Config struct {
+	// PrefixBytes is how many bytes of extranonce2 are reserved per client
+	PrefixBytes int `json:"prefix_bytes"`
+	// ChainPrefixBytes is the narrower prefix given to downstream karoo
+	// instances, leaving them extranonce2 bytes to subdivide; 0 treats them
+	// like any client
+	ChainPrefixBytes int `json:"chain_prefix_bytes"`
+}
+
 // Client represents a mining client interface for nonce package
 type Client interface {
 	GetExtraNoncePrefix() string
@@ -20,6 +44,12 @@ type Client interface {
 	WriteJSON(stratum.Message) error
 }
 
+// ChainedClient is a Client that may be another karoo proxy
+type ChainedClient interface {
+	Client
+	Chained() bool
+}
+
 // Manager handles extranonce allocation and subscription queue
 type Manager struct {
 	up *connection.Upstream
@@ -30,10 +60,30 @@ type Manager struct {
 	readyCh chan struct{}
 
 	subMu       sync.Mutex
-	pendingSubs map[Client]*int64
+	pendingSubs map[Client]*stratum.ID
 
 	// extranonce prefix allocation
-	prefixCounter atomic.Uint64
+	cfg   Config
+	alloc prefixAllocator
+
+	exhausted      atomic.Uint64
+	warnedNoPrefix atomic.Bool
+}
+
+// prefixAllocator hands out unique prefixes and reclaims released ones.
+// Chained blocks are carved from the top of the space while single prefixes
+// count up from the bottom, so the two never overlap.
+type prefixAllocator struct {
+	mu    sync.Mutex
+	width int                 // bytes, fixed while any prefix is in use
+	next  uint64              // number of never-used values handed out so far
+	free  []uint64            // released values ready for reuse
+	inUse map[uint64]struct{} // currently assigned values
+
+	blockWidth int                 // bytes of a chained block; 0 when chaining is off
+	carved     uint64              // blocks carved from the top so far
+	freeBlocks []uint64            // released blocks ready for reuse
+	blocks     map[uint64]struct{} // currently assigned blocks
 }
 
 // NewManager creates a new nonce manager
@@ -41,18 +91,40 @@ func NewManager(up *connection.Upstream) *Manager {
 	return &Manager{
 		up:          up,
 		readyCh:     make(chan struct{}),
-		pendingSubs: make(map[Client]*int64),
+		pendingSubs: make(map[Client]*stratum.ID),
+		cfg:         Config{PrefixBytes: DefaultPrefixBytes},
+		alloc:       prefixAllocator{inUse: make(map[uint64]struct{}), blocks: make(map[uint64]struct{})},
+	}
+}
+
+// UpdateConfig updates the prefix width. A new width takes effect once no
+// client holds a prefix of the previous width, so prefixes never overlap.
+func (m *Manager) UpdateConfig(cfg *Config) {
+	m.alloc.mu.Lock()
+	defer m.alloc.mu.Unlock()
+	m.cfg = *cfg
+	if m.cfg.PrefixBytes <= 0 {
+		m.cfg.PrefixBytes = DefaultPrefixBytes
+	}
+	if m.cfg.PrefixBytes > MaxPrefixBytes {
+		m.cfg.PrefixBytes = MaxPrefixBytes
+	}
+	if m.cfg.ChainPrefixBytes < 0 || m.cfg.ChainPrefixBytes > m.cfg.PrefixBytes {
+		m.cfg.ChainPrefixBytes = 0
+	}
+	if m.alloc.idle() {
+		m.alloc.reset(m.cfg.PrefixBytes, m.cfg.ChainPrefixBytes)
 	}
 }
 
 // UpstreamReady checks if upstream is ready for subscriptions
 func (m *Manager) UpstreamReady() bool {
-	ex1, ex2Size := m.up.GetExtranonce()
-	return m.upReady.Load() && ex2Size > 0 && ex1 != ""
+	ex := m.up.ExtranonceState()
+	return m.upReady.Load() && ex.Ex2Size > 0 && ex.Ex1 != ""
 }
 
 // EnqueuePendingSubscribe adds client to pending subscribe queue
-func (m *Manager) EnqueuePendingSubscribe(cl Client, id *int64) {
+func (m *Manager) EnqueuePendingSubscribe(cl Client, id *stratum.ID) {
 	copy := stratum.CopyID(id)
 
 	// Check readiness first to avoid unnecessary locking in common case
@@ -71,7 +143,7 @@ func (m *Manager) EnqueuePendingSubscribe(cl Client, id *int64) {
 	}
 
 	if m.pendingSubs == nil {
-		m.pendingSubs = make(map[Client]*int64)
+		m.pendingSubs = make(map[Client]*stratum.ID)
 	}
 	// single pending subscribe per client; latest ID wins
 	m.pendingSubs[cl] = copy
@@ -92,12 +164,12 @@ func (m *Manager) FlushPendingSubscribes() {
 		m.subMu.Unlock()
 		return
 	}
-	pending := make(map[Client]*int64, len(m.pendingSubs))
+	pending := make(map[Client]*stratum.ID, len(m.pendingSubs))
 	for cl, id := range m.pendingSubs {
 		pending[cl] = id
 	}
 	// reset map so new subscribers can queue while we reply
-	m.pendingSubs = make(map[Client]*int64)
+	m.pendingSubs = make(map[Client]*stratum.ID)
 	m.subMu.Unlock()
 
 	for cl, id := range pending {
@@ -107,7 +179,7 @@ func (m *Manager) FlushPendingSubscribes() {
 
 // RespondSubscribe responds to mining.subscribe request
 // If upstream is not ready, enqueues the request
-func (m *Manager) RespondSubscribe(cl Client, id *int64) {
+func (m *Manager) RespondSubscribe(cl Client, id *stratum.ID) {
 	if !m.UpstreamReady() {
 		m.EnqueuePendingSubscribe(cl, id)
 		return
@@ -117,44 +189,237 @@ func (m *Manager) RespondSubscribe(cl Client, id *int64) {
 
 // RespondSubscribeIfReady responds immediately without checking readiness
 // Used when caller has already verified upstream is ready
-func (m *Manager) RespondSubscribeIfReady(cl Client, id *int64) {
-	m.AssignNoncePrefix(cl)
-	ex1Resp, ex2Resp := m.GetClientExtranonce(cl)
+func (m *Manager) RespondSubscribeIfReady(cl Client, id *stratum.ID) {
+	// one snapshot for the prefix and the answer, so a reconnect between
+	// them cannot pair a prefix with another connection's extranonce
+	ex := m.up.ExtranonceState()
+	if err := m.assignNoncePrefix(cl, ex.Ex2Size); err != nil {
+		log.Printf("nonce: refusing subscribe: %v", err)
+		m.WriteClient(cl, stratum.ErrorResponseFor(id, stratum.ErrProxyFull))
+		if c, ok := cl.(io.Closer); ok {
+			_ = c.Close()
+		}
+		return
+	}
+	ex1Resp, ex2Resp := m.clientExtranonce(cl, ex)
 	resp := stratum.NewSuccessResponse(id, []interface{}{[]interface{}{}, ex1Resp, ex2Resp})
 	m.WriteClient(cl, resp)
 }
 
-// AssignNoncePrefix assigns a unique extranonce prefix to client
-func (m *Manager) AssignNoncePrefix(cl Client) {
+// AssignNoncePrefix assigns a unique extranonce prefix to client; a chained
+// karoo gets a block of chain_prefix_bytes instead. Returns
+// ErrPrefixExhausted when no prefix is free.
+func (m *Manager) AssignNoncePrefix(cl Client) error {
+	return m.assignNoncePrefix(cl, m.up.ExtranonceState().Ex2Size)
+}
+
+// assignNoncePrefix assigns a prefix carved from an extranonce2 of ex2Size
+// bytes
+func (m *Manager) assignNoncePrefix(cl Client, ex2Size int) error {
 	if cl.GetExtraNoncePrefix() != "" {
-		return
+		return nil
+	}
+
+	m.alloc.mu.Lock()
+	if m.alloc.idle() && (m.alloc.width != m.cfg.PrefixBytes || m.alloc.blockWidth != m.alloc.chainWidth(m.cfg.PrefixBytes, m.cfg.ChainPrefixBytes)) {
+		m.alloc.reset(m.cfg.PrefixBytes, m.cfg.ChainPrefixBytes)
+	}
+	width := m.alloc.width
+	chained := false
+	if ch, ok := cl.(ChainedClient); ok && ch.Chained() && m.alloc.blockWidth > 0 {
+		width, chained = m.alloc.blockWidth, true
+	}
+	if width <= 0 {
+		m.alloc.mu.Unlock()
+		return nil
+	}
+	if ex2Size <= width {
+		m.alloc.mu.Unlock()
+		if ex2Size > 0 && !m.warnedNoPrefix.Swap(true) {
+			log.Printf("nonce: extranonce2 size %d too small for %d-byte prefixes; clients will share extranonce space", ex2Size, width)
+		}
+		return nil
+	}
+	var val uint64
+	var ok bool
+	if chained {
+		val, ok = m.alloc.takeBlock()
+	} else {
+		val, ok = m.alloc.take()
+	}
+	m.alloc.mu.Unlock()
+	if !ok {
+		m.exhausted.Add(1)
+		return ErrPrefixExhausted
 	}
-	const extraNoncePrefixBytes = 1
-	if extraNoncePrefixBytes <= 0 {
+	if chained {
+		log.Printf("nonce: chained proxy gets %d-byte prefix block %0*X", width, width*2, val)
+	}
+
+	prefix := fmt.Sprintf("%0*X", width*2, val)
+	cl.SetExtraNoncePrefix(prefix)
+	cl.SetExtraNonceTrim(width)
+	return nil
+}
+
+// ReleaseNoncePrefix returns the client's prefix to the free list
+func (m *Manager) ReleaseNoncePrefix(cl Client) {
+	prefix := cl.GetExtraNoncePrefix()
+	if prefix == "" {
 		return
 	}
-	_, ex2Size := m.up.GetExtranonce()
-	if ex2Size <= extraNoncePrefixBytes {
+	val, err := strconv.ParseUint(prefix, 16, 64)
+	if err != nil {
 		return
 	}
-	bits := extraNoncePrefixBytes * 8
-	if bits <= 0 || bits >= 64 {
+	m.alloc.mu.Lock()
+	switch len(prefix) {
+	case m.alloc.width * 2:
+		m.alloc.release(val)
+	case m.alloc.blockWidth * 2:
+		m.alloc.releaseBlock(val)
+	}
+	m.alloc.mu.Unlock()
+	cl.SetExtraNoncePrefix("")
+	cl.SetExtraNonceTrim(0)
+}
+
+// Exhausted reports whether every prefix of the current width is assigned
+func (m *Manager) Exhausted() bool {
+	m.alloc.mu.Lock()
+	defer m.alloc.mu.Unlock()
+	return m.alloc.width > 0 && len(m.alloc.free) == 0 && m.alloc.next >= m.alloc.limit()
+}
+
+// GetStats returns extranonce allocation statistics
+func (m *Manager) GetStats() map[string]interface{} {
+	m.alloc.mu.Lock()
+	defer m.alloc.mu.Unlock()
+	return map[string]interface{}{
+		"prefix_bytes":       m.alloc.width,
+		"prefixes_in_use":    len(m.alloc.inUse),
+		"prefix_capacity":    m.alloc.capacity(),
+		"exhausted_events":   m.exhausted.Load(),
+		"chain_prefix_bytes": m.alloc.blockWidth,
+		"chained_in_use":     len(m.alloc.blocks),
+	}
+}
+
+// chainWidth returns the block width used for chained clients; blocks as
+// wide as a prefix are plain prefixes
+func (a *prefixAllocator) chainWidth(width, chain int) int {
+	if chain <= 0 || chain >= width {
+		return 0
+	}
+	return chain
+}
+
+// idle reports whether no prefix or block is assigned
+func (a *prefixAllocator) idle() bool {
+	return len(a.inUse) == 0 && len(a.blocks) == 0
+}
+
+// reset clears the allocator and sets new prefix and block widths
+func (a *prefixAllocator) reset(width, chain int) {
+	a.width = width
+	a.next = 0
+	a.free = nil
+	a.inUse = make(map[uint64]struct{})
+	a.blockWidth = a.chainWidth(width, chain)
+	a.carved = 0
+	a.freeBlocks = nil
+	a.blocks = make(map[uint64]struct{})
+}
+
+// shift is the number of bits a block value is shifted to give its first
+// prefix value
+func (a *prefixAllocator) shift() uint {
+	return uint(a.width-a.blockWidth) * 8
+}
+
+// limit is the counter value single prefixes stop at: the whole space, or
+// just below the lowest carved block
+func (a *prefixAllocator) limit() uint64 {
+	if a.carved == 0 {
+		return a.capacity()
+	}
+	low := uint64(1)<<(uint(a.blockWidth)*8) - a.carved
+	return low<<a.shift() - 1
+}
+
+// capacity returns the number of distinct prefixes for the current width
+func (a *prefixAllocator) capacity() uint64 {
+	if a.width <= 0 {
+		return 0
+	}
+	return uint64(1) << (uint(a.width) * 8)
+}
+
+// take returns an unused prefix value, preferring reclaimed ones
+func (a *prefixAllocator) take() (uint64, bool) {
+	if n := len(a.free); n > 0 {
+		val := a.free[n-1]
+		a.free = a.free[:n-1]
+		a.inUse[val] = struct{}{}
+		return val, true
+	}
+	capacity := a.capacity()
+	if a.next >= a.limit() {
+		return 0, false
+	}
+	a.next++
+	// first prefix is 01; zero is handed out last
+	val := a.next & (capacity - 1)
+	a.inUse[val] = struct{}{}
+	return val, true
+}
+
+// release marks a prefix value as free again
+func (a *prefixAllocator) release(val uint64) {
+	if _, ok := a.inUse[val]; !ok {
 		return
 	}
-	mask := (uint64(1) << bits) - 1
-	if mask == 0 {
+	delete(a.inUse, val)
+	a.free = append(a.free, val)
+}
+
+// takeBlock returns an unused block value, preferring reclaimed ones. A new
+// block is carved below the previous one as long as single prefixes have not
+// reached it; block zero, which holds the last single prefix, is never carved.
+func (a *prefixAllocator) takeBlock() (uint64, bool) {
+	if n := len(a.freeBlocks); n > 0 {
+		val := a.freeBlocks[n-1]
+		a.freeBlocks = a.freeBlocks[:n-1]
+		a.blocks[val] = struct{}{}
+		return val, true
+	}
+	val := uint64(1)<<(uint(a.blockWidth)*8) - a.carved - 1
+	if val == 0 || val<<a.shift() <= a.next {
+		return 0, false
+	}
+	a.carved++
+	a.blocks[val] = struct{}{}
+	return val, true
+}
+
+// releaseBlock marks a block value as free again
+func (a *prefixAllocator) releaseBlock(val uint64) {
+	if _, ok := a.blocks[val]; !ok {
 		return
 	}
-	val := m.prefixCounter.Add(1) & mask
-	prefix := fmt.Sprintf("%0*X", extraNoncePrefixBytes*2, val)
-	cl.SetExtraNoncePrefix(prefix)
-	cl.SetExtraNonceTrim(extraNoncePrefixBytes)
+	delete(a.blocks, val)
+	a.freeBlocks = append(a.freeBlocks, val)
 }
 
 // GetClientExtranonce returns the extranonce values for a specific client
 func (m *Manager) GetClientExtranonce(cl Client) (string, int) {
-	ex1, ex2Size := m.up.GetExtranonce()
-	ex1Resp := ex1
+	return m.clientExtranonce(cl, m.up.ExtranonceState())
+}
+
+// clientExtranonce derives a client's extranonce values from one upstream
+// assignment
+func (m *Manager) clientExtranonce(cl Client, ex connection.Extranonce) (string, int) {
+	ex1Resp, ex2Size := ex.Ex1, ex.Ex2Size
 	ex2Resp := ex2Size
 
 	if cl.GetExtraNoncePrefix() != "" && cl.GetExtraNonceTrim() > 0 {
@@ -162,8 +427,7 @@ func (m *Manager) GetClientExtranonce(cl Client) (string, int) {
 			ex1Resp = ex1Resp + cl.GetExtraNoncePrefix()
 			ex2Resp = ex2Size - cl.GetExtraNonceTrim()
 		} else {
-			cl.SetExtraNoncePrefix("")
-			cl.SetExtraNonceTrim(0)
+			m.ReleaseNoncePrefix(cl)
 		}
 	}
 	return ex1Resp, ex2Resp
@@ -211,14 +475,19 @@ func (m *Manager) WriteClient(cl Client, msg stratum.Message) {
 
 // Reset resets the nonce manager state
 func (m *Manager) Reset() {
-	m.upReady.Store(false)
-	m.readyMu.Lock()
-	m.readyCh = make(chan struct{})
-	m.readyMu.Unlock()
+	// under readyMu, so a concurrent ready transition cannot leave upReady
+	// set with an unclosed channel
+	m.SetUpstreamReady(false)
 
 	m.subMu.Lock()
-	m.pendingSubs = make(map[Client]*int64)
+	m.pendingSubs = make(map[Client]*stratum.ID)
 	m.subMu.Unlock()
 
-	m.prefixCounter.Store(0)
+	// connected clients keep their prefixes; only an idle allocator restarts
+	m.alloc.mu.Lock()
+	if m.alloc.idle() {
+		m.alloc.reset(m.cfg.PrefixBytes, m.cfg.ChainPrefixBytes)
+	}
+	m.alloc.mu.Unlock()
+	m.warnedNoPrefix.Store(false)
 }
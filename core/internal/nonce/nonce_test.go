@@ -79,10 +79,10 @@ func TestEnqueuePendingSubscribe(t *testing.T) {
 	m := NewManager(up)
 
 	cl := &mockClient{}
-	id := int64(123)
+	id := stratum.NewID(123)
 
 	// Test enqueue when not ready
-	m.EnqueuePendingSubscribe(cl, &id)
+	m.EnqueuePendingSubscribe(cl, id)
 
 	m.subMu.Lock()
 	if len(m.pendingSubs) != 1 {
@@ -91,8 +91,8 @@ func TestEnqueuePendingSubscribe(t *testing.T) {
 	if m.pendingSubs[cl] == nil {
 		t.Error("Client not found in pending subscribes")
 	}
-	if *m.pendingSubs[cl] != id {
-		t.Errorf("Expected ID %d, got %d", id, *m.pendingSubs[cl])
+	if *m.pendingSubs[cl] != *id {
+		t.Errorf("Expected ID %v, got %v", id, m.pendingSubs[cl])
 	}
 	m.subMu.Unlock()
 }
@@ -102,10 +102,10 @@ func TestRemovePendingSubscribe(t *testing.T) {
 	m := NewManager(up)
 
 	cl := &mockClient{}
-	id := int64(123)
+	id := stratum.NewID(123)
 
 	// Add to pending
-	m.EnqueuePendingSubscribe(cl, &id)
+	m.EnqueuePendingSubscribe(cl, id)
 
 	// Remove
 	m.RemovePendingSubscribe(cl)
@@ -124,12 +124,12 @@ func TestFlushPendingSubscribes(t *testing.T) {
 	cl1 := &mockClient{}
 	cl2 := &mockClient{}
 
-	id1 := int64(123)
-	id2 := int64(456)
+	id1 := stratum.NewID(123)
+	id2 := stratum.NewID(456)
 
 	// Add to pending
-	m.EnqueuePendingSubscribe(cl1, &id1)
-	m.EnqueuePendingSubscribe(cl2, &id2)
+	m.EnqueuePendingSubscribe(cl1, id1)
+	m.EnqueuePendingSubscribe(cl2, id2)
 
 	// Verify they are pending
 	m.subMu.Lock()
@@ -307,9 +307,21 @@ func TestReset(t *testing.T) {
 	if m.upReady.Load() {
 		t.Error("Upstream ready should be false after reset")
 	}
-	if m.prefixCounter.Load() != 0 {
-		t.Error("Prefix counter should be 0 after reset")
+	// The client answered above is still connected, so its prefix survives
+	m.alloc.mu.Lock()
+	if len(m.alloc.inUse) != 1 {
+		t.Errorf("Prefixes held by connected clients should survive reset, got %d", len(m.alloc.inUse))
 	}
+	m.alloc.mu.Unlock()
+
+	// Once released, the allocator starts over on the next reset
+	m.ReleaseNoncePrefix(cl)
+	m.Reset()
+	m.alloc.mu.Lock()
+	if m.alloc.next != 0 || len(m.alloc.inUse) != 0 {
+		t.Error("Prefix allocator should be empty after reset")
+	}
+	m.alloc.mu.Unlock()
 
 	m.subMu.Lock()
 	if len(m.pendingSubs) != 0 {
@@ -317,3 +329,162 @@ func TestReset(t *testing.T) {
 	}
 	m.subMu.Unlock()
 }
+
+func TestPrefixAllocatorReclaimsReleasedPrefixes(t *testing.T) {
+	up := createTestUpstream()
+	m := NewManager(up)
+	up.SetExtranonce("deadbeef", 4)
+
+	first := &mockClient{}
+	second := &mockClient{}
+	if err := m.AssignNoncePrefix(first); err != nil {
+		t.Fatalf("AssignNoncePrefix failed: %v", err)
+	}
+	if err := m.AssignNoncePrefix(second); err != nil {
+		t.Fatalf("AssignNoncePrefix failed: %v", err)
+	}
+	if first.GetExtraNoncePrefix() == second.GetExtraNoncePrefix() {
+		t.Fatal("Clients must not share a prefix")
+	}
+
+	released := first.GetExtraNoncePrefix()
+	m.ReleaseNoncePrefix(first)
+	if first.GetExtraNoncePrefix() != "" || first.GetExtraNonceTrim() != 0 {
+		t.Error("Released client should have no prefix")
+	}
+
+	third := &mockClient{}
+	if err := m.AssignNoncePrefix(third); err != nil {
+		t.Fatalf("AssignNoncePrefix failed: %v", err)
+	}
+	if third.GetExtraNoncePrefix() != released {
+		t.Errorf("Expected reclaimed prefix %s, got %s", released, third.GetExtraNoncePrefix())
+	}
+}
+
+func TestPrefixAllocatorExhaustion(t *testing.T) {
+	up := createTestUpstream()
+	m := NewManager(up)
+	up.SetExtranonce("deadbeef", 4)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		cl := &mockClient{}
+		if err := m.AssignNoncePrefix(cl); err != nil {
+			t.Fatalf("Unexpected error after %d prefixes: %v", i, err)
+		}
+		if seen[cl.GetExtraNoncePrefix()] {
+			t.Fatalf("Duplicate prefix %s", cl.GetExtraNoncePrefix())
+		}
+		seen[cl.GetExtraNoncePrefix()] = true
+	}
+
+	if !m.Exhausted() {
+		t.Error("Allocator should report exhaustion")
+	}
+	if err := m.AssignNoncePrefix(&mockClient{}); err != ErrPrefixExhausted {
+		t.Errorf("Expected ErrPrefixExhausted, got %v", err)
+	}
+	if m.GetStats()["exhausted_events"].(uint64) != 1 {
+		t.Error("Exhaustion should be counted")
+	}
+}
+
+func TestPrefixWidthConfigurable(t *testing.T) {
+	up := createTestUpstream()
+	m := NewManager(up)
+	m.UpdateConfig(&Config{PrefixBytes: 2})
+	up.SetExtranonce("deadbeef", 8)
+
+	cl := &mockClient{}
+	if err := m.AssignNoncePrefix(cl); err != nil {
+		t.Fatalf("AssignNoncePrefix failed: %v", err)
+	}
+	if len(cl.GetExtraNoncePrefix()) != 4 {
+		t.Errorf("Expected 2-byte prefix, got %q", cl.GetExtraNoncePrefix())
+	}
+	if cl.GetExtraNonceTrim() != 2 {
+		t.Errorf("Expected trim 2, got %d", cl.GetExtraNonceTrim())
+	}
+	if _, ex2 := m.GetClientExtranonce(cl); ex2 != 6 {
+		t.Errorf("Expected extranonce2 size 6, got %d", ex2)
+	}
+
+	// Width changes wait until outstanding prefixes are released
+	m.UpdateConfig(&Config{PrefixBytes: 1})
+	other := &mockClient{}
+	_ = m.AssignNoncePrefix(other)
+	if len(other.GetExtraNoncePrefix()) != 4 {
+		t.Errorf("Width must not change while prefixes are in use, got %q", other.GetExtraNoncePrefix())
+	}
+}
+
+// chainedClient is a mockClient that reports itself as a karoo proxy
+type chainedClient struct{ mockClient }
+
+func (c *chainedClient) Chained() bool { return true }
+
+func TestChainedPrefixBlocks(t *testing.T) {
+	up := createTestUpstream()
+	m := NewManager(up)
+	m.UpdateConfig(&Config{PrefixBytes: 2, ChainPrefixBytes: 1})
+	up.SetExtranonce("deadbeef", 8)
+
+	miner := &mockClient{}
+	child := &chainedClient{}
+	if err := m.AssignNoncePrefix(miner); err != nil || miner.GetExtraNoncePrefix() != "0001" {
+		t.Fatalf("miner prefix = %q, %v", miner.GetExtraNoncePrefix(), err)
+	}
+	if err := m.AssignNoncePrefix(child); err != nil || child.GetExtraNoncePrefix() != "FF" {
+		t.Fatalf("chained prefix = %q, %v", child.GetExtraNoncePrefix(), err)
+	}
+	if ex1, ex2 := m.GetClientExtranonce(child); ex1 != "deadbeefFF" || ex2 != 7 {
+		t.Errorf("chained extranonce = %s/%d, want deadbeefFF/7", ex1, ex2)
+	}
+	if m.GetStats()["chained_in_use"].(int) != 1 {
+		t.Error("chained block not counted")
+	}
+
+	// blocks are reused and carved downwards
+	m.ReleaseNoncePrefix(child)
+	again, next := &chainedClient{}, &chainedClient{}
+	_ = m.AssignNoncePrefix(again)
+	_ = m.AssignNoncePrefix(next)
+	if again.GetExtraNoncePrefix() != "FF" || next.GetExtraNoncePrefix() != "FE" {
+		t.Errorf("blocks = %q, %q; want FF, FE", again.GetExtraNoncePrefix(), next.GetExtraNoncePrefix())
+	}
+
+	// single prefixes stop below the lowest block, and no block is carved
+	// over them
+	m = NewManager(up)
+	m.UpdateConfig(&Config{PrefixBytes: 2, ChainPrefixBytes: 1})
+	for i := 0; i < 254; i++ {
+		if err := m.AssignNoncePrefix(&chainedClient{}); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 511; i++ {
+		if err := m.AssignNoncePrefix(&mockClient{}); err != nil {
+			t.Fatalf("prefix %d: %v", i, err)
+		}
+	}
+	last := &mockClient{}
+	if err := m.AssignNoncePrefix(last); err != ErrPrefixExhausted {
+		t.Errorf("prefix below the lowest block: %q, %v", last.GetExtraNoncePrefix(), err)
+	}
+	if err := m.AssignNoncePrefix(&chainedClient{}); err != ErrPrefixExhausted {
+		t.Errorf("block zero must not be carved, got %v", err)
+	}
+	if !m.Exhausted() {
+		t.Error("allocator should report exhaustion")
+	}
+
+	// without chain_prefix_bytes a karoo gets an ordinary prefix
+	m = NewManager(up)
+	m.UpdateConfig(&Config{PrefixBytes: 2})
+	plain := &chainedClient{}
+	_ = m.AssignNoncePrefix(plain)
+	if plain.GetExtraNoncePrefix() != "0001" {
+		t.Errorf("prefix = %q, want 0001", plain.GetExtraNoncePrefix())
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig obtains and renews the downstream TLS certificates from Let's
+// Encrypt, or another ACME CA, instead of cert_file and key_file
+type ACMEConfig struct {
+	Enabled bool     `json:"enabled"`
+	Hosts   []string `json:"hosts"` // names certificates are requested for
+	Email   string   `json:"email"` // contact for expiry notices; optional
+	// CacheDir keeps the account key and certificates across restarts;
+	// default "acme-cache"
+	CacheDir string `json:"cache_dir"`
+	// DirectoryURL selects the CA; empty uses Let's Encrypt production
+	DirectoryURL string `json:"directory_url"`
+	// ChallengeListen answers HTTP-01 challenges and must be reachable on
+	// port 80 of every host; default ":80"
+	ChallengeListen string `json:"challenge_listen"`
+	// Dashboard serves http.listen over HTTPS with the same certificates
+	Dashboard bool `json:"dashboard"`
+}
+
+// Validate checks that certificates can be requested
+func (c ACMEConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Hosts) == 0 {
+		return errors.New("hosts is required")
+	}
+	for _, h := range c.Hosts {
+		if h == "" || strings.ContainsAny(h, ":/*") {
+			return errors.New("hosts must be plain DNS names")
+		}
+	}
+	return nil
+}
+
+// challengeListen returns the HTTP-01 challenge address
+func (c ACMEConfig) challengeListen() string {
+	if c.ChallengeListen == "" {
+		return ":80"
+	}
+	return c.ChallengeListen
+}
+
+// newACMEManager creates the certificate manager for cfg, or nil when ACME
+// is disabled
+func newACMEManager(cfg ACMEConfig) *autocert.Manager {
+	if !cfg.Enabled {
+		return nil
+	}
+	dir := cfg.CacheDir
+	if dir == "" {
+		dir = "acme-cache"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+		Email:      cfg.Email,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
+	}
+	return m
+}
+
+// acmeCertificate returns the ACME certificate for a handshake. Miners often
+// connect by address without SNI; they get the first configured host.
+func (p *Proxy) acmeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cfg := p.config()
+	if hello.ServerName == "" && len(cfg.ACME.Hosts) > 0 {
+		h := *hello
+		h.ServerName = cfg.ACME.Hosts[0]
+		hello = &h
+	}
+	return p.acme.GetCertificate(hello)
+}
+
+// ACMELoop answers HTTP-01 challenges until ctx is done. Other requests are
+// redirected to HTTPS.
+func (p *Proxy) ACMELoop(ctx context.Context) {
+	cfg := p.config()
+	addr := cfg.ACME.challengeListen()
+	srv := &http.Server{Addr: addr, Handler: p.acme.HTTPHandler(nil)}
+	go func() {
+		<-ctx.Done()
+		ctx2, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx2)
+	}()
+	log.Printf("acme: answering challenges on %s for %s", addr, strings.Join(cfg.ACME.Hosts, ", "))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("acme: challenge listener: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Build metadata reported by the version endpoints; set by main
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+)
+
+// IdentityConfig controls which identifying strings karoo reveals
+type IdentityConfig struct {
+	UserAgent     string `json:"user_agent"`      // sent to pools in mining.subscribe
+	HideUserAgent bool   `json:"hide_user_agent"` // subscribe without any agent string
+	ServerHeader  string `json:"server_header"`   // HTTP Server header; empty sends none
+	HideVersion   bool   `json:"hide_version"`    // disable the public /version endpoint
+}
+
+// writeJSON encodes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// requireAdmin guards a handler with the admin token. The admin API is
+// disabled (404) when no token is configured.
+func (p *Proxy) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := p.config().Admin.Token
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		got := r.Header.Get("X-Admin-Token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			got = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// withServerHeader sets the configured Server header on every response
+func (p *Proxy) withServerHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hdr := p.config().Identity.ServerHeader; hdr != "" {
+			w.Header().Set("Server", hdr)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerIdentityHandlers adds the public and admin version endpoints
+func (p *Proxy) registerIdentityHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		if p.config().Identity.HideVersion {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]string{"version": Version, "build_time": BuildTime})
+	})
+	mux.HandleFunc("/admin/version", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		id := p.config().Identity
+		writeJSON(w, map[string]interface{}{
+			"version":         Version,
+			"build_time":      BuildTime,
+			"go_version":      runtime.Version(),
+			"user_agent":      id.UserAgent,
+			"hide_user_agent": id.HideUserAgent,
+			"server_header":   id.ServerHeader,
+		})
+	}))
+}
+
+// auditLabel names a message for the allocation audit, folding unknown
+// methods together so miners cannot grow the table
+func auditLabel(source, method string) string {
+	switch method {
+	case "":
+		return source + " response"
+	case stratum.MethodSubscribe, stratum.MethodAuthorize, stratum.MethodSubmit,
+		stratum.MethodSetDifficulty, stratum.MethodNotify, stratum.MethodConfigure,
+		"mining.extranonce.subscribe", "mining.set_extranonce", stratum.MethodSuggestDifficulty:
+		return source + " " + method
+	}
+	return source + " other"
+}
+
+// registerAuditHandlers adds the allocation audit admin endpoint. GET reports
+// per-method averages, POST ?enabled=true|false toggles collection and DELETE
+// clears the collected stats.
+func (p *Proxy) registerAuditHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/allocs", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			p.au.SetEnabled(enabled)
+		case http.MethodDelete:
+			p.au.Reset()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.au.Report())
+	}))
+}
+
+// registerCaptureHandlers adds the traffic capture admin endpoint. GET
+// returns the capture status and the buffered lines (?client= filters by
+// address, IP or worker, ?limit= keeps the newest), POST ?enabled=true|false
+// starts or stops capturing everything, or one ?client=, and DELETE clears
+// the buffer.
+func (p *Proxy) registerCaptureHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/capture", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 0
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(q.Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			target := q.Get("client")
+			if target != "" {
+				p.tap.SetTarget(target, enabled)
+			} else {
+				target = "all clients"
+				p.tap.SetAll(enabled)
+			}
+			log.Printf("admin: capture of %s enabled=%v", target, enabled)
+		case http.MethodDelete:
+			p.tap.Clear()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"status":  p.tap.GetStatus(),
+			"entries": p.tap.Entries(q.Get("client"), limit),
+		})
+	}))
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// aggregateRouting adapts the aggregator to the router's local backend hook:
+// clients are answered locally and only shares meeting the pool difficulty
+// are submitted upstream, all under the upstream user
+type aggregateRouting struct {
+	p *Proxy
+}
+
+// Authorize accepts any worker; upstream only ever sees upstream.user
+func (a aggregateRouting) Authorize(cl routing.Client, worker, password string) bool {
+	log.Printf("aggregate: worker authorized: %s (%s session=%s)", worker, cl.GetAddr(), routing.SessionOf(cl))
+	return true
+}
+
+// Submit validates the share against the client's difficulty
+func (a aggregateRouting) Submit(cl routing.Client, params []any) error {
+	ex1, _ := a.p.up.GetExtranonce()
+	forward, err := a.p.ag.Check(params, ex1, a.p.shareDifficulty(cl))
+	if err != nil {
+		return err
+	}
+	if forward {
+		return routing.ErrForward
+	}
+	return nil
+}
+
+// ObserveUpstream tracks jobs and keeps the pool difficulty away from clients
+func (a aggregateRouting) ObserveUpstream(msg stratum.Message) bool {
+	switch msg.Method {
+	case stratum.MethodSetDifficulty:
+		if arr, ok := msg.Params.([]any); ok && len(arr) > 0 {
+			if v, ok := arr[0].(float64); ok {
+				a.p.ag.SetUpstreamDifficulty(v)
+			}
+		}
+		return false
+	case stratum.MethodNotify:
+		job, ok := a.p.rt.UpstreamJob()
+		if !ok {
+			return true
+		}
+		a.p.ag.AddJob(job)
+		// without vardiff every clean job re-announces the fixed share difficulty
+		if cfg := a.p.config(); job.Clean && !cfg.VarDiff.Enabled {
+			if b, err := json.Marshal(stratum.NewSetDifficultyMessage(cfg.Aggregate.ShareDifficulty)); err == nil {
+				f := stratum.NewFrame(b)
+				a.p.rt.BroadcastFrame(f, stratum.MethodSetDifficulty)
+				f.Release()
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// banRequest is the body accepted by POST /admin/bans
+type banRequest struct {
+	IP              string `json:"ip"`
+	Worker          string `json:"worker"`
+	Reason          string `json:"reason"`
+	DurationSeconds int    `json:"duration_seconds"` // 0 bans until restart
+}
+
+// rejectBannedWorker answers an authorize from a banned worker and reports
+// whether the client must be dropped
+func (p *Proxy) rejectBannedWorker(cl *Client, msg stratum.Message) bool {
+	params, ok := msg.Params.([]interface{})
+	if !ok || len(params) == 0 {
+		return false
+	}
+	worker, _ := params[0].(string)
+	if !p.rl.WorkerBanned(worker) {
+		return false
+	}
+	log.Printf("rejecting client %s session=%s: worker %s is banned", cl.addr, cl.session, worker)
+	p.refuseAuthorize(cl, worker, reasonBannedWorker)
+	_ = cl.WriteJSON(stratum.NewErrorResponse(msg.ID, stratum.CodeUnauthorized, "Worker banned", nil))
+	return true
+}
+
+// dropInvalid disconnects a client that sent n lines that are not JSON-RPC
+// and, when proxy.invalid_ban_seconds is set, bans its address
+func (p *Proxy) dropInvalid(cl *Client, n uint64) {
+	secs := p.config().Proxy.InvalidBanSeconds
+	if secs <= 0 {
+		log.Printf("dropping client %s session=%s: %d invalid lines", cl.addr, cl.session, n)
+		cl.closing(reasonInvalidInput)
+		return
+	}
+	reason := fmt.Sprintf("%d invalid lines", n)
+	log.Printf("banning client %s session=%s for %ds: %s", cl.addr, cl.session, secs, reason)
+	if _, err := p.rl.BanFrom(ratelimit.SourceInput, hostOf(cl.addr), reason, time.Duration(secs)*time.Second); err != nil {
+		log.Printf("could not ban %s session=%s: %v", cl.addr, cl.session, err)
+	}
+	p.ban(cl, reason)
+	p.kickBanned()
+}
+
+// kickBanned disconnects clients covered by a ban added at runtime
+func (p *Proxy) kickBanned() {
+	p.clMu.RLock()
+	defer p.clMu.RUnlock()
+	for cl := range p.clients {
+		if p.rl.IPBanned(cl.c.RemoteAddr()) || p.rl.WorkerBanned(cl.GetWorker()) {
+			log.Printf("disconnecting banned client %s worker=%s session=%s", cl.addr, cl.GetWorker(), cl.session)
+			p.ban(cl, "ban added")
+			_ = cl.Close()
+		}
+	}
+}
+
+// registerBanHandlers adds the ban management admin endpoint. GET lists the
+// active bans, POST adds one from a banRequest body and DELETE ?ip= or
+// ?worker= lifts one. Runtime bans are not written back to the config.
+func (p *Proxy) registerBanHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/bans", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req banRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if (req.IP == "") == (req.Worker == "") || req.DurationSeconds < 0 {
+				http.Error(w, "set exactly one of ip and worker, with a non-negative duration", http.StatusBadRequest)
+				return
+			}
+			d := time.Duration(req.DurationSeconds) * time.Second
+			if req.IP != "" {
+				target, err := p.rl.Ban(req.IP, req.Reason, d)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Printf("admin: banned %s (%s)", target, req.Reason)
+			} else {
+				p.rl.BanWorker(req.Worker, req.Reason, d)
+				log.Printf("admin: banned worker %s (%s)", req.Worker, req.Reason)
+			}
+			p.kickBanned()
+			for _, sub := range p.profiles {
+				sub.kickBanned()
+			}
+		case http.MethodDelete:
+			var removed bool
+			if ip := r.URL.Query().Get("ip"); ip != "" {
+				var err error
+				if removed, err = p.rl.Unban(ip); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else if worker := r.URL.Query().Get("worker"); worker != "" {
+				removed = p.rl.UnbanWorker(worker)
+			} else {
+				http.Error(w, "ip or worker is required", http.StatusBadRequest)
+				return
+			}
+			if !removed {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("admin: lifted ban %s", r.URL.RawQuery)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.rl.Bans())
+	}))
+}
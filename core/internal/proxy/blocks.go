@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// shareJobs bounds the upstream jobs kept to hash accepted shares; replies
+// may arrive after a clean job, so jobs are only dropped by age
+const shareJobs = 64
+
+// recentBlocks bounds the block candidates listed in /status
+const recentBlocks = 16
+
+// bestShare is the highest difficulty accepted share of a worker or of the
+// whole proxy
+type bestShare struct {
+	Difficulty float64 `json:"difficulty"`
+	Worker     string  `json:"worker,omitempty"`
+	Time       int64   `json:"time"`
+}
+
+// blockCandidate is an accepted share that met the network target
+type blockCandidate struct {
+	Time       int64   `json:"time"`
+	Worker     string  `json:"worker"`
+	JobID      string  `json:"job_id"`
+	Difficulty float64 `json:"difficulty"`
+	Network    float64 `json:"network_difficulty"`
+}
+
+// shareScores hashes accepted shares against the jobs they were mined on
+type shareScores struct {
+	mu      sync.Mutex
+	jobs    map[string]stratum.Job
+	order   []string
+	network float64 // of the newest job
+	alg     *stratum.Algorithm
+	best    bestShare
+	workers map[string]bestShare
+	blocks  []blockCandidate
+}
+
+// setAlgorithm selects the proof of work shares are hashed with
+func (s *shareScores) setAlgorithm(a *stratum.Algorithm) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alg = a
+}
+
+// algorithm returns the upstream's proof of work; callers hold s.mu
+func (s *shareScores) algorithm() *stratum.Algorithm {
+	if s.alg == nil {
+		return stratum.SHA256d
+	}
+	return s.alg
+}
+
+// addJob remembers an upstream job
+func (s *shareScores) addJob(j stratum.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]stratum.Job)
+	}
+	if _, ok := s.jobs[j.ID]; !ok {
+		s.order = append(s.order, j.ID)
+	}
+	s.jobs[j.ID] = j
+	for len(s.order) > shareJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.network = stratum.DiffFromBits(j.NBits)
+}
+
+// score hashes a share and records it as a best share when it is one. It
+// returns the share's difficulty, the job's network difficulty, whether the
+// share is the best overall and whether its hash meets the job's nBits
+// target; ok is false when the job is unknown or the share cannot be hashed.
+func (s *shareScores) score(worker, ex1 string, params []any, now time.Time) (diff, network float64, best, block, ok bool) {
+	var fields [6]string
+	for i := 1; i < len(params) && i < 6; i++ {
+		v, _ := params[i].(string)
+		fields[i] = strings.ToLower(v)
+	}
+	s.mu.Lock()
+	j, found := s.jobs[fields[1]]
+	alg := s.algorithm()
+	s.mu.Unlock()
+	if !found {
+		return 0, 0, false, false, false
+	}
+	hash, err := alg.ShareHash(j, ex1, fields[2], fields[3], fields[4], fields[5])
+	if err != nil || hash.Sign() == 0 {
+		return 0, 0, false, false, false
+	}
+	diff = alg.HashDifficulty(hash)
+	target := stratum.BitsTarget(j.NBits)
+	block = target != nil && hash.Cmp(target) <= 0
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := bestShare{Difficulty: diff, Worker: worker, Time: now.Unix()}
+	if best = diff > s.best.Difficulty; best {
+		s.best = b
+	}
+	if s.workers == nil {
+		s.workers = make(map[string]bestShare)
+	}
+	if diff > s.workers[worker].Difficulty {
+		b.Worker = ""
+		s.workers[worker] = b
+	}
+	return diff, stratum.DiffFromBits(j.NBits), best, block, true
+}
+
+// found records a block candidate
+func (s *shareScores) found(c blockCandidate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = append(s.blocks, c)
+	if len(s.blocks) > recentBlocks {
+		s.blocks = s.blocks[1:]
+	}
+}
+
+// jobStage remembers every job the pool sends
+func (p *Proxy) jobStage(next routing.UpstreamHandler) routing.UpstreamHandler {
+	return func(msg stratum.Message, line []byte) {
+		if msg.Method == stratum.MethodNotify {
+			if j, ok := p.rt.UpstreamJob(); ok {
+				p.scores.addJob(j)
+				p.recordJob(j)
+			}
+		}
+		next(msg, line)
+	}
+}
+
+// scoreShare computes the difficulty an accepted share actually met and
+// reports it loudly when it solves a block. Solo mode submits its own blocks.
+func (p *Proxy) scoreShare(ev routing.ShareEvent) {
+	if !ev.Accepted || p.solo != nil || len(ev.Params) < 5 {
+		return
+	}
+	ex1, _ := p.up.GetExtranonce()
+	diff, network, best, block, ok := p.scores.score(ev.Worker, ex1, ev.Params, ev.Time)
+	if !ok {
+		return
+	}
+	if best {
+		p.mx.SetBestShare(diff)
+	}
+	if !block {
+		return
+	}
+	log.Printf("BLOCK CANDIDATE worker=%s session=%s job=%s diff=%.6g network=%.6g", ev.Worker, routing.SessionOf(ev.Client), ev.JobID, diff, network)
+	p.mx.IncrementBlocksFound()
+	p.scores.found(blockCandidate{Time: ev.Time.Unix(), Worker: ev.Worker, JobID: ev.JobID, Difficulty: diff, Network: network})
+	p.emit(events.BlockFound, map[string]interface{}{
+		"worker":             ev.Worker,
+		"session":            routing.SessionOf(ev.Client),
+		"job_id":             ev.JobID,
+		"difficulty":         diff,
+		"network_difficulty": network,
+	})
+}
+
+// bestShareStats reports best shares and block candidates for /status
+func (p *Proxy) bestShareStats() map[string]interface{} {
+	s := &p.scores
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workers := make(map[string]bestShare, len(s.workers))
+	for k, v := range s.workers {
+		workers[k] = v
+	}
+	return map[string]interface{}{
+		"best":               s.best,
+		"network_difficulty": s.network,
+		"blocks_found":       p.mx.BlocksFound.Load(),
+		"blocks":             append([]blockCandidate(nil), s.blocks...),
+		"workers":            workers,
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/configfile"
+)
+
+// ConfigIssue is a problem found by CheckConfig
+type ConfigIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"` // the proxy can still start
+}
+
+// ConfigCheck is the result of a dry-run validation
+type ConfigCheck struct {
+	Valid  bool          `json:"valid"`
+	Error  string        `json:"error,omitempty"` // the config did not load
+	Issues []ConfigIssue `json:"issues"`
+	Config interface{}   `json:"config,omitempty"` // effective config, secrets redacted
+}
+
+// CheckConfig runs the checks loading a config cannot do on its own: listen
+// addresses and conflicts, TLS key pairs, writable state directories and
+// DNS resolution of every upstream host. Nothing is bound or dialed.
+func CheckConfig(ctx context.Context, cfg *Config, resolver *net.Resolver) []ConfigIssue {
+	var issues []ConfigIssue
+	fail := func(field, format string, args ...interface{}) {
+		issues = append(issues, ConfigIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...interface{}) {
+		issues = append(issues, ConfigIssue{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+	}
+
+	// Listen addresses must parse and must not collide
+	ports := map[string]string{}
+	listen := func(field, addr string) {
+		if addr == "" {
+			return
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fail(field, "invalid listen address %q: %v", addr, err)
+			return
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail(field, "invalid port %q", port)
+			return
+		}
+		if other, ok := ports[port]; ok && port != "0" {
+			fail(field, "port %s is also used by %s", port, other)
+			return
+		}
+		ports[port] = field
+	}
+	listen("proxy.listen", cfg.Proxy.Listen)
+	listen("http.listen", cfg.HTTP.Listen)
+	listen("http.pprof_listen", cfg.HTTP.PprofListen)
+	if cfg.Public.Enabled {
+		listen("public.listen", cfg.Public.Listen)
+	}
+	for i, l := range cfg.Listeners {
+		listen(fmt.Sprintf("listeners[%d].listen", i), l.Listen)
+	}
+	if cfg.ACME.Enabled {
+		listen("acme.challenge_listen", cfg.ACME.challengeListen())
+	}
+
+	// TLS key pairs must load; without cert_file ACME provides them
+	keyPair := func(field, cert, key string) {
+		if cert == "" && cfg.ACME.Enabled {
+			return
+		}
+		if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+			fail(field, "%v", err)
+		}
+	}
+	if cfg.Proxy.TLS.Enabled {
+		keyPair("proxy.tls", cfg.Proxy.TLS.Cert, cfg.Proxy.TLS.Key)
+		for i, r := range cfg.SNIRoutes {
+			if r.CertFile != "" {
+				keyPair(fmt.Sprintf("sni_routes[%d]", i), r.CertFile, r.KeyFile)
+			}
+		}
+	}
+	if cfg.HTTP.TLS.Enabled {
+		keyPair("http.tls", cfg.HTTP.TLS.Cert, cfg.HTTP.TLS.Key)
+	}
+	for i, l := range cfg.Listeners {
+		if l.TLS.Enabled {
+			keyPair(fmt.Sprintf("listeners[%d].tls", i), l.TLS.Cert, l.TLS.Key)
+		}
+	}
+
+	// Files the proxy writes need an existing directory
+	dir := func(field, path string) {
+		if path == "" {
+			return
+		}
+		if st, err := os.Stat(filepath.Dir(path)); err != nil || !st.IsDir() {
+			warn(field, "directory of %s does not exist", path)
+		}
+	}
+	if cfg.Journal.Enabled {
+		dir("journal.path", cfg.Journal.Path)
+	}
+	if cfg.Availability.Enabled {
+		dir("availability.state_file", cfg.Availability.StateFile)
+	}
+	dir("vardiff.state_file", cfg.VarDiff.StateFile)
+	dir("workers.file", cfg.Workers.File)
+
+	// Upstreams need a sane backoff and a resolvable host
+	upstream := func(field string, u UpstreamConfig) {
+		if u.BackoffMinMs > u.BackoffMaxMs {
+			fail(field, "backoff_min_ms (%d) exceeds backoff_max_ms (%d)", u.BackoffMinMs, u.BackoffMaxMs)
+		}
+		if u.Port <= 0 || u.Port > 65535 {
+			fail(field+".port", "invalid port %d", u.Port)
+		}
+		if err := u.ValidateProxy(); err != nil {
+			fail(field+".socks_proxy", "%v", err)
+		}
+		// a proxy that resolves hostnames may reach hosts, like .onion, that
+		// local DNS cannot
+		sp := u.socksProxy()
+		if sp.ResolvesRemotely() {
+			return
+		}
+		rctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if _, err := resolver.LookupHost(rctx, u.Host); err != nil {
+			fail(field+".host", "cannot resolve %s: %v", u.Host, err)
+		}
+	}
+	upstream("upstream", cfg.Upstream)
+	for i, b := range cfg.Backups {
+		upstream(fmt.Sprintf("backups[%d]", i), b)
+	}
+	for name, pc := range cfg.Profiles {
+		upstream("profiles."+name+".upstream", pc.Upstream)
+		for i, b := range pc.Backups {
+			upstream(fmt.Sprintf("profiles.%s.backups[%d]", name, i), b)
+		}
+	}
+
+	if cfg.VarDiff.Enabled && cfg.VarDiff.MinDiff > cfg.VarDiff.MaxDiff {
+		fail("vardiff", "min_diff (%d) exceeds max_diff (%d)", cfg.VarDiff.MinDiff, cfg.VarDiff.MaxDiff)
+	}
+	return issues
+}
+
+// RunConfigCheck validates a loaded config and renders the effective config
+// with secrets redacted. loadErr is the error from loading it, if any.
+func RunConfigCheck(ctx context.Context, cfg *Config, loadErr error) ConfigCheck {
+	if loadErr != nil {
+		return ConfigCheck{Error: loadErr.Error(), Issues: []ConfigIssue{}}
+	}
+	res := ConfigCheck{Valid: true, Issues: CheckConfig(ctx, cfg, net.DefaultResolver)}
+	if res.Issues == nil {
+		res.Issues = []ConfigIssue{}
+	}
+	for _, is := range res.Issues {
+		if !is.Warning {
+			res.Valid = false
+		}
+	}
+	if redacted, err := configfile.Redact(cfg); err == nil {
+		res.Config = redacted
+	}
+	return res
+}
+
+// SetConfigLoader registers how /admin/config/check reads the config from
+// disk; without it the running config is checked
+func (p *Proxy) SetConfigLoader(load func() (*Config, error)) {
+	p.loadConfig = load
+}
+
+// registerConfigHandlers adds the admin dry-run validation endpoint
+func (p *Proxy) registerConfigHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/config/check", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := p.config(), error(nil)
+		if p.loadConfig != nil {
+			cfg, err = p.loadConfig()
+		}
+		writeJSON(w, RunConfigCheck(r.Context(), cfg, err))
+	}))
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Duplicate worker policies
+const (
+	DuplicateAllow  = "allow"  // accept silently
+	DuplicateWarn   = "warn"   // accept, log and record an event
+	DuplicateRename = "rename" // accept under a suffixed worker name
+	DuplicateReject = "reject" // refuse the newer connection
+)
+
+// maxDuplicateEvents bounds the events kept for /status
+const maxDuplicateEvents = 50
+
+// DuplicateConfig controls how a worker name connected from several
+// addresses at once (typically a cloned rig config) is handled
+type DuplicateConfig struct {
+	Policy string `json:"policy"`
+}
+
+// DuplicateEvent records one detected duplicate
+type DuplicateEvent struct {
+	Time      time.Time `json:"time"`
+	Worker    string    `json:"worker"`
+	Addr      string    `json:"addr"`
+	OtherAddr string    `json:"other_addr"`
+	Action    string    `json:"action"`
+	Renamed   string    `json:"renamed,omitempty"`
+}
+
+// duplicateLog keeps the most recent duplicate events
+type duplicateLog struct {
+	mu     sync.Mutex
+	events []DuplicateEvent
+}
+
+func (d *duplicateLog) add(ev DuplicateEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, ev)
+	if len(d.events) > maxDuplicateEvents {
+		d.events = d.events[len(d.events)-maxDuplicateEvents:]
+	}
+}
+
+func (d *duplicateLog) list() []DuplicateEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DuplicateEvent(nil), d.events...)
+}
+
+// hostOf returns the IP part of a client address
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// workerConnections returns the addresses of other clients using a worker name
+func (p *Proxy) workerConnections(cl *Client, worker string) []string {
+	var addrs []string
+	p.clMu.RLock()
+	defer p.clMu.RUnlock()
+	for other := range p.clients {
+		if other != cl && other.GetWorker() == worker {
+			addrs = append(addrs, other.addr)
+		}
+	}
+	return addrs
+}
+
+// checkDuplicate applies the duplicate policy to an authorize request. It may
+// rewrite the worker name in msg and reports whether the client must be
+// dropped.
+func (p *Proxy) checkDuplicate(cl *Client, msg *stratum.Message) bool {
+	policy := p.config().Duplicates.Policy
+	if policy == "" || policy == DuplicateAllow {
+		return false
+	}
+	params, ok := msg.Params.([]interface{})
+	if !ok || len(params) == 0 {
+		return false
+	}
+	worker, _ := params[0].(string)
+	if worker == "" {
+		return false
+	}
+
+	var other string
+	for _, addr := range p.workerConnections(cl, worker) {
+		if hostOf(addr) != hostOf(cl.addr) {
+			other = addr
+			break
+		}
+	}
+	if other == "" {
+		return false
+	}
+
+	ev := DuplicateEvent{Time: time.Now(), Worker: worker, Addr: cl.addr, OtherAddr: other, Action: policy}
+	drop := false
+	switch policy {
+	case DuplicateRename:
+		for n := 2; ; n++ {
+			name := fmt.Sprintf("%s_%d", worker, n)
+			if len(p.workerConnections(cl, name)) == 0 {
+				ev.Renamed = name
+				params[0] = name
+				break
+			}
+		}
+		log.Printf("duplicate worker %s from %s session=%s (also on %s): renamed to %s", worker, cl.addr, cl.session, other, ev.Renamed)
+	case DuplicateReject:
+		drop = true
+		log.Printf("duplicate worker %s from %s session=%s (also on %s): rejected", worker, cl.addr, cl.session, other)
+		p.refuseAuthorize(cl, worker, reasonDuplicateWorker)
+		_ = cl.WriteJSON(stratum.NewErrorResponse(msg.ID, stratum.CodeUnauthorized, "Worker already connected from another address", nil))
+	default:
+		log.Printf("duplicate worker %s from %s session=%s (also on %s)", worker, cl.addr, cl.session, other)
+	}
+	p.dup.add(ev)
+	p.mx.IncrementDuplicateWorkers(policy)
+	return drop
+}
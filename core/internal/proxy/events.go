@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+)
+
+// EventsLoop delivers queued events to the configured webhooks
+func (p *Proxy) EventsLoop(ctx context.Context) {
+	p.ev.Run(ctx)
+}
+
+// emit queues an event, tagging it with the profile it came from
+func (p *Proxy) emit(typ string, data map[string]interface{}) {
+	if p.name != "" {
+		data["profile"] = p.name
+	}
+	p.ev.Emit(typ, data)
+}
+
+// upstreamEvent describes the active upstream for upstream events
+func upstreamEvent(idx int, uc UpstreamConfig) map[string]interface{} {
+	return map[string]interface{}{"index": idx, "host": uc.Host, "port": uc.Port}
+}
+
+// onBan reports bans issued at runtime
+func (p *Proxy) onBan(b ratelimit.Ban) {
+	data := map[string]interface{}{"source": b.Source, "reason": b.Reason}
+	if b.IP != "" {
+		data["ip"] = b.IP
+	}
+	if b.Worker != "" {
+		data["worker"] = b.Worker
+	}
+	if !b.Expires.IsZero() {
+		data["expires"] = b.Expires
+	}
+	p.emit(events.BanIssued, data)
+}
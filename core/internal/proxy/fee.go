@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Fee modes
+const (
+	FeeModeSubmit    = "submit"
+	FeeModeTimeslice = "timeslice"
+)
+
+// FeeConfig credits a fixed share of the clients' work to another account
+type FeeConfig struct {
+	Enabled bool    `json:"enabled"`
+	Percent float64 `json:"percent"` // of shares (submit) or of mining time (timeslice)
+	// Mode "submit" (default) sends every share that falls due as User on
+	// the same upstream; "timeslice" mines on Upstream for Percent of every
+	// period
+	Mode          string         `json:"mode"`
+	User          string         `json:"user"`
+	Pass          string         `json:"pass"`
+	Upstream      UpstreamConfig `json:"upstream"`
+	PeriodSeconds int            `json:"period_seconds"` // timeslice period; default 3600
+}
+
+// Validate checks the fee settings
+func (c FeeConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Percent <= 0 || c.Percent >= 100 {
+		return errors.New("percent must be above 0 and below 100")
+	}
+	if c.PeriodSeconds < 0 {
+		return errors.New("period_seconds must not be negative")
+	}
+	switch c.mode() {
+	case FeeModeSubmit:
+		if c.User == "" {
+			return errors.New("user is required in submit mode")
+		}
+	case FeeModeTimeslice:
+		if c.Upstream.Host == "" {
+			return errors.New("upstream.host is required in timeslice mode")
+		}
+	default:
+		return errors.New("mode must be submit or timeslice")
+	}
+	return nil
+}
+
+func (c FeeConfig) mode() string {
+	if c.Mode == "" {
+		return FeeModeSubmit
+	}
+	return c.Mode
+}
+
+func (c FeeConfig) period() time.Duration {
+	if c.PeriodSeconds <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.PeriodSeconds) * time.Second
+}
+
+// inSlice reports whether the fee upstream is due elapsed into the run. The
+// fee slice closes each period so a restart does not begin with it.
+func (c FeeConfig) inSlice(elapsed time.Duration) bool {
+	period := c.period()
+	slice := time.Duration(float64(period) * c.Percent / 100)
+	return elapsed%period >= period-slice
+}
+
+// account returns the account credited with the fee
+func (c FeeConfig) account() string {
+	if c.mode() == FeeModeTimeslice {
+		return c.Upstream.User
+	}
+	return c.User
+}
+
+// feeMeter keeps the fee accounting. Shares count once they are sent.
+type feeMeter struct {
+	mu        sync.Mutex
+	due       float64 // shares owed to the fee account, below one
+	shares    uint64
+	feeShares uint64
+	work      float64 // accepted difficulty
+	feeWork   float64
+	feeBad    uint64 // fee shares the pool rejected
+	mining    time.Duration
+	feeTime   time.Duration
+}
+
+// take reports whether a share falls due for the fee account
+func (m *feeMeter) take(percent float64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.due += percent / 100
+	if m.due < 1 {
+		return false
+	}
+	m.due--
+	return true
+}
+
+// untake gives back what take did for a share that was never sent, so the
+// next share falls due in its place
+func (m *feeMeter) untake(percent float64, fee bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.due -= percent / 100
+	if fee {
+		m.due++
+	}
+}
+
+// count counts a share mined while the fee upstream was or was not active
+func (m *feeMeter) count(fee bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shares++
+	if fee {
+		m.feeShares++
+	}
+}
+
+// result accounts the pool's answer to a share
+func (m *feeMeter) result(accepted, fee bool, diff float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case accepted && fee:
+		m.work += diff
+		m.feeWork += diff
+	case accepted:
+		m.work += diff
+	case fee:
+		m.feeBad++
+	}
+}
+
+// tick adds connected time
+func (m *feeMeter) tick(d time.Duration, fee bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mining += d
+	if fee {
+		m.feeTime += d
+	}
+}
+
+// feeStage redirects the shares that fall due to the fee account in submit
+// mode
+func (p *Proxy) feeStage(next routing.ClientHandler) routing.ClientHandler {
+	return func(cl routing.Client, msg stratum.Message) {
+		fc := p.config().Fee
+		if msg.Method == stratum.MethodSubmit && fc.Enabled && fc.mode() == FeeModeSubmit {
+			if arr, ok := msg.Params.([]any); ok && len(arr) > 0 && p.fee.take(fc.Percent) {
+				arr[0] = fc.User
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// feeSubmitted counts a share for the fee once it is sent. A share that
+// never left gives back its place in the submit mode schedule.
+func (p *Proxy) feeSubmitted(cl routing.Client, msg stratum.Message, sent bool) {
+	fc := p.config().Fee
+	if !fc.Enabled {
+		return
+	}
+	fee := p.feeOn.Load()
+	if fc.mode() == FeeModeSubmit {
+		arr, _ := msg.Params.([]any)
+		fee = len(arr) > 0 && arr[0] == fc.User
+		if !sent {
+			p.fee.untake(fc.Percent, fee)
+			return
+		}
+	}
+	if sent {
+		p.fee.count(fee)
+	}
+}
+
+// feeResult accounts a submit outcome for the fee
+func (p *Proxy) feeResult(ev routing.ShareEvent) {
+	fc := p.config().Fee
+	if !fc.Enabled {
+		return
+	}
+	fee := p.feeOn.Load()
+	if fc.mode() == FeeModeSubmit {
+		fee = ev.User == fc.User
+	}
+	p.fee.result(ev.Accepted, fee, ev.Difficulty)
+}
+
+// authorizeFee authorizes the fee account on a new upstream connection in
+// submit mode; its answer goes unmatched, and a refusal shows up as rejected
+// fee shares
+func (p *Proxy) authorizeFee() {
+	fc := p.config().Fee
+	if !fc.Enabled || fc.mode() != FeeModeSubmit {
+		return
+	}
+	if _, err := p.up.Send(stratum.NewAuthorizeMessage(fc.User, fc.Pass)); err != nil {
+		log.Printf("fee: authorize %s: %v", fc.User, err)
+	}
+}
+
+// FeeLoop moves to the fee upstream and back on the timeslice schedule and
+// accounts mining time
+func (p *Proxy) FeeLoop(ctx context.Context) {
+	start := time.Now()
+	last := start
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if p.mx.UpConnected.Load() {
+				p.fee.tick(now.Sub(last), p.feeOn.Load())
+			}
+			last = now
+
+			fc := p.config().Fee
+			want := fc.Enabled && fc.mode() == FeeModeTimeslice && fc.inSlice(now.Sub(start))
+			if p.feeSlice.Swap(want) == want || !p.mx.UpConnected.Load() {
+				continue
+			}
+			if want {
+				log.Printf("fee: mining for %s on %s", fc.Upstream.User, fc.Upstream.addr())
+			} else {
+				log.Printf("fee: slice over, back to the main upstream")
+			}
+			p.switching.Store(true)
+			p.up.Close()
+		}
+	}
+}
+
+// feeStats reports the fee accounting for /status
+func (p *Proxy) feeStats() map[string]interface{} {
+	fc := p.config().Fee
+	m := &p.fee
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var effective float64
+	if m.work > 0 {
+		effective = m.feeWork / m.work * 100
+	}
+	out := map[string]interface{}{
+		"mode":              fc.mode(),
+		"percent":           fc.Percent,
+		"account":           fc.account(),
+		"shares":            m.shares,
+		"fee_shares":        m.feeShares,
+		"fee_rejected":      m.feeBad,
+		"accepted_work":     m.work,
+		"fee_work":          m.feeWork,
+		"effective_percent": effective,
+	}
+	if fc.mode() == FeeModeTimeslice {
+		out["active"] = p.feeOn.Load()
+		out["mining_seconds"] = int64(m.mining.Seconds())
+		out["fee_seconds"] = int64(m.feeTime.Seconds())
+	}
+	return out
+}
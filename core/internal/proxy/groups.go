@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+)
+
+// GroupRule labels the clients it matches for reporting. A client is in every
+// group whose rule matches its address, worker name or listener.
+type GroupRule struct {
+	Name           string   `json:"name"`
+	IPRanges       []string `json:"ip_ranges"` // addresses or CIDR blocks
+	WorkerPrefixes []string `json:"worker_prefixes"`
+	Listeners      []string `json:"listeners"` // names from listeners
+}
+
+// ValidateGroups checks that group names are set and unique and that the
+// IP ranges parse
+func ValidateGroups(rules []GroupRule) error {
+	seen := map[string]bool{}
+	for _, g := range rules {
+		if g.Name == "" || seen[g.Name] {
+			return fmt.Errorf("group names must be set and unique (%q)", g.Name)
+		}
+		seen[g.Name] = true
+		for _, r := range g.IPRanges {
+			if _, err := ratelimit.ParseCIDR(r); err != nil {
+				return fmt.Errorf("group %s: %w", g.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// groupRule is a GroupRule with its ranges parsed
+type groupRule struct {
+	GroupRule
+	nets []*net.IPNet
+}
+
+// matches reports whether a client falls in the group
+func (g *groupRule) matches(ip net.IP, worker, listener string) bool {
+	if listener != "" && slices.Contains(g.Listeners, listener) {
+		return true
+	}
+	for _, prefix := range g.WorkerPrefixes {
+		if worker != "" && strings.HasPrefix(worker, prefix) {
+			return true
+		}
+	}
+	for _, n := range g.nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupCounter holds the share totals of one group
+type groupCounter struct {
+	accepted uint64
+	rejected uint64
+	hr       *hashMeter
+}
+
+// clientGroups classifies clients into the configured groups and totals their
+// shares; the main proxy and its profiles share one
+type clientGroups struct {
+	mu     sync.Mutex
+	rules  []groupRule
+	counts map[string]*groupCounter
+}
+
+func newClientGroups(rules []GroupRule) *clientGroups {
+	g := &clientGroups{}
+	g.UpdateConfig(rules)
+	return g
+}
+
+// UpdateConfig applies new rules. Totals of groups still configured are
+// kept; those of removed groups are dropped.
+func (g *clientGroups) UpdateConfig(rules []GroupRule) {
+	compiled := make([]groupRule, 0, len(rules))
+	for _, r := range rules {
+		gr := groupRule{GroupRule: r}
+		for _, s := range r.IPRanges {
+			if n, err := ratelimit.ParseCIDR(s); err == nil {
+				gr.nets = append(gr.nets, n)
+			}
+		}
+		compiled = append(compiled, gr)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	counts := make(map[string]*groupCounter, len(compiled))
+	for _, r := range compiled {
+		if c, ok := g.counts[r.Name]; ok {
+			counts[r.Name] = c
+		} else {
+			counts[r.Name] = &groupCounter{hr: newHashMeter(publicHashrateWindow)}
+		}
+	}
+	g.rules, g.counts = compiled, counts
+}
+
+// enabled reports whether any group is configured
+func (g *clientGroups) enabled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.rules) > 0
+}
+
+// of returns the groups of a client
+func (g *clientGroups) of(cl *Client) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.rules) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(hostOf(cl.addr))
+	listener := ""
+	if cl.ln != nil {
+		listener = cl.ln.cfg.Name
+	}
+	var out []string
+	for i := range g.rules {
+		if g.rules[i].matches(ip, cl.GetWorker(), listener) {
+			out = append(out, g.rules[i].Name)
+		}
+	}
+	return out
+}
+
+// record adds a share to the totals of the given groups
+func (g *clientGroups) record(groups []string, ev routing.ShareEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, name := range groups {
+		c, ok := g.counts[name]
+		if !ok {
+			continue
+		}
+		if !ev.Accepted {
+			c.rejected++
+			continue
+		}
+		c.accepted++
+		if ev.Difficulty > 0 {
+			c.hr.add(ev.Time, ev.Difficulty)
+		}
+	}
+}
+
+// GroupStats summarizes one group for /status and the periodic report
+type GroupStats struct {
+	Name          string  `json:"name"`
+	Clients       int     `json:"clients"`
+	Accepted      uint64  `json:"accepted"`
+	Rejected      uint64  `json:"rejected"`
+	RejectPercent float64 `json:"reject_percent"`
+	HashrateHs    float64 `json:"hashrate_hs"`
+}
+
+// recordGroups credits a share to the groups of its client
+func (p *Proxy) recordGroups(ev routing.ShareEvent) {
+	cl, ok := ev.Client.(*Client)
+	if !ok {
+		return
+	}
+	groups := p.grp.of(cl)
+	p.grp.record(groups, ev)
+	for _, name := range groups {
+		p.mx.ObserveGroupShare(name, ev.Accepted, ev.Difficulty)
+	}
+}
+
+// groupStats totals every group over this proxy and its profiles, sorted by
+// name
+func (p *Proxy) groupStats() []GroupStats {
+	clients := map[string]int{}
+	for _, px := range append([]*Proxy{p}, p.profileList()...) {
+		px.clMu.RLock()
+		for cl := range px.clients {
+			for _, name := range p.grp.of(cl) {
+				clients[name]++
+			}
+		}
+		px.clMu.RUnlock()
+	}
+
+	now := time.Now()
+	p.grp.mu.Lock()
+	out := make([]GroupStats, 0, len(p.grp.counts))
+	for name, c := range p.grp.counts {
+		st := GroupStats{
+			Name:       name,
+			Clients:    clients[name],
+			Accepted:   c.accepted,
+			Rejected:   c.rejected,
+			HashrateHs: c.hr.rate(now),
+		}
+		if total := c.accepted + c.rejected; total > 0 {
+			st.RejectPercent = float64(c.rejected) * 100 / float64(total)
+		}
+		out = append(out, st)
+	}
+	p.grp.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// logGroups writes one periodic report line per group
+func (p *Proxy) logGroups() {
+	for _, st := range p.groupStats() {
+		log.Printf("Periodic Report group=%s | clients %d | accepted %d rejected %d (%.1f%%) | hashrate %.3f GH/s", st.Name, st.Clients, st.Accepted, st.Rejected, st.RejectPercent, st.HashrateHs/1e9)
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HistoryLoop samples the connected clients of the proxy and its profiles
+// into the history series
+func (p *Proxy) HistoryLoop(ctx context.Context) {
+	t := time.NewTicker(10 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n := p.mx.ClientsActive.Load()
+			for _, sub := range p.profiles {
+				n += sub.mx.ClientsActive.Load()
+			}
+			p.hist.SampleClients(now, n)
+		}
+	}
+}
+
+// handleHistory serves the history series. ?since= takes unix seconds and
+// ?limit= keeps the newest points.
+func (p *Proxy) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !p.config().History.Enabled {
+		http.Error(w, "history is disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "since must be a unix time in seconds", http.StatusBadRequest)
+			return
+		}
+		since = time.Unix(n, 0)
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, map[string]interface{}{
+		"resolution_seconds": int64(p.hist.Resolution().Seconds()),
+		"points":             p.hist.Points(time.Now(), since, limit),
+	})
+}
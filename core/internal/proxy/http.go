@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// HTTPConfig configures the status, metrics and admin HTTP listener
+type HTTPConfig struct {
+	Listen string `json:"listen"`
+	Pprof  bool   `json:"pprof"` // serve /debug/pprof
+	// PprofListen moves pprof to its own loopback-only address instead of
+	// listen
+	PprofListen string          `json:"pprof_listen"`
+	Auth        HTTPAuthConfig  `json:"auth"`
+	Readiness   ReadinessConfig `json:"readiness"`
+	TLS         struct {
+		Enabled bool   `json:"enabled"`
+		Cert    string `json:"cert_file"`
+		Key     string `json:"key_file"`
+	} `json:"tls"`
+}
+
+// HTTPAuthConfig guards every endpoint of http.listen but the probes and
+// /public. Either credential is accepted when both are set, and so is the
+// admin token.
+type HTTPAuthConfig struct {
+	Username string `json:"username"` // basic auth, with Password
+	Password string `json:"password"`
+	Token    string `json:"token"` // bearer token
+}
+
+// ReadinessConfig sets what /readyz requires besides the client listener
+// accepting connections
+type ReadinessConfig struct {
+	// SkipUpstream reports ready without a pool connection
+	SkipUpstream bool `json:"skip_upstream"`
+	// MaxJobAgeSec also requires a job from the pool this recent; 0 skips
+	MaxJobAgeSec int `json:"max_job_age_sec"`
+}
+
+// enabled reports whether any credential is configured
+func (c HTTPAuthConfig) enabled() bool {
+	return c.Username != "" || c.Token != ""
+}
+
+// Validate checks the credentials, the TLS files and the pprof address
+func (c HTTPConfig) Validate() error {
+	if (c.Auth.Username == "") != (c.Auth.Password == "") {
+		return errors.New("auth username and password must be set together")
+	}
+	if c.Readiness.MaxJobAgeSec < 0 {
+		return errors.New("readiness.max_job_age_sec must not be negative")
+	}
+	if c.TLS.Enabled && (c.TLS.Cert == "" || c.TLS.Key == "") {
+		return errors.New("tls requires cert_file and key_file")
+	}
+	if c.PprofListen != "" {
+		if !c.Pprof {
+			return errors.New("pprof_listen requires pprof")
+		}
+		host, _, err := net.SplitHostPort(c.PprofListen)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return errors.New("pprof_listen must be a loopback address")
+		}
+	}
+	return nil
+}
+
+// authorized reports whether r carries a configured credential
+func (p *Proxy) authorized(r *http.Request) bool {
+	cfg := p.config()
+	auth := cfg.HTTP.Auth
+	match := func(got, want string) bool {
+		return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		return match(user, auth.Username) && match(pass, auth.Password)
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return match(bearer, auth.Token) || match(bearer, cfg.Admin.Token)
+	}
+	return match(r.Header.Get("X-Admin-Token"), cfg.Admin.Token)
+}
+
+// notReady lists why the proxy should not receive miners yet: the listener
+// must be accepting and, unless skipped, the upstream handshake done with an
+// extranonce to hand out
+func (p *Proxy) notReady(now time.Time) []string {
+	var out []string
+	if !p.listening.Load() {
+		out = append(out, "listener not accepting")
+	}
+	rc := p.config().HTTP.Readiness
+	if rc.SkipUpstream {
+		return out
+	}
+	if !p.nm.UpstreamReady() {
+		return append(out, "upstream not ready")
+	}
+	if rc.MaxJobAgeSec > 0 {
+		last := p.mx.LastNotifyUnix.Load()
+		if last == 0 || now.Sub(time.Unix(last, 0)) > time.Duration(rc.MaxJobAgeSec)*time.Second {
+			out = append(out, "no recent job from upstream")
+		}
+	}
+	return out
+}
+
+// Ready reports whether the proxy meets the readiness criteria of /readyz
+func (p *Proxy) Ready() bool {
+	return len(p.notReady(time.Now())) == 0
+}
+
+// serveLive answers the liveness probe: the process is up and serving HTTP
+func serveLive(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// serveReady answers the readiness probe with 503 and the reasons until
+// miners can be served
+func (p *Proxy) serveReady(w http.ResponseWriter, r *http.Request) {
+	if reasons := p.notReady(time.Now()); len(reasons) > 0 {
+		http.Error(w, "not ready: "+strings.Join(reasons, "; "), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ready"))
+}
+
+// probePaths are served without credentials so orchestrators can probe them
+var probePaths = map[string]bool{"/healthz": true, "/livez": true, "/readyz": true}
+
+// withHTTPAuth requires http.auth credentials on every request but the
+// probes, which orchestrators call anonymously, and the public stats
+func (p *Proxy) withHTTPAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		open := probePaths[r.URL.Path] || r.URL.Path == "/public"
+		auth := p.config().HTTP.Auth
+		if !auth.enabled() || open || p.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if auth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="karoo"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// registerPprof adds the runtime profiling endpoints to mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// PprofServe serves the profiling endpoints on http.pprof_listen until ctx
+// is done
+func (p *Proxy) PprofServe(ctx context.Context) {
+	cfg := p.config()
+	mux := http.NewServeMux()
+	registerPprof(mux)
+	srv := &http.Server{Addr: cfg.HTTP.PprofListen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		ctx2, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx2)
+	}()
+	log.Printf("pprof: listening on %s", cfg.HTTP.PprofListen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("pprof err: %v", err)
+	}
+}
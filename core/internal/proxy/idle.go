@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/idle"
+)
+
+// idleWorkers lists the authorized clients of the proxy and its profiles for
+// the idle watchdog
+func (p *Proxy) idleWorkers() []idle.Worker {
+	var out []idle.Worker
+	for _, px := range append([]*Proxy{p}, p.profileList()...) {
+		px.clMu.RLock()
+		for cl := range px.clients {
+			worker := cl.GetWorker()
+			if worker == "" || !cl.handshakeDone.Load() {
+				continue
+			}
+			last := cl.connected
+			if ms := cl.GetLastAccept(); ms > 0 {
+				last = time.UnixMilli(ms)
+			}
+			out = append(out, idle.Worker{Name: worker, Addr: cl.addr, LastShare: last})
+		}
+		px.clMu.RUnlock()
+	}
+	return out
+}
+
+// IdleLoop alerts on connected workers that stopped submitting accepted shares
+func (p *Proxy) IdleLoop(ctx context.Context) {
+	p.id.Run(ctx, p.idleWorkers, p.mx.SetIdleWorkers)
+}
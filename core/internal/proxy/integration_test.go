@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/carlosrabelo/karoo/core/internal/metrics"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
 )
 
@@ -18,12 +20,17 @@ func TestProxyIntegration(t *testing.T) {
 	// Create test configuration
 	cfg := &Config{
 		Proxy: struct {
-			Listen       string `json:"listen"`
-			ClientIdleMs int    `json:"client_idle_ms"`
-			MaxClients   int    `json:"max_clients"`
-			ReadBuf      int    `json:"read_buf"`
-			WriteBuf     int    `json:"write_buf"`
-			TLS          struct {
+			Listen            string `json:"listen"`
+			ClientIdleMs      int    `json:"client_idle_ms"`
+			MaxClients        int    `json:"max_clients"`
+			ReadBuf           int    `json:"read_buf"`
+			WriteBuf          int    `json:"write_buf"`
+			IdleGraceMs       int    `json:"idle_grace_ms"`
+			MaxLineBytes      int    `json:"max_line_bytes"`
+			MaxInvalidLines   int    `json:"max_invalid_lines"`
+			InvalidBanSeconds int    `json:"invalid_ban_seconds"`
+			KeepUpstream      bool   `json:"keep_upstream"`
+			TLS               struct {
 				Enabled bool   `json:"enabled"`
 				Cert    string `json:"cert_file"`
 				Key     string `json:"key_file"`
@@ -42,24 +49,7 @@ func TestProxyIntegration(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		Upstream: struct {
-			Host               string `json:"host"`
-			Port               int    `json:"port"`
-			User               string `json:"user"`
-			Pass               string `json:"pass"`
-			TLS                bool   `json:"tls"`
-			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
-			BackoffMinMs       int    `json:"backoff_min_ms"`
-			BackoffMaxMs       int    `json:"backoff_max_ms"`
-			SocksProxy         struct {
-				Enabled  bool   `json:"enabled"`
-				Type     string `json:"type"`
-				Host     string `json:"host"`
-				Port     int    `json:"port"`
-				Username string `json:"username"`
-				Password string `json:"password"`
-			} `json:"socks_proxy"`
-		}{
+		Upstream: UpstreamConfig{
 			Host:         "127.0.0.1",
 			Port:         0, // Will be set to mock server
 			User:         "testuser",
@@ -78,19 +68,10 @@ func TestProxyIntegration(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		HTTP: struct {
-			Listen string `json:"listen"`
-			Pprof  bool   `json:"pprof"`
-		}{
+		HTTP: HTTPConfig{
 			Listen: "127.0.0.1:0", // Random port
 		},
-		VarDiff: struct {
-			Enabled       bool `json:"enabled"`
-			TargetSeconds int  `json:"target_seconds"`
-			MinDiff       int  `json:"min_diff"`
-			MaxDiff       int  `json:"max_diff"`
-			AdjustEveryMs int  `json:"adjust_every_ms"`
-		}{
+		VarDiff: VarDiffConfig{
 			Enabled:       false, // Disable for simpler test
 			TargetSeconds: 15,
 			MinDiff:       1000,
@@ -315,12 +296,17 @@ func TestEndToEndFlow(t *testing.T) {
 	// Create proxy configuration
 	cfg := &Config{
 		Proxy: struct {
-			Listen       string `json:"listen"`
-			ClientIdleMs int    `json:"client_idle_ms"`
-			MaxClients   int    `json:"max_clients"`
-			ReadBuf      int    `json:"read_buf"`
-			WriteBuf     int    `json:"write_buf"`
-			TLS          struct {
+			Listen            string `json:"listen"`
+			ClientIdleMs      int    `json:"client_idle_ms"`
+			MaxClients        int    `json:"max_clients"`
+			ReadBuf           int    `json:"read_buf"`
+			WriteBuf          int    `json:"write_buf"`
+			IdleGraceMs       int    `json:"idle_grace_ms"`
+			MaxLineBytes      int    `json:"max_line_bytes"`
+			MaxInvalidLines   int    `json:"max_invalid_lines"`
+			InvalidBanSeconds int    `json:"invalid_ban_seconds"`
+			KeepUpstream      bool   `json:"keep_upstream"`
+			TLS               struct {
 				Enabled bool   `json:"enabled"`
 				Cert    string `json:"cert_file"`
 				Key     string `json:"key_file"`
@@ -339,24 +325,7 @@ func TestEndToEndFlow(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		Upstream: struct {
-			Host               string `json:"host"`
-			Port               int    `json:"port"`
-			User               string `json:"user"`
-			Pass               string `json:"pass"`
-			TLS                bool   `json:"tls"`
-			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
-			BackoffMinMs       int    `json:"backoff_min_ms"`
-			BackoffMaxMs       int    `json:"backoff_max_ms"`
-			SocksProxy         struct {
-				Enabled  bool   `json:"enabled"`
-				Type     string `json:"type"`
-				Host     string `json:"host"`
-				Port     int    `json:"port"`
-				Username string `json:"username"`
-				Password string `json:"password"`
-			} `json:"socks_proxy"`
-		}{
+		Upstream: UpstreamConfig{
 			Host:         "127.0.0.1",
 			Port:         port,
 			User:         "testuser",
@@ -375,19 +344,10 @@ func TestEndToEndFlow(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		HTTP: struct {
-			Listen string `json:"listen"`
-			Pprof  bool   `json:"pprof"`
-		}{
+		HTTP: HTTPConfig{
 			Listen: "",
 		},
-		VarDiff: struct {
-			Enabled       bool `json:"enabled"`
-			TargetSeconds int  `json:"target_seconds"`
-			MinDiff       int  `json:"min_diff"`
-			MaxDiff       int  `json:"max_diff"`
-			AdjustEveryMs int  `json:"adjust_every_ms"`
-		}{
+		VarDiff: VarDiffConfig{
 			Enabled: false,
 		},
 		Compat: struct {
@@ -458,24 +418,7 @@ func TestMultipleClientsIntegration(t *testing.T) {
 // TestUpstreamReconnection tests upstream reconnection logic
 func TestUpstreamReconnection(t *testing.T) {
 	cfg := &Config{
-		Upstream: struct {
-			Host               string `json:"host"`
-			Port               int    `json:"port"`
-			User               string `json:"user"`
-			Pass               string `json:"pass"`
-			TLS                bool   `json:"tls"`
-			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
-			BackoffMinMs       int    `json:"backoff_min_ms"`
-			BackoffMaxMs       int    `json:"backoff_max_ms"`
-			SocksProxy         struct {
-				Enabled  bool   `json:"enabled"`
-				Type     string `json:"type"`
-				Host     string `json:"host"`
-				Port     int    `json:"port"`
-				Username string `json:"username"`
-				Password string `json:"password"`
-			} `json:"socks_proxy"`
-		}{
+		Upstream: UpstreamConfig{
 			Host:         "127.0.0.1",
 			Port:         9999, // Non-existent port
 			User:         "testuser",
@@ -504,6 +447,61 @@ func TestUpstreamReconnection(t *testing.T) {
 	}
 }
 
+// TestUpstreamHandshakeState tests that the upstream counts as connected only
+// after subscribe and a successful authorize
+func TestUpstreamHandshakeState(t *testing.T) {
+	for _, authorized := range []bool{true, false} {
+		mockServer := &MockStratumServer{
+			subscribeResponse: []interface{}{[]interface{}{}, "deadbeef", float64(4)},
+			authorizeResponse: authorized,
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go mockServer.HandleConnection(conn)
+			}
+		}()
+		addr := listener.Addr().(*net.TCPAddr)
+
+		cfg := &Config{}
+		cfg.Upstream = UpstreamConfig{Host: "127.0.0.1", Port: addr.Port, User: "testuser",
+			BackoffMinMs: 10, BackoffMaxMs: 10, AuthBackoffMs: 20}
+		p := NewProxy(cfg)
+		if got := p.mx.UpstreamState(); got != metrics.UpstreamDisconnected {
+			t.Errorf("initial state = %s", got)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go p.UpstreamLoop(ctx)
+
+		deadline := time.Now().Add(2 * time.Second)
+		done := func() bool {
+			if authorized {
+				return p.mx.UpstreamState() == metrics.UpstreamConnected
+			}
+			return p.mx.UpstreamAuthRejections.Load() >= 2
+		}
+		for !done() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !done() {
+			t.Errorf("authorized=%v: state %s, %d rejections", authorized, p.mx.UpstreamState(), p.mx.UpstreamAuthRejections.Load())
+		}
+		if !authorized && p.mx.UpConnected.Load() {
+			t.Error("a refused authorize must not count as connected")
+		}
+		cancel()
+		p.up.Close()
+		_ = listener.Close()
+	}
+}
+
 // TestShareAccounting tests share acceptance and rejection tracking
 func TestShareAccounting(t *testing.T) {
 	cfg := &Config{}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// recentJobs is how many upstream jobs /status/jobs keeps
+const recentJobs = 100
+
+// jobRecord is the life of one upstream job
+type jobRecord struct {
+	ID        string    `json:"id"`
+	Arrived   time.Time `json:"arrived"`
+	Clean     bool      `json:"clean"`
+	Submitted uint64    `json:"submitted"`
+	Accepted  uint64    `json:"accepted"`
+	Rejected  uint64    `json:"rejected"`
+	// LifetimeMs is how long the job was current before the next one
+	// arrived; 0 while it still is
+	LifetimeMs int64 `json:"lifetime_ms"`
+}
+
+// jobLog keeps the latest upstream jobs, oldest first
+type jobLog struct {
+	mu   sync.Mutex
+	jobs []*jobRecord
+}
+
+// add records a new job and returns how long the previous one was current,
+// 0 for the first
+func (l *jobLog) add(id string, clean bool, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lifetime time.Duration
+	if n := len(l.jobs); n > 0 {
+		prev := l.jobs[n-1]
+		lifetime = now.Sub(prev.Arrived)
+		prev.LifetimeMs = lifetime.Milliseconds()
+	}
+	l.jobs = append(l.jobs, &jobRecord{ID: id, Arrived: now, Clean: clean})
+	if len(l.jobs) > recentJobs {
+		l.jobs = l.jobs[1:]
+	}
+	return lifetime
+}
+
+// find returns the newest job with the ID; pools may reuse IDs
+func (l *jobLog) find(id string) *jobRecord {
+	for i := len(l.jobs) - 1; i >= 0; i-- {
+		if l.jobs[i].ID == id {
+			return l.jobs[i]
+		}
+	}
+	return nil
+}
+
+// submitted counts a share submitted against a job and, for its first
+// share, returns how long after the job arrived it came; 0 otherwise
+func (l *jobLog) submitted(id string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j := l.find(id)
+	if j == nil {
+		return 0
+	}
+	j.Submitted++
+	if j.Submitted > 1 {
+		return 0
+	}
+	return now.Sub(j.Arrived)
+}
+
+// result counts the outcome of a share submitted against a job
+func (l *jobLog) result(id string, accepted bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j := l.find(id)
+	switch {
+	case j == nil:
+	case accepted:
+		j.Accepted++
+	default:
+		j.Rejected++
+	}
+}
+
+// report returns the newest limit jobs (all when limit is 0) and a summary
+// of the whole log
+func (l *jobLog) report(limit int) map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var clean, replaced int
+	var lifetime int64
+	for _, j := range l.jobs {
+		if j.Clean {
+			clean++
+		}
+		if j.LifetimeMs > 0 {
+			replaced++
+			lifetime += j.LifetimeMs
+		}
+	}
+	var avg float64
+	if replaced > 0 {
+		avg = float64(lifetime) / float64(replaced)
+	}
+	jobs := l.jobs
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[len(jobs)-limit:]
+	}
+	out := make([]jobRecord, len(jobs))
+	for i, j := range jobs {
+		out[i] = *j
+	}
+	return map[string]interface{}{
+		"count":           len(l.jobs),
+		"clean":           clean,
+		"avg_lifetime_ms": avg,
+		"jobs":            out,
+	}
+}
+
+// recordJob logs a job arriving from the pool
+func (p *Proxy) recordJob(j stratum.Job) {
+	lifetime := p.jobs.add(j.ID, j.Clean, time.Now())
+	p.mx.ObserveJob(j.Clean, lifetime)
+}
+
+// jobSubmitStage counts every submit against its job before any stage can
+// refuse it, timing the first share of each job
+func (p *Proxy) jobSubmitStage(next routing.ClientHandler) routing.ClientHandler {
+	return func(cl routing.Client, msg stratum.Message) {
+		if msg.Method == stratum.MethodSubmit {
+			if arr, ok := msg.Params.([]any); ok && len(arr) > 1 {
+				if id, ok := arr[1].(string); ok {
+					if d := p.jobs.submitted(id, time.Now()); d > 0 {
+						p.mx.ObserveFirstShare(d, cl.GetWorker(), routing.SessionOf(cl))
+					}
+				}
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// handleJobs serves the latest upstream jobs; ?limit= keeps the newest
+func (p *Proxy) handleJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, p.jobs.report(limit))
+}
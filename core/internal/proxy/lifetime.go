@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// methodReconnect asks the miner to reconnect; without params it returns to
+// the same address
+const methodReconnect = "client.reconnect"
+
+// Lifetime actions
+const (
+	LifetimeReconnect = "reconnect" // send client.reconnect, close after the grace time
+	LifetimeClose     = "close"     // close the connection
+)
+
+// LifetimeConfig recycles client sessions older than a maximum lifetime, for
+// firmware that degrades on very long-lived stratum sessions
+type LifetimeConfig struct {
+	MaxMinutes int `json:"max_minutes"` // 0 is off
+	// JitterPct spreads each client's limit by up to this percent either
+	// way, so clients connected together do not reconnect together;
+	// default 10
+	JitterPct int    `json:"jitter_pct"`
+	Action    string `json:"action"` // "reconnect" (default) or "close"
+	// GraceSeconds is how long a client asked to reconnect has before it is
+	// closed; default 30
+	GraceSeconds int `json:"grace_seconds"`
+}
+
+// Validate checks the limits and the action
+func (c LifetimeConfig) Validate() error {
+	if c.MaxMinutes < 0 || c.GraceSeconds < 0 {
+		return errors.New("max_minutes and grace_seconds must not be negative")
+	}
+	if c.JitterPct < 0 || c.JitterPct > 50 {
+		return errors.New("jitter_pct must be between 0 and 50")
+	}
+	if c.Action != "" && c.Action != LifetimeReconnect && c.Action != LifetimeClose {
+		return errors.New(`action must be "reconnect" or "close"`)
+	}
+	return nil
+}
+
+func (c LifetimeConfig) jitterPct() int {
+	if c.JitterPct == 0 {
+		return 10
+	}
+	return c.JitterPct
+}
+
+func (c LifetimeConfig) action() string {
+	if c.Action == "" {
+		return LifetimeReconnect
+	}
+	return c.Action
+}
+
+func (c LifetimeConfig) grace() time.Duration {
+	if c.GraceSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.GraceSeconds) * time.Second
+}
+
+// limit returns the lifetime of a client whose jitter is j, in [-1, 1)
+func (c LifetimeConfig) limit(j float64) time.Duration {
+	max := time.Duration(c.MaxMinutes) * time.Minute
+	return max + time.Duration(float64(max)*j*float64(c.jitterPct())/100)
+}
+
+// LifetimeLoop recycles clients past the maximum lifetime; it follows reloads
+func (p *Proxy) LifetimeLoop(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if p.config().Lifetime.MaxMinutes > 0 {
+				p.recycle(now)
+			}
+		}
+	}
+}
+
+// recycle asks or forces the clients of the proxy and its profiles that
+// outlived their limit to reconnect, and closes those that ignored the
+// request for the grace time. It returns how many it acted on.
+func (p *Proxy) recycle(now time.Time) int {
+	cfg := p.config().Lifetime
+	type due struct {
+		px *Proxy
+		cl *Client
+	}
+	var ask, drop []due
+	for _, px := range append([]*Proxy{p}, p.profileList()...) {
+		px.clMu.RLock()
+		for cl := range px.clients {
+			if cl.shed.Load() {
+				continue
+			}
+			if asked := cl.reconnectAsked.Load(); asked != 0 {
+				if now.Sub(time.UnixMilli(asked)) >= cfg.grace() {
+					drop = append(drop, due{px, cl})
+				}
+				continue
+			}
+			if now.Sub(cl.connected) >= cfg.limit(cl.lifeJitter) {
+				if cfg.action() == LifetimeClose {
+					drop = append(drop, due{px, cl})
+				} else {
+					ask = append(ask, due{px, cl})
+				}
+			}
+		}
+		px.clMu.RUnlock()
+	}
+
+	for _, d := range ask {
+		log.Printf("client %s worker=%s session=%s reached its maximum lifetime; asking it to reconnect", d.cl.addr, d.cl.GetWorker(), d.cl.session)
+		d.cl.reconnectAsked.Store(now.UnixMilli())
+		p.mx.IncrementClientsRecycled(LifetimeReconnect)
+		_ = d.cl.WriteJSON(stratum.Message{Method: methodReconnect, Params: []interface{}{}})
+	}
+	for _, d := range drop {
+		log.Printf("closing client %s worker=%s session=%s: maximum lifetime reached", d.cl.addr, d.cl.GetWorker(), d.cl.session)
+		if d.cl.reconnectAsked.Load() == 0 {
+			p.mx.IncrementClientsRecycled(LifetimeClose)
+		}
+		d.px.kick(d.cl, reasonMaxLifetime)
+		_ = d.cl.Close()
+	}
+	return len(ask) + len(drop)
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+
+	"github.com/carlosrabelo/karoo/core/internal/compat"
+	"github.com/carlosrabelo/karoo/core/internal/tunnel"
+)
+
+// ListenerConfig is an additional client port with its own TLS settings,
+// upstream profile, starting difficulty, client limit and compat profile
+type ListenerConfig struct {
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	TLS    struct {
+		Enabled bool   `json:"enabled"`
+		Cert    string `json:"cert_file"`
+		Key     string `json:"key_file"`
+	} `json:"tls"`
+	Profile         string  `json:"profile"`          // empty uses the main upstream
+	StartDifficulty float64 `json:"start_difficulty"` // 0 uses vardiff.start_diff
+	MaxClients      int     `json:"max_clients"`      // 0 leaves only proxy.max_clients
+	Compat          string  `json:"compat"`           // empty uses client_compat.default
+	// Tunnel accepts compressed connections from other karoo instances
+	// instead of miners
+	Tunnel tunnel.Config `json:"tunnel"`
+	// Chain treats every client as a downstream karoo, for instances whose
+	// user agent is changed or hidden
+	Chain bool `json:"chain"`
+}
+
+// listener tracks the clients admitted through one configured listener
+type listener struct {
+	cfg       ListenerConfig
+	target    *Proxy
+	active    atomic.Int64
+	listening atomic.Bool
+	cert      *certFile // nil without cert_file
+}
+
+// newListeners binds each configured listener to the proxy or profile that
+// serves it
+func (p *Proxy) newListeners() {
+	for _, lc := range p.config().Listeners {
+		target := p
+		if sub, ok := p.profiles[lc.Profile]; ok {
+			target = sub
+		}
+		p.listeners = append(p.listeners, &listener{cfg: lc, target: target})
+	}
+}
+
+// full reports whether the listener reached its own client limit
+func (l *listener) full() bool {
+	return l.cfg.MaxClients > 0 && l.active.Load() >= int64(l.cfg.MaxClients)
+}
+
+// startDifficulty returns the difficulty new clients of the listener start at
+func (p *Proxy) startDifficulty(l *listener) float64 {
+	cfg := p.config()
+	if l != nil && l.cfg.StartDifficulty > 0 {
+		return l.cfg.StartDifficulty
+	}
+	if cfg.VarDiff.StartDiff > 0 {
+		return cfg.VarDiff.StartDiff
+	}
+	return float64(cfg.VarDiff.MinDiff)
+}
+
+// compatProfile returns the firmware quirk profile for clients of l
+func (p *Proxy) compatProfile(l *listener) *compat.Profile {
+	cfg := p.config()
+	name := cfg.ClientCompat.Default
+	if l != nil && l.cfg.Compat != "" {
+		name = l.cfg.Compat
+	}
+	prof, _ := cfg.ClientCompat.Lookup(name)
+	return prof
+}
+
+// RunListeners opens every additional listener and accepts clients on it
+// until ctx is done. Listeners are bound before returning so port
+// conflicts surface at startup.
+func (p *Proxy) RunListeners(ctx context.Context) error {
+	var acme func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if p.acme != nil {
+		acme = p.acmeCertificate
+	}
+	lns := make([]net.Listener, 0, len(p.listeners))
+	for _, l := range p.listeners {
+		ln, err := l.listen(acme)
+		if err != nil {
+			for _, open := range lns {
+				_ = open.Close()
+			}
+			return fmt.Errorf("listener %s: %w", l.cfg.Name, err)
+		}
+		lns = append(lns, ln)
+	}
+	for i, l := range p.listeners {
+		go p.serveListener(ctx, l, lns[i])
+	}
+	return nil
+}
+
+// listen opens the listener's socket, with TLS when configured. acme serves
+// certificates when the listener has no cert_file.
+func (l *listener) listen(acme func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (net.Listener, error) {
+	if !l.cfg.TLS.Enabled {
+		log.Printf("proxy: listener %s on %s", l.cfg.Name, l.cfg.Listen)
+		return net.Listen("tcp", l.cfg.Listen)
+	}
+	if l.cfg.TLS.Cert == "" && acme != nil {
+		log.Printf("proxy: listener %s on %s (TLS via ACME)", l.cfg.Name, l.cfg.Listen)
+		return tls.Listen("tcp", l.cfg.Listen, &tls.Config{GetCertificate: acme})
+	}
+	cf, err := loadCertFile(l.cfg.TLS.Cert, l.cfg.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	l.cert = cf
+	log.Printf("proxy: listener %s on %s (TLS enabled)", l.cfg.Name, l.cfg.Listen)
+	return tls.Listen("tcp", l.cfg.Listen, &tls.Config{GetCertificate: cf.get})
+}
+
+// serveListener accepts clients on ln and admits them to the listener's target
+func (p *Proxy) serveListener(ctx context.Context, l *listener, ln net.Listener) {
+	l.listening.Store(true)
+	defer l.listening.Store(false)
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("listener %s: accept err: %v", l.cfg.Name, err)
+			continue
+		}
+		if !p.rl.AllowConnection(conn.RemoteAddr()) {
+			log.Printf("rejecting client %s: rate limit exceeded", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		if l.cfg.Tunnel.Enabled {
+			go p.admitTunnel(ctx, l, conn)
+			continue
+		}
+		l.target.admit(ctx, conn, l)
+	}
+}
+
+// admitTunnel completes a tunnel handshake before admitting the peer karoo
+// as a client
+func (p *Proxy) admitTunnel(ctx context.Context, l *listener, conn net.Conn) {
+	tc, err := tunnel.Server(conn, l.cfg.Tunnel)
+	if err != nil {
+		log.Printf("rejecting client %s: %v", conn.RemoteAddr(), err)
+		p.rl.ReleaseConnection(conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	l.target.admit(ctx, tc, l)
+}
+
+// listenerStats summarizes each additional listener for /status
+func (p *Proxy) listenerStats() []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(p.listeners))
+	for _, l := range p.listeners {
+		out = append(out, map[string]interface{}{
+			"name":        l.cfg.Name,
+			"listen":      l.cfg.Listen,
+			"tls":         l.cfg.TLS.Enabled,
+			"profile":     l.cfg.Profile,
+			"listening":   l.listening.Load(),
+			"clients":     l.active.Load(),
+			"max_clients": l.cfg.MaxClients,
+			"compat":      l.cfg.Compat,
+		})
+	}
+	return out
+}
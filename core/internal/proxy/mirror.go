@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// MirrorConfig submits every share to a second upstream as well, so another
+// endpoint of the same pool can be compared with the primary without moving
+// hashrate. Shares keep the primary's job and extranonce, so the mirror must
+// hand out the same ones and is disabled once it hands out another
+// extranonce1; only the primary's answer reaches the miner.
+type MirrorConfig struct {
+	Enabled  bool           `json:"enabled"`
+	Upstream UpstreamConfig `json:"upstream"`
+	// TimeoutMs expires mirror submits left unanswered; default 30000
+	TimeoutMs int `json:"timeout_ms"`
+}
+
+func (c MirrorConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// mirror is the secondary connection and its accounting, kept apart from
+// the primary's pending requests
+type mirror struct {
+	cfg MirrorConfig
+	up  *connection.Upstream
+
+	mu         sync.Mutex
+	disabled   string // why the mirror was given up, empty while it runs
+	submitted  uint64
+	skipped    uint64 // not sent while the mirror was down
+	mismatched uint64 // not sent as the mirror's extranonce1 differs
+	accepted   uint64
+	rejected   uint64
+	expired    uint64 // unanswered or lost on disconnect
+	rejects    map[string]uint64
+	latency    time.Duration // sum over answered submits
+	maxLatency time.Duration
+	primaryOK  uint64
+	primaryBad uint64
+}
+
+// newMirror creates the mirror connection of cfg, nil when disabled
+func newMirror(cfg *Config) *mirror {
+	if !cfg.Mirror.Enabled {
+		return nil
+	}
+	up, err := connection.NewUpstream(connectionConfig(cfg, cfg.Mirror.Upstream))
+	if err != nil {
+		log.Printf("mirror: %v", err)
+		return nil
+	}
+	return &mirror{cfg: cfg.Mirror, up: up, rejects: make(map[string]uint64)}
+}
+
+// checkExtranonce disables the mirror when its extranonce1 is known to
+// differ from the primary's (ex1): no share built on the primary's work can
+// be accepted there, and pools handing out one extranonce1 per connection
+// never match. It reports whether the mirror is still usable.
+func (m *mirror) checkExtranonce(ex1 string) bool {
+	own, _ := m.up.GetExtranonce()
+	m.mu.Lock()
+	if m.disabled != "" {
+		m.mu.Unlock()
+		return false
+	}
+	if ex1 == "" || own == "" || own == ex1 {
+		m.mu.Unlock()
+		return true
+	}
+	m.disabled = "extranonce1 " + own + " differs from the primary's " + ex1
+	m.mu.Unlock()
+	log.Printf("mirror: %s hands out %s; shares cannot be mirrored, disabling the mirror", m.cfg.Upstream.addr(), m.disabled)
+	m.up.Close()
+	return false
+}
+
+// submit sends a copy of a share as the mirror's user. The share was built
+// on the primary's extranonce1 (ex1), so it is not sent to a mirror that
+// handed out another one.
+func (m *mirror) submit(params []any, ex1 string) {
+	if !m.checkExtranonce(ex1) {
+		m.mu.Lock()
+		m.mismatched++
+		m.mu.Unlock()
+		return
+	}
+	params = append([]any(nil), params...)
+	params[0] = m.cfg.Upstream.User
+	if !m.up.IsConnected() {
+		m.mu.Lock()
+		m.skipped++
+		m.mu.Unlock()
+		return
+	}
+	req := connection.PendingReq{Method: stratum.MethodSubmit, Params: params, Sent: time.Now()}
+	if _, err := m.up.Request(stratum.Message{Method: stratum.MethodSubmit, Params: params}, req); err != nil {
+		m.mu.Lock()
+		m.skipped++
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Lock()
+	m.submitted++
+	m.mu.Unlock()
+}
+
+// response accounts the mirror's answer to one of its submits
+func (m *mirror) response(req connection.PendingReq, msg stratum.Message) {
+	lat := time.Since(req.Sent)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency += lat
+	if lat > m.maxLatency {
+		m.maxLatency = lat
+	}
+	if ok, _ := msg.Result.(bool); ok && msg.Error == nil {
+		m.accepted++
+		return
+	}
+	m.rejected++
+	code, reason := stratum.ParseError(msg.Error)
+	m.rejects[stratum.ClassifyReject(code, reason)]++
+}
+
+// expire counts mirror submits that will never be answered
+func (m *mirror) expire(n int) {
+	if n == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.expired += uint64(n)
+	m.mu.Unlock()
+}
+
+// primary counts the primary's answer for comparison
+func (m *mirror) primary(accepted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if accepted {
+		m.primaryOK++
+	} else {
+		m.primaryBad++
+	}
+}
+
+// mirrorStage copies each submit to the mirror as it goes to the primary
+func (p *Proxy) mirrorStage(next routing.ClientHandler) routing.ClientHandler {
+	return func(cl routing.Client, msg stratum.Message) {
+		if msg.Method == stratum.MethodSubmit && p.mir != nil {
+			if arr, ok := msg.Params.([]any); ok && len(arr) > 0 {
+				ex1, _ := p.up.GetExtranonce()
+				p.mir.submit(arr, ex1)
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// MirrorLoop keeps the mirror connected and reads its answers
+func (p *Proxy) MirrorLoop(ctx context.Context) {
+	m := p.mir
+	if m == nil {
+		return
+	}
+	go m.expireLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		m.up.Close()
+	}()
+	u := m.cfg.Upstream
+	for ctx.Err() == nil && m.running() {
+		m.up.UpdateTarget(u.Host, u.Port, u.User, u.Pass, u.TLS, u.InsecureSkipVerify)
+		m.up.SetTLSOptions(u.tlsOptions())
+		err := m.up.SetSocksProxy(u.socksProxy())
+		if err == nil {
+			err = m.up.Dial(ctx)
+		}
+		if err == nil {
+			err = m.up.SubscribeAuthorize()
+		}
+		if err != nil {
+			d := connection.Backoff(time.Duration(u.BackoffMinMs)*time.Millisecond, time.Duration(u.BackoffMaxMs)*time.Millisecond)
+			log.Printf("mirror: %s: %v; retry in %s", u.addr(), err, d)
+			m.up.Close()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+			continue
+		}
+		log.Printf("mirror: connected to %s", u.addr())
+
+		sc := bufio.NewScanner(m.up.GetReader())
+		sc.Buffer(make([]byte, 0, p.config().Proxy.ReadBuf), 1024*1024)
+		for sc.Scan() {
+			var msg stratum.Message
+			if json.Unmarshal(sc.Bytes(), &msg) != nil || msg.Method != "" {
+				continue
+			}
+			id, ok := msg.ID.Int64()
+			if !ok {
+				continue
+			}
+			if m.up.HandshakeMethod(id) == stratum.MethodSubscribe {
+				if info := stratum.ParseExtranonceResult(msg.Result); info.Valid {
+					m.up.SetExtranonce(info.Extranonce1, info.Extranonce2Size)
+					ex1, _ := p.up.GetExtranonce()
+					if !m.checkExtranonce(ex1) {
+						break
+					}
+				}
+			} else if req, ok := m.up.RemovePendingRequest(id); ok {
+				m.response(req, msg)
+			}
+		}
+		m.up.Close()
+		m.expire(len(m.up.DropPending()))
+		if ctx.Err() == nil && m.running() {
+			log.Printf("mirror: disconnected from %s", u.addr())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(connection.Backoff(time.Duration(u.BackoffMinMs)*time.Millisecond, time.Duration(u.BackoffMaxMs)*time.Millisecond)):
+			}
+		}
+	}
+}
+
+// running reports whether the mirror has not been disabled
+func (m *mirror) running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.disabled == ""
+}
+
+// expireLoop drops mirror submits older than the timeout
+func (m *mirror) expireLoop(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			m.expire(len(m.up.ExpirePending(now.Add(-m.cfg.timeout()))))
+		}
+	}
+}
+
+// GetStats reports the mirror next to the primary for /status
+func (m *mirror) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var avg float64
+	if answered := m.accepted + m.rejected; answered > 0 {
+		avg = float64(m.latency.Microseconds()) / float64(answered) / 1000
+	}
+	rejects := make(map[string]uint64, len(m.rejects))
+	for k, v := range m.rejects {
+		rejects[k] = v
+	}
+	return map[string]interface{}{
+		"upstream":       m.cfg.Upstream.addr(),
+		"connected":      m.up.IsConnected(),
+		"disabled":       m.disabled,
+		"submitted":      m.submitted,
+		"skipped":        m.skipped,
+		"mismatched":     m.mismatched,
+		"accepted":       m.accepted,
+		"rejected":       m.rejected,
+		"rejects":        rejects,
+		"expired":        m.expired,
+		"pending":        m.up.PendingCount(),
+		"avg_latency_ms": avg,
+		"max_latency_ms": float64(m.maxLatency.Microseconds()) / 1000,
+		"primary":        map[string]uint64{"accepted": m.primaryOK, "rejected": m.primaryBad},
+	}
+}
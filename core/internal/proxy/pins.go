@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// WorkerPinConfig pins each worker name to the network it first authorized
+// from, so another rig cannot silently take over its name and stats
+type WorkerPinConfig struct {
+	Enabled bool `json:"enabled"`
+	// TTLSeconds is how long a pin outlives the worker's last authorization
+	// from its network; default 86400
+	TTLSeconds int `json:"ttl_seconds"`
+	IPv4Prefix int `json:"ipv4_prefix"` // default 32 (the exact address)
+	IPv6Prefix int `json:"ipv6_prefix"` // default 64
+}
+
+// ttl returns how long an unused pin is kept
+func (c WorkerPinConfig) ttl() time.Duration {
+	if c.TTLSeconds <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// network returns the block a worker authorizing from ip is pinned to
+func (c WorkerPinConfig) network(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		bits := c.IPv4Prefix
+		if bits <= 0 {
+			bits = 32
+		}
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)}).String()
+	}
+	bits := c.IPv6Prefix
+	if bits <= 0 {
+		bits = 64
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(bits, 128)), Mask: net.CIDRMask(bits, 128)}).String()
+}
+
+// WorkerPin is a worker name bound to a network
+type WorkerPin struct {
+	Worker  string    `json:"worker"`
+	Network string    `json:"network"`
+	Pinned  time.Time `json:"pinned"`
+	Expires time.Time `json:"expires"`
+}
+
+// pinStore holds the worker pins; profiles share the main proxy's store
+type pinStore struct {
+	mu       sync.Mutex
+	pins     map[string]WorkerPin
+	rejected uint64
+}
+
+func newPinStore() *pinStore {
+	return &pinStore{pins: make(map[string]WorkerPin)}
+}
+
+// check pins worker to the network of ip, or refreshes its pin, and reports
+// whether the authorization is allowed. A refusal returns the existing pin.
+func (s *pinStore) check(cfg WorkerPinConfig, worker string, ip net.IP, now time.Time) (WorkerPin, bool) {
+	network := cfg.network(ip)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin, ok := s.pins[worker]
+	if ok && now.Before(pin.Expires) && pin.Network != network {
+		s.rejected++
+		return pin, false
+	}
+	if !ok || !now.Before(pin.Expires) || pin.Network != network {
+		pin = WorkerPin{Worker: worker, Network: network, Pinned: now}
+	}
+	pin.Expires = now.Add(cfg.ttl())
+	s.pins[worker] = pin
+	return pin, true
+}
+
+// remove lifts the pin of a worker and reports whether one existed
+func (s *pinStore) remove(worker string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.pins[worker]
+	delete(s.pins, worker)
+	return ok
+}
+
+// list drops expired pins and returns the rest sorted by worker
+func (s *pinStore) list(now time.Time) []WorkerPin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]WorkerPin, 0, len(s.pins))
+	for w, pin := range s.pins {
+		if !now.Before(pin.Expires) {
+			delete(s.pins, w)
+			continue
+		}
+		out = append(out, pin)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Worker < out[j].Worker })
+	return out
+}
+
+// stats returns the worker pin view in /status
+func (s *pinStore) stats(now time.Time) map[string]interface{} {
+	pinned := len(s.list(now))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{"pinned": pinned, "rejected": s.rejected}
+}
+
+// rejectPinnedWorker answers an authorize for a worker pinned to another
+// network and reports whether the client must be dropped
+func (p *Proxy) rejectPinnedWorker(cl *Client, msg stratum.Message) bool {
+	cfg := p.config().WorkerPin
+	if !cfg.Enabled {
+		return false
+	}
+	params, ok := msg.Params.([]interface{})
+	if !ok || len(params) == 0 {
+		return false
+	}
+	worker, _ := params[0].(string)
+	ip := net.ParseIP(hostOf(cl.addr))
+	if worker == "" || ip == nil {
+		return false
+	}
+	pin, ok := p.pins.check(cfg, worker, ip, time.Now())
+	if ok {
+		return false
+	}
+	log.Printf("rejecting client %s session=%s: worker %s is pinned to %s", cl.addr, cl.session, worker, pin.Network)
+	p.mx.IncrementWorkerPinRejections()
+	p.refuseAuthorize(cl, worker, reasonPinned)
+	_ = cl.WriteJSON(stratum.NewErrorResponse(msg.ID, stratum.CodeUnauthorized, "Worker pinned to another address", nil))
+	return true
+}
+
+// registerPinHandlers adds the worker pin admin endpoint. GET lists the
+// active pins and DELETE ?worker= lifts one, letting a rig that moved
+// authorize from its new network.
+func (p *Proxy) registerPinHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/worker-pins", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			worker := r.URL.Query().Get("worker")
+			if worker == "" {
+				http.Error(w, "worker is required", http.StatusBadRequest)
+				return
+			}
+			if !p.pins.remove(worker) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("admin: unpinned worker %s", worker)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.pins.list(time.Now()))
+	}))
+}
@@ -6,28 +6,59 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/carlosrabelo/karoo/core/internal/admission"
+	"github.com/carlosrabelo/karoo/core/internal/aggregate"
+	"github.com/carlosrabelo/karoo/core/internal/allocaudit"
+	"github.com/carlosrabelo/karoo/core/internal/availability"
+	"github.com/carlosrabelo/karoo/core/internal/canary"
+	"github.com/carlosrabelo/karoo/core/internal/capture"
+	"github.com/carlosrabelo/karoo/core/internal/compat"
+	"github.com/carlosrabelo/karoo/core/internal/configfile"
 	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/history"
+	"github.com/carlosrabelo/karoo/core/internal/idle"
+	"github.com/carlosrabelo/karoo/core/internal/journal"
+	"github.com/carlosrabelo/karoo/core/internal/logfile"
 	"github.com/carlosrabelo/karoo/core/internal/metrics"
 	"github.com/carlosrabelo/karoo/core/internal/nonce"
 	"github.com/carlosrabelo/karoo/core/internal/proxysocks"
 	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
 	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/selection"
+	"github.com/carlosrabelo/karoo/core/internal/sessions"
+	"github.com/carlosrabelo/karoo/core/internal/solo"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/throttle"
+	"github.com/carlosrabelo/karoo/core/internal/tunnel"
 	"github.com/carlosrabelo/karoo/core/internal/vardiff"
+	"github.com/carlosrabelo/karoo/core/internal/workername"
+	"github.com/carlosrabelo/karoo/core/internal/workers"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Client represents a mining client connection
 type Client struct {
+	// ctx ends when the connection closes or the proxy shuts down; set by
+	// bind, nil for clients that were never admitted
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	c                net.Conn
 	br               *bufio.Reader
 	bw               *bufio.Writer
@@ -35,6 +66,12 @@ type Client struct {
 	worker           string
 	upUser           string
 	handshakeDone    atomic.Bool
+	pending          atomic.Bool // admitted, mining.subscribe not yet seen
+	handshakeSlot    atomic.Bool // holds an admission handshake slot
+	subscribedOK     atomic.Bool // mining.subscribe seen
+	preAuth          preAuthHold // difficulty and job held until authorize
+	shed             atomic.Bool // being disconnected by load shedding
+	chained          atomic.Bool // another karoo proxy, given a prefix block
 	last             atomic.Int64
 	diff             atomic.Int64
 	ok               atomic.Uint64
@@ -43,6 +80,43 @@ type Client struct {
 	extraNonceTrim   int
 	lastAccept       atomic.Int64
 	clientMetrics    *metrics.ClientMetrics
+
+	// difficulty requested with d=N in the password (float64 bits)
+	reqDiff atomic.Uint64
+
+	rejectMu sync.Mutex
+	rejects  map[string]uint64
+
+	// additional listener the client connected through (nil for proxy.listen)
+	ln *listener
+
+	// outbound queue (nil writes synchronously under wmu)
+	q   *clientQueue
+	wmu sync.Mutex
+
+	// firmware quirk handling (nil for standard clients)
+	cs *compat.Session
+
+	// traffic capture (nil until admitted)
+	tap *capture.Recorder
+
+	// audit trail session ID and why the proxy closed the connection
+	session     string
+	closeReason atomic.Pointer[string]
+
+	// lines from the client that were not JSON-RPC
+	invalid atomic.Uint64
+
+	connected time.Time
+
+	// spread of this client's maximum lifetime, in [-1, 1), and when it was
+	// asked to reconnect (unix ms, 0 before)
+	lifeJitter     float64
+	reconnectAsked atomic.Int64
+
+	// delay before the first difficulty and job after authorize, for
+	// clients let in from the admission backlog (ns, 0 for none)
+	stagger atomic.Int64
 }
 
 // UpstreamConfig holds upstream connection details
@@ -55,14 +129,108 @@ type UpstreamConfig struct {
 	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
 	BackoffMinMs       int    `json:"backoff_min_ms"`
 	BackoffMaxMs       int    `json:"backoff_max_ms"`
-	SocksProxy         struct {
+	Algorithm          string `json:"algorithm"` // "sha256d" (default) or "scrypt"
+	// AuthBackoffMs is the wait after the pool refuses mining.authorize,
+	// which retrying at once will not fix; default 30000
+	AuthBackoffMs int `json:"auth_backoff_ms"`
+	// Subscribe sets the agent, session resume and protocol version sent
+	// to this pool in mining.subscribe
+	Subscribe connection.SubscribeConfig `json:"subscribe"`
+	// private CA, certificate pins and client certificate for TLS pools
+	CAFile     string   `json:"ca_file"`
+	CertFile   string   `json:"cert_file"`
+	KeyFile    string   `json:"key_file"`
+	PinSHA256  []string `json:"pin_sha256"`
+	SocksProxy struct {
 		Enabled  bool   `json:"enabled"`
-		Type     string `json:"type"` // "socks4" or "socks5"
+		Type     string `json:"type"` // "socks5", "socks4", "socks4a" or "http"
 		Host     string `json:"host"`
 		Port     int    `json:"port"`
-		Username string `json:"username"` // optional for SOCKS5
-		Password string `json:"password"` // optional for SOCKS5
+		Username string `json:"username"` // optional; the user ID for SOCKS4
+		Password string `json:"password"` // optional; not for SOCKS4
 	} `json:"socks_proxy"`
+	// DifficultyScale is how many times the pool's difficulties exceed
+	// standard ones (e.g. 65536); they are divided by it for clients and
+	// local validation. 0 or 1 uses them as sent.
+	DifficultyScale float64 `json:"difficulty_scale"`
+	// Tunnel compresses the stream to another karoo's tunnel listener
+	Tunnel tunnel.Config `json:"tunnel"`
+}
+
+// tlsOptions returns the TLS trust settings of the upstream
+func (u UpstreamConfig) tlsOptions() connection.TLSOptions {
+	return connection.TLSOptions{CAFile: u.CAFile, CertFile: u.CertFile, KeyFile: u.KeyFile, Pins: u.PinSHA256}
+}
+
+// ValidateTLS loads the TLS files of the upstream and parses its pins
+func (u UpstreamConfig) ValidateTLS() error {
+	return u.tlsOptions().Validate()
+}
+
+// socksProxy returns the egress proxy of the upstream
+func (u UpstreamConfig) socksProxy() proxysocks.Config {
+	return proxysocks.Config(u.SocksProxy)
+}
+
+// ValidateProxy checks the egress proxy of the upstream and that .onion
+// hosts are resolved by it
+func (u UpstreamConfig) ValidateProxy() error {
+	sp := u.socksProxy()
+	if _, err := proxysocks.NewProxyDialer(&sp); err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.TrimSuffix(u.Host, "."), ".onion") && !sp.ResolvesRemotely() {
+		return fmt.Errorf("%s needs a socks5, socks4a or http socks_proxy", u.Host)
+	}
+	return nil
+}
+
+// authBackoff returns the wait after an authorize rejection
+func (u UpstreamConfig) authBackoff() time.Duration {
+	if u.AuthBackoffMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(u.AuthBackoffMs) * time.Millisecond
+}
+
+// addr identifies the upstream as host:port in latency metrics
+func (u UpstreamConfig) addr() string {
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// addrs maps upstream configs to their host:port
+func addrs(configs []UpstreamConfig) []string {
+	out := make([]string, len(configs))
+	for i, uc := range configs {
+		out[i] = uc.addr()
+	}
+	return out
+}
+
+// VarDiffConfig holds variable difficulty settings
+type VarDiffConfig struct {
+	Enabled           bool   `json:"enabled"`
+	TargetSeconds     int    `json:"target_seconds"`
+	MinDiff           int    `json:"min_diff"`
+	MaxDiff           int    `json:"max_diff"`
+	AdjustEveryMs     int    `json:"adjust_every_ms"`
+	RestoreDifficulty bool   `json:"restore_difficulty"`
+	RestoreTTLSeconds int    `json:"restore_ttl_seconds"`
+	StateFile         string `json:"state_file"`
+	// PasswordDifficulty honors d=N in the authorize password as a floor,
+	// ceiling or fixed difficulty; empty or "off" ignores it
+	PasswordDifficulty string `json:"password_difficulty"`
+
+	// StartDiff is the difficulty new clients start at unless their listener
+	// sets one; 0 uses min_diff
+	StartDiff           float64 `json:"start_diff"`
+	FastRetargetShares  int     `json:"fast_retarget_shares"`
+	FastRetargetSeconds int     `json:"fast_retarget_seconds"`
+
+	Steps         string    `json:"steps"` // "pow2", "list" or empty
+	StepList      []float64 `json:"step_list"`
+	HysteresisPct float64   `json:"hysteresis_pct"`
+	LogDecisions  bool      `json:"log_decisions"`
 }
 
 // Config holds proxy configuration
@@ -73,40 +241,123 @@ type Config struct {
 		MaxClients   int    `json:"max_clients"`
 		ReadBuf      int    `json:"read_buf"`
 		WriteBuf     int    `json:"write_buf"`
+		// IdleGraceMs keeps the upstream connected this long after the last
+		// client left; default 30000
+		IdleGraceMs int `json:"idle_grace_ms"`
+		// MaxLineBytes bounds one line from a client; a longer one is
+		// answered with an error and the client dropped. Default 16384
+		MaxLineBytes int `json:"max_line_bytes"`
+		// MaxInvalidLines drops a client after this many lines that are not
+		// JSON-RPC; 0 never does
+		MaxInvalidLines int `json:"max_invalid_lines"`
+		// InvalidBanSeconds also bans the address of a client dropped for
+		// invalid lines for this long; 0 only disconnects it
+		InvalidBanSeconds int `json:"invalid_ban_seconds"`
+		// KeepUpstream connects the upstream at start and keeps it up without
+		// clients instead of following client activity
+		KeepUpstream bool `json:"keep_upstream"`
 		TLS          struct {
 			Enabled bool   `json:"enabled"`
 			Cert    string `json:"cert_file"`
 			Key     string `json:"key_file"`
 		} `json:"tls"`
 	} `json:"proxy"`
-	Upstream UpstreamConfig   `json:"upstream"`
-	Backups  []UpstreamConfig `json:"backups"`
-	HTTP     struct {
-		Listen string `json:"listen"`
-		Pprof  bool   `json:"pprof"`
-	} `json:"http"`
-	VarDiff struct {
-		Enabled       bool `json:"enabled"`
-		TargetSeconds int  `json:"target_seconds"`
-		MinDiff       int  `json:"min_diff"`
-		MaxDiff       int  `json:"max_diff"`
-		AdjustEveryMs int  `json:"adjust_every_ms"`
-	} `json:"vardiff"`
+	Upstream  UpstreamConfig   `json:"upstream"`
+	Backups   []UpstreamConfig `json:"backups"`
+	HTTP      HTTPConfig       `json:"http"`
+	VarDiff   VarDiffConfig    `json:"vardiff"`
 	RateLimit struct {
 		Enabled                 bool `json:"enabled"`
 		MaxConnectionsPerIP     int  `json:"max_connections_per_ip"`
 		MaxConnectionsPerMinute int  `json:"max_connections_per_minute"`
 		BanDurationSeconds      int  `json:"ban_duration_seconds"`
 		CleanupIntervalSeconds  int  `json:"cleanup_interval_seconds"`
+		IPv4Prefix              int  `json:"ipv4_prefix"`
+		IPv6Prefix              int  `json:"ipv6_prefix"`
+
+		Bans []ratelimit.BanConfig `json:"bans"`
 	} `json:"ratelimit"`
 	Compat struct {
 		StrictBroadcast bool `json:"strict_broadcast"`
 	} `json:"compat"`
+	Extranonce struct {
+		PrefixBytes      int `json:"prefix_bytes"`
+		ChainPrefixBytes int `json:"chain_prefix_bytes"`
+	} `json:"extranonce"`
+	Solo         SoloConfig            `json:"solo"`
+	Submit       routing.SubmitConfig  `json:"submit"`
+	Pending      routing.PendingConfig `json:"pending"`
+	Availability struct {
+		Enabled          bool   `json:"enabled"`
+		StateFile        string `json:"state_file"`
+		SampleIntervalMs int    `json:"sample_interval_ms"`
+		RetentionDays    int    `json:"retention_days"`
+	} `json:"availability"`
+	Journal struct {
+		Enabled   bool   `json:"enabled"`
+		Path      string `json:"path"`
+		Format    string `json:"format"`
+		MaxSizeMB int    `json:"max_size_mb"`
+		MaxFiles  int    `json:"max_files"`
+	} `json:"journal"`
+	Canary struct {
+		Enabled           bool   `json:"enabled"`
+		Worker            string `json:"worker"`
+		Password          string `json:"password"`
+		HashesPerSec      int    `json:"hashes_per_sec"`
+		SubmitIntervalMs  int    `json:"submit_interval_ms"`
+		FailThreshold     int    `json:"fail_threshold"`
+		ResponseTimeoutMs int    `json:"response_timeout_ms"`
+	} `json:"canary"`
+	Identity IdentityConfig `json:"identity"`
+	Admin    struct {
+		Token string `json:"token"`
+	} `json:"admin"`
+	Profiles    map[string]ProfileConfig `json:"profiles"`
+	SNIRoutes   []SNIRoute               `json:"sni_routes"`
+	Diagnostics struct {
+		AllocAudit bool           `json:"alloc_audit"`
+		Capture    capture.Config `json:"capture"`
+	} `json:"diagnostics"`
+	Public         PublicConfig               `json:"public"`
+	Aggregate      aggregate.Config           `json:"aggregate"`
+	Workers        workers.Config             `json:"workers"`
+	Duplicates     DuplicateConfig            `json:"duplicates"`
+	Listeners      []ListenerConfig           `json:"listeners"`
+	Idle           idle.Config                `json:"idle"`
+	Events         events.Config              `json:"events"`
+	Selection      selection.Config           `json:"selection"`
+	ClientQueue    ClientQueueConfig          `json:"client_queue"`
+	ClientCompat   compat.Config              `json:"client_compat"`
+	UpstreamWriter connection.WriterConfig    `json:"upstream_writer"`
+	UpstreamDNS    connection.DNSConfig       `json:"upstream_dns"`
+	UpstreamDial   connection.DialConfig      `json:"upstream_dial"`
+	Keepalive      connection.KeepaliveConfig `json:"keepalive"`
+	Throttle       throttle.Config            `json:"throttle"`
+	Admission      admission.Config           `json:"admission"`
+	Shedding       ShedConfig                 `json:"shedding"`
+	Lifetime       LifetimeConfig             `json:"lifetime"`
+	Runtime        RuntimeConfig              `json:"runtime"`
+	UpstreamAuth   UpstreamAuthConfig         `json:"upstream_auth"`
+	WorkerPin      WorkerPinConfig            `json:"worker_pin"`
+	WorkerNames    workername.Config          `json:"worker_names"`
+	Authorize      routing.AuthorizeConfig    `json:"authorize"`
+	Metrics        metrics.LatencyConfig      `json:"metrics"`
+	ACME           ACMEConfig                 `json:"acme"`
+	Fee            FeeConfig                  `json:"fee"`
+	Schedule       ScheduleConfig             `json:"schedule"`
+	Mirror         MirrorConfig               `json:"mirror"`
+	History        history.Config             `json:"history"`
+	Groups         []GroupRule                `json:"groups"`
+	Sessions       sessions.Config            `json:"sessions"`
+	Log            logfile.Config             `json:"log"`
+	ConfigWatch    configfile.WatchConfig     `json:"config_watch"`
 }
 
 // Proxy represents the main proxy instance
 type Proxy struct {
-	cfg *Config
+	// swapped whole by Reload; load it once per operation
+	cfg atomic.Pointer[Config]
 	up  *connection.Upstream
 	mx  *metrics.Collector
 	rt  *routing.Router
@@ -114,14 +365,186 @@ type Proxy struct {
 	vd  *vardiff.Manager
 	rl  *ratelimit.Limiter
 
+	solo *solo.Backend
+	av   *availability.Tracker
+	jr   *journal.Journal
+	cn   *canary.Canary
+	au   *allocaudit.Auditor
+	hr   *hashMeter
+	ag   *aggregate.Aggregator
+	wr   *workers.Registry
+	id   *idle.Watchdog
+	ev   *events.Dispatcher
+	sel  *selection.Selector
+	th   *throttle.Tracker
+	adm  *admission.Controller
+	pins *pinStore
+	tap  *capture.Recorder
+	hist *history.Series
+	grp  *clientGroups
+	ss   *sessions.Trail
+	acme *autocert.Manager
+	dup  duplicateLog
+
+	listening atomic.Bool
+
+	// set while no upstream connection is wanted: no clients and
+	// keep_upstream off, so being disconnected is not an outage
+	upIdle atomic.Bool
+
+	// index of the connected upstream (-1 when down) and whether the
+	// selector closed it to move to a faster one
+	upIdx     atomic.Int32
+	switching atomic.Bool
+
+	// entry of the connected upstream (nil when down) and whether a reload
+	// changed it, which reconnects to the same index
+	activeUp atomic.Pointer[UpstreamConfig]
+	retarget atomic.Bool
+
+	// set while the pool refuses the proxy's credentials
+	authFail atomic.Pointer[authFailure]
+
+	// certificates reloads can replace (nil when not loaded from files)
+	defCert  *certFile
+	sniCerts []*certFile
+	httpCert atomic.Pointer[certFile]
+
+	// restarts the HTTP server on a new address
+	httpRestart chan struct{}
+
+	// fee accounting, whether the timeslice wants the fee upstream and
+	// whether it is the one connected
+	fee      feeMeter
+	feeSlice atomic.Bool
+	feeOn    atomic.Bool
+
+	// index of the open schedule window (-1 for none)
+	schedIdx atomic.Int32
+
+	// second upstream every share is copied to (nil when disabled)
+	mir *mirror
+
+	// jobs, best shares and block candidates
+	scores shareScores
+	jobs   jobLog
+
+	// SNI-selected upstream profiles (empty name for the main proxy)
+	name     string
+	profiles map[string]*Proxy
+
+	// additional client listeners
+	listeners []*listener
+
+	// reads the config file for /admin/config/check (set by main)
+	loadConfig func() (*Config, error)
+
 	clMu    sync.RWMutex
 	clients map[*Client]struct{}
 }
 
-// NewProxy creates a new proxy instance
-func NewProxy(cfg *Config) *Proxy {
-	// Convert config for connection package
-	connCfg := &connection.Config{
+// managerConfig converts the vardiff section into the vardiff package config
+func (c VarDiffConfig) managerConfig() *vardiff.Config {
+	return &vardiff.Config{
+		Enabled:           c.Enabled,
+		TargetSeconds:     c.TargetSeconds,
+		MinDiff:           c.MinDiff,
+		MaxDiff:           c.MaxDiff,
+		AdjustEveryMs:     c.AdjustEveryMs,
+		RestoreDifficulty: c.RestoreDifficulty,
+		RestoreTTLSeconds: c.RestoreTTLSeconds,
+		StateFile:         c.StateFile,
+
+		FastRetargetShares:  c.FastRetargetShares,
+		FastRetargetSeconds: c.FastRetargetSeconds,
+
+		Steps:         c.Steps,
+		StepList:      c.StepList,
+		HysteresisPct: c.HysteresisPct,
+		LogDecisions:  c.LogDecisions,
+	}
+}
+
+// defaultMaxLineBytes bounds client lines when proxy.max_line_bytes is
+// unset; Stratum requests from miners stay well below it
+const defaultMaxLineBytes = 16 << 10
+
+// config returns the current configuration
+func (p *Proxy) config() *Config {
+	return p.cfg.Load()
+}
+
+// maxLineBytes returns the longest line accepted from a client
+func (p *Proxy) maxLineBytes() int {
+	if n := p.config().Proxy.MaxLineBytes; n > 0 {
+		return n
+	}
+	return defaultMaxLineBytes
+}
+
+// userAgent returns the agent string announced to pools
+func userAgent(cfg *Config) string {
+	if cfg.Identity.HideUserAgent {
+		return ""
+	}
+	return cfg.Identity.UserAgent
+}
+
+// routingConfig converts the proxy config for the routing package
+func routingConfig(cfg *Config) *routing.Config {
+	return &routing.Config{
+		Upstream: struct {
+			User string `json:"user"`
+		}{
+			User: cfg.Upstream.User,
+		},
+		Compat:  cfg.Compat,
+		Submit:  cfg.Submit,
+		Pending: cfg.Pending,
+
+		Authorize:   cfg.Authorize,
+		WorkerNames: cfg.WorkerNames,
+	}
+}
+
+// availabilityConfig converts the availability section for the tracker
+func availabilityConfig(cfg *Config) *availability.Config {
+	return &availability.Config{
+		Enabled:          cfg.Availability.Enabled,
+		StateFile:        cfg.Availability.StateFile,
+		SampleIntervalMs: cfg.Availability.SampleIntervalMs,
+		RetentionDays:    cfg.Availability.RetentionDays,
+	}
+}
+
+// journalConfig converts the journal section for the share journal
+func journalConfig(cfg *Config) *journal.Config {
+	return &journal.Config{
+		Enabled:   cfg.Journal.Enabled,
+		Path:      cfg.Journal.Path,
+		Format:    cfg.Journal.Format,
+		MaxSizeMB: cfg.Journal.MaxSizeMB,
+		MaxFiles:  cfg.Journal.MaxFiles,
+	}
+}
+
+// rateLimitConfig converts the ratelimit section for the limiter
+func rateLimitConfig(cfg *Config) *ratelimit.Config {
+	return &ratelimit.Config{
+		Enabled:                 cfg.RateLimit.Enabled,
+		MaxConnectionsPerIP:     cfg.RateLimit.MaxConnectionsPerIP,
+		MaxConnectionsPerMinute: cfg.RateLimit.MaxConnectionsPerMinute,
+		BanDurationSeconds:      cfg.RateLimit.BanDurationSeconds,
+		CleanupIntervalSeconds:  cfg.RateLimit.CleanupIntervalSeconds,
+		IPv4Prefix:              cfg.RateLimit.IPv4Prefix,
+		IPv6Prefix:              cfg.RateLimit.IPv6Prefix,
+		Bans:                    cfg.RateLimit.Bans,
+	}
+}
+
+// connectionConfig converts the proxy config for a connection to upstream u
+func connectionConfig(cfg *Config, u UpstreamConfig) *connection.Config {
+	return &connection.Config{
 		Proxy: struct {
 			ReadBuf  int `json:"read_buf"`
 			WriteBuf int `json:"write_buf"`
@@ -138,91 +561,231 @@ func NewProxy(cfg *Config) *Proxy {
 			InsecureSkipVerify bool              `json:"insecure_skip_verify"`
 			SocksProxy         proxysocks.Config `json:"socks_proxy"`
 		}{
-			Host:               cfg.Upstream.Host,
-			Port:               cfg.Upstream.Port,
-			User:               cfg.Upstream.User,
-			Pass:               cfg.Upstream.Pass,
-			TLS:                cfg.Upstream.TLS,
-			InsecureSkipVerify: cfg.Upstream.InsecureSkipVerify,
-			SocksProxy:         cfg.Upstream.SocksProxy,
+			Host:               u.Host,
+			Port:               u.Port,
+			User:               u.User,
+			Pass:               u.Pass,
+			TLS:                u.TLS,
+			InsecureSkipVerify: u.InsecureSkipVerify,
+			SocksProxy:         u.SocksProxy,
 		},
+		UserAgent:  userAgent(cfg),
+		Writer:     cfg.UpstreamWriter,
+		DNS:        cfg.UpstreamDNS,
+		Dial:       cfg.UpstreamDial,
+		Keepalive:  cfg.Keepalive,
+		TLSOptions: u.tlsOptions(),
 	}
-	// Convert config for routing package
-	routingCfg := &routing.Config{
-		Upstream: struct {
-			User string `json:"user"`
-		}{
-			User: cfg.Upstream.User,
-		},
-		Compat: cfg.Compat,
-	}
+}
+
+// NewProxy creates a new proxy instance
+func NewProxy(cfg *Config) *Proxy {
+	// Convert config for connection package
+	connCfg := connectionConfig(cfg, cfg.Upstream)
 
 	up, err := connection.NewUpstream(connCfg)
 	if err != nil {
 		log.Fatalf("Failed to create upstream: %v", err)
 	}
 	mx := metrics.NewCollector()
-	rt := routing.NewRouter(routingCfg, up, mx)
+	mx.ConfigureLatency(&cfg.Metrics)
+	rt := routing.NewRouter(routingConfig(cfg), up, mx)
 	nm := nonce.NewManager(up)
+	nm.UpdateConfig(&nonce.Config{PrefixBytes: cfg.Extranonce.PrefixBytes, ChainPrefixBytes: cfg.Extranonce.ChainPrefixBytes})
 
-	vdCfg := &vardiff.Config{
-		Enabled:       cfg.VarDiff.Enabled,
-		TargetSeconds: cfg.VarDiff.TargetSeconds,
-		MinDiff:       cfg.VarDiff.MinDiff,
-		MaxDiff:       cfg.VarDiff.MaxDiff,
-		AdjustEveryMs: cfg.VarDiff.AdjustEveryMs,
+	vd := vardiff.NewManager(cfg.VarDiff.managerConfig())
+	if cfg.VarDiff.StateFile != "" {
+		if err := vd.LoadState(cfg.VarDiff.StateFile); err != nil {
+			log.Printf("vardiff: could not load state from %s: %v", cfg.VarDiff.StateFile, err)
+		}
 	}
-	vd := vardiff.NewManager(vdCfg)
 
-	rlCfg := &ratelimit.Config{
-		Enabled:                 cfg.RateLimit.Enabled,
-		MaxConnectionsPerIP:     cfg.RateLimit.MaxConnectionsPerIP,
-		MaxConnectionsPerMinute: cfg.RateLimit.MaxConnectionsPerMinute,
-		BanDurationSeconds:      cfg.RateLimit.BanDurationSeconds,
-		CleanupIntervalSeconds:  cfg.RateLimit.CleanupIntervalSeconds,
+	rl := ratelimit.NewLimiter(rateLimitConfig(cfg))
+
+	av := availability.NewTracker(availabilityConfig(cfg))
+	if cfg.Availability.StateFile != "" {
+		if err := av.LoadState(cfg.Availability.StateFile); err != nil {
+			log.Printf("availability: could not load state from %s: %v", cfg.Availability.StateFile, err)
+		}
+	}
+
+	p := &Proxy{
+		up:       up,
+		mx:       mx,
+		rt:       rt,
+		nm:       nm,
+		vd:       vd,
+		rl:       rl,
+		av:       av,
+		jr:       journal.New(journalConfig(cfg)),
+		au:       allocaudit.New(cfg.Diagnostics.AllocAudit),
+		hr:       newHashMeter(publicHashrateWindow),
+		wr:       workers.NewRegistry(&cfg.Workers),
+		id:       idle.New(&cfg.Idle),
+		ev:       events.New(&cfg.Events),
+		sel:      selection.New(&cfg.Selection),
+		th:       throttle.New(&cfg.Throttle),
+		adm:      admission.New(&cfg.Admission),
+		pins:     newPinStore(),
+		tap:      capture.New(&cfg.Diagnostics.Capture),
+		hist:     history.New(&cfg.History),
+		grp:      newClientGroups(cfg.Groups),
+		ss:       sessions.New(&cfg.Sessions),
+		acme:     newACMEManager(cfg.ACME),
+		mir:      newMirror(cfg),
+		clients:  make(map[*Client]struct{}),
+		profiles: make(map[string]*Proxy),
+
+		httpRestart: make(chan struct{}, 1),
+	}
+	p.cfg.Store(cfg)
+	p.upIdle.Store(true)
+	p.upIdx.Store(-1)
+	p.schedIdx.Store(-1)
+	up.SetFlushHook(func(fs connection.FlushStats) {
+		mx.ObserveUpstreamFlush(fs.Lines, fs.Latency, fs.Queued)
+	})
+	up.SetWriteHook(func(line []byte) {
+		if p.tap.Active() {
+			p.tap.Record(capture.ToUpstream, "", "", line)
+		}
+	})
+	rt.SetShareHook(p.onShare)
+	rt.SetAuthorizeHook(p.onAuthorize)
+	rt.SetSubmitHook(p.feeSubmitted)
+	_ = rt.UseClient("fee", p.feeStage)
+	_ = rt.UseClient("mirror", p.mirrorStage)
+	_ = rt.InsertClient(routing.StageAuth, "jobs", p.jobSubmitStage)
+	_ = rt.UseUpstream("jobs", p.jobStage)
+	rl.SetBanHook(p.onBan)
+	if err := p.wr.Load(); err != nil {
+		log.Printf("workers: could not load registry: %v", err)
+	}
+	p.newProfiles()
+	p.newListeners()
+
+	if cfg.Canary.Enabled {
+		p.cn = canary.New(&canary.Config{
+			Enabled:           true,
+			Addr:              loopbackAddr(cfg.Proxy.Listen),
+			TLS:               cfg.Proxy.TLS.Enabled,
+			Worker:            cfg.Canary.Worker,
+			Password:          cfg.Canary.Password,
+			HashesPerSec:      cfg.Canary.HashesPerSec,
+			SubmitIntervalMs:  cfg.Canary.SubmitIntervalMs,
+			FailThreshold:     cfg.Canary.FailThreshold,
+			ResponseTimeoutMs: cfg.Canary.ResponseTimeoutMs,
+		})
+		p.cn.OnChange(func(healthy bool, reason string) {
+			p.mx.SetCanaryHealthy(healthy)
+		})
 	}
-	rl := ratelimit.NewLimiter(rlCfg)
 
-	return &Proxy{
-		cfg:     cfg,
-		up:      up,
-		mx:      mx,
-		rt:      rt,
-		nm:      nm,
-		vd:      vd,
-		rl:      rl,
-		clients: make(map[*Client]struct{}),
+	if cfg.Solo.Enabled {
+		p.solo = solo.NewBackend(&solo.Config{
+			RPCURL:        cfg.Solo.RPCURL,
+			RPCUser:       cfg.Solo.RPCUser,
+			RPCPass:       cfg.Solo.RPCPass,
+			PayoutAddress: cfg.Solo.PayoutAddress,
+			PollInterval:  time.Duration(cfg.Solo.PollIntervalMs) * time.Millisecond,
+			CoinbaseTag:   cfg.Solo.CoinbaseTag,
+		})
+		rt.SetBackend(soloRouting{p: p})
+	}
+	if cfg.Aggregate.Enabled {
+		p.ag = aggregate.New()
+		rt.SetBackend(aggregateRouting{p: p})
 	}
+	return p
 }
 
-// Reload updates proxy configuration at runtime
-func (p *Proxy) Reload(newCfg *Config) {
+// Reload updates proxy configuration at runtime and reports what was
+// applied and what needs a restart. newCfg becomes the live config and must
+// not be modified afterwards.
+func (p *Proxy) Reload(newCfg *Config) ReloadReport {
 	log.Println("Reloading configuration...")
+	var rep ReloadReport
+	old := p.config()
 
-	// Update Config (Struct copy)
-	// We update the fields implementation pointers point to
-	*p.cfg = *newCfg
+	// Readers load the pointer once per operation, so they see either
+	// config whole
+	p.cfg.Store(newCfg)
+	restartOnly(old, newCfg, &rep)
 
 	// Update specific managers that support reloading
 	// VarDiff
-	p.vd.UpdateConfig(&vardiff.Config{
-		Enabled:       newCfg.VarDiff.Enabled,
-		TargetSeconds: newCfg.VarDiff.TargetSeconds,
-		MinDiff:       newCfg.VarDiff.MinDiff,
-		MaxDiff:       newCfg.VarDiff.MaxDiff,
-		AdjustEveryMs: newCfg.VarDiff.AdjustEveryMs,
-	})
+	p.vd.UpdateConfig(newCfg.VarDiff.managerConfig())
+
+	// SNI upstream profiles
+	p.reloadProfiles(newCfg, &rep)
+
+	// Certificates, the connected upstream and the HTTP server
+	p.reloadCerts(newCfg, &rep)
+	p.retargetUpstream(&rep)
+	if p.name == "" && old.HTTP.Listen != "" && newCfg.HTTP.Listen != "" &&
+		(old.HTTP.Listen != newCfg.HTTP.Listen || old.HTTP.TLS.Enabled != newCfg.HTTP.TLS.Enabled) {
+		select {
+		case p.httpRestart <- struct{}{}:
+		default:
+		}
+		rep.applied("http.listen restarted on %s", newCfg.HTTP.Listen)
+	}
+
+	// Agent string announced on the next upstream subscribe
+	p.up.SetUserAgent(userAgent(newCfg))
+	p.up.SetWriterConfig(newCfg.UpstreamWriter)
+	p.up.SetDNSConfig(newCfg.UpstreamDNS)
+	p.up.SetDialConfig(newCfg.UpstreamDial)
+	p.up.SetKeepaliveConfig(newCfg.Keepalive)
+
+	// Routing (compat and submit limits)
+	p.rt.UpdateConfig(routingConfig(newCfg))
+
+	// Extranonce prefix width (applied once current prefixes are released)
+	p.nm.UpdateConfig(&nonce.Config{PrefixBytes: newCfg.Extranonce.PrefixBytes, ChainPrefixBytes: newCfg.Extranonce.ChainPrefixBytes})
+
+	// Share journal
+	p.jr.UpdateConfig(journalConfig(newCfg))
+
+	// Allocation audit
+	p.au.SetEnabled(newCfg.Diagnostics.AllocAudit)
+
+	// Traffic capture
+	p.tap.UpdateConfig(&newCfg.Diagnostics.Capture)
+
+	// Metrics history
+	p.hist.UpdateConfig(&newCfg.History)
+
+	// Client groups
+	p.grp.UpdateConfig(newCfg.Groups)
+
+	// Session audit trail
+	p.ss.UpdateConfig(&newCfg.Sessions)
+
+	// Idle worker watchdog
+	p.id.UpdateConfig(&newCfg.Idle)
+
+	// Event webhooks
+	p.ev.UpdateConfig(&newCfg.Events)
+
+	// Upstream selection policy
+	p.sel.UpdateConfig(&newCfg.Selection)
+
+	// Share throttle
+	p.th.UpdateConfig(&newCfg.Throttle)
+
+	// Connection admission
+	p.adm.UpdateConfig(&newCfg.Admission)
+
+	// Availability
+	p.av.UpdateConfig(availabilityConfig(newCfg))
 
 	// RateLimit
-	p.rl.UpdateConfig(&ratelimit.Config{
-		Enabled:                 newCfg.RateLimit.Enabled,
-		MaxConnectionsPerIP:     newCfg.RateLimit.MaxConnectionsPerIP,
-		MaxConnectionsPerMinute: newCfg.RateLimit.MaxConnectionsPerMinute,
-		BanDurationSeconds:      newCfg.RateLimit.BanDurationSeconds,
-		CleanupIntervalSeconds:  newCfg.RateLimit.CleanupIntervalSeconds,
-	})
+	p.rl.UpdateConfig(rateLimitConfig(newCfg))
 
 	log.Println("Configuration reloaded")
+	rep.log()
+	return rep
 }
 
 // NewClient creates a new client instance
@@ -234,6 +797,8 @@ func NewClient(conn net.Conn, cfg *Config) *Client {
 		addr:          conn.RemoteAddr().String(),
 		upUser:        cfg.Upstream.User,
 		clientMetrics: metrics.NewClientMetrics(),
+		connected:     time.Now(),
+		lifeJitter:    rand.Float64()*2 - 1,
 	}
 }
 
@@ -242,6 +807,11 @@ func (c *Client) GetAddr() string {
 	return c.addr
 }
 
+// GetSession returns the ID of the client's connection session
+func (c *Client) GetSession() string {
+	return c.session
+}
+
 // GetWorker returns the worker name
 func (c *Client) GetWorker() string {
 	return c.worker
@@ -282,6 +852,11 @@ func (c *Client) SetExtraNonceTrim(trim int) {
 	c.extraNonceTrim = trim
 }
 
+// Chained reports whether the client is another karoo proxy
+func (c *Client) Chained() bool {
+	return c.chained.Load()
+}
+
 // GetLastAccept returns the last accept timestamp
 func (c *Client) GetLastAccept() int64 {
 	return c.lastAccept.Load()
@@ -312,39 +887,102 @@ func (c *Client) IncrementBad() {
 	c.bad.Add(1)
 }
 
-// SetHandshakeDone sets the handshake done flag
+// Subscribed reports whether the client sent mining.subscribe
+func (c *Client) Subscribed() bool {
+	return c.subscribedOK.Load()
+}
+
+// SetHandshakeDone sets the handshake done flag; setting it sends the
+// difficulty and job held back until then
 func (c *Client) SetHandshakeDone(done bool) {
+	if done && !c.handshakeDone.Load() {
+		if d := time.Duration(c.stagger.Swap(0)); d > 0 {
+			t := time.AfterFunc(d, c.authorized)
+			context.AfterFunc(c.ctx, func() { t.Stop() })
+			return
+		}
+		c.authorized()
+		return
+	}
 	c.handshakeDone.Store(done)
 }
 
+// recordReject counts a rejected share under its canonical category
+func (c *Client) recordReject(category string) {
+	c.rejectMu.Lock()
+	defer c.rejectMu.Unlock()
+	if c.rejects == nil {
+		c.rejects = make(map[string]uint64)
+	}
+	c.rejects[category]++
+}
+
+// getRejects returns a copy of the client's reject counters
+func (c *Client) getRejects() map[string]uint64 {
+	c.rejectMu.Lock()
+	defer c.rejectMu.Unlock()
+	if len(c.rejects) == 0 {
+		return nil
+	}
+	out := make(map[string]uint64, len(c.rejects))
+	for k, v := range c.rejects {
+		out[k] = v
+	}
+	return out
+}
+
 // WriteJSON writes a JSON message to the client
 func (c *Client) WriteJSON(msg stratum.Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	_, err = c.bw.Write(data)
-	if err != nil {
-		return err
+	f := stratum.NewFrame(data)
+	err = c.WriteFrame(f)
+	f.Release()
+	return err
+}
+
+// decode parses a line from the client, through its compat profile if any
+func (c *Client) decode(line string) (stratum.Message, error) {
+	if c.cs != nil {
+		return c.cs.Decode([]byte(line))
 	}
-	_, err = c.bw.WriteString("\n")
-	if err != nil {
-		return err
+	var msg stratum.Message
+	err := json.Unmarshal([]byte(line), &msg)
+	if err == nil && msg.Method == stratum.MethodSubmit {
+		msg.Raw = line
+	}
+	return msg, err
+}
+
+// bind scopes the client to ctx: cancelling ctx or closing the client
+// closes the connection, which ends the client loop and writer
+func (c *Client) bind(ctx context.Context) {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	context.AfterFunc(c.ctx, func() {
+		if ctx.Err() != nil {
+			c.closing(reasonShutdown)
+		}
+		_ = c.Close()
+	})
+}
+
+// Close closes the client connection, ending its client loop
+func (c *Client) Close() error {
+	if c.cancel != nil {
+		c.cancel()
 	}
-	return c.bw.Flush()
+	c.stopWriter()
+	return c.c.Close()
 }
 
 // WriteLine writes a line to the client
 func (c *Client) WriteLine(line string) error {
-	_, err := c.bw.WriteString(line)
-	if err != nil {
-		return err
-	}
-	_, err = c.bw.WriteString("\n")
-	if err != nil {
-		return err
-	}
-	return c.bw.Flush()
+	f := stratum.NewFrameString(line)
+	err := c.WriteFrame(f)
+	f.Release()
+	return err
 }
 
 // AcceptLoop accepts new client connections
@@ -352,22 +990,24 @@ func (p *Proxy) AcceptLoop(ctx context.Context) error {
 	var ln net.Listener
 	var err error
 
-	if p.cfg.Proxy.TLS.Enabled {
-		cert, err := tls.LoadX509KeyPair(p.cfg.Proxy.TLS.Cert, p.cfg.Proxy.TLS.Key)
-		if err != nil {
-			return fmt.Errorf("loading tls keys: %w", err)
+	cfg := p.config()
+	if cfg.Proxy.TLS.Enabled {
+		var tlsCfg *tls.Config
+		if tlsCfg, err = p.listenerTLSConfig(); err != nil {
+			return err
 		}
-		tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
-		ln, err = tls.Listen("tcp", p.cfg.Proxy.Listen, tlsCfg)
-		log.Printf("proxy: listening on %s (TLS enabled)", p.cfg.Proxy.Listen)
+		ln, err = tls.Listen("tcp", cfg.Proxy.Listen, tlsCfg)
+		log.Printf("proxy: listening on %s (TLS enabled, %d SNI routes)", cfg.Proxy.Listen, len(cfg.SNIRoutes))
 	} else {
-		ln, err = net.Listen("tcp", p.cfg.Proxy.Listen)
-		log.Printf("proxy: listening on %s", p.cfg.Proxy.Listen)
+		ln, err = net.Listen("tcp", cfg.Proxy.Listen)
+		log.Printf("proxy: listening on %s", cfg.Proxy.Listen)
 	}
 
 	if err != nil {
 		return err
 	}
+	p.listening.Store(true)
+	defer p.listening.Store(false)
 	go func() {
 		<-ctx.Done()
 		_ = ln.Close()
@@ -389,38 +1029,178 @@ func (p *Proxy) AcceptLoop(ctx context.Context) error {
 			continue
 		}
 
-		if p.mx.ClientsActive.Load() >= int64(p.cfg.Proxy.MaxClients) {
-			log.Printf("rejecting client: max reached")
+		// SNI routing needs the handshake, which must not block the accept loop
+		if p.config().Proxy.TLS.Enabled && len(p.profiles) > 0 {
+			go p.dispatch(ctx, conn)
+			continue
+		}
+		p.admit(ctx, conn, nil)
+	}
+}
+
+// admit registers an accepted connection as a client and starts its loop.
+// l is the additional listener the connection arrived on, if any. Past
+// admission.max_handshakes the connection waits in the backlog, off the
+// accept loop, until a handshake finishes.
+func (p *Proxy) admit(ctx context.Context, conn net.Conn, l *listener) {
+	wait, ok := p.adm.Handshake()
+	if !ok {
+		log.Printf("rejecting client %s: admission %s", conn.RemoteAddr(), admission.ReasonBacklog)
+		p.mx.IncrementAdmissionRejections(admission.ReasonBacklog)
+		p.rl.ReleaseConnection(conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	if wait == nil {
+		p.admitNow(ctx, conn, l, 0)
+		return
+	}
+	go func() {
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			p.adm.CancelHandshake(wait)
 			p.rl.ReleaseConnection(conn.RemoteAddr())
 			_ = conn.Close()
-			continue
+			return
 		}
-		cli := NewClient(conn, p.cfg)
-		cli.last.Store(time.Now().UnixMilli())
-		cli.diff.Store(int64(p.cfg.VarDiff.MinDiff))
+		adm := p.config().Admission
+		if d := adm.BacklogJitter(); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				p.adm.HandshakeDone()
+				p.rl.ReleaseConnection(conn.RemoteAddr())
+				_ = conn.Close()
+				return
+			}
+		}
+		p.admitNow(ctx, conn, l, adm.Stagger())
+	}()
+}
 
-		p.clMu.Lock()
-		p.clients[cli] = struct{}{}
-		p.clMu.Unlock()
+// admitNow admits a connection holding a handshake slot. stagger delays the
+// first difficulty and job after authorize.
+func (p *Proxy) admitNow(ctx context.Context, conn net.Conn, l *listener, stagger time.Duration) {
+	cfg := p.config()
+	if l != nil && l.full() {
+		log.Printf("rejecting client %s: listener %s max reached", conn.RemoteAddr(), l.cfg.Name)
+		p.adm.HandshakeDone()
+		p.rl.ReleaseConnection(conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	if p.nm.Exhausted() {
+		log.Printf("rejecting client %s: extranonce prefixes exhausted", conn.RemoteAddr())
+		p.adm.HandshakeDone()
+		p.rl.ReleaseConnection(conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	if reason := p.adm.Admit(p.mx.ClientsActive.Load(), cfg.Proxy.MaxClients, time.Now()); reason != admission.ReasonNone {
+		log.Printf("rejecting client %s: admission %s", conn.RemoteAddr(), reason)
+		p.mx.IncrementAdmissionRejections(reason)
+		p.adm.HandshakeDone()
+		p.rl.ReleaseConnection(conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+	cfg.Keepalive.SetTCPKeepAlive(conn)
+	cli := NewClient(conn, cfg)
+	cli.bind(ctx)
+	cli.pending.Store(true)
+	cli.handshakeSlot.Store(true)
+	cli.stagger.Store(int64(stagger))
+	p.mx.SetPendingSubscribe(p.adm.Pending())
+	if d := cfg.Admission.SubscribeTimeout(); d > 0 {
+		t := time.AfterFunc(d, func() { p.subscribeTimeout(cli, d) })
+		context.AfterFunc(cli.ctx, func() { t.Stop() })
+	}
+	cli.cs = compat.NewSession(p.compatProfile(l))
+	cli.tap = p.tap
+	cli.session = p.ss.NewID()
+	cli.startWriter(cfg.ClientQueue, p.mx)
+	cli.last.Store(time.Now().UnixMilli())
+	start := p.startDifficulty(l)
+	cli.diff.Store(int64(start))
+	if l != nil {
+		cli.ln = l
+		l.active.Add(1)
+	}
+
+	p.clMu.Lock()
+	p.clients[cli] = struct{}{}
+	p.clMu.Unlock()
+
+	// Add to all managers
+	p.rt.AddClient(cli)
+	p.vd.AddClientAt(cli, start)
+	p.mx.ClientsActive.Add(1)
+	if p.name != "" {
+		log.Printf("client connected: %s session=%s (profile %s)", cli.addr, cli.session, p.name)
+	} else {
+		log.Printf("client connected: %s session=%s", cli.addr, cli.session)
+	}
+
+	p.sessionEvent(cli, sessions.Connect, "", "")
+
+	go p.ClientLoop(ctx, cli)
+}
+
+// subscribed ends the pending state of a client that subscribed or
+// disconnected
+func (p *Proxy) subscribed(cl *Client) {
+	if cl.pending.CompareAndSwap(true, false) {
+		p.adm.Release()
+		p.mx.SetPendingSubscribe(p.adm.Pending())
+	}
+}
 
-		// Add to all managers
-		p.rt.AddClient(cli)
-		p.vd.AddClient(cli)
-		p.mx.ClientsActive.Add(1)
-		log.Printf("client connected: %s", cli.addr)
+// chainedAgent reports whether a subscribe comes from another karoo, which
+// announces itself as karoo/<version> unless its agent is changed or hidden
+func chainedAgent(msg stratum.Message) bool {
+	params, ok := msg.Params.([]interface{})
+	if !ok || len(params) == 0 {
+		return false
+	}
+	agent, _ := params[0].(string)
+	return strings.HasPrefix(agent, "karoo/")
+}
 
-		go p.ClientLoop(ctx, cli)
+// handshakeFinished frees the client's handshake slot once it is authorized,
+// refused or gone, letting the next connection out of the backlog
+func (p *Proxy) handshakeFinished(cl *Client) {
+	if cl.handshakeSlot.CompareAndSwap(true, false) {
+		p.adm.HandshakeDone()
 	}
 }
 
+// subscribeTimeout closes a client that has not subscribed after d
+func (p *Proxy) subscribeTimeout(cl *Client, d time.Duration) {
+	if !cl.pending.Load() {
+		return
+	}
+	log.Printf("closing client %s session=%s: no mining.subscribe within %s", cl.addr, cl.session, d)
+	p.kick(cl, reasonSubscribeTimeout)
+	p.adm.TimedOut()
+	p.mx.IncrementSubscribeTimeouts()
+	_ = cl.Close()
+}
+
 // ClientLoop handles individual client communication
 func (p *Proxy) ClientLoop(ctx context.Context, cl *Client) {
 	startTime := time.Now()
 
 	defer func() {
+		p.subscribed(cl)
+		p.handshakeFinished(cl)
 		p.nm.RemovePendingSubscribe(cl)
+		p.nm.ReleaseNoncePrefix(cl)
 		p.rt.RemoveClient(cl)
 		p.vd.RemoveClient(cl)
+		p.th.Remove(cl.addr)
 		p.rl.ReleaseConnection(cl.c.RemoteAddr())
 
 		p.clMu.Lock()
@@ -428,7 +1208,13 @@ func (p *Proxy) ClientLoop(ctx context.Context, cl *Client) {
 		p.clMu.Unlock()
 
 		p.mx.ClientsActive.Add(-1)
-		_ = cl.c.Close()
+		if w := cl.GetWorker(); w != "" {
+			p.emit(events.WorkerDisconnected, map[string]interface{}{"worker": w, "addr": cl.addr, "session": cl.session})
+		}
+		if cl.ln != nil {
+			cl.ln.active.Add(-1)
+		}
+		_ = cl.Close()
 
 		// Log graceful disconnect with session statistics
 		duration := time.Since(startTime)
@@ -438,48 +1224,100 @@ func (p *Proxy) ClientLoop(ctx context.Context, cl *Client) {
 			worker = "unknown"
 		}
 
-		log.Printf("client closed: %s worker=%s duration=%s shares=%d (ok=%d bad=%d)",
-			cl.addr, worker, duration.Round(time.Second), totalShares, cl.GetOK(), cl.GetBad())
+		log.Printf("client closed: %s worker=%s session=%s duration=%s shares=%d (ok=%d bad=%d)",
+			cl.addr, worker, cl.session, duration.Round(time.Second), totalShares, cl.GetOK(), cl.GetBad())
+
+		cl.closing(reasonClientClosed)
+		p.sessionEvent(cl, sessions.Disconnect, "", *cl.closeReason.Load())
 	}()
 
 	sc := bufio.NewScanner(cl.br)
-	buf := make([]byte, 0, p.cfg.Proxy.ReadBuf)
-	sc.Buffer(buf, 1024*1024)
+	buf := make([]byte, 0, min(p.config().Proxy.ReadBuf, p.maxLineBytes()))
+	sc.Buffer(buf, p.maxLineBytes())
 
-	idle := p.cfg.Proxy.ClientIdleMs
-	postHandshakeIdle := 30 * time.Minute // Timeout for authenticated clients
+	idle := p.config().Proxy.ClientIdleMs
 	for {
+		cfg := p.config()
 		if idle > 0 && !cl.handshakeDone.Load() {
 			// Pre-handshake timeout (shorter)
 			_ = cl.c.SetReadDeadline(time.Now().Add(time.Duration(idle) * time.Millisecond))
 		} else if cl.handshakeDone.Load() {
-			// Post-handshake timeout (longer, prevents resource leaks)
-			_ = cl.c.SetReadDeadline(time.Now().Add(postHandshakeIdle))
+			// Post-handshake silence window (longer, catches dead peers)
+			_ = cl.c.SetReadDeadline(time.Now().Add(cfg.Keepalive.ClientSilence()))
 		} else {
 			_ = cl.c.SetReadDeadline(time.Time{})
 		}
 		if !sc.Scan() {
-			if err := sc.Err(); err != nil && !isNetClosed(err) {
-				log.Printf("client scan err %s: %v", cl.addr, err)
+			err := sc.Err()
+			if errors.Is(err, bufio.ErrTooLong) {
+				p.mx.IncrementClientOversizedLines()
+				log.Printf("dropping client %s session=%s: line longer than %d bytes", cl.addr, cl.session, p.maxLineBytes())
+				cl.closing(reasonLineTooLong)
+				_ = cl.WriteJSON(stratum.ErrorResponseFor(nil, stratum.ErrLineTooLong))
+				return
 			}
+			if err != nil && !isNetClosed(err) {
+				log.Printf("client scan err %s session=%s: %v", cl.addr, cl.session, err)
+			}
+			cl.closing(readReason(err))
 			return
 		}
 		line := sc.Text()
 		cl.last.Store(time.Now().UnixMilli())
+		if p.tap.Active() {
+			p.tap.Record(capture.FromClient, cl.addr, cl.worker, []byte(line))
+		}
 
-		var msg stratum.Message
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		sample := p.au.Begin()
+		msg, err := cl.decode(line)
+		if err != nil {
+			p.au.End(sample, "client invalid")
+			p.mx.IncrementClientInvalidLines()
+			n := cl.invalid.Add(1)
+			if limit := cfg.Proxy.MaxInvalidLines; limit > 0 && n >= uint64(limit) {
+				p.dropInvalid(cl, n)
+				return
+			}
 			continue
 		}
 
 		switch msg.Method {
 		case "mining.subscribe":
+			p.subscribed(cl)
+			cl.subscribedOK.Store(true)
+			p.sessionEvent(cl, sessions.Subscribe, "", "")
+			if (cl.ln != nil && cl.ln.cfg.Chain) || chainedAgent(msg) {
+				cl.chained.Store(true)
+			}
 			p.nm.RespondSubscribe(cl, msg.ID)
+			p.au.End(sample, auditLabel("client", msg.Method))
+			continue
+
+		case stratum.MethodSuggestDifficulty:
+			p.suggestDifficulty(cl, msg)
+			p.au.End(sample, auditLabel("client", msg.Method))
 			continue
 
 		default:
+			if msg.Method == "mining.authorize" && (p.rejectBannedWorker(cl, msg) || p.rejectPinnedWorker(cl, msg) || p.checkDuplicate(cl, &msg)) {
+				return
+			}
+			if msg.Method == stratum.MethodSubmit && p.throttleSubmit(cl, msg) {
+				p.au.End(sample, auditLabel("client", msg.Method))
+				continue
+			}
+
 			// Route all other messages through the router
 			p.rt.ProcessClientMessage(cl, msg)
+
+			// Restore the worker's last known difficulty once it identifies itself
+			if msg.Method == "mining.authorize" && cl.GetWorker() != "" {
+				p.vd.BindWorker(cl, cl.GetWorker())
+				p.applyPasswordDifficulty(cl, msg)
+				p.applyWorkerProfile(cl)
+				p.emit(events.WorkerConnected, map[string]interface{}{"worker": cl.GetWorker(), "addr": cl.addr, "session": cl.session})
+			}
+			p.au.End(sample, auditLabel("client", msg.Method))
 		}
 	}
 }
@@ -487,12 +1325,17 @@ func (p *Proxy) ClientLoop(ctx context.Context, cl *Client) {
 // UpstreamLoop manages upstream connection and message handling with failover support
 func (p *Proxy) UpstreamLoop(ctx context.Context) {
 	currentIdx := 0
+	lastFee, lastWindow := false, int32(-1)
 
 	for ctx.Err() == nil {
-		// Rebuild list of upstreams to try (Primary + Backups) on every iteration
+		// Rebuild list of upstreams to try on every iteration
 		// This allows hot-reloading of upstream configuration
-		configs := []UpstreamConfig{p.cfg.Upstream}
-		configs = append(configs, p.cfg.Backups...)
+		window := p.schedIdx.Load()
+		fee := p.feeSlice.Load()
+		if fee != lastFee || window != lastWindow {
+			currentIdx, lastFee, lastWindow = 0, fee, window
+		}
+		configs := p.upstreamConfigs(fee)
 
 		// Safety check if configs is empty (shouldn't happen with validation)
 		if len(configs) == 0 {
@@ -516,132 +1359,394 @@ func (p *Proxy) UpstreamLoop(ctx context.Context) {
 			activeCfg.TLS,
 			activeCfg.InsecureSkipVerify,
 		)
+		p.up.SetTLSOptions(activeCfg.tlsOptions())
+		p.rt.SetDifficultyScale(activeCfg.DifficultyScale)
+		p.up.SetTunnel(activeCfg.Tunnel)
+		p.up.SetSubscribe(activeCfg.Subscribe)
 
 		min := time.Duration(activeCfg.BackoffMinMs) * time.Millisecond
 		max := time.Duration(activeCfg.BackoffMaxMs) * time.Millisecond
 
-		if err := p.up.Dial(ctx); err != nil {
+		dialStart := time.Now()
+		err := p.up.SetSocksProxy(activeCfg.socksProxy())
+		if err == nil {
+			err = p.up.Dial(ctx)
+		}
+		if err != nil {
 			d := connection.Backoff(min, max)
 			log.Printf("upstream dial fail (idx=%d): %v; retry in %s", currentIdx, err, d)
 
 			// Failover logic: switch to next upstream
-			currentIdx = (currentIdx + 1) % len(configs)
+			failed := currentIdx
+			currentIdx = p.sel.Next(addrs(configs), currentIdx)
 			if currentIdx != 0 {
 				log.Printf("switching to backup upstream index %d", currentIdx)
 			} else {
 				log.Printf("cycled through all upstreams, back to primary")
 			}
+			if len(configs) > 1 {
+				p.emit(events.UpstreamFailover, map[string]interface{}{
+					"from": upstreamEvent(failed, configs[failed]),
+					"to":   upstreamEvent(currentIdx, configs[currentIdx]),
+				})
+			}
 
 			time.Sleep(d)
 			continue
 		}
 
-		p.mx.UpConnected.Store(true)
-		log.Printf("upstream connected (idx=%d)", currentIdx)
+		p.mx.SetUpstreamState(metrics.UpstreamAuthenticating)
+		p.mx.ObserveUpstreamDial(activeCfg.addr(), time.Since(dialStart))
+		p.upIdx.Store(int32(currentIdx))
+		p.activeUp.Store(&activeCfg)
+		p.feeOn.Store(fee && currentIdx == 0)
+		log.Printf("upstream dialed (idx=%d); authenticating", currentIdx)
+
+		// difficulties and share hashes follow the pool's proof of work;
+		// the name was checked when the config was loaded
+		alg, _ := stratum.AlgorithmByName(activeCfg.Algorithm)
+		if alg == nil {
+			alg = stratum.SHA256d
+		}
+		p.rt.SetAlgorithm(alg)
+		p.scores.setAlgorithm(alg)
+		if p.ag != nil {
+			p.ag.SetAlgorithm(alg)
+		}
 
 		// handshake
+		handshakeStart := time.Now()
 		if err := p.up.SubscribeAuthorize(); err != nil {
 			log.Printf("handshake err: %v", err)
 			p.up.Close()
-			p.mx.UpConnected.Store(false)
+			p.rt.ResetSubmits()
+			p.mx.SetUpstreamState(metrics.UpstreamDisconnected)
+			p.mx.SetUpstreamInactive()
+			p.upIdx.Store(-1)
+			p.activeUp.Store(nil)
+			p.feeOn.Store(false)
 
 			// Try next upstream on handshake failure
-			currentIdx = (currentIdx + 1) % len(configs)
+			currentIdx = p.sel.Next(addrs(configs), currentIdx)
 			time.Sleep(1 * time.Second)
 			continue
 		}
+		p.authorizeFee()
+
+		// connected once the extranonce is parsed and authorize returned true
+		authorized, connected, authRejected := false, false, false
+		markConnected := func() {
+			if connected || !authorized || !p.nm.UpstreamReady() {
+				return
+			}
+			connected = true
+			p.authAccepted()
+			p.mx.SetUpstreamState(metrics.UpstreamConnected)
+			p.mx.ObserveUpstreamHandshake(time.Since(handshakeStart))
+			log.Printf("upstream connected (idx=%d)", currentIdx)
+			p.emit(events.UpstreamUp, upstreamEvent(currentIdx, activeCfg))
+			if n := p.rt.ReleaseHeld(); n > 0 {
+				log.Printf("forwarded %d submit(s) held during the reconnect", n)
+			}
+		}
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		go p.upstreamWatchdog(watchCtx, handshakeStart)
+		// the manager stopping the upstream (idle grace) closes the connection
+		stopClose := context.AfterFunc(ctx, p.up.Close)
 
 		sc := bufio.NewScanner(p.up.GetReader())
-		buf := make([]byte, 0, p.cfg.Proxy.ReadBuf)
+		buf := make([]byte, 0, p.config().Proxy.ReadBuf)
 		sc.Buffer(buf, 1024*1024)
 
 		for sc.Scan() {
-			line := sc.Text()
-			p.rt.ProcessUpstreamMessage(line)
-
-			// Handle subscribe result specially
-			var msg stratum.Message
-			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			if p.tap.Active() {
+				p.tap.Record(capture.FromUpstream, "", "", sc.Bytes())
+			}
+			sample := p.au.Begin()
+			msg, err := p.rt.ProcessUpstreamLine(sc.Bytes())
+			if err != nil {
+				p.au.End(sample, "upstream invalid")
 				continue
 			}
 
-			if msg.Result != nil && msg.ID != nil && *msg.ID == 1 {
-				log.Printf("subscribe result: %v", msg.Result)
-				p.nm.ProcessSubscribeResult(msg.Result)
+			if id, ok := msg.ID.Int64(); ok && !p.up.PingReply(id) {
+				switch p.up.HandshakeMethod(id) {
+				case stratum.MethodSubscribe:
+					if msg.Result != nil {
+						log.Printf("subscribe result: %v", msg.Result)
+						p.nm.ProcessSubscribeResult(msg.Result)
+					}
+					markConnected()
+				case stratum.MethodAuthorize:
+					if ok, _ := msg.Result.(bool); !ok || msg.Error != nil {
+						authRejected = true
+					} else {
+						authorized = true
+						markConnected()
+					}
+				}
+			}
+			p.au.End(sample, auditLabel("upstream", msg.Method))
+			if authRejected {
+				break
 			}
 		}
 
-		if err := sc.Err(); err != nil && !isNetClosed(err) {
+		stopWatch()
+		stopClose()
+		if err := sc.Err(); errors.Is(err, os.ErrDeadlineExceeded) {
+			log.Printf("upstream silent for %s; reconnecting", p.config().Keepalive.UpstreamSilence())
+			p.mx.IncrementUpstreamStalls("silent")
+		} else if err != nil && !isNetClosed(err) {
 			log.Printf("upstream read err: %v", err)
 		}
 		p.up.Close()
-		p.mx.UpConnected.Store(false)
+		p.mx.SetUpstreamState(metrics.UpstreamDisconnected)
+		p.mx.SetUpstreamInactive()
+		p.upIdx.Store(-1)
+		p.activeUp.Store(nil)
+		p.feeOn.Store(false)
+		if connected {
+			p.emit(events.UpstreamDown, upstreamEvent(currentIdx, activeCfg))
+		}
+		p.rt.ResetSubmits()
 		p.nm.Reset()
+		if p.ag != nil {
+			p.ag.Reset()
+		}
 
+		// A reload that changed this upstream reconnects to it at once
+		if p.retarget.Swap(false) {
+			continue
+		}
+
+		// Refused credentials wait longer, on the same upstream unless
+		// switch_backup finds one with other credentials
+		if authRejected {
+			refused := currentIdx
+			var d time.Duration
+			currentIdx, d = p.authRejected(configs, refused, time.Now())
+			p.authWait(ctx, fee, refused, activeCfg, d)
+			continue
+		}
+
+		// Try next upstream on disconnect; a deliberate switch skips the backoff
+		currentIdx = p.sel.Next(addrs(configs), currentIdx)
+		if p.switching.Swap(false) {
+			continue
+		}
 		d := connection.Backoff(min, max)
 		log.Printf("upstream disconnected; retry in %s", d)
 		time.Sleep(d)
+	}
+}
 
-		// Try next upstream on disconnect
-		currentIdx = (currentIdx + 1) % len(configs)
+// upstreamConfigs lists the upstreams in the order they are tried. A
+// scheduled window and then a fee timeslice put their upstream first; the
+// main upstream and backups remain its failover.
+func (p *Proxy) upstreamConfigs(fee bool) []UpstreamConfig {
+	cfg := p.config()
+	configs := []UpstreamConfig{cfg.Upstream}
+	configs = append(configs, cfg.Backups...)
+	if w := p.scheduledWindow(); w != nil {
+		configs = w.apply(configs)
 	}
+	if fee {
+		configs = append([]UpstreamConfig{cfg.Fee.Upstream}, configs...)
+	}
+	return configs
 }
 
-// HttpServe starts HTTP server with status and health endpoints
-func (p *Proxy) HttpServe(ctx context.Context) {
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		_, _ = w.Write([]byte("ok"))
-	})
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		type clientView struct {
-			IP     string `json:"ip"`
-			Worker string `json:"worker"`
-			UpUser string `json:"upstream_user"`
-			OK     uint64 `json:"ok"`
-			Bad    uint64 `json:"bad"`
-		}
-		p.clMu.RLock()
-		var clv []clientView
-		for cl := range p.clients {
-			clv = append(clv, clientView{
-				IP:     cl.addr,
-				Worker: cl.worker,
-				UpUser: cl.upUser,
-				OK:     cl.ok.Load(),
-				Bad:    cl.bad.Load(),
-			})
-		}
-		p.clMu.RUnlock()
-
-		ex1, ex2Size := p.up.GetExtranonce()
+// httpHandler builds the handler of http.listen on a private mux, so
+// packages registering on mux (net/http/pprof) stay off it
+func (p *Proxy) httpHandler() http.Handler {
+	cfg := p.config()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", serveLive)
+	mux.HandleFunc("/healthz", serveLive)
+	mux.HandleFunc("/readyz", p.serveReady)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		cfg := p.config()
+		ex := p.up.ExtranonceState()
 		out := map[string]interface{}{
 			"upstream":         p.mx.UpConnected.Load(),
-			"extranonce1":      ex1,
-			"extranonce2_size": ex2Size,
+			"upstream_state":   p.mx.UpstreamState(),
+			"upstream_auth":    p.authFail.Load(),
+			"extranonce1":      ex.Ex1,
+			"extranonce2_size": ex.Ex2Size,
+			"extranonce_gen":   ex.Gen,
 			"last_notify_unix": p.mx.LastNotifyUnix.Load(),
+			"notify_gap":       p.mx.NotifyGap.Load(),
+			"job_refreshes":    p.mx.JobRefreshes.Load(),
 			"last_diff":        p.mx.LastSetDiff.Load(),
 			"shares_ok":        p.mx.SharesOK.Load(),
 			"shares_bad":       p.mx.SharesBad.Load(),
-			"clients":          clv,
+			"reject_reasons":   p.mx.GetRejectReasons(),
+			"clients":          p.clientViews(),
+			"upstreams":        p.upstreamViews(),
 			"vardiff":          p.vd.GetStats(),
+			"extranonce":       p.nm.GetStats(),
 			"ratelimit":        p.rl.GetGlobalStats(),
+			"bans":             p.rl.Bans(),
+			"submits":          p.rt.GetSubmitStats(),
+			"pending_requests": p.rt.GetPendingStats(),
+			"upstream_latency": p.mx.UpstreamLatencies(),
+			"upstream_writer":  p.up.GetWriterStats(),
+			"upstream_dns":     p.up.GetDNSStats(),
+		}
+		if p.ag != nil {
+			out["aggregate"] = p.ag.GetStats()
+		}
+		if p.solo != nil {
+			out["solo"] = p.solo.GetStats()
+		}
+		if p.cn != nil {
+			out["canary"] = p.cn.GetStats()
+		}
+		if len(p.profiles) > 0 {
+			out["profiles"] = p.profileStats()
+		}
+		if n := p.mx.BroadcastSuppressed.Load(); n > 0 {
+			out["broadcast_suppressed"] = n
+		}
+		out["runtime"] = runtimeStats()
+		if n := p.mx.GoroutineLeaks.Load(); n > 0 {
+			out["goroutine_leaks"] = n
+		}
+		if len(p.listeners) > 0 {
+			out["listeners"] = p.listenerStats()
+		}
+		if cfg.Idle.Enabled {
+			out["idle"] = p.id.Idle()
+		}
+		if p.ev.Enabled() {
+			out["events"] = p.ev.GetStats()
+		}
+		if cfg.WorkerPin.Enabled {
+			out["worker_pins"] = p.pins.stats(time.Now())
+		}
+		if cfg.Throttle.Enabled {
+			out["throttle"] = p.th.GetStats(time.Now())
+		}
+		if cfg.Admission.Enabled {
+			out["admission"] = p.adm.GetStats(time.Now())
+		}
+		if cfg.Shedding.Enabled {
+			out["shedding"] = p.shedStats()
+		}
+		if cfg.Fee.Enabled {
+			out["fee"] = p.feeStats()
+		}
+		if cfg.Schedule.Enabled {
+			out["schedule"] = p.scheduleStats()
+		}
+		if p.mir != nil {
+			out["mirror"] = p.mir.GetStats()
+		}
+		if p.solo == nil {
+			out["best_shares"] = p.bestShareStats()
+		}
+		if p.grp.enabled() {
+			out["groups"] = p.groupStats()
+		}
+		if ts, ok := p.up.TunnelStats(); ok {
+			out["tunnel"] = ts
+		}
+		if p.sel.Probing() {
+			out["selection"] = p.sel.GetStats(p.upstreamAddrs())
+		}
+		if cfg.Duplicates.Policy != "" && cfg.Duplicates.Policy != DuplicateAllow {
+			out["duplicates"] = p.dup.list()
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(out)
 	})
-	http.Handle("/metrics", promhttp.Handler())
-	srv := &http.Server{Addr: p.cfg.HTTP.Listen}
-	go func() {
-		<-ctx.Done()
+	mux.HandleFunc("/status/history", p.handleHistory)
+	mux.HandleFunc("/status/jobs", p.handleJobs)
+	mux.HandleFunc("/sessions", p.handleSessions)
+	mux.HandleFunc("/vardiff/", p.handleVarDiff)
+	mux.HandleFunc("/api/availability", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p.av.Report())
+	})
+	// exemplars are only served in the OpenMetrics format
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: cfg.Metrics.Exemplars})))
+	p.registerIdentityHandlers(mux)
+	p.registerAuditHandlers(mux)
+	p.registerCaptureHandlers(mux)
+	p.registerBanHandlers(mux)
+	p.registerPinHandlers(mux)
+	p.registerWorkerHandlers(mux)
+	p.registerConfigHandlers(mux)
+	if cfg.Public.Listen == "" {
+		mux.HandleFunc("/public", p.handlePublic)
+	}
+	if cfg.HTTP.Pprof && cfg.HTTP.PprofListen == "" {
+		registerPprof(mux)
+	}
+	return p.withServerHeader(p.withHTTPAuth(mux))
+}
+
+// HttpServe starts HTTP server with status and health endpoints
+func (p *Proxy) HttpServe(ctx context.Context) {
+	handler := p.httpHandler()
+	shutdown := func(srv *http.Server) {
 		ctx2, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 		_ = srv.Shutdown(ctx2)
-	}()
-	log.Printf("http: listening on %s", p.cfg.HTTP.Listen)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Printf("http err: %v", err)
 	}
+
+	// A reload that moves http.listen restarts the server on the new address
+	for {
+		srv := &http.Server{Addr: p.config().HTTP.Listen, Handler: handler}
+		done := make(chan error, 1)
+		go func() { done <- p.listenHTTP(srv) }()
+		select {
+		case <-ctx.Done():
+			shutdown(srv)
+			return
+		case <-p.httpRestart:
+			shutdown(srv)
+			<-done
+			continue
+		case err := <-done:
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("http err: %v", err)
+			}
+		}
+		// wait for a reload to fix the address
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.httpRestart:
+		}
+	}
+}
+
+// listenHTTP serves srv with the TLS settings of http
+func (p *Proxy) listenHTTP(srv *http.Server) error {
+	cfg := p.config()
+	if cfg.HTTP.TLS.Enabled {
+		cf := p.httpCert.Load()
+		if cf == nil {
+			var err error
+			if cf, err = loadCertFile(cfg.HTTP.TLS.Cert, cfg.HTTP.TLS.Key); err != nil {
+				return err
+			}
+			p.httpCert.Store(cf)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: cf.get}
+		log.Printf("http: listening on %s (TLS enabled)", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	if p.acme != nil && cfg.ACME.Dashboard {
+		srv.TLSConfig = &tls.Config{GetCertificate: p.acmeCertificate}
+		log.Printf("http: listening on %s (TLS via ACME)", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	log.Printf("http: listening on %s", srv.Addr)
+	return srv.ListenAndServe()
 }
 
 // ReportLoop generates periodic reports about proxy performance
@@ -683,7 +1788,8 @@ func (p *Proxy) ReportLoop(ctx context.Context, interval time.Duration) {
 			if submittedTotal > 0 {
 				accTotal = (float64(totalOK) / float64(submittedTotal)) * 100
 			}
-			log.Printf("Periodic Report interval=%10s total=%10s | submitted %d/%d (acc %.1f%% / %.1f%%) | rejects %d/%d | rate %.2f/min (overall %.2f/min)", intervalDur.Round(time.Second), totalDur.Round(time.Second), deltaOK, totalOK, accInterval, accTotal, deltaBad, totalBad, rateInterval, rateTotal)
+			log.Printf("Periodic Report interval=%10s total=%10s | submitted %d/%d (acc %.1f%% / %.1f%%) | rejects %d/%d%s | rate %.2f/min (overall %.2f/min)", intervalDur.Round(time.Second), totalDur.Round(time.Second), deltaOK, totalOK, accInterval, accTotal, deltaBad, totalBad, formatRejectReasons(p.mx.GetRejectReasons()), rateInterval, rateTotal)
+			p.logGroups()
 			last = now
 			lastOK = totalOK
 			lastBad = totalBad
@@ -691,8 +1797,31 @@ func (p *Proxy) ReportLoop(ctx context.Context, interval time.Duration) {
 	}
 }
 
-// UpstreamManager manages upstream connection based on client activity
-func (p *Proxy) UpstreamManager(ctx context.Context, idleGrace time.Duration) {
+// formatRejectReasons renders non-zero reject categories for the periodic report
+func formatRejectReasons(reasons map[string]uint64) string {
+	var parts []string
+	for _, cat := range stratum.RejectCategories {
+		if n := reasons[cat]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", cat, n))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, " ") + ")"
+}
+
+// idleGrace returns how long the upstream outlives the last client
+func (c *Config) idleGrace() time.Duration {
+	if c.Proxy.IdleGraceMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.Proxy.IdleGraceMs) * time.Millisecond
+}
+
+// UpstreamManager manages upstream connection based on client activity, or
+// keeps it up with proxy.keep_upstream
+func (p *Proxy) UpstreamManager(ctx context.Context) {
 	var upCancel context.CancelFunc
 	var upCtx context.Context
 	upstreamRunning := false
@@ -720,12 +1849,13 @@ func (p *Proxy) UpstreamManager(ctx context.Context, idleGrace time.Duration) {
 					upCancel()
 				}
 				upstreamRunning = false
+				p.upIdle.Store(true)
 			}
 			graceTimer = nil
 			graceTimerCh = nil
 
 		case <-ticker.C:
-			hasClients := p.mx.ClientsActive.Load() > 0
+			hasClients := p.mx.ClientsActive.Load() > 0 || p.config().Proxy.KeepUpstream
 
 			if hasClients && !upstreamRunning {
 				// Cancel any pending grace period
@@ -735,13 +1865,18 @@ func (p *Proxy) UpstreamManager(ctx context.Context, idleGrace time.Duration) {
 					graceTimerCh = nil
 				}
 				// Start upstream
+				p.upIdle.Store(false)
 				upCtx, upCancel = context.WithCancel(ctx)
-				go p.UpstreamLoop(upCtx)
+				if p.solo != nil {
+					go p.SoloLoop(upCtx)
+				} else {
+					go p.UpstreamLoop(upCtx)
+				}
 				upstreamRunning = true
 
 			} else if !hasClients && upstreamRunning && graceTimer == nil {
 				// Start grace period timer (only if not already started)
-				graceTimer = time.NewTimer(idleGrace)
+				graceTimer = time.NewTimer(p.config().idleGrace())
 				graceTimerCh = graceTimer.C
 
 			} else if hasClients && graceTimer != nil {
@@ -754,6 +1889,112 @@ func (p *Proxy) UpstreamManager(ctx context.Context, idleGrace time.Duration) {
 	}
 }
 
+// onShare receives every submit outcome from the router
+func (p *Proxy) onShare(ev routing.ShareEvent) {
+	if p.solo != nil || p.ag != nil {
+		ev.Difficulty = p.shareDifficulty(ev.Client)
+	}
+	p.feeResult(ev)
+	if p.mir != nil {
+		p.mir.primary(ev.Accepted)
+	}
+	if !ev.Accepted {
+		if cl, ok := ev.Client.(*Client); ok {
+			cl.recordReject(ev.Category)
+		}
+	}
+	p.recordHashrate(ev)
+	p.scoreShare(ev)
+	p.jobs.result(ev.JobID, ev.Accepted)
+	p.hist.AddShare(ev.Time, ev.Accepted, ev.Difficulty)
+	p.recordGroups(ev)
+	p.ev.ObserveShare(ev.Accepted)
+	p.throttleResult(ev)
+	if cl, ok := ev.Client.(*Client); ok {
+		p.vd.RecordShare(cl, ev.Accepted, ev.Difficulty)
+	}
+	result := "rejected"
+	if ev.Accepted {
+		result = "accepted"
+	}
+	if err := p.jr.Record(journal.Entry{
+		Time:       ev.Time,
+		Worker:     ev.Worker,
+		Session:    routing.SessionOf(ev.Client),
+		JobID:      ev.JobID,
+		Difficulty: ev.Difficulty,
+		Result:     result,
+		LatencyMs:  ev.Latency.Milliseconds(),
+		Reason:     ev.Reason,
+	}); err != nil {
+		log.Printf("journal: %v", err)
+	}
+}
+
+// CanaryLoop runs the synthetic end-to-end miner against our own listener
+func (p *Proxy) CanaryLoop(ctx context.Context) {
+	if p.cn == nil {
+		return
+	}
+	p.cn.Run(ctx)
+}
+
+// loopbackAddr turns a listen address into one the canary can dial locally
+func loopbackAddr(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// AvailabilityLoop samples upstream, listener and share health for the
+// daily availability report
+func (p *Proxy) AvailabilityLoop(ctx context.Context) {
+	p.av.Run(ctx, p.availabilitySample)
+}
+
+// availabilitySample reads the current state for the availability tracker.
+// The upstream counts as up while it is closed on purpose for lack of
+// clients, so quiet hours are not recorded as outages.
+func (p *Proxy) availabilitySample() availability.Sample {
+	return availability.Sample{
+		UpstreamUp: p.mx.UpConnected.Load() || p.upIdle.Load(),
+		ListenerUp: p.listening.Load(),
+		SharesOK:   p.mx.SharesOK.Load(),
+		SharesBad:  p.mx.SharesBad.Load(),
+	}
+}
+
+// WatchdogLoop calls beat every interval while the proxy is responsive. Each
+// beat first takes the client lock of the proxy and of its profiles, so a
+// deadlock stops the beats and lets the supervisor restart karoo.
+func (p *Proxy) WatchdogLoop(ctx context.Context, interval time.Duration, beat func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, px := range append([]*Proxy{p}, p.profileList()...) {
+				// empty on purpose: only checks the lock can be taken
+				px.clMu.Lock()
+				px.clMu.Unlock()
+			}
+			beat()
+		}
+	}
+}
+
+// PendingLoop times out upstream requests left unanswered
+func (p *Proxy) PendingLoop(ctx context.Context) {
+	p.rt.PendingLoop(ctx)
+}
+
 // VarDiffLoop starts variable difficulty adjustment
 func (p *Proxy) VarDiffLoop(ctx context.Context) {
 	p.vd.Run(ctx)
@@ -1,13 +1,39 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/events"
 	"github.com/carlosrabelo/karoo/core/internal/proxysocks"
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/sessions"
+	"github.com/carlosrabelo/karoo/core/internal/sim"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
 )
 
@@ -43,12 +69,17 @@ func TestNewProxy(t *testing.T) {
 func TestNewClient(t *testing.T) {
 	cfg := &Config{
 		Proxy: struct {
-			Listen       string `json:"listen"`
-			ClientIdleMs int    `json:"client_idle_ms"`
-			MaxClients   int    `json:"max_clients"`
-			ReadBuf      int    `json:"read_buf"`
-			WriteBuf     int    `json:"write_buf"`
-			TLS          struct {
+			Listen            string `json:"listen"`
+			ClientIdleMs      int    `json:"client_idle_ms"`
+			MaxClients        int    `json:"max_clients"`
+			ReadBuf           int    `json:"read_buf"`
+			WriteBuf          int    `json:"write_buf"`
+			IdleGraceMs       int    `json:"idle_grace_ms"`
+			MaxLineBytes      int    `json:"max_line_bytes"`
+			MaxInvalidLines   int    `json:"max_invalid_lines"`
+			InvalidBanSeconds int    `json:"invalid_ban_seconds"`
+			KeepUpstream      bool   `json:"keep_upstream"`
+			TLS               struct {
 				Enabled bool   `json:"enabled"`
 				Cert    string `json:"cert_file"`
 				Key     string `json:"key_file"`
@@ -64,24 +95,7 @@ func TestNewClient(t *testing.T) {
 				Enabled: false,
 			},
 		},
-		Upstream: struct {
-			Host               string `json:"host"`
-			Port               int    `json:"port"`
-			User               string `json:"user"`
-			Pass               string `json:"pass"`
-			TLS                bool   `json:"tls"`
-			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
-			BackoffMinMs       int    `json:"backoff_min_ms"`
-			BackoffMaxMs       int    `json:"backoff_max_ms"`
-			SocksProxy         struct {
-				Enabled  bool   `json:"enabled"`
-				Type     string `json:"type"`
-				Host     string `json:"host"`
-				Port     int    `json:"port"`
-				Username string `json:"username"`
-				Password string `json:"password"`
-			} `json:"socks_proxy"`
-		}{
+		Upstream: UpstreamConfig{
 			User: "testuser",
 			Pass: "testpass",
 			SocksProxy: struct {
@@ -215,12 +229,17 @@ func TestUpstreamIsConnected(t *testing.T) {
 func TestClientWriteOperations(t *testing.T) {
 	cfg := &Config{
 		Proxy: struct {
-			Listen       string `json:"listen"`
-			ClientIdleMs int    `json:"client_idle_ms"`
-			MaxClients   int    `json:"max_clients"`
-			ReadBuf      int    `json:"read_buf"`
-			WriteBuf     int    `json:"write_buf"`
-			TLS          struct {
+			Listen            string `json:"listen"`
+			ClientIdleMs      int    `json:"client_idle_ms"`
+			MaxClients        int    `json:"max_clients"`
+			ReadBuf           int    `json:"read_buf"`
+			WriteBuf          int    `json:"write_buf"`
+			IdleGraceMs       int    `json:"idle_grace_ms"`
+			MaxLineBytes      int    `json:"max_line_bytes"`
+			MaxInvalidLines   int    `json:"max_invalid_lines"`
+			InvalidBanSeconds int    `json:"invalid_ban_seconds"`
+			KeepUpstream      bool   `json:"keep_upstream"`
+			TLS               struct {
 				Enabled bool   `json:"enabled"`
 				Cert    string `json:"cert_file"`
 				Key     string `json:"key_file"`
@@ -305,8 +324,8 @@ func TestBackoff(t *testing.T) {
 	}
 }
 
-// Tests for diffFromBits and fmtDuration have been moved to:
-// - core/internal/routing/routing_test.go (where these functions now reside)
+// Tests for fmtDuration have been moved to:
+// - core/internal/routing/routing_test.go (where this function now resides)
 
 func TestProxyMetricsIntegration(t *testing.T) {
 	cfg := &Config{}
@@ -341,13 +360,7 @@ func TestProxyMetricsIntegration(t *testing.T) {
 
 func TestVarDiffLoop(t *testing.T) {
 	cfg := &Config{
-		VarDiff: struct {
-			Enabled       bool `json:"enabled"`
-			TargetSeconds int  `json:"target_seconds"`
-			MinDiff       int  `json:"min_diff"`
-			MaxDiff       int  `json:"max_diff"`
-			AdjustEveryMs int  `json:"adjust_every_ms"`
-		}{
+		VarDiff: VarDiffConfig{
 			Enabled:       false,
 			AdjustEveryMs: 1000,
 		},
@@ -393,5 +406,1846 @@ func TestUpstreamManager(t *testing.T) {
 	defer cancel()
 
 	// Should not panic even without real upstream loop
-	p.UpstreamManager(ctx, 30*time.Second)
+	p.UpstreamManager(ctx)
+}
+
+func TestAvailabilityIdleUpstream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close() // nothing answers: a wanted upstream stays down
+
+	cfg := &Config{}
+	cfg.Proxy.IdleGraceMs = 50
+	cfg.Upstream = UpstreamConfig{Host: "127.0.0.1", Port: port, User: "farm", BackoffMinMs: 10, BackoffMaxMs: 20}
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.UpstreamManager(ctx)
+
+	waitUp := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for p.availabilitySample().UpstreamUp != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("upstream up = %v, want %v", !want, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// no clients: the upstream is closed on purpose, not an outage
+	time.Sleep(300 * time.Millisecond)
+	waitUp(true)
+
+	// a client wants the upstream, which cannot connect
+	p.mx.ClientsActive.Add(1)
+	waitUp(false)
+
+	// the last client left and the idle grace expired
+	p.mx.ClientsActive.Add(-1)
+	waitUp(true)
+}
+
+// idleUpstreamProxy runs the upstream manager of a proxy against a fake pool
+func idleUpstreamProxy(t *testing.T, ctx context.Context, keep bool, graceMs int) *Proxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := sim.New(&sim.Config{})
+	go func() { _ = pool.Serve(ctx, ln) }()
+
+	cfg := &Config{}
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	cfg.Proxy.KeepUpstream = keep
+	cfg.Proxy.IdleGraceMs = graceMs
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg.Upstream = UpstreamConfig{Host: "127.0.0.1", Port: port, User: "farm", BackoffMinMs: 10, BackoffMaxMs: 20}
+	p := NewProxy(cfg)
+	go p.UpstreamManager(ctx)
+	return p
+}
+
+// waitConnected waits for the upstream connection to be up or down
+func waitConnected(t *testing.T, p *Proxy, want bool, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for p.mx.UpConnected.Load() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("upstream connected = %v after %s, want %v", !want, within, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeepUpstream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := idleUpstreamProxy(t, ctx, true, 50)
+
+	// connected at start without any client
+	waitConnected(t, p, true, 2*time.Second)
+
+	// and still connected well past the idle grace after the last client left
+	p.mx.ClientsActive.Add(1)
+	time.Sleep(300 * time.Millisecond)
+	p.mx.ClientsActive.Add(-1)
+	time.Sleep(600 * time.Millisecond)
+	if !p.mx.UpConnected.Load() || p.upIdle.Load() {
+		t.Error("keep_upstream upstream closed after the last client left")
+	}
+}
+
+func TestIdleGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := idleUpstreamProxy(t, ctx, false, 300)
+
+	// no client, no upstream
+	time.Sleep(400 * time.Millisecond)
+	if p.mx.UpConnected.Load() {
+		t.Fatal("upstream connected without clients")
+	}
+
+	p.mx.ClientsActive.Add(1)
+	waitConnected(t, p, true, 2*time.Second)
+
+	// the upstream outlives the last client by idle_grace_ms, then closes
+	left := time.Now()
+	p.mx.ClientsActive.Add(-1)
+	waitConnected(t, p, false, 2*time.Second)
+	if d := time.Since(left); d < 300*time.Millisecond {
+		t.Errorf("upstream closed %s after the last client left, before the 300ms grace", d)
+	}
+	if !p.upIdle.Load() {
+		t.Error("upstream closed by the grace timer not marked idle")
+	}
+}
+
+func TestAdminAuthAndIdentity(t *testing.T) {
+	cfg := &Config{}
+	cfg.Identity.ServerHeader = "nginx"
+	cfg.Identity.HideVersion = true
+	p := NewProxy(cfg)
+
+	mux := http.NewServeMux()
+	p.registerIdentityHandlers(mux)
+	srv := httptest.NewServer(p.withServerHeader(mux))
+	defer srv.Close()
+
+	get := func(path, token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("/version", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("hidden /version status = %d, want 404", resp.StatusCode)
+	}
+	if resp := get("/admin/version", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("admin without token configured = %d, want 404", resp.StatusCode)
+	}
+
+	cfg.Admin.Token = "s3cret"
+	if resp := get("/admin/version", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("admin with bad token = %d, want 401", resp.StatusCode)
+	}
+	resp := get("/admin/version", "s3cret")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("admin with token = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Server"); got != "nginx" {
+		t.Errorf("Server header = %q, want nginx", got)
+	}
+}
+
+func TestSNIRouting(t *testing.T) {
+	cfg := &Config{}
+	cfg.Upstream.Host = "main.pool"
+	cfg.Profiles = map[string]ProfileConfig{
+		"alt": {Upstream: UpstreamConfig{Host: "alt.pool", Port: 3333}},
+	}
+	cfg.SNIRoutes = []SNIRoute{
+		{ServerName: "alt.example.com", Profile: "alt"},
+		{ServerName: "*.farm.example.com", Profile: "alt"},
+		{ServerName: "main.example.com"},
+	}
+	p := NewProxy(cfg)
+
+	sub, ok := p.profiles["alt"]
+	if !ok {
+		t.Fatal("profile alt not created")
+	}
+	if sub.config().Upstream.Host != "alt.pool" || len(sub.config().SNIRoutes) != 0 {
+		t.Errorf("profile config not derived: %+v", sub.config().Upstream)
+	}
+	if sub.rl != p.rl {
+		t.Error("profile should share the main rate limiter")
+	}
+
+	cases := map[string]string{
+		"alt.example.com":       "alt",
+		"ALT.example.com":       "alt",
+		"rig1.farm.example.com": "alt",
+		"a.b.farm.example.com":  "-",
+		"main.example.com":      "",
+		"other.example.com":     "-",
+		"":                      "-",
+	}
+	for name, want := range cases {
+		r := p.routeFor(name)
+		got := "-"
+		if r != nil {
+			got = r.Profile
+		}
+		if got != want {
+			t.Errorf("routeFor(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAuditLabel(t *testing.T) {
+	cases := map[[2]string]string{
+		{"client", "mining.submit"}:   "client mining.submit",
+		{"upstream", "mining.notify"}: "upstream mining.notify",
+		{"upstream", ""}:              "upstream response",
+		{"client", "mining.bogus"}:    "client other",
+	}
+	for in, want := range cases {
+		if got := auditLabel(in[0], in[1]); got != want {
+			t.Errorf("auditLabel(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
+
+func TestHashMeter(t *testing.T) {
+	m := newHashMeter(10 * time.Minute)
+	now := time.Now()
+	m.add(now.Add(-time.Minute), 600)
+	m.add(now, 600)
+	m.add(now.Add(-20*time.Minute), 1e9) // outside the window
+
+	want := 1200 * 4294967296 / 600.0
+	if got := m.rate(now); got != want {
+		t.Errorf("rate = %v, want %v", got, want)
+	}
+}
+
+func TestPublicStatsPrivacy(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	cl := NewClient(server, cfg)
+	cl.SetWorker("alice.rig1")
+	p.clients[cl] = struct{}{}
+	p.mx.SharesOK.Store(3)
+	p.mx.SharesBad.Store(1)
+
+	rec := httptest.NewRecorder()
+	p.handlePublic(rec, httptest.NewRequest(http.MethodGet, "/public", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("disabled /public status = %d, want 404", rec.Code)
+	}
+
+	cfg.Public.Enabled = true
+	rec = httptest.NewRecorder()
+	p.handlePublic(rec, httptest.NewRequest(http.MethodGet, "/public", nil))
+	body := rec.Body.String()
+	if strings.Contains(body, "alice") || strings.Contains(body, "pipe") {
+		t.Errorf("/public leaked client details: %s", body)
+	}
+	var st PublicStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if st.Workers != 1 || st.AcceptancePercent != 75 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestDuplicateWorkerPolicies(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+
+	newClient := func(addr string) *Client {
+		server, client := net.Pipe()
+		t.Cleanup(func() { server.Close(); client.Close() })
+		go func() { _, _ = io.Copy(io.Discard, client) }()
+		cl := NewClient(server, cfg)
+		cl.addr = addr
+		p.clients[cl] = struct{}{}
+		return cl
+	}
+	authorize := func(worker string) *stratum.Message {
+		return &stratum.Message{ID: stratum.NewID(2), Method: "mining.authorize", Params: []interface{}{worker, "x"}}
+	}
+
+	first := newClient("10.0.0.1:4000")
+	first.SetWorker("rig1")
+	sameHost := newClient("10.0.0.1:4001")
+	clone := newClient("10.0.0.2:4000")
+
+	for _, policy := range []string{DuplicateAllow, DuplicateWarn} {
+		cfg.Duplicates.Policy = policy
+		if p.checkDuplicate(clone, authorize("rig1")) {
+			t.Errorf("policy %s should keep the connection", policy)
+		}
+	}
+	if len(p.dup.list()) != 1 {
+		t.Errorf("expected one recorded event, got %d", len(p.dup.list()))
+	}
+
+	cfg.Duplicates.Policy = DuplicateReject
+	if p.checkDuplicate(sameHost, authorize("rig1")) {
+		t.Error("same address should not count as a duplicate")
+	}
+	if !p.checkDuplicate(clone, authorize("rig1")) {
+		t.Error("reject policy should drop the clone")
+	}
+
+	cfg.Duplicates.Policy = DuplicateRename
+	msg := authorize("rig1")
+	if p.checkDuplicate(clone, msg) {
+		t.Error("rename policy should keep the connection")
+	}
+	if got := msg.Params.([]interface{})[0]; got != "rig1_2" {
+		t.Errorf("renamed worker = %v, want rig1_2", got)
+	}
+}
+
+func TestListenerProfiles(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 10
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	cfg.VarDiff = VarDiffConfig{Enabled: true, TargetSeconds: 15, MinDiff: 1, MaxDiff: 1000000, AdjustEveryMs: 60000}
+	cfg.Profiles = map[string]ProfileConfig{
+		"alt": {Upstream: UpstreamConfig{Host: "alt.pool", Port: 3333}},
+	}
+	cfg.Listeners = []ListenerConfig{{Name: "asic", Profile: "alt", StartDifficulty: 4096, MaxClients: 1}}
+	p := NewProxy(cfg)
+
+	if len(p.listeners) != 1 || p.listeners[0].target != p.profiles["alt"] {
+		t.Fatal("listener not bound to its profile")
+	}
+	if len(p.profiles["alt"].listeners) != 0 {
+		t.Error("profiles should not open listeners of their own")
+	}
+	l := p.listeners[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.serveListener(ctx, l, ln)
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	// the starting difficulty is held until the client authorizes
+	alt := p.profiles["alt"]
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		alt.clMu.RLock()
+		n := len(alt.clients)
+		alt.clMu.RUnlock()
+		if n == 1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	alt.clMu.RLock()
+	for cl := range alt.clients {
+		cl.SetHandshakeDone(true)
+	}
+	alt.clMu.RUnlock()
+	_ = first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(first).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading initial difficulty: %v", err)
+	}
+	var msg stratum.Message
+	if err := json.Unmarshal([]byte(line), &msg); err != nil || msg.Method != "mining.set_difficulty" ||
+		msg.Params.([]interface{})[0] != 4096.0 {
+		t.Errorf("expected starting difficulty 4096, got %q", line)
+	}
+	if l.active.Load() != 1 {
+		t.Errorf("listener clients = %d, want 1", l.active.Load())
+	}
+
+	// The listener is full; the next client is closed without a difficulty
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the second client to be closed, got %v", err)
+	}
+
+	_ = first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.active.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l.active.Load() != 0 {
+		t.Error("listener client count not released on disconnect")
+	}
+}
+
+func TestIdleWorkers(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	cl := NewClient(server, cfg)
+	cl.SetWorker("rig1")
+	pending := NewClient(server, cfg) // not authorized yet
+	p.clients[cl] = struct{}{}
+	p.clients[pending] = struct{}{}
+
+	if got := p.idleWorkers(); len(got) != 0 {
+		t.Errorf("workers before handshake should be skipped: %+v", got)
+	}
+
+	cl.handshakeDone.Store(true)
+	got := p.idleWorkers()
+	if len(got) != 1 || got[0].Name != "rig1" || !got[0].LastShare.Equal(cl.connected) {
+		t.Fatalf("expected rig1 measured from its connection time, got %+v", got)
+	}
+
+	share := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	cl.UpdateLastAccept(share.UnixMilli())
+	if got := p.idleWorkers(); !got[0].LastShare.Equal(share) {
+		t.Errorf("last share = %v, want %v", got[0].LastShare, share)
+	}
+}
+
+func TestBanEventWebhook(t *testing.T) {
+	got := make(chan events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev events.Event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		got <- ev
+	}))
+	defer srv.Close()
+
+	cfg := &Config{}
+	cfg.Events.Webhooks = []events.WebhookConfig{{URL: srv.URL, Events: []string{events.BanIssued}}}
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.EventsLoop(ctx)
+
+	if _, err := p.rl.Ban("198.51.100.7", "abuse", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-got:
+		if ev.Type != events.BanIssued || ev.Data["ip"] != "198.51.100.7/32" || ev.Data["source"] != "admin" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("ban event not delivered")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.Listen = "0.0.0.0:3333"
+	cfg.HTTP.Listen = "127.0.0.1:3333"
+	cfg.Upstream = UpstreamConfig{Host: "127.0.0.1", Port: 3333, Pass: "s3cret", BackoffMinMs: 1000, BackoffMaxMs: 500}
+	cfg.Backups = []UpstreamConfig{{Host: "nohost.invalid", Port: 4444}, {Host: "pool.onion", Port: 3333}, {Host: "pool.onion", Port: 3333}}
+	cfg.Backups[1].SocksProxy.Enabled = true
+	cfg.Backups[1].SocksProxy.Type = "socks5"
+	cfg.Backups[1].SocksProxy.Host = "127.0.0.1"
+	cfg.Backups[1].SocksProxy.Port = 9050
+	cfg.Listeners = []ListenerConfig{{Listen: "bad-address"}}
+	cfg.Listeners = append(cfg.Listeners, ListenerConfig{Listen: ":3334"})
+	cfg.Listeners[1].TLS.Enabled = true
+	cfg.Listeners[1].TLS.Cert = "/nonexistent/cert.pem"
+	cfg.Listeners[1].TLS.Key = "/nonexistent/key.pem"
+	cfg.VarDiff.StateFile = "/nonexistent/vardiff.json"
+
+	offline := &net.Resolver{PreferGo: true, Dial: func(context.Context, string, string) (net.Conn, error) {
+		return nil, io.EOF
+	}}
+	got := map[string]bool{}
+	for _, is := range CheckConfig(context.Background(), cfg, offline) {
+		got[is.Field] = is.Warning
+	}
+	want := map[string]bool{
+		"http.listen":            false, // same port as proxy.listen
+		"listeners[0].listen":    false,
+		"listeners[1].tls":       false,
+		"vardiff.state_file":     true,
+		"upstream":               false, // backoff range
+		"backups[0].host":        false,
+		"backups[2].socks_proxy": false, // .onion without a proxy
+	}
+	for field, warning := range want {
+		if w, ok := got[field]; !ok || w != warning {
+			t.Errorf("%s: reported=%v warning=%v, want warning=%v", field, ok, w, warning)
+		}
+	}
+	if _, ok := got["upstream.host"]; ok {
+		t.Error("an IP upstream host should not need DNS")
+	}
+	if _, ok := got["backups[1].host"]; ok {
+		t.Error("a host behind a socks5 proxy should not need local DNS")
+	}
+
+	res := RunConfigCheck(context.Background(), cfg, nil)
+	if res.Valid {
+		t.Error("config with errors reported valid")
+	}
+	up := res.Config.(map[string]interface{})["upstream"].(map[string]interface{})
+	if up["pass"] != "***" || up["host"] != "127.0.0.1" {
+		t.Errorf("effective config not redacted: %v", up)
+	}
+}
+
+func TestClientWriteQueue(t *testing.T) {
+	cfg := &Config{}
+	cfg.ClientQueue = ClientQueueConfig{Size: 2, WriteTimeoutMs: 60000}
+	p := NewProxy(cfg)
+
+	// A stuck client never reads; a healthy one does
+	stuckSrv, stuckCli := net.Pipe()
+	defer stuckCli.Close()
+	stuck := NewClient(stuckSrv, cfg)
+	stuck.startWriter(cfg.ClientQueue, p.mx)
+	fastSrv, fastCli := net.Pipe()
+	defer fastCli.Close()
+	fast := NewClient(fastSrv, cfg)
+	fast.startWriter(cfg.ClientQueue, p.mx)
+	defer fast.Close()
+	for _, cl := range []*Client{stuck, fast} {
+		cl.subscribedOK.Store(true)
+		cl.SetHandshakeDone(true)
+	}
+	p.rt.AddClient(stuck)
+	p.rt.AddClient(fast)
+
+	fastLines := bufio.NewReader(fastCli)
+	for i := 0; i < 5; i++ {
+		p.rt.Broadcast(`{"method":"mining.notify"}`)
+		_ = fastCli.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := fastLines.ReadString('\n'); err != nil {
+			t.Fatalf("broadcast %d held up by the stuck client: %v", i, err)
+		}
+	}
+
+	if err := stuck.WriteLine("x"); err != errClientClosed {
+		t.Errorf("stuck client should have been dropped, got %v", err)
+	}
+	if p.mx.ClientQueueOverflows.Load() != 1 {
+		t.Errorf("overflows = %d, want 1", p.mx.ClientQueueOverflows.Load())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for p.mx.ClientQueued.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.mx.ClientQueued.Load(); n != 0 {
+		t.Errorf("queued lines after drop = %d, want 0", n)
+	}
+}
+
+func TestPreAuthHold(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	cl := NewClient(srv, cfg)
+	cl.startWriter(cfg.ClientQueue, p.mx)
+	defer cl.Close()
+
+	// work before authorize is held, only the latest of each kind
+	_ = cl.WriteJSON(stratum.Message{Method: stratum.MethodSetDifficulty, Params: []any{512}})
+	_ = cl.WriteLine(`{"id":null,"method":"mining.notify","params":["old"]}`)
+	_ = cl.WriteJSON(stratum.Message{Method: stratum.MethodSetDifficulty, Params: []any{1024}})
+	_ = cl.WriteLine(`{"id":null,"method":"mining.notify","params":["new"]}`)
+	_ = cl.WriteJSON(stratum.NewSuccessResponse(stratum.NewID(2), true))
+	cl.SetHandshakeDone(true)
+	_ = cl.WriteLine(`{"id":null,"method":"mining.notify","params":["next"]}`)
+
+	rd := bufio.NewReader(cli)
+	want := []string{`"result":true`, `[1024]`, `["new"]`, `["next"]`}
+	for _, w := range want {
+		_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", w, err)
+		}
+		if !strings.Contains(line, w) {
+			t.Errorf("got %q, want a line with %s", line, w)
+		}
+	}
+}
+
+func TestClientLineLimits(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 10
+	cfg.Proxy.ReadBuf = 64
+	cfg.Proxy.WriteBuf = 4096
+	cfg.Proxy.MaxLineBytes = 256
+	cfg.Proxy.MaxInvalidLines = 2
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// an oversized line drops the client; the error answer is best-effort
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	p.admit(ctx, srv, nil)
+	go func() { _, _ = cli.Write([]byte(`{"id":1,"method":"` + strings.Repeat("a", 300) + "\"}\n")) }()
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(cli); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("client not dropped after an oversized line")
+	}
+	if n := p.mx.ClientOversizedLines.Load(); n != 1 {
+		t.Errorf("oversized lines = %d, want 1", n)
+	}
+
+	// garbage is counted and drops the client at max_invalid_lines
+	srv, cli = net.Pipe()
+	defer cli.Close()
+	p.admit(ctx, srv, nil)
+	go func() { _, _ = cli.Write([]byte("garbage\n{not json\n")) }()
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(cli); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("client not dropped after invalid lines: %v", err)
+	}
+	if n := p.mx.ClientInvalidLines.Load(); n != 2 {
+		t.Errorf("invalid lines = %d, want 2", n)
+	}
+
+	// with invalid_ban_seconds the address is banned as well
+	cfg.Proxy.InvalidBanSeconds = 60
+	srv, cli = net.Pipe()
+	defer cli.Close()
+	p.admit(ctx, tcpPipe{srv}, nil)
+	go func() { _, _ = cli.Write([]byte("garbage\n{not json\n")) }()
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = io.ReadAll(cli)
+	bans := p.rl.Bans()
+	if len(bans) != 1 || bans[0].Source != ratelimit.SourceInput {
+		t.Errorf("bans = %+v, want one from invalid input", bans)
+	}
+}
+
+// tcpPipe is a pipe end that reports a TCP peer address
+type tcpPipe struct{ net.Conn }
+
+func (tcpPipe) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000} }
+
+// discardConn is a connection that accepts and drops every write
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error)       { return len(b), nil }
+func (discardConn) Close() error                      { return nil }
+func (discardConn) RemoteAddr() net.Addr              { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (discardConn) SetWriteDeadline(time.Time) error  { return nil }
+func (discardConn) Read(b []byte) (int, error)        { return 0, io.EOF }
+func (discardConn) SetReadDeadline(t time.Time) error { return nil }
+
+func BenchmarkBroadcastNotify(b *testing.B) {
+	cfg := &Config{}
+	cfg.ClientQueue.Size = 1 << 16
+	p := NewProxy(cfg)
+	for i := 0; i < 10000; i++ {
+		cl := NewClient(discardConn{}, cfg)
+		cl.startWriter(cfg.ClientQueue, p.mx)
+		cl.subscribedOK.Store(true)
+		cl.SetHandshakeDone(true)
+		defer cl.Close()
+		p.rt.AddClient(cl)
+	}
+	line := []byte(`{"id":null,"method":"mining.notify","params":["1a2b","00000000000000000000000000000000000000000000000000000000000000000","01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff","ffffffff",[],"20000000","1d00ffff","495fab29",false]}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.rt.ProcessUpstreamLine(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWorkerPins(t *testing.T) {
+	cfg := WorkerPinConfig{Enabled: true, TTLSeconds: 60, IPv4Prefix: 24}
+	s := newPinStore()
+	now := time.Unix(1000, 0)
+
+	if _, ok := s.check(cfg, "rig1", net.ParseIP("10.0.0.5"), now); !ok {
+		t.Fatal("first authorization refused")
+	}
+	if _, ok := s.check(cfg, "rig1", net.ParseIP("10.0.0.9"), now.Add(time.Second)); !ok {
+		t.Error("authorization from the pinned /24 refused")
+	}
+	pin, ok := s.check(cfg, "rig1", net.ParseIP("10.0.1.5"), now.Add(2*time.Second))
+	if ok || pin.Network != "10.0.0.0/24" {
+		t.Errorf("other network: pin %+v, ok %v; want refused by 10.0.0.0/24", pin, ok)
+	}
+
+	// the pin lasts ttl_seconds after the last authorization from its network
+	if _, ok := s.check(cfg, "rig1", net.ParseIP("10.0.1.5"), now.Add(60*time.Second)); ok {
+		t.Error("pin expired while refreshed")
+	}
+	if pin, ok := s.check(cfg, "rig1", net.ParseIP("10.0.1.5"), now.Add(62*time.Second)); !ok || pin.Network != "10.0.1.0/24" {
+		t.Errorf("expired pin: %+v, %v; want repinned to the new network", pin, ok)
+	}
+
+	if st := s.stats(now.Add(62 * time.Second)); st["pinned"] != 1 || st["rejected"] != uint64(2) {
+		t.Errorf("stats = %v", st)
+	}
+	if !s.remove("rig1") || s.remove("rig1") {
+		t.Error("remove should lift the pin once")
+	}
+}
+
+func TestACMEConfig(t *testing.T) {
+	if err := (ACMEConfig{}).Validate(); err != nil {
+		t.Errorf("disabled config rejected: %v", err)
+	}
+	for _, c := range []ACMEConfig{
+		{Enabled: true},
+		{Enabled: true, Hosts: []string{"pool.example.com:443"}},
+		{Enabled: true, Hosts: []string{"*.example.com"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+	if err := (ACMEConfig{Enabled: true, Hosts: []string{"pool.example.com"}}).Validate(); err != nil {
+		t.Errorf("valid config rejected: %v", err)
+	}
+	if newACMEManager(ACMEConfig{}) != nil {
+		t.Error("manager created while disabled")
+	}
+
+	// listeners without key files take their certificates from ACME
+	cfg := &Config{}
+	cfg.Proxy.Listen = ":3333"
+	cfg.Listeners = []ListenerConfig{{Listen: ":3334"}}
+	cfg.Listeners[0].TLS.Enabled = true
+	cfg.ACME = ACMEConfig{Enabled: true, Hosts: []string{"pool.example.com"}, ChallengeListen: ":3333"}
+	got := map[string]bool{}
+	for _, is := range CheckConfig(context.Background(), cfg, nil) {
+		got[is.Field] = true
+	}
+	if got["listeners[0].tls"] {
+		t.Error("ACME listener reported missing key files")
+	}
+	if !got["acme.challenge_listen"] {
+		t.Error("challenge port clash with proxy.listen not reported")
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	cfg := &Config{}
+	cfg.HTTP.Auth = HTTPAuthConfig{Username: "ops", Password: "pw", Token: "tok"}
+	cfg.Admin.Token = "adm"
+	p := &Proxy{}
+	p.cfg.Store(cfg)
+	h := p.withHTTPAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		path string
+		set  func(r *http.Request)
+		want int
+	}{
+		{"/status", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"/healthz", func(r *http.Request) {}, http.StatusOK},
+		{"/readyz", func(r *http.Request) {}, http.StatusOK},
+		{"/metrics", func(r *http.Request) { r.SetBasicAuth("ops", "pw") }, http.StatusOK},
+		{"/metrics", func(r *http.Request) { r.SetBasicAuth("ops", "tok") }, http.StatusUnauthorized},
+		{"/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer tok") }, http.StatusOK},
+		{"/status", func(r *http.Request) { r.Header.Set("Authorization", "tok") }, http.StatusUnauthorized},
+		{"/admin/bans", func(r *http.Request) { r.Header.Set("X-Admin-Token", "adm") }, http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		c.set(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %v: got %d, want %d", c.path, req.Header, rec.Code, c.want)
+		}
+	}
+
+	bad := []HTTPConfig{
+		{Auth: HTTPAuthConfig{Username: "ops"}},
+		{Pprof: true, PprofListen: "0.0.0.0:6060"},
+		{PprofListen: "127.0.0.1:6060"},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+	if err := (HTTPConfig{Pprof: true, PprofListen: "[::1]:6060"}).Validate(); err != nil {
+		t.Errorf("loopback pprof rejected: %v", err)
+	}
+}
+
+func TestHTTPPprof(t *testing.T) {
+	cases := []struct {
+		pprof  bool
+		listen string
+		want   int
+	}{
+		{false, "", http.StatusNotFound},
+		{false, "127.0.0.1:18932", http.StatusNotFound},
+		{true, "127.0.0.1:18932", http.StatusNotFound},
+		{true, "", http.StatusOK},
+	}
+	for _, c := range cases {
+		cfg := &Config{}
+		cfg.Proxy.MaxClients = 10
+		cfg.HTTP.Pprof = c.pprof
+		cfg.HTTP.PprofListen = c.listen
+		h := NewProxy(cfg).httpHandler()
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != c.want {
+				t.Errorf("pprof=%v pprof_listen=%q: %s got %d, want %d", c.pprof, c.listen, path, rec.Code, c.want)
+			}
+		}
+	}
+}
+
+func TestNotifyStalled(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+	since := time.Unix(1000, 0)
+	if p.notifyStalled(since, since.Add(time.Hour)) {
+		t.Error("stall reported with the watchdog disabled")
+	}
+
+	cfg.Keepalive = connection.KeepaliveConfig{UpstreamNotifySeconds: 120}
+	if p.notifyStalled(since, since.Add(119*time.Second)) {
+		t.Error("stall reported before the timeout")
+	}
+	if !p.notifyStalled(since, since.Add(120*time.Second)) {
+		t.Error("connection without a notify not reported")
+	}
+	p.mx.SetLastNotify(since.Add(100 * time.Second))
+	if p.notifyStalled(since, since.Add(200*time.Second)) {
+		t.Error("stall reported within the timeout of the last notify")
+	}
+	if !p.notifyStalled(since, since.Add(220*time.Second)) {
+		t.Error("stall after the last notify not reported")
+	}
+	if d := cfg.Keepalive.UpstreamSilence(); d != 120*time.Second {
+		t.Errorf("read deadline = %s, want the notify timeout", d)
+	}
+}
+
+func TestShed(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 4
+	cfg.ClientQueue.Size = 16
+	cfg.Shedding = ShedConfig{
+		Enabled:          true,
+		ClientsHighWater: 0.5,
+		Tiers: []PriorityTier{
+			{Name: "farm", Priority: 10, WorkerPrefixes: []string{"farm."}, Protected: true},
+			{Name: "hobby", Priority: -1, WorkerPrefixes: []string{"hobby."}},
+		},
+	}
+	if err := cfg.Shedding.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if tier := cfg.Shedding.classify("other", ""); tier.Name != "default" || tier.Priority != 0 {
+		t.Errorf("unmatched worker tier = %+v", tier)
+	}
+	p := NewProxy(cfg)
+
+	add := func(worker string, age time.Duration) (*Client, net.Conn) {
+		srv, cli := net.Pipe()
+		t.Cleanup(func() { _ = cli.Close() })
+		cl := NewClient(srv, cfg)
+		cl.startWriter(cfg.ClientQueue, p.mx)
+		cl.SetWorker(worker)
+		cl.connected = time.Now().Add(-age)
+		p.clients[cl] = struct{}{}
+		p.mx.ClientsActive.Add(1)
+		return cl, cli
+	}
+	farm, _ := add("farm.1", time.Hour)
+	other, _ := add("other", time.Hour)
+	oldHobby, _ := add("hobby.1", time.Hour)
+	newHobby, cli := add("hobby.2", time.Minute)
+
+	// the newest client of the lowest tier goes first
+	if n := p.shed(); n != 1 || !newHobby.shed.Load() || oldHobby.shed.Load() {
+		t.Fatalf("first shed = %d (new %v, old %v)", n, newHobby.shed.Load(), oldHobby.shed.Load())
+	}
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, err := bufio.NewReader(cli).ReadString('\n'); err != nil || !strings.Contains(line, methodShowMessage) {
+		t.Errorf("shed client message = %q, %v", line, err)
+	}
+	p.shed()
+	p.shed()
+	if !oldHobby.shed.Load() || !other.shed.Load() {
+		t.Error("unprotected clients not shed in turn")
+	}
+	if n := p.shed(); n != 0 || farm.shed.Load() {
+		t.Error("protected client shed")
+	}
+	if p.mx.ClientsShed.Load() != 3 {
+		t.Errorf("clients_shed = %d, want 3", p.mx.ClientsShed.Load())
+	}
+
+	// below the high water mark nothing is shed
+	p.mx.ClientsActive.Store(1)
+	delete(p.clients, farm)
+	if n := p.shed(); n != 0 {
+		t.Errorf("shed %d below high water", n)
+	}
+}
+
+func TestFee(t *testing.T) {
+	for _, bad := range []FeeConfig{
+		{Enabled: true, Percent: 0, User: "fee"},
+		{Enabled: true, Percent: 2},
+		{Enabled: true, Percent: 2, Mode: FeeModeTimeslice},
+		{Enabled: true, Percent: 2, Mode: "random", User: "fee"},
+	} {
+		if bad.Validate() == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+	slice := FeeConfig{Percent: 10, PeriodSeconds: 100}
+	if slice.inSlice(50*time.Second) || !slice.inSlice(95*time.Second) || slice.inSlice(105*time.Second) {
+		t.Error("fee slice should be the last 10s of every 100s")
+	}
+
+	cfg := &Config{}
+	cfg.Upstream.User = "farm"
+	cfg.Fee = FeeConfig{Enabled: true, Percent: 25, User: "fee"}
+	p := NewProxy(cfg)
+	var users []string
+	// capture stands in for forward and sends every share
+	_ = p.rt.UseClient("capture", func(next routing.ClientHandler) routing.ClientHandler {
+		return func(cl routing.Client, msg stratum.Message) {
+			users = append(users, msg.Params.([]any)[0].(string))
+			p.feeSubmitted(cl, msg, true)
+		}
+	})
+	cl := NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	for i := 0; i < 8; i++ {
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig", "1", "00", "00", "00"}})
+	}
+	fee := 0
+	for _, u := range users {
+		if u == "fee" {
+			fee++
+		} else if u != "farm" {
+			t.Errorf("share submitted as %q", u)
+		}
+	}
+	if len(users) != 8 || fee != 2 {
+		t.Fatalf("%d of %d shares went to the fee account, want 2 of 8", fee, len(users))
+	}
+
+	for _, u := range users {
+		p.feeResult(routing.ShareEvent{Client: cl, User: u, Accepted: true, Difficulty: 4})
+	}
+	p.feeResult(routing.ShareEvent{Client: cl, User: "fee", Accepted: false, Difficulty: 4})
+	st := p.feeStats()
+	if st["effective_percent"] != 25.0 || st["fee_work"] != 8.0 || st["fee_rejected"] != uint64(1) || st["fee_shares"] != uint64(2) {
+		t.Errorf("fee stats = %v", st)
+	}
+
+	// shares refused locally neither fall due nor count as fee rejections
+	cfg = &Config{}
+	cfg.Upstream.User = "farm"
+	cfg.Submit.Dedupe = true
+	cfg.Fee = FeeConfig{Enabled: true, Percent: 50, User: "fee"}
+	p = NewProxy(cfg)
+	cl = NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	for i := 0; i < 2; i++ {
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig", "1", "00000000", "495fab29", "7c2bac1d"}})
+	}
+	if st := p.feeStats(); st["fee_shares"] != uint64(0) || st["fee_rejected"] != uint64(0) {
+		t.Errorf("duplicate counted for the fee: %v", st)
+	}
+
+	// shares that fail to reach the pool do not use up the fee quota: the
+	// second share falls due, fails with the upstream down, and the next
+	// sent share falls due in its place
+	cfg = &Config{}
+	cfg.Upstream.User = "farm"
+	cfg.Fee = FeeConfig{Enabled: true, Percent: 50, User: "fee"}
+	p = NewProxy(cfg)
+	users = nil
+	send := false
+	_ = p.rt.UseClient("capture", func(next routing.ClientHandler) routing.ClientHandler {
+		return func(cl routing.Client, msg stratum.Message) {
+			users = append(users, msg.Params.([]any)[0].(string))
+			if send {
+				p.feeSubmitted(cl, msg, true)
+				return
+			}
+			next(cl, msg)
+		}
+	})
+	cl = NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	for i := 0; i < 4; i++ {
+		send = i%2 == 0
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig", "1", "00", "00", "00"}})
+	}
+	if got := strings.Join(users, ","); got != "farm,fee,fee,farm" {
+		t.Errorf("shares submitted as %s, want farm,fee,fee,farm", got)
+	}
+	if st := p.feeStats(); st["shares"] != uint64(2) || st["fee_shares"] != uint64(1) {
+		t.Errorf("fee stats after failed forwards = %v", st)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	night := ScheduleWindow{Name: "cheap", Days: []string{"fri"}, Start: "22:00", End: "06:00", User: "night.rig"}
+	for _, bad := range []ScheduleConfig{
+		{Windows: []ScheduleWindow{{Name: "a", Start: "25:00", End: "06:00", User: "u"}}},
+		{Windows: []ScheduleWindow{{Name: "a", Days: []string{"fun"}, Start: "01:00", End: "06:00", User: "u"}}},
+		{Windows: []ScheduleWindow{{Name: "a", Start: "01:00", End: "06:00"}}},
+		{Windows: []ScheduleWindow{night, night}},
+		{Timezone: "Mars/Olympus"},
+	} {
+		bad.Enabled = true
+		if bad.Validate() == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+
+	fri := time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC) // a Friday
+	if !night.covers(fri) || !night.covers(fri.Add(4*time.Hour)) || night.covers(fri.Add(24*time.Hour)) || night.covers(fri.Add(-2*time.Hour)) {
+		t.Error("window past midnight should cover Friday night only")
+	}
+	if got := night.apply([]UpstreamConfig{{Host: "a", User: "day"}, {Host: "b", User: "day"}}); got[0].User != "night.rig" || got[1].Host != "b" || got[1].User != "night.rig" {
+		t.Errorf("user window applied as %+v", got)
+	}
+
+	cfg := &Config{}
+	cfg.Schedule = ScheduleConfig{Enabled: true, Timezone: "UTC", Windows: []ScheduleWindow{night}}
+	if err := cfg.Schedule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	p := NewProxy(cfg)
+	if !p.applySchedule(fri) || p.scheduledWindow() == nil || p.applySchedule(fri.Add(time.Hour)) {
+		t.Error("window not opened once")
+	}
+	if !p.applySchedule(fri.Add(12*time.Hour)) || p.scheduledWindow() != nil {
+		t.Error("window not closed")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := sim.New(&sim.Config{})
+	go func() { _ = pool.Serve(ctx, ln) }()
+
+	cfg := &Config{}
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	cfg.Upstream.User = "farm"
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg.Mirror = MirrorConfig{Enabled: true, Upstream: UpstreamConfig{Host: "127.0.0.1", Port: port, User: "trial", Pass: "x", BackoffMinMs: 10, BackoffMaxMs: 50}}
+	p := NewProxy(cfg)
+	go p.MirrorLoop(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !p.mir.up.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the primary is down, so only the mirror gets the share; the fake pool
+	// knows no such job
+	cl := NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	submit := func() {
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: stratum.MethodSubmit, Params: []any{"rig", "nojob", "00000000", "5f000000", "00000000"}})
+	}
+	submit()
+	for time.Now().Before(deadline) {
+		if st := p.mir.GetStats(); st["rejected"] == uint64(1) {
+			if st["submitted"] != uint64(1) || st["rejects"].(map[string]uint64)["stale"] != 1 {
+				t.Errorf("mirror stats = %v", st)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := p.mir.GetStats(); st["rejected"] != uint64(1) {
+		t.Fatalf("mirror answer not accounted: %v", st)
+	}
+	if ex1, _ := p.mir.up.GetExtranonce(); ex1 == "" {
+		t.Fatal("mirror extranonce not tracked")
+	}
+
+	// a share built on another extranonce1 is not sent and gives up the mirror
+	p.up.SetExtranonce("ffffffff", 4)
+	submit()
+	if st := p.mir.GetStats(); st["mismatched"] != uint64(1) || st["submitted"] != uint64(1) || st["disabled"] == "" {
+		t.Errorf("mismatched share mirrored: %v", st)
+	}
+}
+
+func TestMirrorOtherExtranonce(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the fake pool hands out a new extranonce1 per connection
+	pool := sim.New(&sim.Config{})
+	go func() { _ = pool.Serve(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":[]}` + "\n"))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg stratum.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		t.Fatal(err)
+	}
+	info := stratum.ParseExtranonceResult(msg.Result)
+	if !info.Valid {
+		t.Fatalf("subscribe answer %s", line)
+	}
+
+	cfg := &Config{}
+	cfg.Proxy.ReadBuf, cfg.Proxy.WriteBuf = 4096, 4096
+	cfg.Upstream.User = "farm"
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg.Mirror = MirrorConfig{Enabled: true, Upstream: UpstreamConfig{Host: "127.0.0.1", Port: port, User: "trial", Pass: "x", BackoffMinMs: 10, BackoffMaxMs: 50}}
+	p := NewProxy(cfg)
+	p.up.SetExtranonce(info.Extranonce1, info.Extranonce2Size)
+
+	done := make(chan struct{})
+	go func() {
+		p.MirrorLoop(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("mirror kept running on another extranonce1: %v", p.mir.GetStats())
+	}
+	st := p.mir.GetStats()
+	if st["disabled"] == "" || st["connected"] != false {
+		t.Errorf("mirror not disabled: %v", st)
+	}
+
+	cl := NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: stratum.MethodSubmit, Params: []any{"rig", "1", "00000000", "5f000000", "00000000"}})
+	if st := p.mir.GetStats(); st["mismatched"] != uint64(1) || st["submitted"] != uint64(0) {
+		t.Errorf("share mirrored after disabling: %v", st)
+	}
+}
+
+func TestBestShare(t *testing.T) {
+	got := make(chan events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev events.Event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		got <- ev
+	}))
+	defer srv.Close()
+
+	cfg := &Config{}
+	cfg.Events.Webhooks = []events.WebhookConfig{{URL: srv.URL, Events: []string{events.BlockFound}}}
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.EventsLoop(ctx)
+
+	// the genesis block as a job, its coinbase split around the extranonces
+	const coinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+	notify := fmt.Sprintf(`{"id":null,"method":"mining.notify","params":["g","%s","%s","%s",[],"00000001","1d00ffff","495fab29",true]}`,
+		strings.Repeat("0", 64), coinbase[:84], coinbase[100:])
+	if _, err := p.rt.ProcessUpstreamLine([]byte(notify)); err != nil {
+		t.Fatal(err)
+	}
+	p.up.SetExtranonce("04ffff00", 4)
+
+	cl := NewClient(discardConn{}, cfg)
+	share := func(worker, nonce string) {
+		p.onShare(routing.ShareEvent{Time: time.Now(), Client: cl, Worker: worker, JobID: "g", Accepted: true,
+			Params: []any{"farm", "g", "1d010445", "495fab29", nonce}})
+	}
+	share("rig1", "7c2bac1e") // not the genesis nonce: a low difficulty share
+	if p.mx.BlocksFound.Load() != 0 {
+		t.Fatal("low share counted as a block")
+	}
+	share("rig2", "7c2bac1d")
+	if p.mx.BlocksFound.Load() != 1 {
+		t.Fatal("genesis share not detected as a block")
+	}
+
+	st := p.bestShareStats()
+	best := st["best"].(bestShare)
+	if best.Worker != "rig2" || best.Difficulty < 2536 || st["network_difficulty"] != 1.0 {
+		t.Errorf("best share stats = %v", st)
+	}
+	if w := st["workers"].(map[string]bestShare); len(w) != 2 || w["rig1"].Difficulty >= 1 {
+		t.Errorf("worker bests = %v", w)
+	}
+	// hashed with scrypt, the genesis share is no block
+	p.scores.setAlgorithm(stratum.Scrypt)
+	share("rig3", "7c2bac1d")
+	if p.mx.BlocksFound.Load() != 1 {
+		t.Error("sha256d block counted under scrypt")
+	}
+	select {
+	case ev := <-got:
+		if ev.Type != events.BlockFound || ev.Data["worker"] != "rig2" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("block event not delivered")
+	}
+}
+
+func TestJobStats(t *testing.T) {
+	p := NewProxy(&Config{})
+	notify := func(id string, clean bool) {
+		line := fmt.Sprintf(`{"id":null,"method":"mining.notify","params":["%s","%s","","",[],"20000000","1d00ffff","495fab29",%v]}`,
+			id, strings.Repeat("0", 64), clean)
+		if _, err := p.rt.ProcessUpstreamLine([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notify("a", true)
+	notify("b", false)
+
+	cl := NewClient(discardConn{}, &Config{})
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	for i, job := range []string{"a", "b", "b"} {
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig", job, "00", "00", "00"}})
+	}
+	p.onShare(routing.ShareEvent{Time: time.Now(), Client: cl, JobID: "b", Accepted: true})
+	p.onShare(routing.ShareEvent{Time: time.Now(), Client: cl, JobID: "b", Accepted: false})
+
+	rep := p.jobs.report(0)
+	jobs := rep["jobs"].([]jobRecord)
+	if len(jobs) != 2 || rep["clean"] != 1 {
+		t.Fatalf("job report = %+v", rep)
+	}
+	if a := jobs[0]; a.ID != "a" || !a.Clean || a.Submitted != 1 || a.LifetimeMs < 0 {
+		t.Errorf("job a = %+v", a)
+	}
+	if b := jobs[1]; b.Submitted != 2 || b.Accepted != 1 || b.Rejected != 1 || b.LifetimeMs != 0 {
+		t.Errorf("job b = %+v", b)
+	}
+	if jobs := p.jobs.report(1)["jobs"].([]jobRecord); len(jobs) != 1 || jobs[0].ID != "b" {
+		t.Errorf("limited report = %+v", jobs)
+	}
+
+	// only the first share of a job is timed
+	notify("c", false)
+	arrived := p.jobs.find("c").Arrived
+	if d := p.jobs.submitted("c", arrived.Add(3*time.Second)); d != 3*time.Second {
+		t.Errorf("first share after %s, want 3s", d)
+	}
+	if d := p.jobs.submitted("c", arrived.Add(4*time.Second)); d != 0 {
+		t.Errorf("second share timed at %s", d)
+	}
+}
+
+func TestClientGroups(t *testing.T) {
+	cfg := &Config{Groups: []GroupRule{
+		{Name: "container-3", IPRanges: []string{"127.0.0.0/8"}},
+		{Name: "s19", WorkerPrefixes: []string{"s19."}},
+		{Name: "asic", Listeners: []string{"asic"}},
+	}}
+	if err := ValidateGroups(cfg.Groups); err != nil {
+		t.Fatal(err)
+	}
+	if ValidateGroups([]GroupRule{{Name: "a", IPRanges: []string{"10.0.0.0/33"}}}) == nil ||
+		ValidateGroups([]GroupRule{{Name: "a"}, {Name: "a"}}) == nil {
+		t.Error("invalid groups accepted")
+	}
+
+	p := NewProxy(cfg)
+	rig := NewClient(discardConn{}, cfg)
+	rig.worker = "s19.rack1"
+	other := NewClient(discardConn{}, cfg)
+	other.addr = "10.0.0.5:4000"
+	other.ln = &listener{cfg: ListenerConfig{Name: "asic"}}
+	p.clients[rig] = struct{}{}
+	p.clients[other] = struct{}{}
+
+	now := time.Now()
+	p.onShare(routing.ShareEvent{Time: now, Client: rig, Accepted: true, Difficulty: 1000})
+	p.onShare(routing.ShareEvent{Time: now, Client: rig, Accepted: false})
+	p.onShare(routing.ShareEvent{Time: now, Client: other, Accepted: true, Difficulty: 10})
+
+	got := map[string]GroupStats{}
+	for _, st := range p.groupStats() {
+		got[st.Name] = st
+	}
+	if st := got["container-3"]; st.Clients != 1 || st.Accepted != 1 || st.Rejected != 1 || st.RejectPercent != 50 || st.HashrateHs <= 0 {
+		t.Errorf("container-3 = %+v", st)
+	}
+	if st := got["s19"]; st.Clients != 1 || st.Accepted != 1 {
+		t.Errorf("s19 = %+v", st)
+	}
+	if st := got["asic"]; st.Clients != 1 || st.Accepted != 1 || st.Rejected != 0 || st.HashrateHs >= got["s19"].HashrateHs {
+		t.Errorf("asic = %+v", st)
+	}
+
+	// totals survive a reload for groups still configured
+	p.grp.UpdateConfig(cfg.Groups[:1])
+	if stats := p.groupStats(); len(stats) != 1 || stats[0].Accepted != 1 {
+		t.Errorf("after reload = %+v", stats)
+	}
+}
+
+// writeTestCert stores a new self-signed certificate as PEM files
+func writeTestCert(t *testing.T, certPath, keyPath string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "karoo test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	_ = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	leaf, _ := x509.ParseCertificate(der)
+	return leaf
+}
+
+func TestReloadReport(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{}
+	cfg.Proxy.Listen = ":3333"
+	cfg.Proxy.TLS.Enabled = true
+	cfg.Proxy.TLS.Cert, cfg.Proxy.TLS.Key = filepath.Join(dir, "proxy.crt"), filepath.Join(dir, "proxy.key")
+	cfg.Upstream.Host, cfg.Upstream.Port = "pool.example.com", 3333
+	writeTestCert(t, cfg.Proxy.TLS.Cert, cfg.Proxy.TLS.Key)
+
+	p := NewProxy(cfg)
+	tlsCfg, err := p.listenerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() []byte {
+		c, _ := tlsCfg.GetCertificate(&tls.ClientHelloInfo{})
+		return c.Certificate[0]
+	}
+
+	// a renewed certificate is served after a reload
+	renewed := writeTestCert(t, cfg.Proxy.TLS.Cert, cfg.Proxy.TLS.Key)
+	next := *cfg
+	rep := p.Reload(&next)
+	if !bytes.Equal(served(), renewed.Raw) || len(rep.Applied) != 1 || len(rep.RestartRequired) != 0 || len(rep.Errors) != 0 {
+		t.Fatalf("report = %+v", rep)
+	}
+
+	// a broken file keeps the current certificate
+	_ = os.WriteFile(cfg.Proxy.TLS.Key, []byte("garbage"), 0o600)
+	broken := *cfg
+	if rep := p.Reload(&broken); len(rep.Errors) != 1 || !bytes.Equal(served(), renewed.Raw) {
+		t.Errorf("report = %+v", rep)
+	}
+
+	// a changed connected upstream reconnects; the listen address needs a
+	// restart
+	active := cfg.Upstream
+	p.activeUp.Store(&active)
+	p.upIdx.Store(0)
+	moved := *cfg
+	moved.Upstream.Host = "pool2.example.com"
+	moved.Proxy.Listen = ":4444"
+	rep = p.Reload(&moved)
+	if !p.retarget.Load() || len(rep.Applied) != 1 || !strings.Contains(rep.Applied[0], "reconnecting") {
+		t.Errorf("applied = %v", rep.Applied)
+	}
+	if len(rep.RestartRequired) != 1 || rep.RestartRequired[0] != "proxy.listen" {
+		t.Errorf("restart required = %v", rep.RestartRequired)
+	}
+}
+
+func TestReloadWhileSharesFlow(t *testing.T) {
+	cfg := &Config{}
+	cfg.Upstream.User = "farm"
+	cfg.Fee = FeeConfig{Enabled: true, Percent: 25, User: "fee"}
+	p := NewProxy(cfg)
+	_ = p.rt.UseClient("sink", func(next routing.ClientHandler) routing.ClientHandler {
+		return func(cl routing.Client, msg stratum.Message) {}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			next := &Config{}
+			next.Upstream.User = "farm"
+			next.Fee = FeeConfig{Enabled: i%2 == 0, Percent: 10 + float64(i), User: "fee"}
+			next.Lifetime.MaxMinutes = i % 3
+			next.Shedding.Enabled = i%2 == 1
+			next.Keepalive.UpstreamNotifySeconds = i
+			p.Reload(next)
+		}
+	}()
+	cl := NewClient(discardConn{}, cfg)
+	cl.subscribedOK.Store(true)
+	cl.SetWorker("rig")
+	now := time.Now()
+	for i := 0; i < 200; i++ {
+		p.rt.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig", "1", "00", fmt.Sprintf("%08x", i), "00"}})
+		p.feeResult(routing.ShareEvent{Client: cl, User: "fee", Accepted: true, Difficulty: 1})
+		p.shed()
+		p.recycle(now)
+		p.notifyStalled(now, now.Add(time.Minute))
+	}
+	<-done
+	if got := p.config().Fee.Percent; got != 59 {
+		t.Errorf("live fee percent = %v, want the last reload's 59", got)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	cfg := &Config{}
+	p := NewProxy(cfg)
+	now := time.Now()
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		p.serveReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	if got := p.notReady(now); len(got) != 2 {
+		t.Fatalf("fresh proxy: got %v, want listener and upstream", got)
+	}
+	p.listening.Store(true)
+	if ready() != http.StatusServiceUnavailable {
+		t.Error("ready before the upstream handshake")
+	}
+	p.up.SetExtranonce("deadbeef", 4)
+	p.nm.SetUpstreamReady(true)
+	if ready() != http.StatusOK {
+		t.Errorf("not ready with listener and upstream: %v", p.notReady(now))
+	}
+
+	cfg.HTTP.Readiness.MaxJobAgeSec = 30
+	if got := p.notReady(now); len(got) != 1 {
+		t.Errorf("no job yet: got %v", got)
+	}
+	p.mx.SetLastNotify(now.Add(-10 * time.Second))
+	if got := p.notReady(now); len(got) != 0 {
+		t.Errorf("recent job: got %v", got)
+	}
+	if got := p.notReady(now.Add(time.Minute)); len(got) != 1 {
+		t.Errorf("stale job: got %v", got)
+	}
+
+	p.nm.SetUpstreamReady(false)
+	cfg.HTTP.Readiness.SkipUpstream = true
+	if ready() != http.StatusOK {
+		t.Error("skip_upstream still waits for the pool")
+	}
+	p.listening.Store(false)
+	if ready() != http.StatusServiceUnavailable {
+		t.Error("ready without a listener")
+	}
+}
+
+func TestSessionTrail(t *testing.T) {
+	cfg := &Config{}
+	cfg.Sessions.Enabled = true
+	p := NewProxy(cfg)
+	server, client := net.Pipe()
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+	cl := NewClient(client, cfg)
+	cl.session = p.ss.NewID()
+
+	p.sessionEvent(cl, sessions.Connect, "", "")
+	cl.worker = "rig1"
+	p.onAuthorize(cl, true)
+	p.ban(cl, "too many invalid shares")
+	p.kick(cl, reasonShed) // the ban already decided the reason
+	cl.closing(reasonClientClosed)
+	p.sessionEvent(cl, sessions.Disconnect, "", *cl.closeReason.Load())
+
+	rec := httptest.NewRecorder()
+	p.handleSessions(rec, httptest.NewRequest(http.MethodGet, "/sessions?worker=rig1", nil))
+	var body struct {
+		Sessions []sessions.Session `json:"sessions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(body.Sessions))
+	}
+	s := body.Sessions[0]
+	var types []string
+	for _, ev := range s.Events {
+		types = append(types, ev.Type)
+	}
+	want := []string{sessions.Connect, sessions.Authorize, sessions.Ban, sessions.Disconnect}
+	if !reflect.DeepEqual(types, want) || s.Reason != reasonBanned || s.Events[2].Reason != "too many invalid shares" {
+		t.Errorf("got events %v reason %q, want %v reason %q", types, s.Reason, want, reasonBanned)
+	}
+
+	rec = httptest.NewRecorder()
+	p.handleSessions(rec, httptest.NewRequest(http.MethodGet, "/sessions?since=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad since: got %d", rec.Code)
+	}
+	if got := readReason(&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}); got != reasonTimeout {
+		t.Errorf("deadline: got %s", got)
+	}
+}
+
+func TestClientLifetime(t *testing.T) {
+	cfg := &Config{}
+	cfg.ClientQueue.Size = 16
+	cfg.Lifetime = LifetimeConfig{MaxMinutes: 60, JitterPct: 10, GraceSeconds: 30}
+	if err := cfg.Lifetime.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bad := (LifetimeConfig{Action: "kick"}); bad.Validate() == nil {
+		t.Error("unknown action accepted")
+	}
+	if lo, hi := cfg.Lifetime.limit(-1), cfg.Lifetime.limit(0.999); lo != 54*time.Minute || hi <= lo || hi > 66*time.Minute {
+		t.Errorf("limits = %s, %s; want within 54m..66m", lo, hi)
+	}
+	p := NewProxy(cfg)
+
+	add := func(age time.Duration) (*Client, net.Conn) {
+		srv, cli := net.Pipe()
+		t.Cleanup(func() { _ = cli.Close() })
+		cl := NewClient(srv, cfg)
+		cl.startWriter(cfg.ClientQueue, p.mx)
+		cl.connected = time.Now().Add(-age)
+		cl.lifeJitter = 0
+		p.clients[cl] = struct{}{}
+		return cl, cli
+	}
+	young, _ := add(30 * time.Minute)
+	old, cli := add(2 * time.Hour)
+
+	now := time.Now()
+	if n := p.recycle(now); n != 1 || old.reconnectAsked.Load() == 0 || young.reconnectAsked.Load() != 0 {
+		t.Fatalf("first pass acted on %d clients", n)
+	}
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, err := bufio.NewReader(cli).ReadString('\n'); err != nil || !strings.Contains(line, methodReconnect) {
+		t.Errorf("reconnect request = %q, %v", line, err)
+	}
+	if n := p.recycle(now.Add(10 * time.Second)); n != 0 {
+		t.Errorf("client closed within the grace time (%d)", n)
+	}
+	if n := p.recycle(now.Add(31 * time.Second)); n != 1 || *old.closeReason.Load() != reasonMaxLifetime {
+		t.Errorf("client past the grace time not closed (%d)", n)
+	}
+
+	delete(p.clients, old) // as ClientLoop does once the connection closes
+	cfg.Lifetime.Action = LifetimeClose
+	young.connected = now.Add(-61 * time.Minute)
+	if n := p.recycle(now); n != 1 || young.closeReason.Load() == nil || young.reconnectAsked.Load() != 0 {
+		t.Errorf("close action: acted on %d clients", n)
+	}
+}
+
+func TestUpstreamAuthRejection(t *testing.T) {
+	c := UpstreamAuthConfig{BackoffMaxMs: 5000}
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		if got := c.backoff(time.Second, n); got != want {
+			t.Errorf("backoff after %d refusals = %s, want %s", n, got, want)
+		}
+	}
+
+	cfg := &Config{}
+	cfg.Upstream = UpstreamConfig{Host: "a", Port: 1, User: "w1", AuthBackoffMs: 1000}
+	cfg.Backups = []UpstreamConfig{{Host: "b", Port: 1, User: "w1"}, {Host: "c", Port: 1, User: "w2"}}
+	p := NewProxy(cfg)
+	configs := p.upstreamConfigs(false)
+	now := time.Now()
+
+	next, d := p.authRejected(configs, 0, now)
+	if next != 0 || d != time.Second {
+		t.Errorf("got idx %d after %s, want the same upstream after 1s", next, d)
+	}
+	next, d = p.authRejected(configs, 0, now.Add(d))
+	f := p.authFail.Load()
+	if next != 0 || d != 2*time.Second || f == nil || f.Rejections != 2 || !f.Since.Equal(now) || f.User != "w1" {
+		t.Errorf("second refusal: idx %d, %s, %+v", next, d, f)
+	}
+
+	// switch_backup skips the backup with the same credentials
+	cfg.UpstreamAuth.SwitchBackup = true
+	if next, _ = p.authRejected(configs, 0, now); next != 2 {
+		t.Errorf("switch_backup moved to idx %d, want 2", next)
+	}
+	if p.mx.UpstreamAuthRejections.Load() != 3 {
+		t.Errorf("rejections = %d, want 3", p.mx.UpstreamAuthRejections.Load())
+	}
+
+	p.authAccepted()
+	if p.authFail.Load() != nil {
+		t.Error("a successful authorize should clear the failure")
+	}
+}
+
+func TestLeakWatch(t *testing.T) {
+	cfg := RuntimeConfig{LeakChecks: 3, MinGrowth: 30}
+	var w leakWatch
+	steps := []struct {
+		goroutines int
+		clients    int64
+		leak       bool
+	}{
+		{100, 10, false},
+		{110, 10, false},
+		{120, 9, false},
+		{125, 10, false}, // three rises but only 25 more
+		{140, 10, true},  // 30 more since 110 over the last three
+		{150, 10, false}, // reported runs start over
+		{160, 12, false}, // more clients explain more goroutines
+		{170, 12, false},
+		{180, 12, false},
+		{175, 12, false}, // a drop starts over
+	}
+	for i, s := range steps {
+		if got := w.observe(runtimeSample{goroutines: s.goroutines, clients: s.clients}, cfg); got != s.leak {
+			t.Errorf("step %d: leak = %v, want %v", i, got, s.leak)
+		}
+	}
+
+	stats := runtimeStats()
+	if n, _ := stats["goroutines"].(int); n <= 0 {
+		t.Errorf("runtime stats = %v", stats)
+	}
+}
+
+func TestClientTeardown(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 10
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	cfg.Admission.Enabled = true
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancelling the server context closes the connection as a shutdown
+	srv, cli := net.Pipe()
+	sctx, stop := context.WithCancel(ctx)
+	p.admit(sctx, srv, nil)
+	var cl *Client
+	p.clMu.Lock()
+	for c := range p.clients {
+		cl = c
+	}
+	p.clMu.Unlock()
+	stop()
+	_ = cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(cli); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("client not closed on shutdown")
+	}
+	_ = cli.Close()
+	waitClients(t, p)
+	if r := cl.closeReason.Load(); r == nil || *r != reasonShutdown {
+		t.Errorf("close reason = %v, want %s", r, reasonShutdown)
+	}
+
+	// connect and disconnect, alternating who ends the connection, must
+	// leave no goroutine behind
+	base := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		srv, cli := net.Pipe()
+		cctx, stop := context.WithCancel(ctx)
+		p.admit(cctx, srv, nil)
+		if i%2 == 0 {
+			_ = cli.Close()
+		} else {
+			stop()
+		}
+		waitClients(t, p)
+		stop()
+		_ = cli.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	n := runtime.NumGoroutine()
+	for n > base && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	if n > base {
+		t.Errorf("goroutines grew from %d to %d over 1000 connections", base, n)
+	}
+}
+
+func TestHandshakeBacklog(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 10
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	cfg.Admission.Enabled = true
+	cfg.Admission.MaxHandshakes = 1
+	cfg.Admission.StaggerMs = 20
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv1, cli1 := net.Pipe()
+	p.admit(ctx, srv1, nil)
+	srv2, cli2 := net.Pipe()
+	defer cli2.Close()
+	p.admit(ctx, srv2, nil)
+	if n := p.mx.ClientsActive.Load(); n != 1 {
+		t.Fatalf("%d clients admitted with one handshake slot, want 1", n)
+	}
+
+	// the first client leaving lets the backlogged one in, with its first
+	// work staggered
+	_ = cli1.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	var cl *Client
+	for cl == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("backlogged connection not admitted")
+		}
+		time.Sleep(time.Millisecond)
+		p.clMu.Lock()
+		for c := range p.clients {
+			if c.c == srv2 {
+				cl = c
+			}
+		}
+		p.clMu.Unlock()
+	}
+	cl.stagger.Store(int64(20 * time.Millisecond))
+	cl.SetHandshakeDone(true)
+	if cl.handshakeDone.Load() {
+		t.Error("staggered client authorized at once")
+	}
+	for !cl.handshakeDone.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("staggered client never authorized")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a client gone before its stagger elapses is never authorized
+	srv3, cli3 := net.Pipe()
+	defer cli3.Close()
+	gone := NewClient(srv3, cfg)
+	gctx, gcancel := context.WithCancel(ctx)
+	gone.bind(gctx)
+	gone.stagger.Store(int64(20 * time.Millisecond))
+	gone.SetHandshakeDone(true)
+	gcancel()
+	time.Sleep(50 * time.Millisecond)
+	if gone.handshakeDone.Load() {
+		t.Error("stagger timer authorized a client that had gone")
+	}
+}
+
+func TestUpstreamViews(t *testing.T) {
+	cfg := &Config{}
+	cfg.Proxy.MaxClients = 10
+	cfg.Proxy.ReadBuf = 4096
+	cfg.Proxy.WriteBuf = 4096
+	cfg.Upstream = UpstreamConfig{Host: "main.pool", Port: 3333}
+	cfg.Backups = []UpstreamConfig{{Host: "backup.pool", Port: 3333}}
+	cfg.Profiles = map[string]ProfileConfig{
+		"alt": {Upstream: UpstreamConfig{Host: "alt.pool", Port: 3333}},
+	}
+	p := NewProxy(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	p.admit(ctx, srv, nil)
+
+	// failed over to the backup, which accepted a share
+	p.mx.ObserveUpstreamDial("main.pool:3333", time.Millisecond)
+	p.mx.SetUpstreamInactive()
+	p.mx.ObserveUpstreamDial("backup.pool:3333", time.Millisecond)
+	p.mx.IncrementSharesOK()
+
+	got := p.upstreamViews()
+	if len(got) != 3 {
+		t.Fatalf("got %d upstreams, want 3: %+v", len(got), got)
+	}
+	primary, backup, alt := got[0], got[1], got[2]
+	if primary.Role != rolePrimary || primary.Active || primary.State != "standby" || len(primary.Clients) != 0 || primary.Latency == nil {
+		t.Errorf("primary = %+v", primary)
+	}
+	if backup.Role != roleBackup || !backup.Active || backup.SharesOK != 1 || len(backup.Clients) != 1 {
+		t.Errorf("backup = %+v", backup)
+	}
+	if alt.Route != "alt" || alt.Host != "alt.pool:3333" || alt.Active || alt.Clients == nil {
+		t.Errorf("profile upstream = %+v", alt)
+	}
+}
+
+// waitClients waits for every client of p to be torn down
+func waitClients(t *testing.T, p *Proxy) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for p.mx.ClientsActive.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients still active", p.mx.ClientsActive.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+)
+
+// publicHashrateWindow is the span the public hashrate estimate averages over
+const publicHashrateWindow = 10 * time.Minute
+
+// PublicConfig controls the unauthenticated aggregate stats endpoint
+type PublicConfig struct {
+	Enabled bool `json:"enabled"`
+	// Listen binds /public on its own server; empty serves it on http.listen
+	Listen string `json:"listen"`
+}
+
+// PublicStats is everything /public reveals: aggregates only, no worker
+// names, addresses or upstream details
+type PublicStats struct {
+	HashrateHs        float64 `json:"hashrate_hs"`
+	Workers           int     `json:"workers"`
+	Connections       int64   `json:"connections"`
+	SharesAccepted    uint64  `json:"shares_accepted"`
+	SharesRejected    uint64  `json:"shares_rejected"`
+	AcceptancePercent float64 `json:"acceptance_percent"`
+	UpstreamConnected bool    `json:"upstream_connected"`
+	UpdatedUnix       int64   `json:"updated_unix"`
+}
+
+// hashMeter estimates hashrate from accepted share difficulty over a sliding
+// window split into fixed buckets
+type hashMeter struct {
+	mu     sync.Mutex
+	bucket time.Duration
+	work   []float64 // accepted difficulty per bucket
+	slot   []int64   // bucket number each entry currently holds
+}
+
+func newHashMeter(window time.Duration) *hashMeter {
+	const buckets = 30
+	return &hashMeter{
+		bucket: window / buckets,
+		work:   make([]float64, buckets),
+		slot:   make([]int64, buckets),
+	}
+}
+
+// add records an accepted share of the given difficulty
+func (m *hashMeter) add(t time.Time, diff float64) {
+	n := t.UnixNano() / int64(m.bucket)
+	i := int(n % int64(len(m.work)))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.slot[i] > n {
+		return // older than the window the slot already holds
+	}
+	if m.slot[i] != n {
+		m.slot[i] = n
+		m.work[i] = 0
+	}
+	m.work[i] += diff
+}
+
+// rate returns the estimated hashes per second at now
+func (m *hashMeter) rate(now time.Time) float64 {
+	n := now.UnixNano() / int64(m.bucket)
+	oldest := n - int64(len(m.work)) + 1
+	var work float64
+	m.mu.Lock()
+	for i, s := range m.slot {
+		if s >= oldest && s <= n {
+			work += m.work[i]
+		}
+	}
+	m.mu.Unlock()
+	window := m.bucket * time.Duration(len(m.work))
+	return work * 4294967296 / window.Seconds()
+}
+
+// recordHashrate feeds accepted shares into the public hashrate estimate
+func (p *Proxy) recordHashrate(ev routing.ShareEvent) {
+	if ev.Accepted && ev.Difficulty > 0 {
+		p.hr.add(ev.Time, ev.Difficulty)
+	}
+}
+
+// publicStats aggregates this proxy and its SNI profiles
+func (p *Proxy) publicStats() PublicStats {
+	now := time.Now()
+	st := PublicStats{
+		HashrateHs:        p.hr.rate(now),
+		UpstreamConnected: p.mx.UpConnected.Load(),
+		UpdatedUnix:       now.Unix(),
+	}
+	workers := make(map[string]struct{})
+	for _, px := range append([]*Proxy{p}, p.profileList()...) {
+		st.Connections += px.mx.ClientsActive.Load()
+		st.SharesAccepted += px.mx.SharesOK.Load()
+		st.SharesRejected += px.mx.SharesBad.Load()
+		px.clMu.RLock()
+		for cl := range px.clients {
+			if w := cl.GetWorker(); w != "" {
+				workers[w] = struct{}{}
+			}
+		}
+		px.clMu.RUnlock()
+	}
+	st.Workers = len(workers)
+	if total := st.SharesAccepted + st.SharesRejected; total > 0 {
+		st.AcceptancePercent = float64(st.SharesAccepted) * 100 / float64(total)
+	}
+	return st
+}
+
+// profileList returns the SNI profile proxies
+func (p *Proxy) profileList() []*Proxy {
+	out := make([]*Proxy, 0, len(p.profiles))
+	for _, sub := range p.profiles {
+		out = append(out, sub)
+	}
+	return out
+}
+
+// handlePublic serves the aggregate stats; safe to expose without auth
+func (p *Proxy) handlePublic(w http.ResponseWriter, r *http.Request) {
+	if !p.config().Public.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=10")
+	writeJSON(w, p.publicStats())
+}
+
+// PublicServe runs the dedicated /public listener. It serves nothing else so
+// the address can be exposed to the internet.
+func (p *Proxy) PublicServe(ctx context.Context) {
+	cfg := p.config()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/public", p.handlePublic)
+	srv := &http.Server{Addr: cfg.Public.Listen, Handler: p.withServerHeader(mux)}
+	go func() {
+		<-ctx.Done()
+		ctx2, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx2)
+	}()
+	log.Printf("public: listening on %s", cfg.Public.Listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("public http err: %v", err)
+	}
+}
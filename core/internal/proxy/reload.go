@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ReloadReport lists what a reload applied and the changes that only take
+// effect after a restart
+type ReloadReport struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+	Errors          []string `json:"errors"`
+}
+
+func (r *ReloadReport) applied(format string, args ...interface{}) {
+	r.Applied = append(r.Applied, fmt.Sprintf(format, args...))
+}
+
+func (r *ReloadReport) restart(format string, args ...interface{}) {
+	r.RestartRequired = append(r.RestartRequired, fmt.Sprintf(format, args...))
+}
+
+func (r *ReloadReport) fail(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// merge adds a profile's report under its name. Profile configs derive from
+// the main one, whose report already lists what needs a restart.
+func (r *ReloadReport) merge(name string, sub ReloadReport) {
+	for _, s := range sub.Applied {
+		r.applied("profile %s: %s", name, s)
+	}
+	for _, s := range sub.Errors {
+		r.fail("profile %s: %s", name, s)
+	}
+}
+
+// log writes the report after a reload
+func (r ReloadReport) log() {
+	if len(r.Applied) > 0 {
+		log.Printf("reload applied: %s", strings.Join(r.Applied, "; "))
+	}
+	if len(r.RestartRequired) > 0 {
+		log.Printf("reload: restart required for: %s", strings.Join(r.RestartRequired, "; "))
+	}
+	for _, e := range r.Errors {
+		log.Printf("reload error: %s", e)
+	}
+}
+
+// certFile is a certificate loaded from disk. A reload swaps it while
+// established connections keep the one they were handshaken with.
+type certFile struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func loadCertFile(certPath, keyPath string) (*certFile, error) {
+	c := &certFile{}
+	if err := c.reload(certPath, keyPath); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the files again; on error the current certificate stays
+func (c *certFile) reload(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("loading tls keys: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// get serves the certificate to TLS handshakes
+func (c *certFile) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reloadCerts rereads every certificate file the proxy serves, using the
+// paths of the new config
+func (p *Proxy) reloadCerts(newCfg *Config, rep *ReloadReport) {
+	n := 0
+	reload := func(what string, c *certFile, certPath, keyPath string) {
+		if c == nil || certPath == "" {
+			return
+		}
+		if err := c.reload(certPath, keyPath); err != nil {
+			rep.fail("%s certificate: %v; keeping the current one", what, err)
+			return
+		}
+		n++
+	}
+	reload("proxy.tls", p.defCert, newCfg.Proxy.TLS.Cert, newCfg.Proxy.TLS.Key)
+	for i, c := range p.sniCerts {
+		if i < len(newCfg.SNIRoutes) {
+			r := newCfg.SNIRoutes[i]
+			reload("sni_routes "+r.ServerName, c, r.CertFile, r.KeyFile)
+		}
+	}
+	for _, l := range p.listeners {
+		for _, lc := range newCfg.Listeners {
+			if lc.Name == l.cfg.Name {
+				reload("listener "+lc.Name, l.cert, lc.TLS.Cert, lc.TLS.Key)
+			}
+		}
+	}
+	reload("http.tls", p.httpCert.Load(), newCfg.HTTP.TLS.Cert, newCfg.HTTP.TLS.Key)
+	if n > 0 {
+		rep.applied("reloaded %d TLS certificate(s)", n)
+	}
+}
+
+// retargetUpstream reconnects when the entry of the connected upstream was
+// changed, so new hosts, accounts and TLS settings apply without a restart.
+// Other entries take effect on the next failover.
+func (p *Proxy) retargetUpstream(rep *ReloadReport) {
+	active := p.activeUp.Load()
+	if active == nil {
+		return
+	}
+	configs := p.upstreamConfigs(p.feeSlice.Load())
+	idx := int(p.upIdx.Load())
+	if idx >= 0 && idx < len(configs) && reflect.DeepEqual(configs[idx], *active) {
+		return
+	}
+	rep.applied("upstream %s changed; reconnecting", active.addr())
+	p.rt.ForceCleanJobs()
+	p.retarget.Store(true)
+	p.up.Close()
+}
+
+// restartOnly lists the changes a reload cannot apply
+func restartOnly(old, cur *Config, rep *ReloadReport) {
+	if old.Proxy.Listen != cur.Proxy.Listen || old.Proxy.TLS.Enabled != cur.Proxy.TLS.Enabled {
+		rep.restart("proxy.listen")
+	}
+	if !reflect.DeepEqual(routeKeys(old.SNIRoutes), routeKeys(cur.SNIRoutes)) {
+		rep.restart("sni_routes")
+	}
+	if !reflect.DeepEqual(listenerKeys(old.Listeners), listenerKeys(cur.Listeners)) {
+		rep.restart("listeners")
+	}
+	if (old.HTTP.Listen == "") != (cur.HTTP.Listen == "") {
+		rep.restart("http.listen")
+	}
+	if old.HTTP.Pprof != cur.HTTP.Pprof || old.HTTP.PprofListen != cur.HTTP.PprofListen {
+		rep.restart("http.pprof")
+	}
+	if old.Public.Enabled != cur.Public.Enabled || old.Public.Listen != cur.Public.Listen {
+		rep.restart("public")
+	}
+	if !reflect.DeepEqual(old.ACME, cur.ACME) {
+		rep.restart("acme")
+	}
+	if !reflect.DeepEqual(old.Solo, cur.Solo) {
+		rep.restart("solo")
+	}
+	if old.Aggregate.Enabled != cur.Aggregate.Enabled {
+		rep.restart("aggregate")
+	}
+	if !reflect.DeepEqual(old.Canary, cur.Canary) {
+		rep.restart("canary")
+	}
+	if old.ConfigWatch != cur.ConfigWatch {
+		rep.restart("config_watch")
+	}
+}
+
+// routeKeys drops the certificate paths, which reloads apply
+func routeKeys(routes []SNIRoute) []SNIRoute {
+	out := make([]SNIRoute, len(routes))
+	for i, r := range routes {
+		r.CertFile, r.KeyFile = "", ""
+		out[i] = r
+	}
+	return out
+}
+
+// listenerKeys drops the certificate paths, which reloads apply
+func listenerKeys(listeners []ListenerConfig) []ListenerConfig {
+	out := make([]ListenerConfig, len(listeners))
+	for i, l := range listeners {
+		l.TLS.Cert, l.TLS.Key = "", ""
+		out[i] = l
+	}
+	return out
+}
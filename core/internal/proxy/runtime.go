@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+)
+
+// RuntimeConfig watches the process for leaked goroutines, such as client
+// loops that outlive their connection
+type RuntimeConfig struct {
+	// LeakChecks is how many checks in a row the goroutine count must rise
+	// while the client count does not before a leak is reported; 0 is off
+	LeakChecks int `json:"leak_checks"`
+	// IntervalSeconds between checks; default 60
+	IntervalSeconds int `json:"interval_seconds"`
+	// MinGrowth is how many goroutines the count must gain over those
+	// checks, so scheduling noise is not reported; default 50
+	MinGrowth int `json:"min_growth"`
+}
+
+func (c RuntimeConfig) interval() time.Duration {
+	if c.IntervalSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.IntervalSeconds) * time.Second
+}
+
+func (c RuntimeConfig) minGrowth() int {
+	if c.MinGrowth <= 0 {
+		return 50
+	}
+	return c.MinGrowth
+}
+
+// runtimeSample is one check of the goroutine watchdog
+type runtimeSample struct {
+	goroutines int
+	clients    int64
+}
+
+// leakWatch keeps the samples of the current run of rising goroutine counts
+type leakWatch struct {
+	run []runtimeSample
+}
+
+// observe adds a sample and reports whether the run shows a leak. A sample
+// whose goroutine count did not rise, or whose client count rose, starts a
+// new run; a reported leak starts one too, so it is reported once per run.
+func (w *leakWatch) observe(s runtimeSample, cfg RuntimeConfig) bool {
+	if n := len(w.run); n > 0 && (s.goroutines <= w.run[n-1].goroutines || s.clients > w.run[0].clients) {
+		w.run = w.run[:0]
+	}
+	w.run = append(w.run, s)
+	if cfg.LeakChecks <= 0 || len(w.run) <= cfg.LeakChecks {
+		return false
+	}
+	if s.goroutines-w.run[0].goroutines < cfg.minGrowth() {
+		w.run = w.run[1:]
+		return false
+	}
+	w.run = []runtimeSample{s}
+	return true
+}
+
+// RuntimeLoop checks the goroutine count against the client count and
+// reports a suspected leak; it follows reloads
+func (p *Proxy) RuntimeLoop(ctx context.Context) {
+	var w leakWatch
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config().Runtime.interval()):
+		}
+		rc := p.config().Runtime
+		s := runtimeSample{goroutines: runtime.NumGoroutine(), clients: p.totalClients()}
+		first := runtimeSample{}
+		if len(w.run) > 0 {
+			first = w.run[0]
+		}
+		if !w.observe(s, rc) {
+			continue
+		}
+		log.Printf("runtime: goroutines rose from %d to %d over %d checks while clients went from %d to %d; possible leak",
+			first.goroutines, s.goroutines, rc.LeakChecks, first.clients, s.clients)
+		p.mx.IncrementGoroutineLeaks()
+		p.emit(events.GoroutineLeak, map[string]interface{}{
+			"goroutines": s.goroutines,
+			"from":       first.goroutines,
+			"clients":    s.clients,
+		})
+	}
+}
+
+// totalClients counts the clients of the proxy and its profiles
+func (p *Proxy) totalClients() int64 {
+	n := p.mx.ClientsActive.Load()
+	for _, sub := range p.profileList() {
+		n += sub.mx.ClientsActive.Load()
+	}
+	return n
+}
+
+// runtimeStats reports the process for /status
+func runtimeStats() map[string]interface{} {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	out := map[string]interface{}{
+		"goroutines":        runtime.NumGoroutine(),
+		"heap_alloc_bytes":  ms.HeapAlloc,
+		"heap_sys_bytes":    ms.HeapSys,
+		"gc_runs":           ms.NumGC,
+		"gc_pause_total_ms": float64(ms.PauseTotalNs) / 1e6,
+	}
+	if ms.NumGC > 0 {
+		out["gc_pause_last_ms"] = float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6
+	}
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		out["open_fds"] = len(fds)
+	}
+	return out
+}
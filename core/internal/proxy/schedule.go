@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+)
+
+// ScheduleConfig switches the upstream, or only its account, by time of day
+type ScheduleConfig struct {
+	Enabled  bool             `json:"enabled"`
+	Timezone string           `json:"timezone"` // IANA name; default local time
+	Windows  []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is a daily time range with its upstream. The first window
+// covering the current time wins; outside all of them the main upstream and
+// backups are used.
+type ScheduleWindow struct {
+	Name  string   `json:"name"`
+	Days  []string `json:"days"`  // "mon".."sun"; empty is every day
+	Start string   `json:"start"` // "HH:MM"
+	End   string   `json:"end"`   // "HH:MM"; earlier than start runs past midnight
+	// Upstream is mined during the window, with the main upstream and
+	// backups as its failover; or User and Pass only replace the account
+	Upstream *UpstreamConfig `json:"upstream"`
+	User     string          `json:"user"`
+	Pass     string          `json:"pass"`
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseClock turns "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the timezone and windows
+func (c ScheduleConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, err := c.location(); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i, w := range c.Windows {
+		if w.Name == "" || seen[w.Name] {
+			return fmt.Errorf("windows[%d]: names must be set and unique", i)
+		}
+		seen[w.Name] = true
+		for _, d := range w.Days {
+			if !slices.Contains(weekdays, strings.ToLower(d)) {
+				return fmt.Errorf("windows[%d]: unknown day %q", i, d)
+			}
+		}
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return fmt.Errorf("windows[%d]: %w", i, err)
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return fmt.Errorf("windows[%d]: %w", i, err)
+		}
+		if start == end {
+			return fmt.Errorf("windows[%d]: start and end must differ", i)
+		}
+		if (w.Upstream == nil) == (w.User == "") {
+			return fmt.Errorf("windows[%d]: set either upstream or user", i)
+		}
+	}
+	return nil
+}
+
+func (c ScheduleConfig) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	return loc, nil
+}
+
+// covers reports whether the window is open at t. A window past midnight
+// belongs to the day it starts on.
+func (w ScheduleWindow) covers(t time.Time) bool {
+	start, err1 := parseClock(w.Start)
+	end, err2 := parseClock(w.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case start < end:
+		if now < start || now >= end {
+			return false
+		}
+	case now >= start:
+	case now < end:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(w.Days, func(d string) bool { return strings.EqualFold(d, weekdays[day]) })
+}
+
+// active returns the index of the window open at t, or -1
+func (c ScheduleConfig) active(t time.Time) int {
+	if !c.Enabled {
+		return -1
+	}
+	loc, err := c.location()
+	if err != nil {
+		return -1
+	}
+	t = t.In(loc)
+	for i, w := range c.Windows {
+		if w.covers(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply returns the upstreams to try while the window is open
+func (w ScheduleWindow) apply(configs []UpstreamConfig) []UpstreamConfig {
+	if w.Upstream != nil {
+		return append([]UpstreamConfig{*w.Upstream}, configs...)
+	}
+	out := make([]UpstreamConfig, len(configs))
+	for i, c := range configs {
+		c.User, c.Pass = w.User, w.Pass
+		out[i] = c
+	}
+	return out
+}
+
+// scheduledWindow returns the window the scheduler has switched to, if any
+func (p *Proxy) scheduledWindow() *ScheduleWindow {
+	cfg := p.config()
+	i := int(p.schedIdx.Load())
+	if i < 0 || i >= len(cfg.Schedule.Windows) {
+		return nil
+	}
+	return &cfg.Schedule.Windows[i]
+}
+
+// ScheduleLoop follows the schedule, moving to the upstream of each window
+// as it opens and back when it closes
+func (p *Proxy) ScheduleLoop(ctx context.Context) {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		p.applySchedule(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// applySchedule switches the upstream when the open window changed and
+// reports whether it did
+func (p *Proxy) applySchedule(now time.Time) bool {
+	idx := int32(p.config().Schedule.active(now))
+	prev := p.schedIdx.Swap(idx)
+	if prev == idx {
+		return false
+	}
+	name := "main"
+	if w := p.scheduledWindow(); w != nil {
+		name = w.Name
+	}
+	log.Printf("schedule: switching upstream to %s", name)
+	p.emit(events.UpstreamScheduled, map[string]interface{}{"window": name})
+	if p.mx.UpConnected.Load() {
+		// the next upstream's first job must make miners drop the old work
+		p.rt.ForceCleanJobs()
+		p.switching.Store(true)
+		p.up.Close()
+	}
+	return true
+}
+
+// scheduleStats reports the open window for /status
+func (p *Proxy) scheduleStats() map[string]interface{} {
+	out := map[string]interface{}{"active": ""}
+	if w := p.scheduledWindow(); w != nil {
+		out["active"] = w.Name
+	}
+	loc, err := p.config().Schedule.location()
+	if err == nil {
+		out["timezone"] = loc.String()
+	}
+	return out
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"log"
+)
+
+// upstreamAddrs lists the configured upstreams (primary first) as host:port
+func (p *Proxy) upstreamAddrs() []string {
+	cfg := p.config()
+	addrs := []string{cfg.Upstream.addr()}
+	for _, b := range cfg.Backups {
+		addrs = append(addrs, b.addr())
+	}
+	return addrs
+}
+
+// SelectionLoop probes the upstreams for the latency and round_robin
+// policies and moves to a faster upstream when the latency policy finds one
+func (p *Proxy) SelectionLoop(ctx context.Context) {
+	p.sel.Run(ctx, p.upstreamAddrs, p.maybeSwitchUpstream)
+}
+
+// maybeSwitchUpstream drops the upstream connection when a faster upstream
+// is available; UpstreamLoop then reconnects to it without backoff
+func (p *Proxy) maybeSwitchUpstream() {
+	if p.feeSlice.Load() || p.scheduledWindow() != nil {
+		return
+	}
+	idx := int(p.upIdx.Load())
+	addrs := p.upstreamAddrs()
+	next, ok := p.sel.Switch(addrs, idx)
+	if !ok {
+		return
+	}
+	log.Printf("selection: switching upstream %s -> %s (lower latency)", addrs[idx], addrs[next])
+	p.switching.Store(true)
+	p.up.Close()
+}
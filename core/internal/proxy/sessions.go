@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/sessions"
+)
+
+// Disconnect reasons of the session audit trail
+const (
+	reasonClientClosed     = "client_closed"
+	reasonTimeout          = "timeout"
+	reasonReadError        = "read_error"
+	reasonWriteError       = "write_error"
+	reasonQueueFull        = "queue_full"
+	reasonSubscribeTimeout = "subscribe_timeout"
+	reasonBanned           = "banned"
+	reasonBannedWorker     = "banned_worker"
+	reasonPinned           = "pinned"
+	reasonDuplicateWorker  = "duplicate_worker"
+	reasonShed             = "shed"
+	reasonMaxLifetime      = "max_lifetime"
+	reasonLineTooLong      = "line_too_long"
+	reasonInvalidInput     = "invalid_input"
+	reasonShutdown         = "shutdown"
+)
+
+// closing records why the client is being disconnected and reports whether
+// it is the first reason given
+func (c *Client) closing(reason string) bool {
+	return c.closeReason.CompareAndSwap(nil, &reason)
+}
+
+// sessionEvent records a step of the client's session
+func (p *Proxy) sessionEvent(cl *Client, typ, worker, reason string) {
+	if worker == "" {
+		worker = cl.GetWorker()
+	}
+	p.ss.Record(sessions.Event{Session: cl.session, Type: typ, Addr: cl.addr, Worker: worker, Reason: reason})
+}
+
+// kick records that the proxy is disconnecting the client; the caller closes
+// it
+func (p *Proxy) kick(cl *Client, reason string) {
+	if cl.closing(reason) {
+		p.sessionEvent(cl, sessions.Kick, "", reason)
+	}
+}
+
+// ban records that a ban is disconnecting the client
+func (p *Proxy) ban(cl *Client, reason string) {
+	if cl.closing(reasonBanned) {
+		p.sessionEvent(cl, sessions.Ban, "", reason)
+	}
+}
+
+// refuseAuthorize records an authorize the proxy answered with an error
+// before dropping the client
+func (p *Proxy) refuseAuthorize(cl *Client, worker, reason string) {
+	cl.closing(reason)
+	p.sessionEvent(cl, sessions.AuthorizeFailed, worker, reason)
+}
+
+// onAuthorize receives the outcome of every mining.authorize from the router
+func (p *Proxy) onAuthorize(c routing.Client, ok bool) {
+	cl, isClient := c.(*Client)
+	if !isClient {
+		return
+	}
+	p.handshakeFinished(cl)
+	typ := sessions.Authorize
+	if !ok {
+		typ = sessions.AuthorizeFailed
+	}
+	p.sessionEvent(cl, typ, "", "")
+}
+
+// readReason turns the error that ended the read loop into a disconnect
+// reason
+func readReason(err error) string {
+	var ne net.Error
+	switch {
+	case err == nil || isNetClosed(err):
+		return reasonClientClosed
+	case errors.As(err, &ne) && ne.Timeout():
+		return reasonTimeout
+	default:
+		return reasonReadError
+	}
+}
+
+// handleSessions serves the session audit trail. ?worker= keeps one worker's
+// sessions, ?since= and ?until= (unix seconds) those connected in that
+// range, and ?limit= the newest.
+func (p *Proxy) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !p.config().Sessions.Enabled {
+		http.Error(w, "sessions are disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	f := sessions.Filter{Worker: q.Get("worker"), Limit: 100}
+	for _, arg := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if s := q.Get(arg.name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, arg.name+" must be a unix time in seconds", http.StatusBadRequest)
+				return
+			}
+			*arg.t = time.Unix(n, 0)
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	writeJSON(w, map[string]interface{}{"sessions": p.ss.Query(f)})
+}
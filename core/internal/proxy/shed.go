@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// methodShowMessage asks the miner to display a message to its operator
+const methodShowMessage = "client.show_message"
+
+// ShedConfig disconnects low priority clients first when the proxy nears
+// proxy.max_clients or the upstream write queue backs up
+type ShedConfig struct {
+	Enabled bool           `json:"enabled"`
+	Tiers   []PriorityTier `json:"tiers"`
+	// DefaultPriority applies to clients no tier matches; default 0
+	DefaultPriority int `json:"default_priority"`
+	// ClientsHighWater is the fraction of proxy.max_clients connected at
+	// which clients are shed; default 0.95
+	ClientsHighWater float64 `json:"clients_high_water"`
+	// QueueHighWater is the fraction of upstream_writer.queue_size waiting at
+	// which clients of that upstream are shed; default 0.8
+	QueueHighWater float64 `json:"queue_high_water"`
+	PerCheck       int     `json:"per_check"`   // clients shed per check; default 1
+	IntervalMs     int     `json:"interval_ms"` // check interval; default 1000
+	// Message is shown to shed miners with client.show_message
+	Message string `json:"message"`
+}
+
+// PriorityTier classifies clients by worker name prefix or listener. The
+// first matching tier wins; higher priorities are shed later.
+type PriorityTier struct {
+	Name           string   `json:"name"`
+	Priority       int      `json:"priority"`
+	WorkerPrefixes []string `json:"worker_prefixes"`
+	Listeners      []string `json:"listeners"` // names from listeners
+	Protected      bool     `json:"protected"` // never shed
+}
+
+// Validate checks the thresholds and tier names
+func (c ShedConfig) Validate() error {
+	if c.ClientsHighWater < 0 || c.ClientsHighWater > 1 || c.QueueHighWater < 0 || c.QueueHighWater > 1 {
+		return errors.New("clients_high_water and queue_high_water must be between 0 and 1")
+	}
+	if c.PerCheck < 0 || c.IntervalMs < 0 {
+		return errors.New("per_check and interval_ms must not be negative")
+	}
+	seen := map[string]bool{}
+	for _, t := range c.Tiers {
+		if t.Name == "" || seen[t.Name] {
+			return fmt.Errorf("tier names must be set and unique (%q)", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return nil
+}
+
+func (c ShedConfig) clientsHighWater() float64 {
+	if c.ClientsHighWater <= 0 {
+		return 0.95
+	}
+	return c.ClientsHighWater
+}
+
+func (c ShedConfig) queueHighWater() float64 {
+	if c.QueueHighWater <= 0 {
+		return 0.8
+	}
+	return c.QueueHighWater
+}
+
+func (c ShedConfig) perCheck() int {
+	if c.PerCheck <= 0 {
+		return 1
+	}
+	return c.PerCheck
+}
+
+func (c ShedConfig) interval() time.Duration {
+	if c.IntervalMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.IntervalMs) * time.Millisecond
+}
+
+func (c ShedConfig) message() string {
+	if c.Message == "" {
+		return "Server overloaded, please reconnect later"
+	}
+	return c.Message
+}
+
+// classify returns the tier of a client, "default" when none matches
+func (c ShedConfig) classify(worker, listener string) PriorityTier {
+	for _, t := range c.Tiers {
+		if listener != "" && slices.Contains(t.Listeners, listener) {
+			return t
+		}
+		for _, prefix := range t.WorkerPrefixes {
+			if worker != "" && strings.HasPrefix(worker, prefix) {
+				return t
+			}
+		}
+	}
+	return PriorityTier{Name: "default", Priority: c.DefaultPriority}
+}
+
+// shedCandidate is a client that may be shed
+type shedCandidate struct {
+	px   *Proxy
+	cl   *Client
+	tier PriorityTier
+}
+
+// ShedLoop sheds clients while the proxy is saturated
+func (p *Proxy) ShedLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config().Shedding.interval()):
+		}
+		if p.config().Shedding.Enabled {
+			p.shed()
+		}
+	}
+}
+
+// shed disconnects up to per_check of the lowest priority clients of the
+// saturated proxies and returns how many it shed
+func (p *Proxy) shed() int {
+	pc := p.config()
+	cfg := pc.Shedding
+	all := append([]*Proxy{p}, p.profileList()...)
+
+	var active int64
+	for _, px := range all {
+		active += px.mx.ClientsActive.Load()
+	}
+	reason := ""
+	var saturated []*Proxy
+	if max := pc.Proxy.MaxClients; max > 0 && float64(active) >= cfg.clientsHighWater()*float64(max) {
+		reason, saturated = "clients", all
+	} else {
+		for _, px := range all {
+			if ws := px.up.GetWriterStats(); ws.Capacity > 0 && float64(ws.Queued) >= cfg.queueHighWater()*float64(ws.Capacity) {
+				reason = "upstream_queue"
+				saturated = append(saturated, px)
+			}
+		}
+	}
+	if reason == "" {
+		return 0
+	}
+
+	var cands []shedCandidate
+	for _, px := range saturated {
+		px.clMu.RLock()
+		for cl := range px.clients {
+			listener := ""
+			if cl.ln != nil {
+				listener = cl.ln.cfg.Name
+			}
+			t := cfg.classify(cl.GetWorker(), listener)
+			if !t.Protected && !cl.shed.Load() {
+				cands = append(cands, shedCandidate{px: px, cl: cl, tier: t})
+			}
+		}
+		px.clMu.RUnlock()
+	}
+	// lowest priority first, newest connection first within a tier
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].tier.Priority != cands[j].tier.Priority {
+			return cands[i].tier.Priority < cands[j].tier.Priority
+		}
+		return cands[i].cl.connected.After(cands[j].cl.connected)
+	})
+	if len(cands) > cfg.perCheck() {
+		cands = cands[:cfg.perCheck()]
+	}
+	for _, c := range cands {
+		c.px.shedClient(c.cl, c.tier.Name, reason, cfg.message())
+	}
+	return len(cands)
+}
+
+// shedClient tells the miner why and disconnects it once the message had a
+// moment to be written
+func (p *Proxy) shedClient(cl *Client, tier, reason, message string) {
+	cl.shed.Store(true)
+	p.kick(cl, reasonShed)
+	log.Printf("shedding client %s worker=%s session=%s tier=%s: %s saturated", cl.addr, cl.GetWorker(), cl.session, tier, reason)
+	p.mx.IncrementClientsShed(tier)
+	p.emit(events.ClientShed, map[string]interface{}{"worker": cl.GetWorker(), "addr": cl.addr, "session": cl.session, "tier": tier, "reason": reason})
+	_ = cl.WriteJSON(stratum.Message{Method: methodShowMessage, Params: []interface{}{message}})
+	time.AfterFunc(500*time.Millisecond, func() { _ = cl.Close() })
+}
+
+// shedStats counts the connected clients of each priority tier for /status
+func (p *Proxy) shedStats() map[string]interface{} {
+	tiers := map[string]int{}
+	var shed uint64
+	for _, px := range append([]*Proxy{p}, p.profileList()...) {
+		px.clMu.RLock()
+		for cl := range px.clients {
+			listener := ""
+			if cl.ln != nil {
+				listener = cl.ln.cfg.Name
+			}
+			tiers[p.config().Shedding.classify(cl.GetWorker(), listener).Name]++
+		}
+		px.clMu.RUnlock()
+		shed += px.mx.ClientsShed.Load()
+	}
+	return map[string]interface{}{"tiers": tiers, "shed": shed}
+}
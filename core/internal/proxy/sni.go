@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ProfileConfig is an alternative upstream set that SNI routes can select
+type ProfileConfig struct {
+	Upstream UpstreamConfig   `json:"upstream"`
+	Backups  []UpstreamConfig `json:"backups"`
+}
+
+// SNIRoute maps a TLS server name to a certificate and an upstream profile
+type SNIRoute struct {
+	ServerName string `json:"server_name"` // exact name or "*.example.com"
+	CertFile   string `json:"cert_file"`   // optional; defaults to proxy.tls cert
+	KeyFile    string `json:"key_file"`
+	Profile    string `json:"profile"` // empty routes to the main upstream
+}
+
+// matches reports whether the route applies to a client's server name
+func (r SNIRoute) matches(serverName string) bool {
+	name := strings.ToLower(serverName)
+	pattern := strings.ToLower(r.ServerName)
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return strings.HasSuffix(name, suffix) && !strings.Contains(strings.TrimSuffix(name, suffix), ".")
+	}
+	return name == pattern
+}
+
+// routeFor returns the first SNI route matching serverName, if any
+func (p *Proxy) routeFor(serverName string) *SNIRoute {
+	cfg := p.config()
+	if serverName == "" {
+		return nil
+	}
+	for i := range cfg.SNIRoutes {
+		if cfg.SNIRoutes[i].matches(serverName) {
+			return &cfg.SNIRoutes[i]
+		}
+	}
+	return nil
+}
+
+// profileConfig derives a profile's config from the main config. Features
+// that own files or listeners stay with the main proxy.
+func profileConfig(cfg *Config, pc ProfileConfig) *Config {
+	c := *cfg
+	c.Upstream = pc.Upstream
+	c.Backups = pc.Backups
+	c.Profiles = nil
+	c.SNIRoutes = nil
+	c.Listeners = nil
+	c.Solo.Enabled = false
+	c.Canary.Enabled = false
+	c.Availability.Enabled = false
+	c.Availability.StateFile = ""
+	c.VarDiff.StateFile = ""
+	c.Fee.Enabled = false
+	c.Schedule.Enabled = false
+	c.Mirror.Enabled = false
+	return &c
+}
+
+// newProfiles creates one proxy per upstream profile. Profiles share the main
+// rate limiter, admission controller, share journal, allocation audit,
+// traffic capture, metrics history, hashrate meter, client groups, worker
+// registry, worker pins and event dispatcher; connections reach them through dispatch.
+func (p *Proxy) newProfiles() {
+	cfg := p.config()
+	for name, pc := range cfg.Profiles {
+		sub := NewProxy(profileConfig(cfg, pc))
+		sub.name = name
+		sub.rl = p.rl
+		sub.adm = p.adm
+		sub.jr = p.jr
+		sub.au = p.au
+		sub.tap = p.tap
+		sub.hist = p.hist
+		sub.grp = p.grp
+		sub.ss = p.ss
+		sub.hr = p.hr
+		sub.wr = p.wr
+		sub.ev = p.ev
+		sub.pins = p.pins
+		p.profiles[name] = sub
+	}
+}
+
+// RunProfiles starts the upstream, selection, pending request and vardiff
+// loops of every profile
+func (p *Proxy) RunProfiles(ctx context.Context) {
+	for _, sub := range p.profiles {
+		go sub.UpstreamManager(ctx)
+		go sub.SelectionLoop(ctx)
+		go sub.PendingLoop(ctx)
+		if sub.config().VarDiff.Enabled {
+			go sub.VarDiffLoop(ctx)
+		}
+	}
+}
+
+// reloadProfiles applies a new config to the existing profiles
+func (p *Proxy) reloadProfiles(newCfg *Config, rep *ReloadReport) {
+	for name, sub := range p.profiles {
+		pc, ok := newCfg.Profiles[name]
+		if !ok {
+			rep.restart("profile %s removed", name)
+			continue
+		}
+		rep.merge(name, sub.Reload(profileConfig(newCfg, pc)))
+	}
+	for name := range newCfg.Profiles {
+		if _, ok := p.profiles[name]; !ok {
+			rep.restart("profile %s added", name)
+		}
+	}
+}
+
+// listenerTLSConfig builds the downstream TLS config, selecting certificates
+// by SNI when routes provide their own. Without cert_file the default
+// certificate comes from ACME.
+func (p *Proxy) listenerTLSConfig() (*tls.Config, error) {
+	cfg := p.config()
+	def := p.acmeCertificate
+	if p.acme == nil || cfg.Proxy.TLS.Cert != "" {
+		cf, err := loadCertFile(cfg.Proxy.TLS.Cert, cfg.Proxy.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+		p.defCert, def = cf, cf.get
+	}
+	p.sniCerts = make([]*certFile, len(cfg.SNIRoutes))
+	for i, r := range cfg.SNIRoutes {
+		if r.CertFile == "" {
+			continue
+		}
+		cf, err := loadCertFile(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.ServerName, err)
+		}
+		p.sniCerts[i] = cf
+	}
+	certs := p.sniCerts
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			for i, r := range p.config().SNIRoutes {
+				if i < len(certs) && certs[i] != nil && r.matches(hello.ServerName) {
+					return certs[i].get(hello)
+				}
+			}
+			return def(hello)
+		},
+	}, nil
+}
+
+// dispatch completes the TLS handshake to learn the server name and hands
+// the connection to the matching profile
+func (p *Proxy) dispatch(ctx context.Context, conn net.Conn) {
+	target := p
+	if tc, ok := conn.(*tls.Conn); ok {
+		hctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := tc.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			log.Printf("rejecting client %s: tls handshake: %v", conn.RemoteAddr(), err)
+			p.rl.ReleaseConnection(conn.RemoteAddr())
+			_ = conn.Close()
+			return
+		}
+		if r := p.routeFor(tc.ConnectionState().ServerName); r != nil && r.Profile != "" {
+			if sub, ok := p.profiles[r.Profile]; ok {
+				target = sub
+			}
+		}
+	}
+	target.admit(ctx, conn, nil)
+}
+
+// profileStats summarizes each profile for /status
+func (p *Proxy) profileStats() map[string]interface{} {
+	out := make(map[string]interface{}, len(p.profiles))
+	for name, sub := range p.profiles {
+		out[name] = map[string]interface{}{
+			"upstream":       sub.mx.UpConnected.Load(),
+			"upstream_state": sub.mx.UpstreamState(),
+			"upstream_host":  sub.config().Upstream.Host,
+			"clients":        sub.mx.ClientsActive.Load(),
+			"shares_ok":      sub.mx.SharesOK.Load(),
+			"shares_bad":     sub.mx.SharesBad.Load(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/metrics"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/solo"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// SoloConfig holds settings for mining directly against a bitcoind node
+type SoloConfig struct {
+	Enabled         bool    `json:"enabled"`
+	RPCURL          string  `json:"rpc_url"`
+	RPCUser         string  `json:"rpc_user"`
+	RPCPass         string  `json:"rpc_pass"`
+	PayoutAddress   string  `json:"payout_address"`
+	PollIntervalMs  int     `json:"poll_interval_ms"`
+	ShareDifficulty float64 `json:"share_difficulty"`
+	// CoinbaseTag signs found blocks; default "/karoo/"
+	CoinbaseTag string `json:"coinbase_tag"`
+}
+
+// soloRouting adapts the solo backend to the router's local backend hook
+type soloRouting struct {
+	p *Proxy
+}
+
+// Authorize accepts any worker; payouts go to the configured address
+func (s soloRouting) Authorize(cl routing.Client, worker, password string) bool {
+	log.Printf("solo: worker authorized: %s (%s session=%s)", worker, cl.GetAddr(), routing.SessionOf(cl))
+	return true
+}
+
+// Submit checks the share against the client's current difficulty
+func (s soloRouting) Submit(cl routing.Client, params []any) error {
+	return s.p.solo.Submit(context.Background(), params, s.p.shareDifficulty(cl))
+}
+
+// shareDifficulty returns the difficulty a client is currently mining at
+func (p *Proxy) shareDifficulty(cl routing.Client) float64 {
+	cfg := p.config()
+	if cfg.VarDiff.Enabled {
+		if stats := p.vd.GetClientStats(cl); stats != nil {
+			return stats.CurrentDifficulty
+		}
+	}
+	if cfg.Aggregate.Enabled {
+		return cfg.Aggregate.ShareDifficulty
+	}
+	return cfg.Solo.ShareDifficulty
+}
+
+// SoloLoop polls the node for block templates and feeds the resulting jobs
+// to clients as if they came from an upstream pool
+func (p *Proxy) SoloLoop(ctx context.Context) {
+	defer func() {
+		p.mx.SetUpstreamState(metrics.UpstreamDisconnected)
+		p.nm.Reset()
+	}()
+
+	ex1, ex2Size := p.solo.Extranonce()
+	ready := false
+	publish := func(job *solo.Job) {
+		if !ready {
+			p.nm.ProcessSubscribeResult([]interface{}{nil, ex1, float64(ex2Size)})
+			p.mx.SetUpstreamState(metrics.UpstreamConnected)
+			ready = true
+		}
+		// without vardiff every clean job re-announces the fixed share difficulty
+		if cfg := p.config(); job.Clean && !cfg.VarDiff.Enabled {
+			p.broadcastMessage(stratum.NewSetDifficultyMessage(cfg.Solo.ShareDifficulty))
+		}
+		p.broadcastMessage(job.NotifyMessage())
+	}
+
+	for ctx.Err() == nil {
+		err := p.solo.Run(ctx, publish)
+		if err == nil {
+			return
+		}
+		if ready {
+			p.mx.SetUpstreamState(metrics.UpstreamDisconnected)
+			p.nm.Reset()
+			ready = false
+		}
+		d := connection.Backoff(time.Second, 30*time.Second)
+		log.Printf("solo: node error: %v; retry in %s", err, d)
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+	}
+}
+
+// broadcastMessage routes a locally generated notification to all clients
+func (p *Proxy) broadcastMessage(msg stratum.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("solo: encoding %s: %v", msg.Method, err)
+		return
+	}
+	_, _ = p.rt.ProcessUpstreamLine(data)
+}
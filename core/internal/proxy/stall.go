@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/connection"
+)
+
+// notifyStalled reports whether the pool has gone without a mining.notify
+// for keepalive.upstream_notify_seconds; a connection made at since that has
+// not received one yet counts from since
+func (p *Proxy) notifyStalled(since, now time.Time) bool {
+	timeout := p.config().Keepalive.NotifyTimeout()
+	if timeout == 0 {
+		return false
+	}
+	last := p.mx.GetLastNotify()
+	if last.Before(since) {
+		last = since
+	}
+	return now.Sub(last) >= timeout
+}
+
+// refreshJob re-sends the last job of the connection made at since once the
+// pool has gone keepalive.upstream_refresh_seconds without a mining.notify,
+// and again every interval until one arrives. It reports whether a job was
+// sent.
+func (p *Proxy) refreshJob(since, now, lastRefresh time.Time) bool {
+	every := p.config().Keepalive.RefreshInterval()
+	last := p.rt.LastJob()
+	if every == 0 || last.Before(since) {
+		p.mx.SetNotifyGap(false)
+		return false
+	}
+	gap := now.Sub(last) >= every
+	p.mx.SetNotifyGap(gap)
+	if !gap || now.Sub(lastRefresh) < every || !p.rt.RefreshJob(now) {
+		return false
+	}
+	log.Printf("upstream sent no mining.notify for %s; re-sent the last job with a rolled ntime", now.Sub(last).Round(time.Second))
+	p.mx.IncrementJobRefreshes()
+	return true
+}
+
+// pingParams returns the params of the keepalive request
+func (p *Proxy) pingParams(method string) []interface{} {
+	if method == connection.PingMethodSuggest {
+		return []interface{}{p.mx.GetLastSetDifficulty()}
+	}
+	return []interface{}{}
+}
+
+// upstreamWatchdog keeps the pool connection made at since alive with
+// keepalive requests when it is idle, and drops it once the pool stops
+// sending jobs or answering them, so UpstreamLoop reconnects or fails over.
+// It returns when ctx is done.
+func (p *Proxy) upstreamWatchdog(ctx context.Context, since time.Time) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	defer p.mx.SetNotifyGap(false)
+	var lastRefresh time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			if p.refreshJob(since, now, lastRefresh) {
+				lastRefresh = now
+			}
+			ka := p.config().Keepalive
+			reason := ""
+			switch {
+			case p.notifyStalled(since, now):
+				log.Printf("upstream sent no mining.notify for %s; reconnecting", ka.NotifyTimeout())
+				reason = "no_notify"
+			case ka.PingInterval() > 0 && p.up.PingOverdue(now, ka.PingTimeout()):
+				log.Printf("upstream did not answer %s within %s; reconnecting", ka.PingMethod(), ka.PingTimeout())
+				reason = "ping"
+			case ka.PingInterval() > 0 && p.up.IdleFor(now) >= ka.PingInterval():
+				if err := p.up.Ping(ka.PingMethod(), p.pingParams(ka.PingMethod()), now); err != nil {
+					log.Printf("upstream keepalive: %v", err)
+				}
+			}
+			if reason != "" {
+				p.mx.IncrementUpstreamStalls(reason)
+				p.up.Close()
+				return
+			}
+		}
+	}
+}
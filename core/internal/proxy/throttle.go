@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+	"github.com/carlosrabelo/karoo/core/internal/ratelimit"
+	"github.com/carlosrabelo/karoo/core/internal/routing"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/throttle"
+)
+
+// throttleSubmit counts a submit against the share throttle and reports
+// whether it was refused, either because the client is muted or because it
+// was just muted or banned
+func (p *Proxy) throttleSubmit(cl *Client, msg stratum.Message) bool {
+	v := p.th.Submit(cl.addr, time.Now())
+	if v.Action == throttle.ActionMute && v.Reason == "" {
+		_ = cl.WriteJSON(stratum.ErrorResponseFor(msg.ID, stratum.ErrThrottled))
+		return true
+	}
+	switch p.throttle(cl, v) {
+	case throttle.ActionMute:
+		_ = cl.WriteJSON(stratum.ErrorResponseFor(msg.ID, stratum.ErrThrottled))
+		return true
+	case throttle.ActionBan:
+		return true
+	}
+	return false
+}
+
+// throttleResult counts a share result against the share throttle
+func (p *Proxy) throttleResult(ev routing.ShareEvent) {
+	cl, ok := ev.Client.(*Client)
+	if !ok {
+		return
+	}
+	p.throttle(cl, p.th.Result(cl.addr, ev.Accepted, ev.Time))
+}
+
+// throttle applies a throttle verdict to a client and returns the action
+// taken. A difficulty that cannot go higher moves straight to the next step.
+func (p *Proxy) throttle(cl *Client, v throttle.Verdict) string {
+	cfg := p.config()
+	data := map[string]interface{}{"addr": cl.addr, "worker": cl.GetWorker(), "session": cl.session, "reason": v.Reason}
+	if v.Action == throttle.ActionEscalate {
+		diff, ok := p.vd.RaiseDifficulty(cl, cfg.Throttle.Factor())
+		if ok {
+			data["difficulty"] = diff
+		} else {
+			v = p.th.Skip(cl.addr, v, time.Now())
+		}
+	}
+
+	switch v.Action {
+	case throttle.ActionNone:
+		return v.Action
+	case throttle.ActionEscalate:
+		log.Printf("throttle: raised %s worker=%s session=%s to difficulty %g: %s", cl.addr, cl.GetWorker(), cl.session, data["difficulty"], v.Reason)
+	case throttle.ActionMute:
+		log.Printf("throttle: muted %s worker=%s session=%s for %s: %s", cl.addr, cl.GetWorker(), cl.session, cfg.Throttle.MuteDuration(), v.Reason)
+	case throttle.ActionBan:
+		log.Printf("throttle: banning %s worker=%s session=%s: %s", cl.addr, cl.GetWorker(), cl.session, v.Reason)
+		p.throttleBan(cl, v.Reason)
+	}
+	data["action"] = v.Action
+	p.mx.IncrementThrottleActions(v.Action)
+	p.emit(events.ClientThrottled, data)
+	return v.Action
+}
+
+// throttleBan bans the client's address, or its worker name when configured
+// and known, and disconnects every client the ban covers
+func (p *Proxy) throttleBan(cl *Client, reason string) {
+	cfg := p.config()
+	d := cfg.Throttle.BanDuration()
+	if w := cl.GetWorker(); w != "" && cfg.Throttle.BanTarget() == throttle.BanWorker {
+		p.rl.BanWorkerFrom(ratelimit.SourceShares, w, reason, d)
+	} else if _, err := p.rl.BanFrom(ratelimit.SourceShares, hostOf(cl.addr), reason, d); err != nil {
+		log.Printf("throttle: could not ban %s session=%s: %v", cl.addr, cl.session, err)
+	}
+	p.ban(cl, reason)
+	p.kickBanned()
+	_ = cl.Close()
+}
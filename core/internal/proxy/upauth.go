@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/events"
+)
+
+// UpstreamAuthConfig controls what happens when a pool refuses the proxy's
+// credentials
+type UpstreamAuthConfig struct {
+	// BackoffMaxMs caps the wait, which starts at the upstream's
+	// auth_backoff_ms and doubles with every consecutive refusal; default
+	// 600000
+	BackoffMaxMs int `json:"backoff_max_ms"`
+	// SwitchBackup moves to the next upstream whose user or password
+	// differ instead of retrying the one that refused them
+	SwitchBackup bool `json:"switch_backup"`
+}
+
+// Validate checks the cap
+func (c UpstreamAuthConfig) Validate() error {
+	if c.BackoffMaxMs < 0 {
+		return errors.New("backoff_max_ms must not be negative")
+	}
+	return nil
+}
+
+// backoff returns the wait after the n-th consecutive refusal
+func (c UpstreamAuthConfig) backoff(base time.Duration, n int) time.Duration {
+	max := 10 * time.Minute
+	if c.BackoffMaxMs > 0 {
+		max = time.Duration(c.BackoffMaxMs) * time.Millisecond
+	}
+	d := base
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// authFailure describes the pool refusing the proxy's credentials; /status
+// shows it until an authorize succeeds
+type authFailure struct {
+	Upstream   string    `json:"upstream"`
+	User       string    `json:"user"`
+	Rejections int       `json:"rejections"` // consecutive
+	Since      time.Time `json:"since"`
+	RetryAt    time.Time `json:"retry_at"`
+}
+
+// nextCredentials returns the first upstream after idx whose user or
+// password differ from those at idx, or idx when there is none
+func nextCredentials(configs []UpstreamConfig, idx int) int {
+	for i := 1; i < len(configs); i++ {
+		j := (idx + i) % len(configs)
+		if configs[j].User != configs[idx].User || configs[j].Pass != configs[idx].Pass {
+			return j
+		}
+	}
+	return idx
+}
+
+// authRejected records that the upstream at idx refused authorize and
+// returns the index to try next and how long to wait first
+func (p *Proxy) authRejected(configs []UpstreamConfig, idx int, now time.Time) (int, time.Duration) {
+	cfg := p.config()
+	uc := configs[idx]
+	p.mx.IncrementUpstreamAuthRejections()
+	f := authFailure{Upstream: uc.addr(), User: uc.User, Rejections: 1, Since: now}
+	if prev := p.authFail.Load(); prev != nil {
+		f.Rejections, f.Since = prev.Rejections+1, prev.Since
+	}
+	d := cfg.UpstreamAuth.backoff(uc.authBackoff(), f.Rejections)
+	f.RetryAt = now.Add(d)
+	p.authFail.Store(&f)
+
+	next := idx
+	if cfg.UpstreamAuth.SwitchBackup {
+		next = nextCredentials(configs, idx)
+	}
+	log.Printf("UPSTREAM REFUSED CREDENTIALS: %s rejected authorize for %s (%d in a row); retrying idx=%d in %s",
+		uc.addr(), uc.User, f.Rejections, next, d)
+	if f.Rejections == 1 {
+		data := upstreamEvent(idx, uc)
+		data["user"] = uc.User
+		p.emit(events.UpstreamAuthFailed, data)
+	}
+	return next, d
+}
+
+// authAccepted clears the failure once an upstream authorized the proxy
+func (p *Proxy) authAccepted() {
+	if f := p.authFail.Swap(nil); f != nil {
+		log.Printf("upstream accepted the credentials after %d refusal(s)", f.Rejections)
+	}
+}
+
+// authWait sleeps d after a refusal. A reload that changes the refused
+// entry, such as fixed credentials, ends the wait early.
+func (p *Proxy) authWait(ctx context.Context, fee bool, idx int, refused UpstreamConfig, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-ticker.C:
+			configs := p.upstreamConfigs(fee)
+			if idx >= len(configs) || !reflect.DeepEqual(configs[idx], refused) {
+				log.Printf("upstream %s changed; retrying authorize now", refused.addr())
+				return
+			}
+		}
+	}
+}
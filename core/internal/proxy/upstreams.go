@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"math"
+	"slices"
+
+	"github.com/carlosrabelo/karoo/core/internal/metrics"
+)
+
+// Upstream roles in /status
+const (
+	rolePrimary   = "primary"
+	roleBackup    = "backup"
+	roleScheduled = "scheduled"
+	roleFee       = "fee"
+)
+
+// clientView is one client in /status
+type clientView struct {
+	IP      string            `json:"ip"`
+	Session string            `json:"session"`
+	Worker  string            `json:"worker"`
+	Group   string            `json:"group,omitempty"`
+	UpUser  string            `json:"upstream_user"`
+	OK      uint64            `json:"ok"`
+	Bad     uint64            `json:"bad"`
+	Rejects map[string]uint64 `json:"rejects,omitempty"`
+	Invalid uint64            `json:"invalid_lines,omitempty"`
+	Queued  int               `json:"queued"`
+	ReqDiff float64           `json:"requested_difficulty,omitempty"`
+}
+
+// clientViews lists the clients of p for /status
+func (p *Proxy) clientViews() []clientView {
+	p.clMu.RLock()
+	defer p.clMu.RUnlock()
+	clv := make([]clientView, 0, len(p.clients))
+	for cl := range p.clients {
+		clv = append(clv, clientView{
+			IP:      cl.addr,
+			Session: cl.session,
+			Worker:  cl.worker,
+			Group:   p.workerGroup(cl.worker),
+			UpUser:  cl.upUser,
+			OK:      cl.ok.Load(),
+			Bad:     cl.bad.Load(),
+			Rejects: cl.getRejects(),
+			Invalid: cl.invalid.Load(),
+			Queued:  cl.queued(),
+			ReqDiff: math.Float64frombits(cl.reqDiff.Load()),
+		})
+	}
+	return clv
+}
+
+// upstreamView is one upstream of a route in /status. The connected
+// upstream carries the extranonce and job state and the route's clients.
+type upstreamView struct {
+	Route           string                   `json:"route,omitempty"`
+	Role            string                   `json:"role"`
+	Host            string                   `json:"host"`
+	Active          bool                     `json:"active"`
+	State           string                   `json:"state"`
+	SharesOK        uint64                   `json:"shares_ok"`
+	SharesBad       uint64                   `json:"shares_bad"`
+	Latency         *metrics.UpstreamLatency `json:"latency,omitempty"`
+	Extranonce1     string                   `json:"extranonce1,omitempty"`
+	Extranonce2Size int                      `json:"extranonce2_size,omitempty"`
+	LastNotifyUnix  int64                    `json:"last_notify_unix,omitempty"`
+	Clients         []clientView             `json:"clients"`
+}
+
+// upstreamViews lists the upstreams of the main proxy and then of each
+// profile, each route's in the order they are tried
+func (p *Proxy) upstreamViews() []upstreamView {
+	out := p.routeUpstreams("")
+	names := make([]string, 0, len(p.profiles))
+	for name := range p.profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		out = append(out, p.profiles[name].routeUpstreams(name)...)
+	}
+	return out
+}
+
+// routeUpstreams lists the upstreams p may connect to. The one it is
+// connected to is active; the others are standing by for failover.
+func (p *Proxy) routeUpstreams(route string) []upstreamView {
+	cfg := p.config()
+	type entry struct {
+		role string
+		cfg  UpstreamConfig
+	}
+	var entries []entry
+	if cfg.Fee.Enabled && cfg.Fee.mode() == FeeModeTimeslice {
+		entries = append(entries, entry{roleFee, cfg.Fee.Upstream})
+	}
+	if w := p.scheduledWindow(); w != nil && w.Upstream != nil {
+		entries = append(entries, entry{roleScheduled, *w.Upstream})
+	}
+	entries = append(entries, entry{rolePrimary, cfg.Upstream})
+	for _, b := range cfg.Backups {
+		entries = append(entries, entry{roleBackup, b})
+	}
+
+	latency := make(map[string]metrics.UpstreamLatency)
+	for _, l := range p.mx.UpstreamLatencies() {
+		latency[l.Upstream] = l
+	}
+	out := make([]upstreamView, 0, len(entries))
+	found := false
+	for _, e := range entries {
+		v := upstreamView{Route: route, Role: e.role, Host: e.cfg.addr(), State: "standby", Clients: []clientView{}}
+		if l, ok := latency[v.Host]; ok {
+			v.Latency = &l
+			v.SharesOK, v.SharesBad = l.SharesOK, l.SharesBad
+			// the same address may be listed twice, e.g. a backup with
+			// another account; the first one is taken as connected
+			if l.Active && !found {
+				found = true
+				ex := p.up.ExtranonceState()
+				v.Active = true
+				v.State = p.mx.UpstreamState()
+				v.Extranonce1, v.Extranonce2Size = ex.Ex1, ex.Ex2Size
+				v.LastNotifyUnix = p.mx.LastNotifyUnix.Load()
+				v.Clients = p.clientViews()
+			}
+		}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/workers"
+)
+
+// applyWorkerProfile applies a registry entry once a client names its worker
+func (p *Proxy) applyWorkerProfile(cl *Client) {
+	w, ok := p.wr.Get(cl.GetWorker())
+	if !ok || w.Difficulty <= 0 {
+		return
+	}
+	p.vd.PinDifficulty(cl, w.Difficulty)
+	log.Printf("worker %s: static difficulty %.6g", w.Name, w.Difficulty)
+}
+
+// applyPasswordDifficulty honors a d=N difficulty request in the authorize
+// password, bounding vardiff as vardiff.password_difficulty says
+func (p *Proxy) applyPasswordDifficulty(cl *Client, msg stratum.Message) {
+	bound := p.config().VarDiff.PasswordDifficulty
+	if bound == "" || bound == "off" {
+		return
+	}
+	arr, _ := msg.Params.([]any)
+	if len(arr) < 2 {
+		return
+	}
+	pass, _ := arr[1].(string)
+	want, ok := stratum.PasswordDifficulty(pass)
+	if !ok {
+		return
+	}
+	diff, ok := p.vd.RequestDifficulty(cl, want, bound)
+	if !ok {
+		return
+	}
+	cl.reqDiff.Store(math.Float64bits(diff))
+	log.Printf("worker %s: requested difficulty %.6g (%s %.6g)", cl.GetWorker(), want, bound, diff)
+}
+
+// suggestDifficulty answers mining.suggest_difficulty locally: the pool's
+// answer would apply to the whole proxy connection, not to this client.
+// With vardiff the suggestion becomes the client's difficulty, clamped to
+// the configured bounds.
+func (p *Proxy) suggestDifficulty(cl *Client, msg stratum.Message) {
+	_ = cl.WriteJSON(stratum.NewSuccessResponse(msg.ID, true))
+	arr, _ := msg.Params.([]any)
+	if len(arr) == 0 {
+		return
+	}
+	want, _ := arr[0].(float64)
+	if !(want > 0) {
+		return
+	}
+	if diff, ok := p.vd.SuggestDifficulty(cl, want); ok {
+		log.Printf("client %s session=%s: suggested difficulty %.6g (applied %.6g)", cl.addr, cl.session, want, diff)
+	}
+}
+
+// handleVarDiff explains the vardiff state of a worker's clients: their
+// share window, recent difficulty changes and when the next retarget is due
+func (p *Proxy) handleVarDiff(w http.ResponseWriter, r *http.Request) {
+	if !p.config().VarDiff.Enabled {
+		http.Error(w, "vardiff is disabled", http.StatusNotFound)
+		return
+	}
+	worker := strings.TrimPrefix(r.URL.Path, "/vardiff/")
+	if worker == "" {
+		http.Error(w, "usage: /vardiff/{worker}", http.StatusBadRequest)
+		return
+	}
+	clients := p.vd.Explain(worker)
+	if len(clients) == 0 {
+		http.Error(w, "worker not connected", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"worker": worker, "clients": clients})
+}
+
+// workerGroup returns the registry group of a worker, if any
+func (p *Proxy) workerGroup(name string) string {
+	w, _ := p.wr.Get(name)
+	return w.Group
+}
+
+// registerWorkerHandlers adds the worker registry admin endpoint. GET exports
+// the registry (?format=json|csv); POST imports a body in that format,
+// merging by name unless ?mode=replace, and persists it to workers.file.
+func (p *Proxy) registerWorkerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/workers", p.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = workers.FormatCSV
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if format == workers.FormatCSV {
+				w.Header().Set("Content-Type", "text/csv")
+				w.Header().Set("Content-Disposition", `attachment; filename="workers.csv"`)
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			if err := p.wr.Export(w, format); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		case http.MethodPost:
+			list, err := workers.Parse(r.Body, format)
+			if err != nil {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			replace := r.URL.Query().Get("mode") == "replace"
+			added, updated, err := p.wr.Import(list, replace)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := p.wr.Save(); err != nil {
+				http.Error(w, fmt.Sprintf("saving registry: %v", err), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("admin: imported workers added=%d updated=%d replace=%v", added, updated, replace)
+			writeJSON(w, map[string]int{"added": added, "updated": updated, "total": len(p.wr.List())})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
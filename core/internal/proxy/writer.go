@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/capture"
+	"github.com/carlosrabelo/karoo/core/internal/metrics"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// ClientQueueConfig bounds the outbound queue of each client
+type ClientQueueConfig struct {
+	Size           int `json:"size"`             // messages; default 256
+	WriteTimeoutMs int `json:"write_timeout_ms"` // per flush; default 10000
+}
+
+// size returns the queue capacity
+func (c ClientQueueConfig) size() int {
+	if c.Size <= 0 {
+		return 256
+	}
+	return c.Size
+}
+
+// timeout returns how long one flush to the client may block
+func (c ClientQueueConfig) timeout() time.Duration {
+	if c.WriteTimeoutMs <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.WriteTimeoutMs) * time.Millisecond
+}
+
+var (
+	errQueueFull    = errors.New("client write queue full")
+	errClientClosed = errors.New("client closed")
+)
+
+// clientQueue holds the frames waiting for a client's writer goroutine
+type clientQueue struct {
+	ch      chan *stratum.Frame
+	done    chan struct{}
+	timeout time.Duration
+	mx      *metrics.Collector
+
+	mu     sync.Mutex // orders sends against close
+	closed bool
+}
+
+// startWriter gives the client an outbound queue drained by its own
+// goroutine, so a slow client cannot hold up writes to the others. A client
+// whose queue overflows is disconnected. Without a queue writes go straight
+// to the connection.
+func (c *Client) startWriter(cfg ClientQueueConfig, mx *metrics.Collector) {
+	c.q = &clientQueue{
+		ch:      make(chan *stratum.Frame, cfg.size()),
+		done:    make(chan struct{}),
+		timeout: cfg.timeout(),
+		mx:      mx,
+	}
+	go c.writeLoop(c.q)
+}
+
+// preAuthHold keeps the latest difficulty and job meant for a client that
+// has not completed mining.authorize, since some miners misbehave when they
+// get work before their authorize answer
+type preAuthHold struct {
+	mu     sync.Mutex
+	diff   *stratum.Frame
+	notify *stratum.Frame
+}
+
+// holdPreAuth keeps f back when the client is not authorized yet and f is a
+// mining.set_difficulty or mining.notify, replacing the one held before.
+// Reports whether f was held.
+func (c *Client) holdPreAuth(f *stratum.Frame) bool {
+	c.preAuth.mu.Lock()
+	defer c.preAuth.mu.Unlock()
+	if c.handshakeDone.Load() {
+		return false
+	}
+	var head struct {
+		Method string `json:"method"`
+	}
+	_ = json.Unmarshal(f.Bytes(), &head)
+	var slot **stratum.Frame
+	switch head.Method {
+	case stratum.MethodSetDifficulty:
+		slot = &c.preAuth.diff
+	case stratum.MethodNotify:
+		slot = &c.preAuth.notify
+	default:
+		return false
+	}
+	if *slot != nil {
+		(*slot).Release()
+	}
+	f.Retain()
+	*slot = f
+	return true
+}
+
+// authorized marks the handshake done and sends the held difficulty and job,
+// in that order, before any later write reaches the client
+func (c *Client) authorized() {
+	c.preAuth.mu.Lock()
+	defer c.preAuth.mu.Unlock()
+	for _, f := range []*stratum.Frame{c.preAuth.diff, c.preAuth.notify} {
+		if f != nil {
+			_ = c.writeFrame(f)
+			f.Release()
+		}
+	}
+	c.preAuth.diff, c.preAuth.notify = nil, nil
+	c.handshakeDone.Store(true)
+}
+
+// WriteFrame queues a frame for the client, taking its own reference; the
+// caller keeps (and releases) its reference. Difficulty and jobs are held
+// until the client is authorized.
+func (c *Client) WriteFrame(f *stratum.Frame) error {
+	if !c.handshakeDone.Load() && c.holdPreAuth(f) {
+		return nil
+	}
+	return c.writeFrame(f)
+}
+
+// writeFrame queues a frame for the client without holding it back
+func (c *Client) writeFrame(f *stratum.Frame) error {
+	if c.cs != nil && c.cs.RewritesOutput() {
+		b := f.Bytes()
+		own := stratum.NewFrame(c.cs.Rewrite(b[:len(b)-1]))
+		defer own.Release()
+		f = own
+	}
+	if c.tap != nil && c.tap.Active() {
+		c.tap.Record(capture.ToClient, c.addr, c.worker, f.Bytes())
+	}
+	q := c.q
+	if q == nil {
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		if _, err := c.bw.Write(f.Bytes()); err != nil {
+			return err
+		}
+		return c.bw.Flush()
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errClientClosed
+	}
+	f.Retain()
+	select {
+	case q.ch <- f:
+		q.mu.Unlock()
+		q.mx.AddClientQueued(1)
+		return nil
+	default:
+	}
+	q.mu.Unlock()
+	f.Release()
+
+	q.mx.IncrementClientQueueOverflows()
+	log.Printf("client %s session=%s write queue full (%d messages); disconnecting", c.addr, c.session, cap(q.ch))
+	c.closing(reasonQueueFull)
+	_ = c.Close()
+	return errQueueFull
+}
+
+// writeLoop flushes queued lines to the connection, batching whatever has
+// accumulated, until the client is closed or a write fails
+func (c *Client) writeLoop(q *clientQueue) {
+	defer func() {
+		// close has stopped further sends; drop what is left
+		for {
+			select {
+			case f := <-q.ch:
+				f.Release()
+				q.mx.AddClientQueued(-1)
+			default:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-q.done:
+			return
+		case f := <-q.ch:
+			q.mx.AddClientQueued(-1)
+			_ = c.c.SetWriteDeadline(time.Now().Add(q.timeout))
+			_, err := c.bw.Write(f.Bytes())
+			f.Release()
+			for err == nil && len(q.ch) > 0 {
+				f = <-q.ch
+				q.mx.AddClientQueued(-1)
+				_, err = c.bw.Write(f.Bytes())
+				f.Release()
+			}
+			if err == nil {
+				err = c.bw.Flush()
+			}
+			if err != nil {
+				if !isNetClosed(err) {
+					log.Printf("client %s session=%s write error: %v", c.addr, c.session, err)
+					c.closing(reasonWriteError)
+				}
+				_ = c.Close()
+				return
+			}
+		}
+	}
+}
+
+// queued returns how many frames wait in the client's queue
+func (c *Client) queued() int {
+	if c.q == nil {
+		return 0
+	}
+	return len(c.q.ch)
+}
+
+// stopWriter stops the writer goroutine; later sends fail
+func (c *Client) stopWriter() {
+	q := c.q
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
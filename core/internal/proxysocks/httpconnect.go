@@ -0,0 +1,70 @@
+package proxysocks
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// connectDialer tunnels through an HTTP proxy with the CONNECT method
+type connectDialer struct {
+	proxyAddr string
+	username  string
+	password  string
+	forward   *net.Dialer
+}
+
+func (d *connectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext opens a tunnel to address through the proxy
+func (d *connectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	var br *bufio.Reader
+	if err := handshake(ctx, conn, func() error {
+		req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+		if d.username != "" {
+			cred := base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password))
+			req += "Proxy-Authorization: Basic " + cred + "\r\n"
+		}
+		if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+			return err
+		}
+		br = bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("http proxy refused %s: %s", address, resp.Status)
+		}
+		return nil
+	}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		// the pool spoke first and its bytes were read with the response
+		return &bufferedConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what was buffered during the handshake before the
+// rest of the connection
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
@@ -1,4 +1,5 @@
-// Package proxysocks provides SOCKS5 proxy support for Karoo
+// Package proxysocks provides SOCKS5, SOCKS4 and HTTP CONNECT proxy support
+// for Karoo
 package proxysocks
 
 import (
@@ -6,21 +7,36 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/proxy"
 )
 
+// Proxy types
+const (
+	TypeSOCKS5  = "socks5"
+	TypeSOCKS4  = "socks4"
+	TypeSOCKS4a = "socks4a"
+	TypeHTTP    = "http" // HTTP CONNECT
+)
+
 // Config holds SOCKS proxy configuration
 type Config struct {
 	Enabled  bool   `json:"enabled"`
-	Type     string `json:"type"` // must be "socks5"
+	Type     string `json:"type"` // "socks5", "socks4", "socks4a" or "http"
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	Username string `json:"username"` // optional authentication
 	Password string `json:"password"` // optional authentication
 }
 
+// ResolvesRemotely reports whether destination hostnames are passed to the
+// proxy instead of resolved locally, as .onion addresses require
+func (c *Config) ResolvesRemotely() bool {
+	return c.Enabled && c.Type != TypeSOCKS4
+}
+
 // ProxyDialer wraps SOCKS proxy functionality
 type ProxyDialer struct {
 	config *Config
@@ -38,15 +54,38 @@ func NewProxyDialer(config *Config) (*ProxyDialer, error) {
 		}, nil
 	}
 
-	if config.Type != "socks5" {
-		return nil, fmt.Errorf("unsupported proxy type: %s (must be 'socks5')", config.Type)
+	switch config.Type {
+	case TypeSOCKS5, TypeSOCKS4, TypeSOCKS4a, TypeHTTP:
+	default:
+		return nil, fmt.Errorf("unsupported proxy type: %s (must be 'socks5', 'socks4', 'socks4a' or 'http')", config.Type)
 	}
 
 	if config.Host == "" || config.Port == 0 {
 		return nil, fmt.Errorf("proxy host and port are required when proxy is enabled")
 	}
 
-	proxyAddr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	proxyAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	forward := &net.Dialer{Timeout: 10 * time.Second}
+
+	switch config.Type {
+	case TypeSOCKS4, TypeSOCKS4a:
+		if config.Password != "" {
+			return nil, fmt.Errorf("socks4 supports a username (user ID) only")
+		}
+		return &ProxyDialer{config: config, dialer: &socks4Dialer{
+			proxyAddr: proxyAddr,
+			userID:    config.Username,
+			remoteDNS: config.Type == TypeSOCKS4a,
+			forward:   forward,
+		}}, nil
+	case TypeHTTP:
+		return &ProxyDialer{config: config, dialer: &connectDialer{
+			proxyAddr: proxyAddr,
+			username:  config.Username,
+			password:  config.Password,
+			forward:   forward,
+		}}, nil
+	}
 
 	var dialer proxy.Dialer
 	var err error
@@ -111,7 +150,7 @@ func (p *ProxyDialer) IsEnabled() bool {
 	return p.config.Enabled
 }
 
-// GetType returns the proxy type (socks5)
+// GetType returns the proxy type
 func (p *ProxyDialer) GetType() string {
 	return p.config.Type
 }
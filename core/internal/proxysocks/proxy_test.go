@@ -1,7 +1,12 @@
 package proxysocks
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"io"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -102,8 +107,8 @@ func TestNewProxyDialer_SOCKS5_WithAuth(t *testing.T) {
 	}
 }
 
-// TestNewProxyDialer_SOCKS4_NotSupported tests that SOCKS4 is not supported
-func TestNewProxyDialer_SOCKS4_NotSupported(t *testing.T) {
+// TestNewProxyDialer_SOCKS4 tests SOCKS4 proxy configuration
+func TestNewProxyDialer_SOCKS4(t *testing.T) {
 	cfg := &Config{
 		Enabled: true,
 		Type:    "socks4",
@@ -112,12 +117,18 @@ func TestNewProxyDialer_SOCKS4_NotSupported(t *testing.T) {
 	}
 
 	dialer, err := NewProxyDialer(cfg)
-	if err == nil {
-		t.Error("Expected error for SOCKS4 (not supported)")
+	if err != nil {
+		t.Fatalf("NewProxyDialer failed: %v", err)
 	}
 
-	if dialer != nil {
-		t.Error("Expected nil dialer for unsupported proxy type")
+	if dialer.GetType() != "socks4" {
+		t.Errorf("Expected type socks4, got %s", dialer.GetType())
+	}
+
+	// SOCKS4 has no password authentication
+	cfg.Password = "secret"
+	if _, err := NewProxyDialer(cfg); err == nil {
+		t.Error("Expected error for a SOCKS4 password")
 	}
 }
 
@@ -377,3 +388,139 @@ func TestProxyDialer_Dial(t *testing.T) {
 		t.Error("Expected error when dialing non-existent address")
 	}
 }
+
+// fakeProxy accepts one connection, runs serve on it and then echoes
+// whatever the client sends
+func fakeProxy(t *testing.T, serve func(c net.Conn, br *bufio.Reader) bool) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		if serve(c, br) {
+			_, _ = io.Copy(c, br)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// echo sends a line over conn and expects it back
+func echo(t *testing.T, conn net.Conn) {
+	t.Helper()
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Errorf("tunnel echoed %q, %v", line, err)
+	}
+}
+
+// TestSOCKS4aDial tests a SOCKS4a CONNECT with a hostname
+func TestSOCKS4aDial(t *testing.T) {
+	got := make(chan []byte, 1)
+	port := fakeProxy(t, func(c net.Conn, br *bufio.Reader) bool {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(br, head); err != nil {
+			return false
+		}
+		user, _ := br.ReadBytes(0)
+		host, _ := br.ReadBytes(0)
+		got <- append(append(head, user...), host...)
+		_, _ = c.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+		return true
+	})
+
+	dialer, err := NewProxyDialer(&Config{Enabled: true, Type: "socks4a", Host: "127.0.0.1", Port: port, Username: "rig1"})
+	if err != nil {
+		t.Fatalf("NewProxyDialer failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", "pool.example.onion:3333")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	want := append([]byte{4, 1, 0x0d, 0x05, 0, 0, 0, 1}, "rig1\x00pool.example.onion\x00"...)
+	if req := <-got; !bytes.Equal(req, want) {
+		t.Errorf("request = %q, want %q", req, want)
+	}
+	echo(t, conn)
+}
+
+// TestSOCKS4Rejected tests a SOCKS4 request refused by the proxy
+func TestSOCKS4Rejected(t *testing.T) {
+	port := fakeProxy(t, func(c net.Conn, br *bufio.Reader) bool {
+		_, _ = br.ReadBytes(0)
+		_, _ = c.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+		return false
+	})
+	dialer, err := NewProxyDialer(&Config{Enabled: true, Type: "socks4", Host: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatalf("NewProxyDialer failed: %v", err)
+	}
+	if conn, err := dialer.DialContext(context.Background(), "tcp", "192.0.2.1:3333"); err == nil {
+		_ = conn.Close()
+		t.Error("Expected error for a refused request")
+	}
+}
+
+// TestHTTPConnectDial tests an HTTP CONNECT tunnel with authentication
+func TestHTTPConnectDial(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	port := fakeProxy(t, func(c net.Conn, br *bufio.Reader) bool {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return false
+		}
+		got <- req
+		_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return true
+	})
+
+	dialer, err := NewProxyDialer(&Config{Enabled: true, Type: "http", Host: "127.0.0.1", Port: port, Username: "u", Password: "p"})
+	if err != nil {
+		t.Fatalf("NewProxyDialer failed: %v", err)
+	}
+	conn, err := dialer.DialContext(context.Background(), "tcp", "pool.example.com:3333")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	req := <-got
+	if req.Method != http.MethodConnect || req.Host != "pool.example.com:3333" {
+		t.Errorf("unexpected request %s %s", req.Method, req.Host)
+	}
+	if auth := req.Header.Get("Proxy-Authorization"); auth != "Basic dTpw" {
+		t.Errorf("Proxy-Authorization = %q", auth)
+	}
+	echo(t, conn)
+}
+
+// TestHTTPConnectRefused tests a CONNECT refused by the proxy
+func TestHTTPConnectRefused(t *testing.T) {
+	port := fakeProxy(t, func(c net.Conn, br *bufio.Reader) bool {
+		_, _ = http.ReadRequest(br)
+		_, _ = c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+		return false
+	})
+	dialer, err := NewProxyDialer(&Config{Enabled: true, Type: "http", Host: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatalf("NewProxyDialer failed: %v", err)
+	}
+	if conn, err := dialer.DialContext(context.Background(), "tcp", "pool.example.com:3333"); err == nil {
+		_ = conn.Close()
+		t.Error("Expected error for a refused CONNECT")
+	}
+}
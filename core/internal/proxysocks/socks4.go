@@ -0,0 +1,106 @@
+package proxysocks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks4Dialer connects through a SOCKS4 proxy. SOCKS4 only carries IPv4
+// addresses, so hostnames are resolved locally; SOCKS4a passes them to the
+// proxy instead.
+type socks4Dialer struct {
+	proxyAddr string
+	userID    string
+	remoteDNS bool // SOCKS4a
+	forward   *net.Dialer
+}
+
+func (d *socks4Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to address through the proxy
+func (d *socks4Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" {
+		return nil, fmt.Errorf("socks4: unsupported network %s", network)
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks4: invalid port %q", portStr)
+	}
+
+	ip := net.ParseIP(host).To4()
+	if ip == nil && net.ParseIP(host) != nil {
+		return nil, errors.New("socks4: IPv6 destinations are not supported")
+	}
+	if ip == nil && !d.remoteDNS {
+		addrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+		if err != nil {
+			return nil, fmt.Errorf("socks4: resolve %s: %w", host, err)
+		}
+		ip = addrs[0].To4()
+	}
+
+	// VN 4, CD 1 (connect), port, address, user ID; SOCKS4a sends the
+	// address 0.0.0.1 and appends the hostname
+	req := []byte{4, 1, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	if ip != nil {
+		req = append(req, ip...)
+	} else {
+		req = append(req, 0, 0, 0, 1)
+	}
+	req = append(append(req, d.userID...), 0)
+	if ip == nil {
+		req = append(append(req, host...), 0)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(ctx, conn, func() error {
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		var resp [8]byte
+		if _, err := io.ReadFull(conn, resp[:]); err != nil {
+			return err
+		}
+		if resp[1] != 0x5a {
+			return fmt.Errorf("socks4: proxy refused %s (code %#x)", address, resp[1])
+		}
+		return nil
+	}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshake runs fn on conn, aborting it when ctx is done
+func handshake(ctx context.Context, conn net.Conn, fn func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if err := fn(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
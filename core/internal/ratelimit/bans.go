@@ -0,0 +1,321 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ban sources
+const (
+	SourceConfig = "config" // listed in ratelimit.bans
+	SourceAdmin  = "admin"  // added at runtime through the admin API
+	SourceAuto   = "auto"   // connection rate exceeded
+	SourceShares = "shares" // share rate or invalid shares exceeded
+	SourceInput  = "input"  // too many malformed lines from a client
+)
+
+// BanConfig is a ban listed in the config file. Exactly one of IP (address
+// or CIDR) and Worker is set.
+type BanConfig struct {
+	IP     string `json:"ip"`
+	Worker string `json:"worker"`
+	Reason string `json:"reason"`
+	// Until is an optional RFC 3339 expiry; empty bans permanently
+	Until string `json:"until"`
+}
+
+// Ban describes an active ban as reported by Bans
+type Ban struct {
+	IP      string    `json:"ip,omitempty"`
+	Worker  string    `json:"worker,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitempty"` // zero means permanent
+}
+
+type ipBan struct {
+	Ban
+	net *net.IPNet
+}
+
+// active reports whether the ban still applies at now
+func (b Ban) active(now time.Time) bool {
+	return b.Expires.IsZero() || now.Before(b.Expires)
+}
+
+// ParseCIDR accepts a single address or a CIDR block
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %q", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ValidateBan checks a configured ban entry
+func ValidateBan(b BanConfig) error {
+	if (b.IP == "") == (b.Worker == "") {
+		return fmt.Errorf("exactly one of ip and worker must be set")
+	}
+	if b.IP != "" {
+		if _, err := ParseCIDR(b.IP); err != nil {
+			return err
+		}
+	}
+	if b.Until != "" {
+		if _, err := time.Parse(time.RFC3339, b.Until); err != nil {
+			return fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadBans replaces the config-sourced bans, keeping runtime ones. Entries
+// are expected to be validated by the caller; invalid ones are skipped.
+func (l *Limiter) loadBans(bans []BanConfig) {
+	now := time.Now()
+	l.banMu.Lock()
+	defer l.banMu.Unlock()
+
+	ips := l.ipBans[:0]
+	for _, b := range l.ipBans {
+		if b.Source != SourceConfig {
+			ips = append(ips, b)
+		}
+	}
+	l.ipBans = ips
+	for w, b := range l.workerBans {
+		if b.Source == SourceConfig {
+			delete(l.workerBans, w)
+		}
+	}
+
+	for _, bc := range bans {
+		if ValidateBan(bc) != nil {
+			continue
+		}
+		ban := Ban{Reason: bc.Reason, Source: SourceConfig, Created: now}
+		if bc.Until != "" {
+			ban.Expires, _ = time.Parse(time.RFC3339, bc.Until)
+		}
+		if bc.Worker != "" {
+			ban.Worker = bc.Worker
+			l.workerBans[bc.Worker] = ban
+			continue
+		}
+		n, _ := ParseCIDR(bc.IP)
+		ban.IP = n.String()
+		l.ipBans = append(l.ipBans, ipBan{Ban: ban, net: n})
+	}
+}
+
+// Ban blocks an address or CIDR block for d (0 bans permanently) and
+// returns the normalized target
+func (l *Limiter) Ban(target, reason string, d time.Duration) (string, error) {
+	return l.BanFrom(SourceAdmin, target, reason, d)
+}
+
+// BanFrom is Ban attributed to source
+func (l *Limiter) BanFrom(source, target, reason string, d time.Duration) (string, error) {
+	n, err := ParseCIDR(target)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ban := Ban{IP: n.String(), Reason: reason, Source: source, Created: now}
+	if d > 0 {
+		ban.Expires = now.Add(d)
+	}
+
+	l.banMu.Lock()
+	replaced := false
+	for i, b := range l.ipBans {
+		if b.IP == ban.IP {
+			l.ipBans[i] = ipBan{Ban: ban, net: n}
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		l.ipBans = append(l.ipBans, ipBan{Ban: ban, net: n})
+	}
+	l.banMu.Unlock()
+	l.notifyBan(ban)
+	return ban.IP, nil
+}
+
+// Unban lifts every ban on an address or CIDR block, including an automatic
+// rate limit ban, and reports whether anything was removed
+func (l *Limiter) Unban(target string) (bool, error) {
+	n, err := ParseCIDR(target)
+	if err != nil {
+		return false, err
+	}
+	removed := false
+
+	l.banMu.Lock()
+	ips := l.ipBans[:0]
+	for _, b := range l.ipBans {
+		if b.IP == n.String() {
+			removed = true
+			continue
+		}
+		ips = append(ips, b)
+	}
+	l.ipBans = ips
+	l.banMu.Unlock()
+
+	l.mu.RLock()
+	for key, stats := range l.stats {
+		// keys are addresses or, with subnet limits, CIDR blocks
+		kn, err := ParseCIDR(key)
+		if err != nil || !n.Contains(kn.IP) && !kn.Contains(n.IP) {
+			continue
+		}
+		stats.mu.Lock()
+		if time.Now().Before(stats.bannedUntil) {
+			stats.bannedUntil = time.Time{}
+			removed = true
+		}
+		stats.mu.Unlock()
+	}
+	l.mu.RUnlock()
+	return removed, nil
+}
+
+// BanWorker blocks a worker name for d (0 bans permanently)
+func (l *Limiter) BanWorker(worker, reason string, d time.Duration) {
+	l.BanWorkerFrom(SourceAdmin, worker, reason, d)
+}
+
+// BanWorkerFrom is BanWorker attributed to source
+func (l *Limiter) BanWorkerFrom(source, worker, reason string, d time.Duration) {
+	now := time.Now()
+	ban := Ban{Worker: worker, Reason: reason, Source: source, Created: now}
+	if d > 0 {
+		ban.Expires = now.Add(d)
+	}
+	l.banMu.Lock()
+	l.workerBans[worker] = ban
+	l.banMu.Unlock()
+	l.notifyBan(ban)
+}
+
+// SetBanHook registers a callback invoked for every ban issued at runtime,
+// whether through the admin API or automatically. Config bans are not
+// reported.
+func (l *Limiter) SetBanHook(fn func(Ban)) {
+	l.onBan = fn
+}
+
+// notifyBan reports a new ban to the hook, if any
+func (l *Limiter) notifyBan(b Ban) {
+	if l.onBan != nil {
+		l.onBan(b)
+	}
+}
+
+// UnbanWorker lifts a worker ban and reports whether one existed
+func (l *Limiter) UnbanWorker(worker string) bool {
+	l.banMu.Lock()
+	defer l.banMu.Unlock()
+	_, ok := l.workerBans[worker]
+	delete(l.workerBans, worker)
+	return ok
+}
+
+// IPBanned reports whether an explicit ban covers the address. Automatic
+// rate limit bans are reported by IsBanned.
+func (l *Limiter) IPBanned(addr net.Addr) bool {
+	ip := net.ParseIP(extractIP(addr))
+	if ip == nil {
+		return false
+	}
+	now := time.Now()
+	l.banMu.RLock()
+	defer l.banMu.RUnlock()
+	for _, b := range l.ipBans {
+		if b.active(now) && b.net.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkerBanned reports whether the worker name is banned
+func (l *Limiter) WorkerBanned(worker string) bool {
+	if worker == "" {
+		return false
+	}
+	l.banMu.RLock()
+	defer l.banMu.RUnlock()
+	b, ok := l.workerBans[worker]
+	return ok && b.active(time.Now())
+}
+
+// Bans lists the active explicit and automatic bans, newest first
+func (l *Limiter) Bans() []Ban {
+	now := time.Now()
+	out := []Ban{}
+
+	l.banMu.RLock()
+	for _, b := range l.ipBans {
+		if b.active(now) {
+			out = append(out, b.Ban)
+		}
+	}
+	for _, b := range l.workerBans {
+		if b.active(now) {
+			out = append(out, b)
+		}
+	}
+	l.banMu.RUnlock()
+
+	l.mu.RLock()
+	for ip, stats := range l.stats {
+		stats.mu.Lock()
+		if now.Before(stats.bannedUntil) {
+			out = append(out, Ban{
+				IP:      ip,
+				Reason:  "connection rate exceeded",
+				Source:  SourceAuto,
+				Created: stats.bannedUntil.Add(-time.Duration(l.cfg.BanDurationSeconds) * time.Second),
+				Expires: stats.bannedUntil,
+			})
+		}
+		stats.mu.Unlock()
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out
+}
+
+// pruneBans drops expired explicit bans
+func (l *Limiter) pruneBans(now time.Time) {
+	l.banMu.Lock()
+	defer l.banMu.Unlock()
+	ips := l.ipBans[:0]
+	for _, b := range l.ipBans {
+		if b.active(now) {
+			ips = append(ips, b)
+		}
+	}
+	l.ipBans = ips
+	for w, b := range l.workerBans {
+		if !b.active(now) {
+			delete(l.workerBans, w)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConfigBans(t *testing.T) {
+	l := NewLimiter(&Config{
+		Enabled: false,
+		Bans: []BanConfig{
+			{IP: "10.1.0.0/16", Reason: "abuse"},
+			{IP: "192.168.1.7"},
+			{Worker: "thief.rig1", Reason: "stolen"},
+			{IP: "172.16.0.1", Until: "2000-01-01T00:00:00Z"},
+		},
+	})
+
+	cases := map[string]bool{
+		"10.1.2.3":    false,
+		"10.2.0.1":    true,
+		"192.168.1.7": false,
+		"192.168.1.8": true,
+		"172.16.0.1":  true, // expired
+	}
+	for ip, want := range cases {
+		addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}
+		if got := l.AllowConnection(addr); got != want {
+			t.Errorf("AllowConnection(%s) = %v, want %v (bans apply even when disabled)", ip, got, want)
+		}
+	}
+	if !l.WorkerBanned("thief.rig1") || l.WorkerBanned("honest.rig1") {
+		t.Error("worker ban not applied")
+	}
+	if n := len(l.Bans()); n != 3 {
+		t.Errorf("Bans() returned %d entries, want 3 active", n)
+	}
+}
+
+func TestRuntimeBans(t *testing.T) {
+	l := NewLimiter(&Config{Enabled: true, Bans: []BanConfig{{IP: "10.0.0.1"}}})
+	addr := &net.TCPAddr{IP: net.ParseIP("10.9.9.9"), Port: 1}
+
+	if _, err := l.Ban("not-an-ip", "", 0); err == nil {
+		t.Error("expected error for invalid target")
+	}
+	target, err := l.Ban("10.9.0.0/16", "test", time.Hour)
+	if err != nil || target != "10.9.0.0/16" {
+		t.Fatalf("Ban = %q, %v", target, err)
+	}
+	if l.AllowConnection(addr) {
+		t.Error("banned CIDR should be rejected")
+	}
+	l.BanWorker("w1", "test", 0)
+
+	// Reload replaces config bans but keeps runtime ones
+	l.UpdateConfig(&Config{Enabled: true})
+	if l.IPBanned(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}) {
+		t.Error("config ban should be dropped on reload")
+	}
+	if !l.IPBanned(addr) || !l.WorkerBanned("w1") {
+		t.Error("runtime bans should survive reload")
+	}
+
+	if removed, _ := l.Unban("10.9.0.0/16"); !removed {
+		t.Error("Unban should report removal")
+	}
+	if !l.UnbanWorker("w1") || l.UnbanWorker("w1") {
+		t.Error("UnbanWorker should remove exactly once")
+	}
+	if !l.AllowConnection(addr) {
+		t.Error("connection should be allowed after unban")
+	}
+}
+
+func TestUnbanAutomatic(t *testing.T) {
+	l := NewLimiter(&Config{Enabled: true, MaxConnectionsPerMinute: 1, BanDurationSeconds: 300})
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 1}
+	l.AllowConnection(addr)
+	l.AllowConnection(addr)
+	if !l.IsBanned(addr) {
+		t.Fatal("expected automatic ban")
+	}
+	bans := l.Bans()
+	if len(bans) != 1 || bans[0].Source != SourceAuto {
+		t.Fatalf("Bans() = %+v", bans)
+	}
+	if removed, _ := l.Unban("10.0.0.5"); !removed || l.IsBanned(addr) {
+		t.Error("Unban should lift the automatic ban")
+	}
+}
+
+func TestValidateBan(t *testing.T) {
+	bad := []BanConfig{{}, {IP: "1.2.3.4", Worker: "w"}, {IP: "1.2.3.4/99"}, {Worker: "w", Until: "tomorrow"}}
+	for _, b := range bad {
+		if ValidateBan(b) == nil {
+			t.Errorf("ValidateBan(%+v) should fail", b)
+		}
+	}
+	if err := ValidateBan(BanConfig{IP: "2001:db8::/32", Until: "2030-01-01T00:00:00Z"}); err != nil {
+		t.Errorf("valid ban rejected: %v", err)
+	}
+}
+
+func TestBanHook(t *testing.T) {
+	l := NewLimiter(&Config{
+		Enabled: true, MaxConnectionsPerMinute: 1, BanDurationSeconds: 300,
+		Bans: []BanConfig{{IP: "192.0.2.1"}},
+	})
+	var got []Ban
+	l.SetBanHook(func(b Ban) { got = append(got, b) })
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 1}
+	l.AllowConnection(addr)
+	l.AllowConnection(addr)
+	if _, err := l.Ban("10.1.0.0/16", "abuse", 0); err != nil {
+		t.Fatal(err)
+	}
+	l.BanWorker("leech.1", "", time.Hour)
+
+	if len(got) != 3 {
+		t.Fatalf("hook called %d times, want 3: %+v", len(got), got)
+	}
+	if got[0].Source != SourceAuto || got[0].IP != "10.0.0.5" || got[0].Expires.IsZero() {
+		t.Errorf("automatic ban = %+v", got[0])
+	}
+	if got[1].IP != "10.1.0.0/16" || got[1].Reason != "abuse" || got[2].Worker != "leech.1" {
+		t.Errorf("admin bans = %+v", got[1:])
+	}
+}
@@ -3,6 +3,7 @@ package ratelimit
 
 import (
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,17 +12,42 @@ import (
 type Config struct {
 	// Enabled indicates if rate limiting is active
 	Enabled bool `json:"enabled"`
-	// MaxConnectionsPerIP limits connections from a single IP
+	// MaxConnectionsPerIP limits connections from a single IP or subnet
 	MaxConnectionsPerIP int `json:"max_connections_per_ip"`
-	// MaxConnectionsPerMinute limits new connections per minute from a single IP
+	// MaxConnectionsPerMinute limits new connections per minute from a single
+	// IP or subnet
 	MaxConnectionsPerMinute int `json:"max_connections_per_minute"`
+	// IPv4Prefix and IPv6Prefix group addresses into one limit per subnet so
+	// rotating addresses within a NAT pool or a v6 prefix does not escape it;
+	// defaults are 32 (each IPv4 address) and 64
+	IPv4Prefix int `json:"ipv4_prefix"`
+	IPv6Prefix int `json:"ipv6_prefix"`
 	// BanDurationSeconds how long to ban an IP that exceeds limits
 	BanDurationSeconds int `json:"ban_duration_seconds"`
 	// CleanupIntervalSeconds how often to cleanup old entries
 	CleanupIntervalSeconds int `json:"cleanup_interval_seconds"`
+	// Bans are explicit IP, CIDR or worker bans; they apply even when
+	// rate limiting is disabled
+	Bans []BanConfig `json:"bans"`
 }
 
-// IPStats tracks connection statistics for an IP address
+// ipv4Prefix returns the IPv4 limit granularity
+func (c *Config) ipv4Prefix() int {
+	if c.IPv4Prefix <= 0 || c.IPv4Prefix > 32 {
+		return 32
+	}
+	return c.IPv4Prefix
+}
+
+// ipv6Prefix returns the IPv6 limit granularity
+func (c *Config) ipv6Prefix() int {
+	if c.IPv6Prefix <= 0 || c.IPv6Prefix > 128 {
+		return 64
+	}
+	return c.IPv6Prefix
+}
+
+// IPStats tracks connection statistics for an IP address or subnet
 type IPStats struct {
 	mu                sync.Mutex
 	activeConnections int
@@ -34,6 +60,12 @@ type Limiter struct {
 	cfg   *Config
 	mu    sync.RWMutex
 	stats map[string]*IPStats
+
+	banMu      sync.RWMutex
+	ipBans     []ipBan
+	workerBans map[string]Ban
+
+	onBan func(Ban)
 }
 
 // NewLimiter creates a new rate limiter
@@ -49,9 +81,11 @@ func NewLimiter(cfg *Config) *Limiter {
 	}
 
 	l := &Limiter{
-		cfg:   cfg,
-		stats: make(map[string]*IPStats),
+		cfg:        cfg,
+		stats:      make(map[string]*IPStats),
+		workerBans: make(map[string]Ban),
 	}
+	l.loadBans(cfg.Bans)
 
 	// Start cleanup routine if enabled
 	if cfg.Enabled && cfg.CleanupIntervalSeconds > 0 {
@@ -64,35 +98,39 @@ func NewLimiter(cfg *Config) *Limiter {
 // UpdateConfig updates the limiter configuration
 func (l *Limiter) UpdateConfig(cfg *Config) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
 	l.cfg = cfg
+	l.mu.Unlock()
+	l.loadBans(cfg.Bans)
 }
 
 // AllowConnection checks if a connection from the given address should be allowed
 func (l *Limiter) AllowConnection(addr net.Addr) bool {
+	if l.IPBanned(addr) {
+		return false
+	}
 	if !l.cfg.Enabled {
 		return true
 	}
 
-	ip := extractIP(addr)
-	if ip == "" {
+	key := l.key(addr)
+	if key == "" {
 		return false
 	}
 
-	// Get or create stats for this IP
+	// Get or create stats for this IP or subnet
 	l.mu.RLock()
-	stats, exists := l.stats[ip]
+	stats, exists := l.stats[key]
 	l.mu.RUnlock()
 
 	if !exists {
 		l.mu.Lock()
 		// Double-check after acquiring write lock
-		stats, exists = l.stats[ip]
+		stats, exists = l.stats[key]
 		if !exists {
 			stats = &IPStats{
 				connectionTimes: make([]time.Time, 0, l.cfg.MaxConnectionsPerMinute),
 			}
-			l.stats[ip] = stats
+			l.stats[key] = stats
 		}
 		l.mu.Unlock()
 	}
@@ -128,6 +166,7 @@ func (l *Limiter) AllowConnection(addr net.Addr) bool {
 		if len(stats.connectionTimes) >= l.cfg.MaxConnectionsPerMinute {
 			// Ban this IP
 			stats.bannedUntil = now.Add(time.Duration(l.cfg.BanDurationSeconds) * time.Second)
+			l.notifyBan(Ban{IP: key, Reason: "connection rate exceeded", Source: SourceAuto, Created: now, Expires: stats.bannedUntil})
 			return false
 		}
 
@@ -146,13 +185,13 @@ func (l *Limiter) ReleaseConnection(addr net.Addr) {
 		return
 	}
 
-	ip := extractIP(addr)
-	if ip == "" {
+	key := l.key(addr)
+	if key == "" {
 		return
 	}
 
 	l.mu.RLock()
-	stats, exists := l.stats[ip]
+	stats, exists := l.stats[key]
 	l.mu.RUnlock()
 
 	if !exists {
@@ -172,13 +211,13 @@ func (l *Limiter) IsBanned(addr net.Addr) bool {
 		return false
 	}
 
-	ip := extractIP(addr)
-	if ip == "" {
+	key := l.key(addr)
+	if key == "" {
 		return false
 	}
 
 	l.mu.RLock()
-	stats, exists := l.stats[ip]
+	stats, exists := l.stats[key]
 	l.mu.RUnlock()
 
 	if !exists {
@@ -198,13 +237,16 @@ func (l *Limiter) GetStats(addr net.Addr) map[string]interface{} {
 		return nil
 	}
 
+	key := l.key(addr)
+
 	l.mu.RLock()
-	stats, exists := l.stats[ip]
+	stats, exists := l.stats[key]
 	l.mu.RUnlock()
 
 	if !exists {
 		return map[string]interface{}{
 			"ip":                    ip,
+			"subnet":                key,
 			"active_connections":    0,
 			"connections_in_minute": 0,
 			"banned":                false,
@@ -216,6 +258,7 @@ func (l *Limiter) GetStats(addr net.Addr) map[string]interface{} {
 
 	return map[string]interface{}{
 		"ip":                    ip,
+		"subnet":                key,
 		"active_connections":    stats.activeConnections,
 		"connections_in_minute": len(stats.connectionTimes),
 		"banned":                time.Now().Before(stats.bannedUntil),
@@ -242,12 +285,19 @@ func (l *Limiter) GetGlobalStats() map[string]interface{} {
 		stats.mu.Unlock()
 	}
 
+	l.banMu.RLock()
+	explicit := len(l.ipBans) + len(l.workerBans)
+	l.banMu.RUnlock()
+
 	return map[string]interface{}{
+		"explicit_bans":    explicit,
 		"total_ips":        totalIPs,
 		"total_active":     totalActive,
 		"banned_ips":       bannedIPs,
 		"max_per_ip":       l.cfg.MaxConnectionsPerIP,
 		"max_per_minute":   l.cfg.MaxConnectionsPerMinute,
+		"ipv4_prefix":      l.cfg.ipv4Prefix(),
+		"ipv6_prefix":      l.cfg.ipv6Prefix(),
 		"ban_duration_sec": l.cfg.BanDurationSeconds,
 	}
 }
@@ -260,6 +310,7 @@ func (l *Limiter) cleanupRoutine() {
 
 	for range ticker.C {
 		l.cleanup()
+		l.pruneBans(time.Now())
 	}
 }
 
@@ -285,7 +336,26 @@ func (l *Limiter) cleanup() {
 	}
 }
 
-// extractIP extracts the IP address from net.Addr
+// key returns the IP or subnet an address is limited under: the address
+// itself at full prefix length, otherwise the CIDR block containing it.
+// IPv4-mapped IPv6 addresses from dual-stack listeners count as IPv4.
+func (l *Limiter) key(addr net.Addr) string {
+	ip := net.ParseIP(extractIP(addr))
+	if ip == nil {
+		return extractIP(addr)
+	}
+	bits, size := l.cfg.ipv6Prefix(), 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, size = v4, l.cfg.ipv4Prefix(), 32
+	}
+	if bits == size {
+		return ip.String()
+	}
+	mask := net.CIDRMask(bits, size)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// extractIP extracts the IP address from net.Addr, without any IPv6 zone
 func extractIP(addr net.Addr) string {
 	switch v := addr.(type) {
 	case *net.TCPAddr:
@@ -296,7 +366,10 @@ func extractIP(addr net.Addr) string {
 		// Try to parse as string
 		host, _, err := net.SplitHostPort(addr.String())
 		if err != nil {
-			return addr.String()
+			host = addr.String()
+		}
+		if i := strings.IndexByte(host, '%'); i >= 0 {
+			host = host[:i]
 		}
 		return host
 	}
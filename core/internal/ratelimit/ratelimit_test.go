@@ -375,3 +375,57 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Error("GetGlobalStats returned nil after concurrent access")
 	}
 }
+
+func TestSubnetLimits(t *testing.T) {
+	l := NewLimiter(&Config{Enabled: true, MaxConnectionsPerIP: 2, IPv4Prefix: 24})
+
+	// IPv6 defaults to /64: rotating the interface ID stays in one bucket
+	v6a := &net.TCPAddr{IP: net.ParseIP("2001:db8:1:2::1"), Port: 1}
+	v6b := &net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:ffff::9"), Port: 1}
+	v6c := &net.TCPAddr{IP: net.ParseIP("2001:db8:1:3::1"), Port: 1}
+	if !l.AllowConnection(v6a) || !l.AllowConnection(v6b) {
+		t.Fatal("first connections from the /64 refused")
+	}
+	if l.AllowConnection(&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2::abcd"), Port: 1}) {
+		t.Error("third address in the same /64 allowed past max_connections_per_ip")
+	}
+	if !l.AllowConnection(v6c) {
+		t.Error("another /64 refused")
+	}
+
+	// IPv4-mapped addresses from a dual-stack listener share the IPv4 bucket
+	v4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 1}
+	mapped := &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.77"), Port: 1}
+	if !l.AllowConnection(v4) || !l.AllowConnection(mapped) {
+		t.Fatal("first connections from the /24 refused")
+	}
+	if l.AllowConnection(&net.TCPAddr{IP: net.ParseIP("192.0.2.200"), Port: 1}) {
+		t.Error("third address in the same /24 allowed")
+	}
+	if st := l.GetStats(mapped); st["subnet"] != "192.0.2.0/24" || st["active_connections"] != 2 {
+		t.Errorf("stats = %v", st)
+	}
+
+	l.ReleaseConnection(v4)
+	if !l.AllowConnection(&net.TCPAddr{IP: net.ParseIP("192.0.2.200"), Port: 1}) {
+		t.Error("released slot not reusable by another address in the /24")
+	}
+}
+
+func TestSubnetAutoBan(t *testing.T) {
+	l := NewLimiter(&Config{Enabled: true, MaxConnectionsPerMinute: 1, BanDurationSeconds: 60})
+	l.AllowConnection(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1})
+	if l.AllowConnection(&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1}) {
+		t.Fatal("second connection from the /64 within a minute allowed")
+	}
+	bans := l.Bans()
+	if len(bans) != 1 || bans[0].IP != "2001:db8::/64" {
+		t.Fatalf("bans = %+v, want the /64", bans)
+	}
+	if removed, err := l.Unban("2001:db8::5"); err != nil || !removed {
+		t.Errorf("unbanning an address in the banned /64: %v, %v", removed, err)
+	}
+	if l.IsBanned(&net.TCPAddr{IP: net.ParseIP("2001:db8::3"), Port: 1}) {
+		t.Error("/64 still banned")
+	}
+}
@@ -0,0 +1,159 @@
+package routing
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/connection"
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// AuthorizeConfig controls how client authorizes reach the pool
+type AuthorizeConfig struct {
+	// FastAck answers mining.authorize at once, once the proxy's own
+	// rules passed, and authorizes each distinct upstream name with the
+	// pool in the background, once per upstream connection
+	FastAck bool `json:"fast_ack"`
+	// RejectTTLSeconds is how long a name the pool refused is refused
+	// locally before the pool is asked again; default 60
+	RejectTTLSeconds int `json:"reject_ttl_seconds"`
+}
+
+// Validate checks the reject TTL
+func (c AuthorizeConfig) Validate() error {
+	if c.RejectTTLSeconds < 0 {
+		return errors.New("reject_ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+func (c AuthorizeConfig) rejectTTL() time.Duration {
+	if c.RejectTTLSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.RejectTTLSeconds) * time.Second
+}
+
+// authState is what the pool said about an upstream name
+type authState struct {
+	pending bool
+	ok      bool
+	answer  stratum.Message // the pool's refusal, replayed to clients
+	at      time.Time
+}
+
+// authCache remembers the upstream names authorized on the connection
+type authCache struct {
+	mu    sync.Mutex
+	names map[string]authState
+}
+
+// authProbe marks the pending request of a background authorize; its
+// answer goes to the cache rather than to a client
+type authProbe struct {
+	user string
+}
+
+// fastAuthorize answers an authorize locally and makes sure its upstream
+// name is authorized on the connection. It reports false when the request
+// should be forwarded as usual: fast acks are off or the upstream is down.
+func (r *Router) fastAuthorize(cl Client, msg stratum.Message) bool {
+	cfg := r.config()
+	if !cfg.Authorize.FastAck || !r.up.IsConnected() {
+		return false
+	}
+	a, err := stratum.ParseAuthorize(msg.Params)
+	if err != nil {
+		return false
+	}
+	user := a.Worker
+
+	now := time.Now()
+	r.auth.mu.Lock()
+	if r.auth.names == nil {
+		r.auth.names = make(map[string]authState)
+	}
+	st, known := r.auth.names[user]
+	if known && !st.pending && !st.ok && now.Sub(st.at) > cfg.Authorize.rejectTTL() {
+		known = false
+	}
+	probe := !known && user != cfg.Upstream.User
+	switch {
+	case !known && !probe:
+		// the proxy's own handshake authorized the configured user
+		st = authState{ok: true, at: now}
+		r.auth.names[user] = st
+	case probe:
+		st = authState{pending: true, at: now}
+		r.auth.names[user] = st
+	}
+	r.auth.mu.Unlock()
+
+	if !st.pending && !st.ok {
+		answer := st.answer
+		answer.ID = msg.ID
+		r.writeClient(cl, answer)
+		if r.onAuth != nil {
+			r.onAuth(cl, false)
+		}
+		return true
+	}
+	if probe {
+		params := append([]any(nil), msg.Params.([]any)...)
+		req := connection.PendingReq{Client: authProbe{user: user}, Method: stratum.MethodAuthorize, Params: params}
+		if _, err := r.up.Request(stratum.Message{Method: stratum.MethodAuthorize, Params: params}, req); err != nil {
+			r.forgetAuthorized(user)
+			return false
+		}
+	}
+
+	r.mx.IncrementAuthorizeFastAcks()
+	r.writeClient(cl, stratum.NewSuccessResponse(msg.ID, true))
+	cl.SetHandshakeDone(true)
+	if r.onAuth != nil {
+		r.onAuth(cl, true)
+	}
+	return true
+}
+
+// authorizedAs records the pool's answer to a background authorize
+func (r *Router) authorizedAs(p authProbe, msg stratum.Message) {
+	ok, _ := msg.Result.(bool)
+	ok = ok && msg.Error == nil
+	st := authState{ok: ok, at: time.Now()}
+	result := "accepted"
+	if !ok {
+		result = "rejected"
+		st.answer = stratum.Message{Result: msg.Result, Error: msg.Error}
+		if st.answer.Result == nil && st.answer.Error == nil {
+			st.answer.Result = false
+		}
+		code, reason := stratum.ParseError(msg.Error)
+		log.Printf("pool refused upstream name %s (%d %s); clients authorizing as it are refused for %s",
+			p.user, code, reason, r.config().Authorize.rejectTTL())
+	}
+	r.mx.IncrementAuthorizeChecks(result)
+	r.auth.mu.Lock()
+	if r.auth.names != nil {
+		r.auth.names[p.user] = st
+	}
+	r.auth.mu.Unlock()
+}
+
+// forgetAuthorized drops what is known about an upstream name, so the
+// next authorize asks the pool again
+func (r *Router) forgetAuthorized(user string) {
+	r.auth.mu.Lock()
+	delete(r.auth.names, user)
+	r.auth.mu.Unlock()
+}
+
+// resetAuthorized forgets every upstream name; a new connection has to
+// authorize them again
+func (r *Router) resetAuthorized() {
+	r.auth.mu.Lock()
+	r.auth.names = nil
+	r.auth.mu.Unlock()
+}
@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// heldSubmit is a submit waiting for the upstream to come back
+type heldSubmit struct {
+	msg  stratum.Message
+	held time.Time
+}
+
+func (c SubmitConfig) holdPerClient() int {
+	if c.HoldPerClient <= 0 {
+		return 8
+	}
+	return c.HoldPerClient
+}
+
+// hold keeps a submit that arrived while the upstream is down. It reports
+// false when holding is off; a client whose queue is full is answered with
+// a retryable error.
+func (r *Router) hold(cl Client, msg stratum.Message) bool {
+	sub := r.config().Submit
+	if sub.HoldMs <= 0 {
+		return false
+	}
+	r.subMu.Lock()
+	q := r.held[cl]
+	if len(q) >= sub.holdPerClient() {
+		r.subMu.Unlock()
+		r.mx.IncrementSubmitsHeld("overflow")
+		r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, stratum.ErrReconnecting))
+		r.submitted(cl, msg, false)
+		return true
+	}
+	msg.ID = stratum.CopyID(msg.ID)
+	r.held[cl] = append(q, heldSubmit{msg: msg, held: time.Now()})
+	r.heldN++
+	r.subMu.Unlock()
+	return true
+}
+
+// ReleaseHeld forwards the submits held while the upstream was down, each
+// client's in the order they arrived; called once the upstream is ready
+func (r *Router) ReleaseHeld() int {
+	r.subMu.Lock()
+	held := r.held
+	r.held = make(map[Client][]heldSubmit)
+	r.heldN = 0
+	r.subMu.Unlock()
+
+	n := 0
+	for cl, q := range held {
+		for _, h := range q {
+			r.mx.IncrementSubmitsHeld("forwarded")
+			r.dispatchSubmit(cl, h.msg)
+			n++
+		}
+	}
+	return n
+}
+
+// expireHeld answers the submits held longer than the window with a
+// retryable error
+func (r *Router) expireHeld(now time.Time) int {
+	type expired struct {
+		cl  Client
+		msg stratum.Message
+	}
+	var out []expired
+	cutoff := now.Add(-time.Duration(r.config().Submit.HoldMs) * time.Millisecond)
+	r.subMu.Lock()
+	for cl, q := range r.held {
+		i := 0
+		for i < len(q) && !q[i].held.After(cutoff) {
+			out = append(out, expired{cl, q[i].msg})
+			i++
+		}
+		if i == len(q) {
+			delete(r.held, cl)
+		} else {
+			r.held[cl] = q[i:]
+		}
+	}
+	r.heldN -= len(out)
+	r.subMu.Unlock()
+
+	for _, e := range out {
+		r.mx.IncrementSubmitsHeld("expired")
+		r.writeClient(e.cl, stratum.ErrorResponseFor(e.msg.ID, stratum.ErrReconnecting))
+		r.submitted(e.cl, e.msg, false)
+	}
+	return len(out)
+}
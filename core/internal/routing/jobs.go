@@ -0,0 +1,73 @@
+package routing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// jobCacheSize is how many upstream jobs the router keeps parsed
+const jobCacheSize = 32
+
+// cachedJob is an upstream job and when its mining.notify arrived
+type cachedJob struct {
+	job stratum.Job
+	at  time.Time
+}
+
+// jobCache keeps the latest upstream jobs, parsed once from their notify
+type jobCache struct {
+	mu     sync.RWMutex
+	jobs   map[string]cachedJob
+	order  []string
+	latest cachedJob
+}
+
+// notify parses an upstream mining.notify and caches its job. A malformed
+// notify leaves no newest job.
+func (c *jobCache) notify(params any, at time.Time) {
+	j, ok := stratum.ParseNotify(params)
+	if !ok {
+		c.mu.Lock()
+		c.latest = cachedJob{}
+		c.mu.Unlock()
+		return
+	}
+	c.add(j, at)
+}
+
+// add stores a job, replacing an older one with the same ID
+func (c *jobCache) add(j stratum.Job, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobs == nil {
+		c.jobs = make(map[string]cachedJob)
+	}
+	if _, ok := c.jobs[j.ID]; !ok {
+		c.order = append(c.order, j.ID)
+	}
+	c.jobs[j.ID] = cachedJob{job: j, at: at}
+	c.latest = c.jobs[j.ID]
+	for len(c.order) > jobCacheSize {
+		delete(c.jobs, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Job returns a cached upstream job by ID
+func (r *Router) Job(id string) (stratum.Job, bool) {
+	r.jobs.mu.RLock()
+	defer r.jobs.mu.RUnlock()
+	cj, ok := r.jobs.jobs[id]
+	return cj.job, ok
+}
+
+// UpstreamJob returns the job of the newest mining.notify from the upstream,
+// false when there was none or it was malformed. Upstream stages handling a
+// notify get the job of that notify, so they need not parse it again.
+func (r *Router) UpstreamJob() (stratum.Job, bool) {
+	r.jobs.mu.RLock()
+	defer r.jobs.mu.RUnlock()
+	return r.jobs.latest.job, !r.jobs.latest.at.IsZero()
+}
@@ -0,0 +1,341 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// ClientHandler handles one message from a client
+type ClientHandler func(cl Client, msg stratum.Message)
+
+// ClientMiddleware is a stage of the client message pipeline. It passes the
+// message on by calling next, possibly changed, or stops it by returning;
+// a stage that stops a request should answer the client.
+type ClientMiddleware func(next ClientHandler) ClientHandler
+
+// UpstreamHandler handles one decoded upstream message. line is the raw
+// frame and is only valid during the call.
+type UpstreamHandler func(msg stratum.Message, line []byte)
+
+// UpstreamMiddleware is a stage run on every upstream message before it is
+// broadcast or matched to its request
+type UpstreamMiddleware func(next UpstreamHandler) UpstreamHandler
+
+// Built-in stages of the client pipeline, in the order they run
+const (
+	StageAuth     = "auth"     // records the worker and answers local authorizes
+	StageRewrite  = "rewrite"  // sets the upstream user and extranonce of submits
+	StageDedupe   = "dedupe"   // refuses resubmitted shares (submit.dedupe)
+	StageValidate = "validate" // refuses malformed submits (submit.validate, ntime_roll_seconds)
+	StageStale    = "stale"    // refuses submits for replaced jobs (submit.stale_policy)
+	StageForward  = "forward"  // sends the request to the backend or upstream
+)
+
+// stage is a named middleware of a pipeline
+type stage[M any] struct {
+	name string
+	mw   M
+}
+
+// pipeline holds the registered stages and the chains built from them
+type pipeline struct {
+	mu       sync.RWMutex
+	client   []stage[ClientMiddleware]
+	upstream []stage[UpstreamMiddleware]
+	clientH  ClientHandler
+	upH      UpstreamHandler
+}
+
+// initPipeline registers the built-in stages
+func (r *Router) initPipeline() {
+	r.pl.client = []stage[ClientMiddleware]{
+		{StageAuth, r.authStage},
+		{StageRewrite, r.rewriteStage},
+		{StageDedupe, r.dedupeStage},
+		{StageValidate, r.validateStage},
+		{StageStale, r.staleStage},
+	}
+	r.rebuild()
+}
+
+// UseClient adds a client stage right before forward
+func (r *Router) UseClient(name string, mw ClientMiddleware) error {
+	return r.InsertClient(StageForward, name, mw)
+}
+
+// InsertClient adds a client stage before the named one
+func (r *Router) InsertClient(before, name string, mw ClientMiddleware) error {
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	if name == "" || name == StageForward || stageIndex(r.pl.client, name) >= 0 {
+		return fmt.Errorf("client stage %q must be named and unique", name)
+	}
+	i := len(r.pl.client)
+	if before != StageForward {
+		if i = stageIndex(r.pl.client, before); i < 0 {
+			return fmt.Errorf("unknown client stage %q", before)
+		}
+	}
+	r.pl.client = append(r.pl.client[:i], append([]stage[ClientMiddleware]{{name, mw}}, r.pl.client[i:]...)...)
+	r.rebuildLocked()
+	return nil
+}
+
+// UseUpstream adds an upstream stage after those already registered
+func (r *Router) UseUpstream(name string, mw UpstreamMiddleware) error {
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	if name == "" || stageIndex(r.pl.upstream, name) >= 0 {
+		return fmt.Errorf("upstream stage %q must be named and unique", name)
+	}
+	r.pl.upstream = append(r.pl.upstream, stage[UpstreamMiddleware]{name, mw})
+	r.rebuildLocked()
+	return nil
+}
+
+// ClientStages lists the client pipeline in order
+func (r *Router) ClientStages() []string {
+	r.pl.mu.RLock()
+	defer r.pl.mu.RUnlock()
+	names := make([]string, 0, len(r.pl.client)+1)
+	for _, s := range r.pl.client {
+		names = append(names, s.name)
+	}
+	return append(names, StageForward)
+}
+
+func stageIndex[M any](stages []stage[M], name string) int {
+	for i, s := range stages {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Router) rebuild() {
+	r.pl.mu.Lock()
+	defer r.pl.mu.Unlock()
+	r.rebuildLocked()
+}
+
+// rebuildLocked chains the stages so the first registered runs first
+func (r *Router) rebuildLocked() {
+	h := ClientHandler(r.forward)
+	for i := len(r.pl.client) - 1; i >= 0; i-- {
+		h = r.pl.client[i].mw(h)
+	}
+	r.pl.clientH = h
+
+	u := UpstreamHandler(r.dispatchUpstream)
+	for i := len(r.pl.upstream) - 1; i >= 0; i-- {
+		u = r.pl.upstream[i].mw(u)
+	}
+	r.pl.upH = u
+}
+
+func (r *Router) clientChain() ClientHandler {
+	r.pl.mu.RLock()
+	defer r.pl.mu.RUnlock()
+	return r.pl.clientH
+}
+
+func (r *Router) upstreamChain() UpstreamHandler {
+	r.pl.mu.RLock()
+	defer r.pl.mu.RUnlock()
+	return r.pl.upH
+}
+
+// authStage records the worker name, and the upstream name worker_names
+// builds from it, and answers authorize through the local backend when
+// there is one. Submits from clients that never subscribed or authorized
+// are refused.
+func (r *Router) authStage(next ClientHandler) ClientHandler {
+	return func(cl Client, msg stratum.Message) {
+		if hc, ok := cl.(HandshakeClient); ok && msg.Method == stratum.MethodSubmit {
+			if !hc.Subscribed() {
+				r.refuseShare(cl, msg, stratum.ErrNotSubscribed)
+				return
+			}
+			if cl.GetWorker() == "" {
+				r.refuseShare(cl, msg, stratum.ErrUnauthorized)
+				return
+			}
+		}
+		if msg.Method == stratum.MethodAuthorize {
+			if a, err := stratum.ParseAuthorize(msg.Params); err == nil {
+				cl.SetWorker(a.Worker)
+				if names := r.names.Load(); names.Active() {
+					cl.SetUpUser(names.Name(r.config().Upstream.User, a.Worker))
+				}
+			}
+			if r.backend != nil {
+				r.authorizeLocal(cl, msg)
+				return
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// rewriteStage maps submits onto the upstream user and extranonce. With
+// worker_names active, authorizes go upstream under the client's upstream
+// name too, so the pool knows the names its shares arrive under.
+func (r *Router) rewriteStage(next ClientHandler) ClientHandler {
+	return func(cl Client, msg stratum.Message) {
+		switch msg.Method {
+		case stratum.MethodSubmit:
+			r.rewriteSubmit(cl, &msg)
+		case stratum.MethodAuthorize:
+			if arr, ok := msg.Params.([]any); ok && len(arr) > 0 && r.names.Load().Active() {
+				arr[0] = cl.GetUpUser()
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// dedupeStage refuses a share the client already submitted, with the same
+// error pools use, before it costs an upstream round trip
+func (r *Router) dedupeStage(next ClientHandler) ClientHandler {
+	return func(cl Client, msg stratum.Message) {
+		if msg.Method == stratum.MethodSubmit && r.config().Submit.Dedupe {
+			if s, err := stratum.ParseSubmit(msg.Params); err == nil && r.seenShare(cl, s) {
+				r.refuseShare(cl, msg, stratum.ErrDuplicate)
+				return
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// validateStage refuses submits whose parameters cannot be a share, and
+// those whose ntime was rolled outside the window the pool accepts
+func (r *Router) validateStage(next ClientHandler) ClientHandler {
+	return func(cl Client, msg stratum.Message) {
+		if msg.Method == stratum.MethodSubmit {
+			cfg := r.config()
+			s, err := stratum.ParseSubmit(msg.Params)
+			if cfg.Submit.Validate && (err != nil || s.Validate() != nil) {
+				r.refuseShare(cl, msg, stratum.ErrInvalidShare)
+				return
+			}
+			if roll := cfg.Submit.NTimeRollSeconds; err == nil && roll > 0 && !r.ntimeInRange(s, roll) {
+				r.refuseShare(cl, msg, stratum.ErrNTimeRange)
+				return
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// staleStage answers a submit for a job a clean_jobs notify replaced at
+// once, instead of waiting for the pool to reject it, once the policy's
+// grace is over
+func (r *Router) staleStage(next ClientHandler) ClientHandler {
+	return func(cl Client, msg stratum.Message) {
+		sub := r.config().Submit
+		policy := sub.StalePolicy
+		if msg.Method == stratum.MethodSubmit && (policy == StaleForward || policy == StaleDrop) {
+			if s, err := stratum.ParseSubmit(msg.Params); err == nil {
+				if old, age := r.gens.replaced(s.JobID, time.Now()); old && (policy == StaleDrop || age > sub.staleGrace()) {
+					r.refuseShare(cl, msg, stratum.ErrStaleJob)
+					return
+				}
+			}
+		}
+		next(cl, msg)
+	}
+}
+
+// refuseShare answers a submit the pipeline stopped and accounts it
+func (r *Router) refuseShare(cl Client, msg stratum.Message, err *stratum.Error) {
+	r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, err))
+	r.accountShare(cl, msg.Params, false, 0, err.Code, err.Message)
+}
+
+// forward is the last client stage: it sends the request to the local
+// backend or the upstream
+func (r *Router) forward(cl Client, msg stratum.Message) {
+	switch msg.Method {
+	case stratum.MethodSubscribe:
+		// This will be handled by the nonce manager
+		return
+
+	case stratum.MethodAuthorize:
+		if r.fastAuthorize(cl, msg) {
+			return
+		}
+		r.ForwardToUpstream(cl, msg.Method, msg.Params, msg.ID)
+
+	case stratum.MethodSubmit:
+		if r.backend != nil {
+			r.submitLocal(cl, msg)
+			return
+		}
+		r.dispatchSubmit(cl, msg)
+
+	default:
+		// Generic pass-through for any mining.* call
+		if strings.HasPrefix(msg.Method, "mining.") {
+			if r.backend != nil {
+				r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, stratum.ErrNotSupported))
+				return
+			}
+			r.ForwardToUpstream(cl, msg.Method, msg.Params, msg.ID)
+		}
+	}
+}
+
+// dispatchUpstream is the last upstream stage: notifications are broadcast
+// and responses answer their requests
+func (r *Router) dispatchUpstream(msg stratum.Message, line []byte) {
+	if msg.Method != "" {
+		r.processUpstreamNotification(msg, line)
+		return
+	}
+	// Handle responses (including error-only replies such as rejected shares)
+	if msg.IsResponse() {
+		r.processUpstreamResponse(msg)
+	}
+}
+
+// shareWindow is how many recent shares per client dedupe remembers
+const shareWindow = 256
+
+// recentShares remembers the latest shares of one client
+type recentShares struct {
+	seen  map[string]struct{}
+	order []string
+}
+
+// seenShare records a share and reports whether the client sent it before
+func (r *Router) seenShare(cl Client, s stratum.Submit) bool {
+	parts := []string{s.JobID, s.Extranonce2, s.NTime, s.Nonce}
+	if s.VersionBits != "" {
+		parts = append(parts, s.VersionBits)
+	}
+	key := strings.ToLower(strings.Join(parts, ":"))
+
+	r.dupMu.Lock()
+	defer r.dupMu.Unlock()
+	rs := r.recent[cl]
+	if rs == nil {
+		rs = &recentShares{seen: make(map[string]struct{})}
+		r.recent[cl] = rs
+	}
+	if _, dup := rs.seen[key]; dup {
+		return true
+	}
+	rs.seen[key] = struct{}{}
+	rs.order = append(rs.order, key)
+	if len(rs.order) > shareWindow {
+		delete(rs.seen, rs.order[0])
+		rs.order = rs.order[1:]
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// PendingConfig bounds how long a forwarded request waits for its response
+type PendingConfig struct {
+	TimeoutMs      int `json:"timeout_ms"`       // default 30000
+	ReapIntervalMs int `json:"reap_interval_ms"` // default 1000
+}
+
+// timeout returns how long a request may stay unanswered
+func (c PendingConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// interval returns how often pending requests are checked
+func (c PendingConfig) interval() time.Duration {
+	if c.ReapIntervalMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.ReapIntervalMs) * time.Millisecond
+}
+
+// ReapPending expires the requests the upstream has not answered within the
+// timeout, replies to their clients with an error and frees submit slots.
+// A response arriving later finds no pending entry and is dropped.
+func (r *Router) ReapPending(now time.Time) int {
+	timeout := r.config().Pending.timeout()
+
+	expired := r.up.ExpirePending(now.Add(-timeout))
+	for upID, req := range expired {
+		r.mx.IncrementRequestTimeouts(req.Method)
+		if probe, ok := req.Client.(authProbe); ok {
+			log.Printf("pool did not answer the authorize of upstream name %s; asking again on the next authorize", probe.user)
+			r.mx.IncrementAuthorizeChecks("timeout")
+			r.forgetAuthorized(probe.user)
+			continue
+		}
+		cl, ok := req.Client.(Client)
+		if !ok {
+			continue
+		}
+		log.Printf("upstream request %d (%s) from %s session=%s timed out after %s", upID, req.Method, cl.GetAddr(), SessionOf(cl), now.Sub(req.Sent).Round(time.Millisecond))
+		r.writeClient(cl, stratum.ErrorResponseFor(req.OrigID, stratum.ErrRequestTimeout))
+		if req.Method == "mining.submit" {
+			r.submitDone()
+		}
+	}
+	return len(expired)
+}
+
+// PendingLoop reaps expired requests until ctx is done
+func (r *Router) PendingLoop(ctx context.Context) {
+	interval := r.config().Pending.interval()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.ReapPending(now)
+			r.expireHeld(now)
+		}
+	}
+}
+
+// GetPendingStats returns the pending request view in /status
+func (r *Router) GetPendingStats() map[string]interface{} {
+	timeout := r.config().Pending.timeout()
+	return map[string]interface{}{
+		"pending":    r.up.PendingCount(),
+		"timed_out":  r.mx.RequestTimeouts.Load(),
+		"timeout_ms": timeout.Milliseconds(),
+	}
+}
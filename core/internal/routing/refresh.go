@@ -0,0 +1,52 @@
+package routing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// sentJob is a job broadcast to clients and when it arrived; job is zero
+// when its notify was malformed
+type sentJob struct {
+	job stratum.Job
+	at  time.Time
+}
+
+// LastJob returns when the last job arrived, zero before the first
+func (r *Router) LastJob() time.Time {
+	if j := r.lastJob.Load(); j != nil {
+		return j.at
+	}
+	return time.Time{}
+}
+
+// RefreshJob re-sends the last job with its ntime rolled forward by the time
+// since it arrived, so miners keep hashing while the pool sends no new
+// work. clean_jobs is cleared, leaving shares for the original valid. It
+// returns false when there is no job, its ntime cannot be read, or the roll
+// would pass submit.ntime_roll_seconds.
+func (r *Router) RefreshJob(now time.Time) bool {
+	j := r.lastJob.Load()
+	if j == nil {
+		return false
+	}
+	job := j.job
+	base := parseNTime(job.NTime)
+	if base == 0 {
+		return false
+	}
+	elapsed := uint32(now.Sub(j.at) / time.Second)
+	if roll := r.config().Submit.NTimeRollSeconds; roll > 0 && elapsed > uint32(roll) {
+		return false
+	}
+	job.NTime = fmt.Sprintf("%08x", base+elapsed)
+	job.Clean = false
+	b, err := job.MarshalNotify()
+	if err != nil {
+		return false
+	}
+	r.broadcastLine(b, stratum.MethodNotify)
+	return true
+}
@@ -3,16 +3,18 @@ package routing
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
-	"math/big"
-	"strconv"
+	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/carlosrabelo/karoo/core/internal/connection"
 	"github.com/carlosrabelo/karoo/core/internal/metrics"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/workername"
 )
 
 // Config holds proxy configuration (subset needed for routing)
@@ -23,6 +25,11 @@ type Config struct {
 	Compat struct {
 		StrictBroadcast bool `json:"strict_broadcast"`
 	} `json:"compat"`
+	Submit  SubmitConfig  `json:"submit"`
+	Pending PendingConfig `json:"pending"`
+
+	Authorize   AuthorizeConfig   `json:"authorize"`
+	WorkerNames workername.Config `json:"worker_names"`
 }
 
 // Client represents a mining client interface for routing package
@@ -45,26 +52,181 @@ type Client interface {
 	WriteLine(string) error
 }
 
+// FrameWriter is implemented by clients that can queue a shared frame
+// without copying it; Broadcast falls back to WriteLine for the others
+type FrameWriter interface {
+	WriteFrame(*stratum.Frame) error
+}
+
+// HandshakeClient is implemented by clients that know whether they sent
+// mining.subscribe; they get no broadcasts until they subscribed, and their
+// submits are refused until they also named a worker with mining.authorize
+type HandshakeClient interface {
+	Subscribed() bool
+}
+
+// SessionClient is implemented by clients with a connection session ID,
+// which log lines carry so connections sharing a worker name can be told
+// apart
+type SessionClient interface {
+	GetSession() string
+}
+
+// SessionOf returns the session ID of a client, or "-" when it has none
+func SessionOf(cl Client) string {
+	if sc, ok := cl.(SessionClient); ok && sc.GetSession() != "" {
+		return sc.GetSession()
+	}
+	return "-"
+}
+
+// Backend answers authorize and submit locally instead of forwarding them
+// upstream (used by solo mining and aggregated submission). Submit may return
+// ErrForward to send a share it accepted on to the upstream pool.
+type Backend interface {
+	Authorize(cl Client, worker, password string) bool
+	Submit(cl Client, params []any) error
+}
+
+// UpstreamObserver is implemented by backends that need to see upstream
+// notifications; returning false keeps the message from reaching clients
+type UpstreamObserver interface {
+	ObserveUpstream(msg stratum.Message) bool
+}
+
+// ErrForward is returned by Backend.Submit for shares that must also be
+// submitted upstream; the pool's reply then answers the client
+var ErrForward = errors.New("forward share upstream")
+
+// ShareEvent describes the outcome of a single mining.submit
+type ShareEvent struct {
+	Time       time.Time
+	Client     Client
+	Worker     string
+	User       string // upstream account the share was submitted as
+	JobID      string
+	Difficulty float64
+	Accepted   bool
+	Latency    time.Duration
+	Reason     string
+	Category   string // canonical reject category, empty when accepted
+	Params     []any  // submit parameters as sent upstream
+}
+
 // Router manages message routing between upstream and downstream connections
 type Router struct {
-	cfg *Config
-	up  *connection.Upstream
-	mx  *metrics.Collector
+	// swapped whole by UpdateConfig; load it once per message
+	cfg      atomic.Pointer[Config]
+	up       *connection.Upstream
+	mx       *metrics.Collector
+	backend  Backend
+	onShare  func(ShareEvent)
+	onAuth   func(cl Client, ok bool)
+	onSubmit func(cl Client, msg stratum.Message, sent bool)
+
+	// proof of work of the connected upstream (nil for sha256d)
+	alg atomic.Pointer[stratum.Algorithm]
 
 	clMu    sync.RWMutex
 	clients map[Client]struct{}
+
+	subMu    sync.Mutex
+	inFlight int
+	subQueue []queuedSubmit
+	held     map[Client][]heldSubmit
+	heldN    int
+
+	pl     pipeline
+	dupMu  sync.Mutex
+	recent map[Client]*recentShares
+	gens   jobGenerations
+	jobs   jobCache
+	auth   authCache
+
+	// the next job is sent with clean_jobs set
+	forceClean atomic.Bool
+
+	// last job broadcast, re-sent during notify gaps
+	lastJob atomic.Pointer[sentJob]
+
+	// float64 bits of the connected upstream's difficulty scale
+	diffScale atomic.Uint64
+
+	// builds the upstream name of each client from its worker name
+	names atomic.Pointer[workername.Rewriter]
 }
 
 // NewRouter creates a new message router
 func NewRouter(cfg *Config, up *connection.Upstream, mx *metrics.Collector) *Router {
-	return &Router{
-		cfg:     cfg,
+	r := &Router{
 		up:      up,
 		mx:      mx,
 		clients: make(map[Client]struct{}),
+		held:    make(map[Client][]heldSubmit),
+		recent:  make(map[Client]*recentShares),
+	}
+	r.cfg.Store(cfg)
+	r.names.Store(workername.New(cfg.WorkerNames))
+	r.initPipeline()
+	return r
+}
+
+// SetBackend routes authorize and submit to a local backend; nil restores
+// upstream forwarding
+func (r *Router) SetBackend(b Backend) {
+	r.backend = b
+}
+
+// SetAlgorithm sets the proof of work of the connected upstream, which job
+// difficulties are reported in
+func (r *Router) SetAlgorithm(a *stratum.Algorithm) {
+	r.alg.Store(a)
+}
+
+// config returns the current configuration
+func (r *Router) config() *Config {
+	return r.cfg.Load()
+}
+
+// algorithm returns the upstream's proof of work
+func (r *Router) algorithm() *stratum.Algorithm {
+	if a := r.alg.Load(); a != nil {
+		return a
+	}
+	return stratum.SHA256d
+}
+
+// SetShareHook registers a callback invoked for every submit outcome
+func (r *Router) SetShareHook(fn func(ShareEvent)) {
+	r.onShare = fn
+}
+
+// SetAuthorizeHook registers a callback invoked with the outcome of every
+// mining.authorize
+func (r *Router) SetAuthorizeHook(fn func(cl Client, ok bool)) {
+	r.onAuth = fn
+}
+
+// SetSubmitHook registers a callback invoked once per submit that reached
+// forward: sent when it went to the upstream or the local backend, not sent
+// when it was answered with an error without reaching either
+func (r *Router) SetSubmitHook(fn func(cl Client, msg stratum.Message, sent bool)) {
+	r.onSubmit = fn
+}
+
+// submitted reports a submit's fate to the submit hook
+func (r *Router) submitted(cl Client, msg stratum.Message, sent bool) {
+	if r.onSubmit != nil && msg.Method == stratum.MethodSubmit {
+		r.onSubmit(cl, msg, sent)
 	}
 }
 
+// UpdateConfig updates the router configuration
+func (r *Router) UpdateConfig(cfg *Config) {
+	r.cfg.Store(cfg)
+	r.names.Store(workername.New(cfg.WorkerNames))
+}
+
 // AddClient adds a client to the routing table
 func (r *Router) AddClient(cl Client) {
 	r.clMu.Lock()
@@ -75,178 +237,321 @@ func (r *Router) AddClient(cl Client) {
 // RemoveClient removes a client from the routing table
 func (r *Router) RemoveClient(cl Client) {
 	r.clMu.Lock()
-	defer r.clMu.Unlock()
 	delete(r.clients, cl)
+	r.clMu.Unlock()
+
+	r.subMu.Lock()
+	r.heldN -= len(r.held[cl])
+	delete(r.held, cl)
+	queued := r.subQueue[:0]
+	for _, q := range r.subQueue {
+		if q.cl != cl {
+			queued = append(queued, q)
+		}
+	}
+	clear(r.subQueue[len(queued):])
+	r.subQueue = queued
+	r.mx.SetSubmitsQueued(int64(len(r.subQueue)))
+	r.subMu.Unlock()
+
+	// Submits already upstream stay pending so their answer is still
+	// accounted; nothing else the client asked for is of use now
+	r.up.DropPendingIf(func(req connection.PendingReq) bool {
+		return req.Client == cl && req.Method != "mining.submit"
+	})
+
+	r.dupMu.Lock()
+	delete(r.recent, cl)
+	r.dupMu.Unlock()
 }
 
 // ForwardToUpstream forwards message to upstream with routing
-func (r *Router) ForwardToUpstream(cl Client, method string, params any, id *int64) bool {
+func (r *Router) ForwardToUpstream(cl Client, method string, params any, id *stratum.ID) bool {
+	return r.forwardRequest(cl, stratum.Message{ID: id, Method: method, Params: params})
+}
+
+// forwardRequest sends a client request upstream under a new ID, keeping
+// the raw line of a submit so it is patched rather than marshalled
+func (r *Router) forwardRequest(cl Client, msg stratum.Message) bool {
 	if !r.up.IsConnected() {
-		r.writeClient(cl, stratum.NewErrorResponse(id, -1, "Upstream down", nil))
-		return false
-	}
-	origID := stratum.CopyID(id)
-	upID, err := r.up.Send(stratum.Message{Method: method, Params: params})
-	if err != nil {
-		r.writeClient(cl, stratum.NewErrorResponse(id, -1, "Forward error", nil))
+		r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, stratum.ErrUpstreamDown))
+		r.submitted(cl, msg, false)
 		return false
 	}
 	req := connection.PendingReq{
 		Client: cl,
-		Method: method,
+		Method: msg.Method,
+		Params: msg.Params,
 		Sent:   time.Now(),
-		OrigID: origID,
+		OrigID: stratum.CopyID(msg.ID),
+	}
+	if _, err := r.up.Request(stratum.Message{Method: msg.Method, Params: msg.Params, Raw: msg.Raw}, req); err != nil {
+		r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, stratum.ErrForwardFailed))
+		r.submitted(cl, msg, false)
+		return false
 	}
-	r.up.AddPendingRequest(upID, req)
+	r.submitted(cl, msg, true)
 	return true
 }
 
 // Broadcast sends message to all connected clients
 func (r *Router) Broadcast(line string) {
+	f := stratum.NewFrameString(line)
+	r.BroadcastFrame(f, "")
+	f.Release()
+}
+
+// BroadcastFrame sends a frame serialized once to all subscribed clients;
+// method labels the ones skipped in the suppressed counter. The caller keeps
+// its own reference to the frame.
+func (r *Router) BroadcastFrame(f *stratum.Frame, method string) {
+	if method == "" {
+		method = "other"
+	}
 	r.clMu.RLock()
 	defer r.clMu.RUnlock()
 	for cl := range r.clients {
-		if err := cl.WriteLine(line); err != nil {
-			log.Printf("broadcast write error to %s: %v", cl.GetAddr(), err)
+		if hc, ok := cl.(HandshakeClient); ok && !hc.Subscribed() {
+			r.mx.IncrementBroadcastSuppressed(method)
+			continue
+		}
+		var err error
+		if fw, ok := cl.(FrameWriter); ok {
+			err = fw.WriteFrame(f)
+		} else {
+			b := f.Bytes()
+			err = cl.WriteLine(string(b[:len(b)-1]))
+		}
+		if err != nil {
+			log.Printf("broadcast write error to %s session=%s: %v", cl.GetAddr(), SessionOf(cl), err)
 		}
 	}
 }
 
-// ProcessClientMessage processes a message from a client
-func (r *Router) ProcessClientMessage(cl Client, msg stratum.Message) {
-	switch msg.Method {
-	case "mining.subscribe":
-		// This will be handled by the nonce manager
-		return
+// broadcastLine frames an upstream line once and sends it to all clients
+func (r *Router) broadcastLine(line []byte, method string) {
+	f := stratum.NewFrame(line)
+	r.BroadcastFrame(f, method)
+	f.Release()
+}
 
-	case "mining.authorize":
-		if arr, ok := msg.Params.([]any); ok && len(arr) > 0 {
-			if s, ok := arr[0].(string); ok {
-				cl.SetWorker(s)
-			}
-		}
-		r.ForwardToUpstream(cl, msg.Method, msg.Params, msg.ID)
+// ProcessClientMessage runs a client message through the pipeline
+func (r *Router) ProcessClientMessage(cl Client, msg stratum.Message) {
+	r.clientChain()(cl, msg)
+}
 
-	case "mining.submit":
-		r.processSubmit(cl, msg)
+// authorizeLocal answers mining.authorize through the local backend
+func (r *Router) authorizeLocal(cl Client, msg stratum.Message) {
+	a, _ := stratum.ParseAuthorize(msg.Params)
+	ok := r.backend.Authorize(cl, cl.GetWorker(), a.Password)
+	// answer first: the handshake flag releases work held for the client
+	r.writeClient(cl, stratum.NewSuccessResponse(msg.ID, ok))
+	if ok {
+		cl.SetHandshakeDone(true)
+	}
+	if r.onAuth != nil {
+		r.onAuth(cl, ok)
+	}
+}
 
-	default:
-		// Generic pass-through for any mining.* call
-		if strings.HasPrefix(msg.Method, "mining.") {
-			r.ForwardToUpstream(cl, msg.Method, msg.Params, msg.ID)
-		}
+// submitLocal validates a share through the local backend and accounts it
+func (r *Router) submitLocal(cl Client, msg stratum.Message) {
+	start := time.Now()
+	arr, _ := msg.Params.([]any)
+	err := r.backend.Submit(cl, arr)
+	if errors.Is(err, ErrForward) {
+		r.dispatchSubmit(cl, msg)
+		return
+	}
+	r.submitted(cl, msg, true)
+	var code int
+	var reason string
+	if err == nil {
+		r.writeClient(cl, stratum.NewSuccessResponse(msg.ID, true))
+	} else {
+		code, reason = stratum.ErrorCode(err), err.Error()
+		r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, err))
 	}
+	r.accountShare(cl, msg.Params, err == nil, time.Since(start), code, reason)
 }
 
-// processSubmit processes mining.submit message with nonce transformation
-func (r *Router) processSubmit(cl Client, msg stratum.Message) {
-	if arr, ok := msg.Params.([]any); ok && len(arr) > 0 {
-		if cl.GetUpUser() == "" {
-			cl.SetUpUser(r.cfg.Upstream.User)
-		}
-		arr[0] = cl.GetUpUser()
-
-		// Handle extranonce transformation
-		if len(arr) > 2 && cl.GetExtraNoncePrefix() != "" && cl.GetExtraNonceTrim() > 0 {
-			if s, ok := arr[2].(string); ok {
-				sUp := strings.ToUpper(s)
-				prefix := cl.GetExtraNoncePrefix()
-				_, ex2Size := r.up.GetExtranonce()
-				expectedLen := (ex2Size - cl.GetExtraNonceTrim()) * 2
-
-				switch {
-				case len(sUp) == expectedLen:
-					sUp = prefix + sUp
-				case len(sUp) == ex2Size*2:
-					if !strings.HasPrefix(sUp, prefix) {
-						sUp = prefix + sUp[len(prefix):]
-					}
-				default:
-					if !strings.HasPrefix(sUp, prefix) {
-						sUp = prefix + sUp
-					}
-				}
-				arr[2] = sUp
+// rewriteSubmit sets the upstream user and extranonce of a mining.submit
+func (r *Router) rewriteSubmit(cl Client, msg *stratum.Message) {
+	s, err := stratum.ParseSubmit(msg.Params)
+	if err != nil {
+		return
+	}
+	if cl.GetUpUser() == "" {
+		cl.SetUpUser(r.config().Upstream.User)
+	}
+	s.Worker = cl.GetUpUser()
+
+	// Handle extranonce transformation
+	if cl.GetExtraNoncePrefix() != "" && cl.GetExtraNonceTrim() > 0 {
+		sUp := strings.ToUpper(s.Extranonce2)
+		prefix := cl.GetExtraNoncePrefix()
+		_, ex2Size := r.up.GetExtranonce()
+		expectedLen := (ex2Size - cl.GetExtraNonceTrim()) * 2
+
+		switch {
+		case len(sUp) == expectedLen:
+			sUp = prefix + sUp
+		case len(sUp) == ex2Size*2:
+			if !strings.HasPrefix(sUp, prefix) {
+				sUp = prefix + sUp[len(prefix):]
+			}
+		default:
+			if !strings.HasPrefix(sUp, prefix) {
+				sUp = prefix + sUp
 			}
 		}
-		msg.Params = arr
+		s.Extranonce2 = sUp
+	}
+	// patch the decoded params in place rather than building new ones; the
+	// raw line, if kept, gets the same two fields when it is forwarded
+	if arr, ok := msg.Params.([]any); ok {
+		if arr[0] != s.Worker {
+			arr[0] = s.Worker
+		}
+		if arr[2] != s.Extranonce2 {
+			arr[2] = s.Extranonce2
+		}
 	}
-	r.ForwardToUpstream(cl, "mining.submit", msg.Params, msg.ID)
 }
 
 // ProcessUpstreamMessage processes a message from upstream
 func (r *Router) ProcessUpstreamMessage(line string) {
-	var msg stratum.Message
-	if err := json.Unmarshal([]byte(line), &msg); err != nil {
-		return
-	}
+	_, _ = r.ProcessUpstreamLine([]byte(line))
+}
 
-	if msg.Method != "" {
-		r.processUpstreamNotification(msg, line)
-		return
+// ProcessUpstreamLine routes one upstream line and returns it decoded, so
+// the caller need not parse it again. line is only read during the call.
+func (r *Router) ProcessUpstreamLine(line []byte) (stratum.Message, error) {
+	var msg stratum.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, err
 	}
-
-	// Handle responses
-	if msg.Result != nil && msg.ID != nil {
-		r.processUpstreamResponse(msg)
+	if msg.Method == stratum.MethodNotify {
+		r.jobs.notify(msg.Params, time.Now())
 	}
+	r.upstreamChain()(msg, line)
+	return msg, nil
 }
 
 // processUpstreamNotification handles notifications from upstream
-func (r *Router) processUpstreamNotification(msg stratum.Message, line string) {
+func (r *Router) processUpstreamNotification(msg stratum.Message, line []byte) {
 	switch msg.Method {
 	case "mining.set_difficulty":
+		if scaled, ok := r.scaleDifficulty(&msg); ok {
+			line = scaled
+		}
 		// Store difficulty in metrics
-		if arr, ok := msg.Params.([]any); ok && len(arr) > 0 {
-			if v, ok := arr[0].(float64); ok {
-				r.mx.SetLastSetDifficulty(int64(v))
-			}
+		if sd, err := stratum.ParseSetDifficulty(msg.Params); err == nil {
+			r.mx.SetLastSetDifficulty(int64(sd.Difficulty))
 		}
-		r.Broadcast(line)
+		if !r.observe(msg) {
+			return
+		}
+		r.broadcastLine(line, msg.Method)
 
 	case "mining.notify":
 		// Track notify timestamp in metrics
 		r.mx.SetLastNotify(time.Now())
 
-		if arr, ok := msg.Params.([]any); ok {
-			var jobID, nbits string
-			var clean bool
-			if len(arr) > 0 {
-				if s, ok := arr[0].(string); ok {
-					jobID = s
-				}
-			}
-			if len(arr) > 6 {
-				if s, ok := arr[6].(string); ok {
-					nbits = s
-				}
+		job, parsed := r.UpstreamJob()
+		if parsed {
+			r.gens.add(job.ID, parseNTime(job.NTime), job.Clean, time.Now())
+			if job.Clean {
+				diff := stratum.DiffFromBits(job.NBits)
+				log.Printf("new job job=%s diff=%.6g", job.ID, diff)
 			}
-			if len(arr) > 8 {
-				switch v := arr[8].(type) {
-				case bool:
-					clean = v
-				case string:
-					clean = strings.EqualFold(v, "true")
-				}
-			}
-			if clean {
-				diff := diffFromBits(nbits)
-				log.Printf("new job job=%s diff=%.6g", jobID, diff)
+		}
+		if !r.observe(msg) {
+			return
+		}
+		r.lastJob.Store(&sentJob{job: job, at: time.Now()})
+		if r.forceClean.Swap(false) && parsed {
+			if forced, ok := cleanNotify(job); ok {
+				log.Printf("job sent with clean_jobs after an upstream switch")
+				r.broadcastLine(forced, msg.Method)
+				return
 			}
 		}
-		r.Broadcast(line)
+		r.broadcastLine(line, msg.Method)
 
 	default:
 		// Compatibility mode: when strict is off, forward any unrecognized mining.*
-		if !r.cfg.Compat.StrictBroadcast && strings.HasPrefix(msg.Method, "mining.") {
-			r.Broadcast(line)
+		if !r.config().Compat.StrictBroadcast && strings.HasPrefix(msg.Method, "mining.") {
+			r.broadcastLine(line, msg.Method)
 		}
 	}
 }
 
+// SetDifficultyScale sets the factor by which the connected pool's
+// difficulties exceed standard ones; 0 or 1 passes them through
+func (r *Router) SetDifficultyScale(scale float64) {
+	r.diffScale.Store(math.Float64bits(scale))
+}
+
+// scaleDifficulty converts a pool set_difficulty to the standard scale in
+// place and returns the re-encoded line; false when no scale applies
+func (r *Router) scaleDifficulty(msg *stratum.Message) ([]byte, bool) {
+	scale := math.Float64frombits(r.diffScale.Load())
+	if scale <= 0 || scale == 1 {
+		return nil, false
+	}
+	sd, err := stratum.ParseSetDifficulty(msg.Params)
+	if err != nil {
+		return nil, false
+	}
+	sd.Difficulty /= scale
+	msg.Params = sd.Params()
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// ForceCleanJobs makes the next mining.notify tell miners to drop their
+// current work, for an upstream switch whose first job may not
+func (r *Router) ForceCleanJobs() {
+	r.forceClean.Store(true)
+}
+
+// cleanNotify encodes a job with clean_jobs set; false when it already was
+func cleanNotify(job stratum.Job) ([]byte, bool) {
+	if job.Clean {
+		return nil, false
+	}
+	job.Clean = true
+	b, err := job.MarshalNotify()
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// observe passes an upstream notification to the backend, if it watches them,
+// and reports whether the message should still be broadcast
+func (r *Router) observe(msg stratum.Message) bool {
+	if obs, ok := r.backend.(UpstreamObserver); ok {
+		return obs.ObserveUpstream(msg)
+	}
+	return true
+}
+
 // processUpstreamResponse handles responses from upstream
 func (r *Router) processUpstreamResponse(msg stratum.Message) {
-	req, exists := r.up.RemovePendingRequest(*msg.ID)
+	upID, ok := msg.ID.Int64()
+	if !ok {
+		return
+	}
+	req, exists := r.up.RemovePendingRequest(upID)
+	if probe, ok := req.Client.(authProbe); exists && ok {
+		r.authorizedAs(probe, msg)
+		return
+	}
 	if !exists || req.Client == nil {
 		return
 	}
@@ -258,11 +563,15 @@ func (r *Router) processUpstreamResponse(msg stratum.Message) {
 	}
 	client := req.Client.(Client)
 	if err := client.WriteJSON(msg); err != nil {
-		log.Printf("response write error to %s: %v", client.GetAddr(), err)
+		log.Printf("response write error to %s session=%s: %v", client.GetAddr(), SessionOf(client), err)
 	}
 
 	switch req.Method {
 	case "mining.submit":
+		r.submitDone()
+		rtt := time.Since(req.Sent)
+		r.mx.ObserveSubmitRTT(rtt)
+		r.mx.ObserveSubmitLatency(rtt, client.GetWorker(), SessionOf(client))
 		r.handleSubmitResponse(req, msg)
 	case "mining.authorize":
 		r.handleAuthorizeResponse(req, msg)
@@ -271,22 +580,38 @@ func (r *Router) processUpstreamResponse(msg stratum.Message) {
 
 // handleSubmitResponse handles submit response from upstream
 func (r *Router) handleSubmitResponse(req connection.PendingReq, msg stratum.Message) {
-	client := req.Client.(Client)
 	success := false
 	if b, ok := msg.Result.(bool); ok {
 		success = b
 	}
+	var code int
+	var reason string
+	if !success {
+		code, reason = stratum.ParseError(msg.Error)
+	}
+	r.accountShare(req.Client.(Client), req.Params, success, time.Since(req.Sent), code, reason)
+}
+
+// accountShare updates share counters, logs the outcome of a submit and
+// reports it to the share hook
+func (r *Router) accountShare(client Client, params any, success bool, latency time.Duration, code int, reason string) {
+	worker := client.GetWorker()
+	if worker == "" {
+		worker = client.GetAddr()
+	}
 
 	// Increment share counters
+	var category string
 	if success {
 		client.IncrementOK()
 		r.mx.IncrementSharesOK()
 	} else {
+		category = stratum.ClassifyReject(code, reason)
 		client.IncrementBad()
 		r.mx.IncrementSharesBad()
+		r.mx.IncrementRejectReason(worker, category)
 	}
 
-	latency := time.Since(req.Sent)
 	var sincePrev time.Duration
 	if success {
 		nowMs := time.Now().UnixMilli()
@@ -300,72 +625,50 @@ func (r *Router) handleSubmitResponse(req connection.PendingReq, msg stratum.Mes
 	totalOK := client.GetOK()
 	totalBad := client.GetBad()
 	totalShares := totalOK + totalBad
-	status := "Rejected"
 	if success {
-		status = "Accepted"
+		log.Printf("share Accepted worker=%s session=%s share=%d ok=%d bad=%d since_prev=%s latency=%s",
+			worker, SessionOf(client), totalShares, totalOK, totalBad, fmtDuration(sincePrev), latency)
+	} else {
+		log.Printf("share Rejected worker=%s session=%s share=%d ok=%d bad=%d reason=%s (%q) latency=%s",
+			worker, SessionOf(client), totalShares, totalOK, totalBad, category, reason, latency)
 	}
-	worker := client.GetWorker()
-	if worker == "" {
-		worker = client.GetAddr()
+
+	if r.onShare != nil {
+		ev := ShareEvent{
+			Time:       time.Now(),
+			Client:     client,
+			Worker:     worker,
+			Difficulty: float64(r.mx.LastSetDiff.Load()),
+			Accepted:   success,
+			Latency:    latency,
+			Reason:     reason,
+			Category:   category,
+		}
+		if s, err := stratum.ParseSubmit(params); err == nil {
+			ev.User, ev.JobID = s.Worker, s.JobID
+			ev.Params = s.Params()
+		}
+		r.onShare(ev)
 	}
-	log.Printf("share %s worker=%s share=%d ok=%d bad=%d since_prev=%s latency=%s",
-		status, worker, totalShares, totalOK, totalBad, fmtDuration(sincePrev), latency)
 }
 
 // handleAuthorizeResponse handles authorize response from upstream
 func (r *Router) handleAuthorizeResponse(req connection.PendingReq, msg stratum.Message) {
 	client := req.Client.(Client)
-	if res, ok := msg.Result.(bool); ok && res {
+	res, _ := msg.Result.(bool)
+	if res {
 		client.SetHandshakeDone(true)
 	}
+	if r.onAuth != nil {
+		r.onAuth(client, res)
+	}
 }
 
 // writeClient writes a message to a client
 func (r *Router) writeClient(cl Client, msg stratum.Message) {
 	if err := cl.WriteJSON(msg); err != nil {
-		log.Printf("client write error to %s: %v", cl.GetAddr(), err)
-	}
-}
-
-// diffFromBits converts Bitcoin-style difficulty bits (compact target) to difficulty value.
-// The nBits format is a compact representation of a 256-bit target threshold.
-//
-// Format: 0x1d00ffff
-//   - First byte (0x1d): exponent (number of bytes in target)
-//   - Remaining 3 bytes (0x00ffff): mantissa (coefficient)
-//
-// Calculation:
-//  1. Extract exponent and mantissa from compact format
-//  2. Compute target = mantissa * 2^(8*(exponent-3))
-//  3. Compute difficulty = difficulty_1_target / target
-//
-// Where difficulty_1_target = 0xFFFF * 2^(8*(0x1d-3))
-//
-// Returns 0 for invalid inputs.
-func diffFromBits(bits string) float64 {
-	bits = strings.TrimPrefix(bits, "0x")
-	if bits == "" {
-		return 0
-	}
-	val, err := strconv.ParseUint(bits, 16, 32)
-	if err != nil {
-		return 0
-	}
-	exponent := byte(val >> 24)
-	mantissa := val & 0xFFFFFF
-	if mantissa == 0 || exponent <= 3 {
-		return 0
-	}
-	target := new(big.Int).Lsh(big.NewInt(int64(mantissa)), uint(8*(int(exponent)-3)))
-	if target.Sign() <= 0 {
-		return 0
-	}
-	diffOne := new(big.Int).Lsh(big.NewInt(0xFFFF), uint(8*(0x1d-3)))
-	t := new(big.Float).SetInt(target)
-	d := new(big.Float).SetInt(diffOne)
-	res := new(big.Float).Quo(d, t)
-	out, _ := res.Float64()
-	return out
+		log.Printf("client write error to %s session=%s: %v", cl.GetAddr(), SessionOf(cl), err)
+	}
 }
 
 // fmtDuration formats duration for logging with millisecond precision.
@@ -1,12 +1,21 @@
 package routing
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/carlosrabelo/karoo/core/internal/connection"
 	"github.com/carlosrabelo/karoo/core/internal/metrics"
 	"github.com/carlosrabelo/karoo/core/internal/stratum"
+	"github.com/carlosrabelo/karoo/core/internal/workername"
 )
 
 // mockClient implements the Client interface for testing
@@ -81,7 +90,7 @@ func TestNewRouter(t *testing.T) {
 	if r == nil {
 		t.Fatal("NewRouter returned nil")
 	}
-	if r.cfg != cfg {
+	if r.cfg.Load() != cfg {
 		t.Error("Config not set correctly")
 	}
 	if r.up != up {
@@ -121,14 +130,28 @@ func TestRemoveClient(t *testing.T) {
 	r := NewRouter(cfg, up, mx)
 
 	cl := &mockClient{addr: "192.168.1.1:12345"}
+	other := &mockClient{addr: "192.168.1.2:12345"}
 	r.AddClient(cl)
+	r.AddClient(other)
+	up.AddPendingRequest(1, connection.PendingReq{Client: cl, Method: "mining.submit", Sent: time.Now()})
+	up.AddPendingRequest(2, connection.PendingReq{Client: cl, Method: "mining.authorize", Sent: time.Now()})
+	up.AddPendingRequest(3, connection.PendingReq{Client: other, Method: "mining.authorize", Sent: time.Now()})
+	r.subQueue = []queuedSubmit{{cl: cl}, {cl: other}, {cl: cl}}
 	r.RemoveClient(cl)
 
 	r.clMu.RLock()
-	if len(r.clients) != 0 {
-		t.Errorf("Expected 0 clients, got %d", len(r.clients))
+	if len(r.clients) != 1 {
+		t.Errorf("Expected 1 client, got %d", len(r.clients))
 	}
 	r.clMu.RUnlock()
+
+	// the submit already upstream stays to be accounted; the rest goes
+	if up.PendingCount() != 2 {
+		t.Errorf("pending = %d, want 2", up.PendingCount())
+	}
+	if len(r.subQueue) != 1 || r.subQueue[0].cl != other {
+		t.Errorf("queued submits = %v, want only the other client's", r.subQueue)
+	}
 }
 
 func TestBroadcast(t *testing.T) {
@@ -182,31 +205,6 @@ func TestWriteClient(t *testing.T) {
 	// Should not panic
 }
 
-func TestDiffFromBits(t *testing.T) {
-	tests := []struct {
-		name  string
-		bits  string
-		valid bool
-	}{
-		{"valid with 0x", "0x1d00ffff", true},
-		{"valid without 0x", "1d00ffff", true},
-		{"empty string", "", false},
-		{"invalid hex", "invalid", false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			diff := diffFromBits(tt.bits)
-			if tt.valid && diff == 0 {
-				t.Errorf("diffFromBits(%s) returned 0 for valid input", tt.bits)
-			}
-			if !tt.valid && diff != 0 {
-				t.Errorf("diffFromBits(%s) returned non-zero for invalid input", tt.bits)
-			}
-		})
-	}
-}
-
 func TestFmtDuration(t *testing.T) {
 	tests := []struct {
 		name string
@@ -230,10 +228,804 @@ func TestFmtDuration(t *testing.T) {
 }
 
 // Helper functions
-func intPtr(i int64) *int64 {
-	return &i
+func intPtr(i int64) *stratum.ID {
+	return stratum.NewID(i)
 }
 
 func toDuration(ms int64) time.Duration {
 	return time.Duration(ms) * time.Millisecond
 }
+
+// fakeBackend records local authorize/submit calls
+type fakeBackend struct {
+	submits int
+	err     error
+}
+
+func (f *fakeBackend) Authorize(cl Client, worker, password string) bool { return worker != "" }
+func (f *fakeBackend) Submit(cl Client, params []any) error {
+	f.submits++
+	return f.err
+}
+
+func TestLocalBackend(t *testing.T) {
+	up := createTestUpstream()
+	up.SetExtranonce("aabbccdd", 8)
+	r := NewRouter(createTestConfig(), up, metrics.NewCollector())
+	be := &fakeBackend{}
+	r.SetBackend(be)
+
+	cl := &mockClient{addr: "127.0.0.1:1"}
+	id := stratum.NewID(1)
+	r.ProcessClientMessage(cl, stratum.Message{ID: id, Method: "mining.authorize", Params: []any{"rig1", "x"}})
+	if !cl.handshakeDone {
+		t.Error("expected local authorize to complete the handshake")
+	}
+
+	submit := stratum.Message{ID: id, Method: "mining.submit", Params: []any{"rig1", "1", "00000000000000", "6553f100", "00000000"}}
+	r.ProcessClientMessage(cl, submit)
+	be.err = &stratum.Error{Code: 23, Message: "Low difficulty share"}
+	r.ProcessClientMessage(cl, submit)
+
+	if be.submits != 2 {
+		t.Errorf("backend submits = %d, want 2", be.submits)
+	}
+	if cl.ok != 1 || cl.bad != 1 {
+		t.Errorf("ok=%d bad=%d, want 1/1", cl.ok, cl.bad)
+	}
+}
+
+func TestSubmitInFlightCap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, c)
+		}
+	}()
+
+	up := createTestUpstream()
+	addr := ln.Addr().(*net.TCPAddr)
+	up.UpdateTarget("127.0.0.1", addr.Port, "u", "x", false, false)
+	if err := up.Dial(context.Background()); err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer up.Close()
+
+	cfg := createTestConfig()
+	cfg.Submit = SubmitConfig{MaxInFlight: 1, QueueSize: 1}
+	mx := metrics.NewCollector()
+	r := NewRouter(cfg, up, mx)
+	cl := &mockClient{addr: "127.0.0.1:1"}
+
+	for i := 0; i < 3; i++ {
+		id := stratum.NewID(int64(i + 1))
+		r.ProcessClientMessage(cl, stratum.Message{ID: id, Method: "mining.submit", Params: []any{"w", "1", "00", "00", "00"}})
+	}
+	if mx.SubmitsInFlight.Load() != 1 || mx.SubmitsQueued.Load() != 1 || mx.SubmitsDropped.Load() != 1 {
+		t.Fatalf("inflight=%d queued=%d dropped=%d, want 1/1/1",
+			mx.SubmitsInFlight.Load(), mx.SubmitsQueued.Load(), mx.SubmitsDropped.Load())
+	}
+
+	// answering the first submit releases the queued one
+	r.ProcessUpstreamMessage(`{"id":1,"result":true}`)
+	if mx.SubmitsInFlight.Load() != 1 || mx.SubmitsQueued.Load() != 0 {
+		t.Errorf("after response inflight=%d queued=%d, want 1/0", mx.SubmitsInFlight.Load(), mx.SubmitsQueued.Load())
+	}
+
+	// a rejection carried only in the error field still completes the submit
+	r.ProcessUpstreamMessage(`{"id":2,"result":null,"error":[23,"Low difficulty share",null]}`)
+	if mx.SubmitsInFlight.Load() != 0 {
+		t.Errorf("inflight=%d after error response, want 0", mx.SubmitsInFlight.Load())
+	}
+	if cl.ok != 1 || cl.bad != 1 {
+		t.Errorf("ok=%d bad=%d, want 1/1", cl.ok, cl.bad)
+	}
+}
+
+func TestShareHookReason(t *testing.T) {
+	up := createTestUpstream()
+	r := NewRouter(createTestConfig(), up, metrics.NewCollector())
+	var events []ShareEvent
+	r.SetShareHook(func(ev ShareEvent) { events = append(events, ev) })
+
+	cl := &mockClient{addr: "127.0.0.1:1", worker: "rig1"}
+	up.AddPendingRequest(7, connection.PendingReq{
+		Client: cl,
+		Method: "mining.submit",
+		Params: []any{"u", "job42", "00", "00", "00"},
+		Sent:   time.Now(),
+	})
+	r.ProcessUpstreamMessage(`{"id":7,"result":null,"error":[21,"Job not found",null]}`)
+
+	if len(events) != 1 {
+		t.Fatalf("got %d share events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Accepted || ev.JobID != "job42" || ev.Reason != "Job not found" || ev.Worker != "rig1" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if ev.Category != stratum.RejectStale {
+		t.Errorf("category = %q, want %q", ev.Category, stratum.RejectStale)
+	}
+	if got := r.mx.GetRejectReasons()[stratum.RejectStale]; got != 1 {
+		t.Errorf("stale rejects = %d, want 1", got)
+	}
+}
+
+type observingBackend struct {
+	fakeBackend
+	seen []string
+}
+
+func (o *observingBackend) ObserveUpstream(msg stratum.Message) bool {
+	o.seen = append(o.seen, msg.Method)
+	return msg.Method != "mining.set_difficulty"
+}
+
+func TestBackendForwardAndObserve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, c)
+		}
+	}()
+
+	up := createTestUpstream()
+	addr := ln.Addr().(*net.TCPAddr)
+	up.UpdateTarget("127.0.0.1", addr.Port, "u", "x", false, false)
+	if err := up.Dial(context.Background()); err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer up.Close()
+
+	mx := metrics.NewCollector()
+	r := NewRouter(createTestConfig(), up, mx)
+	be := &observingBackend{fakeBackend: fakeBackend{err: ErrForward}}
+	r.SetBackend(be)
+	cl := &mockClient{addr: "127.0.0.1:1"}
+
+	id := stratum.NewID(1)
+	r.ProcessClientMessage(cl, stratum.Message{ID: id, Method: "mining.submit", Params: []any{"w", "1", "00", "00", "00"}})
+	if mx.SubmitsInFlight.Load() != 1 || cl.ok != 0 || cl.bad != 0 {
+		t.Errorf("forwarded share: inflight=%d ok=%d bad=%d, want 1/0/0", mx.SubmitsInFlight.Load(), cl.ok, cl.bad)
+	}
+
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.set_difficulty","params":[4096]}`)
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.notify","params":["1","00","00","00",[],"20000000","1d00ffff","495fab29",true]}`)
+	if len(be.seen) != 2 || mx.LastSetDiff.Load() != 4096 {
+		t.Errorf("observer saw %v, last diff %d", be.seen, mx.LastSetDiff.Load())
+	}
+}
+
+func TestReapPending(t *testing.T) {
+	up := createTestUpstream()
+	cfg := createTestConfig()
+	cfg.Pending = PendingConfig{TimeoutMs: 1000}
+	mx := metrics.NewCollector()
+	r := NewRouter(cfg, up, mx)
+	cl := &mockClient{addr: "127.0.0.1:1", worker: "rig1"}
+
+	now := time.Now()
+	up.AddPendingRequest(1, connection.PendingReq{Client: cl, Method: "mining.submit", Sent: now.Add(-2 * time.Second), OrigID: stratum.NewID(3)})
+	up.AddPendingRequest(2, connection.PendingReq{Client: cl, Method: "mining.submit", Sent: now})
+	r.inFlight = 2
+
+	if n := r.ReapPending(now); n != 1 {
+		t.Fatalf("reaped %d requests, want 1", n)
+	}
+	if up.PendingCount() != 1 {
+		t.Errorf("pending = %d, want 1", up.PendingCount())
+	}
+	if mx.RequestTimeouts.Load() != 1 {
+		t.Errorf("timeouts = %d, want 1", mx.RequestTimeouts.Load())
+	}
+	if mx.SubmitsInFlight.Load() != 1 {
+		t.Errorf("inflight = %d, want 1", mx.SubmitsInFlight.Load())
+	}
+
+	// a late response to the expired request is ignored
+	r.ProcessUpstreamMessage(`{"id":1,"result":true}`)
+	if cl.ok != 0 || mx.SubmitsInFlight.Load() != 1 {
+		t.Errorf("late response accounted: ok=%d inflight=%d", cl.ok, mx.SubmitsInFlight.Load())
+	}
+}
+
+func TestClientPipeline(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Submit.Dedupe = true
+	cfg.Submit.Validate = true
+	r := NewRouter(cfg, createTestUpstream(), metrics.NewCollector())
+	be := &fakeBackend{}
+	r.SetBackend(be)
+
+	// a policy before dedupe sees the rewritten submit and may stop it
+	var users []any
+	if err := r.InsertClient(StageDedupe, "filter", func(next ClientHandler) ClientHandler {
+		return func(cl Client, msg stratum.Message) {
+			if msg.Method == "mining.extranonce.subscribe" {
+				return
+			}
+			if msg.Method == stratum.MethodSubmit {
+				users = append(users, msg.Params.([]any)[0])
+			}
+			next(cl, msg)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertClient("missing", "x", func(next ClientHandler) ClientHandler { return next }); err == nil {
+		t.Error("insert before an unknown stage accepted")
+	}
+	if err := r.UseClient("filter", func(next ClientHandler) ClientHandler { return next }); err == nil {
+		t.Error("duplicate stage name accepted")
+	}
+	want := []string{StageAuth, StageRewrite, "filter", StageDedupe, StageValidate, StageStale, StageForward}
+	if got := r.ClientStages(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("stages = %v, want %v", got, want)
+	}
+
+	cl := &mockClient{addr: "127.0.0.1:1"}
+	r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: "mining.extranonce.subscribe"})
+	submit := func(nonce string) {
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(2), Method: stratum.MethodSubmit, Params: []any{"rig1", "1", "00", "6553f100", nonce}})
+	}
+	submit("0000000a")
+	submit("0000000A") // the same share in another case
+	submit("zz")
+	if be.submits != 1 || cl.ok != 1 || cl.bad != 2 {
+		t.Errorf("backend submits=%d ok=%d bad=%d, want 1/1/2", be.submits, cl.ok, cl.bad)
+	}
+	if len(users) != 3 || users[0] != "testuser" {
+		t.Errorf("filter saw users %v", users)
+	}
+
+	// upstream stages run before broadcast
+	var seen []string
+	_ = r.UseUpstream("drop-notify", func(next UpstreamHandler) UpstreamHandler {
+		return func(msg stratum.Message, line []byte) {
+			seen = append(seen, msg.Method)
+			if msg.Method != stratum.MethodNotify {
+				next(msg, line)
+			}
+		}
+	})
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.notify","params":["1","00","00","00",[],"20000000","1d00ffff","495fab29",true]}`)
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.set_difficulty","params":[512]}`)
+	if len(seen) != 2 || r.mx.LastSetDiff.Load() != 512 || r.mx.LastNotifyUnix.Load() != 0 {
+		t.Errorf("upstream stage saw %v", seen)
+	}
+}
+
+func TestCleanNotify(t *testing.T) {
+	job, _ := stratum.ParseNotify([]any{"1", "00", "00", "00", []any{}, "20000000", "1d00ffff", "495fab29", false})
+	b, ok := cleanNotify(job)
+	if !ok {
+		t.Fatal("job not re-encoded")
+	}
+	forced, ok := stratum.ParseNotify(mustParams(t, b))
+	if !ok || !forced.Clean || forced.ID != "1" {
+		t.Errorf("forced job = %+v", forced)
+	}
+	if job.Clean {
+		t.Error("original job changed")
+	}
+	job.Clean = true
+	if _, ok := cleanNotify(job); ok {
+		t.Error("clean job re-encoded")
+	}
+}
+
+func mustParams(t *testing.T, line []byte) any {
+	t.Helper()
+	var m stratum.Message
+	if err := m.Unmarshal(line); err != nil {
+		t.Fatal(err)
+	}
+	return m.Params
+}
+
+func TestStaleSubmits(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		grace  int
+		want   int // submits reaching the backend out of three
+	}{
+		{"", 0, 3},
+		{StaleForward, 60000, 3},
+		{StaleForward, 1, 2},
+		{StaleDrop, 60000, 2},
+	} {
+		cfg := createTestConfig()
+		cfg.Submit.StalePolicy = tc.policy
+		cfg.Submit.StaleGraceMs = tc.grace
+		r := NewRouter(cfg, createTestUpstream(), metrics.NewCollector())
+		be := &fakeBackend{}
+		r.SetBackend(be)
+
+		notify := func(id string, clean bool) {
+			line := fmt.Sprintf(`{"id":null,"method":"mining.notify","params":["%s","","","",[],"","","",%v]}`, id, clean)
+			if _, err := r.ProcessUpstreamLine([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		notify("1", true)
+		notify("2", false)
+		notify("3", true)
+		time.Sleep(5 * time.Millisecond)
+
+		cl := &mockClient{addr: "127.0.0.1:1"}
+		for i, job := range []string{"2", "3", "unknown"} {
+			r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig1", job, "00", "00", "00"}})
+		}
+		if be.submits != tc.want || int(cl.bad) != 3-tc.want {
+			t.Errorf("policy %q grace %d: %d submits forwarded, %d refused; want %d", tc.policy, tc.grace, be.submits, cl.bad, tc.want)
+		}
+	}
+}
+
+func TestNTimeRoll(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Submit.NTimeRollSeconds = 600
+	r := NewRouter(cfg, createTestUpstream(), metrics.NewCollector())
+	be := &fakeBackend{}
+	r.SetBackend(be)
+	if _, err := r.ProcessUpstreamLine([]byte(`{"id":null,"method":"mining.notify","params":["j","","","",[],"","","65000000",true]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := &mockClient{addr: "127.0.0.1:1"}
+	for i, sub := range [][2]string{
+		{"j", "65000000"},
+		{"j", "65000258"}, // +600s, the edge of the window
+		{"j", "65000259"},
+		{"j", "64ffffff"},
+		{"other", "70000000"}, // unknown job: left to the pool
+	} {
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig1", sub[0], "00", sub[1], "00"}})
+	}
+	if be.submits != 3 || cl.bad != 2 {
+		t.Errorf("%d submits forwarded, %d refused; want 3 and 2", be.submits, cl.bad)
+	}
+}
+
+func TestUpdateConfigConcurrent(t *testing.T) {
+	r := NewRouter(createTestConfig(), createTestUpstream(), metrics.NewCollector())
+	be := &fakeBackend{}
+	r.SetBackend(be)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cfg := createTestConfig()
+			cfg.Submit.Dedupe = i%2 == 0
+			cfg.Submit.NTimeRollSeconds = 600
+			r.UpdateConfig(cfg)
+		}
+	}()
+	cl := &mockClient{addr: "127.0.0.1:1"}
+	for i := 0; i < 100; i++ {
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig1", "j", "00", fmt.Sprintf("%08x", i), "00"}})
+	}
+	<-done
+	if be.submits != 100 {
+		t.Errorf("%d submits forwarded, want 100", be.submits)
+	}
+}
+
+// diffObserver records the difficulties the backend sees
+type diffObserver struct {
+	fakeBackend
+	diffs []any
+}
+
+func (o *diffObserver) ObserveUpstream(msg stratum.Message) bool {
+	if msg.Method == stratum.MethodSetDifficulty {
+		o.diffs = append(o.diffs, msg.Params.([]any)[0])
+	}
+	return true
+}
+
+func TestDifficultyScale(t *testing.T) {
+	r := NewRouter(createTestConfig(), createTestUpstream(), metrics.NewCollector())
+	be := &diffObserver{}
+	r.SetBackend(be)
+	setDiff := func() {
+		if _, err := r.ProcessUpstreamLine([]byte(`{"id":null,"method":"mining.set_difficulty","params":[131072]}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setDiff()
+	r.SetDifficultyScale(65536)
+	setDiff()
+	if len(be.diffs) != 2 || be.diffs[0] != 131072.0 || be.diffs[1] != 2.0 {
+		t.Errorf("backend saw difficulties %v, want [131072 2]", be.diffs)
+	}
+	if got := r.mx.LastSetDiff.Load(); got != 2 {
+		t.Errorf("recorded difficulty = %d, want 2", got)
+	}
+}
+
+// handshakeClient records its answers and whether it subscribed
+type handshakeClient struct {
+	mockClient
+	subscribed bool
+	sent       []stratum.Message
+}
+
+func (h *handshakeClient) Subscribed() bool { return h.subscribed }
+
+func (h *handshakeClient) WriteJSON(msg stratum.Message) error {
+	h.sent = append(h.sent, msg)
+	return nil
+}
+
+func TestSubmitErrorCodes(t *testing.T) {
+	r := NewRouter(createTestConfig(), createTestUpstream(), metrics.NewCollector())
+	cl := &handshakeClient{mockClient: mockClient{addr: "127.0.0.1:1"}}
+	submit := func() []interface{} {
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: stratum.MethodSubmit, Params: []any{"rig1", "j", "00", "00", "00"}})
+		e, _ := cl.sent[len(cl.sent)-1].Error.([]interface{})
+		return e
+	}
+
+	if e := submit(); e[0] != stratum.CodeNotSubscribed {
+		t.Errorf("submit before subscribe answered %v", e)
+	}
+	cl.subscribed = true
+	if e := submit(); e[0] != stratum.CodeUnauthorized {
+		t.Errorf("submit before authorize answered %v", e)
+	}
+	cl.worker = "rig1"
+	if e := submit(); e[0] != stratum.CodeOther || e[1] != "Upstream down" {
+		t.Errorf("submit with the upstream down answered %v", e)
+	}
+	if cl.bad != 2 {
+		t.Errorf("refused shares = %d, want 2", cl.bad)
+	}
+}
+
+func TestHoldSubmits(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Submit.HoldMs = 5000
+	cfg.Submit.HoldPerClient = 2
+	r := NewRouter(cfg, createTestUpstream(), metrics.NewCollector())
+	var sent, dropped int
+	r.SetSubmitHook(func(cl Client, msg stratum.Message, ok bool) {
+		if ok {
+			sent++
+		} else {
+			dropped++
+		}
+	})
+	cl := &handshakeClient{mockClient: mockClient{addr: "127.0.0.1:1", worker: "rig1"}, subscribed: true}
+	for i := 0; i < 3; i++ {
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(int64(i)), Method: stratum.MethodSubmit, Params: []any{"rig1", "j", "00", "00", "00"}})
+	}
+	if len(cl.sent) != 1 || cl.sent[0].Error.([]interface{})[1] != stratum.ErrReconnecting.Message {
+		t.Fatalf("the submit past the per-client limit should be refused, got %+v", cl.sent)
+	}
+	if held := r.GetSubmitStats()["held"]; held != 2 {
+		t.Errorf("held = %v, want 2", held)
+	}
+
+	// still down when released: held again rather than failed
+	if n := r.ReleaseHeld(); n != 2 || len(cl.sent) != 1 {
+		t.Errorf("released %d, answered %d", n, len(cl.sent))
+	}
+	if n := r.expireHeld(time.Now()); n != 0 {
+		t.Errorf("expired %d within the window", n)
+	}
+	if n := r.expireHeld(time.Now().Add(6 * time.Second)); n != 2 || len(cl.sent) != 3 {
+		t.Errorf("expired %d, answered %d; want 2 and 3", n, len(cl.sent))
+	}
+	if held := r.GetSubmitStats()["held"]; held != 0 {
+		t.Errorf("held = %v after expiry", held)
+	}
+	// the refused and the expired submits were never sent
+	if sent != 0 || dropped != 3 {
+		t.Errorf("submit hook saw %d sent and %d dropped, want 0 and 3", sent, dropped)
+	}
+}
+
+func (h *handshakeClient) WriteLine(line string) error {
+	var msg stratum.Message
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		return err
+	}
+	return h.WriteJSON(msg)
+}
+
+func TestRefreshJob(t *testing.T) {
+	cfg := createTestConfig()
+	r := NewRouter(cfg, createTestUpstream(), metrics.NewCollector())
+	cl := &handshakeClient{mockClient: mockClient{addr: "127.0.0.1:1"}, subscribed: true}
+	r.AddClient(cl)
+
+	if r.RefreshJob(time.Now()) || !r.LastJob().IsZero() {
+		t.Fatal("refreshed without a job")
+	}
+	if _, err := r.ProcessUpstreamLine([]byte(`{"id":null,"method":"mining.notify","params":["j","","","",[],"","","65000000",true]}`)); err != nil {
+		t.Fatal(err)
+	}
+	at := r.LastJob()
+	if !r.RefreshJob(at.Add(90 * time.Second)) {
+		t.Fatal("job not refreshed")
+	}
+	if len(cl.sent) != 2 {
+		t.Fatalf("client got %d messages, want 2", len(cl.sent))
+	}
+	params := cl.sent[1].Params.([]any)
+	if params[0] != "j" || params[7] != "6500005a" || params[8] != false {
+		t.Errorf("refreshed job params = %v", params)
+	}
+
+	// a roll past the ntime window would be refused, so it is not sent
+	cfg.Submit.NTimeRollSeconds = 60
+	if r.RefreshJob(at.Add(90 * time.Second)) {
+		t.Error("refreshed past ntime_roll_seconds")
+	}
+}
+
+func TestBroadcastSubscribedOnly(t *testing.T) {
+	cfg := createTestConfig()
+	mx := metrics.NewCollector()
+	r := NewRouter(cfg, createTestUpstream(), mx)
+	ready := &handshakeClient{mockClient: mockClient{addr: "127.0.0.1:1"}, subscribed: true}
+	fresh := &handshakeClient{mockClient: mockClient{addr: "127.0.0.1:2"}}
+	plain := &mockClient{addr: "127.0.0.1:3"}
+	r.AddClient(ready)
+	r.AddClient(fresh)
+	r.AddClient(plain)
+
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.set_difficulty","params":[1024]}`)
+	r.ProcessUpstreamMessage(`{"id":null,"method":"mining.notify","params":["j","","","",[],"","","65000000",true]}`)
+	if len(ready.sent) != 2 || len(fresh.sent) != 0 {
+		t.Errorf("sent ready=%d fresh=%d; want 2 and 0", len(ready.sent), len(fresh.sent))
+	}
+	// clients without subscription state are never filtered
+	if n := mx.BroadcastSuppressed.Load(); n != 2 {
+		t.Errorf("suppressed = %d, want 2", n)
+	}
+
+	fresh.subscribed = true
+	r.Broadcast(`{"id":null,"method":"mining.set_extranonce","params":["00",4]}`)
+	if len(fresh.sent) != 1 || mx.BroadcastSuppressed.Load() != 2 {
+		t.Errorf("subscribed client missed a broadcast: %d sent", len(fresh.sent))
+	}
+}
+
+func TestJobCache(t *testing.T) {
+	r := NewRouter(createTestConfig(), createTestUpstream(), metrics.NewCollector())
+	if _, ok := r.UpstreamJob(); ok {
+		t.Fatal("job before any notify")
+	}
+	for i := 0; i < jobCacheSize+1; i++ {
+		line := fmt.Sprintf(`{"id":null,"method":"mining.notify","params":["%d","","","",[],"","","65000000","true"]}`, i)
+		if _, err := r.ProcessUpstreamLine([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, ok := r.UpstreamJob()
+	if !ok || job.ID != fmt.Sprint(jobCacheSize) || !job.Clean {
+		t.Errorf("upstream job = %+v, %v", job, ok)
+	}
+	if _, ok := r.Job("0"); ok {
+		t.Error("oldest job kept past the cache size")
+	}
+	if j, ok := r.Job("1"); !ok || j.NTime != "65000000" {
+		t.Errorf("job 1 = %+v, %v", j, ok)
+	}
+
+	// a malformed notify leaves no newest job but keeps the cached ones
+	if _, err := r.ProcessUpstreamLine([]byte(`{"id":null,"method":"mining.notify","params":["x"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.UpstreamJob(); ok {
+		t.Error("malformed notify left a job")
+	}
+	if _, ok := r.Job("1"); !ok {
+		t.Error("cached job dropped")
+	}
+}
+
+// dialCapture connects an upstream to a listener that passes each line it
+// reads to lines, or drops them when lines is nil
+func dialCapture(tb testing.TB, lines chan<- string) *connection.Upstream {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if lines == nil {
+			_, _ = io.Copy(io.Discard, c)
+			return
+		}
+		sc := bufio.NewScanner(c)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	up := createTestUpstream()
+	addr := ln.Addr().(*net.TCPAddr)
+	up.UpdateTarget("127.0.0.1", addr.Port, "u", "x", false, false)
+	if err := up.Dial(context.Background()); err != nil {
+		tb.Fatalf("dial: %v", err)
+	}
+	return up
+}
+
+// testSubmitLine is a submit as a miner sends it, with a member karoo does
+// not know
+const testSubmitLine = `{"id":5,"method":"mining.submit","params":["rig1","j1","abcd","495fab29","7c2bac1d"],"jsonrpc":"2.0"}`
+
+func TestForwardPatchesSubmit(t *testing.T) {
+	lines := make(chan string, 4)
+	up := dialCapture(t, lines)
+	defer up.Close()
+	r := NewRouter(createTestConfig(), up, metrics.NewCollector())
+	cl := &mockClient{addr: "127.0.0.1:1", upUser: "pool.user", extraNoncePrefix: "0000", extraNonceTrim: 2}
+
+	var msg stratum.Message
+	if err := json.Unmarshal([]byte(testSubmitLine), &msg); err != nil {
+		t.Fatal(err)
+	}
+	msg.Raw = testSubmitLine
+	r.ProcessClientMessage(cl, msg)
+
+	want := `{"id":1,"method":"mining.submit","params":["pool.user","j1","0000ABCD","495fab29","7c2bac1d"],"jsonrpc":"2.0"}`
+	select {
+	case got := <-lines:
+		if got != want {
+			t.Errorf("forwarded %s\nwant      %s", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("submit not forwarded")
+	}
+}
+
+// BenchmarkSubmitPath runs a submit line from its decoding to the upstream
+// writer, marshalled again from its params or patched from its line.
+// allocs/5k-shares is what a minute of 5000 shares allocates.
+func BenchmarkSubmitPath(b *testing.B) {
+	for _, patch := range []bool{false, true} {
+		name := "marshal"
+		if patch {
+			name = "patch"
+		}
+		b.Run(name, func(b *testing.B) {
+			up := dialCapture(b, nil)
+			defer up.Close()
+			r := NewRouter(createTestConfig(), up, metrics.NewCollector())
+			cl := &mockClient{addr: "127.0.0.1:1", upUser: "pool.user", extraNoncePrefix: "0000", extraNonceTrim: 2}
+			line := []byte(testSubmitLine)
+
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			start := ms.Mallocs
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var msg stratum.Message
+				_ = json.Unmarshal(line, &msg)
+				if patch {
+					msg.Raw = testSubmitLine
+				}
+				r.ProcessClientMessage(cl, msg)
+				if i%1024 == 1023 {
+					up.DropPending()
+				}
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&ms)
+			b.ReportMetric(float64(ms.Mallocs-start)/float64(b.N)*5000, "allocs/5k-shares")
+		})
+	}
+}
+
+func TestWorkerNames(t *testing.T) {
+	lines := make(chan string, 4)
+	up := dialCapture(t, lines)
+	defer up.Close()
+	cfg := createTestConfig()
+	cfg.WorkerNames = workername.Config{Template: "{upstream_user}.{client_rig}", Strip: "#"}
+	r := NewRouter(cfg, up, metrics.NewCollector())
+	cl := &mockClient{addr: "127.0.0.1:1", upUser: cfg.Upstream.User}
+
+	next := func() stratum.Message {
+		t.Helper()
+		select {
+		case line := <-lines:
+			var msg stratum.Message
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		case <-time.After(2 * time.Second):
+			t.Fatal("nothing forwarded")
+		}
+		return stratum.Message{}
+	}
+
+	r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: "mining.authorize", Params: []any{"alice.rig#1", "x"}})
+	if a, err := stratum.ParseAuthorize(next().Params); err != nil || a.Worker != "testuser.rig1" {
+		t.Errorf("authorize forwarded as %+v, %v", a, err)
+	}
+	if cl.worker != "alice.rig#1" || cl.upUser != "testuser.rig1" {
+		t.Errorf("worker %q upstream name %q", cl.worker, cl.upUser)
+	}
+
+	r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(2), Method: "mining.submit", Params: []any{"alice.rig#1", "j1", "00", "495fab29", "7c2bac1d"}})
+	if s, err := stratum.ParseSubmit(next().Params); err != nil || s.Worker != "testuser.rig1" {
+		t.Errorf("submit forwarded as %+v, %v", s, err)
+	}
+}
+
+func TestFastAuthorize(t *testing.T) {
+	lines := make(chan string, 8)
+	up := dialCapture(t, lines)
+	defer up.Close()
+	cfg := createTestConfig()
+	cfg.Authorize = AuthorizeConfig{FastAck: true}
+	mx := metrics.NewCollector()
+	r := NewRouter(cfg, up, mx)
+	var results []bool
+	r.SetAuthorizeHook(func(cl Client, ok bool) { results = append(results, ok) })
+	authorize := func(worker string) *mockClient {
+		cl := &mockClient{addr: "127.0.0.1:1"}
+		r.ProcessClientMessage(cl, stratum.Message{ID: stratum.NewID(1), Method: "mining.authorize", Params: []any{worker, "x"}})
+		return cl
+	}
+
+	// a fleet of one name is acked at once and authorized upstream once
+	for i := 0; i < 3; i++ {
+		if cl := authorize("rig1"); !cl.handshakeDone {
+			t.Fatalf("authorize %d not acked", i)
+		}
+	}
+	// the configured user was authorized by the proxy's own handshake
+	authorize("testuser")
+	if up.PendingCount() != 1 || mx.AuthorizeFastAcks.Load() != 4 {
+		t.Fatalf("pending = %d, fast acks = %d, want 1 and 4", up.PendingCount(), mx.AuthorizeFastAcks.Load())
+	}
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, `"rig1"`) {
+			t.Errorf("upstream got %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("authorize not sent upstream")
+	}
+
+	// a refusal is replayed to the next clients of that name
+	r.ProcessUpstreamMessage(`{"id":1,"result":false,"error":[24,"Unauthorized worker",null]}`)
+	if cl := authorize("rig1"); cl.handshakeDone {
+		t.Error("refused name acked")
+	}
+	if want := []bool{true, true, true, true, false}; fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Errorf("authorize results %v, want %v", results, want)
+	}
+
+	// a lost connection forgets the names
+	r.ResetSubmits()
+	if cl := authorize("rig1"); !cl.handshakeDone || up.PendingCount() != 1 {
+		t.Errorf("after reset: acked %v, pending %d", cl.handshakeDone, up.PendingCount())
+	}
+}
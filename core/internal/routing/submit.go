@@ -0,0 +1,216 @@
+package routing
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// SubmitConfig limits outstanding mining.submit requests toward the upstream
+type SubmitConfig struct {
+	MaxInFlight int `json:"max_inflight"` // 0 = unlimited
+	QueueSize   int `json:"queue_size"`
+	// Dedupe refuses a share the client already sent among its last 256
+	Dedupe bool `json:"dedupe"`
+	// Validate refuses submits whose parameters are not hex strings
+	Validate bool `json:"validate"`
+	// StalePolicy handles submits for jobs a clean_jobs notify replaced: ""
+	// forwards them as before, "forward" forwards them for StaleGraceMs and
+	// refuses them after, "drop" refuses them at once
+	StalePolicy  string `json:"stale_policy"`
+	StaleGraceMs int    `json:"stale_grace_ms"` // default 2000
+	// NTimeRollSeconds refuses submits whose ntime is before the job's or
+	// more than this many seconds after it; 0 disables the check
+	NTimeRollSeconds int `json:"ntime_roll_seconds"`
+	// HoldMs keeps submits that arrive while the upstream is down for up to
+	// this long, forwarding them if it is back in time; 0 fails them at once
+	HoldMs        int `json:"hold_ms"`
+	HoldPerClient int `json:"hold_per_client"` // default 8
+}
+
+// Stale submit policies
+const (
+	StaleForward = "forward"
+	StaleDrop    = "drop"
+)
+
+func (c SubmitConfig) staleGrace() time.Duration {
+	if c.StaleGraceMs <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.StaleGraceMs) * time.Millisecond
+}
+
+// jobGenerations tells the jobs since the last clean_jobs notify from those
+// it replaced, and keeps the ntime of both
+type jobGenerations struct {
+	mu      sync.Mutex
+	current map[string]uint32
+	stale   map[string]uint32
+	cleanAt time.Time
+}
+
+// add records a job from the upstream
+func (g *jobGenerations) add(id string, ntime uint32, clean bool, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if clean || g.current == nil {
+		g.stale, g.current = g.current, make(map[string]uint32)
+		g.cleanAt = now
+	}
+	g.current[id] = ntime
+}
+
+// ntime returns the ntime of a known job
+func (g *jobGenerations) ntime(id string) (uint32, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.current[id]; ok {
+		return t, true
+	}
+	t, ok := g.stale[id]
+	return t, ok
+}
+
+// parseNTime decodes a hex ntime, 0 when invalid
+func parseNTime(s string) uint32 {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(v)
+}
+
+// ntimeInRange reports whether a submit's ntime is within roll seconds of
+// its job. Unknown jobs and unparsable values are left to the pool.
+func (r *Router) ntimeInRange(s stratum.Submit, roll int) bool {
+	base, ok := r.gens.ntime(s.JobID)
+	t := parseNTime(s.NTime)
+	if !ok || base == 0 || t == 0 {
+		return true
+	}
+	return t >= base && t-base <= uint32(roll)
+}
+
+// replaced reports whether a clean_jobs notify replaced the job, and how long
+// ago it arrived
+func (g *jobGenerations) replaced(id string, now time.Time) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.current[id]; ok {
+		return false, 0
+	}
+	if _, ok := g.stale[id]; !ok {
+		return false, 0
+	}
+	return true, now.Sub(g.cleanAt)
+}
+
+// queuedSubmit is a submit waiting for an in-flight slot
+type queuedSubmit struct {
+	cl     Client
+	msg    stratum.Message
+	queued time.Time
+}
+
+// dispatchSubmit forwards a submit upstream, queueing it when the in-flight
+// cap is reached and refusing it when the queue is full
+func (r *Router) dispatchSubmit(cl Client, msg stratum.Message) {
+	if !r.up.IsConnected() && r.hold(cl, msg) {
+		return
+	}
+	sub := r.config().Submit
+	r.subMu.Lock()
+	limit := sub.MaxInFlight
+	if limit > 0 && r.inFlight >= limit {
+		if len(r.subQueue) >= sub.QueueSize {
+			r.subMu.Unlock()
+			r.mx.IncrementSubmitsDropped()
+			r.writeClient(cl, stratum.ErrorResponseFor(msg.ID, stratum.ErrQueueFull))
+			r.submitted(cl, msg, false)
+			return
+		}
+		r.subQueue = append(r.subQueue, queuedSubmit{cl: cl, msg: msg, queued: time.Now()})
+		r.mx.SetSubmitsQueued(int64(len(r.subQueue)))
+		r.subMu.Unlock()
+		return
+	}
+	r.inFlight++
+	r.mx.SetSubmitsInFlight(int64(r.inFlight))
+	r.subMu.Unlock()
+
+	if !r.forwardRequest(cl, msg) {
+		r.submitDone()
+	}
+}
+
+// submitDone releases an in-flight slot and sends the next queued submit
+func (r *Router) submitDone() {
+	r.subMu.Lock()
+	if r.inFlight > 0 {
+		r.inFlight--
+	}
+	var next *queuedSubmit
+	limit := r.config().Submit.MaxInFlight
+	if len(r.subQueue) > 0 && (limit <= 0 || r.inFlight < limit) {
+		q := r.subQueue[0]
+		r.subQueue = r.subQueue[1:]
+		next = &q
+		r.inFlight++
+		r.mx.SetSubmitsQueued(int64(len(r.subQueue)))
+	}
+	r.mx.SetSubmitsInFlight(int64(r.inFlight))
+	r.subMu.Unlock()
+
+	if next != nil && !r.forwardRequest(next.cl, next.msg) {
+		r.submitDone()
+	}
+}
+
+// ResetSubmits forgets in-flight submits and fails queued ones along with
+// every request still awaiting a response, and forgets the upstream names
+// authorized on the connection; called when the upstream connection is
+// lost and no responses will arrive
+func (r *Router) ResetSubmits() {
+	r.resetAuthorized()
+
+	r.subMu.Lock()
+	queued := r.subQueue
+	r.subQueue = nil
+	r.inFlight = 0
+	r.mx.SetSubmitsInFlight(0)
+	r.mx.SetSubmitsQueued(0)
+	r.subMu.Unlock()
+
+	for _, q := range queued {
+		r.writeClient(q.cl, stratum.ErrorResponseFor(q.msg.ID, stratum.ErrUpstreamDown))
+		r.submitted(q.cl, q.msg, false)
+	}
+	for _, req := range r.up.DropPending() {
+		if cl, ok := req.Client.(Client); ok {
+			r.writeClient(cl, stratum.ErrorResponseFor(req.OrigID, stratum.ErrUpstreamDown))
+		}
+	}
+}
+
+// GetSubmitStats returns upstream submit pipeline statistics
+func (r *Router) GetSubmitStats() map[string]interface{} {
+	sub := r.config().Submit
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	var oldest time.Duration
+	if len(r.subQueue) > 0 {
+		oldest = time.Since(r.subQueue[0].queued)
+	}
+	return map[string]interface{}{
+		"inflight":         r.inFlight,
+		"queued":           len(r.subQueue),
+		"dropped":          r.mx.SubmitsDropped.Load(),
+		"max_inflight":     sub.MaxInFlight,
+		"queue_size":       sub.QueueSize,
+		"oldest_queued_ms": oldest.Milliseconds(),
+		"held":             r.heldN,
+	}
+}
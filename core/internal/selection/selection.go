@@ -0,0 +1,269 @@
+// Package selection chooses which configured upstream the proxy connects to
+package selection
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Selection policies
+const (
+	Priority   = "priority"    // config order, moving on when an upstream fails
+	Latency    = "latency"     // lowest probed connect time
+	RoundRobin = "round_robin" // next healthy upstream on every reconnect
+)
+
+// Policies lists the accepted policy names
+var Policies = []string{Priority, Latency, RoundRobin}
+
+// Config holds upstream selection settings
+type Config struct {
+	Policy               string `json:"policy"`
+	ProbeIntervalSeconds int    `json:"probe_interval_seconds"`
+	ProbeTimeoutMs       int    `json:"probe_timeout_ms"`
+	// latency: how much faster (percent) another upstream must be before
+	// the proxy switches to it
+	SwitchMarginPct int `json:"switch_margin_pct"`
+}
+
+// policy returns the configured policy, priority by default
+func (c *Config) policy() string {
+	if c.Policy == "" {
+		return Priority
+	}
+	return c.Policy
+}
+
+// interval returns how often upstreams are probed
+func (c *Config) interval() time.Duration {
+	if c.ProbeIntervalSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.ProbeIntervalSeconds) * time.Second
+}
+
+// timeout returns how long a single probe may take
+func (c *Config) timeout() time.Duration {
+	if c.ProbeTimeoutMs <= 0 {
+		return 3 * time.Second
+	}
+	return time.Duration(c.ProbeTimeoutMs) * time.Millisecond
+}
+
+// margin returns the latency switch margin in percent
+func (c *Config) margin() int {
+	if c.SwitchMarginPct <= 0 {
+		return 20
+	}
+	return c.SwitchMarginPct
+}
+
+// Probe is the last probe result of one upstream
+type Probe struct {
+	Upstream string    `json:"upstream"`
+	Healthy  bool      `json:"healthy"`
+	RTTMs    float64   `json:"rtt_ms"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+
+	rtt time.Duration
+}
+
+// Stats is the selector state shown in /status
+type Stats struct {
+	Policy string  `json:"policy"`
+	Probes []Probe `json:"probes"`
+}
+
+// Selector probes upstreams and picks the next one to connect to
+type Selector struct {
+	cfg *Config
+
+	mu     sync.Mutex
+	probes map[string]Probe // by host:port
+
+	// probe measures one upstream; replaced in tests
+	probe func(ctx context.Context, addr string) (time.Duration, error)
+}
+
+// New creates a new upstream selector
+func New(cfg *Config) *Selector {
+	return &Selector{
+		cfg:    cfg,
+		probes: make(map[string]Probe),
+		probe:  dialProbe,
+	}
+}
+
+// dialProbe measures the TCP connect time to addr
+func dialProbe(ctx context.Context, addr string) (time.Duration, error) {
+	var d net.Dialer
+	start := time.Now()
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	_ = c.Close()
+	return rtt, nil
+}
+
+// UpdateConfig updates the selector configuration
+func (s *Selector) UpdateConfig(cfg *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// config returns the current configuration
+func (s *Selector) config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// Probing reports whether the policy needs upstream probes
+func (s *Selector) Probing() bool {
+	return s.config().policy() != Priority
+}
+
+// ProbeAll probes every upstream concurrently and records the results
+func (s *Selector) ProbeAll(ctx context.Context, addrs []string) {
+	timeout := s.config().timeout()
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			rtt, err := s.probe(pctx, addr)
+			cancel()
+			p := Probe{Upstream: addr, Healthy: err == nil, At: time.Now(), rtt: rtt}
+			p.RTTMs = float64(rtt.Microseconds()) / 1000
+			if err != nil {
+				p.Error = err.Error()
+			}
+			s.mu.Lock()
+			prev, seen := s.probes[addr]
+			s.probes[addr] = p
+			s.mu.Unlock()
+			if seen && prev.Healthy != p.Healthy {
+				if p.Healthy {
+					log.Printf("selection: upstream %s probe recovered (%s)", addr, rtt.Round(time.Millisecond))
+				} else {
+					log.Printf("selection: upstream %s probe failed: %v", addr, err)
+				}
+			}
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// healthy reports whether addr passed its last probe; upstreams not probed
+// yet count as healthy. mu must be held.
+func (s *Selector) healthy(addr string) bool {
+	p, ok := s.probes[addr]
+	return !ok || p.Healthy
+}
+
+// best returns the index of the healthy, probed upstream with the lowest
+// connect time other than exclude, or -1. mu must be held.
+func (s *Selector) best(addrs []string, exclude int) int {
+	b := -1
+	for i, addr := range addrs {
+		p, ok := s.probes[addr]
+		if i == exclude || !ok || !p.Healthy {
+			continue
+		}
+		if b < 0 || p.rtt < s.probes[addrs[b]].rtt {
+			b = i
+		}
+	}
+	return b
+}
+
+// Next returns the index of the upstream to connect to after the one at
+// current failed or disconnected
+func (s *Selector) Next(addrs []string, current int) int {
+	n := len(addrs)
+	if n == 0 {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.cfg.policy() {
+	case Latency:
+		if b := s.best(addrs, current); b >= 0 {
+			return b
+		}
+	case RoundRobin:
+		for i := 1; i <= n; i++ {
+			if j := (current + i) % n; s.healthy(addrs[j]) {
+				return j
+			}
+		}
+	}
+	return (current + 1) % n
+}
+
+// Switch reports whether the latency policy prefers another upstream over
+// the connected one at current, and which. Both must have passed their last
+// probe and the other must be faster by the switch margin.
+func (s *Selector) Switch(addrs []string, current int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.policy() != Latency || current < 0 || current >= len(addrs) {
+		return 0, false
+	}
+	cur, ok := s.probes[addrs[current]]
+	if !ok || !cur.Healthy {
+		return 0, false
+	}
+	b := s.best(addrs, current)
+	if b < 0 {
+		return 0, false
+	}
+	faster := s.probes[addrs[b]].rtt * time.Duration(100+s.cfg.margin())
+	if faster >= cur.rtt*100 {
+		return 0, false
+	}
+	return b, true
+}
+
+// GetStats returns the policy and the last probe of each upstream in addrs
+func (s *Selector) GetStats(addrs []string) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := Stats{Policy: s.cfg.policy(), Probes: make([]Probe, 0, len(addrs))}
+	for _, addr := range addrs {
+		p, ok := s.probes[addr]
+		if !ok {
+			p = Probe{Upstream: addr}
+		}
+		out.Probes = append(out.Probes, p)
+	}
+	return out
+}
+
+// Run probes the upstreams returned by addrs every interval while the
+// policy needs probes, until ctx is done. onProbe runs after each round.
+func (s *Selector) Run(ctx context.Context, addrs func() []string, onProbe func()) {
+	ticker := time.NewTicker(s.config().interval())
+	defer ticker.Stop()
+	for {
+		if s.Probing() {
+			s.ProbeAll(ctx, addrs())
+			if onProbe != nil && ctx.Err() == nil {
+				onProbe()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package selection
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeProbes makes the selector report fixed connect times; a zero
+// duration is a failed probe
+func fakeProbes(s *Selector, rtts map[string]time.Duration) {
+	s.probe = func(ctx context.Context, addr string) (time.Duration, error) {
+		if rtts[addr] == 0 {
+			return 0, errors.New("connection refused")
+		}
+		return rtts[addr], nil
+	}
+}
+
+var addrs = []string{"primary:3333", "backup1:3333", "backup2:3333"}
+
+func TestPriorityIgnoresProbes(t *testing.T) {
+	s := New(&Config{})
+	if s.Probing() {
+		t.Fatal("priority should not probe")
+	}
+	fakeProbes(s, map[string]time.Duration{"backup1:3333": 0, "backup2:3333": time.Millisecond})
+	s.ProbeAll(context.Background(), addrs)
+	if got := s.Next(addrs, 0); got != 1 {
+		t.Errorf("priority Next(0) = %d, want 1", got)
+	}
+	if got := s.Next(addrs, 2); got != 0 {
+		t.Errorf("priority Next(2) = %d, want 0", got)
+	}
+	if _, ok := s.Switch(addrs, 0); ok {
+		t.Error("priority should never switch a live upstream")
+	}
+}
+
+func TestRoundRobinSkipsUnhealthy(t *testing.T) {
+	s := New(&Config{Policy: RoundRobin})
+	// Before any probe every upstream is a candidate
+	if got := s.Next(addrs, 0); got != 1 {
+		t.Errorf("Next(0) before probes = %d, want 1", got)
+	}
+	fakeProbes(s, map[string]time.Duration{"primary:3333": time.Millisecond, "backup2:3333": time.Millisecond})
+	s.ProbeAll(context.Background(), addrs)
+	if got := s.Next(addrs, 0); got != 2 {
+		t.Errorf("Next(0) = %d, want 2 (backup1 is down)", got)
+	}
+	if got := s.Next(addrs, 2); got != 0 {
+		t.Errorf("Next(2) = %d, want 0", got)
+	}
+}
+
+func TestLatencySelection(t *testing.T) {
+	s := New(&Config{Policy: Latency, SwitchMarginPct: 20})
+	rtts := map[string]time.Duration{
+		"primary:3333": 50 * time.Millisecond,
+		"backup1:3333": 45 * time.Millisecond,
+		"backup2:3333": 30 * time.Millisecond,
+	}
+	fakeProbes(s, rtts)
+	s.ProbeAll(context.Background(), addrs)
+
+	if got := s.Next(addrs, 0); got != 2 {
+		t.Errorf("Next(0) = %d, want the fastest (2)", got)
+	}
+	if got := s.Next(addrs, 2); got != 1 {
+		t.Errorf("Next(2) = %d, want the fastest other upstream (1)", got)
+	}
+	if next, ok := s.Switch(addrs, 0); !ok || next != 2 {
+		t.Errorf("Switch(0) = %d, %v; want 2, true", next, ok)
+	}
+	// backup1 is faster than primary, but not by the margin
+	rtts["backup2:3333"] = 0
+	s.ProbeAll(context.Background(), addrs)
+	if _, ok := s.Switch(addrs, 0); ok {
+		t.Error("switched for less than the margin")
+	}
+	if got := s.Next(addrs, 1); got != 0 {
+		t.Errorf("Next(1) = %d, want 0 (backup2 is down)", got)
+	}
+
+	st := s.GetStats(addrs)
+	if st.Policy != Latency || len(st.Probes) != 3 || st.Probes[2].Healthy || st.Probes[2].Error == "" ||
+		st.Probes[0].RTTMs != 50 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
@@ -0,0 +1,233 @@
+// Package sessions keeps an audit trail of client connections: when each one
+// connected, subscribed and authorized, and why it was kicked, banned or
+// went away
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	Connect         = "connect"
+	Subscribe       = "subscribe"
+	Authorize       = "authorize"
+	AuthorizeFailed = "authorize_failed"
+	Kick            = "kick"
+	Ban             = "ban"
+	Disconnect      = "disconnect"
+)
+
+// Config holds the audit trail settings
+type Config struct {
+	Enabled   bool   `json:"enabled"`
+	MaxEvents int    `json:"max_events"` // events kept in memory; default 10000
+	File      string `json:"file"`       // also append events here as NDJSON
+}
+
+// Validate checks the retention
+func (c *Config) Validate() error {
+	if c.MaxEvents < 0 {
+		return fmt.Errorf("max_events must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) maxEvents() int {
+	if c.MaxEvents <= 0 {
+		return 10000
+	}
+	return c.MaxEvents
+}
+
+// Event is one step in the life of a session
+type Event struct {
+	Time    time.Time `json:"time"`
+	Session string    `json:"session"`
+	Type    string    `json:"type"`
+	Addr    string    `json:"addr"`
+	Worker  string    `json:"worker,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// Session is the recorded history of one connection
+type Session struct {
+	ID     string     `json:"id"`
+	Addr   string     `json:"addr"`
+	Worker string     `json:"worker,omitempty"`
+	Start  time.Time  `json:"start"`
+	End    *time.Time `json:"end,omitempty"`
+	Reason string     `json:"reason,omitempty"` // why it disconnected
+	Events []Event    `json:"events"`
+}
+
+// Filter narrows a query. A session matches when its worker equals Worker
+// and it was connected at some point between Since and Until; zero values
+// match everything.
+type Filter struct {
+	Worker string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // newest sessions kept; 0 keeps all
+}
+
+// Trail records session events in a ring and, when configured, a file
+type Trail struct {
+	mu   sync.Mutex
+	cfg  Config
+	ring []Event
+	next int
+	full bool
+	f    *os.File
+
+	// IDs are the process start time and a counter, unique across restarts
+	boot string
+	seq  atomic.Uint64
+}
+
+// New creates an empty trail; the file is opened on the first event
+func New(cfg *Config) *Trail {
+	t := &Trail{boot: fmt.Sprintf("%x", time.Now().Unix())}
+	t.UpdateConfig(cfg)
+	return t
+}
+
+// UpdateConfig applies new settings. Events are kept, up to the new limit;
+// a changed file is opened on the next event.
+func (t *Trail) UpdateConfig(cfg *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cfg.File != t.cfg.File && t.f != nil {
+		_ = t.f.Close()
+		t.f = nil
+	}
+	t.cfg = *cfg
+	if !cfg.Enabled {
+		t.ring, t.next, t.full = nil, 0, false
+		return
+	}
+	if n := cfg.maxEvents(); n != len(t.ring) {
+		kept := t.events()
+		if len(kept) > n {
+			kept = kept[len(kept)-n:]
+		}
+		t.ring = make([]Event, n)
+		t.next = copy(t.ring, kept)
+		t.full = t.next == n
+		if t.full {
+			t.next = 0
+		}
+	}
+}
+
+// NewID returns the ID of a new session
+func (t *Trail) NewID() string {
+	return fmt.Sprintf("%s-%d", t.boot, t.seq.Add(1))
+}
+
+// Record adds an event; the time is set when missing
+func (t *Trail) Record(ev Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.cfg.Enabled {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	t.ring[t.next] = ev
+	t.next++
+	if t.next == len(t.ring) {
+		t.next, t.full = 0, true
+	}
+	if t.cfg.File != "" {
+		t.write(ev)
+	}
+}
+
+// write appends ev to the file; a file that cannot be opened is reported
+// on stderr and retried with the next event
+func (t *Trail) write(ev Event) {
+	if t.f == nil {
+		f, err := os.OpenFile(t.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sessions: opening %s: %v\n", t.cfg.File, err)
+			return
+		}
+		t.f = f
+	}
+	line, _ := json.Marshal(ev)
+	_, _ = t.f.Write(append(line, '\n'))
+}
+
+// events returns the ring oldest first; callers hold mu
+func (t *Trail) events() []Event {
+	if !t.full {
+		return append([]Event(nil), t.ring[:t.next]...)
+	}
+	return append(append([]Event(nil), t.ring[t.next:]...), t.ring[:t.next]...)
+}
+
+// Query groups the recorded events into sessions, newest first. A session
+// whose first events left the ring starts at its oldest remaining one.
+func (t *Trail) Query(f Filter) []Session {
+	t.mu.Lock()
+	events := t.events()
+	t.mu.Unlock()
+
+	byID := map[string]*Session{}
+	var order []*Session
+	for _, ev := range events {
+		s := byID[ev.Session]
+		if s == nil {
+			s = &Session{ID: ev.Session, Addr: ev.Addr, Start: ev.Time}
+			byID[ev.Session] = s
+			order = append(order, s)
+		}
+		if ev.Worker != "" {
+			s.Worker = ev.Worker
+		}
+		if ev.Type == Disconnect {
+			end := ev.Time
+			s.End, s.Reason = &end, ev.Reason
+		}
+		s.Events = append(s.Events, ev)
+	}
+
+	out := make([]Session, 0, len(order))
+	for _, s := range order {
+		if f.Worker != "" && s.Worker != f.Worker {
+			continue
+		}
+		if !f.Until.IsZero() && s.Start.After(f.Until) {
+			continue
+		}
+		if !f.Since.IsZero() && s.End != nil && s.End.Before(f.Since) {
+			continue
+		}
+		out = append(out, *s)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.After(out[j].Start) })
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out
+}
+
+// Close closes the file
+func (t *Trail) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
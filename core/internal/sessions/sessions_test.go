@@ -0,0 +1,76 @@
+package sessions
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrail(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sessions.ndjson")
+	tr := New(&Config{Enabled: true, MaxEvents: 6, File: file})
+	defer tr.Close()
+	base := time.Unix(1700000000, 0)
+
+	a, b := tr.NewID(), tr.NewID()
+	if a == b {
+		t.Fatalf("session IDs repeat: %s", a)
+	}
+	tr.Record(Event{Time: base, Session: a, Type: Connect, Addr: "10.0.0.1:1"})
+	tr.Record(Event{Time: base.Add(time.Second), Session: a, Type: Authorize, Addr: "10.0.0.1:1", Worker: "rig1"})
+	tr.Record(Event{Time: base.Add(time.Minute), Session: b, Type: Connect, Addr: "10.0.0.2:1"})
+	tr.Record(Event{Time: base.Add(2 * time.Minute), Session: a, Type: Disconnect, Addr: "10.0.0.1:1", Worker: "rig1", Reason: "banned"})
+
+	all := tr.Query(Filter{})
+	if len(all) != 2 || all[0].ID != b || all[1].ID != a {
+		t.Fatalf("got %+v, want sessions %s then %s", all, b, a)
+	}
+	if s := all[1]; s.Worker != "rig1" || s.End == nil || s.Reason != "banned" || len(s.Events) != 3 {
+		t.Errorf("session a: %+v", s)
+	}
+	if got := tr.Query(Filter{Worker: "rig1"}); len(got) != 1 || got[0].ID != a {
+		t.Errorf("worker filter: %+v", got)
+	}
+	if got := tr.Query(Filter{Since: base.Add(3 * time.Minute)}); len(got) != 1 || got[0].ID != b {
+		t.Errorf("since excludes the ended session, keeps the open one: %+v", got)
+	}
+	if got := tr.Query(Filter{Until: base.Add(30 * time.Second)}); len(got) != 1 || got[0].ID != a {
+		t.Errorf("until: %+v", got)
+	}
+	if got := tr.Query(Filter{Limit: 1}); len(got) != 1 || got[0].ID != b {
+		t.Errorf("limit: %+v", got)
+	}
+
+	// the ring drops the oldest events
+	for i := 0; i < 4; i++ {
+		tr.Record(Event{Time: base.Add(time.Hour), Session: b, Type: Subscribe, Addr: "10.0.0.2:1"})
+	}
+	if got := tr.Query(Filter{Worker: "rig1"}); len(got) != 1 || len(got[0].Events) != 1 || got[0].Events[0].Type != Disconnect {
+		t.Errorf("after wrapping: %+v", got)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %d: %v", n, err)
+		}
+	}
+	if n != 8 {
+		t.Errorf("file has %d events, want 8", n)
+	}
+
+	tr.UpdateConfig(&Config{})
+	tr.Record(Event{Session: a, Type: Connect})
+	if got := tr.Query(Filter{}); len(got) != 0 {
+		t.Errorf("disabled trail kept %d sessions", len(got))
+	}
+}
@@ -0,0 +1,387 @@
+// Package sim runs a scripted fake Stratum pool for exercising failover,
+// vardiff and nonce handling without a real pool
+package sim
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// Config scripts the behavior of the fake pool
+type Config struct {
+	Listen          string `json:"listen"`           // default 127.0.0.1:3333
+	Extranonce2Size int    `json:"extranonce2_size"` // default 4
+	// NotifyIntervalMs is the cadence of new jobs; default 30000
+	NotifyIntervalMs int `json:"notify_interval_ms"`
+	// CleanEvery marks every Nth job clean_jobs, as for a new block; 0 never
+	CleanEvery int `json:"clean_every"`
+	// Difficulties are sent in turn, one every DifficultyIntervalMs (0 sends
+	// only the first); default [1]
+	Difficulties         []float64 `json:"difficulties"`
+	DifficultyIntervalMs int       `json:"difficulty_interval_ms"`
+	// RejectRate is the fraction of otherwise valid submits rejected with
+	// RejectMessage
+	RejectRate    float64 `json:"reject_rate"`
+	RejectMessage string  `json:"reject_message"` // default "Low difficulty share"
+	// DisconnectAfterMs drops every connection this long after it opened;
+	// 0 never
+	DisconnectAfterMs int `json:"disconnect_after_ms"`
+	// StallAfterMs stops sending jobs to a connection this long after it
+	// opened, while still answering requests; 0 never
+	StallAfterMs int `json:"stall_after_ms"`
+}
+
+// Validate checks the script for impossible values
+func (c *Config) Validate() error {
+	if c.Extranonce2Size < 0 || c.Extranonce2Size > 16 {
+		return errors.New("extranonce2_size must be between 0 and 16")
+	}
+	if c.NotifyIntervalMs < 0 || c.CleanEvery < 0 || c.DifficultyIntervalMs < 0 || c.DisconnectAfterMs < 0 || c.StallAfterMs < 0 {
+		return errors.New("intervals and counts must not be negative")
+	}
+	if c.RejectRate < 0 || c.RejectRate > 1 {
+		return errors.New("reject_rate must be between 0 and 1")
+	}
+	for _, d := range c.Difficulties {
+		if d <= 0 {
+			return errors.New("difficulties must be positive")
+		}
+	}
+	return nil
+}
+
+func (c *Config) listen() string {
+	if c.Listen == "" {
+		return "127.0.0.1:3333"
+	}
+	return c.Listen
+}
+
+func (c *Config) ex2Size() int {
+	if c.Extranonce2Size == 0 {
+		return 4
+	}
+	return c.Extranonce2Size
+}
+
+func (c *Config) notifyInterval() time.Duration {
+	if c.NotifyIntervalMs <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.NotifyIntervalMs) * time.Millisecond
+}
+
+func (c *Config) difficulties() []float64 {
+	if len(c.Difficulties) == 0 {
+		return []float64{1}
+	}
+	return c.Difficulties
+}
+
+func (c *Config) rejectMessage() string {
+	if c.RejectMessage == "" {
+		return "Low difficulty share"
+	}
+	return c.RejectMessage
+}
+
+// Stats counts what the pool has seen
+type Stats struct {
+	Connections uint64 `json:"connections"`
+	Active      int64  `json:"active"`
+	Jobs        uint64 `json:"jobs"`
+	Accepted    uint64 `json:"accepted"`
+	Rejected    uint64 `json:"rejected"`
+	Stale       uint64 `json:"stale"`
+}
+
+// Pool is a fake Stratum pool
+type Pool struct {
+	cfg *Config
+
+	ex1  atomic.Uint32 // extranonce1 of the next connection
+	job  atomic.Uint64 // last job ID handed out
+	rand *rand.Rand
+	rmu  sync.Mutex
+
+	connections atomic.Uint64
+	active      atomic.Int64
+	jobs        atomic.Uint64
+	accepted    atomic.Uint64
+	rejected    atomic.Uint64
+	stale       atomic.Uint64
+}
+
+// New creates a fake pool
+func New(cfg *Config) *Pool {
+	return &Pool{cfg: cfg, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// ListenAndServe accepts miners on cfg.Listen until ctx is done
+func (p *Pool) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", p.cfg.listen())
+	if err != nil {
+		return err
+	}
+	log.Printf("sim: pool listening on %s", ln.Addr())
+	return p.Serve(ctx, ln)
+}
+
+// Serve accepts miners on ln until ctx is done
+func (p *Pool) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go p.handle(ctx, c)
+	}
+}
+
+// GetStats returns the pool counters
+func (p *Pool) GetStats() Stats {
+	return Stats{
+		Connections: p.connections.Load(),
+		Active:      p.active.Load(),
+		Jobs:        p.jobs.Load(),
+		Accepted:    p.accepted.Load(),
+		Rejected:    p.rejected.Load(),
+		Stale:       p.stale.Load(),
+	}
+}
+
+// reject draws whether a valid submit is rejected anyway
+func (p *Pool) reject() bool {
+	if p.cfg.RejectRate <= 0 {
+		return false
+	}
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	return p.rand.Float64() < p.cfg.RejectRate
+}
+
+// session is one miner connection
+type session struct {
+	p    *Pool
+	c    net.Conn
+	addr string
+	ex1  string
+
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	mu         sync.Mutex
+	authorized bool
+	jobs       map[string]bool // jobs valid for submits
+	seq        int             // jobs sent, for CleanEvery
+	diffIdx    int
+}
+
+func (p *Pool) handle(ctx context.Context, c net.Conn) {
+	p.connections.Add(1)
+	p.active.Add(1)
+	defer p.active.Add(-1)
+
+	s := &session{
+		p:    p,
+		c:    c,
+		addr: c.RemoteAddr().String(),
+		ex1:  fmt.Sprintf("%08x", p.ex1.Add(1)),
+		bw:   bufio.NewWriter(c),
+		jobs: make(map[string]bool),
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+	log.Printf("sim: %s connected (extranonce1 %s)", s.addr, s.ex1)
+	defer log.Printf("sim: %s disconnected", s.addr)
+
+	if d := p.cfg.DisconnectAfterMs; d > 0 {
+		time.AfterFunc(time.Duration(d)*time.Millisecond, cancel)
+	}
+
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 0, 4096), 64*1024)
+	for sc.Scan() {
+		var msg stratum.Message
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			continue
+		}
+		s.request(ctx, msg)
+	}
+}
+
+// request answers one miner request
+func (s *session) request(ctx context.Context, msg stratum.Message) {
+	switch msg.Method {
+	case stratum.MethodSubscribe:
+		s.write(stratum.NewSuccessResponse(msg.ID, []interface{}{
+			[]interface{}{
+				[]interface{}{stratum.MethodSetDifficulty, s.ex1},
+				[]interface{}{stratum.MethodNotify, s.ex1},
+			},
+			s.ex1,
+			s.p.cfg.ex2Size(),
+		}))
+	case stratum.MethodAuthorize:
+		s.write(stratum.NewSuccessResponse(msg.ID, true))
+		s.mu.Lock()
+		first := !s.authorized
+		s.authorized = true
+		s.mu.Unlock()
+		if first {
+			go s.run(ctx)
+		}
+	case stratum.MethodSubmit:
+		s.submit(msg)
+	case stratum.MethodConfigure:
+		s.write(stratum.NewSuccessResponse(msg.ID, map[string]interface{}{}))
+	case stratum.MethodSuggestDifficulty, "mining.ping", "mining.extranonce.subscribe":
+		s.write(stratum.NewSuccessResponse(msg.ID, true))
+	default:
+		if msg.IsRequest() {
+			s.write(stratum.NewErrorResponse(msg.ID, stratum.CodeOther, "Unsupported method", nil))
+		}
+	}
+}
+
+// submit accepts a share for a current job, subject to the reject rate
+func (s *session) submit(msg stratum.Message) {
+	params, _ := msg.Params.([]interface{})
+	var jobID string
+	if len(params) > 1 {
+		jobID, _ = params[1].(string)
+	}
+	s.mu.Lock()
+	authorized, current := s.authorized, s.jobs[jobID]
+	s.mu.Unlock()
+	switch {
+	case !authorized:
+		s.p.rejected.Add(1)
+		s.write(stratum.ErrorResponseFor(msg.ID, stratum.ErrUnauthorized))
+	case !current:
+		s.p.stale.Add(1)
+		s.p.rejected.Add(1)
+		s.write(stratum.ErrorResponseFor(msg.ID, stratum.ErrJobNotFound))
+	case s.p.reject():
+		s.p.rejected.Add(1)
+		s.write(stratum.NewErrorResponse(msg.ID, stratum.CodeLowDifficulty, s.p.cfg.rejectMessage(), nil))
+	default:
+		s.p.accepted.Add(1)
+		s.write(stratum.NewSuccessResponse(msg.ID, true))
+	}
+}
+
+// run sends the difficulty and jobs on their schedules once authorized
+func (s *session) run(ctx context.Context) {
+	cfg := s.p.cfg
+	s.difficulty()
+	s.notify()
+
+	notify := time.NewTicker(cfg.notifyInterval())
+	defer notify.Stop()
+	var diffC <-chan time.Time
+	if cfg.DifficultyIntervalMs > 0 && len(cfg.difficulties()) > 1 {
+		t := time.NewTicker(time.Duration(cfg.DifficultyIntervalMs) * time.Millisecond)
+		defer t.Stop()
+		diffC = t.C
+	}
+	var stallC <-chan time.Time
+	if cfg.StallAfterMs > 0 {
+		t := time.NewTimer(time.Duration(cfg.StallAfterMs) * time.Millisecond)
+		defer t.Stop()
+		stallC = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stallC:
+			log.Printf("sim: %s stalled; no more jobs", s.addr)
+			return
+		case <-diffC:
+			s.difficulty()
+		case <-notify.C:
+			s.notify()
+		}
+	}
+}
+
+// difficulty sends the next scripted difficulty
+func (s *session) difficulty() {
+	diffs := s.p.cfg.difficulties()
+	s.mu.Lock()
+	d := diffs[s.diffIdx%len(diffs)]
+	s.diffIdx++
+	s.mu.Unlock()
+	s.write(stratum.NewSetDifficultyMessage(d))
+}
+
+// notify sends a new job, clearing the old ones every CleanEvery jobs
+func (s *session) notify() {
+	id := strconv.FormatUint(s.p.job.Add(1), 16)
+	s.p.jobs.Add(1)
+
+	s.mu.Lock()
+	s.seq++
+	clean := s.seq == 1 || (s.p.cfg.CleanEvery > 0 && s.seq%s.p.cfg.CleanEvery == 0)
+	if clean {
+		s.jobs = make(map[string]bool)
+	}
+	s.jobs[id] = true
+	s.mu.Unlock()
+
+	prev := make([]byte, 32)
+	s.p.rmu.Lock()
+	s.p.rand.Read(prev)
+	s.p.rmu.Unlock()
+	s.write(stratum.Message{Method: stratum.MethodNotify, Params: []interface{}{
+		id,
+		hex.EncodeToString(prev),
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff20",
+		"ffffffff0100f2052a010000001976a914000000000000000000000000000000000000000088ac00000000",
+		[]interface{}{},
+		"20000000",
+		"1d00ffff",
+		fmt.Sprintf("%08x", time.Now().Unix()),
+		clean,
+	}})
+}
+
+// write sends one line to the miner
+func (s *session) write(msg stratum.Message) {
+	b, err := msg.Marshal()
+	if err != nil {
+		return
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_ = s.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.bw.Write(b); err == nil {
+		_ = s.bw.Flush()
+	}
+}
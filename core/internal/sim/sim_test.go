@@ -0,0 +1,141 @@
+package sim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// miner is a test client of the fake pool
+type miner struct {
+	t  *testing.T
+	c  net.Conn
+	br *bufio.Reader
+}
+
+func dialMiner(t *testing.T, addr string) *miner {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return &miner{t: t, c: c, br: bufio.NewReader(c)}
+}
+
+func (m *miner) send(line string) {
+	if _, err := m.c.Write([]byte(line + "\n")); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func (m *miner) read() stratum.Message {
+	_ = m.c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := m.br.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("read: %v", err)
+	}
+	var msg stratum.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("bad line %q: %v", line, err)
+	}
+	return msg
+}
+
+func startPool(t *testing.T, cfg *Config) (*Pool, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p := New(cfg)
+	go func() { _ = p.Serve(ctx, ln) }()
+	return p, ln.Addr().String()
+}
+
+func TestPoolScript(t *testing.T) {
+	p, addr := startPool(t, &Config{
+		NotifyIntervalMs:     50,
+		CleanEvery:           2,
+		Difficulties:         []float64{8, 16},
+		DifficultyIntervalMs: 50,
+		RejectRate:           1,
+		RejectMessage:        "Above target",
+	})
+	m := dialMiner(t, addr)
+
+	m.send(`{"id":1,"method":"mining.subscribe","params":[]}`)
+	sub := m.read()
+	ex := stratum.ParseExtranonceResult(sub.Result)
+	if ex.Extranonce1 == "" || ex.Extranonce2Size != 4 {
+		t.Fatalf("subscribe result = %v", sub.Result)
+	}
+	m.send(`{"id":2,"method":"mining.authorize","params":["w","x"]}`)
+	if auth := m.read(); auth.Result != true {
+		t.Fatalf("authorize = %+v", auth)
+	}
+
+	// the first difficulty and a clean job follow the authorization
+	if d := m.read(); d.Method != stratum.MethodSetDifficulty || d.Params.([]interface{})[0] != 8.0 {
+		t.Fatalf("first difficulty = %+v", d)
+	}
+	job, ok := stratum.ParseNotify(m.read().Params)
+	if !ok || !job.Clean {
+		t.Fatalf("first job = %+v", job)
+	}
+
+	// the script then rotates the difficulty and sends more jobs
+	seen := map[string]bool{}
+	for i := 0; i < 10 && !(seen["diff16"] && seen["notify"]); i++ {
+		msg := m.read()
+		switch {
+		case msg.Method == stratum.MethodSetDifficulty && msg.Params.([]interface{})[0] == 16.0:
+			seen["diff16"] = true
+		case msg.Method == stratum.MethodNotify:
+			seen["notify"] = true
+		}
+	}
+	if !seen["diff16"] || !seen["notify"] {
+		t.Fatalf("scripted updates missing: %v", seen)
+	}
+
+	// an unknown job is stale; a current one hits the reject rate
+	m.send(`{"id":3,"method":"mining.submit","params":["w","nope","00000000","5f000000","00000000"]}`)
+	m.send(`{"id":4,"method":"mining.submit","params":["w","` + job.ID + `","00000000","5f000000","00000000"]}`)
+	replies := map[int64]string{}
+	for len(replies) < 2 {
+		msg := m.read()
+		if id, ok := msg.ID.Int64(); ok && msg.Method == "" {
+			_, replies[id] = stratum.ParseError(msg.Error)
+		}
+	}
+	if replies[3] != "Job not found" {
+		t.Errorf("unknown job = %q", replies[3])
+	}
+	if replies[4] != "Above target" && replies[4] != "Job not found" {
+		t.Errorf("rejected share = %q", replies[4])
+	}
+	if st := p.GetStats(); st.Rejected != 2 || st.Stale < 1 || st.Connections != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestPoolDisconnect(t *testing.T) {
+	_, addr := startPool(t, &Config{DisconnectAfterMs: 100})
+	m := dialMiner(t, addr)
+	_ = m.c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := m.br.ReadByte(); err == nil {
+		t.Fatal("connection not dropped")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection still open after disconnect_after_ms")
+	}
+
+	if err := (&Config{RejectRate: 2}).Validate(); err == nil {
+		t.Error("reject_rate above 1 accepted")
+	}
+}
@@ -0,0 +1,91 @@
+package solo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// rpcClient is a minimal bitcoind JSON-RPC client
+type rpcClient struct {
+	url  string
+	user string
+	pass string
+	hc   *http.Client
+	id   atomic.Int64
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func newRPCClient(url, user, pass string) *rpcClient {
+	return &rpcClient{
+		url:  url,
+		user: user,
+		pass: pass,
+		hc:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call invokes method with params and decodes the result into out (if non-nil)
+func (c *rpcClient) call(ctx context.Context, method string, params []interface{}, out interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: "1.0", ID: c.id.Add(1), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%s: rpc authentication failed (%s)", method, resp.Status)
+	}
+
+	var rr rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return fmt.Errorf("%s: decoding response (%s): %w", method, resp.Status, err)
+	}
+	if rr.Error != nil {
+		return fmt.Errorf("%s: %w", method, rr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(rr.Result, out)
+}
@@ -0,0 +1,336 @@
+// Package solo implements a local block-template backend that builds Stratum
+// jobs from bitcoind's getblocktemplate and submits found blocks itself
+package solo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+const (
+	// ExtranonceSize is the extranonce1 size the backend hands to the proxy
+	ExtranonceSize = 4
+	// Extranonce2Size is the extranonce2 size advertised to the proxy
+	Extranonce2Size = 8
+
+	defaultPollInterval = 1 * time.Second
+	refreshInterval     = 30 * time.Second
+	maxJobs             = 16
+
+	// DefaultCoinbaseTag signs the coinbase when no tag is configured
+	DefaultCoinbaseTag = "/karoo/"
+	// maxScriptSig is the consensus limit on the coinbase scriptSig
+	maxScriptSig = 100
+	// maxHeightPush is the longest BIP34 height push, for heights below 2^31
+	maxHeightPush = 5
+)
+
+// Config holds solo backend settings
+type Config struct {
+	RPCURL        string
+	RPCUser       string
+	RPCPass       string
+	PayoutAddress string
+	PollInterval  time.Duration
+	// CoinbaseTag is pushed into the coinbase scriptSig after the
+	// extranonce; empty uses DefaultCoinbaseTag
+	CoinbaseTag string
+}
+
+// coinbaseTag returns the tag to sign coinbases with
+func (c *Config) coinbaseTag() string {
+	if c.CoinbaseTag == "" {
+		return DefaultCoinbaseTag
+	}
+	return c.CoinbaseTag
+}
+
+// MaxCoinbaseTag is the longest tag that fits the coinbase scriptSig at any
+// height next to the extranonce1 and extranonce2 space
+var MaxCoinbaseTag = func() int {
+	room := maxScriptSig - maxHeightPush - 1 - ExtranonceSize - Extranonce2Size
+	n := room
+	for n > 0 && len(pushData(make([]byte, n))) > room {
+		n--
+	}
+	return n
+}()
+
+// ValidateCoinbaseTag checks that tag fits the coinbase scriptSig
+func ValidateCoinbaseTag(tag string) error {
+	if len(tag) > MaxCoinbaseTag {
+		return fmt.Errorf("coinbase tag is %d bytes, at most %d fit next to the %d-byte extranonce", len(tag), MaxCoinbaseTag, ExtranonceSize+Extranonce2Size)
+	}
+	return nil
+}
+
+var (
+	errJobNotFound   = stratum.ErrJobNotFound
+	errDuplicate     = stratum.ErrDuplicate
+	errLowDifficulty = stratum.ErrLowDifficulty
+	errMalformed     = stratum.NewError(stratum.CodeOther, "Malformed submit")
+)
+
+// Backend polls bitcoind for block templates and validates shares locally
+type Backend struct {
+	cfg *Config
+	rpc *rpcClient
+
+	ex1 string
+
+	mu           sync.RWMutex
+	payoutScript []byte
+	jobs         map[string]*Job
+	order        []string
+	current      *Job
+	lastRefresh  time.Time
+
+	jobSeq         atomic.Uint64
+	sharesOK       atomic.Uint64
+	sharesRejected atomic.Uint64
+	blocksFound    atomic.Uint64
+	blocksRejected atomic.Uint64
+}
+
+// NewBackend creates a solo backend for the given bitcoind endpoint
+func NewBackend(cfg *Config) *Backend {
+	ex1 := make([]byte, ExtranonceSize)
+	_, _ = rand.Read(ex1)
+	return &Backend{
+		cfg:  cfg,
+		rpc:  newRPCClient(cfg.RPCURL, cfg.RPCUser, cfg.RPCPass),
+		ex1:  hex.EncodeToString(ex1),
+		jobs: make(map[string]*Job),
+	}
+}
+
+// Extranonce returns the extranonce1 and extranonce2 size used for all jobs
+func (b *Backend) Extranonce() (string, int) {
+	return b.ex1, Extranonce2Size
+}
+
+// Run polls for block templates until ctx is cancelled, calling onJob for
+// every new job. It returns the first RPC error so the caller can back off.
+func (b *Backend) Run(ctx context.Context, onJob func(*Job)) error {
+	if err := b.resolvePayout(ctx); err != nil {
+		return err
+	}
+	interval := b.cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := b.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if job != nil {
+			onJob(job)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolvePayout looks up the scriptPubKey for the configured payout address
+func (b *Backend) resolvePayout(ctx context.Context) error {
+	var res struct {
+		IsValid      bool   `json:"isvalid"`
+		ScriptPubKey string `json:"scriptPubKey"`
+	}
+	if err := b.rpc.call(ctx, "validateaddress", []interface{}{b.cfg.PayoutAddress}, &res); err != nil {
+		return err
+	}
+	if !res.IsValid || res.ScriptPubKey == "" {
+		return fmt.Errorf("payout address %q rejected by node", b.cfg.PayoutAddress)
+	}
+	script, err := hex.DecodeString(res.ScriptPubKey)
+	if err != nil {
+		return fmt.Errorf("payout script: %w", err)
+	}
+	b.mu.Lock()
+	b.payoutScript = script
+	b.mu.Unlock()
+	return nil
+}
+
+// poll fetches a template and returns a new job when the tip changed or the
+// current job is due for a refresh; it returns nil when nothing changed
+func (b *Backend) poll(ctx context.Context) (*Job, error) {
+	var tpl blockTemplate
+	params := []interface{}{map[string]interface{}{"rules": []string{"segwit"}}}
+	if err := b.rpc.call(ctx, "getblocktemplate", params, &tpl); err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	cur := b.current
+	stale := time.Since(b.lastRefresh) >= refreshInterval
+	script := b.payoutScript
+	b.mu.RUnlock()
+
+	clean := cur == nil || cur.Height != tpl.Height || !strings.EqualFold(cur.prevHashHex(), tpl.PreviousBlockHash)
+	if !clean && !stale {
+		return nil, nil
+	}
+
+	job, err := buildJob(jobIDString(b.jobSeq.Add(1)), &tpl, script, b.cfg.coinbaseTag(), ExtranonceSize+Extranonce2Size)
+	if err != nil {
+		return nil, err
+	}
+	job.Clean = clean
+
+	b.mu.Lock()
+	if clean {
+		b.jobs = make(map[string]*Job)
+		b.order = b.order[:0]
+	}
+	b.jobs[job.ID] = job
+	b.order = append(b.order, job.ID)
+	for len(b.order) > maxJobs {
+		delete(b.jobs, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.current = job
+	b.lastRefresh = time.Now()
+	b.mu.Unlock()
+
+	if clean {
+		log.Printf("solo: new block template height=%d txs=%d", tpl.Height, len(tpl.Transactions))
+	}
+	return job, nil
+}
+
+// Submit validates a mining.submit (params after the worker name has been
+// replaced) against the share difficulty and submits the block when the
+// header also meets the network target. A nil error means the share is valid.
+func (b *Backend) Submit(ctx context.Context, params []interface{}, difficulty float64) error {
+	if len(params) < 5 {
+		return errMalformed
+	}
+	var fields [5]string
+	for i := 1; i < 5; i++ {
+		s, ok := params[i].(string)
+		if !ok {
+			return errMalformed
+		}
+		fields[i] = strings.ToLower(s)
+	}
+	jobID, ex2, ntime, nonceHex := fields[1], fields[2], fields[3], fields[4]
+	if len(ex2) != Extranonce2Size*2 || len(ntime) != 8 || len(nonceHex) != 8 {
+		return errMalformed
+	}
+
+	b.mu.Lock()
+	job, ok := b.jobs[jobID]
+	if !ok {
+		b.mu.Unlock()
+		b.sharesRejected.Add(1)
+		return errJobNotFound
+	}
+
+	var versionBits string
+	if len(params) > 5 {
+		s, ok := params[5].(string)
+		if !ok {
+			b.mu.Unlock()
+			return errMalformed
+		}
+		versionBits = strings.ToLower(s)
+	}
+
+	key := ex2 + ntime + nonceHex + versionBits
+	if _, dup := job.seen[key]; dup {
+		b.mu.Unlock()
+		b.sharesRejected.Add(1)
+		return errDuplicate
+	}
+	job.seen[key] = struct{}{}
+	b.mu.Unlock()
+
+	header, err := job.Work().Header(b.ex1, ex2, ntime, nonceHex, versionBits)
+	if err != nil {
+		return errMalformed
+	}
+	hash := stratum.SHA256d.HeaderHash(header)
+
+	if difficulty > 0 && stratum.SHA256d.HashDifficulty(hash) < difficulty*0.999 {
+		b.sharesRejected.Add(1)
+		return errLowDifficulty
+	}
+	b.sharesOK.Add(1)
+
+	if hash.Cmp(job.target) <= 0 {
+		coinbase, _ := job.coinbase(b.ex1, ex2) // decoded by Header already
+		b.submitBlock(ctx, job, header, coinbase, hash)
+	}
+	return nil
+}
+
+// submitBlock hands a solved block to bitcoind
+func (b *Backend) submitBlock(ctx context.Context, job *Job, header, coinbase []byte, hash *big.Int) {
+	blockHash := fmt.Sprintf("%064x", hash)
+	var result interface{}
+	err := b.rpc.call(ctx, "submitblock", []interface{}{job.blockHex(header, coinbase)}, &result)
+	if err == nil && result != nil {
+		err = fmt.Errorf("%v", result)
+	}
+	if err != nil {
+		b.blocksRejected.Add(1)
+		log.Printf("solo: block %s at height %d rejected: %v", blockHash, job.Height, err)
+		return
+	}
+	b.blocksFound.Add(1)
+	log.Printf("solo: BLOCK FOUND hash=%s height=%d", blockHash, job.Height)
+}
+
+// Difficulty returns the network difficulty of the job's block target
+func (j *Job) Difficulty() float64 {
+	return stratum.NetworkDifficulty(j.target)
+}
+
+// prevHashHex returns the job's previous block hash in display order
+func (j *Job) prevHashHex() string {
+	return hex.EncodeToString(reverseBytes(j.prevHashLE))
+}
+
+// GetStats returns solo backend statistics
+func (b *Backend) GetStats() map[string]interface{} {
+	b.mu.RLock()
+	var height int64
+	var netDiff float64
+	if b.current != nil {
+		height = b.current.Height
+		netDiff = b.current.Difficulty()
+	}
+	jobs := len(b.jobs)
+	b.mu.RUnlock()
+	return map[string]interface{}{
+		"height":             height,
+		"network_difficulty": netDiff,
+		"jobs":               jobs,
+		"shares_ok":          b.sharesOK.Load(),
+		"shares_rejected":    b.sharesRejected.Load(),
+		"blocks_found":       b.blocksFound.Load(),
+		"blocks_rejected":    b.blocksRejected.Load(),
+	}
+}
@@ -0,0 +1,224 @@
+package solo
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWorkHeaderGenesis(t *testing.T) {
+	// the genesis coinbase split around an extranonce of 04ffff00 1d010445
+	const coinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+	job := &Job{PrevHash: strings.Repeat("0", 64), Coinb1: coinbase[:84], Coinb2: coinbase[100:], Version: "00000001", NBits: "1d00ffff"}
+	header, err := job.Work().Header("04ffff00", "1d010445", "495fab29", "7c2bac1d", "")
+	if err != nil {
+		t.Fatalf("Header: %v", err)
+	}
+	got := hex.EncodeToString(reverseBytes(doubleSHA256(header)))
+	want := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	if got != want {
+		t.Errorf("genesis hash = %s, want %s", got, want)
+	}
+}
+
+func TestStratumPrevHash(t *testing.T) {
+	got, err := stratumPrevHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	if err != nil {
+		t.Fatalf("stratumPrevHash: %v", err)
+	}
+	want := "0a8ce26f72b3f1b646a2a6c14ff763ae65831e939c085ae10019d66800000000"
+	if got != want {
+		t.Errorf("stratumPrevHash = %s, want %s", got, want)
+	}
+	if _, err := stratumPrevHash("abcd"); err == nil {
+		t.Error("expected error for short hash")
+	}
+}
+
+func TestMerkleBranch(t *testing.T) {
+	h := func(b byte) []byte { return doubleSHA256([]byte{b}) }
+	cb, t1, t2 := h(0), h(1), h(2)
+
+	branch := merkleBranch([][]byte{t1, t2})
+	if len(branch) != 2 {
+		t.Fatalf("branch length = %d, want 2", len(branch))
+	}
+	// full tree: [cb t1] [t2 t2]
+	left := doubleSHA256(append(append([]byte{}, cb...), t1...))
+	right := doubleSHA256(append(append([]byte{}, t2...), t2...))
+	want := doubleSHA256(append(left, right...))
+	got := cb
+	for _, h := range branch {
+		got = doubleSHA256(append(append([]byte{}, got...), h...))
+	}
+	if hex.EncodeToString(got) != hex.EncodeToString(want) {
+		t.Errorf("merkle root mismatch")
+	}
+	if len(merkleBranch(nil)) != 0 {
+		t.Error("coinbase-only block should have an empty branch")
+	}
+}
+
+func TestSerializeHeight(t *testing.T) {
+	tests := []struct {
+		height int64
+		want   string
+	}{
+		{1, "51"},
+		{16, "60"},
+		{17, "0111"},
+		{128, "028000"},
+		{840000, "0340d10c"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(serializeHeight(tt.height)); got != tt.want {
+			t.Errorf("serializeHeight(%d) = %s, want %s", tt.height, got, tt.want)
+		}
+	}
+}
+
+// fakeNode is a minimal bitcoind stand-in for getblocktemplate/submitblock
+type fakeNode struct {
+	mu        sync.Mutex
+	submitted []string
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64         `json:"id"`
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	var result interface{}
+	switch req.Method {
+	case "validateaddress":
+		result = map[string]interface{}{"isvalid": true, "scriptPubKey": "0014" + strings.Repeat("11", 20)}
+	case "getblocktemplate":
+		result = map[string]interface{}{
+			"version":                    0x20000000,
+			"previousblockhash":          strings.Repeat("00", 28) + "0badc0de",
+			"transactions":               []interface{}{},
+			"coinbasevalue":              5000000000,
+			"target":                     "7fffff" + strings.Repeat("00", 29),
+			"curtime":                    1700000000,
+			"bits":                       "207fffff",
+			"height":                     101,
+			"default_witness_commitment": "6a24aa21a9ed" + strings.Repeat("22", 32),
+		}
+	case "submitblock":
+		f.mu.Lock()
+		f.submitted = append(f.submitted, req.Params[0].(string))
+		f.mu.Unlock()
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result, "error": nil})
+}
+
+func TestBackendSubmitBlock(t *testing.T) {
+	node := &fakeNode{}
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	b := NewBackend(&Config{RPCURL: srv.URL, PayoutAddress: "bcrt1qtest"})
+	ctx := context.Background()
+	if err := b.resolvePayout(ctx); err != nil {
+		t.Fatalf("resolvePayout: %v", err)
+	}
+	job, err := b.poll(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("poll: job=%v err=%v", job, err)
+	}
+	if !job.Clean || job.Height != 101 {
+		t.Errorf("unexpected job: clean=%v height=%d", job.Clean, job.Height)
+	}
+	if again, _ := b.poll(ctx); again != nil {
+		t.Error("unchanged template should not produce a new job")
+	}
+
+	ex2 := strings.Repeat("00", Extranonce2Size)
+	if err := b.Submit(ctx, []interface{}{"w", "nope", ex2, job.NTime, "00000000"}, 0); !errors.Is(err, errJobNotFound) {
+		t.Errorf("unknown job err = %v", err)
+	}
+
+	// regtest target accepts roughly every other nonce
+	var found bool
+	for n := 0; n < 64 && !found; n++ {
+		params := []interface{}{"w", job.ID, ex2, job.NTime, fmt.Sprintf("%08x", n)}
+		if err := b.Submit(ctx, params, 0); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		node.mu.Lock()
+		found = len(node.submitted) > 0
+		node.mu.Unlock()
+		if found {
+			if err := b.Submit(ctx, params, 0); !errors.Is(err, errDuplicate) {
+				t.Errorf("duplicate err = %v", err)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no block submitted")
+	}
+	block := node.submitted[0]
+	// header, tx count 1, then a segwit coinbase (version + marker/flag)
+	if !strings.HasPrefix(block[160:], "01"+"01000000"+"0001") {
+		t.Errorf("unexpected block body: %s", block[160:180])
+	}
+	if stats := b.GetStats(); stats["blocks_found"].(uint64) != 1 {
+		t.Errorf("blocks_found = %v", stats["blocks_found"])
+	}
+}
+
+func TestBackendLowDifficulty(t *testing.T) {
+	srv := httptest.NewServer(&fakeNode{})
+	defer srv.Close()
+
+	b := NewBackend(&Config{RPCURL: srv.URL, PayoutAddress: "bcrt1qtest"})
+	ctx := context.Background()
+	if err := b.resolvePayout(ctx); err != nil {
+		t.Fatalf("resolvePayout: %v", err)
+	}
+	job, err := b.poll(ctx)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	params := []interface{}{"w", job.ID, strings.Repeat("00", Extranonce2Size), job.NTime, "00000000"}
+	if err := b.Submit(ctx, params, 1e12); !errors.Is(err, errLowDifficulty) {
+		t.Errorf("err = %v, want low difficulty", err)
+	}
+}
+
+func TestCoinbaseTag(t *testing.T) {
+	srv := httptest.NewServer(&fakeNode{})
+	defer srv.Close()
+
+	tag := strings.Repeat("k", MaxCoinbaseTag)
+	b := NewBackend(&Config{RPCURL: srv.URL, PayoutAddress: "bcrt1qtest", CoinbaseTag: tag})
+	ctx := context.Background()
+	if err := b.resolvePayout(ctx); err != nil {
+		t.Fatalf("resolvePayout: %v", err)
+	}
+	job, err := b.poll(ctx)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if !strings.HasPrefix(job.Coinb2, hex.EncodeToString(pushData([]byte(tag)))) {
+		t.Errorf("coinb2 does not start with the tag: %s", job.Coinb2)
+	}
+
+	// the longest tag still fits at the largest height
+	tpl := &blockTemplate{PreviousBlockHash: strings.Repeat("00", 32), Target: "7fffff", Bits: "207fffff", Height: 1<<31 - 1}
+	if _, err := buildJob("1", tpl, []byte{0x51}, tag, ExtranonceSize+Extranonce2Size); err != nil {
+		t.Errorf("longest tag: %v", err)
+	}
+	if ValidateCoinbaseTag(tag) != nil || ValidateCoinbaseTag(tag+"k") == nil {
+		t.Errorf("tag limit is not %d bytes", MaxCoinbaseTag)
+	}
+}
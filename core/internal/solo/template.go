@@ -0,0 +1,215 @@
+package solo
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/carlosrabelo/karoo/core/internal/stratum"
+)
+
+// blockTemplate is the subset of getblocktemplate output used to build jobs
+type blockTemplate struct {
+	Version                  int64         `json:"version"`
+	PreviousBlockHash        string        `json:"previousblockhash"`
+	Transactions             []templateTx  `json:"transactions"`
+	CoinbaseValue            int64         `json:"coinbasevalue"`
+	Target                   string        `json:"target"`
+	CurTime                  int64         `json:"curtime"`
+	Bits                     string        `json:"bits"`
+	Height                   int64         `json:"height"`
+	DefaultWitnessCommitment string        `json:"default_witness_commitment"`
+	Rules                    []interface{} `json:"rules"`
+}
+
+type templateTx struct {
+	Data string `json:"data"`
+	TxID string `json:"txid"`
+	Hash string `json:"hash"`
+}
+
+// Job is a unit of work derived from a block template
+type Job struct {
+	ID           string
+	PrevHash     string // stratum word-swapped form
+	Coinb1       string
+	Coinb2       string
+	MerkleBranch []string
+	Version      string
+	NBits        string
+	NTime        string
+	Clean        bool
+
+	Height  int64
+	Created time.Time
+
+	prevHashLE []byte
+	target     *big.Int
+	txData     []string
+	witness    bool
+	seen       map[string]struct{}
+}
+
+// NotifyMessage renders the job as a mining.notify notification
+func (j *Job) NotifyMessage() stratum.Message {
+	branch := make([]interface{}, len(j.MerkleBranch))
+	for i, b := range j.MerkleBranch {
+		branch[i] = b
+	}
+	return stratum.Message{
+		Method: stratum.MethodNotify,
+		Params: []interface{}{j.ID, j.PrevHash, j.Coinb1, j.Coinb2, branch, j.Version, j.NBits, j.NTime, j.Clean},
+	}
+}
+
+// Work returns the job as the proxy's view of a mining.notify, which rebuilds
+// share headers
+func (j *Job) Work() stratum.Job {
+	return stratum.Job{
+		ID:           j.ID,
+		PrevHash:     j.PrevHash,
+		Coinbase1:    j.Coinb1,
+		Coinbase2:    j.Coinb2,
+		MerkleBranch: j.MerkleBranch,
+		Version:      j.Version,
+		NBits:        j.NBits,
+		NTime:        j.NTime,
+		Clean:        j.Clean,
+	}
+}
+
+// buildJob turns a template into a job whose coinbase pays payoutScript and
+// leaves room for extranonce1+extranonce2 between coinb1 and coinb2
+func buildJob(id string, tpl *blockTemplate, payoutScript []byte, tag string, extranonceSize int) (*Job, error) {
+	prevHash, err := stratumPrevHash(tpl.PreviousBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	prevDisplay, err := hex.DecodeString(tpl.PreviousBlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("previousblockhash: %w", err)
+	}
+	target, ok := new(big.Int).SetString(tpl.Target, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid target %q", tpl.Target)
+	}
+	if len(tpl.Bits) != 8 {
+		return nil, fmt.Errorf("invalid bits %q", tpl.Bits)
+	}
+
+	// scriptSig: BIP34 height, extranonce push, optional tag
+	heightPush := serializeHeight(tpl.Height)
+	var tagPush []byte
+	if tag != "" {
+		tagPush = pushData([]byte(tag))
+	}
+	scriptLen := len(heightPush) + 1 + extranonceSize + len(tagPush)
+	if scriptLen > 100 {
+		return nil, fmt.Errorf("coinbase scriptSig too long (%d bytes)", scriptLen)
+	}
+
+	var cb1 []byte
+	cb1 = append(cb1, 0x01, 0x00, 0x00, 0x00) // tx version
+	cb1 = append(cb1, 0x01)                   // input count
+	cb1 = append(cb1, make([]byte, 32)...)    // null prevout hash
+	cb1 = append(cb1, 0xff, 0xff, 0xff, 0xff) // prevout index
+	cb1 = append(cb1, varInt(uint64(scriptLen))...)
+	cb1 = append(cb1, heightPush...)
+	cb1 = append(cb1, byte(extranonceSize))
+
+	var cb2 []byte
+	cb2 = append(cb2, tagPush...)
+	cb2 = append(cb2, 0xff, 0xff, 0xff, 0xff) // sequence
+
+	witness := tpl.DefaultWitnessCommitment != ""
+	outputs := 1
+	if witness {
+		outputs++
+	}
+	cb2 = append(cb2, varInt(uint64(outputs))...)
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(tpl.CoinbaseValue))
+	cb2 = append(cb2, value...)
+	cb2 = append(cb2, varInt(uint64(len(payoutScript)))...)
+	cb2 = append(cb2, payoutScript...)
+	if witness {
+		commitment, err := hex.DecodeString(tpl.DefaultWitnessCommitment)
+		if err != nil {
+			return nil, fmt.Errorf("default_witness_commitment: %w", err)
+		}
+		cb2 = append(cb2, make([]byte, 8)...)
+		cb2 = append(cb2, varInt(uint64(len(commitment)))...)
+		cb2 = append(cb2, commitment...)
+	}
+	cb2 = append(cb2, 0x00, 0x00, 0x00, 0x00) // locktime
+
+	txHashes := make([][]byte, 0, len(tpl.Transactions))
+	txData := make([]string, 0, len(tpl.Transactions))
+	for _, tx := range tpl.Transactions {
+		id, err := hex.DecodeString(tx.TxID)
+		if err != nil || len(id) != 32 {
+			return nil, fmt.Errorf("invalid txid %q", tx.TxID)
+		}
+		txHashes = append(txHashes, reverseBytes(id))
+		txData = append(txData, tx.Data)
+	}
+	branch := merkleBranch(txHashes)
+	branchHex := make([]string, len(branch))
+	for i, b := range branch {
+		branchHex[i] = hex.EncodeToString(b)
+	}
+
+	return &Job{
+		ID:           id,
+		PrevHash:     prevHash,
+		Coinb1:       hex.EncodeToString(cb1),
+		Coinb2:       hex.EncodeToString(cb2),
+		MerkleBranch: branchHex,
+		Version:      fmt.Sprintf("%08x", uint32(tpl.Version)),
+		NBits:        tpl.Bits,
+		NTime:        fmt.Sprintf("%08x", uint32(tpl.CurTime)),
+		Height:       tpl.Height,
+		Created:      time.Now(),
+		prevHashLE:   reverseBytes(prevDisplay),
+		target:       target,
+		txData:       txData,
+		witness:      witness,
+		seen:         make(map[string]struct{}),
+	}, nil
+}
+
+// coinbase assembles the full coinbase transaction for the given extranonces
+func (j *Job) coinbase(ex1, ex2 string) ([]byte, error) {
+	return hex.DecodeString(j.Coinb1 + ex1 + ex2 + j.Coinb2)
+}
+
+// blockHex serializes a full block for submitblock
+func (j *Job) blockHex(header, coinbase []byte) string {
+	var block []byte
+	block = append(block, header...)
+	block = append(block, varInt(uint64(len(j.txData)+1))...)
+	if j.witness {
+		// re-serialize the coinbase with marker, flag and the reserved witness
+		block = append(block, coinbase[:4]...)
+		block = append(block, 0x00, 0x01)
+		block = append(block, coinbase[4:len(coinbase)-4]...)
+		block = append(block, 0x01, 0x20)
+		block = append(block, make([]byte, 32)...)
+		block = append(block, coinbase[len(coinbase)-4:]...)
+	} else {
+		block = append(block, coinbase...)
+	}
+	out := hex.EncodeToString(block)
+	for _, tx := range j.txData {
+		out += tx
+	}
+	return out
+}
+
+// jobIDString formats a job sequence number
+func jobIDString(seq uint64) string {
+	return strconv.FormatUint(seq, 16)
+}
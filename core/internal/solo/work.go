@@ -0,0 +1,105 @@
+package solo
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// doubleSHA256 returns SHA256(SHA256(b))
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// reverseBytes returns a reversed copy of b
+func reverseBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+// varInt encodes n as a Bitcoin CompactSize integer
+func varInt(n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return []byte{byte(n)}
+	case n <= 0xffff:
+		b := make([]byte, 3)
+		b[0] = 0xfd
+		binary.LittleEndian.PutUint16(b[1:], uint16(n))
+		return b
+	case n <= 0xffffffff:
+		b := make([]byte, 5)
+		b[0] = 0xfe
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+		return b
+	default:
+		b := make([]byte, 9)
+		b[0] = 0xff
+		binary.LittleEndian.PutUint64(b[1:], n)
+		return b
+	}
+}
+
+// pushData returns a script push of data (only small pushes are needed here)
+func pushData(data []byte) []byte {
+	if len(data) < 0x4c {
+		return append([]byte{byte(len(data))}, data...)
+	}
+	return append([]byte{0x4c, byte(len(data))}, data...)
+}
+
+// serializeHeight encodes a block height the way BIP34 expects it in the
+// coinbase scriptSig (identical to Bitcoin Core's CScript() << height)
+func serializeHeight(height int64) []byte {
+	if height == 0 {
+		return []byte{0x00}
+	}
+	if height >= 1 && height <= 16 {
+		return []byte{byte(0x50 + height)}
+	}
+	var num []byte
+	for v := height; v > 0; v >>= 8 {
+		num = append(num, byte(v&0xff))
+	}
+	if num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0x00)
+	}
+	return pushData(num)
+}
+
+// merkleBranch computes the Stratum merkle branch for a coinbase at index 0
+// given the remaining transaction hashes in internal byte order
+func merkleBranch(txHashes [][]byte) [][]byte {
+	var branch [][]byte
+	level := append([][]byte{nil}, txHashes...)
+	for len(level) > 1 {
+		branch = append(branch, level[1])
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := [][]byte{nil}
+		for i := 2; i < len(level); i += 2 {
+			next = append(next, doubleSHA256(append(append([]byte{}, level[i]...), level[i+1]...)))
+		}
+		level = next
+	}
+	return branch
+}
+
+// stratumPrevHash converts a display-order block hash into the word-swapped
+// form used by mining.notify
+func stratumPrevHash(displayHex string) (string, error) {
+	if len(displayHex) != 64 {
+		return "", fmt.Errorf("invalid block hash length %d", len(displayHex))
+	}
+	out := make([]byte, 0, 64)
+	for i := 56; i >= 0; i -= 8 {
+		out = append(out, displayHex[i:i+8]...)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,124 @@
+package stratum
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithm is the proof of work of a coin: how a block header is hashed
+// and which target share difficulty 1 stands for. Network difficulty from
+// nBits does not depend on it; see DiffFromBits.
+type Algorithm struct {
+	Name string
+	// hash returns the proof-of-work hash of an 80-byte header, in the
+	// little-endian byte order of the chain
+	hash func(header []byte) []byte
+	// diffOne is the target of share difficulty 1
+	diffOne *big.Int
+}
+
+// Supported algorithms. Scrypt pools count share difficulty from a target
+// 2^16 times that of Bitcoin, so share difficulties of the two are not
+// comparable, and a scrypt share difficulty is not comparable with the
+// network difficulty either: compare hashes with BitsTarget instead.
+var (
+	SHA256d = &Algorithm{Name: "sha256d", hash: doubleSHA256, diffOne: DiffOneTarget}
+	Scrypt  = &Algorithm{Name: "scrypt", hash: scryptHash, diffOne: new(big.Int).Lsh(big.NewInt(0xFFFF), 224)}
+)
+
+// algorithms maps the names accepted in the config to their algorithm
+var algorithms = map[string]*Algorithm{
+	"sha256d": SHA256d,
+	"sha256":  SHA256d,
+	"scrypt":  Scrypt,
+}
+
+// AlgorithmByName returns the named algorithm; empty is sha256d
+func AlgorithmByName(name string) (*Algorithm, error) {
+	if name == "" {
+		return SHA256d, nil
+	}
+	if a, ok := algorithms[strings.ToLower(name)]; ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q (want sha256d or scrypt)", name)
+}
+
+// Hash returns the proof-of-work hash of an 80-byte header, little-endian
+func (a *Algorithm) Hash(header []byte) []byte {
+	return a.hash(header)
+}
+
+// Target returns the share target of a difficulty; 0 or less counts as 1
+func (a *Algorithm) Target(diff float64) *big.Int {
+	if diff <= 0 {
+		diff = 1
+	}
+	t, _ := new(big.Float).Quo(new(big.Float).SetInt(a.diffOne), big.NewFloat(diff)).Int(nil)
+	return t
+}
+
+// HeaderHash hashes a header and returns the hash as a number, to compare
+// with a target
+func (a *Algorithm) HeaderHash(header []byte) *big.Int {
+	hash := a.hash(header)
+	for i, k := 0, len(hash)-1; i < k; i, k = i+1, k-1 {
+		hash[i], hash[k] = hash[k], hash[i]
+	}
+	return new(big.Int).SetBytes(hash)
+}
+
+// HeaderDifficulty hashes a header and returns the difficulty it meets
+func (a *Algorithm) HeaderDifficulty(header []byte) (float64, error) {
+	h := a.HeaderHash(header)
+	if h.Sign() == 0 {
+		return 0, errors.New("zero hash")
+	}
+	return a.HashDifficulty(h), nil
+}
+
+// ShareHash rebuilds the block header of a submitted share and returns its
+// hash as a number
+func (a *Algorithm) ShareHash(j Job, ex1, ex2, ntime, nonce, versionBits string) (*big.Int, error) {
+	header, err := j.Header(ex1, ex2, ntime, nonce, versionBits)
+	if err != nil {
+		return nil, err
+	}
+	return a.HeaderHash(header), nil
+}
+
+// ShareDifficulty rebuilds the block header of a submitted share and returns
+// the difficulty its hash meets
+func (a *Algorithm) ShareDifficulty(j Job, ex1, ex2, ntime, nonce, versionBits string) (float64, error) {
+	header, err := j.Header(ex1, ex2, ntime, nonce, versionBits)
+	if err != nil {
+		return 0, err
+	}
+	return a.HeaderDifficulty(header)
+}
+
+// HashDifficulty returns the share difficulty a hash (or target) meets, 0
+// for zero
+func (a *Algorithm) HashDifficulty(target *big.Int) float64 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	q := new(big.Float).Quo(new(big.Float).SetInt(a.diffOne), new(big.Float).SetInt(target))
+	out, _ := q.Float64()
+	return out
+}
+
+// scryptHash returns the Litecoin proof-of-work hash: scrypt with N=1024,
+// r=1, p=1 over the header, salted with itself
+func scryptHash(header []byte) []byte {
+	h, err := scrypt.Key(header, header, 1024, 1, 1, 32)
+	if err != nil {
+		// only invalid parameters fail, and these are fixed
+		panic(err)
+	}
+	return h
+}
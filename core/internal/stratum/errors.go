@@ -0,0 +1,53 @@
+package stratum
+
+import "errors"
+
+// Error codes answered to miners, the numbering most pools use
+const (
+	CodeOther         = 20 // other or unknown error
+	CodeJobNotFound   = 21 // job not found, or stale
+	CodeDuplicate     = 22 // duplicate share
+	CodeLowDifficulty = 23 // low difficulty share
+	CodeUnauthorized  = 24 // unauthorized worker
+	CodeNotSubscribed = 25 // not subscribed
+)
+
+// Errors the proxy answers miners with
+var (
+	ErrUpstreamDown   = &Error{Code: CodeOther, Message: "Upstream down"}
+	ErrForwardFailed  = &Error{Code: CodeOther, Message: "Forward error"}
+	ErrRequestTimeout = &Error{Code: CodeOther, Message: "Upstream request timed out"}
+	ErrQueueFull      = &Error{Code: CodeOther, Message: "Submit queue full"}
+	ErrReconnecting   = &Error{Code: CodeOther, Message: "Upstream reconnecting, retry"}
+	ErrNotSupported   = &Error{Code: CodeOther, Message: "Method not supported"}
+	ErrProxyFull      = &Error{Code: CodeOther, Message: "Proxy full"}
+	ErrThrottled      = &Error{Code: CodeOther, Message: "Submissions throttled"}
+	ErrInvalidShare   = &Error{Code: CodeOther, Message: "Invalid share parameters"}
+	ErrNTimeRange     = &Error{Code: CodeOther, Message: "ntime out of range"}
+	ErrLineTooLong    = &Error{Code: CodeOther, Message: "Line too long"}
+	ErrJobNotFound    = &Error{Code: CodeJobNotFound, Message: "Job not found"}
+	ErrStaleJob       = &Error{Code: CodeJobNotFound, Message: "Stale job"}
+	ErrDuplicate      = &Error{Code: CodeDuplicate, Message: "Duplicate share"}
+	ErrLowDifficulty  = &Error{Code: CodeLowDifficulty, Message: "Low difficulty share"}
+	ErrUnauthorized   = &Error{Code: CodeUnauthorized, Message: "Unauthorized worker"}
+	ErrNotSubscribed  = &Error{Code: CodeNotSubscribed, Message: "Not subscribed"}
+)
+
+// NewError returns an error answered to miners with code
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// ErrorCode returns the code of err, CodeOther when it is not an *Error
+func ErrorCode(err error) int {
+	var se *Error
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return CodeOther
+}
+
+// ErrorResponseFor answers the request id with err
+func ErrorResponseFor(id *ID, err error) Message {
+	return NewErrorResponse(id, ErrorCode(err), err.Error(), nil)
+}
@@ -0,0 +1,55 @@
+package stratum
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledFrame keeps unusually large frames out of the pool
+const maxPooledFrame = 64 << 10
+
+// Frame is a newline-terminated line serialized once and shared by every
+// client it is written to. It is reference counted: the creator holds one
+// reference, each queue holding it takes another, and the buffer returns to
+// a pool when the last one is released. Its bytes must not be modified.
+type Frame struct {
+	b    []byte
+	refs atomic.Int32
+}
+
+var framePool = sync.Pool{
+	New: func() interface{} { return &Frame{b: make([]byte, 0, 1024)} },
+}
+
+// NewFrame copies line into a pooled frame and terminates it with a newline
+func NewFrame(line []byte) *Frame {
+	f := framePool.Get().(*Frame)
+	f.b = append(append(f.b[:0], line...), '\n')
+	f.refs.Store(1)
+	return f
+}
+
+// NewFrameString is NewFrame for a string, without converting it first
+func NewFrameString(line string) *Frame {
+	f := framePool.Get().(*Frame)
+	f.b = append(append(f.b[:0], line...), '\n')
+	f.refs.Store(1)
+	return f
+}
+
+// Bytes returns the line including its newline
+func (f *Frame) Bytes() []byte {
+	return f.b
+}
+
+// Retain takes another reference to the frame
+func (f *Frame) Retain() {
+	f.refs.Add(1)
+}
+
+// Release drops a reference; the last one returns the frame to the pool
+func (f *Frame) Release() {
+	if f.refs.Add(-1) == 0 && cap(f.b) <= maxPooledFrame {
+		framePool.Put(f)
+	}
+}
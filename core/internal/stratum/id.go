@@ -0,0 +1,81 @@
+package stratum
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// ID is a JSON-RPC request ID kept exactly as it appeared on the wire: an
+// integer, a string or another JSON number. Replies echo it unchanged; a
+// missing or null ID is a nil *ID.
+type ID struct {
+	raw string // JSON text
+}
+
+var errBadID = errors.New("id must be a number, a string or null")
+
+// NewID returns an integer ID
+func NewID(n int64) *ID {
+	return &ID{raw: strconv.FormatInt(n, 10)}
+}
+
+// NewStringID returns a string ID
+func NewStringID(s string) *ID {
+	b, _ := json.Marshal(s)
+	return &ID{raw: string(b)}
+}
+
+// Int64 returns the ID as an integer; ok is false for nil, string and
+// fractional IDs
+func (id *ID) Int64() (int64, bool) {
+	if id == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(id.raw, 10, 64)
+	return n, err == nil
+}
+
+// String returns the ID as JSON text, "null" for nil
+func (id *ID) String() string {
+	if id == nil || id.raw == "" {
+		return "null"
+	}
+	return id.raw
+}
+
+// MarshalJSON writes the ID as received
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.raw == "" {
+		return []byte("null"), nil
+	}
+	return []byte(id.raw), nil
+}
+
+// UnmarshalJSON accepts a number, a string or null
+func (id *ID) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return errBadID
+	}
+	switch c := b[0]; {
+	case c == 'n' && string(b) == "null":
+		id.raw = ""
+		return nil
+	case c == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	case c == '-' || c >= '0' && c <= '9':
+		var n json.Number
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+	default:
+		return errBadID
+	}
+	id.raw = string(b)
+	return nil
+}
@@ -0,0 +1,259 @@
+package stratum
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrParams is wrapped by every error the params parsers return
+var ErrParams = errors.New("malformed params")
+
+// paramsError returns an ErrParams with what was wrong
+func paramsError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrParams, fmt.Sprintf(format, args...))
+}
+
+// paramsArray returns params as an array of at least min entries
+func paramsArray(params interface{}, min int) ([]interface{}, error) {
+	arr, ok := params.([]interface{})
+	if !ok {
+		return nil, paramsError("not an array")
+	}
+	if len(arr) < min {
+		return nil, paramsError("%d params, want at least %d", len(arr), min)
+	}
+	return arr, nil
+}
+
+// isHex reports whether s is a non-empty hex string
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorize holds mining.authorize params
+type Authorize struct {
+	Worker   string
+	Password string // empty when not sent
+}
+
+// ParseAuthorize extracts the worker and optional password of an authorize
+func ParseAuthorize(params interface{}) (Authorize, error) {
+	arr, err := paramsArray(params, 1)
+	if err != nil {
+		return Authorize{}, err
+	}
+	var a Authorize
+	var ok bool
+	if a.Worker, ok = arr[0].(string); !ok {
+		return Authorize{}, paramsError("worker is not a string")
+	}
+	if len(arr) > 1 && arr[1] != nil {
+		if a.Password, ok = arr[1].(string); !ok {
+			return Authorize{}, paramsError("password is not a string")
+		}
+	}
+	return a, nil
+}
+
+// Submit holds mining.submit params
+type Submit struct {
+	Worker      string
+	JobID       string
+	Extranonce2 string
+	NTime       string
+	Nonce       string
+	VersionBits string // BIP310 rolled version, empty when not sent
+}
+
+// ParseSubmit extracts the five or six string params of a submit. Their
+// content is checked by Validate.
+func ParseSubmit(params interface{}) (Submit, error) {
+	arr, err := paramsArray(params, 5)
+	if err != nil {
+		return Submit{}, err
+	}
+	if len(arr) > 6 {
+		return Submit{}, paramsError("%d params, want at most 6", len(arr))
+	}
+	var fields [6]string
+	for i, v := range arr {
+		s, ok := v.(string)
+		if !ok {
+			return Submit{}, paramsError("param %d is not a string", i)
+		}
+		fields[i] = s
+	}
+	return Submit{
+		Worker:      fields[0],
+		JobID:       fields[1],
+		Extranonce2: fields[2],
+		NTime:       fields[3],
+		Nonce:       fields[4],
+		VersionBits: fields[5],
+	}, nil
+}
+
+// Validate checks for a job ID and hex extranonce2, ntime, nonce and
+// version bits
+func (s Submit) Validate() error {
+	if s.JobID == "" {
+		return paramsError("empty job id")
+	}
+	for i, v := range []string{s.Extranonce2, s.NTime, s.Nonce} {
+		if !isHex(v) {
+			return paramsError("param %d %q is not hex", i+2, v)
+		}
+	}
+	if s.VersionBits != "" && !isHex(s.VersionBits) {
+		return paramsError("version bits %q are not hex", s.VersionBits)
+	}
+	return nil
+}
+
+// Params returns the submit as message params
+func (s Submit) Params() []interface{} {
+	params := []interface{}{s.Worker, s.JobID, s.Extranonce2, s.NTime, s.Nonce}
+	if s.VersionBits != "" {
+		params = append(params, s.VersionBits)
+	}
+	return params
+}
+
+// SetDifficulty holds mining.set_difficulty params
+type SetDifficulty struct {
+	Difficulty float64
+}
+
+// ParseSetDifficulty extracts a positive, finite difficulty
+func ParseSetDifficulty(params interface{}) (SetDifficulty, error) {
+	arr, err := paramsArray(params, 1)
+	if err != nil {
+		return SetDifficulty{}, err
+	}
+	d, ok := arr[0].(float64)
+	if !ok {
+		return SetDifficulty{}, paramsError("difficulty is not a number")
+	}
+	if !(d > 0) || math.IsInf(d, 1) {
+		return SetDifficulty{}, paramsError("difficulty %v is not positive", d)
+	}
+	return SetDifficulty{Difficulty: d}, nil
+}
+
+// Params returns the difficulty as message params
+func (s SetDifficulty) Params() []interface{} {
+	return []interface{}{s.Difficulty}
+}
+
+// Validate checks that the job fields are hex of the sizes a header needs:
+// a 32-byte prevhash and merkle branches, and 4-byte version, nbits and
+// ntime
+func (j Job) Validate() error {
+	if j.ID == "" {
+		return paramsError("empty job id")
+	}
+	if len(j.PrevHash) != 64 || !isHex(j.PrevHash) {
+		return paramsError("prevhash %q is not 32 bytes of hex", j.PrevHash)
+	}
+	for _, v := range []string{j.Coinbase1, j.Coinbase2} {
+		if v != "" && !isHex(v) || len(v)%2 != 0 {
+			return paramsError("coinbase %q is not hex", v)
+		}
+	}
+	for _, h := range j.MerkleBranch {
+		if len(h) != 64 || !isHex(h) {
+			return paramsError("merkle branch %q is not 32 bytes of hex", h)
+		}
+	}
+	for i, v := range []string{j.Version, j.NBits, j.NTime} {
+		if len(v) != 8 || !isHex(v) {
+			return paramsError("param %d %q is not 4 bytes of hex", i+5, v)
+		}
+	}
+	return nil
+}
+
+// Params returns the job as mining.notify params
+func (j Job) Params() []interface{} {
+	branch := make([]interface{}, len(j.MerkleBranch))
+	for i, h := range j.MerkleBranch {
+		branch[i] = h
+	}
+	return []interface{}{j.ID, j.PrevHash, j.Coinbase1, j.Coinbase2, branch, j.Version, j.NBits, j.NTime, j.Clean}
+}
+
+// Configure holds mining.configure params: the extensions a miner asks for
+// and their parameters, keyed "<extension>.<name>"
+type Configure struct {
+	Extensions []string
+	Params     map[string]interface{}
+}
+
+// ParseConfigure extracts the extension list and optional parameter object
+// of a configure
+func ParseConfigure(params interface{}) (Configure, error) {
+	arr, err := paramsArray(params, 1)
+	if err != nil {
+		return Configure{}, err
+	}
+	exts, ok := arr[0].([]interface{})
+	if !ok {
+		return Configure{}, paramsError("extensions are not an array")
+	}
+	c := Configure{Extensions: make([]string, 0, len(exts)), Params: map[string]interface{}{}}
+	for _, e := range exts {
+		s, ok := e.(string)
+		if !ok || s == "" {
+			return Configure{}, paramsError("extension %v is not a name", e)
+		}
+		c.Extensions = append(c.Extensions, s)
+	}
+	if len(arr) > 1 && arr[1] != nil {
+		if c.Params, ok = arr[1].(map[string]interface{}); !ok {
+			return Configure{}, paramsError("extension params are not an object")
+		}
+	}
+	for k := range c.Params {
+		ext, _, ok := strings.Cut(k, ".")
+		if !ok || !c.Has(ext) {
+			return Configure{}, paramsError("param %q is not for a requested extension", k)
+		}
+	}
+	return c, nil
+}
+
+// Has reports whether the miner asked for extension
+func (c Configure) Has(extension string) bool {
+	for _, e := range c.Extensions {
+		if e == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionRollingMask returns the version-rolling mask the miner asked for;
+// false when it did not ask for version rolling or sent no valid mask
+func (c Configure) VersionRollingMask() (uint32, bool) {
+	if !c.Has("version-rolling") {
+		return 0, false
+	}
+	s, _ := c.Params["version-rolling.mask"].(string)
+	if len(s) > 8 || !isHex(s) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	return uint32(v), err == nil
+}
@@ -0,0 +1,179 @@
+package stratum
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubmit(t *testing.T) {
+	s, err := ParseSubmit([]interface{}{"w", "j1", "0000abcd", "495fab29", "7c2bac1d", "00002000"})
+	if err != nil || s.JobID != "j1" || s.VersionBits != "00002000" || s.Validate() != nil {
+		t.Fatalf("ParseSubmit = %+v, %v", s, err)
+	}
+	if got := s.Params(); len(got) != 6 || got[5] != "00002000" {
+		t.Errorf("Params = %v", got)
+	}
+
+	for _, params := range []interface{}{
+		nil,
+		"w",
+		[]interface{}{"w", "j1", "00", "495fab29"},
+		[]interface{}{"w", "j1", "00", "495fab29", 1.0},
+		[]interface{}{"w", "j1", "00", "495fab29", "7c2bac1d", "00", "extra"},
+	} {
+		if _, err := ParseSubmit(params); !errors.Is(err, ErrParams) {
+			t.Errorf("ParseSubmit(%v) err = %v", params, err)
+		}
+	}
+	for _, s := range []Submit{
+		{JobID: "", Extranonce2: "00", NTime: "495fab29", Nonce: "7c2bac1d"},
+		{JobID: "j", Extranonce2: "zz", NTime: "495fab29", Nonce: "7c2bac1d"},
+		{JobID: "j", Extranonce2: "00", NTime: "", Nonce: "7c2bac1d"},
+		{JobID: "j", Extranonce2: "00", NTime: "495fab29", Nonce: "7c2bac1d", VersionBits: "x"},
+	} {
+		if s.Validate() == nil {
+			t.Errorf("%+v validated", s)
+		}
+	}
+}
+
+func TestParseSetDifficulty(t *testing.T) {
+	if sd, err := ParseSetDifficulty([]interface{}{512.0}); err != nil || sd.Difficulty != 512 {
+		t.Errorf("ParseSetDifficulty = %+v, %v", sd, err)
+	}
+	for _, params := range []interface{}{[]interface{}{}, []interface{}{"512"}, []interface{}{0.0}, []interface{}{-1.0}} {
+		if _, err := ParseSetDifficulty(params); err == nil {
+			t.Errorf("ParseSetDifficulty(%v) accepted", params)
+		}
+	}
+}
+
+func TestParseConfigure(t *testing.T) {
+	c, err := ParseConfigure([]interface{}{
+		[]interface{}{"version-rolling", "minimum-difficulty"},
+		map[string]interface{}{"version-rolling.mask": "1fffe000", "minimum-difficulty.value": 2048.0},
+	})
+	if err != nil || !c.Has("minimum-difficulty") {
+		t.Fatalf("ParseConfigure = %+v, %v", c, err)
+	}
+	if mask, ok := c.VersionRollingMask(); !ok || mask != VersionRollingMask {
+		t.Errorf("mask = %08x, %v", mask, ok)
+	}
+	if c, err := ParseConfigure([]interface{}{[]interface{}{"version-rolling"}}); err != nil || len(c.Params) != 0 {
+		t.Errorf("configure without params = %+v, %v", c, err)
+	} else if _, ok := c.VersionRollingMask(); ok {
+		t.Error("mask reported without one")
+	}
+	for _, params := range []interface{}{
+		[]interface{}{"version-rolling"},
+		[]interface{}{[]interface{}{1.0}},
+		[]interface{}{[]interface{}{"version-rolling"}, "mask"},
+		[]interface{}{[]interface{}{"version-rolling"}, map[string]interface{}{"subscribe-extranonce.x": true}},
+	} {
+		if _, err := ParseConfigure(params); err == nil {
+			t.Errorf("ParseConfigure(%v) accepted", params)
+		}
+	}
+}
+
+func TestJobValidate(t *testing.T) {
+	job := Job{
+		ID:           "1",
+		PrevHash:     strings.Repeat("0", 64),
+		Coinbase1:    "01000000",
+		Coinbase2:    "ffffffff",
+		MerkleBranch: []string{strings.Repeat("ab", 32)},
+		Version:      "20000000",
+		NBits:        "1d00ffff",
+		NTime:        "495fab29",
+	}
+	if err := job.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	back, ok := ParseNotify(job.Params())
+	if !ok || !reflect.DeepEqual(back, job) {
+		t.Errorf("round trip = %+v, %v", back, ok)
+	}
+	bad := job
+	bad.PrevHash = "00"
+	if bad.Validate() == nil {
+		t.Error("short prevhash validated")
+	}
+	bad = job
+	bad.NBits = "1d00fff"
+	if bad.Validate() == nil {
+		t.Error("short nbits validated")
+	}
+}
+
+// fuzzSeeds are messages for the fuzz targets to start from
+var fuzzSeeds = []string{
+	`{"id":1,"method":"mining.submit","params":["w","j1","0000abcd","495fab29","7c2bac1d"]}`,
+	`{"id":2,"method":"mining.submit","params":["w","j1","0000abcd","495fab29","7c2bac1d","00002000"]}`,
+	`{"id":3,"method":"mining.authorize","params":["w","x"]}`,
+	`{"id":4,"method":"mining.configure","params":[["version-rolling"],{"version-rolling.mask":"1fffe000"}]}`,
+	`{"id":null,"method":"mining.set_difficulty","params":[1024]}`,
+	`{"id":null,"method":"mining.notify","params":["1","` + strings.Repeat("0", 64) + `","01","ff",[],"20000000","1d00ffff","495fab29",true]}`,
+	`{"id":5,"method":"mining.submit","params":{}}`,
+}
+
+// FuzzParams feeds arbitrary messages to every params parser and checks that
+// what parses survives a round trip through its params
+func FuzzParams(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg Message
+		if json.Unmarshal(data, &msg) != nil {
+			return
+		}
+		_, _ = ParseAuthorize(msg.Params)
+		if c, err := ParseConfigure(msg.Params); err == nil {
+			_, _ = c.VersionRollingMask()
+		}
+		if s, err := ParseSubmit(msg.Params); err == nil {
+			if back, err := ParseSubmit(s.Params()); err != nil || back != s {
+				t.Fatalf("submit round trip %+v -> %+v, %v", s, back, err)
+			}
+		}
+		if sd, err := ParseSetDifficulty(msg.Params); err == nil {
+			if back, err := ParseSetDifficulty(sd.Params()); err != nil || back != sd {
+				t.Fatalf("set_difficulty round trip %+v -> %+v, %v", sd, back, err)
+			}
+		}
+		if job, ok := ParseNotify(msg.Params); ok {
+			back, ok := ParseNotify(job.Params())
+			if !ok || !reflect.DeepEqual(back, job) {
+				t.Fatalf("notify round trip %+v -> %+v", job, back)
+			}
+			if job.Validate() == nil {
+				if _, err := SHA256d.ShareDifficulty(job, "", "00", job.NTime, "00000000", ""); err != nil && !strings.Contains(err.Error(), "hash") {
+					t.Fatalf("valid job %+v: %v", job, err)
+				}
+			}
+		}
+	})
+}
+
+// FuzzMessage checks that decoding arbitrary lines never panics and that a
+// decoded message encodes again
+func FuzzMessage(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg Message
+		if msg.Unmarshal(data) != nil {
+			return
+		}
+		if _, err := msg.Marshal(); err != nil {
+			t.Fatalf("Marshal %q: %v", data, err)
+		}
+		_, _ = ParseError(msg.Error)
+		_ = ParseExtranonceResult(msg.Result)
+	})
+}
@@ -0,0 +1,227 @@
+package stratum
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// MarshalRequest encodes a request with its newline, like Marshal. A submit
+// that kept the line it was decoded from is encoded by patching the ID,
+// worker and extranonce2 into that line, without marshalling its params
+// again; when the line does not match the params any more it is marshalled.
+func (m *Message) MarshalRequest() ([]byte, error) {
+	if m.Raw != "" && m.Method == MethodSubmit {
+		if s, err := ParseSubmit(m.Params); err == nil {
+			if b, ok := PatchSubmit(nil, m.Raw, m.ID, s); ok {
+				return b, nil
+			}
+		}
+	}
+	return m.Marshal()
+}
+
+// PatchSubmit appends to dst the mining.submit request line with id, the
+// worker and the extranonce2 of s in place of those in line, followed by a
+// newline. Every other member of line is copied as is. line must be valid
+// JSON, as it is once decoded. It reports false when line cannot be patched:
+// it is not an object with one id and one params array, or its other params
+// differ from those of s.
+func PatchSubmit(dst []byte, line string, id *ID, s Submit) ([]byte, bool) {
+	i := skipSpace(line, 0)
+	if i >= len(line) || line[i] != '{' {
+		return dst, false
+	}
+	// room for the line and what the patch may add, so it grows once
+	dst = slices.Grow(dst, len(line)+len(s.Worker)+len(s.Extranonce2)+24)
+	dst = append(dst, '{')
+	i++
+	var haveID, haveParams bool
+	for n := 0; ; n++ {
+		i = skipSpace(line, i)
+		if i < len(line) && line[i] == '}' {
+			i++
+			break
+		}
+		if n > 0 {
+			if i >= len(line) || line[i] != ',' {
+				return dst, false
+			}
+			i = skipSpace(line, i+1)
+		}
+		end, ok := skipString(line, i)
+		if !ok {
+			return dst, false
+		}
+		key := line[i:end]
+		i = skipSpace(line, end)
+		if i >= len(line) || line[i] != ':' {
+			return dst, false
+		}
+		i = skipSpace(line, i+1)
+		end, ok = skipValue(line, i)
+		if !ok {
+			return dst, false
+		}
+		value := line[i:end]
+		i = end
+
+		// the decoder matches keys without regard to case or escapes;
+		// only the plain spelling is patched
+		name := key[1 : len(key)-1]
+		if strings.Contains(name, `\`) {
+			return dst, false
+		}
+		if n > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, key...)
+		dst = append(dst, ':')
+		switch {
+		case name == "id" && !haveID:
+			haveID = true
+			dst = append(dst, id.String()...)
+		case name == "params" && !haveParams:
+			haveParams = true
+			if dst, ok = patchSubmitParams(dst, value, s); !ok {
+				return dst, false
+			}
+		case name == "method":
+			dst = append(dst, value...)
+		case strings.EqualFold(name, "id"), strings.EqualFold(name, "params"), strings.EqualFold(name, "method"):
+			return dst, false
+		default:
+			dst = append(dst, value...)
+		}
+	}
+	if !haveID || !haveParams || skipSpace(line, i) != len(line) {
+		return dst, false
+	}
+	return append(dst, '}', '\n'), true
+}
+
+// patchSubmitParams appends the params array of a submit with the worker
+// and extranonce2 of s, checking the other params against s
+func patchSubmitParams(dst []byte, value string, s Submit) ([]byte, bool) {
+	if value == "" || value[0] != '[' {
+		return dst, false
+	}
+	want := [6]string{s.Worker, s.JobID, s.Extranonce2, s.NTime, s.Nonce, s.VersionBits}
+	count := 5
+	if s.VersionBits != "" {
+		count = 6
+	}
+	dst = append(dst, '[')
+	i := 1
+	for n := 0; ; n++ {
+		i = skipSpace(value, i)
+		if i < len(value) && value[i] == ']' {
+			return append(dst, ']'), n == count
+		}
+		if n > 0 {
+			if i >= len(value) || value[i] != ',' {
+				return dst, false
+			}
+			i = skipSpace(value, i+1)
+			dst = append(dst, ',')
+		}
+		end, ok := skipString(value, i)
+		if !ok || n >= count {
+			return dst, false
+		}
+		switch n {
+		case 0, 2:
+			dst = appendString(dst, want[n])
+		default:
+			if !isPlain(want[n]) || value[i+1:end-1] != want[n] {
+				return dst, false
+			}
+			dst = append(dst, value[i:end]...)
+		}
+		i = end
+	}
+}
+
+// skipSpace returns the index of the first non-space byte of s from i
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\r' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// skipString returns the end of the JSON string starting at i
+func skipString(s string, i int) (int, bool) {
+	if i >= len(s) || s[i] != '"' {
+		return i, false
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// skipValue returns the end of the JSON value starting at i
+func skipValue(s string, i int) (int, bool) {
+	if i >= len(s) {
+		return i, false
+	}
+	switch s[i] {
+	case '"':
+		return skipString(s, i)
+	case '{', '[':
+		depth := 0
+		for i < len(s) {
+			switch s[i] {
+			case '"':
+				end, ok := skipString(s, i)
+				if !ok {
+					return end, false
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+			i++
+		}
+		return i, false
+	default:
+		j := i
+		for j < len(s) && !strings.ContainsRune(",}] \t\r\n", rune(s[j])) {
+			j++
+		}
+		return j, j > i
+	}
+}
+
+// isPlain reports whether s needs no escaping in a JSON string
+func isPlain(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// appendString appends s as a JSON string
+func appendString(dst []byte, s string) []byte {
+	if isPlain(s) {
+		dst = append(dst, '"')
+		dst = append(dst, s...)
+		return append(dst, '"')
+	}
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
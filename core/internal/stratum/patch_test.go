@@ -0,0 +1,68 @@
+package stratum
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPatchSubmit(t *testing.T) {
+	line := `{"id": "a1", "method":"mining.submit", "params":["rig1","j1","abcd","495fab29","7c2bac1d"], "jsonrpc":"2.0"}`
+	s := Submit{Worker: "pool.user", JobID: "j1", Extranonce2: "0000ABCD", NTime: "495fab29", Nonce: "7c2bac1d"}
+	b, ok := PatchSubmit(nil, line, NewID(42), s)
+	want := `{"id":42,"method":"mining.submit","params":["pool.user","j1","0000ABCD","495fab29","7c2bac1d"],"jsonrpc":"2.0"}` + "\n"
+	if !ok || string(b) != want {
+		t.Fatalf("PatchSubmit = %q, %v\nwant %q", b, ok, want)
+	}
+
+	for name, line := range map[string]string{
+		"other param changed": `{"id":1,"method":"mining.submit","params":["rig1","j2","abcd","495fab29","7c2bac1d"]}`,
+		"extra param":         `{"id":1,"method":"mining.submit","params":["rig1","j1","abcd","495fab29","7c2bac1d","00"]}`,
+		"no id":               `{"method":"mining.submit","params":["rig1","j1","abcd","495fab29","7c2bac1d"]}`,
+		"two ids":             `{"id":1,"ID":2,"method":"mining.submit","params":["rig1","j1","abcd","495fab29","7c2bac1d"]}`,
+		"escaped key":         `{"\u0069d":1,"method":"mining.submit","params":["rig1","j1","abcd","495fab29","7c2bac1d"]}`,
+		"not an object":       `["rig1"]`,
+	} {
+		if b, ok := PatchSubmit(nil, line, NewID(1), s); ok {
+			t.Errorf("%s: patched to %q", name, b)
+		}
+	}
+
+	// a message whose line cannot be patched is marshalled instead
+	msg := Message{ID: NewID(7), Method: MethodSubmit, Params: s.Params(), Raw: `{"id":1}`}
+	b, err := msg.MarshalRequest()
+	var back Message
+	if err != nil || json.Unmarshal(b, &back) != nil || back.ID.String() != "7" {
+		t.Errorf("MarshalRequest = %q, %v", b, err)
+	}
+}
+
+// FuzzPatchSubmit checks that a patched line decodes to the submit it was
+// patched with and keeps the method of the original
+func FuzzPatchSubmit(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		var msg Message
+		if json.Unmarshal([]byte(line), &msg) != nil {
+			return
+		}
+		s, err := ParseSubmit(msg.Params)
+		if err != nil {
+			return
+		}
+		s.Worker, s.Extranonce2 = "pool.user", "0000abcd"
+		b, ok := PatchSubmit(nil, line, NewID(9), s)
+		if !ok {
+			return
+		}
+		var back Message
+		if err := json.Unmarshal(b, &back); err != nil {
+			t.Fatalf("patched %q to invalid %q: %v", line, b, err)
+		}
+		got, err := ParseSubmit(back.Params)
+		if err != nil || got != s || back.ID.String() != "9" || back.Method != msg.Method {
+			t.Fatalf("patched %q to %q: %+v, %v", line, b, got, err)
+		}
+	})
+}
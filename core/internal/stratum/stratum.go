@@ -3,6 +3,7 @@ package stratum
 
 import (
 	"encoding/json"
+	"math"
 	"math/big"
 	"net"
 	"strconv"
@@ -12,11 +13,15 @@ import (
 
 // Message represents a Stratum V1 JSON message
 type Message struct {
-	ID     *int64      `json:"id,omitempty"`
+	ID     *ID         `json:"id,omitempty"`
 	Method string      `json:"method,omitempty"`
 	Params interface{} `json:"params,omitempty"`
 	Result interface{} `json:"result,omitempty"`
 	Error  interface{} `json:"error,omitempty"`
+
+	// Raw is the client line a submit was decoded from, kept so it can be
+	// forwarded by patching (see MarshalRequest); empty for other messages
+	Raw string `json:"-"`
 }
 
 // ExtranonceInfo contains extranonce information from mining.subscribe response
@@ -83,6 +88,25 @@ func ParseExtranonceSize(v interface{}) (int, bool) {
 	}
 }
 
+// PasswordDifficulty extracts the difficulty a miner requests with the d=N
+// convention in its password, e.g. "x,d=4096" or "d=512;mode=solo"
+func PasswordDifficulty(pass string) (float64, bool) {
+	fields := strings.FieldsFunc(pass, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '&'
+	})
+	for _, f := range fields {
+		if len(f) < 3 || (f[0] != 'd' && f[0] != 'D') || f[1] != '=' {
+			continue
+		}
+		d, err := strconv.ParseFloat(f[2:], 64)
+		if err != nil || !(d > 0) || math.IsInf(d, 1) {
+			return 0, false
+		}
+		return d, true
+	}
+	return 0, false
+}
+
 // FormatDuration formats a duration for logging, returns "-" for non-positive values
 func FormatDuration(d time.Duration) string {
 	if d <= 0 {
@@ -92,41 +116,50 @@ func FormatDuration(d time.Duration) string {
 	return d.String()
 }
 
-// DiffFromBits converts mining difficulty bits to decimal difficulty
-func DiffFromBits(bits string) float64 {
+// BitsTarget expands compact nBits into the target a block hash must not
+// exceed, or nil when bits is invalid
+func BitsTarget(bits string) *big.Int {
 	bits = strings.TrimPrefix(bits, "0x")
 	if bits == "" {
-		return 0
+		return nil
 	}
 	val, err := strconv.ParseUint(bits, 16, 32)
 	if err != nil {
-		return 0
+		return nil
 	}
 	exponent := byte(val >> 24)
 	mantissa := val & 0xFFFFFF
 	if mantissa == 0 || exponent <= 3 {
-		return 0
+		return nil
 	}
-	target := new(big.Int).Lsh(big.NewInt(int64(mantissa)), uint(8*(int(exponent)-3)))
-	if target.Sign() <= 0 {
+	return new(big.Int).Lsh(big.NewInt(int64(mantissa)), uint(8*(int(exponent)-3)))
+}
+
+// DiffFromBits converts mining difficulty bits to decimal difficulty. Coin
+// daemons count network difficulty from the Bitcoin difficulty-1 target
+// whatever the proof of work, so this holds for every algorithm.
+func DiffFromBits(bits string) float64 {
+	return NetworkDifficulty(BitsTarget(bits))
+}
+
+// NetworkDifficulty returns the network difficulty of a block target, or 0
+// for a nil or zero target
+func NetworkDifficulty(target *big.Int) float64 {
+	if target == nil || target.Sign() <= 0 {
 		return 0
 	}
-	diffOne := new(big.Int).Lsh(big.NewInt(0xFFFF), uint(8*(0x1d-3)))
-	t := new(big.Float).SetInt(target)
-	d := new(big.Float).SetInt(diffOne)
-	res := new(big.Float).Quo(d, t)
+	res := new(big.Float).Quo(new(big.Float).SetInt(DiffOneTarget), new(big.Float).SetInt(target))
 	out, _ := res.Float64()
 	return out
 }
 
-// CopyID creates a deep copy of an int64 pointer
-func CopyID(id *int64) *int64 {
+// CopyID creates a copy of an ID
+func CopyID(id *ID) *ID {
 	if id == nil {
 		return nil
 	}
-	dup := new(int64)
-	*dup = *id
-	return dup
+	dup := *id
+	return &dup
 }
 
 // ParseURL parses a Stratum URL into host and port components
@@ -155,6 +188,8 @@ const (
 	MethodSetDifficulty = "mining.set_difficulty"
 	MethodNotify        = "mining.notify"
 	MethodConfigure     = "mining.configure"
+
+	MethodSuggestDifficulty = "mining.suggest_difficulty"
 )
 
 // NewSubscribeMessage creates a new mining.subscribe message
@@ -208,15 +243,101 @@ func NewNotifyMessage(jobID, prevHash, coinbase1, coinbase2 []string, merkleBran
 }
 
 // NewErrorResponse creates a new error response
-func NewErrorResponse(id *int64, code int, message string, details interface{}) Message {
+func NewErrorResponse(id *ID, code int, message string, details interface{}) Message {
 	return Message{
 		ID:    id,
 		Error: []interface{}{code, message, details},
 	}
 }
 
+// Error is a Stratum error with the code reported back to the miner
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ParseError extracts the code and message from a response error field,
+// accepting both the [code, message, data] array and the object form
+func ParseError(v interface{}) (int, string) {
+	switch e := v.(type) {
+	case []interface{}:
+		var code int
+		var msg string
+		if len(e) > 0 {
+			if f, ok := e[0].(float64); ok {
+				code = int(f)
+			}
+		}
+		if len(e) > 1 {
+			msg, _ = e[1].(string)
+		}
+		return code, msg
+	case map[string]interface{}:
+		var code int
+		if f, ok := e["code"].(float64); ok {
+			code = int(f)
+		}
+		msg, _ := e["message"].(string)
+		return code, msg
+	case string:
+		return 0, e
+	default:
+		return 0, ""
+	}
+}
+
+// Canonical share reject categories
+const (
+	RejectStale         = "stale"
+	RejectLowDifficulty = "low_difficulty"
+	RejectDuplicate     = "duplicate"
+	RejectUnauthorized  = "unauthorized"
+	RejectInvalid       = "invalid"
+	RejectOther         = "other"
+)
+
+// RejectCategories lists every reject category in display order
+var RejectCategories = []string{RejectStale, RejectLowDifficulty, RejectDuplicate, RejectUnauthorized, RejectInvalid, RejectOther}
+
+// ClassifyReject maps a pool's submit error to a canonical category. The
+// message wins over the code since pools disagree on code numbering.
+func ClassifyReject(code int, message string) string {
+	m := strings.ToLower(message)
+	switch {
+	case strings.Contains(m, "job not found"), strings.Contains(m, "stale"),
+		strings.Contains(m, "expired"), strings.Contains(m, "old job"):
+		return RejectStale
+	case strings.Contains(m, "low diff"), strings.Contains(m, "above target"),
+		strings.Contains(m, "high-hash"), strings.Contains(m, "difficulty"):
+		return RejectLowDifficulty
+	case strings.Contains(m, "duplicate"):
+		return RejectDuplicate
+	case strings.Contains(m, "unauthori"), strings.Contains(m, "not authori"),
+		strings.Contains(m, "not subscribed"):
+		return RejectUnauthorized
+	case strings.Contains(m, "invalid"), strings.Contains(m, "malformed"),
+		strings.Contains(m, "ntime"), strings.Contains(m, "nonce"), strings.Contains(m, "bad "):
+		return RejectInvalid
+	}
+	switch code {
+	case 21:
+		return RejectStale
+	case 22:
+		return RejectDuplicate
+	case 23:
+		return RejectLowDifficulty
+	case 24, 25:
+		return RejectUnauthorized
+	}
+	return RejectOther
+}
+
 // NewSuccessResponse creates a new success response
-func NewSuccessResponse(id *int64, result interface{}) Message {
+func NewSuccessResponse(id *ID, result interface{}) Message {
 	return Message{
 		ID:     id,
 		Result: result,
@@ -238,6 +359,19 @@ func (m *Message) IsResponse() bool {
 	return m.ID != nil && (m.Result != nil || m.Error != nil)
 }
 
+// MarshalJSON omits the id of requests and notifications without one, but
+// always writes it in responses, as null when the request had none
+func (m Message) MarshalJSON() ([]byte, error) {
+	type wire Message
+	if m.ID == nil && m.Method == "" {
+		return json.Marshal(struct {
+			ID *ID `json:"id"`
+			wire
+		}{nil, wire(m)})
+	}
+	return json.Marshal(wire(m))
+}
+
 // Marshal implements json.Marshaler with newline for Stratum protocol
 func (m *Message) Marshal() ([]byte, error) {
 	data, err := json.Marshal(m)
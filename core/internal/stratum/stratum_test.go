@@ -1,6 +1,9 @@
 package stratum
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -282,8 +285,8 @@ func TestDiffFromBits(t *testing.T) {
 func TestCopyID(t *testing.T) {
 	tests := []struct {
 		name string
-		id   *int64
-		want *int64
+		id   *ID
+		want *ID
 	}{
 		{
 			name: "nil id",
@@ -292,8 +295,8 @@ func TestCopyID(t *testing.T) {
 		},
 		{
 			name: "valid id",
-			id:   func() *int64 { i := int64(42); return &i }(),
-			want: func() *int64 { i := int64(42); return &i }(),
+			id:   NewID(42),
+			want: NewID(42),
 		},
 	}
 
@@ -419,8 +422,8 @@ func TestMessageClassification(t *testing.T) {
 	}
 
 	// Test request (has ID and Method)
-	id := int64(1)
-	request := Message{ID: &id, Method: MethodSubscribe}
+	id := NewID(1)
+	request := Message{ID: id, Method: MethodSubscribe}
 	if !request.IsRequest() {
 		t.Error("Expected request to be classified as request")
 	}
@@ -429,7 +432,7 @@ func TestMessageClassification(t *testing.T) {
 	}
 
 	// Test response (has ID and Result)
-	response := Message{ID: &id, Result: true}
+	response := Message{ID: id, Result: true}
 	if !response.IsResponse() {
 		t.Error("Expected response to be classified as response")
 	}
@@ -437,3 +440,149 @@ func TestMessageClassification(t *testing.T) {
 		t.Error("Response should not be classified as notification or request")
 	}
 }
+
+func TestClassifyReject(t *testing.T) {
+	tests := []struct {
+		code int
+		msg  string
+		want string
+	}{
+		{21, "Job not found", RejectStale},
+		{0, "Stale share", RejectStale},
+		{23, "Low difficulty share", RejectLowDifficulty},
+		{0, "high-hash", RejectLowDifficulty},
+		{22, "Duplicate share", RejectDuplicate},
+		{24, "Unauthorized worker", RejectUnauthorized},
+		{0, "Invalid nonce", RejectInvalid},
+		{21, "", RejectStale},
+		{20, "Other/Unknown", RejectOther},
+		{0, "", RejectOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyReject(tt.code, tt.msg); got != tt.want {
+			t.Errorf("ClassifyReject(%d, %q) = %s, want %s", tt.code, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	code, msg := ParseError([]interface{}{float64(21), "Job not found", nil})
+	if code != 21 || msg != "Job not found" {
+		t.Errorf("array form = %d %q", code, msg)
+	}
+	code, msg = ParseError(map[string]interface{}{"code": float64(23), "message": "Low difficulty"})
+	if code != 23 || msg != "Low difficulty" {
+		t.Errorf("object form = %d %q", code, msg)
+	}
+	if code, msg = ParseError(nil); code != 0 || msg != "" {
+		t.Errorf("nil = %d %q", code, msg)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	f := NewFrameString(`{"method":"mining.notify"}`)
+	if got := string(f.Bytes()); got != "{\"method\":\"mining.notify\"}\n" {
+		t.Fatalf("frame = %q", got)
+	}
+	f.Retain()
+	f.Release()
+	if got := string(f.Bytes()); got != "{\"method\":\"mining.notify\"}\n" {
+		t.Errorf("frame changed while still referenced: %q", got)
+	}
+	f.Release()
+
+	g := NewFrame([]byte("x"))
+	defer g.Release()
+	if string(g.Bytes()) != "x\n" {
+		t.Errorf("reused frame = %q", g.Bytes())
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+	tests := []struct {
+		line  string
+		id    string // ID as JSON text, "null" when absent
+		n     int64
+		isInt bool
+	}{
+		{`{"id":7,"method":"mining.subscribe"}`, "7", 7, true},
+		{`{"id":"sub-1","method":"mining.subscribe"}`, `"sub-1"`, 0, false},
+		{`{"id":1.5,"method":"mining.subscribe"}`, "1.5", 0, false},
+		{`{"id":null,"method":"mining.subscribe"}`, "null", 0, false},
+		{`{"method":"mining.subscribe"}`, "null", 0, false},
+	}
+	for _, tt := range tests {
+		var msg Message
+		if err := json.Unmarshal([]byte(tt.line), &msg); err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if got := msg.ID.String(); got != tt.id {
+			t.Errorf("%s: id = %s, want %s", tt.line, got, tt.id)
+		}
+		if n, ok := msg.ID.Int64(); n != tt.n || ok != tt.isInt {
+			t.Errorf("%s: Int64() = %d, %v", tt.line, n, ok)
+		}
+
+		// the reply carries the request's id unchanged
+		out, _ := json.Marshal(NewSuccessResponse(CopyID(msg.ID), true))
+		if want := `{"id":` + tt.id + `,"result":true}`; string(out) != want {
+			t.Errorf("%s: reply = %s, want %s", tt.line, out, want)
+		}
+	}
+
+	for _, line := range []string{`{"id":true}`, `{"id":{"a":1}}`, `{"id":[1]}`} {
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err == nil {
+			t.Errorf("%s: expected an error", line)
+		}
+	}
+
+	// notifications still go out without an id
+	out, _ := json.Marshal(NewSetDifficultyMessage(2))
+	if string(out) != `{"method":"mining.set_difficulty","params":[2]}` {
+		t.Errorf("notification = %s", out)
+	}
+	if s := NewStringID("a\"b").String(); s != `"a\"b"` {
+		t.Errorf("string id = %s", s)
+	}
+}
+
+func TestPasswordDifficulty(t *testing.T) {
+	tests := []struct {
+		pass string
+		want float64
+		ok   bool
+	}{
+		{"d=4096", 4096, true},
+		{"x,d=512", 512, true},
+		{"x; D=0.5", 0.5, true},
+		{"mode=solo&d=2e4", 20000, true},
+		{"x", 0, false},
+		{"d=", 0, false},
+		{"d=-5", 0, false},
+		{"d=abc", 0, false},
+		{"d=NaN", 0, false},
+		{"sd=100", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := PasswordDifficulty(tt.pass)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PasswordDifficulty(%q) = %v, %v; want %v, %v", tt.pass, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestErrorResponseFor(t *testing.T) {
+	id := NewID(7)
+	msg := ErrorResponseFor(id, ErrStaleJob)
+	if e := msg.Error.([]interface{}); e[0] != CodeJobNotFound || e[1] != "Stale job" {
+		t.Errorf("stale job answered %v", e)
+	}
+	if got := ErrorCode(fmt.Errorf("wrapped: %w", ErrLowDifficulty)); got != CodeLowDifficulty {
+		t.Errorf("wrapped code = %d, want %d", got, CodeLowDifficulty)
+	}
+	if e := ErrorResponseFor(id, errors.New("boom")).Error.([]interface{}); e[0] != CodeOther || e[1] != "boom" {
+		t.Errorf("plain error answered %v", e)
+	}
+}
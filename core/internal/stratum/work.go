@@ -0,0 +1,165 @@
+package stratum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// VersionRollingMask is the BIP320 mask of header version bits miners may roll
+const VersionRollingMask uint32 = 0x1fffe000
+
+// DiffOneTarget is the SHA-256d difficulty-1 target (0xFFFF * 2^208)
+var DiffOneTarget = new(big.Int).Lsh(big.NewInt(0xFFFF), 208)
+
+// Job holds the mining.notify fields needed to rebuild a share's header
+type Job struct {
+	ID           string
+	PrevHash     string // word-swapped, as sent in mining.notify
+	Coinbase1    string
+	Coinbase2    string
+	MerkleBranch []string
+	Version      string
+	NBits        string
+	NTime        string
+	Clean        bool
+}
+
+// ParseNotify extracts a Job from mining.notify params
+func ParseNotify(params interface{}) (Job, bool) {
+	arr, ok := params.([]interface{})
+	if !ok || len(arr) < 9 {
+		return Job{}, false
+	}
+	var fields [8]string
+	for _, i := range []int{0, 1, 2, 3, 5, 6, 7} {
+		if fields[i], ok = arr[i].(string); !ok {
+			return Job{}, false
+		}
+	}
+	rawBranch, ok := arr[4].([]interface{})
+	if !ok {
+		return Job{}, false
+	}
+	branch := make([]string, 0, len(rawBranch))
+	for _, b := range rawBranch {
+		s, ok := b.(string)
+		if !ok {
+			return Job{}, false
+		}
+		branch = append(branch, s)
+	}
+	job := Job{
+		ID:           fields[0],
+		PrevHash:     fields[1],
+		Coinbase1:    fields[2],
+		Coinbase2:    fields[3],
+		MerkleBranch: branch,
+		Version:      fields[5],
+		NBits:        fields[6],
+		NTime:        fields[7],
+	}
+	switch v := arr[8].(type) {
+	case bool:
+		job.Clean = v
+	case string:
+		job.Clean = strings.EqualFold(v, "true")
+	}
+	return job, true
+}
+
+// Notify returns the job as a mining.notify notification
+func (j Job) Notify() Message {
+	return Message{Method: MethodNotify, Params: j.Params()}
+}
+
+// MarshalNotify encodes the job as a mining.notify line without the
+// trailing newline. The encoding depends only on the job, so re-sending a
+// job always produces the same bytes.
+func (j Job) MarshalNotify() ([]byte, error) {
+	return json.Marshal(j.Notify())
+}
+
+// Header rebuilds the 80-byte block header of a submitted share. versionBits
+// is the optional BIP310 rolled version from the sixth submit parameter.
+func (j Job) Header(ex1, ex2, ntime, nonce, versionBits string) ([]byte, error) {
+	coinbase, err := hex.DecodeString(j.Coinbase1 + ex1 + ex2 + j.Coinbase2)
+	if err != nil {
+		return nil, fmt.Errorf("coinbase: %w", err)
+	}
+	root := doubleSHA256(coinbase)
+	for _, h := range j.MerkleBranch {
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != 32 {
+			return nil, errors.New("invalid merkle branch")
+		}
+		root = doubleSHA256(append(root, b...))
+	}
+
+	prev, err := hex.DecodeString(j.PrevHash)
+	if err != nil || len(prev) != 32 {
+		return nil, errors.New("invalid prevhash")
+	}
+	version := j.Version
+	if versionBits != "" {
+		if version, err = rollVersion(j.Version, versionBits); err != nil {
+			return nil, err
+		}
+	}
+
+	header := make([]byte, 0, 80)
+	if header, err = appendWord(header, "version", version); err != nil {
+		return nil, err
+	}
+	// each 4-byte word of the notify prevhash is byte-swapped
+	for i := 0; i < 32; i += 4 {
+		header = append(header, prev[i+3], prev[i+2], prev[i+1], prev[i])
+	}
+	header = append(header, root...)
+	for _, w := range []struct{ name, hex string }{{"ntime", ntime}, {"nbits", j.NBits}, {"nonce", nonce}} {
+		if header, err = appendWord(header, w.name, w.hex); err != nil {
+			return nil, err
+		}
+	}
+	if len(header) != 80 {
+		return nil, fmt.Errorf("header is %d bytes, want 80", len(header))
+	}
+
+	return header, nil
+}
+
+// appendWord appends a 4-byte big-endian hex header field in little-endian
+// order
+func appendWord(header []byte, name, field string) ([]byte, error) {
+	b, err := hex.DecodeString(field)
+	if err != nil || len(b) != 4 {
+		return nil, fmt.Errorf("invalid %s %q", name, field)
+	}
+	return append(header, b[3], b[2], b[1], b[0]), nil
+}
+
+// rollVersion applies miner-rolled version bits within the BIP320 mask
+func rollVersion(base, bits string) (string, error) {
+	var baseV, rolled uint32
+	if _, err := fmt.Sscanf(base, "%08x", &baseV); err != nil {
+		return "", err
+	}
+	if _, err := fmt.Sscanf(bits, "%x", &rolled); err != nil {
+		return "", err
+	}
+	if rolled&^VersionRollingMask != 0 {
+		return "", errors.New("version bits outside mask")
+	}
+	return fmt.Sprintf("%08x", (baseV&^VersionRollingMask)|rolled), nil
+}
+
+// doubleSHA256 returns SHA256(SHA256(b))
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
@@ -0,0 +1,137 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// genesisCoinbase is the coinbase transaction of the Bitcoin genesis block
+const genesisCoinbase = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestShareDifficultyGenesis(t *testing.T) {
+	params := []interface{}{
+		"genesis",
+		strings.Repeat("0", 64),
+		genesisCoinbase[:84],
+		genesisCoinbase[100:],
+		[]interface{}{},
+		"00000001",
+		"1d00ffff",
+		"495fab29",
+		true,
+	}
+	job, ok := ParseNotify(params)
+	if !ok || !job.Clean || job.ID != "genesis" {
+		t.Fatalf("ParseNotify = %+v, %v", job, ok)
+	}
+
+	// ex1 and ex2 split the original coinbase so the genesis header is rebuilt
+	diff, err := SHA256d.ShareDifficulty(job, "04ffff00", "1d010445", "495fab29", "7c2bac1d", "")
+	if err != nil {
+		t.Fatalf("ShareDifficulty: %v", err)
+	}
+	if math.Abs(diff-2536.4263) > 0.001 {
+		t.Errorf("genesis share difficulty = %v, want ~2536.4263", diff)
+	}
+
+	if diff, _ := SHA256d.ShareDifficulty(job, "04ffff00", "1d010445", "495fab29", "7c2bac1e", ""); diff > 1 {
+		t.Errorf("wrong nonce should not meet difficulty, got %v", diff)
+	}
+	if _, err := SHA256d.ShareDifficulty(job, "04ffff00", "1d010445", "495fab29", "7c2bac1d", "80000000"); err == nil {
+		t.Error("expected error for version bits outside the mask")
+	}
+	if _, ok := ParseNotify([]interface{}{"short"}); ok {
+		t.Error("ParseNotify should reject short params")
+	}
+}
+
+func TestHeaderRejectsBadWords(t *testing.T) {
+	job := Job{PrevHash: strings.Repeat("0", 64), Coinbase1: genesisCoinbase[:84], Coinbase2: genesisCoinbase[100:], Version: "00000001", NBits: "1d00ffff"}
+	for _, c := range [][2]string{{"prev", "root"}, {"root", "prev"}, {"495fab29", "root"}, {"prev", "7c2bac1d"}, {"495fab", "7c2bac1d"}, {"495fab29", "7c2bac1d00"}} {
+		if h, err := job.Header("04ffff00", "1d010445", c[0], c[1], ""); err == nil {
+			t.Errorf("ntime %q nonce %q: got a %d-byte header, want an error", c[0], c[1], len(h))
+		}
+	}
+	h, err := job.Header("04ffff00", "1d010445", "495fab29", "7c2bac1d", "")
+	if err != nil || len(h) != 80 {
+		t.Errorf("genesis header: %d bytes, %v", len(h), err)
+	}
+}
+
+// litecoinGenesis is the header of the Litecoin genesis block
+const litecoinGenesis = "010000000000000000000000000000000000000000000000000000000000000000000000d9ced4ed1130f7b7faad9be25323ffafa33232a17c3edf6cfd97bee6bafbdd97b9aa8e4ef0ff0f1ecd513f7c"
+
+func TestAlgorithms(t *testing.T) {
+	header, _ := hex.DecodeString(litecoinGenesis)
+	// litecoind's getdifficulty for the genesis bits
+	if network := DiffFromBits("1e0ffff0"); math.Abs(network-0.000244140625) > 1e-12 {
+		t.Errorf("network difficulty of 1e0ffff0 = %v, want ~0.000244", network)
+	}
+	target := BitsTarget("1e0ffff0")
+	if h := Scrypt.HeaderHash(header); h.Cmp(target) > 0 {
+		t.Errorf("litecoin genesis scrypt hash %x above its target", h)
+	}
+	if d, err := Scrypt.HeaderDifficulty(header); err != nil || d < 1 {
+		t.Errorf("litecoin genesis scrypt difficulty = %v, %v; want at least 1", d, err)
+	}
+	if d, _ := SHA256d.HeaderDifficulty(header); d >= 1 {
+		t.Errorf("litecoin genesis meets sha256d difficulty %v", d)
+	}
+	header[76]++
+	if h := Scrypt.HeaderHash(header); h.Cmp(target) <= 0 {
+		t.Errorf("wrong nonce meets the scrypt target: %x", h)
+	}
+
+	if Scrypt.Target(1).Cmp(new(big.Int).Lsh(SHA256d.Target(1), 16)) != 0 {
+		t.Error("scrypt difficulty 1 should be 2^16 times the sha256d target")
+	}
+	for name, want := range map[string]*Algorithm{"": SHA256d, "SHA256d": SHA256d, "scrypt": Scrypt} {
+		if a, err := AlgorithmByName(name); err != nil || a != want {
+			t.Errorf("AlgorithmByName(%q) = %v, %v", name, a, err)
+		}
+	}
+	if _, err := AlgorithmByName("x11"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+func TestBitsTarget(t *testing.T) {
+	if got := BitsTarget("1d00ffff"); got.Cmp(DiffOneTarget) != 0 {
+		t.Errorf("target of 1d00ffff = %x, want the difficulty-1 target", got)
+	}
+	if d := DiffFromBits("0x1b0404cb"); math.Abs(d-16307.42) > 0.01 {
+		t.Errorf("difficulty of 1b0404cb = %v, want ~16307.42", d)
+	}
+	for _, bad := range []string{"", "zz", "invalid", "03000001", "1d000000"} {
+		if got := BitsTarget(bad); got != nil {
+			t.Errorf("target of %q = %x, want nil", bad, got)
+		}
+	}
+}
+
+func TestMarshalNotify(t *testing.T) {
+	line := `{"id":null,"method":"mining.notify","params":["a1","` + strings.Repeat("0", 64) + `","01","ff",["` + strings.Repeat("ab", 32) + `"],"20000000","1d00ffff","495fab29","true"]}`
+	var msg Message
+	if err := msg.Unmarshal([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	job, ok := ParseNotify(msg.Params)
+	if !ok {
+		t.Fatal("notify not parsed")
+	}
+	b, err := job.MarshalNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"method":"mining.notify","params":["a1","` + strings.Repeat("0", 64) + `","01","ff",["` + strings.Repeat("ab", 32) + `"],"20000000","1d00ffff","495fab29",true]}`
+	if string(b) != want {
+		t.Errorf("MarshalNotify = %s\nwant %s", b, want)
+	}
+	again, _ := job.MarshalNotify()
+	if string(again) != string(b) {
+		t.Error("encoding not deterministic")
+	}
+}
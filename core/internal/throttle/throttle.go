@@ -0,0 +1,288 @@
+// Package throttle escalates against clients that flood or spoil shares
+package throttle
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Actions taken against a client, in escalation order
+const (
+	ActionNone     = ""
+	ActionEscalate = "escalate" // raise the client's difficulty
+	ActionMute     = "mute"     // refuse submits for MuteSeconds
+	ActionBan      = "ban"      // disconnect and ban for BanSeconds
+)
+
+// Ban targets
+const (
+	BanIP     = "ip"
+	BanWorker = "worker"
+)
+
+// Config holds share throttling configuration. A client breaching either
+// limit over a window moves one step along escalate, mute and ban; a window
+// without a breach starts it over.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// MaxSharesPerSecond is the submit rate allowed over a window; 0 disables
+	MaxSharesPerSecond float64 `json:"max_shares_per_second"`
+	// MaxInvalidPct is the rejected share of results allowed over a window
+	// once MinShares results are in; 0 disables
+	MaxInvalidPct    float64 `json:"max_invalid_pct"`
+	MinShares        int     `json:"min_shares"`        // default 20
+	WindowSeconds    int     `json:"window_seconds"`    // default 10
+	DifficultyFactor float64 `json:"difficulty_factor"` // default 2
+	MuteSeconds      int     `json:"mute_seconds"`      // default 60
+	BanSeconds       int     `json:"ban_seconds"`       // default 600; ban length
+	BanBy            string  `json:"ban_by"`            // "ip" (default) or "worker"
+}
+
+// Validate checks the limits and the ban target
+func (c *Config) Validate() error {
+	if c.MaxSharesPerSecond < 0 || c.MaxInvalidPct < 0 || c.MaxInvalidPct > 100 {
+		return fmt.Errorf("max_shares_per_second must be >= 0 and max_invalid_pct within 0-100")
+	}
+	if c.DifficultyFactor != 0 && c.DifficultyFactor <= 1 {
+		return fmt.Errorf("difficulty_factor must be greater than 1")
+	}
+	switch c.BanBy {
+	case "", BanIP, BanWorker:
+	default:
+		return fmt.Errorf("ban_by must be %q or %q", BanIP, BanWorker)
+	}
+	return nil
+}
+
+// window returns the span over which limits are measured
+func (c *Config) window() time.Duration {
+	if c.WindowSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.WindowSeconds) * time.Second
+}
+
+// minShares returns the results needed before the invalid rate is judged
+func (c *Config) minShares() int {
+	if c.MinShares <= 0 {
+		return 20
+	}
+	return c.MinShares
+}
+
+// Factor returns the difficulty multiplier applied on escalation
+func (c *Config) Factor() float64 {
+	if c.DifficultyFactor <= 1 {
+		return 2
+	}
+	return c.DifficultyFactor
+}
+
+// MuteDuration returns how long a muted client's submits are refused
+func (c *Config) MuteDuration() time.Duration {
+	if c.MuteSeconds <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.MuteSeconds) * time.Second
+}
+
+// BanDuration returns the length of a throttle ban
+func (c *Config) BanDuration() time.Duration {
+	if c.BanSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.BanSeconds) * time.Second
+}
+
+// BanTarget returns what a throttle ban applies to
+func (c *Config) BanTarget() string {
+	if c.BanBy == "" {
+		return BanIP
+	}
+	return c.BanBy
+}
+
+// Verdict is an action decided against a client and why
+type Verdict struct {
+	Action string
+	Reason string
+}
+
+// client is the state of one tracked connection
+type client struct {
+	start      time.Time // current window
+	submits    int
+	results    int
+	invalid    int
+	stage      int // actions taken so far in this run of breaches
+	mutedUntil time.Time
+}
+
+// Muted describes a client whose submits are currently refused
+type Muted struct {
+	Client string    `json:"client"`
+	Until  time.Time `json:"until"`
+}
+
+// Tracker measures submit and invalid rates per client
+type Tracker struct {
+	mu      sync.Mutex
+	cfg     *Config
+	clients map[string]*client
+	actions map[string]uint64
+}
+
+// New creates a new share throttle
+func New(cfg *Config) *Tracker {
+	return &Tracker{
+		cfg:     cfg,
+		clients: make(map[string]*client),
+		actions: make(map[string]uint64),
+	}
+}
+
+// UpdateConfig updates the throttle configuration
+func (t *Tracker) UpdateConfig(cfg *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+	if !cfg.Enabled {
+		t.clients = make(map[string]*client)
+	}
+}
+
+// Enabled reports whether throttling is active
+func (t *Tracker) Enabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg.Enabled
+}
+
+// get returns the state of key with its window rolled forward to now
+func (t *Tracker) get(key string, now time.Time) *client {
+	c, ok := t.clients[key]
+	if !ok {
+		c = &client{start: now}
+		t.clients[key] = c
+	}
+	if now.Sub(c.start) >= t.cfg.window() {
+		// a full window without a breach ends the escalation; time spent
+		// muted does not count, as refused submits cannot breach
+		if now.Sub(c.mutedUntil) >= t.cfg.window() {
+			c.stage = 0
+		}
+		c.start, c.submits, c.results, c.invalid = now, 0, 0, 0
+	}
+	return c
+}
+
+// Submit records a submit from key. A muted client gets ActionMute with an
+// empty reason and its submit should be refused; otherwise the verdict is
+// the action its submit rate triggered, if any.
+func (t *Tracker) Submit(key string, now time.Time) Verdict {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.cfg.Enabled {
+		return Verdict{}
+	}
+	c := t.get(key, now)
+	if now.Before(c.mutedUntil) {
+		return Verdict{Action: ActionMute}
+	}
+	c.submits++
+	limit := t.cfg.MaxSharesPerSecond
+	if limit <= 0 || float64(c.submits) <= limit*t.cfg.window().Seconds() {
+		return Verdict{}
+	}
+	reason := fmt.Sprintf("%d submits in %s exceed %.4g/s", c.submits, t.cfg.window(), limit)
+	return t.escalate(c, now, reason)
+}
+
+// Result records the outcome of a share from key and returns the action its
+// invalid rate triggered, if any
+func (t *Tracker) Result(key string, accepted bool, now time.Time) Verdict {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.cfg.Enabled {
+		return Verdict{}
+	}
+	c := t.get(key, now)
+	c.results++
+	if !accepted {
+		c.invalid++
+	}
+	limit := t.cfg.MaxInvalidPct
+	if limit <= 0 || c.results < t.cfg.minShares() {
+		return Verdict{}
+	}
+	pct := float64(c.invalid) * 100 / float64(c.results)
+	if pct < limit {
+		return Verdict{}
+	}
+	reason := fmt.Sprintf("%.1f%% of %d shares invalid exceeds %.4g%%", pct, c.results, limit)
+	return t.escalate(c, now, reason)
+}
+
+// escalate moves a breaching client to its next action and starts a fresh
+// window so the next step needs another breach
+func (t *Tracker) escalate(c *client, now time.Time, reason string) Verdict {
+	c.start, c.submits, c.results, c.invalid = now, 0, 0, 0
+	c.stage++
+	v := Verdict{Reason: reason}
+	switch c.stage {
+	case 1:
+		v.Action = ActionEscalate
+	case 2:
+		v.Action = ActionMute
+		c.mutedUntil = now.Add(t.cfg.MuteDuration())
+	default:
+		v.Action = ActionBan
+	}
+	t.actions[v.Action]++
+	return v
+}
+
+// Skip moves a client past an action that could not be applied, such as a
+// difficulty already at its maximum, and returns the next one
+func (t *Tracker) Skip(key string, v Verdict, now time.Time) Verdict {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.clients[key]
+	if !ok {
+		return Verdict{}
+	}
+	t.actions[v.Action]--
+	return t.escalate(c, now, v.Reason)
+}
+
+// Remove forgets a disconnected client
+func (t *Tracker) Remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.clients, key)
+}
+
+// GetStats returns the throttle view in /status
+func (t *Tracker) GetStats(now time.Time) map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	muted := []Muted{}
+	for key, c := range t.clients {
+		if now.Before(c.mutedUntil) {
+			muted = append(muted, Muted{Client: key, Until: c.mutedUntil})
+		}
+	}
+	sort.Slice(muted, func(i, j int) bool { return muted[i].Client < muted[j].Client })
+	actions := make(map[string]uint64, len(t.actions))
+	for k, v := range t.actions {
+		actions[k] = v
+	}
+	return map[string]interface{}{
+		"enabled": t.cfg.Enabled,
+		"tracked": len(t.clients),
+		"muted":   muted,
+		"actions": actions,
+	}
+}
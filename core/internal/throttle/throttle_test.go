@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubmitRateEscalation(t *testing.T) {
+	tr := New(&Config{Enabled: true, MaxSharesPerSecond: 1, WindowSeconds: 10, MuteSeconds: 30})
+	now := time.Unix(1000, 0)
+
+	breach := func() Verdict {
+		var v Verdict
+		for i := 0; i <= 10 && v.Action == ActionNone; i++ {
+			v = tr.Submit("a", now)
+		}
+		return v
+	}
+	if v := breach(); v.Action != ActionEscalate || v.Reason == "" {
+		t.Fatalf("first breach = %+v, want escalate", v)
+	}
+	if v := breach(); v.Action != ActionMute {
+		t.Fatalf("second breach = %+v, want mute", v)
+	}
+	if v := tr.Submit("a", now.Add(time.Second)); v.Action != ActionMute || v.Reason != "" {
+		t.Errorf("submit while muted = %+v, want a refused submit", v)
+	}
+
+	now = now.Add(31 * time.Second)
+	if v := breach(); v.Action != ActionBan {
+		t.Fatalf("breach after the mute = %+v, want ban", v)
+	}
+
+	// a clean window starts the escalation over
+	now = now.Add(21 * time.Second)
+	tr.Submit("a", now)
+	now = now.Add(11 * time.Second)
+	if v := breach(); v.Action != ActionEscalate {
+		t.Errorf("breach after a clean window = %+v, want escalate", v)
+	}
+}
+
+func TestInvalidRate(t *testing.T) {
+	tr := New(&Config{Enabled: true, MaxInvalidPct: 50, MinShares: 4})
+	now := time.Unix(1000, 0)
+
+	tr.Result("a", false, now)
+	tr.Result("a", false, now)
+	if v := tr.Result("a", false, now); v.Action != ActionNone {
+		t.Errorf("judged before min_shares: %+v", v)
+	}
+	if v := tr.Result("a", true, now); v.Action != ActionEscalate {
+		t.Errorf("75%% invalid = %+v, want escalate", v)
+	}
+	for i := 0; i < 8; i++ {
+		if v := tr.Result("b", i%4 != 0, now); v.Action != ActionNone {
+			t.Fatalf("25%% invalid triggered %+v", v)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	tr := New(&Config{Enabled: true, MaxSharesPerSecond: 0.1, WindowSeconds: 10})
+	now := time.Unix(1000, 0)
+	tr.Submit("a", now)
+	v := tr.Submit("a", now)
+	if v.Action != ActionEscalate {
+		t.Fatalf("breach = %+v, want escalate", v)
+	}
+	if v = tr.Skip("a", v, now); v.Action != ActionMute {
+		t.Errorf("skipped escalate = %+v, want mute", v)
+	}
+	actions := tr.GetStats(now)["actions"].(map[string]uint64)
+	if actions[ActionEscalate] != 0 || actions[ActionMute] != 1 {
+		t.Errorf("actions = %v, want only the mute counted", actions)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	tr := New(&Config{MaxSharesPerSecond: 0.1})
+	for i := 0; i < 10; i++ {
+		if v := tr.Submit("a", time.Unix(1000, 0)); v.Action != ActionNone {
+			t.Fatalf("disabled throttle acted: %+v", v)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []Config{
+		{MaxSharesPerSecond: -1},
+		{MaxInvalidPct: 101},
+		{DifficultyFactor: 0.5},
+		{BanBy: "subnet"},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v passed validation", c)
+		}
+	}
+	if err := (&Config{BanBy: BanWorker, DifficultyFactor: 4}).Validate(); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package tunnel compresses and batches the Stratum stream between two karoo
+// instances, for farms that backhaul over links where per-message overhead
+// dominates
+package tunnel
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Magic opens every tunnel, so a listener expecting one refuses plain
+// Stratum clients instead of feeding them compressed bytes
+const Magic = "KAROO-TUNNEL/1\n"
+
+// handshakeTimeout bounds the wait for the magic on accepted connections
+const handshakeTimeout = 10 * time.Second
+
+// Config holds the tunnel settings; both ends must enable it
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// BatchMs holds written lines this long so they share one compressed
+	// flush; 0 flushes every write
+	BatchMs int `json:"batch_ms"`
+	// Level is the flate level, 1 (fastest, default) to 9 (smallest)
+	Level int `json:"level"`
+}
+
+// Validate checks the batching window and compression level
+func (c Config) Validate() error {
+	if c.BatchMs < 0 {
+		return errors.New("batch_ms must not be negative")
+	}
+	if c.Level < 0 || c.Level > flate.BestCompression {
+		return fmt.Errorf("level must be between 1 and %d", flate.BestCompression)
+	}
+	return nil
+}
+
+func (c Config) level() int {
+	if c.Level == 0 {
+		return flate.BestSpeed
+	}
+	return c.Level
+}
+
+// Conn is a net.Conn whose stream is flate-compressed in both directions
+type Conn struct {
+	net.Conn
+	cfg  Config
+	wire *countWriter
+	r    io.ReadCloser
+
+	mu    sync.Mutex
+	w     *flate.Writer
+	timer *time.Timer
+	err   error // of the last batched flush
+
+	plainIn  atomic.Uint64
+	plainOut atomic.Uint64
+	wireIn   atomic.Uint64
+}
+
+// Client opens a tunnel on a dialed connection
+func Client(c net.Conn, cfg Config) (*Conn, error) {
+	if _, err := io.WriteString(c, Magic); err != nil {
+		return nil, err
+	}
+	return wrap(c, bufio.NewReader(c), cfg)
+}
+
+// Server accepts a tunnel on an incoming connection
+func Server(c net.Conn, cfg Config) (*Conn, error) {
+	_ = c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	br := bufio.NewReader(c)
+	buf := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, fmt.Errorf("tunnel handshake: %w", err)
+	}
+	if string(buf) != Magic {
+		return nil, errors.New("tunnel handshake: peer is not a karoo tunnel")
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	return wrap(c, br, cfg)
+}
+
+func wrap(c net.Conn, br *bufio.Reader, cfg Config) (*Conn, error) {
+	t := &Conn{Conn: c, cfg: cfg, wire: &countWriter{w: c}}
+	w, err := flate.NewWriter(t.wire, cfg.level())
+	if err != nil {
+		return nil, err
+	}
+	t.w = w
+	t.r = flate.NewReader(&countReader{r: br, n: &t.wireIn})
+	return t, nil
+}
+
+// NetConn returns the connection the tunnel runs on
+func (t *Conn) NetConn() net.Conn {
+	return t.Conn
+}
+
+// Read returns decompressed bytes from the peer
+func (t *Conn) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.plainIn.Add(uint64(n))
+	return n, err
+}
+
+// Write compresses p and sends it now, or with the batch when BatchMs is set
+func (t *Conn) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.w.Write(p)
+	t.plainOut.Add(uint64(n))
+	if err != nil {
+		return n, err
+	}
+	if t.cfg.BatchMs <= 0 {
+		return n, t.w.Flush()
+	}
+	if t.timer == nil {
+		t.timer = time.AfterFunc(time.Duration(t.cfg.BatchMs)*time.Millisecond, t.flush)
+	}
+	return n, nil
+}
+
+// flush sends the pending batch
+func (t *Conn) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = nil
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+}
+
+// Close sends what is pending and closes the connection
+func (t *Conn) Close() error {
+	t.mu.Lock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if t.err == nil {
+		_ = t.w.Flush()
+	}
+	t.err = net.ErrClosed
+	t.mu.Unlock()
+	return t.Conn.Close()
+}
+
+// Stats reports bytes before and after compression in each direction
+type Stats struct {
+	PlainOut uint64  `json:"plain_out"`
+	WireOut  uint64  `json:"wire_out"`
+	PlainIn  uint64  `json:"plain_in"`
+	WireIn   uint64  `json:"wire_in"`
+	Ratio    float64 `json:"ratio"` // plain / wire over both directions
+}
+
+// Stats returns the byte counters of the tunnel
+func (t *Conn) Stats() Stats {
+	s := Stats{
+		PlainOut: t.plainOut.Load(),
+		WireOut:  t.wire.n.Load(),
+		PlainIn:  t.plainIn.Load(),
+		WireIn:   t.wireIn.Load(),
+	}
+	if wire := s.WireOut + s.WireIn; wire > 0 {
+		s.Ratio = float64(s.PlainOut+s.PlainIn) / float64(wire)
+	}
+	return s
+}
+
+// countWriter counts the compressed bytes written
+type countWriter struct {
+	w io.Writer
+	n atomic.Uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+// countReader counts the compressed bytes read; flate needs a ByteReader
+type countReader struct {
+	r *bufio.Reader
+	n *atomic.Uint64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n.Add(1)
+	}
+	return b, err
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pair opens a tunnel over a local TCP connection
+func pair(t *testing.T, cfg Config) (client, server *Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			server, err = Server(c, cfg)
+		}
+		done <- err
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client, err = Client(c, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestTunnel(t *testing.T) {
+	for _, cfg := range []Config{{Enabled: true}, {Enabled: true, BatchMs: 20, Level: 9}} {
+		client, server := pair(t, cfg)
+		line := `{"id":null,"method":"mining.notify","params":["1f","00000000000000000000000000000000","01000000","ffffffff",[],"20000000","1703a30c","65000000",false]}` + "\n"
+		go func() {
+			for i := 0; i < 50; i++ {
+				_, _ = client.Write([]byte(line))
+			}
+		}()
+		sc := bufio.NewScanner(server)
+		_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for i := 0; i < 50; i++ {
+			if !sc.Scan() || sc.Text()+"\n" != line {
+				t.Fatalf("batch_ms=%d: line %d = %q, %v", cfg.BatchMs, i, sc.Text(), sc.Err())
+			}
+		}
+
+		// and back
+		if _, err := server.Write([]byte("pong\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if got, err := bufio.NewReader(client).ReadString('\n'); err != nil || got != "pong\n" {
+			t.Fatalf("reply = %q, %v", got, err)
+		}
+
+		if st := client.Stats(); st.PlainOut != uint64(50*len(line)) || st.Ratio < 5 {
+			t.Errorf("batch_ms=%d: stats = %+v", cfg.BatchMs, st)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestTunnelRefusesPlainClients(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	go func() { _, _ = a.Write([]byte(strings.Repeat("x", len(Magic)))) }()
+	if _, err := Server(b, Config{Enabled: true}); err == nil {
+		t.Fatal("plain client accepted")
+	}
+	if (Config{Level: 10}).Validate() == nil || (Config{BatchMs: -1}).Validate() == nil {
+		t.Error("invalid config accepted")
+	}
+}
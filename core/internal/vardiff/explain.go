@@ -0,0 +1,111 @@
+package vardiff
+
+import (
+	"log"
+	"time"
+)
+
+// maxDecisions bounds the adjustments kept per client for Explain
+const maxDecisions = 10
+
+// Decision is one difficulty change vardiff made for a client
+type Decision struct {
+	Time            time.Time `json:"time"`
+	Old             float64   `json:"old"`
+	New             float64   `json:"new"`
+	Ideal           float64   `json:"ideal"`
+	SharesPerSecond float64   `json:"shares_per_second"`
+	Window          int       `json:"window"`
+	Reason          string    `json:"reason"` // "retarget" or "fast"
+}
+
+// Explanation is the vardiff state of one client, for tuning target_seconds
+type Explanation struct {
+	Worker          string       `json:"worker"`
+	Session         string       `json:"session,omitempty"`
+	Difficulty      float64      `json:"difficulty"`
+	Ideal           float64      `json:"ideal"`
+	SharesPerSecond float64      `json:"shares_per_second"`
+	TargetSeconds   int          `json:"target_seconds"`
+	Pinned          bool         `json:"pinned"`
+	Floor           float64      `json:"floor,omitempty"`
+	Ceiling         float64      `json:"ceiling,omitempty"`
+	Fast            bool         `json:"fast"`
+	LastAdjust      time.Time    `json:"last_adjust"`
+	NextAdjust      *time.Time   `json:"next_adjust,omitempty"` // nil when pinned or in the fast phase
+	Window          []ShareEntry `json:"window"`
+	Decisions       []Decision   `json:"decisions"`
+}
+
+// sessionOf returns the session ID of clients that carry one
+func sessionOf(cl Client) string {
+	if sc, ok := cl.(interface{ GetSession() string }); ok {
+		return sc.GetSession()
+	}
+	return ""
+}
+
+// decide records a difficulty change of a client and logs it when
+// log_decisions is set. Callers hold stats.mu.
+func (m *Manager) decide(cl Client, stats *ClientStats, old float64, reason string, now time.Time) {
+	d := Decision{
+		Time:            now,
+		Old:             old,
+		New:             stats.CurrentDifficulty,
+		Ideal:           stats.Ideal,
+		SharesPerSecond: stats.SharesPerSecond,
+		Window:          len(stats.ShareWindow),
+		Reason:          reason,
+	}
+	stats.Decisions = append(stats.Decisions, d)
+	if len(stats.Decisions) > maxDecisions {
+		stats.Decisions = stats.Decisions[len(stats.Decisions)-maxDecisions:]
+	}
+	if m.cfg.LogDecisions {
+		log.Printf("vardiff: %s worker=%s session=%s diff %g -> %g (ideal %g) rate=%.4f/s window=%d",
+			reason, stats.Worker, sessionOf(cl), d.Old, d.New, d.Ideal, d.SharesPerSecond, d.Window)
+	}
+}
+
+// Explain returns the vardiff state of every connected client of worker
+func (m *Manager) Explain(worker string) []Explanation {
+	now := time.Now()
+	var out []Explanation
+
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+	for cl, stats := range m.clients {
+		stats.mu.Lock()
+		if stats.Worker != worker {
+			stats.mu.Unlock()
+			continue
+		}
+		e := Explanation{
+			Worker:          stats.Worker,
+			Session:         sessionOf(cl),
+			Difficulty:      stats.CurrentDifficulty,
+			Ideal:           stats.ideal(),
+			SharesPerSecond: stats.SharesPerSecond,
+			TargetSeconds:   m.cfg.TargetSeconds,
+			Pinned:          stats.Pinned,
+			Floor:           stats.Floor,
+			Ceiling:         stats.Ceiling,
+			Fast:            m.fastActive(stats, now),
+			LastAdjust:      stats.LastAdjustTime,
+			Window:          append([]ShareEntry{}, stats.ShareWindow...),
+			Decisions:       append([]Decision{}, stats.Decisions...),
+		}
+		if !e.Pinned && !e.Fast {
+			// the adjust loop ticks every interval, so a due client waits
+			// at most one more tick
+			next := stats.LastAdjustTime.Add(stats.RetargetInterval)
+			if next.Before(now) {
+				next = now.Add(stats.RetargetInterval)
+			}
+			e.NextAdjust = &next
+		}
+		stats.mu.Unlock()
+		out = append(out, e)
+	}
+	return out
+}
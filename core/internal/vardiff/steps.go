@@ -0,0 +1,131 @@
+package vardiff
+
+import (
+	"math"
+	"slices"
+)
+
+// Difficulty sets emitted difficulties are quantized to
+const (
+	StepsPow2 = "pow2" // powers of two
+	StepsList = "list" // the configured step_list
+)
+
+// defaultHysteresis is the relative change a retarget must reach when none
+// is configured
+const defaultHysteresis = 0.1
+
+// hysteresis returns the configured retarget margin as a fraction
+func (c *Config) hysteresis() float64 {
+	if c.HysteresisPct <= 0 {
+		return defaultHysteresis
+	}
+	return c.HysteresisPct / 100
+}
+
+// ideal returns the unquantized difficulty the controller aims for
+func (s *ClientStats) ideal() float64 {
+	if s.Ideal > 0 {
+		return s.Ideal
+	}
+	return s.CurrentDifficulty
+}
+
+// bounds returns the range a client's difficulty may take: the configured
+// min/max narrowed by any requested floor or ceiling. hi is 0 when unbounded.
+func (m *Manager) bounds(stats *ClientStats) (lo, hi float64) {
+	lo, hi = float64(m.cfg.MinDiff), float64(m.cfg.MaxDiff)
+	if stats.Floor > lo {
+		lo = stats.Floor
+	}
+	if stats.Ceiling > 0 && (hi <= 0 || stats.Ceiling < hi) {
+		hi = stats.Ceiling
+	}
+	return lo, hi
+}
+
+// within bounds diff to [lo, hi], hi 0 meaning unbounded
+func within(diff, lo, hi float64) float64 {
+	if hi > 0 && diff > hi {
+		diff = hi
+	}
+	if diff < lo {
+		diff = lo
+	}
+	return diff
+}
+
+// nearestStep returns the allowed difficulty closest to diff on a log scale,
+// preferring values inside [lo, hi]. Without steps diff is returned as is.
+func (m *Manager) nearestStep(diff, lo, hi float64) float64 {
+	if diff <= 0 {
+		return diff
+	}
+	switch m.cfg.Steps {
+	case StepsPow2:
+		q := math.Exp2(math.Round(math.Log2(diff)))
+		for q < lo && (hi <= 0 || q*2 <= hi) {
+			q *= 2
+		}
+		for hi > 0 && q > hi && q/2 >= lo {
+			q /= 2
+		}
+		return q
+	case StepsList:
+		best, inside := 0.0, false
+		for _, s := range m.cfg.StepList {
+			in := s >= lo && (hi <= 0 || s <= hi)
+			if inside && !in {
+				continue
+			}
+			if best == 0 || in && !inside ||
+				math.Abs(math.Log(s/diff)) < math.Abs(math.Log(best/diff)) {
+				best, inside = s, in
+			}
+		}
+		if best > 0 {
+			return best
+		}
+	}
+	return diff
+}
+
+// settle picks the difficulty to send for a retarget from cur towards raw.
+// Without steps raw is sent once it differs from cur by the hysteresis
+// margin; with steps the step nearest raw is sent once raw passes the
+// midpoint between cur and that step by the margin, so a rate sitting on a
+// boundary does not flip between neighbouring steps.
+func (m *Manager) settle(cur, raw, lo, hi float64) float64 {
+	h := m.cfg.hysteresis()
+	if m.cfg.Steps == "" {
+		if ratio := raw / cur; ratio >= 1-h && ratio <= 1+h {
+			return cur
+		}
+		return raw
+	}
+	q := m.nearestStep(raw, lo, hi)
+	if q == cur {
+		return cur
+	}
+	// cur may be off the steps after a config change; snap without waiting
+	if m.nearestStep(cur, lo, hi) != cur {
+		return q
+	}
+	mid := math.Sqrt(cur * q)
+	if q > cur && raw < mid*(1+h) || q < cur && raw > mid*(1-h) {
+		return cur
+	}
+	return q
+}
+
+// ValidSteps reports whether steps names a known difficulty set and, for a
+// list, whether list holds only positive values
+func ValidSteps(steps string, list []float64) bool {
+	switch steps {
+	case "", StepsPow2:
+		return true
+	case StepsList:
+		return len(list) > 0 && !slices.ContainsFunc(list, func(s float64) bool { return s <= 0 })
+	}
+	return false
+}
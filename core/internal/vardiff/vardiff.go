@@ -3,6 +3,10 @@ package vardiff
 
 import (
 	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -15,6 +19,26 @@ const (
 	maxShareWindowSize = 100
 	// maxShareWindowAge is the maximum age of shares to keep in the window
 	maxShareWindowAge = 10 * time.Minute
+	// defaultRestoreTTL is how long a departed worker's difficulty is remembered
+	// when no TTL is configured
+	defaultRestoreTTL = 24 * time.Hour
+	// defaultFastWindow bounds the fast retarget phase when no length is
+	// configured
+	defaultFastWindow = 2 * time.Minute
+	// fastStep caps how far one fast retarget moves the difficulty
+	fastStep = 4.0
+	// fastMinGap spaces fast retargets driven by shares
+	fastMinGap = time.Second
+	// fastTick is how often clients without shares are checked during the
+	// fast phase
+	fastTick = 5 * time.Second
+)
+
+// How a difficulty requested by the miner bounds retargeting
+const (
+	BoundFloor   = "floor"   // retarget, never below the request
+	BoundCeiling = "ceiling" // retarget, never above the request
+	BoundFixed   = "fixed"   // keep the request, no retargeting
 )
 
 // Client represents a mining client interface for vardiff package
@@ -29,6 +53,45 @@ type Config struct {
 	MinDiff       int  `json:"min_diff"`
 	MaxDiff       int  `json:"max_diff"`
 	AdjustEveryMs int  `json:"adjust_every_ms"`
+	// RestoreDifficulty re-applies a worker's last converged difficulty on reconnect
+	RestoreDifficulty bool `json:"restore_difficulty"`
+	// RestoreTTLSeconds bounds how old a remembered difficulty may be
+	RestoreTTLSeconds int `json:"restore_ttl_seconds"`
+	// StateFile persists remembered difficulties across restarts (optional)
+	StateFile string `json:"state_file"`
+
+	// FastRetargetShares retargets new clients on every accepted share until
+	// they sent this many; 0 disables the fast phase
+	FastRetargetShares int `json:"fast_retarget_shares"`
+	// FastRetargetSeconds ends the fast phase early; default 120
+	FastRetargetSeconds int `json:"fast_retarget_seconds"`
+
+	// Steps quantizes emitted difficulties to powers of two ("pow2") or to
+	// StepList ("list"); empty sends them as computed
+	Steps    string    `json:"steps"`
+	StepList []float64 `json:"step_list"`
+	// HysteresisPct is how far past a change a retarget must get before it
+	// is sent, in percent; default 10
+	HysteresisPct float64 `json:"hysteresis_pct"`
+	// LogDecisions logs every difficulty change with the share rate and
+	// window it was based on
+	LogDecisions bool `json:"log_decisions"`
+}
+
+// restoreTTL returns the effective retention for remembered difficulties
+func (c *Config) restoreTTL() time.Duration {
+	if c.RestoreTTLSeconds <= 0 {
+		return defaultRestoreTTL
+	}
+	return time.Duration(c.RestoreTTLSeconds) * time.Second
+}
+
+// fastWindow returns the longest a new client stays in the fast phase
+func (c *Config) fastWindow() time.Duration {
+	if c.FastRetargetSeconds <= 0 {
+		return defaultFastWindow
+	}
+	return time.Duration(c.FastRetargetSeconds) * time.Second
 }
 
 // ClientStats tracks per-client statistics for vardiff calculations
@@ -40,13 +103,45 @@ type ClientStats struct {
 	LastShareTime     time.Time
 	SharesPerSecond   float64
 	RetargetInterval  time.Duration
+	Worker            string
+	// Pinned clients keep a static difficulty and are never retargeted
+	Pinned bool
+	// Per-client bounds from a difficulty the miner requested (0 = none)
+	Floor   float64
+	Ceiling float64
+
+	// Fast phase of a new client: it ends at FastUntil (zero once over) or
+	// after the configured number of accepted shares
+	AddedAt    time.Time
+	FastUntil  time.Time
+	FastShares int
+	FastWork   float64 // sum of accepted share difficulties in the phase
+
+	// Ideal is the unquantized difficulty retargets build on; it runs
+	// ahead of CurrentDifficulty until it clears the hysteresis
+	Ideal float64
+	// Converged is the difficulty the last normal retarget settled on, or
+	// the one restored on reconnect; 0 until then. It is what is remembered
+	// when the client leaves.
+	Converged float64
+	// Raised is set once the share throttle raised the difficulty as a
+	// penalty; later retargets are bounded by it and are not remembered
+	Raised bool
+	// Decisions holds the latest difficulty changes, oldest first
+	Decisions []Decision
+}
+
+// RememberedDifficulty is the last difficulty a worker converged to
+type RememberedDifficulty struct {
+	Difficulty float64   `json:"difficulty"`
+	SavedAt    time.Time `json:"saved_at"`
 }
 
 // ShareEntry represents a single share submission
 type ShareEntry struct {
-	Timestamp  time.Time
-	Accepted   bool
-	Difficulty float64
+	Timestamp  time.Time `json:"time"`
+	Accepted   bool      `json:"accepted"`
+	Difficulty float64   `json:"difficulty"`
 }
 
 // Manager handles variable difficulty adjustment for all clients
@@ -55,13 +150,18 @@ type Manager struct {
 
 	clientsMu sync.RWMutex
 	clients   map[Client]*ClientStats
+
+	// last converged difficulty per worker name
+	rememberMu sync.Mutex
+	remembered map[string]RememberedDifficulty
 }
 
 // NewManager creates a new vardiff manager
 func NewManager(cfg *Config) *Manager {
 	return &Manager{
-		cfg:     cfg,
-		clients: make(map[Client]*ClientStats),
+		cfg:        cfg,
+		clients:    make(map[Client]*ClientStats),
+		remembered: make(map[string]RememberedDifficulty),
 	}
 }
 
@@ -80,16 +180,34 @@ func (m *Manager) UpdateConfig(cfg *Config) {
 
 // AddClient adds a client to vardiff management
 func (m *Manager) AddClient(cl Client) {
+	m.AddClientAt(cl, float64(m.cfg.MinDiff))
+}
+
+// AddClientAt adds a client starting at the given difficulty, clamped to the
+// configured bounds
+func (m *Manager) AddClientAt(cl Client, diff float64) {
 	if !m.cfg.Enabled {
 		return
 	}
+	if diff < float64(m.cfg.MinDiff) {
+		diff = float64(m.cfg.MinDiff)
+	}
+	if m.cfg.MaxDiff > 0 && diff > float64(m.cfg.MaxDiff) {
+		diff = float64(m.cfg.MaxDiff)
+	}
+	diff = m.nearestStep(diff, float64(m.cfg.MinDiff), float64(m.cfg.MaxDiff))
 
+	now := time.Now()
 	stats := &ClientStats{
-		CurrentDifficulty: float64(m.cfg.MinDiff),
-		LastAdjustTime:    time.Now(),
-		LastShareTime:     time.Now(),
+		CurrentDifficulty: diff,
+		LastAdjustTime:    now,
+		LastShareTime:     now,
 		RetargetInterval:  time.Duration(m.cfg.AdjustEveryMs) * time.Millisecond,
 		ShareWindow:       make([]ShareEntry, 0, 100), // Keep last 100 shares
+		AddedAt:           now,
+	}
+	if m.cfg.FastRetargetShares > 0 {
+		stats.FastUntil = now.Add(m.cfg.fastWindow())
 	}
 
 	m.clientsMu.Lock()
@@ -103,8 +221,279 @@ func (m *Manager) AddClient(cl Client) {
 // RemoveClient removes a client from vardiff management
 func (m *Manager) RemoveClient(cl Client) {
 	m.clientsMu.Lock()
+	stats, exists := m.clients[cl]
 	delete(m.clients, cl)
 	m.clientsMu.Unlock()
+
+	if exists {
+		stats.mu.Lock()
+		worker, diff, pinned := stats.Worker, stats.Converged, stats.Pinned
+		stats.mu.Unlock()
+		if !pinned {
+			m.remember(worker, diff)
+		}
+	}
+}
+
+// BindWorker associates a worker name with a client and, when enabled,
+// restores the difficulty the worker had converged to before it reconnected.
+// Returns true if a remembered difficulty was applied.
+func (m *Manager) BindWorker(cl Client, worker string) bool {
+	if !m.cfg.Enabled || worker == "" {
+		return false
+	}
+
+	m.clientsMu.RLock()
+	stats, exists := m.clients[cl]
+	m.clientsMu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.Worker = worker
+
+	if !m.cfg.RestoreDifficulty {
+		return false
+	}
+	m.rememberMu.Lock()
+	saved, ok := m.remembered[worker]
+	m.rememberMu.Unlock()
+	if !ok || time.Since(saved.SavedAt) > m.cfg.restoreTTL() {
+		return false
+	}
+
+	lo, hi := m.bounds(stats)
+	diff := m.nearestStep(within(saved.Difficulty, lo, hi), lo, hi)
+	stats.Converged = diff
+	if diff == stats.CurrentDifficulty {
+		return false
+	}
+	// a remembered difficulty already converged, so the fast phase is moot
+	stats.FastUntil = time.Time{}
+	stats.CurrentDifficulty, stats.Ideal = diff, diff
+	stats.LastAdjustTime = time.Now()
+	m.sendDifficulty(cl, diff)
+	return true
+}
+
+// PinDifficulty fixes a client's difficulty and exempts it from retargeting.
+// Clients vardiff does not track just receive the difficulty.
+func (m *Manager) PinDifficulty(cl Client, diff float64) {
+	m.clientsMu.RLock()
+	stats, exists := m.clients[cl]
+	m.clientsMu.RUnlock()
+	if exists {
+		stats.mu.Lock()
+		stats.Pinned = true
+		stats.CurrentDifficulty, stats.Ideal = diff, diff
+		stats.LastAdjustTime = time.Now()
+		stats.mu.Unlock()
+	}
+	m.sendDifficulty(cl, diff)
+}
+
+// RequestDifficulty starts a client at the difficulty its miner asked for,
+// clamped to the configured bounds, and keeps later retargets on the side of
+// it given by bound. Returns the difficulty applied, or false when the client
+// is not tracked.
+func (m *Manager) RequestDifficulty(cl Client, diff float64, bound string) (float64, bool) {
+	m.clientsMu.RLock()
+	stats, exists := m.clients[cl]
+	m.clientsMu.RUnlock()
+	if !exists {
+		return 0, false
+	}
+
+	diff = m.clamp(diff)
+	if bound != BoundFixed {
+		diff = m.nearestStep(diff, float64(m.cfg.MinDiff), float64(m.cfg.MaxDiff))
+	}
+	stats.mu.Lock()
+	stats.CurrentDifficulty, stats.Ideal = diff, diff
+	stats.LastAdjustTime = time.Now()
+	stats.Floor, stats.Ceiling = 0, 0
+	switch bound {
+	case BoundFloor:
+		stats.Floor = diff
+	case BoundCeiling:
+		stats.Ceiling = diff
+	case BoundFixed:
+		stats.Pinned = true
+	}
+	stats.mu.Unlock()
+	m.sendDifficulty(cl, diff)
+	return diff, true
+}
+
+// SuggestDifficulty moves a client to the difficulty its miner suggested,
+// clamped to the configured bounds and to any bound from a requested
+// difficulty; retargeting continues from there. Pinned clients keep their
+// difficulty. Returns the difficulty applied, or false when it was ignored.
+func (m *Manager) SuggestDifficulty(cl Client, diff float64) (float64, bool) {
+	m.clientsMu.RLock()
+	stats, exists := m.clients[cl]
+	m.clientsMu.RUnlock()
+	if !exists {
+		return 0, false
+	}
+
+	diff = m.clamp(diff)
+	stats.mu.Lock()
+	if stats.Pinned {
+		stats.mu.Unlock()
+		return 0, false
+	}
+	if stats.Floor > 0 && diff < stats.Floor {
+		diff = stats.Floor
+	}
+	if stats.Ceiling > 0 && diff > stats.Ceiling {
+		diff = stats.Ceiling
+	}
+	lo, hi := m.bounds(stats)
+	diff = m.nearestStep(diff, lo, hi)
+	stats.CurrentDifficulty, stats.Ideal = diff, diff
+	stats.LastAdjustTime = time.Now()
+	stats.mu.Unlock()
+	m.sendDifficulty(cl, diff)
+	return diff, true
+}
+
+// RaiseDifficulty multiplies a client's difficulty by factor, clamped to the
+// configured maximum and to any requested ceiling, and keeps retargeting from
+// dropping below the result. Returns the difficulty applied, or false when
+// the client is untracked, pinned or already at its highest difficulty.
+func (m *Manager) RaiseDifficulty(cl Client, factor float64) (float64, bool) {
+	m.clientsMu.RLock()
+	stats, exists := m.clients[cl]
+	m.clientsMu.RUnlock()
+	if !exists {
+		return 0, false
+	}
+
+	stats.mu.Lock()
+	cur := stats.CurrentDifficulty
+	diff := m.clamp(cur * factor)
+	if stats.Ceiling > 0 && diff > stats.Ceiling {
+		diff = stats.Ceiling
+	}
+	_, hi := m.bounds(stats)
+	diff = m.nearestStep(diff, diff, hi)
+	if stats.Pinned || diff <= cur {
+		stats.mu.Unlock()
+		return 0, false
+	}
+	stats.CurrentDifficulty, stats.Ideal = diff, diff
+	stats.LastAdjustTime = time.Now()
+	stats.Floor = diff
+	stats.Raised = true
+	stats.mu.Unlock()
+	m.sendDifficulty(cl, diff)
+	return diff, true
+}
+
+// remember stores the difficulty a worker converged to for later
+// restoration; 0 (nothing converged) leaves an earlier entry in place
+func (m *Manager) remember(worker string, diff float64) {
+	if !m.cfg.RestoreDifficulty || worker == "" || diff <= 0 {
+		return
+	}
+	m.rememberMu.Lock()
+	m.remembered[worker] = RememberedDifficulty{Difficulty: diff, SavedAt: time.Now()}
+	m.rememberMu.Unlock()
+}
+
+// clamp bounds a difficulty to the configured min/max
+func (m *Manager) clamp(diff float64) float64 {
+	if diff < float64(m.cfg.MinDiff) {
+		return float64(m.cfg.MinDiff)
+	}
+	if m.cfg.MaxDiff > 0 && diff > float64(m.cfg.MaxDiff) {
+		return float64(m.cfg.MaxDiff)
+	}
+	return diff
+}
+
+// Remembered returns the stored difficulty for a worker, if any
+func (m *Manager) Remembered(worker string) (float64, bool) {
+	m.rememberMu.Lock()
+	defer m.rememberMu.Unlock()
+	saved, ok := m.remembered[worker]
+	return saved.Difficulty, ok
+}
+
+// snapshotRemembered merges stored difficulties with those of connected workers
+// and drops entries older than the restore TTL
+func (m *Manager) snapshotRemembered() map[string]RememberedDifficulty {
+	now := time.Now()
+	ttl := m.cfg.restoreTTL()
+
+	out := make(map[string]RememberedDifficulty)
+	m.rememberMu.Lock()
+	for worker, saved := range m.remembered {
+		if now.Sub(saved.SavedAt) > ttl {
+			delete(m.remembered, worker)
+			continue
+		}
+		out[worker] = saved
+	}
+	m.rememberMu.Unlock()
+
+	m.clientsMu.RLock()
+	for _, stats := range m.clients {
+		stats.mu.Lock()
+		if stats.Worker != "" && stats.Converged > 0 && !stats.Pinned {
+			out[stats.Worker] = RememberedDifficulty{Difficulty: stats.Converged, SavedAt: now}
+		}
+		stats.mu.Unlock()
+	}
+	m.clientsMu.RUnlock()
+	return out
+}
+
+// SaveState writes remembered difficulties to path atomically
+func (m *Manager) SaveState(path string) error {
+	data, err := json.MarshalIndent(m.snapshotRemembered(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".vardiff-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState reads remembered difficulties previously written by SaveState.
+// A missing file is not an error.
+func (m *Manager) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var saved map[string]RememberedDifficulty
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	m.rememberMu.Lock()
+	for worker, entry := range saved {
+		m.remembered[worker] = entry
+	}
+	m.rememberMu.Unlock()
+	return nil
 }
 
 // RecordShare records a share submission for difficulty calculations
@@ -159,6 +548,74 @@ func (m *Manager) RecordShare(cl Client, accepted bool, difficulty float64) {
 
 	// Calculate shares per second
 	m.calculateSharesPerSecond(stats)
+
+	if accepted && m.fastActive(stats, entry.Timestamp) {
+		if difficulty <= 0 {
+			difficulty = stats.CurrentDifficulty
+		}
+		stats.FastShares++
+		stats.FastWork += difficulty
+		if entry.Timestamp.Sub(stats.LastAdjustTime) >= fastMinGap {
+			m.fastAdjust(cl, stats, entry.Timestamp)
+		}
+	}
+}
+
+// fastActive reports whether a client is still in its fast phase, closing
+// the phase once it ran out. Callers hold stats.mu.
+func (m *Manager) fastActive(stats *ClientStats, now time.Time) bool {
+	if stats.FastUntil.IsZero() || stats.Pinned {
+		return false
+	}
+	if !now.Before(stats.FastUntil) || stats.FastShares >= m.cfg.FastRetargetShares {
+		stats.FastUntil = time.Time{}
+		return false
+	}
+	return true
+}
+
+// fastAdjust moves a client in its fast phase straight towards the
+// difficulty its hashrate since connecting calls for, at most fastStep
+// times per call. Callers hold stats.mu.
+func (m *Manager) fastAdjust(cl Client, stats *ClientStats, now time.Time) {
+	elapsed := now.Sub(stats.AddedAt).Seconds()
+	if elapsed <= 0 || m.cfg.TargetSeconds <= 0 {
+		return
+	}
+	cur := stats.CurrentDifficulty
+	raw := stats.FastWork / elapsed * float64(m.cfg.TargetSeconds)
+	raw = max(cur/fastStep, min(raw, cur*fastStep))
+	lo, hi := m.bounds(stats)
+	raw = within(raw, lo, hi)
+	stats.Ideal = raw
+	diff := m.settle(cur, raw, lo, hi)
+	if diff == cur {
+		return
+	}
+	stats.CurrentDifficulty = diff
+	stats.LastAdjustTime = now
+	m.decide(cl, stats, cur, "fast", now)
+	m.sendDifficulty(cl, diff)
+}
+
+// FastRetarget lowers the difficulty of clients in their fast phase that
+// sent too few shares to retarget on: after twice the target share time
+// without an adjustment they move to what their shares so far call for
+func (m *Manager) FastRetarget(now time.Time) {
+	if !m.cfg.Enabled || m.cfg.FastRetargetShares <= 0 {
+		return
+	}
+	wait := 2 * time.Duration(m.cfg.TargetSeconds) * time.Second
+
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+	for cl, stats := range m.clients {
+		stats.mu.Lock()
+		if m.fastActive(stats, now) && now.Sub(stats.LastAdjustTime) >= wait {
+			m.fastAdjust(cl, stats, now)
+		}
+		stats.mu.Unlock()
+	}
 }
 
 // calculateSharesPerSecond calculates the current share rate
@@ -218,34 +675,33 @@ func (m *Manager) adjustClientDifficulty(cl Client) {
 	defer stats.mu.Unlock()
 
 	now := time.Now()
-	if now.Sub(stats.LastAdjustTime) < stats.RetargetInterval {
+	if stats.Pinned || m.fastActive(stats, now) || now.Sub(stats.LastAdjustTime) < stats.RetargetInterval {
 		return
 	}
 
-	// Calculate new difficulty
-	newDiff := m.calculateNewDifficulty(stats)
+	// Calculate new difficulty within bounds
+	lo, hi := m.bounds(stats)
+	raw := within(m.calculateNewDifficulty(stats), lo, hi)
+	stats.Ideal = raw
 
-	// Apply bounds
-	if newDiff < float64(m.cfg.MinDiff) {
-		newDiff = float64(m.cfg.MinDiff)
-	} else if newDiff > float64(m.cfg.MaxDiff) {
-		newDiff = float64(m.cfg.MaxDiff)
-	}
-
-	// Update if changed significantly (more than 10% difference)
-	diffRatio := newDiff / stats.CurrentDifficulty
-	if diffRatio < 0.9 || diffRatio > 1.1 {
+	// Update if changed past the hysteresis (10% by default)
+	if newDiff := m.settle(stats.CurrentDifficulty, raw, lo, hi); newDiff != stats.CurrentDifficulty {
+		old := stats.CurrentDifficulty
 		stats.CurrentDifficulty = newDiff
 		stats.LastAdjustTime = now
+		m.decide(cl, stats, old, "retarget", now)
 		m.sendDifficulty(cl, newDiff)
 	}
+	if !stats.Raised {
+		stats.Converged = stats.CurrentDifficulty
+	}
 }
 
 // calculateNewDifficulty calculates the optimal difficulty for a client
 func (m *Manager) calculateNewDifficulty(stats *ClientStats) float64 {
 	if stats.SharesPerSecond == 0 {
 		// No shares recently, reduce difficulty
-		return stats.CurrentDifficulty * 0.5
+		return stats.ideal() * 0.5
 	}
 
 	// Target shares per second based on current difficulty
@@ -254,10 +710,10 @@ func (m *Manager) calculateNewDifficulty(stats *ClientStats) float64 {
 	// Adjust difficulty to reach target
 	if stats.SharesPerSecond > targetSharesPerSec*1.2 {
 		// Too fast, increase difficulty
-		return stats.CurrentDifficulty * 1.2
+		return stats.ideal() * 1.2
 	} else if stats.SharesPerSecond < targetSharesPerSec*0.8 {
 		// Too slow, decrease difficulty
-		return stats.CurrentDifficulty * 0.8
+		return stats.ideal() * 0.8
 	}
 
 	// Within acceptable range, keep current difficulty
@@ -281,17 +737,33 @@ func (m *Manager) Run(ctx context.Context) {
 
 	ticker := time.NewTicker(time.Duration(m.cfg.AdjustEveryMs) * time.Millisecond)
 	defer ticker.Stop()
+	fast := time.NewTicker(fastTick)
+	defer fast.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			m.persistState()
 			return
 		case <-ticker.C:
 			m.AdjustDifficulties()
+			m.persistState()
+		case now := <-fast.C:
+			m.FastRetarget(now)
 		}
 	}
 }
 
+// persistState saves remembered difficulties when a state file is configured
+func (m *Manager) persistState() {
+	if !m.cfg.RestoreDifficulty || m.cfg.StateFile == "" {
+		return
+	}
+	if err := m.SaveState(m.cfg.StateFile); err != nil {
+		log.Printf("vardiff: saving state to %s: %v", m.cfg.StateFile, err)
+	}
+}
+
 // GetClientStats returns statistics for a client
 func (m *Manager) GetClientStats(cl Client) *ClientStats {
 	m.clientsMu.RLock()
@@ -305,6 +777,11 @@ func (m *Manager) GetClientStats(cl Client) *ClientStats {
 			LastShareTime:     stats.LastShareTime,
 			SharesPerSecond:   stats.SharesPerSecond,
 			RetargetInterval:  stats.RetargetInterval,
+			Worker:            stats.Worker,
+			Pinned:            stats.Pinned,
+			Floor:             stats.Floor,
+			Ceiling:           stats.Ceiling,
+			Ideal:             stats.Ideal,
 		}
 		stats.mu.Unlock()
 		return copy
@@ -321,7 +798,8 @@ func (m *Manager) Reset() {
 	for _, stats := range m.clients {
 		stats.mu.Lock()
 		stats.ShareWindow = stats.ShareWindow[:0]
-		stats.CurrentDifficulty = float64(m.cfg.MinDiff)
+		stats.CurrentDifficulty = m.nearestStep(float64(m.cfg.MinDiff), float64(m.cfg.MinDiff), float64(m.cfg.MaxDiff))
+		stats.Ideal = 0
 		stats.LastAdjustTime = time.Now()
 		stats.LastShareTime = time.Now()
 		stats.SharesPerSecond = 0
@@ -338,9 +816,14 @@ func (m *Manager) GetStats() map[string]interface{} {
 	avgDifficulty := 0.0
 	avgSharesPerSec := 0.0
 	activeClients := 0
+	fastClients := 0
+	now := time.Now()
 
 	for _, stats := range m.clients {
 		stats.mu.Lock()
+		if m.fastActive(stats, now) {
+			fastClients++
+		}
 		if time.Since(stats.LastShareTime) < time.Minute {
 			activeClients++
 			avgDifficulty += stats.CurrentDifficulty
@@ -357,6 +840,7 @@ func (m *Manager) GetStats() map[string]interface{} {
 	return map[string]interface{}{
 		"total_clients":      totalClients,
 		"active_clients":     activeClients,
+		"fast_clients":       fastClients,
 		"avg_difficulty":     avgDifficulty,
 		"avg_shares_per_sec": avgSharesPerSec,
 		"target_seconds":     m.cfg.TargetSeconds,
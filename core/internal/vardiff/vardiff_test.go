@@ -2,6 +2,7 @@ package vardiff
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -212,3 +213,509 @@ func TestGetStats(t *testing.T) {
 		}
 	}
 }
+
+func TestRestoreDifficultyOnReconnect(t *testing.T) {
+	cfg := &Config{
+		Enabled:           true,
+		TargetSeconds:     15,
+		MinDiff:           1000,
+		MaxDiff:           100000,
+		AdjustEveryMs:     60000,
+		RestoreDifficulty: true,
+	}
+
+	mgr := NewManager(cfg)
+	first := &mockClient{}
+	mgr.AddClient(first)
+	mgr.BindWorker(first, "rig01")
+
+	mgr.clientsMu.RLock()
+	stats := mgr.clients[first]
+	mgr.clientsMu.RUnlock()
+	stats.mu.Lock()
+	stats.CurrentDifficulty, stats.Converged = 32000, 32000
+	stats.mu.Unlock()
+
+	mgr.RemoveClient(first)
+
+	if diff, ok := mgr.Remembered("rig01"); !ok || diff != 32000 {
+		t.Fatalf("Expected remembered difficulty 32000, got %v (ok=%v)", diff, ok)
+	}
+
+	second := &mockClient{}
+	mgr.AddClient(second)
+	if !mgr.BindWorker(second, "rig01") {
+		t.Fatal("Expected difficulty to be restored")
+	}
+	if got := mgr.GetClientStats(second).CurrentDifficulty; got != 32000 {
+		t.Errorf("Expected restored difficulty 32000, got %f", got)
+	}
+	last := second.messages[len(second.messages)-1]
+	if last.Method != "mining.set_difficulty" {
+		t.Errorf("Expected set_difficulty to be sent, got %s", last.Method)
+	}
+
+	// Unknown workers start from MinDiff
+	other := &mockClient{}
+	mgr.AddClient(other)
+	if mgr.BindWorker(other, "rig02") {
+		t.Error("Unknown worker should not be restored")
+	}
+}
+
+func TestRestoreDifficultyDisabled(t *testing.T) {
+	cfg := &Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       1000,
+		MaxDiff:       100000,
+		AdjustEveryMs: 60000,
+	}
+
+	mgr := NewManager(cfg)
+	cl := &mockClient{}
+	mgr.AddClient(cl)
+	mgr.BindWorker(cl, "rig01")
+	mgr.RemoveClient(cl)
+
+	if _, ok := mgr.Remembered("rig01"); ok {
+		t.Error("Difficulty should not be remembered when restore is disabled")
+	}
+}
+
+func TestRestoreSkipsUnconvergedDifficulty(t *testing.T) {
+	cfg := &Config{
+		Enabled:            true,
+		TargetSeconds:      15,
+		MinDiff:            1000,
+		MaxDiff:            100000,
+		AdjustEveryMs:      60000,
+		RestoreDifficulty:  true,
+		FastRetargetShares: 10,
+	}
+
+	mgr := NewManager(cfg)
+	mgr.remember("rig01", 8000)
+
+	// left before its first retarget
+	early := &mockClient{}
+	mgr.AddClientAt(early, 32000)
+	mgr.RemoveClient(early)
+	if diff, _ := mgr.Remembered("rig01"); diff != 8000 {
+		t.Errorf("start difficulty remembered: %v", diff)
+	}
+
+	// left in the middle of its fast phase
+	fast := &mockClient{}
+	mgr.AddClientAt(fast, 16000)
+	mgr.FastRetarget(time.Now().Add(time.Minute))
+	if got := mgr.GetClientStats(fast).CurrentDifficulty; got != 4000 {
+		t.Fatalf("fast retarget moved to %v, want 4000", got)
+	}
+	mgr.BindWorker(fast, "rig02")
+	mgr.RemoveClient(fast)
+	if diff, ok := mgr.Remembered("rig02"); ok {
+		t.Errorf("fast phase difficulty remembered: %v", diff)
+	}
+}
+
+func TestRestoreSkipsRaisedDifficulty(t *testing.T) {
+	cfg := &Config{
+		Enabled:           true,
+		TargetSeconds:     15,
+		MinDiff:           1000,
+		MaxDiff:           100000,
+		AdjustEveryMs:     1,
+		RestoreDifficulty: true,
+	}
+
+	mgr := NewManager(cfg)
+	cl := &mockClient{}
+	mgr.AddClientAt(cl, 8000)
+	mgr.BindWorker(cl, "rig01")
+
+	// a normal retarget without shares halves the difficulty
+	time.Sleep(5 * time.Millisecond)
+	mgr.AdjustDifficulties()
+	if got := mgr.GetClientStats(cl).CurrentDifficulty; got != 4000 {
+		t.Fatalf("retarget moved to %v, want 4000", got)
+	}
+
+	// the throttle's penalty and the retargets it bounds are not kept
+	if diff, ok := mgr.RaiseDifficulty(cl, 4); !ok || diff != 16000 {
+		t.Fatalf("RaiseDifficulty = %v, %v", diff, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	mgr.AdjustDifficulties()
+	mgr.RemoveClient(cl)
+	if diff, ok := mgr.Remembered("rig01"); !ok || diff != 4000 {
+		t.Errorf("remembered %v (ok=%v), want the retargeted 4000", diff, ok)
+	}
+}
+
+func TestRestoreDifficultyExpired(t *testing.T) {
+	cfg := &Config{
+		Enabled:           true,
+		TargetSeconds:     15,
+		MinDiff:           1000,
+		MaxDiff:           100000,
+		AdjustEveryMs:     60000,
+		RestoreDifficulty: true,
+		RestoreTTLSeconds: 60,
+	}
+
+	mgr := NewManager(cfg)
+	mgr.remembered["rig01"] = RememberedDifficulty{Difficulty: 5000, SavedAt: time.Now().Add(-2 * time.Minute)}
+
+	cl := &mockClient{}
+	mgr.AddClient(cl)
+	if mgr.BindWorker(cl, "rig01") {
+		t.Error("Expired difficulty should not be restored")
+	}
+}
+
+func TestSaveLoadState(t *testing.T) {
+	cfg := &Config{
+		Enabled:           true,
+		TargetSeconds:     15,
+		MinDiff:           1000,
+		MaxDiff:           100000,
+		AdjustEveryMs:     60000,
+		RestoreDifficulty: true,
+	}
+	path := filepath.Join(t.TempDir(), "vardiff.json")
+
+	mgr := NewManager(cfg)
+	mgr.remember("rig01", 4096)
+	if err := mgr.SaveState(path); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	restored := NewManager(cfg)
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if diff, ok := restored.Remembered("rig01"); !ok || diff != 4096 {
+		t.Errorf("Expected loaded difficulty 4096, got %v (ok=%v)", diff, ok)
+	}
+
+	// Missing files are ignored
+	if err := restored.LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected no error for missing state file, got %v", err)
+	}
+}
+
+func TestPinDifficulty(t *testing.T) {
+	cfg := &Config{
+		Enabled:           true,
+		TargetSeconds:     15,
+		MinDiff:           1000,
+		MaxDiff:           100000,
+		AdjustEveryMs:     1,
+		RestoreDifficulty: true,
+	}
+
+	mgr := NewManager(cfg)
+	cl := &mockClient{}
+	mgr.AddClient(cl)
+	mgr.BindWorker(cl, "rig01")
+	mgr.PinDifficulty(cl, 65536)
+
+	time.Sleep(5 * time.Millisecond)
+	mgr.AdjustDifficulties()
+	if got := mgr.GetClientStats(cl).CurrentDifficulty; got != 65536 {
+		t.Errorf("pinned difficulty retargeted to %f", got)
+	}
+	last := cl.messages[len(cl.messages)-1]
+	if last.Method != "mining.set_difficulty" || last.Params.([]interface{})[0] != 65536.0 {
+		t.Errorf("expected the pinned difficulty to be sent last, got %+v", last)
+	}
+
+	mgr.RemoveClient(cl)
+	if _, ok := mgr.Remembered("rig01"); ok {
+		t.Error("pinned difficulty should not be remembered")
+	}
+
+	// Untracked clients still receive the difficulty
+	other := &mockClient{}
+	mgr.PinDifficulty(other, 2048)
+	if len(other.messages) != 1 {
+		t.Error("untracked client did not receive the difficulty")
+	}
+}
+
+func TestAddClientAt(t *testing.T) {
+	cfg := &Config{Enabled: true, TargetSeconds: 15, MinDiff: 1000, MaxDiff: 100000, AdjustEveryMs: 1000}
+	mgr := NewManager(cfg)
+
+	tests := []struct {
+		start, want float64
+	}{
+		{16384, 16384},
+		{10, 1000},
+		{1e9, 100000},
+	}
+	for _, tt := range tests {
+		cl := &mockClient{}
+		mgr.AddClientAt(cl, tt.start)
+		if got := mgr.GetClientStats(cl).CurrentDifficulty; got != tt.want {
+			t.Errorf("start %v: difficulty = %v, want %v", tt.start, got, tt.want)
+		}
+		if len(cl.messages) != 1 || cl.messages[0].Params.([]interface{})[0] != tt.want {
+			t.Errorf("start %v: initial difficulty not sent: %+v", tt.start, cl.messages)
+		}
+	}
+}
+
+func TestRequestDifficulty(t *testing.T) {
+	cfg := &Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       1000,
+		MaxDiff:       100000,
+		AdjustEveryMs: 1,
+	}
+	mgr := NewManager(cfg)
+
+	// no shares halve the difficulty on every retarget; the floor stops it
+	floor := &mockClient{}
+	mgr.AddClient(floor)
+	if diff, ok := mgr.RequestDifficulty(floor, 8000, BoundFloor); !ok || diff != 8000 {
+		t.Fatalf("RequestDifficulty = %f, %v", diff, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	mgr.AdjustDifficulties()
+	if got := mgr.GetClientStats(floor).CurrentDifficulty; got != 8000 {
+		t.Errorf("floor client retargeted to %f, want 8000", got)
+	}
+
+	// a ceiling lets it go down but clamps the request to max_diff
+	ceiling := &mockClient{}
+	mgr.AddClient(ceiling)
+	if diff, _ := mgr.RequestDifficulty(ceiling, 1e9, BoundCeiling); diff != 100000 {
+		t.Errorf("request above max_diff applied as %f", diff)
+	}
+	time.Sleep(5 * time.Millisecond)
+	mgr.AdjustDifficulties()
+	if st := mgr.GetClientStats(ceiling); st.CurrentDifficulty != 50000 || st.Ceiling != 100000 {
+		t.Errorf("ceiling client: difficulty %f ceiling %f", st.CurrentDifficulty, st.Ceiling)
+	}
+
+	fixed := &mockClient{}
+	mgr.AddClient(fixed)
+	mgr.RequestDifficulty(fixed, 4096, BoundFixed)
+	if !mgr.GetClientStats(fixed).Pinned {
+		t.Error("fixed request should pin the difficulty")
+	}
+
+	if _, ok := mgr.RequestDifficulty(&mockClient{}, 4096, BoundFloor); ok {
+		t.Error("untracked client should not take a requested difficulty")
+	}
+}
+
+func TestSuggestDifficulty(t *testing.T) {
+	cfg := &Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       1000,
+		MaxDiff:       100000,
+		AdjustEveryMs: 60000,
+	}
+	mgr := NewManager(cfg)
+	cl := &mockClient{}
+	mgr.AddClient(cl)
+
+	if diff, ok := mgr.SuggestDifficulty(cl, 20000); !ok || diff != 20000 {
+		t.Errorf("SuggestDifficulty = %f, %v; want 20000", diff, ok)
+	}
+	last := cl.messages[len(cl.messages)-1]
+	if last.Method != "mining.set_difficulty" || last.Params.([]interface{})[0] != 20000.0 {
+		t.Errorf("expected the suggested difficulty to be sent, got %+v", last)
+	}
+	if diff, _ := mgr.SuggestDifficulty(cl, 10); diff != 1000 {
+		t.Errorf("suggestion below min_diff applied as %f", diff)
+	}
+
+	// a requested floor still holds
+	mgr.RequestDifficulty(cl, 8000, BoundFloor)
+	if diff, _ := mgr.SuggestDifficulty(cl, 2000); diff != 8000 {
+		t.Errorf("suggestion below the floor applied as %f", diff)
+	}
+
+	mgr.PinDifficulty(cl, 65536)
+	if _, ok := mgr.SuggestDifficulty(cl, 2000); ok {
+		t.Error("pinned client took a suggested difficulty")
+	}
+}
+
+func TestRaiseDifficulty(t *testing.T) {
+	mgr := NewManager(&Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       1000,
+		MaxDiff:       5000,
+		AdjustEveryMs: 60000,
+	})
+	cl := &mockClient{}
+	mgr.AddClientAt(cl, 2000)
+
+	if diff, ok := mgr.RaiseDifficulty(cl, 2); !ok || diff != 4000 {
+		t.Errorf("RaiseDifficulty = %f, %v; want 4000", diff, ok)
+	}
+	if stats := mgr.GetClientStats(cl); stats.Floor != 4000 {
+		t.Errorf("floor = %f, want the raised difficulty", stats.Floor)
+	}
+	if diff, ok := mgr.RaiseDifficulty(cl, 2); !ok || diff != 5000 {
+		t.Errorf("raise past max_diff = %f, %v; want 5000", diff, ok)
+	}
+	if _, ok := mgr.RaiseDifficulty(cl, 2); ok {
+		t.Error("raised a client already at max_diff")
+	}
+}
+
+func TestFastRetarget(t *testing.T) {
+	mgr := NewManager(&Config{
+		Enabled:            true,
+		TargetSeconds:      15,
+		MinDiff:            100,
+		MaxDiff:            100000,
+		AdjustEveryMs:      60000,
+		FastRetargetShares: 3,
+	})
+	backdate := func(cl Client, d time.Duration) *ClientStats {
+		stats := mgr.clients[cl]
+		stats.AddedAt = stats.AddedAt.Add(-d)
+		stats.LastAdjustTime = stats.LastAdjustTime.Add(-d)
+		return stats
+	}
+
+	// one share at 1000 in 10s asks for 1500 at a 15s target
+	cl := &mockClient{}
+	mgr.AddClientAt(cl, 1000)
+	backdate(cl, 10*time.Second)
+	mgr.RecordShare(cl, true, 1000)
+	if got := mgr.GetClientStats(cl).CurrentDifficulty; got < 1400 || got > 1500 {
+		t.Errorf("difficulty after first share = %f, want about 1500", got)
+	}
+	if len(cl.messages) != 2 {
+		t.Errorf("fast retarget not sent: %+v", cl.messages)
+	}
+
+	// once the phase is over shares no longer retarget
+	stats := backdate(cl, 10*time.Second)
+	stats.FastShares = 3
+	before := mgr.GetClientStats(cl).CurrentDifficulty
+	mgr.RecordShare(cl, true, before)
+	if got := mgr.GetClientStats(cl).CurrentDifficulty; got != before {
+		t.Errorf("difficulty moved after the fast phase: %f -> %f", before, got)
+	}
+
+	// a silent client drops by at most fastStep per check
+	slow := &mockClient{}
+	mgr.AddClientAt(slow, 64000)
+	mgr.FastRetarget(time.Now())
+	if got := mgr.GetClientStats(slow).CurrentDifficulty; got != 64000 {
+		t.Errorf("retargeted before twice the target time: %f", got)
+	}
+	backdate(slow, 40*time.Second)
+	mgr.FastRetarget(time.Now())
+	if got := mgr.GetClientStats(slow).CurrentDifficulty; got != 16000 {
+		t.Errorf("silent client difficulty = %f, want 16000", got)
+	}
+}
+
+func TestDifficultySteps(t *testing.T) {
+	mgr := NewManager(&Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       1000,
+		MaxDiff:       100000,
+		AdjustEveryMs: 60000,
+		Steps:         StepsPow2,
+	})
+	cl := &mockClient{}
+	mgr.AddClientAt(cl, 2500)
+	if got := mgr.GetClientStats(cl).CurrentDifficulty; got != 2048 {
+		t.Fatalf("start difficulty = %f, want 2048", got)
+	}
+	if got := mgr.nearestStep(600, 1000, 100000); got != 1024 {
+		t.Errorf("step below min_diff = %f, want 1024", got)
+	}
+
+	// retargets accumulate until they pass the midpoint to the next step
+	tests := []struct {
+		raw, want float64
+	}{
+		{2048 * 1.2, 2048},
+		{2048 * 1.5, 2048},
+		{2048 * 1.6, 4096},
+		{2048 * 0.75, 2048},
+		{2048 * 0.6, 1024},
+	}
+	for _, tt := range tests {
+		if got := mgr.settle(2048, tt.raw, 1000, 100000); got != tt.want {
+			t.Errorf("settle(2048, %f) = %f, want %f", tt.raw, got, tt.want)
+		}
+	}
+
+	mgr.cfg.Steps, mgr.cfg.StepList = StepsList, []float64{512, 8192, 1000, 65536}
+	if got := mgr.nearestStep(2500, 1000, 100000); got != 1000 {
+		t.Errorf("nearest listed step = %f, want 1000", got)
+	}
+	if got := mgr.nearestStep(2500, 2000, 100000); got != 8192 {
+		t.Errorf("nearest listed step in bounds = %f, want 8192", got)
+	}
+
+	// without steps only the hysteresis applies
+	mgr.cfg.Steps, mgr.cfg.HysteresisPct = "", 30
+	if got := mgr.settle(1000, 1200, 1000, 100000); got != 1000 {
+		t.Errorf("change inside the hysteresis sent: %f", got)
+	}
+	if got := mgr.settle(1000, 1440, 1000, 100000); got != 1440 {
+		t.Errorf("change past the hysteresis = %f, want 1440", got)
+	}
+
+	if ValidSteps(StepsList, nil) || ValidSteps("fib", nil) || !ValidSteps(StepsPow2, nil) {
+		t.Error("ValidSteps accepted or refused the wrong sets")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	mgr := NewManager(&Config{
+		Enabled:       true,
+		TargetSeconds: 15,
+		MinDiff:       100,
+		MaxDiff:       100000,
+		AdjustEveryMs: 60000,
+		LogDecisions:  true,
+	})
+	cl := &mockClient{}
+	mgr.AddClientAt(cl, 4000)
+	mgr.BindWorker(cl, "rig1")
+	mgr.RecordShare(cl, true, 4000)
+
+	if got := mgr.Explain("rig2"); len(got) != 0 {
+		t.Errorf("explained an unknown worker: %+v", got)
+	}
+
+	// a silent client halves at the next retarget
+	mgr.clients[cl].LastAdjustTime = time.Now().Add(-2 * time.Minute)
+	mgr.clients[cl].SharesPerSecond = 0
+	mgr.AdjustDifficulties()
+
+	got := mgr.Explain("rig1")
+	if len(got) != 1 {
+		t.Fatalf("Explain = %d clients, want 1", len(got))
+	}
+	e := got[0]
+	if e.Difficulty != 2000 || len(e.Window) != 1 || e.TargetSeconds != 15 {
+		t.Errorf("explanation = %+v", e)
+	}
+	if len(e.Decisions) != 1 || e.Decisions[0].Old != 4000 || e.Decisions[0].New != 2000 ||
+		e.Decisions[0].Reason != "retarget" || e.Decisions[0].Window != 1 {
+		t.Errorf("decisions = %+v", e.Decisions)
+	}
+	if e.NextAdjust == nil || e.NextAdjust.Sub(e.LastAdjust) != time.Minute {
+		t.Errorf("next adjust = %v, want a minute after %v", e.NextAdjust, e.LastAdjust)
+	}
+}
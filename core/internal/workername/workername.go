@@ -0,0 +1,138 @@
+// Package workername builds the user name forwarded upstream for a client
+// from the worker name it authorized with
+package workername
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Template placeholders
+const (
+	UpstreamUser = "{upstream_user}" // the configured upstream user
+	ClientWorker = "{client_worker}" // the client's worker name, cleaned
+	ClientRig    = "{client_rig}"    // what follows the first '.' of the worker name, or all of it
+)
+
+// Config holds worker name rewriting. With no template, map or passthrough
+// every client is forwarded as the upstream user.
+type Config struct {
+	// Passthrough forwards the worker name each client authorized with,
+	// cleaned and truncated, so the pool sees its own accounts and rigs;
+	// the map still applies
+	Passthrough bool `json:"passthrough"`
+	// Template of the forwarded name, e.g. "{upstream_user}.{client_rig}"
+	Template string `json:"template"`
+	// Map gives the forwarded name of a worker outright, by exact name;
+	// it takes precedence over the template
+	Map map[string]string `json:"map"`
+	// Replace swaps substrings of the worker name pools disallow before it
+	// is put in the template, e.g. {" ": "_"}
+	Replace map[string]string `json:"replace"`
+	// Strip lists characters removed from the worker name after Replace
+	Strip string `json:"strip"`
+	// MaxLength truncates the forwarded name to this many bytes, at a
+	// character boundary; 0 is no limit
+	MaxLength int `json:"max_length"`
+}
+
+// Validate checks the template placeholders and the length limit
+func (c Config) Validate() error {
+	if c.MaxLength < 0 {
+		return fmt.Errorf("max_length must not be negative")
+	}
+	if c.Passthrough && c.Template != "" {
+		return fmt.Errorf("passthrough and template are mutually exclusive")
+	}
+	rest := strings.NewReplacer(UpstreamUser, "", ClientWorker, "", ClientRig, "").Replace(c.Template)
+	if i := strings.IndexAny(rest, "{}"); i >= 0 {
+		return fmt.Errorf("template %q: unknown placeholder near %q", c.Template, rest[i:])
+	}
+	for k := range c.Replace {
+		if k == "" {
+			return fmt.Errorf("replace: empty key")
+		}
+	}
+	for k, v := range c.Map {
+		if v == "" {
+			return fmt.Errorf("map: empty name for worker %q", k)
+		}
+	}
+	return nil
+}
+
+// Rewriter applies a Config
+type Rewriter struct {
+	cfg   Config
+	clean *strings.Replacer
+}
+
+// New creates a rewriter for cfg, which should have been validated
+func New(cfg Config) *Rewriter {
+	keys := make([]string, 0, len(cfg.Replace))
+	for k := range cfg.Replace {
+		keys = append(keys, k)
+	}
+	// longer keys first so they win over their prefixes, then by name
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k, cfg.Replace[k])
+	}
+	for _, r := range cfg.Strip {
+		pairs = append(pairs, string(r), "")
+	}
+	return &Rewriter{cfg: cfg, clean: strings.NewReplacer(pairs...)}
+}
+
+// Active reports whether clients get names of their own rather than the
+// upstream user
+func (r *Rewriter) Active() bool {
+	return r != nil && (r.cfg.Passthrough || r.cfg.Template != "" || len(r.cfg.Map) > 0)
+}
+
+// Name returns the name to forward for worker. A client that named no
+// worker, or one neither the map nor the template covers, is forwarded as
+// upstreamUser.
+func (r *Rewriter) Name(upstreamUser, worker string) string {
+	if !r.Active() || worker == "" {
+		return upstreamUser
+	}
+	name, ok := r.cfg.Map[worker]
+	if !ok && r.cfg.Passthrough {
+		return truncate(r.clean.Replace(worker), r.cfg.MaxLength)
+	}
+	if !ok {
+		if r.cfg.Template == "" {
+			return upstreamUser
+		}
+		rig := worker
+		if _, after, found := strings.Cut(worker, "."); found && after != "" {
+			rig = after
+		}
+		name = strings.NewReplacer(
+			UpstreamUser, upstreamUser,
+			ClientWorker, r.clean.Replace(worker),
+			ClientRig, r.clean.Replace(rig),
+		).Replace(r.cfg.Template)
+	}
+	return truncate(name, r.cfg.MaxLength)
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,64 @@
+package workername
+
+import "testing"
+
+func TestName(t *testing.T) {
+	r := New(Config{
+		Template:  "{upstream_user}.{client_rig}",
+		Map:       map[string]string{"boss": "acct.special"},
+		Replace:   map[string]string{" ": "_", "  ": "-"},
+		Strip:     "#!",
+		MaxLength: 20,
+	})
+	for _, tc := range []struct{ worker, want string }{
+		{"", "acct"},
+		{"boss", "acct.special"},
+		{"alice.rig1", "acct.rig1"},
+		{"rig#2!", "acct.rig2"},
+		{"my rig  one", "acct.my_rig-one"},
+		{"a.very-long-rig-name-indeed", "acct.very-long-rig-n"},
+		{"a.ééééééééééé", "acct.ééééééé"},
+	} {
+		if got := r.Name("acct", tc.worker); got != tc.want {
+			t.Errorf("Name(%q) = %q, want %q", tc.worker, got, tc.want)
+		}
+	}
+
+	// without a template or map every client is the upstream user
+	if r := New(Config{Strip: "#"}); r.Active() || r.Name("acct", "rig1") != "acct" {
+		t.Error("inactive rewriter renamed a worker")
+	}
+	// passthrough keeps the worker name, unless the map says otherwise
+	r = New(Config{Passthrough: true, Map: map[string]string{"boss": "acct.special"}})
+	if r.Name("acct", "alice.rig 1") != "alice.rig 1" || r.Name("acct", "boss") != "acct.special" {
+		t.Error("passthrough rewriter")
+	}
+	// and still cleans and truncates it
+	r = New(Config{Passthrough: true, Replace: map[string]string{" ": "_"}, Strip: "#", MaxLength: 10})
+	if got := r.Name("acct", "alice.rig #1"); got != "alice.rig_" {
+		t.Errorf("passthrough cleaned to %q", got)
+	}
+	// a map alone renames only its workers
+	r = New(Config{Map: map[string]string{"rig1": "other"}})
+	if r.Name("acct", "rig1") != "other" || r.Name("acct", "rig2") != "acct" {
+		t.Error("map-only rewriter")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Config{Template: "{upstream_user}.{client_worker}"}).Validate(); err != nil {
+		t.Errorf("valid template: %v", err)
+	}
+	for _, cfg := range []Config{
+		{Template: "{upstream_user}.{worker}"},
+		{Template: "{upstream_user"},
+		{MaxLength: -1},
+		{Replace: map[string]string{"": "x"}},
+		{Map: map[string]string{"rig1": ""}},
+		{Passthrough: true, Template: "{client_worker}"},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("%+v validated", cfg)
+		}
+	}
+}
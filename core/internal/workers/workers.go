@@ -0,0 +1,232 @@
+// Package workers keeps the operator-maintained worker registry: groups,
+// notes and static difficulty overrides keyed by worker name
+package workers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Export formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// csvHeader is the column order used for CSV import and export
+var csvHeader = []string{"name", "group", "notes", "difficulty"}
+
+// Config holds worker registry settings
+type Config struct {
+	// File persists the registry as JSON; empty keeps it in memory only
+	File string `json:"file"`
+}
+
+// Worker is a registry entry
+type Worker struct {
+	Name       string  `json:"name"`
+	Group      string  `json:"group,omitempty"`
+	Notes      string  `json:"notes,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"` // static override; 0 leaves vardiff in charge
+}
+
+// Registry is a concurrency-safe set of workers
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	workers map[string]Worker
+}
+
+// NewRegistry creates an empty registry persisted to cfg.File
+func NewRegistry(cfg *Config) *Registry {
+	return &Registry{path: cfg.File, workers: make(map[string]Worker)}
+}
+
+// Get returns the entry for a worker name
+func (r *Registry) Get(name string) (Worker, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	w, ok := r.workers[name]
+	return w, ok
+}
+
+// List returns all entries sorted by name
+func (r *Registry) List() []Worker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Worker, 0, len(r.workers))
+	for _, w := range r.workers {
+		out = append(out, w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Import merges entries into the registry, or replaces it entirely when
+// replace is set, and returns how many entries were added and updated
+func (r *Registry) Import(list []Worker, replace bool) (added, updated int, err error) {
+	for i, w := range list {
+		if err := w.validate(); err != nil {
+			return 0, 0, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if replace {
+		r.workers = make(map[string]Worker, len(list))
+	}
+	for _, w := range list {
+		if _, ok := r.workers[w.Name]; ok {
+			updated++
+		} else {
+			added++
+		}
+		r.workers[w.Name] = w
+	}
+	return added, updated, nil
+}
+
+func (w Worker) validate() error {
+	if strings.TrimSpace(w.Name) == "" {
+		return errors.New("worker name is required")
+	}
+	if w.Difficulty < 0 {
+		return fmt.Errorf("worker %s: negative difficulty", w.Name)
+	}
+	return nil
+}
+
+// Export writes the registry in the given format
+func (r *Registry) Export(w io.Writer, format string) error {
+	list := r.List()
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, wk := range list {
+			diff := ""
+			if wk.Difficulty > 0 {
+				diff = strconv.FormatFloat(wk.Difficulty, 'f', -1, 64)
+			}
+			if err := cw.Write([]string{wk.Name, wk.Group, wk.Notes, diff}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Parse reads entries in the given format. CSV input must start with a
+// header row; columns may appear in any order and unknown ones are ignored.
+func Parse(rd io.Reader, format string) ([]Worker, error) {
+	switch format {
+	case FormatJSON, "":
+		var list []Worker
+		if err := json.NewDecoder(rd).Decode(&list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	case FormatCSV:
+		records, err := csv.NewReader(rd).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		col := make(map[string]int)
+		for i, h := range records[0] {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		if _, ok := col["name"]; !ok {
+			return nil, errors.New("csv header must include a name column")
+		}
+		field := func(rec []string, name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		list := make([]Worker, 0, len(records)-1)
+		for n, rec := range records[1:] {
+			wk := Worker{Name: field(rec, "name"), Group: field(rec, "group"), Notes: field(rec, "notes")}
+			if d := field(rec, "difficulty"); d != "" {
+				if wk.Difficulty, err = strconv.ParseFloat(d, 64); err != nil {
+					return nil, fmt.Errorf("line %d: invalid difficulty %q", n+2, d)
+				}
+			}
+			list = append(list, wk)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// FormatFor guesses the format from a file name
+func FormatFor(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatJSON
+}
+
+// Load reads the registry file. A missing file is not an error.
+func (r *Registry) Load() error {
+	if r.path == "" {
+		return nil
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	list, err := Parse(f, FormatJSON)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", r.path, err)
+	}
+	_, _, err = r.Import(list, true)
+	return err
+}
+
+// Save writes the registry file atomically
+func (r *Registry) Save() error {
+	if r.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".workers-*")
+	if err != nil {
+		return err
+	}
+	if err := r.Export(tmp, FormatJSON); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
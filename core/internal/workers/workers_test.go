@@ -0,0 +1,77 @@
+package workers
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportExportRoundTrip(t *testing.T) {
+	r := NewRegistry(&Config{})
+	added, updated, err := r.Import([]Worker{
+		{Name: "farm.s19-01", Group: "rack-a", Notes: "fan, replaced", Difficulty: 65536},
+		{Name: "farm.usb-01", Group: "usb"},
+	}, false)
+	if err != nil || added != 2 || updated != 0 {
+		t.Fatalf("Import = %d, %d, %v", added, updated, err)
+	}
+
+	for _, format := range []string{FormatJSON, FormatCSV} {
+		var buf bytes.Buffer
+		if err := r.Export(&buf, format); err != nil {
+			t.Fatalf("Export %s: %v", format, err)
+		}
+		list, err := Parse(&buf, format)
+		if err != nil {
+			t.Fatalf("Parse %s: %v", format, err)
+		}
+		if len(list) != 2 || list[0] != r.List()[0] || list[1] != r.List()[1] {
+			t.Errorf("%s round trip mismatch: %+v", format, list)
+		}
+	}
+
+	_, updated, _ = r.Import([]Worker{{Name: "farm.usb-01", Notes: "moved"}}, false)
+	if w, _ := r.Get("farm.usb-01"); updated != 1 || w.Notes != "moved" || len(r.List()) != 2 {
+		t.Errorf("merge import failed: %+v", w)
+	}
+	if _, _, err := r.Import([]Worker{{Name: "only"}}, true); err != nil || len(r.List()) != 1 {
+		t.Errorf("replace import should leave one entry, got %d", len(r.List()))
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	in := "Difficulty,Name,extra\n1024,rig1,x\n,rig2,y\n"
+	list, err := Parse(strings.NewReader(in), FormatCSV)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "rig1" || list[0].Difficulty != 1024 || list[1].Difficulty != 0 {
+		t.Errorf("unexpected parse: %+v", list)
+	}
+	if _, err := Parse(strings.NewReader("group\nx\n"), FormatCSV); err == nil {
+		t.Error("expected error without name column")
+	}
+	if _, _, err := NewRegistry(&Config{}).Import([]Worker{{Name: " "}}, false); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers.json")
+	r := NewRegistry(&Config{File: path})
+	_, _, _ = r.Import([]Worker{{Name: "rig1", Group: "g"}}, false)
+	if err := r.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	r2 := NewRegistry(&Config{File: path})
+	if err := r2.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if w, ok := r2.Get("rig1"); !ok || w.Group != "g" {
+		t.Errorf("loaded entry = %+v, %v", w, ok)
+	}
+	if FormatFor("x.CSV") != FormatCSV || FormatFor("x.json") != FormatJSON {
+		t.Error("FormatFor mismatch")
+	}
+}